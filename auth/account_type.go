@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"strings"
+
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+// Account types, in the AccountTypeAssertion.
+const (
+	AccountTypeUser    = "user"
+	AccountTypeMachine = "machine"
+)
+
+// What LDAPTokenIssuer does with machine accounts, in
+// MachineAccountPolicy.
+const (
+	// MachineAccountsAllow issues tokens to machine accounts like users.
+	MachineAccountsAllow = "allow"
+	// MachineAccountsReject refuses machine accounts a token.
+	MachineAccountsReject = "reject"
+)
+
+// DefaultMachineObjectClasses are the object classes of machine accounts
+// when MachineObjectClasses isn't set: AD's computer accounts.
+var DefaultMachineObjectClasses = []string{"computer"}
+
+// accountType classifies entry as a machine account if it has one of
+// MachineObjectClasses, and as a user otherwise. Object classes are
+// compared without regard to case, as directories do.
+func (lti *LDAPTokenIssuer) accountType(entry *goldap.Entry) string {
+	classes := lti.MachineObjectClasses
+	if len(classes) == 0 {
+		classes = DefaultMachineObjectClasses
+	}
+	for _, objectClass := range entry.GetAttributeValues("objectClass") {
+		for _, class := range classes {
+			if strings.EqualFold(objectClass, class) {
+				return AccountTypeMachine
+			}
+		}
+	}
+	return AccountTypeUser
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestAccountType(t *testing.T) {
+	user := ldap.NewEntry("uid=alice,ou=people,dc=example,dc=com", map[string][]string{
+		"uid":         {"alice"},
+		"objectClass": {"top", "person", "organizationalPerson", "user"},
+	})
+	computer := ldap.NewEntry("cn=build01,ou=computers,dc=example,dc=com", map[string][]string{
+		"uid":         {"build01$"},
+		"objectClass": {"top", "person", "organizationalPerson", "user", "Computer"},
+	})
+	device := ldap.NewEntry("cn=printer,ou=devices,dc=example,dc=com", map[string][]string{
+		"uid":         {"printer"},
+		"objectClass": {"top", "device"},
+	})
+
+	cases := []struct {
+		name          string
+		entry         *ldap.Entry
+		objectClasses []string
+		expected      string
+	}{
+		{name: "user", entry: user, expected: AccountTypeUser},
+		{name: "computer", entry: computer, expected: AccountTypeMachine},
+		{name: "device with the default classes", entry: device, expected: AccountTypeUser},
+		{name: "device", entry: device, objectClasses: []string{"device"}, expected: AccountTypeMachine},
+		{name: "computer with other classes", entry: computer, objectClasses: []string{"device"}, expected: AccountTypeUser},
+	}
+	for _, c := range cases {
+		lti := &LDAPTokenIssuer{
+			TTL:                  time.Hour,
+			UsernameAttribute:    "uid",
+			AccountTypeAssertion: "account_type",
+			MachineObjectClasses: c.objectClasses,
+			MachineExtraGroup:    "machines",
+			ExtraGroups:          []string{"authenticated"},
+		}
+		tok := lti.createToken(c.entry)
+		if tok.Assertions["account_type"] != c.expected {
+			t.Errorf("%s: Expected account type %q, got %q", c.name, c.expected, tok.Assertions["account_type"])
+		}
+		inGroup := false
+		for _, group := range tok.Groups {
+			inGroup = inGroup || group == "machines"
+		}
+		if inGroup != (c.expected == AccountTypeMachine) {
+			t.Errorf("%s: Expected the machines group only for machine accounts, got %v", c.name, tok.Groups)
+		}
+	}
+
+	// Without an assertion configured, tokens don't get one.
+	lti := &LDAPTokenIssuer{TTL: time.Hour, UsernameAttribute: "uid"}
+	if accountType, ok := lti.createToken(computer).Assertions["account_type"]; ok {
+		t.Errorf("Expected no account type assertion, got %q", accountType)
+	}
+}
+
+func TestRejectMachineAccounts(t *testing.T) {
+	for _, c := range []struct {
+		name        string
+		objectClass []string
+		code        int
+	}{
+		{name: "user", objectClass: []string{"top", "person"}, code: http.StatusOK},
+		{name: "computer", objectClass: []string{"top", "computer"}, code: http.StatusForbidden},
+	} {
+		lti := &LDAPTokenIssuer{
+			LDAPAuthenticator: dummyLDAP{entry: ldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{
+				"uid":         {"alice"},
+				"objectClass": c.objectClass,
+			})},
+			TokenSigner:          dummySigner{},
+			TTL:                  time.Hour,
+			UsernameAttribute:    "uid",
+			MachineAccountPolicy: MachineAccountsReject,
+		}
+		req, _ := http.NewRequest("GET", "/ldapAuth", nil)
+		req.SetBasicAuth("alice", "password")
+		rec := httptest.NewRecorder()
+		lti.ServeHTTP(rec, req)
+		if rec.Code != c.code {
+			t.Errorf("%s: Expected %d, got %d: %s", c.name, c.code, rec.Code, rec.Body.String())
+		}
+		if c.code == http.StatusForbidden {
+			assertErrorCode(t, c.name, rec, errCodeMachineAccount)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// RequireBearerToken wraps an admin handler (e.g. /metrics) so that it is
+// only served to requests carrying the given bearer token.
+func RequireBearerToken(bearerToken string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		authorization := req.Header.Get("Authorization")
+		presented := strings.TrimPrefix(authorization, "Bearer ")
+		if presented == authorization || subtle.ConstantTimeCompare([]byte(presented), []byte(bearerToken)) != 1 {
+			resp.Header().Set("WWW-Authenticate", `Bearer realm="kubernetes ldap"`)
+			writeError(resp, http.StatusUnauthorized, errCodeMissingCredentials, "a valid bearer token is required")
+			return
+		}
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// ClientCertTLSConfig returns a TLS config that requires clients to
+// present a certificate signed by one of the CAs in caFile.
+func ClientCertTLSConfig(caFile string) (*tls.Config, error) {
+	caPEM, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading client CA file: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", caFile)
+	}
+
+	return &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+		MinVersion: tls.VersionTLS10,
+	}, nil
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("metrics"))
+})
+
+func TestRequireBearerToken(t *testing.T) {
+	cases := []struct {
+		name          string
+		authorization string
+		expectedCode  int
+	}{
+		{
+			name:         "no token",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:          "wrong token",
+			authorization: "Bearer wrong",
+			expectedCode:  http.StatusUnauthorized,
+		},
+		{
+			name:          "token without bearer scheme",
+			authorization: "Basic s3cr3t",
+			expectedCode:  http.StatusUnauthorized,
+		},
+		{
+			name:          "token without any scheme",
+			authorization: "s3cr3t",
+			expectedCode:  http.StatusUnauthorized,
+		},
+		{
+			name:          "valid token",
+			authorization: "Bearer s3cr3t",
+			expectedCode:  http.StatusOK,
+		},
+	}
+
+	handler := RequireBearerToken("s3cr3t", okHandler)
+
+	for _, c := range cases {
+		req, err := http.NewRequest("GET", "/metrics", nil)
+		if err != nil {
+			t.Fatalf("%s: Failed to create request: %v", c.name, err)
+		}
+		if c.authorization != "" {
+			req.Header.Set("Authorization", c.authorization)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != c.expectedCode {
+			t.Errorf("%s: Expected %d, got %d", c.name, c.expectedCode, rec.Code)
+		}
+		if c.expectedCode == http.StatusOK && rec.Body.String() != "metrics" {
+			t.Errorf("%s: Expected the wrapped handler to be served, got %q", c.name, rec.Body.String())
+		}
+	}
+}
+
+func TestClientCertTLSConfig(t *testing.T) {
+	ca := newTestCA(t, "metrics-ca")
+	otherCA := newTestCA(t, "other-ca")
+
+	tlsConfig, err := ClientCertTLSConfig(writeTestFile(t, "ca.pem", ca.pem))
+	if err != nil {
+		t.Fatalf("creating TLS config: %v", err)
+	}
+	tlsConfig.Certificates = []tls.Certificate{ca.issue(t, "server")}
+
+	srv := httptest.NewUnstartedServer(okHandler)
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	cases := []struct {
+		name        string
+		clientCerts []tls.Certificate
+		expectErr   bool
+	}{
+		{
+			name:      "no client certificate",
+			expectErr: true,
+		},
+		{
+			name:        "client certificate from another CA",
+			clientCerts: []tls.Certificate{otherCA.issue(t, "prometheus")},
+			expectErr:   true,
+		},
+		{
+			name:        "client certificate from the trusted CA",
+			clientCerts: []tls.Certificate{ca.issue(t, "prometheus")},
+		},
+	}
+
+	for _, c := range cases {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: c.clientCerts,
+		}}}
+
+		resp, err := client.Get(srv.URL)
+		if c.expectErr {
+			if err == nil {
+				resp.Body.Close()
+				t.Errorf("%s: Expected the TLS handshake to fail", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", c.name, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: Expected %d, got %d", c.name, http.StatusOK, resp.StatusCode)
+		}
+	}
+
+	if _, err := ClientCertTLSConfig(writeTestFile(t, "empty.pem", []byte("not a cert"))); err == nil {
+		t.Errorf("Expected an error for a CA file without certificates")
+	}
+}
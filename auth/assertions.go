@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+// AssertionMapping copies a directory attribute of the user into a token
+// assertion, after passing it through Transforms in order.
+type AssertionMapping struct {
+	Assertion  string
+	Attribute  string
+	Transforms []Transform
+}
+
+// Transform rewrites an attribute value before it is stamped into a
+// token. An empty result drops the assertion.
+type Transform func(value string) string
+
+// ParseTransform parses a transform spec, one of:
+//
+//	lowercase              lower cases the value
+//	trim                   strips leading and trailing white space
+//	strip-prefix:<prefix>  removes <prefix> if the value starts with it
+//	regex:<expression>     keeps the first capture group of the match,
+//	                       or the whole match without a group; a value
+//	                       that doesn't match is dropped
+func ParseTransform(spec string) (Transform, error) {
+	name, arg := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, arg = spec[:i], spec[i+1:]
+	}
+
+	switch name {
+	case "lowercase":
+		return strings.ToLower, nil
+	case "trim":
+		return strings.TrimSpace, nil
+	case "strip-prefix":
+		if arg == "" {
+			return nil, fmt.Errorf("transform %q: strip-prefix needs a prefix", spec)
+		}
+		return func(value string) string { return strings.TrimPrefix(value, arg) }, nil
+	case "regex":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("transform %q: %v", spec, err)
+		}
+		return func(value string) string {
+			match := re.FindStringSubmatch(value)
+			switch {
+			case match == nil:
+				return ""
+			case len(match) > 1:
+				return match[1]
+			}
+			return match[0]
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown transform %q, expected lowercase, trim, strip-prefix:<prefix> or regex:<expression>", spec)
+}
+
+// mapAssertions adds the user's mapped attributes to assertions.
+// Attributes the user doesn't have, or that transform to an empty
+// value, are left out.
+func mapAssertions(assertions map[string]string, mappings []AssertionMapping, entry *goldap.Entry) {
+	for _, m := range mappings {
+		value := entry.GetAttributeValue(m.Attribute)
+		for _, transform := range m.Transforms {
+			if value == "" {
+				break
+			}
+			value = transform(value)
+		}
+		if value != "" {
+			assertions[m.Assertion] = value
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"testing"
+
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+func TestParseTransform(t *testing.T) {
+	cases := []struct {
+		spec      string
+		value     string
+		expected  string
+		expectErr bool
+	}{
+		{spec: "lowercase", value: "Alice.Smith@CORP.com", expected: "alice.smith@corp.com"},
+		{spec: "trim", value: "  alice \t", expected: "alice"},
+		{spec: "strip-prefix:EMP-", value: "EMP-01234", expected: "01234"},
+		{spec: "strip-prefix:EMP-", value: "01234", expected: "01234"},
+		{spec: "regex:emp=(\\d+)", value: "dept=42;emp=01234;site=nyc", expected: "01234"},
+		{spec: "regex:\\d+", value: "emp 01234", expected: "01234"},
+		{spec: "regex:emp=(\\d+)", value: "dept=42", expected: ""},
+		{spec: "regex:(", expectErr: true},
+		{spec: "strip-prefix:", expectErr: true},
+		{spec: "uppercase", expectErr: true},
+	}
+
+	for _, c := range cases {
+		transform, err := ParseTransform(c.spec)
+		if c.expectErr {
+			if err == nil {
+				t.Errorf("%s: Expected an error", c.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", c.spec, err)
+			continue
+		}
+		if got := transform(c.value); got != c.expected {
+			t.Errorf("%s: Expected %q for %q, got %q", c.spec, c.expected, c.value, got)
+		}
+	}
+}
+
+func TestAssertionMappings(t *testing.T) {
+	transforms := func(specs ...string) []Transform {
+		var result []Transform
+		for _, spec := range specs {
+			transform, err := ParseTransform(spec)
+			if err != nil {
+				t.Fatalf("Parsing transform %q: %v", spec, err)
+			}
+			result = append(result, transform)
+		}
+		return result
+	}
+
+	lti := LDAPTokenIssuer{
+		AssertionMappings: []AssertionMapping{
+			{Assertion: "email", Attribute: "mail", Transforms: transforms("trim", "lowercase")},
+			{Assertion: "employeeNumber", Attribute: "description", Transforms: transforms("regex:emp=(\\w+)", "strip-prefix:E")},
+			{Assertion: "site", Attribute: "l"},
+			{Assertion: "costCenter", Attribute: "departmentNumber", Transforms: transforms("regex:cc=(\\d+)")},
+			{Assertion: "phone", Attribute: "telephoneNumber"},
+		},
+	}
+	e := goldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{
+		"uid":              {"alice"},
+		"mail":             {" Alice.Smith@CORP.com "},
+		"description":      {"dept=42;emp=E01234"},
+		"l":                {"NYC"},
+		"departmentNumber": {"none"},
+	})
+
+	tok := lti.createToken(e)
+	expected := map[string]string{
+		"email":          "alice.smith@corp.com",
+		"employeeNumber": "01234",
+		"site":           "NYC",
+	}
+	for name, value := range expected {
+		if tok.Assertions[name] != value {
+			t.Errorf("Expected assertion %s=%q, got %q", name, value, tok.Assertions[name])
+		}
+	}
+	// Missing attributes and values that don't match are left out.
+	for _, name := range []string{"costCenter", "phone"} {
+		if value, ok := tok.Assertions[name]; ok {
+			t.Errorf("Expected no %s assertion, got %q", name, value)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Sources of the audience of issued tokens.
+const (
+	// AudienceFromHost takes the first label of the request's Host, so a
+	// token issued via cluster-a.example.com is for cluster-a.
+	AudienceFromHost = "host"
+	// AudienceFromPath takes the tenant of a /tenants/<name>/ path
+	// prefix, so a token issued via /tenants/cluster-a/ldapAuth is for
+	// cluster-a.
+	AudienceFromPath = "path"
+)
+
+// tenantPathKey is the context key of the tenant picked by a TenantRouter
+// from the path prefix, which is stripped before the tenant's handlers
+// see the request.
+type tenantPathKey struct{}
+
+func withPathTenant(req *http.Request, name string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), tenantPathKey{}, name))
+}
+
+// requestAudience returns the audience of a token issued for req, or
+// empty if source is empty or req has none.
+func requestAudience(req *http.Request, source string) string {
+	switch source {
+	case AudienceFromHost:
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		// An IP address has no label to speak of.
+		if host == "" || net.ParseIP(host) != nil {
+			return ""
+		}
+		if i := strings.Index(host, "."); i >= 0 {
+			host = host[:i]
+		}
+		return strings.ToLower(host)
+	case AudienceFromPath:
+		name, _ := req.Context().Value(tenantPathKey{}).(string)
+		return name
+	}
+	return ""
+}
+
+// matchAudiences returns the audiences of a token that are among
+// accepted. Tokens without audiences predate them, or were issued
+// without an audience source, and are good for any audience.
+func matchAudiences(tokenAudiences, accepted []string) ([]string, bool) {
+	if len(tokenAudiences) == 0 || len(accepted) == 0 {
+		return accepted, true
+	}
+	var matched []string
+	for _, a := range tokenAudiences {
+		for _, b := range accepted {
+			if strings.EqualFold(a, b) {
+				matched = append(matched, b)
+				break
+			}
+		}
+	}
+	return matched, len(matched) > 0
+}
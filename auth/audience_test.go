@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+// issueVia returns the token issued by handler for a login to url.
+func issueVia(t *testing.T, handler http.Handler, url string) string {
+	req, _ := http.NewRequest("GET", url, nil)
+	req.SetBasicAuth("alice", "password")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s: Expected %d from the issuer, got %d: %s", url, http.StatusOK, rec.Code, rec.Body.String())
+	}
+	return rec.Body.String()
+}
+
+func TestAudienceFromRequest(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	newIssuer := func(source string) *LDAPTokenIssuer {
+		return &LDAPTokenIssuer{
+			LDAPAuthenticator: dummyLDAP{entry: ldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{"uid": {"alice"}})},
+			TokenSigner:       signer,
+			UsernameAttribute: "uid",
+			TTL:               time.Hour,
+			AudienceSource:    source,
+		}
+	}
+	byHost := newIssuer(AudienceFromHost)
+	router := NewTenantRouter(http.NotFoundHandler())
+	for _, name := range []string{"cluster-a", "cluster-b"} {
+		mux := http.NewServeMux()
+		mux.Handle("/ldapAuth", newIssuer(AudienceFromPath))
+		router.AddTenant(name, "", mux)
+	}
+
+	cases := []struct {
+		name     string
+		handler  http.Handler
+		url      string
+		expected []string
+	}{
+		{name: "host", handler: byHost, url: "https://cluster-a.example.com/ldapAuth", expected: []string{"cluster-a"}},
+		{name: "other host", handler: byHost, url: "https://Cluster-B.example.com:8443/ldapAuth", expected: []string{"cluster-b"}},
+		{name: "IP address", handler: byHost, url: "https://10.0.0.1:8443/ldapAuth"},
+		{name: "path", handler: router, url: "https://auth.example.com/tenants/cluster-a/ldapAuth", expected: []string{"cluster-a"}},
+		{name: "other path", handler: router, url: "https://auth.example.com/tenants/cluster-b/ldapAuth", expected: []string{"cluster-b"}},
+		{name: "no source", handler: newIssuer(""), url: "https://cluster-a.example.com/ldapAuth"},
+	}
+	for _, c := range cases {
+		tok, err := verifier.Verify(issueVia(t, c.handler, c.url))
+		if err != nil {
+			t.Fatalf("%s: Failed to verify token: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(tok.Audiences, c.expected) {
+			t.Errorf("%s: Expected audiences %v, got %v", c.name, c.expected, tok.Audiences)
+		}
+	}
+}
+
+func TestWebhookAudiences(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	sign := func(audiences ...string) string {
+		signed, err := signer.Sign(&token.AuthToken{Username: "alice", Expiration: expirationAfter(time.Hour), Audiences: audiences})
+		if err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+		return signed
+	}
+	forA := sign("cluster-a")
+
+	cases := []struct {
+		name              string
+		tok               string
+		reviewAudiences   []string
+		webhookAudiences  []string
+		expectedCode      int
+		expectedAudiences []string
+	}{
+		{name: "matching review", tok: forA, reviewAudiences: []string{"cluster-a", "https://kubernetes.default.svc"}, expectedCode: http.StatusOK, expectedAudiences: []string{"cluster-a"}},
+		{name: "other cluster", tok: forA, reviewAudiences: []string{"cluster-b"}, expectedCode: http.StatusUnauthorized},
+		{name: "matching webhook audiences", tok: forA, webhookAudiences: []string{"cluster-a"}, expectedCode: http.StatusOK},
+		{name: "other webhook audiences", tok: forA, webhookAudiences: []string{"cluster-b"}, expectedCode: http.StatusUnauthorized},
+		// The review's audiences take precedence over the webhook's.
+		{name: "review over webhook audiences", tok: forA, reviewAudiences: []string{"cluster-a"}, webhookAudiences: []string{"cluster-b"}, expectedCode: http.StatusOK, expectedAudiences: []string{"cluster-a"}},
+		{name: "no audiences to check", tok: forA, expectedCode: http.StatusOK},
+		{name: "token without audience", tok: sign(), reviewAudiences: []string{"cluster-b"}, expectedCode: http.StatusOK, expectedAudiences: []string{"cluster-b"}},
+	}
+	for _, c := range cases {
+		tw := NewTokenWebhook(verifier)
+		tw.Audiences = c.webhookAudiences
+		trrJSON, _ := json.Marshal(&TokenReviewRequest{Spec: TokenReviewSpec{Token: c.tok, Audiences: c.reviewAudiences}})
+		req, _ := http.NewRequest("POST", "/authenticate", bytes.NewReader(trrJSON))
+		rec := httptest.NewRecorder()
+		tw.ServeHTTP(rec, req)
+
+		if rec.Code != c.expectedCode {
+			t.Errorf("%s: Expected %d, got %d: %s", c.name, c.expectedCode, rec.Code, rec.Body.String())
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			continue
+		}
+		trr := &TokenReviewRequest{}
+		if err := json.Unmarshal(rec.Body.Bytes(), trr); err != nil {
+			t.Fatalf("%s: Failed to decode review: %v", c.name, err)
+		}
+		if !trr.Status.Authenticated || !reflect.DeepEqual(trr.Status.Audiences, c.expectedAudiences) {
+			t.Errorf("%s: Expected to be authenticated for %v, got %+v", c.name, c.expectedAudiences, trr.Status)
+		}
+	}
+}
+
+func TestWebhookAudienceMismatchUnauthenticated(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	signed, err := signer.Sign(&token.AuthToken{Username: "alice", Expiration: expirationAfter(time.Hour), Audiences: []string{"cluster-a"}})
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	for _, unauthenticated := range []bool{false, true} {
+		tw := NewTokenWebhook(verifier)
+		tw.UnauthenticatedOnAudienceMismatch = unauthenticated
+		trrJSON, _ := json.Marshal(&TokenReviewRequest{Spec: TokenReviewSpec{Token: signed, Audiences: []string{"cluster-b"}}})
+		req, _ := http.NewRequest("POST", "/authenticate", bytes.NewReader(trrJSON))
+		rec := httptest.NewRecorder()
+		tw.ServeHTTP(rec, req)
+
+		if !unauthenticated {
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("Expected %d by default, got %d: %s", http.StatusUnauthorized, rec.Code, rec.Body.String())
+			}
+			assertErrorCode(t, "default", rec, errCodeWrongAudience)
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+		trr := &TokenReviewRequest{}
+		if err := json.Unmarshal(rec.Body.Bytes(), trr); err != nil {
+			t.Fatalf("Failed to decode review: %v", err)
+		}
+		if trr.Status.Authenticated || trr.Status.Error != "" || len(trr.Status.Audiences) != 0 || trr.Status.User.Username != "" {
+			t.Errorf("Expected a clean unauthenticated review, got %+v", trr.Status)
+		}
+		if !reflect.DeepEqual(trr.Spec.Audiences, []string{"cluster-b"}) {
+			t.Errorf("Expected the review's audiences back, got %v", trr.Spec.Audiences)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/proofpoint/kubernetes-ldap/ldap"
+	"github.com/proofpoint/kubernetes-ldap/token"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBreakGlassLogin(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("glass-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	staticUsers, err := ldap.ParseStaticUsers([]byte("users:\n  breakglass:\n    password-hash: " + string(hash) + "\n    groups: [system:masters]\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse static users: %v", err)
+	}
+	staticUsers.UsernameAttribute = "uid"
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	// The directory is down.
+	directory := &dummyLDAP{err: &ldap.UnavailableError{Err: errors.New("connection refused")}}
+	lti := &LDAPTokenIssuer{
+		LDAPAuthenticator: ldap.Fallback{Primary: directory, Secondary: staticUsers},
+		TokenSigner:       signer,
+		UsernameAttribute: "uid",
+		TTL:               time.Hour,
+	}
+
+	login := func(password string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/ldapAuth", nil)
+		req.SetBasicAuth("breakglass", password)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		lti.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := login("glass-password")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected %d for the break-glass password, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode issuer response: %v", err)
+	}
+	tok, err := verifier.Verify(body.Token)
+	if err != nil {
+		t.Fatalf("Failed to verify the break-glass token: %v", err)
+	}
+	if tok.Username != "breakglass" || !reflect.DeepEqual(tok.Groups, []string{"system:masters"}) {
+		t.Errorf("Expected a token for breakglass in system:masters, got %s in %v", tok.Username, tok.Groups)
+	}
+
+	if rec := login("wrong-password"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected %d for a wrong break-glass password, got %d: %s", http.StatusServiceUnavailable, rec.Code, rec.Body.String())
+	}
+
+	// Once the directory is back, it alone decides.
+	directory.err = goldap.NewError(goldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	if rec := login("glass-password"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected %d for breakglass while the directory is up, got %d: %s", http.StatusUnauthorized, rec.Code, rec.Body.String())
+	}
+}
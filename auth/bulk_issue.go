@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+var (
+	bulkIssuedTokens = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_bulk_issued_tokens",
+			Help: "Total number of tokens issued by bulk issuance.",
+		},
+	)
+	bulkRejectedRecords = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_bulk_rejected_records",
+			Help: "Total number of bulk issuance records that no token was issued for.",
+		},
+	)
+)
+
+// RegisterBulkIssueMetrics registers the metrics for bulk issuance.
+func RegisterBulkIssueMetrics() {
+	prometheus.MustRegister(bulkIssuedTokens)
+	prometheus.MustRegister(bulkRejectedRecords)
+}
+
+// maxBulkRecordSize bounds the length of a line of a bulk request.
+const maxBulkRecordSize = 64 * 1024
+
+// BulkTokenIssuer issues tokens for a batch of records, e.g. service
+// accounts being onboarded, without authenticating them against LDAP.
+// The request body has a BulkRecord per line, and the response streams
+// back a BulkResult per line as tokens are signed, in no particular
+// order. A bad record gets an error result and doesn't stop the batch.
+// It doesn't authenticate the caller, so it must be wrapped, e.g. with
+// RequireBearerToken.
+type BulkTokenIssuer struct {
+	TokenSigner token.Signer
+	// TTL is the lifetime of tokens of records without a ttl.
+	TTL time.Duration
+	// MaxTTL, if set, rejects records asking for a longer ttl.
+	MaxTTL time.Duration
+	// TokenPrefix is prepended to every issued token, as for
+	// LDAPTokenIssuer.
+	TokenPrefix string
+	// Concurrency bounds how many tokens are signed at once. Defaults to
+	// GOMAXPROCS.
+	Concurrency int
+	// IssuedTokens, if set, records the tokens issued, for debugging.
+	IssuedTokens *IssuedTokenLog
+	// The groups of the records go through GroupMapper, MaxGroups (with
+	// GroupLimitPolicy and PriorityGroups) and GroupPrefix, as the
+	// groups from the directory do for LDAPTokenIssuer.
+	GroupMapper      GroupMapper
+	MaxGroups        int
+	GroupLimitPolicy string
+	PriorityGroups   []string
+	GroupPrefix      string
+}
+
+// BulkRecord is a line of a bulk issuance request. TTL is a duration
+// such as "720h".
+type BulkRecord struct {
+	Username string   `json:"username"`
+	Groups   []string `json:"groups,omitempty"`
+	TTL      string   `json:"ttl,omitempty"`
+}
+
+// BulkResult is a line of a bulk issuance response. Index is the line
+// of the record in the request, from 0, or -1 for an error reading the
+// request. Either Token or Error is set.
+type BulkResult struct {
+	Index               int    `json:"index"`
+	Username            string `json:"username,omitempty"`
+	Token               string `json:"token,omitempty"`
+	ExpirationTimestamp int64  `json:"expirationTimestamp,omitempty"`
+	Error               string `json:"error,omitempty"`
+}
+
+type bulkJob struct {
+	reqID string
+	index int
+	line  []byte
+}
+
+func (bi *BulkTokenIssuer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	reqID := requestID(resp, req)
+	if req.Method != http.MethodPost {
+		writeError(resp, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "bulk issuance requests must be POSTed")
+		return
+	}
+
+	concurrency := bi.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	jobs := make(chan bulkJob)
+	results := make(chan BulkResult)
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				results <- bi.issue(job)
+			}
+		}()
+	}
+
+	// Records are read as they arrive, so that tokens stream back while
+	// a large batch is still being sent.
+	var readErr error
+	go func() {
+		defer func() {
+			close(jobs)
+			wg.Wait()
+			close(results)
+		}()
+		scanner := bufio.NewScanner(req.Body)
+		scanner.Buffer(make([]byte, 0, 4096), maxBulkRecordSize)
+		for index := 0; scanner.Scan(); index++ {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			line := append([]byte(nil), scanner.Bytes()...)
+			jobs <- bulkJob{reqID: reqID, index: index, line: line}
+		}
+		readErr = scanner.Err()
+	}()
+
+	resp.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := resp.(http.Flusher)
+	encoder := json.NewEncoder(resp)
+	issued, rejected := 0, 0
+	for result := range results {
+		if result.Error != "" {
+			rejected++
+			bulkRejectedRecords.Inc()
+		} else {
+			issued++
+			bulkIssuedTokens.Inc()
+		}
+		if err := encoder.Encode(result); err != nil {
+			glog.Errorf("[%s] Error writing bulk issuance result: %v", reqID, err)
+			continue
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	// The records read before a bad line have their results, so the
+	// error ends the stream rather than replacing it.
+	if readErr != nil {
+		glog.Errorf("[%s] Error reading bulk issuance request: %v", reqID, readErr)
+		encoder.Encode(BulkResult{Index: -1, Error: fmt.Sprintf("error reading request: %v", readErr)})
+	}
+	glog.Infof("[%s] Bulk issuance: %d tokens issued, %d records rejected", reqID, issued, rejected)
+}
+
+// issue validates a record and signs its token.
+func (bi *BulkTokenIssuer) issue(job bulkJob) BulkResult {
+	result := BulkResult{Index: job.index}
+	var record BulkRecord
+	if err := json.Unmarshal(job.line, &record); err != nil {
+		result.Error = fmt.Sprintf("invalid record: %v", err)
+		return result
+	}
+	result.Username = record.Username
+
+	tok, err := bi.newToken(record)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if bi.GroupMapper != nil {
+		tok.Groups = bi.GroupMapper.Map(tok.Groups)
+	}
+	if err := (groupLimit{max: bi.MaxGroups, policy: bi.GroupLimitPolicy, priority: bi.PriorityGroups}).apply(job.reqID, tok); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	tok.Groups = prefixGroups(tok.Groups, bi.GroupPrefix)
+	signed, err := bi.TokenSigner.Sign(tok)
+	if err != nil {
+		errorSigningToken.Inc()
+		result.Error = "error signing token"
+		return result
+	}
+	glog.Infof("[%s] Bulk issued a token for user %q with groups %v, expiring at %s", job.reqID, tok.Username, tok.Groups, millisToTime(tok.Expiration).Format(time.RFC3339))
+	bi.IssuedTokens.record(IssuedByBulk, tok)
+	result.Token = bi.TokenPrefix + signed
+	result.ExpirationTimestamp = tok.Expiration
+	return result
+}
+
+func (bi *BulkTokenIssuer) newToken(record BulkRecord) (*token.AuthToken, error) {
+	if strings.TrimSpace(record.Username) == "" {
+		return nil, errors.New("username is required")
+	}
+	ttl := bi.TTL
+	if record.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(record.TTL); err != nil {
+			return nil, fmt.Errorf("invalid ttl: %v", err)
+		}
+	}
+	if ttl <= 0 {
+		return nil, errors.New("ttl must be positive")
+	}
+	if bi.MaxTTL > 0 && ttl > bi.MaxTTL {
+		return nil, fmt.Errorf("ttl %v is longer than the maximum of %v", ttl, bi.MaxTTL)
+	}
+
+	issuedAt := nowMillis()
+	return &token.AuthToken{
+		Username:   record.Username,
+		Groups:     record.Groups,
+		Assertions: map[string]string{token.AuthMethodAssertion: token.AuthMethodBulk},
+		IssuedAt:   issuedAt,
+		Expiration: issuedAt + ttl.Milliseconds(),
+		ID:         newTokenID(),
+		Type:       token.TypeAccess,
+	}, nil
+}
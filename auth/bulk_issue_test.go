@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+func bulkIssue(bi *BulkTokenIssuer, body string) (*httptest.ResponseRecorder, []BulkResult) {
+	req, _ := http.NewRequest("POST", "/bulkIssue", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	bi.ServeHTTP(rec, req)
+
+	var results []BulkResult
+	scanner := bufio.NewScanner(strings.NewReader(rec.Body.String()))
+	for scanner.Scan() {
+		var result BulkResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err == nil {
+			results = append(results, result)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Index < results[j].Index })
+	return rec, results
+}
+
+func TestBulkIssue(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	bi := &BulkTokenIssuer{
+		TokenSigner: signer,
+		TTL:         time.Hour,
+		MaxTTL:      30 * 24 * time.Hour,
+		TokenPrefix: "ldap:",
+		Concurrency: 2,
+	}
+
+	body := strings.Join([]string{
+		`{"username": "svc-deploy", "groups": ["deployers"], "ttl": "720h"}`,
+		`{"groups": ["nobody"]}`,
+		`{"username": "svc-backup"}`,
+		``,
+		`{"username": "svc-forever", "ttl": "8760h"}`,
+		`not json`,
+		`{"username": "svc-ci", "groups": ["ci-bots", "viewers"], "ttl": "24h"}`,
+	}, "\n")
+	rec, results := bulkIssue(bi, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	expected := []struct {
+		index    int
+		username string
+		groups   []string
+		ttl      time.Duration
+		errorMsg string
+	}{
+		{index: 0, username: "svc-deploy", groups: []string{"deployers"}, ttl: 720 * time.Hour},
+		{index: 1, errorMsg: "username is required"},
+		{index: 2, username: "svc-backup", ttl: time.Hour},
+		{index: 4, username: "svc-forever", errorMsg: "longer than the maximum"},
+		{index: 5, errorMsg: "invalid record"},
+		{index: 6, username: "svc-ci", groups: []string{"ci-bots", "viewers"}, ttl: 24 * time.Hour},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, got %d: %s", len(expected), len(results), rec.Body.String())
+	}
+	for i, e := range expected {
+		result := results[i]
+		if result.Index != e.index || result.Username != e.username {
+			t.Errorf("result %d: Expected record %d of %q, got %+v", i, e.index, e.username, result)
+			continue
+		}
+		if e.errorMsg != "" {
+			if result.Token != "" || !strings.Contains(result.Error, e.errorMsg) {
+				t.Errorf("record %d: Expected an error containing %q and no token, got %+v", e.index, e.errorMsg, result)
+			}
+			continue
+		}
+		if result.Error != "" || !strings.HasPrefix(result.Token, "ldap:") {
+			t.Errorf("record %d: Expected a prefixed token, got %+v", e.index, result)
+			continue
+		}
+
+		tok, err := verifier.Verify(strings.TrimPrefix(result.Token, "ldap:"))
+		if err != nil {
+			t.Errorf("record %d: Failed to verify token: %v", e.index, err)
+			continue
+		}
+		if tok.Username != e.username || !reflect.DeepEqual(tok.Groups, e.groups) {
+			t.Errorf("record %d: Expected %q in %v, got %q in %v", e.index, e.username, e.groups, tok.Username, tok.Groups)
+		}
+		if ttl := time.Duration(tok.Expiration-tok.IssuedAt) * time.Millisecond; ttl != e.ttl {
+			t.Errorf("record %d: Expected a ttl of %v, got %v", e.index, e.ttl, ttl)
+		}
+		if tok.Expiration != result.ExpirationTimestamp {
+			t.Errorf("record %d: Expected expirationTimestamp %d, got %d", e.index, tok.Expiration, result.ExpirationTimestamp)
+		}
+		if tok.Assertions[token.AuthMethodAssertion] != token.AuthMethodBulk {
+			t.Errorf("record %d: Expected the bulk auth method, got %v", e.index, tok.Assertions)
+		}
+	}
+}
+
+func TestBulkIssueSignerError(t *testing.T) {
+	bi := &BulkTokenIssuer{TokenSigner: dummySigner{err: errors.New("signing failed")}, TTL: time.Hour}
+	_, results := bulkIssue(bi, `{"username": "svc-a"}`+"\n"+`{"username": "svc-b"}`)
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %v", results)
+	}
+	for _, result := range results {
+		if result.Error != "error signing token" || result.Token != "" {
+			t.Errorf("Expected a signing error for every record, got %+v", result)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/bulkIssue", nil)
+	rec := httptest.NewRecorder()
+	bi.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected %d for a GET, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+func TestBulkIssueGroupPolicy(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	mapping, err := ParseGroupMapping([]byte(`
+unmapped: drop
+groups:
+  sg-deployers: [deployers]
+  sg-viewers: [viewers]
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse group mapping: %v", err)
+	}
+	bi := &BulkTokenIssuer{
+		TokenSigner: signer,
+		TTL:         time.Hour,
+		GroupMapper: mapping,
+		GroupPrefix: "ldap:",
+	}
+
+	_, results := bulkIssue(bi, `{"username": "svc-deploy", "groups": ["sg-deployers", "sg-viewers", "system:masters"]}`)
+	if len(results) != 1 || results[0].Token == "" {
+		t.Fatalf("Expected a token, got %+v", results)
+	}
+	tok, err := verifier.Verify(results[0].Token)
+	if err != nil {
+		t.Fatalf("Expected a valid token: %v", err)
+	}
+	// Groups outside the mapping are dropped, and the rest prefixed.
+	sort.Strings(tok.Groups)
+	if expected := []string{"ldap:deployers", "ldap:viewers"}; !reflect.DeepEqual(tok.Groups, expected) {
+		t.Errorf("Expected groups %v, got %v", expected, tok.Groups)
+	}
+}
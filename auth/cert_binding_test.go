@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+func TestCertBoundTokens(t *testing.T) {
+	ca := newTestCA(t, "clients")
+	alice := ca.issue(t, "alice").Leaf
+	mallory := ca.issue(t, "mallory").Leaf
+	overTLS := func(req *http.Request, cert *x509.Certificate) *http.Request {
+		req.TLS = &tls.ConnectionState{}
+		if cert != nil {
+			req.TLS.PeerCertificates = []*x509.Certificate{cert}
+		}
+		return req
+	}
+
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	lti := &LDAPTokenIssuer{
+		LDAPAuthenticator: dummyLDAP{entry: ldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{"uid": {"alice"}})},
+		TokenSigner:       signer,
+		UsernameAttribute: "uid",
+		TTL:               24 * time.Hour,
+		CertBound:         true,
+		CertBoundTTL:      10 * time.Minute,
+		RefreshTTL:        24 * time.Hour,
+	}
+	issue := func(cert *x509.Certificate) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/ldapAuth", nil)
+		req.SetBasicAuth("alice", "password")
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		lti.ServeHTTP(rec, overTLS(req, cert))
+		return rec
+	}
+
+	if rec := issue(nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected %d without a client certificate, got %d", http.StatusUnauthorized, rec.Code)
+	}
+	rec := issue(alice)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode issuer response: %v", err)
+	}
+	if _, ok := body["refreshToken"]; ok {
+		t.Errorf("Expected no refresh token for a bound token, got %s", rec.Body.String())
+	}
+	signed := body["token"].(string)
+	tok, err := verifier.Verify(signed)
+	if err != nil {
+		t.Fatalf("Failed to verify token: %v", err)
+	}
+	if tok.Confirmation == nil || tok.Confirmation.X5tS256 != token.CertThumbprint(alice) {
+		t.Errorf("Expected the token to be bound to alice's certificate, got %+v", tok.Confirmation)
+	}
+	if tok.Expiration > expirationAfter(10*time.Minute) {
+		t.Errorf("Expected the bound token to expire within 10 minutes")
+	}
+
+	handler := RequireAccessToken(verifier, "", http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {}))
+	cases := []struct {
+		name         string
+		cert         *x509.Certificate
+		expectedCode int
+	}{
+		{name: "matching certificate", cert: alice, expectedCode: http.StatusOK},
+		{name: "other certificate", cert: mallory, expectedCode: http.StatusUnauthorized},
+		{name: "no certificate", expectedCode: http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest("GET", "/resource", nil)
+		req.Header.Set("Authorization", "Bearer "+signed)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, overTLS(req, c.cert))
+		if rec.Code != c.expectedCode {
+			t.Errorf("%s: Expected %d, got %d: %s", c.name, c.expectedCode, rec.Code, rec.Body.String())
+		}
+	}
+	req, _ := http.NewRequest("GET", "/resource", nil)
+	req.Header.Set("Authorization", "Bearer "+signed)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected %d over plain HTTP, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	// The webhook never sees the user's connection, so it can't confirm
+	// the binding.
+	trrJSON, _ := json.Marshal(&TokenReviewRequest{Spec: TokenReviewSpec{Token: signed}})
+	req, _ = http.NewRequest("POST", "/authenticate", bytes.NewReader(trrJSON))
+	rec = httptest.NewRecorder()
+	NewTokenWebhook(verifier).ServeHTTP(rec, overTLS(req, alice))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the webhook to refuse a bound token with %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
@@ -0,0 +1,18 @@
+package auth
+
+import (
+	"strings"
+
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+// displayName returns the first of attributes the entry has a non-blank
+// value of, e.g. displayName then cn, or "" if it has none.
+func displayName(entry *goldap.Entry, attributes []string) string {
+	for _, attribute := range attributes {
+		if name := strings.TrimSpace(entry.GetAttributeValue(attribute)); name != "" {
+			return name
+		}
+	}
+	return ""
+}
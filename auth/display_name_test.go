@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+func TestDisplayNameAssertion(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	tw := NewTokenWebhook(verifier)
+	tw.DisplayNameAssertion = "displayName"
+	tw.DisplayNameExtraKey = "kubernetes-ldap/display-name"
+
+	cases := []struct {
+		name     string
+		attrs    map[string][]string
+		expected string
+	}{
+		{name: "display name", attrs: map[string][]string{"displayName": {"Alice Smith"}, "cn": {"alice"}}, expected: "Alice Smith"},
+		{name: "common name", attrs: map[string][]string{"cn": {"Alice Smith"}}, expected: "Alice Smith"},
+		{name: "blank display name", attrs: map[string][]string{"displayName": {"  "}, "cn": {" Alice Smith "}}, expected: "Alice Smith"},
+		{name: "no name", attrs: map[string][]string{}},
+	}
+	for _, c := range cases {
+		c.attrs["uid"] = []string{"alice"}
+		lti := &LDAPTokenIssuer{
+			LDAPAuthenticator:     dummyLDAP{entry: ldap.NewEntry("uid=alice,dc=example,dc=com", c.attrs)},
+			TokenSigner:           signer,
+			TTL:                   time.Hour,
+			UsernameAttribute:     "uid",
+			DisplayNameAssertion:  "displayName",
+			DisplayNameAttributes: []string{"displayName", "cn"},
+		}
+		signed := issueVia(t, lti, "/ldapAuth")
+		tok, err := verifier.Verify(signed)
+		if err != nil {
+			t.Fatalf("%s: Failed to verify token: %v", c.name, err)
+		}
+		name, ok := tok.Assertions["displayName"]
+		if name != c.expected || ok != (c.expected != "") {
+			t.Errorf("%s: Expected display name %q, got %q (set: %v)", c.name, c.expected, name, ok)
+		}
+		if tok.Username != "alice" {
+			t.Errorf("%s: Expected username alice, got %q", c.name, tok.Username)
+		}
+
+		rec := reviewToken(tw, signed)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: Expected %d from the webhook, got %d: %s", c.name, http.StatusOK, rec.Code, rec.Body.String())
+		}
+		var review TokenReviewRequest
+		if err := json.Unmarshal(rec.Body.Bytes(), &review); err != nil {
+			t.Fatalf("%s: Failed to decode review: %v", c.name, err)
+		}
+		extra, ok := review.Status.User.Extra["kubernetes-ldap/display-name"]
+		if c.expected == "" {
+			if ok {
+				t.Errorf("%s: Expected no display name extra, got %v", c.name, extra)
+			}
+			continue
+		}
+		if len(extra) != 1 || extra[0] != c.expected {
+			t.Errorf("%s: Expected the display name extra to be %q, got %v", c.name, c.expected, extra)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Machine-readable error codes returned in error responses.
+const (
+	errCodeInvalidCredentials = "invalid_credentials"
+	errCodeMissingCredentials = "missing_credentials"
+	errCodeInvalidToken       = "invalid_token"
+	errCodeTokenExpired       = "token_expired"
+	errCodeWrongTokenType     = "wrong_token_type"
+	errCodeWrongAudience      = "wrong_audience"
+	errCodeCertMismatch       = "cert_mismatch"
+	errCodeInvalidRequest     = "invalid_request"
+	errCodeMethodNotAllowed   = "method_not_allowed"
+	errCodeUnsupportedClient  = "unsupported_client"
+	errCodeBackendUnavailable = "backend_unavailable"
+	errCodeBackendError       = "backend_error"
+	errCodeTooManyGroups      = "too_many_groups"
+	errCodePasswordRejected   = "password_rejected"
+	errCodeUnknownTenant      = "unknown_tenant"
+	errCodePasswordReset      = "password_reset_required"
+	errCodeInvalidScope       = "invalid_scope"
+	errCodeRateLimited        = "rate_limited"
+	errCodeMachineAccount     = "machine_account"
+	errCodeInternal           = "internal_error"
+)
+
+// errorResponse is the body of every error returned by our handlers.
+type errorResponse struct {
+	Error errorDetail `json:"error"`
+}
+
+// errorDetail pairs a stable code clients can switch on with a human
+// readable message.
+type errorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError writes a JSON error response with the given status.
+func writeError(resp http.ResponseWriter, status int, code, message string) {
+	body, _ := json.Marshal(errorResponse{
+		Error: errorDetail{Code: code, Message: message},
+	})
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	resp.Write(body)
+}
+
+// setRetryAfter tells the client to wait before retrying, in whole
+// seconds rounded up, so a wait under a second isn't sent as 0. A wait
+// of zero or less sets no header.
+func setRetryAfter(resp http.ResponseWriter, wait time.Duration) {
+	if wait <= 0 {
+		return
+	}
+	resp.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
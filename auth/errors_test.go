@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/proofpoint/kubernetes-ldap/ldap"
+)
+
+func TestTokenIssuerErrorResponses(t *testing.T) {
+	cases := []struct {
+		name         string
+		basicAuth    bool
+		ldapErr      error
+		signerErr    error
+		expectedCode int
+		expectedErr  string
+	}{
+		{
+			name:         "missing credentials",
+			expectedCode: http.StatusUnauthorized,
+			expectedErr:  "missing_credentials",
+		},
+		{
+			name:         "invalid credentials",
+			basicAuth:    true,
+			ldapErr:      errors.New("LDAP Result Code 49"),
+			expectedCode: http.StatusUnauthorized,
+			expectedErr:  "invalid_credentials",
+		},
+		{
+			name:         "directory unreachable",
+			basicAuth:    true,
+			ldapErr:      &ldap.UnavailableError{Err: errors.New("connection refused")},
+			expectedCode: http.StatusServiceUnavailable,
+			expectedErr:  "backend_unavailable",
+		},
+		{
+			name:         "too many logins in progress",
+			basicAuth:    true,
+			ldapErr:      &ldap.UnavailableError{Err: fmt.Errorf("%w: the queue is full", ldap.ErrTooManyBinds)},
+			expectedCode: http.StatusServiceUnavailable,
+			expectedErr:  "backend_unavailable",
+		},
+		{
+			name:         "signing failed",
+			basicAuth:    true,
+			signerErr:    errors.New("bad key"),
+			expectedCode: http.StatusInternalServerError,
+			expectedErr:  "internal_error",
+		},
+	}
+
+	for _, c := range cases {
+		lti := LDAPTokenIssuer{
+			LDAPAuthenticator:     dummyLDAP{&goldap.Entry{}, c.ldapErr},
+			TokenSigner:           dummySigner{"signedToken", c.signerErr},
+			UnavailableRetryAfter: 30 * time.Second,
+		}
+
+		req, err := http.NewRequest("GET", "", nil)
+		if err != nil {
+			t.Fatalf("%s: Failed to create request: %v", c.name, err)
+		}
+		if c.basicAuth {
+			req.SetBasicAuth("user", "password")
+		}
+
+		rec := httptest.NewRecorder()
+		lti.ServeHTTP(rec, req)
+
+		if rec.Code != c.expectedCode {
+			t.Errorf("%s: Expected %d, got %d", c.name, c.expectedCode, rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: Expected Content-Type application/json, got %q", c.name, ct)
+		}
+		expectedRetry := ""
+		if c.expectedCode == http.StatusServiceUnavailable {
+			expectedRetry = "30"
+		}
+		if retry := rec.Header().Get("Retry-After"); retry != expectedRetry {
+			t.Errorf("%s: Expected Retry-After %q, got %q", c.name, expectedRetry, retry)
+		}
+
+		errResp := &errorResponse{}
+		if err := json.NewDecoder(rec.Body).Decode(errResp); err != nil {
+			t.Fatalf("%s: Error decoding error response: %v", c.name, err)
+		}
+		if errResp.Error.Code != c.expectedErr {
+			t.Errorf("%s: Expected error code %q, got %q", c.name, c.expectedErr, errResp.Error.Code)
+		}
+		if errResp.Error.Message == "" {
+			t.Errorf("%s: Expected a human readable message", c.name)
+		}
+	}
+}
+
+func TestPasswordResetResponse(t *testing.T) {
+	mustChange := fmt.Errorf("wrapped: %w", &ldap.PasswordMustChangeError{Username: "user", Reason: "Password must be changed"})
+	cases := []struct {
+		name            string
+		enabled         bool
+		message         string
+		ldapErr         error
+		expectedCode    int
+		expectedErr     string
+		expectedMessage string
+	}{
+		{
+			name:            "disabled",
+			ldapErr:         mustChange,
+			expectedCode:    http.StatusUnauthorized,
+			expectedErr:     "invalid_credentials",
+			expectedMessage: "invalid username or password",
+		},
+		{
+			name:            "enabled",
+			enabled:         true,
+			ldapErr:         mustChange,
+			expectedCode:    http.StatusForbidden,
+			expectedErr:     "password_reset_required",
+			expectedMessage: DefaultPasswordResetMessage,
+		},
+		{
+			name:            "enabled with a custom message",
+			enabled:         true,
+			message:         "reset your password at https://id.example.com",
+			ldapErr:         mustChange,
+			expectedCode:    http.StatusForbidden,
+			expectedErr:     "password_reset_required",
+			expectedMessage: "reset your password at https://id.example.com",
+		},
+		{
+			name:            "enabled with other failures",
+			enabled:         true,
+			ldapErr:         errors.New("LDAP Result Code 49"),
+			expectedCode:    http.StatusUnauthorized,
+			expectedErr:     "invalid_credentials",
+			expectedMessage: "invalid username or password",
+		},
+	}
+
+	for _, c := range cases {
+		lti := LDAPTokenIssuer{
+			LDAPAuthenticator:     dummyLDAP{nil, c.ldapErr},
+			TokenSigner:           dummySigner{"signedToken", nil},
+			PasswordResetResponse: c.enabled,
+			PasswordResetMessage:  c.message,
+		}
+
+		req, err := http.NewRequest("GET", "", nil)
+		if err != nil {
+			t.Fatalf("%s: Failed to create request: %v", c.name, err)
+		}
+		req.SetBasicAuth("user", "password")
+
+		rec := httptest.NewRecorder()
+		lti.ServeHTTP(rec, req)
+
+		if rec.Code != c.expectedCode {
+			t.Errorf("%s: Expected %d, got %d", c.name, c.expectedCode, rec.Code)
+		}
+		errResp := &errorResponse{}
+		if err := json.NewDecoder(rec.Body).Decode(errResp); err != nil {
+			t.Fatalf("%s: Error decoding error response: %v", c.name, err)
+		}
+		if errResp.Error.Code != c.expectedErr {
+			t.Errorf("%s: Expected error code %q, got %q", c.name, c.expectedErr, errResp.Error.Code)
+		}
+		if errResp.Error.Message != c.expectedMessage {
+			t.Errorf("%s: Expected message %q, got %q", c.name, c.expectedMessage, errResp.Error.Message)
+		}
+	}
+}
+
+func TestBindDiagnosticsNotDisclosed(t *testing.T) {
+	// Telling these apart would let a client enumerate accounts, so the
+	// diagnostic is only logged.
+	var bodies []string
+	for _, code := range []string{"52e", "525", "533", "701", "775"} {
+		diag := fmt.Sprintf("80090308: LdapErr: DSID-0C09042A, comment: AcceptSecurityContext error, data %s, v3839", code)
+		ldapErr := fmt.Errorf("Error binding user to LDAP server: %w", goldap.NewError(goldap.LDAPResultInvalidCredentials, errors.New(diag)))
+		lti := LDAPTokenIssuer{
+			LDAPAuthenticator: dummyLDAP{nil, ldapErr},
+			TokenSigner:       dummySigner{"signedToken", nil},
+		}
+		req, _ := http.NewRequest("GET", "", nil)
+		req.SetBasicAuth("user", "password")
+		rec := httptest.NewRecorder()
+		lti.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("data %s: Expected %d, got %d", code, http.StatusUnauthorized, rec.Code)
+		}
+		bodies = append(bodies, rec.Body.String())
+	}
+	for _, body := range bodies[1:] {
+		if body != bodies[0] {
+			t.Errorf("Expected the same response for every failed bind, got %q and %q", bodies[0], body)
+		}
+	}
+}
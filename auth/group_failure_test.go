@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/proofpoint/kubernetes-ldap/ldap"
+)
+
+func TestGroupsIncompleteAssertion(t *testing.T) {
+	complete := goldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{
+		"uid":      {"alice"},
+		"memberOf": {"cn=mail,ou=lists,dc=example,dc=com"},
+	})
+	incomplete := goldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{
+		"uid":                          {"alice"},
+		"memberOf":                     {"cn=mail,ou=lists,dc=example,dc=com"},
+		ldap.GroupsIncompleteAttribute: {"true"},
+	})
+
+	for _, c := range []struct {
+		name     string
+		entry    *goldap.Entry
+		expected string
+	}{
+		{name: "complete groups", entry: complete},
+		{name: "incomplete groups", entry: incomplete, expected: "true"},
+	} {
+		signer := &recordingSigner{}
+		lti := &LDAPTokenIssuer{
+			LDAPAuthenticator: dummyLDAP{entry: c.entry},
+			TokenSigner:       signer,
+			UsernameAttribute: "uid",
+			TTL:               time.Hour,
+		}
+		req, _ := http.NewRequest("GET", "/ldapAuth", nil)
+		req.SetBasicAuth("alice", "password")
+		rec := httptest.NewRecorder()
+		lti.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: Expected %d, got %d: %s", c.name, http.StatusOK, rec.Code, rec.Body.String())
+		}
+		if got := signer.signed.Assertions["groupsIncomplete"]; got != c.expected {
+			t.Errorf("%s: Expected groupsIncomplete assertion %q, got %q", c.name, c.expected, got)
+		}
+		if !reflect.DeepEqual(signer.signed.Groups, []string{"mail"}) {
+			t.Errorf("%s: Expected the groups from memberOf, got %v", c.name, signer.signed.Groups)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+// groupLimit caps the number of groups in the tokens an endpoint issues,
+// whether they come from the directory, a token being renewed or a bulk
+// record.
+type groupLimit struct {
+	// max is the most groups a token carries. Zero means no limit.
+	max int
+	// policy is GroupLimitTruncate (the default) or GroupLimitReject.
+	policy string
+	// priority groups are kept ahead of the others when truncating.
+	priority []string
+}
+
+// apply enforces the limit on tok. A token over it has its groups
+// truncated, with the original count in the groupsTruncated assertion,
+// or, with GroupLimitReject, is refused with an error for the client.
+func (gl groupLimit) apply(reqID string, tok *token.AuthToken) error {
+	if gl.max <= 0 || len(tok.Groups) <= gl.max {
+		return nil
+	}
+	groupLimitExceeded.Inc()
+	if gl.policy == GroupLimitReject {
+		glog.Errorf("[%s] Refusing token for user %q in %d groups, more than the maximum of %d", reqID, tok.Username, len(tok.Groups), gl.max)
+		return fmt.Errorf("user is a member of more than %d groups", gl.max)
+	}
+	glog.Warningf("[%s] Truncating groups of user %q from %d to %d", reqID, tok.Username, len(tok.Groups), gl.max)
+
+	priority := make(map[string]struct{}, len(gl.priority))
+	for _, group := range gl.priority {
+		priority[strings.ToLower(group)] = struct{}{}
+	}
+	kept := make([]string, 0, len(tok.Groups))
+	var rest []string
+	for _, group := range tok.Groups {
+		if _, ok := priority[strings.ToLower(group)]; ok {
+			kept = append(kept, group)
+		} else {
+			rest = append(rest, group)
+		}
+	}
+	kept = append(kept, rest...)
+
+	// The assertions may be shared with the token this one was copied
+	// from, e.g. when renewing.
+	assertions := make(map[string]string, len(tok.Assertions)+1)
+	for k, v := range tok.Assertions {
+		assertions[k] = v
+	}
+	assertions["groupsTruncated"] = strconv.Itoa(len(tok.Groups))
+	tok.Assertions = assertions
+	tok.Groups = kept[:gl.max]
+	return nil
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+func TestGroupLimitOnRenewal(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	tr := &TokenRenewer{
+		TokenVerifier: verifier,
+		TokenSigner:   signer,
+		TTL:           time.Hour,
+		RenewalWindow: 8 * time.Hour,
+		MaxGroups:     1,
+	}
+	signed, err := signer.Sign(issuedAgo(time.Hour, time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	rec := renewToken(tr, signed)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	renewed, err := verifier.Verify(body.Token)
+	if err != nil {
+		t.Fatalf("Expected the renewed token to verify, got %v", err)
+	}
+	if len(renewed.Groups) != 1 || renewed.Assertions["groupsTruncated"] != "2" {
+		t.Errorf("Expected the groups to be truncated to 1 of 2, got %v and %v", renewed.Groups, renewed.Assertions)
+	}
+
+	tr.GroupLimitPolicy = GroupLimitReject
+	rec = renewToken(tr, signed)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusForbidden, rec.Code, rec.Body.String())
+	}
+	assertErrorCode(t, "renewal over the limit", rec, errCodeTooManyGroups)
+}
+
+func TestGroupLimitOnBulkIssue(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	bi := &BulkTokenIssuer{
+		TokenSigner:      signer,
+		TTL:              time.Hour,
+		MaxGroups:        2,
+		GroupLimitPolicy: GroupLimitReject,
+	}
+
+	_, results := bulkIssue(bi, strings.Join([]string{
+		`{"username": "svc-deploy", "groups": ["deployers", "viewers"]}`,
+		`{"username": "svc-admin", "groups": ["deployers", "viewers", "system:masters"]}`,
+	}, "\n"))
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %v", results)
+	}
+	if _, err := verifier.Verify(results[0].Token); err != nil {
+		t.Errorf("Expected a token for the record within the limit, got %+v: %v", results[0], err)
+	}
+	if results[1].Token != "" || !strings.Contains(results[1].Error, "more than 2 groups") {
+		t.Errorf("Expected the record over the limit to be rejected, got %+v", results[1])
+	}
+}
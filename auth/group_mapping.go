@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"gopkg.in/yaml.v2"
+)
+
+// Policies for directory groups that have no entry in a group mapping.
+const (
+	// UnmappedGroupsPassthrough keeps unmapped groups as they are.
+	UnmappedGroupsPassthrough = "passthrough"
+	// UnmappedGroupsDrop leaves unmapped groups out of the token.
+	UnmappedGroupsDrop = "drop"
+)
+
+// GroupMapper rewrites the directory groups of a user before they are put
+// in a token.
+type GroupMapper interface {
+	Map(groups []string) []string
+}
+
+// GroupMapping maps directory groups to Kubernetes groups.
+type GroupMapping struct {
+	// Groups maps a directory group, matched without regard to case, to
+	// the Kubernetes groups it is replaced with.
+	Groups map[string][]string `yaml:"groups"`
+	// Unmapped is what happens to groups that aren't in Groups: either
+	// UnmappedGroupsPassthrough (the default) or UnmappedGroupsDrop.
+	Unmapped string `yaml:"unmapped"`
+}
+
+// ParseGroupMapping parses a group mapping file, e.g.
+//
+//	unmapped: drop
+//	groups:
+//	  sg-k8s-admins: [cluster-admins]
+//	  sg-developers: [developers, viewers]
+func ParseGroupMapping(data []byte) (*GroupMapping, error) {
+	m := &GroupMapping{}
+	if err := yaml.UnmarshalStrict(data, m); err != nil {
+		return nil, fmt.Errorf("parsing group mapping: %v", err)
+	}
+	switch m.Unmapped {
+	case "":
+		m.Unmapped = UnmappedGroupsPassthrough
+	case UnmappedGroupsPassthrough, UnmappedGroupsDrop:
+	default:
+		return nil, fmt.Errorf("unmapped must be %q or %q, got %q", UnmappedGroupsPassthrough, UnmappedGroupsDrop, m.Unmapped)
+	}
+
+	groups := make(map[string][]string, len(m.Groups))
+	for group, mapped := range m.Groups {
+		for _, g := range mapped {
+			if strings.TrimSpace(g) == "" {
+				return nil, fmt.Errorf("group %q is mapped to an empty group", group)
+			}
+		}
+		key := strings.ToLower(group)
+		groups[key] = append(groups[key], mapped...)
+	}
+	m.Groups = groups
+	return m, nil
+}
+
+// Map returns the Kubernetes groups for the given directory groups, in
+// order and without duplicates.
+func (m *GroupMapping) Map(groups []string) []string {
+	var mapped []string
+	for _, group := range groups {
+		if to, ok := m.Groups[strings.ToLower(group)]; ok {
+			mapped = appendUniqueGroups(mapped, to)
+		} else if m.Unmapped == UnmappedGroupsPassthrough {
+			mapped = appendUniqueGroups(mapped, []string{group})
+		}
+	}
+	if mapped == nil {
+		mapped = []string{}
+	}
+	return mapped
+}
+
+// GroupMappingFile is a GroupMapping read from a file, which is reloaded
+// when the file changes so mappings can be edited without a restart.
+type GroupMappingFile struct {
+	path string
+
+	mu      sync.Mutex
+	mapping *GroupMapping
+	// seen is the state of the file when it was last loaded, or failed
+	// to load.
+	seen fileState
+}
+
+// NewGroupMappingFile loads the group mapping from path.
+func NewGroupMappingFile(path string) (*GroupMappingFile, error) {
+	f := &GroupMappingFile{path: path}
+	f.seen = statFile(path)
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Map maps groups with the current contents of the file. If the file
+// changed but can't be loaded, the previous mapping is used until it
+// changes again.
+func (f *GroupMappingFile) Map(groups []string) []string {
+	f.mu.Lock()
+	if state := statFile(f.path); state != f.seen {
+		f.seen = state
+		if err := f.reload(); err != nil {
+			glog.Errorf("Error reloading group mapping, using the previous one: %v", err)
+		} else {
+			glog.Infof("Reloaded group mapping from %s", f.path)
+		}
+	}
+	mapping := f.mapping
+	f.mu.Unlock()
+
+	return mapping.Map(groups)
+}
+
+func (f *GroupMappingFile) reload() error {
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("reading group mapping: %v", err)
+	}
+	mapping, err := ParseGroupMapping(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", f.path, err)
+	}
+	f.mapping = mapping
+	return nil
+}
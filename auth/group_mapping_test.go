@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+const testGroupMapping = `
+groups:
+  SG-K8s-Admins: [cluster-admins]
+  sg-developers: [developers, viewers]
+  sg-support: [viewers]
+`
+
+func TestGroupMapping(t *testing.T) {
+	cases := []struct {
+		name     string
+		unmapped string
+		groups   []string
+		expected []string
+	}{
+		{
+			name:     "mapped",
+			groups:   []string{"sg-k8s-admins", "sg-developers"},
+			expected: []string{"cluster-admins", "developers", "viewers"},
+		},
+		{
+			name:     "duplicate mapped groups",
+			groups:   []string{"sg-developers", "sg-support"},
+			expected: []string{"developers", "viewers"},
+		},
+		{
+			name:     "passthrough",
+			groups:   []string{"staff", "sg-support"},
+			expected: []string{"staff", "viewers"},
+		},
+		{
+			name:     "drop",
+			unmapped: UnmappedGroupsDrop,
+			groups:   []string{"staff", "sg-support"},
+			expected: []string{"viewers"},
+		},
+		{
+			name:     "all dropped",
+			unmapped: UnmappedGroupsDrop,
+			groups:   []string{"staff"},
+			expected: []string{},
+		},
+	}
+
+	for _, c := range cases {
+		config := testGroupMapping
+		if c.unmapped != "" {
+			config += "unmapped: " + c.unmapped + "\n"
+		}
+		mapping, err := ParseGroupMapping([]byte(config))
+		if err != nil {
+			t.Fatalf("%s: Unexpected error: %v", c.name, err)
+		}
+		if got := mapping.Map(c.groups); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: Expected groups %v, got %v", c.name, c.expected, got)
+		}
+	}
+}
+
+func TestParseGroupMappingErrors(t *testing.T) {
+	cases := map[string]string{
+		"bad policy":        "unmapped: ignore\n",
+		"unknown field":     "group: {a: [b]}\n",
+		"empty target":      "groups: {a: ['']}\n",
+		"not a group list":  "groups: {a: {b: c}}\n",
+		"unparseable input": "groups: [a\n",
+	}
+	for name, config := range cases {
+		if _, err := ParseGroupMapping([]byte(config)); err == nil {
+			t.Errorf("%s: Expected an error", name)
+		}
+	}
+}
+
+func TestGroupMappingApplied(t *testing.T) {
+	mapping, err := ParseGroupMapping([]byte(testGroupMapping + "unmapped: drop\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	lti := LDAPTokenIssuer{
+		GroupMapper: mapping,
+		ExtraGroups: []string{"system:authenticated-ldap"},
+	}
+	e := goldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{
+		"memberOf": {"cn=SG-Developers,dc=example,dc=com", "cn=staff,dc=example,dc=com"},
+	})
+
+	tok := lti.createToken(e)
+	expected := []string{"developers", "viewers", "system:authenticated-ldap"}
+	if !reflect.DeepEqual(tok.Groups, expected) {
+		t.Errorf("Expected groups %v, got %v", expected, tok.Groups)
+	}
+}
+
+func TestGroupMappingFileReload(t *testing.T) {
+	path := writeTestFile(t, "groups.yaml", []byte(testGroupMapping))
+	mapping, err := NewGroupMappingFile(path)
+	if err != nil {
+		t.Fatalf("Loading group mapping: %v", err)
+	}
+	if got := mapping.Map([]string{"sg-support"}); !reflect.DeepEqual(got, []string{"viewers"}) {
+		t.Fatalf("Expected [viewers], got %v", got)
+	}
+
+	update := func(config string) {
+		if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+			t.Fatalf("writing group mapping: %v", err)
+		}
+		later := time.Now().Add(time.Minute)
+		os.Chtimes(path, later, later)
+	}
+
+	update("groups:\n  sg-support: [support]\n")
+	if got := mapping.Map([]string{"sg-support"}); !reflect.DeepEqual(got, []string{"support"}) {
+		t.Errorf("Expected the edited mapping to be applied, got %v", got)
+	}
+
+	// An invalid edit keeps the last good mapping.
+	update("groups: [sg-support\n")
+	if got := mapping.Map([]string{"sg-support"}); !reflect.DeepEqual(got, []string{"support"}) {
+		t.Errorf("Expected the previous mapping while the file is invalid, got %v", got)
+	}
+
+	if _, err := NewGroupMappingFile(path); err == nil {
+		t.Errorf("Expected an error loading an invalid file")
+	}
+}
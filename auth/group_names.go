@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"strings"
+
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+// groupName names the group with the given DN by the value of attribute
+// in its leading RDN, lower cased like the other groups. With cn, the
+// group cn=Team,ou=groups,dc=example,dc=com is team. When the leading RDN
+// is of another attribute, as in ou=team,dc=example,dc=com, its own value
+// is used, so that no group is lost. Values that aren't DNs are kept as
+// they are.
+func groupName(dn, attribute string) string {
+	parsed, err := goldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return strings.ToLower(strings.TrimSpace(dn))
+	}
+
+	rdn := parsed.RDNs[0]
+	for _, attr := range rdn.Attributes {
+		if strings.EqualFold(attr.Type, attribute) {
+			return strings.ToLower(attr.Value)
+		}
+	}
+	return strings.ToLower(rdn.Attributes[0].Value)
+}
+
+// groupNames names the groups of memberOf with groupName, in order and
+// without duplicates.
+func groupNames(membersOf []string, attribute string) []string {
+	groups := []string{}
+	for _, dn := range membersOf {
+		if name := groupName(dn, attribute); name != "" {
+			groups = appendUniqueGroups(groups, []string{name})
+		}
+	}
+	return groups
+}
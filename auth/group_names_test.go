@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+func TestGroupName(t *testing.T) {
+	cases := []struct {
+		name      string
+		dn        string
+		attribute string
+		expected  string
+	}{
+		{name: "cn", dn: "cn=Team,ou=groups,dc=example,dc=com", attribute: "cn", expected: "team"},
+		{name: "attribute type case", dn: "CN=Domain Admins,CN=Users,DC=corp,DC=example,DC=com", attribute: "cn", expected: "domain admins"},
+		{name: "spaces after commas", dn: "cn=k8s-admins, ou=groups, dc=example, dc=com", attribute: "cn", expected: "k8s-admins"},
+		{name: "escaped comma", dn: `cn=Smith\, Jones team,ou=groups,dc=example,dc=com`, attribute: "cn", expected: "smith, jones team"},
+		{name: "nested cn only the leading one", dn: "cn=devs,cn=groups,dc=example,dc=com", attribute: "cn", expected: "devs"},
+		{name: "ou group", dn: "ou=platform,ou=groups,dc=example,dc=com", attribute: "cn", expected: "platform"},
+		{name: "uid group", dn: "uid=release-managers,ou=groups,dc=example,dc=com", attribute: "cn", expected: "release-managers"},
+		{name: "multi-valued RDN", dn: "ou=groups+cn=ops,dc=example,dc=com", attribute: "cn", expected: "ops"},
+		{name: "configured attribute", dn: "ou=platform,ou=groups,dc=example,dc=com", attribute: "ou", expected: "platform"},
+		{name: "not a DN", dn: "Developers", attribute: "cn", expected: "developers"},
+	}
+
+	for _, c := range cases {
+		if got := groupName(c.dn, c.attribute); got != c.expected {
+			t.Errorf("%s: Expected %q, got %q", c.name, c.expected, got)
+		}
+	}
+}
+
+func TestGroupNameAttributeInToken(t *testing.T) {
+	membersOf := []string{
+		"cn=developers,cn=groups,dc=example,dc=com",
+		"ou=platform,ou=groups,dc=example,dc=com",
+		"CN=Developers,OU=Other,DC=example,DC=com",
+	}
+	e := goldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{"memberOf": membersOf})
+
+	lti := LDAPTokenIssuer{GroupNameAttribute: "cn"}
+	expected := []string{"developers", "platform"}
+	if tok := lti.createToken(e); !reflect.DeepEqual(tok.Groups, expected) {
+		t.Errorf("Expected groups %v, got %v", expected, tok.Groups)
+	}
+
+	// Without it, every cn of the DN is a group and other RDNs are lost.
+	lti = LDAPTokenIssuer{}
+	expected = []string{"developers", "groups"}
+	if tok := lti.createToken(e); !reflect.DeepEqual(tok.Groups, expected) {
+		t.Errorf("Expected groups %v, got %v", expected, tok.Groups)
+	}
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Steps of group name normalization, for ParseGroupNormalization.
+const (
+	// NormalizeTrim strips leading and trailing white space and collapses
+	// inner runs of white space to a single space.
+	NormalizeTrim = "trim"
+	// NormalizeFoldCase applies full Unicode case folding, beyond the
+	// lower casing every group gets, so that e.g. Straße and STRASSE are
+	// the same group.
+	NormalizeFoldCase = "fold-case"
+	// NormalizeFoldDiacritics removes accents and other combining marks,
+	// so that e.g. Équipe and Equipe are the same group.
+	NormalizeFoldDiacritics = "fold-diacritics"
+)
+
+// ParseGroupNormalization parses the name of a group name normalization
+// step.
+func ParseGroupNormalization(name string) (Transform, error) {
+	switch name {
+	case NormalizeTrim:
+		return func(group string) string { return strings.Join(strings.Fields(group), " ") }, nil
+	case NormalizeFoldCase:
+		return func(group string) string { return cases.Fold().String(group) }, nil
+	case NormalizeFoldDiacritics:
+		return foldDiacritics, nil
+	}
+	return nil, fmt.Errorf("unknown group normalization %q, expected %s, %s or %s", name, NormalizeTrim, NormalizeFoldCase, NormalizeFoldDiacritics)
+}
+
+// foldDiacritics decomposes group and drops the combining marks, then
+// recomposes what is left.
+func foldDiacritics(group string) string {
+	folded := strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		return r
+	}, norm.NFD.String(group))
+	return norm.NFC.String(folded)
+}
+
+// normalizeGroups passes groups through the normalization steps in
+// order, dropping the groups that end up empty or the same as an
+// earlier one. It also reports whether any group was changed.
+func normalizeGroups(groups []string, steps []Transform) ([]string, bool) {
+	normalized := make([]string, 0, len(groups))
+	changed := false
+	for _, group := range groups {
+		name := group
+		for _, step := range steps {
+			name = step(name)
+		}
+		if name != group {
+			changed = true
+		}
+		if name != "" {
+			normalized = append(normalized, name)
+		}
+	}
+	unique := appendUniqueGroups([]string{}, normalized)
+	return unique, changed || len(unique) != len(groups)
+}
+
+// originalGroups encodes the groups as they were before normalization as
+// a JSON array, for the OriginalGroupsAssertion.
+func originalGroups(groups []string) string {
+	encoded, _ := json.Marshal(groups)
+	return string(encoded)
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestGroupNormalization(t *testing.T) {
+	memberOf := []string{
+		"cn=Platform  Team ,ou=groups,dc=example,dc=com",
+		"cn=platform team,ou=groups,dc=example,dc=com",
+		"cn=Équipe,ou=groups,dc=example,dc=com",
+		"cn=equipe,ou=groups,dc=example,dc=com",
+		"cn=STRASSE,ou=groups,dc=example,dc=com",
+		"cn=Straße,ou=groups,dc=example,dc=com",
+		"cn= ,ou=groups,dc=example,dc=com",
+	}
+	cases := []struct {
+		name     string
+		steps    []string
+		expected []string
+	}{
+		{
+			name:     "trim",
+			steps:    []string{NormalizeTrim},
+			expected: []string{"platform team", "équipe", "equipe", "strasse", "straße"},
+		},
+		{
+			name:     "fold case",
+			steps:    []string{NormalizeFoldCase},
+			expected: []string{"platform  team ", "platform team", "équipe", "equipe", "strasse", " "},
+		},
+		{
+			name:     "fold diacritics",
+			steps:    []string{NormalizeFoldDiacritics},
+			expected: []string{"platform  team ", "platform team", "equipe", "strasse", "straße", " "},
+		},
+		{
+			name:     "all",
+			steps:    []string{NormalizeTrim, NormalizeFoldCase, NormalizeFoldDiacritics},
+			expected: []string{"platform team", "equipe", "strasse"},
+		},
+	}
+
+	for _, c := range cases {
+		var steps []Transform
+		for _, name := range c.steps {
+			step, err := ParseGroupNormalization(name)
+			if err != nil {
+				t.Fatalf("%s: Unexpected error: %v", c.name, err)
+			}
+			steps = append(steps, step)
+		}
+		signer := &recordingSigner{}
+		lti := &LDAPTokenIssuer{
+			LDAPAuthenticator:       dummyLDAP{entry: ldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{"uid": {"alice"}, "memberOf": memberOf})},
+			TokenSigner:             signer,
+			UsernameAttribute:       "uid",
+			TTL:                     time.Hour,
+			GroupNormalization:      steps,
+			OriginalGroupsAssertion: "originalGroups",
+		}
+		req, _ := http.NewRequest("GET", "/ldapAuth", nil)
+		req.SetBasicAuth("alice", "password")
+		rec := httptest.NewRecorder()
+		lti.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: Expected %d, got %d: %s", c.name, http.StatusOK, rec.Code, rec.Body.String())
+		}
+		if !reflect.DeepEqual(signer.signed.Groups, c.expected) {
+			t.Errorf("%s: Expected groups %q, got %q", c.name, c.expected, signer.signed.Groups)
+		}
+		var original []string
+		if err := json.Unmarshal([]byte(signer.signed.Assertions["originalGroups"]), &original); err != nil {
+			t.Fatalf("%s: Failed to decode the original groups: %v", c.name, err)
+		}
+		expectedOriginal := []string{"platform  team ", "platform team", "équipe", "equipe", "strasse", "straße", " "}
+		if !reflect.DeepEqual(original, expectedOriginal) {
+			t.Errorf("%s: Expected original groups %q, got %q", c.name, expectedOriginal, original)
+		}
+	}
+}
+
+func TestGroupNormalizationUnchanged(t *testing.T) {
+	trim, _ := ParseGroupNormalization(NormalizeTrim)
+	groups, changed := normalizeGroups([]string{"devs", "ops"}, []Transform{trim})
+	if changed || !reflect.DeepEqual(groups, []string{"devs", "ops"}) {
+		t.Errorf("Expected the groups to be unchanged, got %q (changed: %v)", groups, changed)
+	}
+	if _, err := ParseGroupNormalization("uppercase"); err == nil {
+		t.Errorf("Expected an unknown normalization to be rejected")
+	}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"strings"
+	"time"
+)
+
+// groupTTL returns the shortest of ttls for the given groups, matched by
+// name without regard to case, or ttl if none of the groups has one.
+func groupTTL(ttls map[string]time.Duration, groups []string, ttl time.Duration) time.Duration {
+	if len(ttls) == 0 {
+		return ttl
+	}
+	byGroup := make(map[string]time.Duration, len(ttls))
+	for group, groupTTL := range ttls {
+		key := strings.ToLower(group)
+		if current, ok := byGroup[key]; !ok || groupTTL < current {
+			byGroup[key] = groupTTL
+		}
+	}
+
+	shortest, matched := time.Duration(0), false
+	for _, group := range groups {
+		groupTTL, ok := byGroup[strings.ToLower(group)]
+		if ok && (!matched || groupTTL < shortest) {
+			shortest, matched = groupTTL, true
+		}
+	}
+	if !matched {
+		return ttl
+	}
+	return shortest
+}
+
+// prefixedGroupTTLs returns ttls with each entry also under the group's
+// name with prefix prepended, so that it matches both the prefixed
+// directory groups and the configured groups of that name.
+func prefixedGroupTTLs(ttls map[string]time.Duration, prefix string) map[string]time.Duration {
+	if prefix == "" || len(ttls) == 0 {
+		return ttls
+	}
+	both := make(map[string]time.Duration, 2*len(ttls))
+	for group, ttl := range ttls {
+		both[group] = ttl
+		both[prefix+group] = ttl
+	}
+	return both
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+func TestGroupTTLs(t *testing.T) {
+	groupTTLs := map[string]time.Duration{
+		"Cluster-Admins": 15 * time.Minute,
+		"oncall":         time.Hour,
+	}
+
+	cases := []struct {
+		name     string
+		memberOf []string
+		expected time.Duration
+	}{
+		{name: "regular user", memberOf: []string{"cn=developers,ou=groups,dc=example,dc=com"}, expected: 8 * time.Hour},
+		{name: "admin", memberOf: []string{"cn=cluster-admins,ou=groups,dc=example,dc=com"}, expected: 15 * time.Minute},
+		{name: "on call", memberOf: []string{"cn=developers,ou=groups,dc=example,dc=com", "cn=oncall,ou=groups,dc=example,dc=com"}, expected: time.Hour},
+		{name: "on call admin", memberOf: []string{"cn=oncall,ou=groups,dc=example,dc=com", "cn=cluster-admins,ou=groups,dc=example,dc=com"}, expected: 15 * time.Minute},
+	}
+	for _, c := range cases {
+		lti := &LDAPTokenIssuer{
+			TTL:       8 * time.Hour,
+			GroupTTLs: groupTTLs,
+		}
+		before := nowMillis()
+		tok := lti.createToken(ldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{"memberOf": c.memberOf}))
+		after := nowMillis()
+		ttl := c.expected.Milliseconds()
+		if tok.Expiration < before+ttl || tok.Expiration > after+ttl {
+			t.Errorf("%s: Expected the token to expire in %v, got %v", c.name, c.expected, time.Duration(tok.Expiration-after)*time.Millisecond)
+		}
+	}
+}
+
+func TestGroupTTLsOfRenewedTokens(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	lti := &LDAPTokenIssuer{
+		LDAPAuthenticator: dummyLDAP{entry: ldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{
+			"uid":      {"alice"},
+			"memberOf": {"cn=cluster-admins,ou=groups,dc=example,dc=com"},
+		})},
+		TokenSigner:       signer,
+		TTL:               8 * time.Hour,
+		UsernameAttribute: "uid",
+		RefreshTTL:        24 * time.Hour,
+		GroupTTLs:         map[string]time.Duration{"cluster-admins": 15 * time.Minute},
+	}
+	access, refresh := issueTokens(t, lti)
+
+	// The admin's token is still short-lived once renewed or refreshed.
+	renewer := &TokenRenewer{
+		TokenVerifier: verifier,
+		TokenSigner:   signer,
+		TTL:           8 * time.Hour,
+		RenewalWindow: 24 * time.Hour,
+		GroupTTLs:     lti.GroupTTLs,
+	}
+	refresher := &TokenRefresher{
+		TokenVerifier: verifier,
+		TokenSigner:   signer,
+		TTL:           8 * time.Hour,
+		GroupTTLs:     lti.GroupTTLs,
+	}
+	limit := nowMillis() + (15 * time.Minute).Milliseconds()
+	tokens := map[string]string{"issued": access}
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"renewed":   renewToken(renewer, access),
+		"refreshed": refreshToken(refresher, refresh),
+	} {
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: Expected %d, got %d: %s", name, http.StatusOK, rec.Code, rec.Body.String())
+		}
+		var body struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: Failed to decode response: %v", name, err)
+		}
+		tokens[name] = body.Token
+	}
+	for name, signed := range tokens {
+		tok, err := verifier.Verify(signed)
+		if err != nil {
+			t.Fatalf("%s: Failed to verify token: %v", name, err)
+		}
+		if tok.Expiration > limit+time.Minute.Milliseconds() {
+			t.Errorf("%s: Expected the admin's token to expire within 15m, got %v", name, time.Duration(tok.Expiration-nowMillis())*time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+var (
+	introspectionRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_introspection_requests",
+			Help: "Total number of token introspection requests.",
+		},
+	)
+	inactiveIntrospections = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_inactive_introspections",
+			Help: "Total number of introspected tokens reported inactive, because they are invalid or expired.",
+		},
+	)
+)
+
+// RegisterIntrospectionMetrics registers the metrics for token
+// introspection.
+func RegisterIntrospectionMetrics() {
+	prometheus.MustRegister(introspectionRequests)
+	prometheus.MustRegister(inactiveIntrospections)
+}
+
+// TokenIntrospector answers token introspection requests (RFC 7662): a
+// token POSTed as the token form parameter is reported active, with the
+// identity it grants, or inactive. It doesn't authenticate the caller,
+// so it must be wrapped, e.g. with RequireBearerToken.
+type TokenIntrospector struct {
+	TokenVerifier token.Verifier
+	// TokenPrefix is expected on the token, as for TokenWebhook.
+	TokenPrefix string
+	// Audiences are reported as the aud of active tokens that carry none
+	// themselves.
+	Audiences []string
+}
+
+// introspectionResponse is the RFC 7662 introspection response. Inactive
+// tokens only have active set to false.
+type introspectionResponse struct {
+	Active    bool     `json:"active"`
+	Username  string   `json:"username,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	Expiry    int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	Groups    []string `json:"groups,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+	// Confirmation is the certificate the token is bound to (RFC 8705).
+	Confirmation *token.Confirmation `json:"cnf,omitempty"`
+}
+
+func (ti *TokenIntrospector) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	introspectionRequests.Inc()
+	reqID := requestID(resp, req)
+	if req.Method != http.MethodPost {
+		writeError(resp, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "introspection requests must be POSTed")
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		writeError(resp, http.StatusBadRequest, errCodeInvalidRequest, "the request body must be form encoded")
+		return
+	}
+	rawToken := req.PostForm.Get("token")
+	if rawToken == "" {
+		writeError(resp, http.StatusBadRequest, errCodeInvalidRequest, "the token parameter is required")
+		return
+	}
+
+	body := ti.introspect(reqID, rawToken)
+	if !body.Active {
+		inactiveIntrospections.Inc()
+	}
+	jsondata, err := json.Marshal(body)
+	if err != nil {
+		glog.Errorf("[%s] Error marshalling json %s", reqID, err.Error())
+		writeError(resp, http.StatusInternalServerError, errCodeInternal, "error marshalling response")
+		return
+	}
+
+	resp.Header().Add("Content-Type", "application/json")
+	resp.Write(jsondata)
+}
+
+// introspect verifies rawToken and describes it. Tokens that fail to
+// verify for any reason are inactive, without saying why.
+func (ti *TokenIntrospector) introspect(reqID, rawToken string) introspectionResponse {
+	if ti.TokenPrefix != "" {
+		if !strings.HasPrefix(rawToken, ti.TokenPrefix) {
+			return introspectionResponse{}
+		}
+		rawToken = strings.TrimPrefix(rawToken, ti.TokenPrefix)
+	}
+
+	tok, err := ti.TokenVerifier.Verify(rawToken)
+	if err != nil {
+		glog.Infof("[%s] Introspected token is inactive: %v", reqID, err)
+		return introspectionResponse{}
+	}
+
+	tokenType := tok.Type
+	if tokenType == "" {
+		tokenType = token.TypeAccess
+	}
+	audiences := ti.Audiences
+	if len(tok.Audiences) > 0 {
+		audiences = tok.Audiences
+	}
+	return introspectionResponse{
+		Active:    true,
+		Username:  tok.Username,
+		Subject:   tok.UID,
+		TokenType: tokenType,
+		Scope:     strings.Join(tok.Scopes, " "),
+		Expiry:    tok.Expiration / 1000,
+		IssuedAt:  tok.IssuedAt / 1000,
+		Audience:  audiences,
+		Groups:    tok.Groups,
+		ID:        tok.ID,
+		// Resource servers check the binding against their client.
+		Confirmation: tok.Confirmation,
+	}
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+func introspect(handler http.Handler, clientToken string, form url.Values) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/introspect", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientToken != "" {
+		req.Header.Set("Authorization", "Bearer "+clientToken)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTokenIntrospection(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	other, _, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	handler := RequireBearerToken("client-secret", &TokenIntrospector{
+		TokenVerifier: verifier,
+		TokenPrefix:   "ldap:",
+		Audiences:     []string{"kubernetes"},
+	})
+
+	sign := func(s token.Signer, tok *token.AuthToken) string {
+		signed, err := s.Sign(tok)
+		if err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+		return "ldap:" + signed
+	}
+	now := time.Now()
+	valid := &token.AuthToken{
+		Username:   "alice",
+		UID:        "1001",
+		Groups:     []string{"admins", "devs"},
+		Scopes:     []string{"read-only"},
+		Expiration: now.Add(time.Hour).UnixNano() / int64(time.Millisecond),
+		IssuedAt:   now.UnixNano() / int64(time.Millisecond),
+	}
+	expired := &token.AuthToken{Username: "alice", Expiration: expirationAfter(-time.Hour)}
+
+	rec := introspect(handler, "client-secret", url.Values{"token": {sign(signer, valid)}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var body introspectionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	expected := introspectionResponse{
+		Active:    true,
+		Username:  "alice",
+		Subject:   "1001",
+		TokenType: token.TypeAccess,
+		Scope:     "read-only",
+		Expiry:    now.Add(time.Hour).Unix(),
+		IssuedAt:  now.Unix(),
+		Audience:  []string{"kubernetes"},
+		Groups:    []string{"admins", "devs"},
+	}
+	if !reflect.DeepEqual(body, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, body)
+	}
+
+	inactive := map[string]string{
+		"expired":        sign(signer, expired),
+		"other signer":   sign(other, valid),
+		"garbage":        "ldap:not-a-token",
+		"missing prefix": strings.TrimPrefix(sign(signer, valid), "ldap:"),
+	}
+	for name, tok := range inactive {
+		rec := introspect(handler, "client-secret", url.Values{"token": {tok}})
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: Expected %d, got %d: %s", name, http.StatusOK, rec.Code, rec.Body.String())
+			continue
+		}
+		// Nothing but active is disclosed about inactive tokens.
+		if strings.TrimSpace(rec.Body.String()) != `{"active":false}` {
+			t.Errorf("%s: Expected an inactive token, got %s", name, rec.Body.String())
+		}
+	}
+}
+
+func TestTokenIntrospectionRejectedRequests(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	handler := RequireBearerToken("client-secret", &TokenIntrospector{TokenVerifier: verifier})
+	signed, err := signer.Sign(&token.AuthToken{Username: "alice", Expiration: expirationAfter(time.Hour)})
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	cases := []struct {
+		name           string
+		clientToken    string
+		form           url.Values
+		expectedStatus int
+	}{
+		{name: "unauthenticated", form: url.Values{"token": {signed}}, expectedStatus: http.StatusUnauthorized},
+		{name: "wrong client token", clientToken: "guess", form: url.Values{"token": {signed}}, expectedStatus: http.StatusUnauthorized},
+		// The token being introspected doesn't authenticate the caller.
+		{name: "introspected token as client token", clientToken: signed, form: url.Values{"token": {signed}}, expectedStatus: http.StatusUnauthorized},
+		{name: "no token", clientToken: "client-secret", form: url.Values{}, expectedStatus: http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		rec := introspect(handler, c.clientToken, c.form)
+		if rec.Code != c.expectedStatus {
+			t.Errorf("%s: Expected %d, got %d: %s", c.name, c.expectedStatus, rec.Code, rec.Body.String())
+		}
+		if strings.Contains(rec.Body.String(), "alice") {
+			t.Errorf("%s: Expected nothing to be disclosed about the token, got %s", c.name, rec.Body.String())
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/introspect?token="+signed, nil)
+	req.Header.Set("Authorization", "Bearer client-secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected %d for a GET, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+// Where an IssuedTokenRecord's token was issued.
+const (
+	IssuedByLogin   = "ldapAuth"
+	IssuedByRefresh = "refresh"
+	IssuedByRenewal = "renew"
+	IssuedByBulk    = "bulkIssue"
+)
+
+// IssuedTokenRecord is what IssuedTokenLog keeps of an issued token:
+// metadata only, never the token itself or its assertions.
+type IssuedTokenRecord struct {
+	ID        string    `json:"jti,omitempty"`
+	Username  string    `json:"username"`
+	Type      string    `json:"type"`
+	IssuedBy  string    `json:"issuedBy"`
+	IssuedAt  time.Time `json:"issuedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// IssuedTokenLog remembers the last tokens issued, up to a fixed number,
+// for live debugging without a token store. Older records are
+// overwritten. A nil log records nothing.
+type IssuedTokenLog struct {
+	mu      sync.Mutex
+	records []IssuedTokenRecord
+	// next is where the next record goes, and full whether records
+	// wrapped around already.
+	next int
+	full bool
+}
+
+// NewIssuedTokenLog returns a log of the last size tokens issued.
+func NewIssuedTokenLog(size int) *IssuedTokenLog {
+	return &IssuedTokenLog{records: make([]IssuedTokenRecord, size)}
+}
+
+func (l *IssuedTokenLog) record(issuedBy string, tok *token.AuthToken) {
+	if l == nil || len(l.records) == 0 {
+		return
+	}
+	tokenType := tok.Type
+	if tokenType == "" {
+		tokenType = token.TypeAccess
+	}
+	record := IssuedTokenRecord{
+		ID:        tok.ID,
+		Username:  tok.Username,
+		Type:      tokenType,
+		IssuedBy:  issuedBy,
+		IssuedAt:  millisToTime(tok.IssuedAt),
+		ExpiresAt: millisToTime(tok.Expiration),
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records[l.next] = record
+	l.next++
+	if l.next == len(l.records) {
+		l.next = 0
+		l.full = true
+	}
+}
+
+// Records returns the records in the log, oldest first.
+func (l *IssuedTokenLog) Records() []IssuedTokenRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.full {
+		return append([]IssuedTokenRecord{}, l.records[:l.next]...)
+	}
+	records := make([]IssuedTokenRecord, 0, len(l.records))
+	records = append(records, l.records[l.next:]...)
+	return append(records, l.records[:l.next]...)
+}
+
+// IssuedTokensHandler dumps an IssuedTokenLog. It doesn't authenticate
+// the caller, so it must be wrapped, e.g. with RequireBearerToken.
+type IssuedTokensHandler struct {
+	Log *IssuedTokenLog
+}
+
+// issuedTokensResponse is what IssuedTokensHandler returns.
+type issuedTokensResponse struct {
+	Tokens []IssuedTokenRecord `json:"tokens"`
+}
+
+func (ih *IssuedTokensHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	reqID := requestID(resp, req)
+	if req.Method != http.MethodGet {
+		writeError(resp, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "issued tokens must be requested with GET")
+		return
+	}
+
+	jsondata, err := json.Marshal(issuedTokensResponse{Tokens: ih.Log.Records()})
+	if err != nil {
+		glog.Errorf("[%s] Error marshalling json %s", reqID, err.Error())
+		writeError(resp, http.StatusInternalServerError, errCodeInternal, "error marshalling response")
+		return
+	}
+
+	resp.Header().Add("Content-Type", "application/json")
+	resp.Write(jsondata)
+}
+
+// newTokenID returns a random token ID, for AuthToken.ID.
+func newTokenID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// The ID is informational, the token is issued without one.
+		glog.Errorf("Error generating token ID: %v", err)
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// millisToTime converts unix milliseconds, like AuthToken times, to a
+// time.
+func millisToTime(millis int64) time.Time {
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC()
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+func recordUsers(log *IssuedTokenLog, names ...string) {
+	for _, name := range names {
+		log.record(IssuedByBulk, &token.AuthToken{ID: "id-" + name, Username: name, IssuedAt: nowMillis(), Expiration: expirationAfter(time.Hour)})
+	}
+}
+
+func recordedUsers(log *IssuedTokenLog) []string {
+	var names []string
+	for _, record := range log.Records() {
+		names = append(names, record.Username)
+	}
+	return names
+}
+
+func TestIssuedTokenLogWraparound(t *testing.T) {
+	log := NewIssuedTokenLog(3)
+	if records := log.Records(); len(records) != 0 {
+		t.Fatalf("Expected an empty log, got %+v", records)
+	}
+
+	recordUsers(log, "alice", "bob")
+	if names := strings.Join(recordedUsers(log), ","); names != "alice,bob" {
+		t.Errorf("Expected alice,bob, got %s", names)
+	}
+	recordUsers(log, "carol")
+	if names := strings.Join(recordedUsers(log), ","); names != "alice,bob,carol" {
+		t.Errorf("Expected alice,bob,carol, got %s", names)
+	}
+	// The oldest records are overwritten, the rest kept in order.
+	recordUsers(log, "dave", "erin")
+	if names := strings.Join(recordedUsers(log), ","); names != "carol,dave,erin" {
+		t.Errorf("Expected carol,dave,erin, got %s", names)
+	}
+	recordUsers(log, "frank", "grace", "heidi", "ivan")
+	if names := strings.Join(recordedUsers(log), ","); names != "grace,heidi,ivan" {
+		t.Errorf("Expected grace,heidi,ivan, got %s", names)
+	}
+
+	// A nil log records nothing.
+	var disabled *IssuedTokenLog
+	recordUsers(disabled, "alice")
+}
+
+func TestIssuedTokenLogConcurrentWrites(t *testing.T) {
+	log := NewIssuedTokenLog(50)
+	var wg sync.WaitGroup
+	for g := 0; g < 10; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				recordUsers(log, fmt.Sprintf("user-%d-%d", g, i))
+				if i%10 == 0 {
+					log.Records()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	records := log.Records()
+	if len(records) != 50 {
+		t.Fatalf("Expected the log to be bounded to 50 records, got %d", len(records))
+	}
+	seen := map[string]bool{}
+	for _, record := range records {
+		if record.Username == "" || seen[record.Username] {
+			t.Errorf("Expected distinct records, got %+v", record)
+		}
+		seen[record.Username] = true
+	}
+}
+
+func TestIssuedTokensHandler(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	log := NewIssuedTokenLog(10)
+	issuer := &LDAPTokenIssuer{
+		LDAPAuthenticator: dummyLDAP{entry: ldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{"uid": {"alice"}})},
+		TokenSigner:       signer,
+		UsernameAttribute: "uid",
+		TTL:               time.Hour,
+		IssuedTokens:      log,
+	}
+	first := issueVia(t, issuer, "/ldapAuth")
+	second := issueVia(t, issuer, "/ldapAuth")
+
+	handler := RequireBearerToken("admin-secret", &IssuedTokensHandler{Log: log})
+	req, _ := http.NewRequest("GET", "/issuedTokens", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), first) || strings.Contains(rec.Body.String(), second) {
+		t.Errorf("Expected the tokens not to be disclosed, got %s", rec.Body.String())
+	}
+
+	var body issuedTokensResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(body.Tokens) != 2 {
+		t.Fatalf("Expected two records, got %s", rec.Body.String())
+	}
+	for i, signed := range []string{first, second} {
+		tok, err := verifier.Verify(signed)
+		if err != nil {
+			t.Fatalf("Failed to verify token: %v", err)
+		}
+		record := body.Tokens[i]
+		if tok.ID == "" || record.ID != tok.ID || record.Username != "alice" || record.Type != token.TypeAccess || record.IssuedBy != IssuedByLogin {
+			t.Errorf("Expected a record of %+v, got %+v", tok, record)
+		}
+		if record.ExpiresAt.UnixNano()/int64(time.Millisecond) != tok.Expiration {
+			t.Errorf("Expected the record to expire at %d, got %v", tok.Expiration, record.ExpiresAt)
+		}
+	}
+	if body.Tokens[0].ID == body.Tokens[1].ID {
+		t.Errorf("Expected distinct token IDs, got %s twice", body.Tokens[0].ID)
+	}
+
+	req, _ = http.NewRequest("GET", "/issuedTokens", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected %d without the bearer token, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+// KeyRotationHandler replaces the signing key with a newly generated one
+// on POST, and returns the new and retired keys' metadata. Anyone who can
+// call it can invalidate every token after the overlap, so it must be
+// wrapped, e.g. with RequireBearerToken.
+type KeyRotationHandler struct {
+	Rotator *token.KeyRotator
+}
+
+func (kh *KeyRotationHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	reqID := requestID(resp, req)
+	if req.Method != http.MethodPost {
+		writeError(resp, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "keys must be rotated with POST")
+		return
+	}
+
+	glog.Warningf("[%s] *** SIGNING KEY ROTATION requested from %s ***", reqID, req.RemoteAddr)
+	rotation, err := kh.Rotator.Rotate()
+	if err != nil {
+		glog.Errorf("[%s] *** SIGNING KEY ROTATION FAILED: %v ***", reqID, err)
+		writeError(resp, http.StatusInternalServerError, errCodeInternal, "error rotating the signing key")
+		return
+	}
+	for _, key := range rotation.Retired {
+		glog.Warningf("[%s] *** Retired signing key %s, trusted until %s ***", reqID, key.Thumbprint, rotation.RetiredUntil.UTC().Format(time.RFC3339))
+	}
+	glog.Warningf("[%s] *** Tokens are now signed with the new key %s, which is lost when the process exits ***", reqID, rotation.Key.Thumbprint)
+
+	jsondata, err := json.Marshal(rotation)
+	if err != nil {
+		glog.Errorf("[%s] Error marshalling json %s", reqID, err.Error())
+		writeError(resp, http.StatusInternalServerError, errCodeInternal, "error marshalling response")
+		return
+	}
+
+	resp.Header().Add("Content-Type", "application/json")
+	resp.Write(jsondata)
+}
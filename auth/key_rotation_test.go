@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+func TestKeyRotation(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	rotator := token.NewKeyRotator(signer, verifier, token.SignerOptions{}, time.Hour)
+	handler := RequireBearerToken("admin-secret", &KeyRotationHandler{Rotator: rotator})
+	lti := &LDAPTokenIssuer{
+		LDAPAuthenticator: dummyLDAP{entry: ldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{"uid": {"alice"}})},
+		TokenSigner:       rotator,
+		TTL:               time.Hour,
+		UsernameAttribute: "uid",
+	}
+	tw := NewTokenWebhook(rotator)
+
+	oldToken := issueVia(t, lti, "/ldapAuth")
+
+	for _, c := range []struct {
+		method, bearer string
+		code           int
+	}{
+		{method: "POST", code: http.StatusUnauthorized},
+		{method: "POST", bearer: "wrong-secret", code: http.StatusUnauthorized},
+		{method: "GET", bearer: "admin-secret", code: http.StatusMethodNotAllowed},
+	} {
+		req, _ := http.NewRequest(c.method, "/rotateKey", nil)
+		if c.bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+c.bearer)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != c.code {
+			t.Errorf("%s with bearer %q: Expected %d, got %d", c.method, c.bearer, c.code, rec.Code)
+		}
+	}
+	if _, err := verifier.Verify(issueVia(t, lti, "/ldapAuth")); err != nil {
+		t.Fatalf("Expected the key not to be rotated by rejected requests, got %v", err)
+	}
+
+	req, _ := http.NewRequest("POST", "/rotateKey", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var rotation token.Rotation
+	if err := json.Unmarshal(rec.Body.Bytes(), &rotation); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	oldKey := verifier.(token.KeyLister).TrustedKeys()[0]
+	if rotation.Key.Thumbprint == "" || rotation.Key.Thumbprint == oldKey.Thumbprint {
+		t.Errorf("Expected a new key, got %s", rec.Body.String())
+	}
+	if len(rotation.Retired) != 1 || rotation.Retired[0].Thumbprint != oldKey.Thumbprint {
+		t.Errorf("Expected the old key to be retired, got %s", rec.Body.String())
+	}
+
+	// New tokens are signed with the new key, and both verify.
+	newToken := issueVia(t, lti, "/ldapAuth")
+	if _, err := verifier.Verify(newToken); err == nil {
+		t.Errorf("Expected a new token not to be signed with the old key")
+	}
+	for name, signed := range map[string]string{"old token": oldToken, "new token": newToken} {
+		if rec := reviewToken(tw, signed); rec.Code != http.StatusOK {
+			t.Errorf("%s: Expected %d from the webhook, got %d: %s", name, http.StatusOK, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+// VerificationKeysHandler lists the public metadata of the keys tokens
+// are verified with, e.g. to confirm that a rotation completed. It
+// doesn't authenticate the caller, so it must be wrapped, e.g. with
+// RequireBearerToken.
+type VerificationKeysHandler struct {
+	KeyListers []token.KeyLister
+}
+
+// verificationKeysResponse is what VerificationKeysHandler returns.
+type verificationKeysResponse struct {
+	Keys []token.KeyInfo `json:"keys"`
+}
+
+func (vh *VerificationKeysHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	reqID := requestID(resp, req)
+	if req.Method != http.MethodGet {
+		writeError(resp, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "verification keys must be requested with GET")
+		return
+	}
+
+	keys := []token.KeyInfo{}
+	for _, lister := range vh.KeyListers {
+		keys = append(keys, lister.TrustedKeys()...)
+	}
+	jsondata, err := json.Marshal(verificationKeysResponse{Keys: keys})
+	if err != nil {
+		glog.Errorf("[%s] Error marshalling json %s", reqID, err.Error())
+		writeError(resp, http.StatusInternalServerError, errCodeInternal, "error marshalling response")
+		return
+	}
+
+	resp.Header().Add("Content-Type", "application/json")
+	resp.Write(jsondata)
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+func TestVerificationKeys(t *testing.T) {
+	dir := t.TempDir()
+	if err := token.GenerateKeypair(dir); err != nil {
+		t.Fatalf("Failed to generate keypair: %v", err)
+	}
+	verifier, err := token.NewVerifier(dir)
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+	handler := RequireBearerToken("admin-secret", &VerificationKeysHandler{
+		KeyListers: []token.KeyLister{verifier.(token.KeyLister)},
+	})
+
+	req, _ := http.NewRequest("GET", "/verificationKeys", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var body struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	expected := verifier.(token.KeyLister).TrustedKeys()
+	if len(body.Keys) != 1 {
+		t.Fatalf("Expected one key, got %s", rec.Body.String())
+	}
+	key := body.Keys[0]
+	if key["alg"] != token.AlgorithmES256 || key["thumbprint"] != expected[0].Thumbprint || key["use"] != token.KeyUsePrimary || key["loadedAt"] == "" {
+		t.Errorf("Expected %+v, got %s", expected[0], rec.Body.String())
+	}
+	// Only metadata is listed, no key material.
+	for name := range key {
+		switch name {
+		case "kid", "alg", "thumbprint", "use", "loadedAt":
+		default:
+			t.Errorf("Expected only key metadata, got %q in %s", name, rec.Body.String())
+		}
+	}
+	der, err := ioutil.ReadFile(filepath.Join(dir, "signing.priv"))
+	if err != nil {
+		t.Fatalf("Failed to read private key: %v", err)
+	}
+	privateKey, err := x509.ParseECPrivateKey(der)
+	if err != nil {
+		t.Fatalf("Failed to parse private key: %v", err)
+	}
+	d := privateKey.D.Bytes()
+	for _, encoded := range []string{base64.RawURLEncoding.EncodeToString(d), base64.StdEncoding.EncodeToString(d), hex.EncodeToString(d)} {
+		if strings.Contains(rec.Body.String(), encoded) {
+			t.Errorf("Expected the private key not to be disclosed, got %s", rec.Body.String())
+		}
+	}
+
+	req, _ = http.NewRequest("GET", "/verificationKeys", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected %d without the bearer token, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+// authTokenKey is the context key of the access token verified by
+// RequireAccessToken.
+type authTokenKey struct{}
+
+// RequireAccessToken wraps a handler, e.g. of another service trusting
+// this one's tokens, so that it is only served to requests with a valid
+// access token as their bearer token. tokenPrefix, if set, is expected
+// on the token, as for TokenWebhook. Tokens bound to a client
+// certificate are only accepted over a connection authenticated with it.
+// The verified token is available to the handler from TokenFromContext.
+func RequireAccessToken(verifier token.Verifier, tokenPrefix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		authorization := req.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "Bearer ") {
+			resp.Header().Set("WWW-Authenticate", `Bearer realm="kubernetes ldap"`)
+			writeError(resp, http.StatusUnauthorized, errCodeMissingCredentials, "a bearer token is required")
+			return
+		}
+		rawToken := strings.TrimPrefix(authorization, "Bearer ")
+		if tokenPrefix != "" {
+			if !strings.HasPrefix(rawToken, tokenPrefix) {
+				writeError(resp, http.StatusUnauthorized, errCodeInvalidToken, "token was not issued by this server")
+				return
+			}
+			rawToken = strings.TrimPrefix(rawToken, tokenPrefix)
+		}
+
+		accessToken, err := verifier.Verify(rawToken)
+		if err != nil {
+			glog.Errorf("[%s] Token is invalid: %v", requestID(resp, req), err)
+			code := errCodeInvalidToken
+			if errors.Is(err, token.ErrTokenExpired) {
+				code = errCodeTokenExpired
+			}
+			writeError(resp, http.StatusUnauthorized, code, err.Error())
+			return
+		}
+
+		// Refresh tokens are only good for getting a new access token.
+		if err := token.RequireType(accessToken, token.TypeAccess); err != nil {
+			glog.Errorf("[%s] Token is invalid: %v", requestID(resp, req), err)
+			writeError(resp, http.StatusUnauthorized, errCodeWrongTokenType, "only access tokens can be used to authenticate")
+			return
+		}
+
+		if err := token.CheckCertBinding(accessToken, peerCertificates(req)); err != nil {
+			glog.Errorf("[%s] Token is invalid: %v", requestID(resp, req), err)
+			writeError(resp, http.StatusUnauthorized, errCodeCertMismatch, "token is bound to another client certificate")
+			return
+		}
+
+		next.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), authTokenKey{}, accessToken)))
+	})
+}
+
+// peerCertificates returns the client certificates of the connection req
+// came over, if it is TLS.
+func peerCertificates(req *http.Request) []*x509.Certificate {
+	if req.TLS == nil {
+		return nil
+	}
+	return req.TLS.PeerCertificates
+}
+
+// TokenFromContext returns the access token verified by
+// RequireAccessToken for the request whose context is ctx.
+func TokenFromContext(ctx context.Context) (*token.AuthToken, bool) {
+	accessToken, ok := ctx.Value(authTokenKey{}).(*token.AuthToken)
+	return accessToken, ok
+}
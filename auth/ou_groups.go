@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"strings"
+
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+// OUGroupsAll, as an entry of LDAPTokenIssuer.OUGroups, makes every
+// organizational unit of the user's DN a group.
+const OUGroupsAll = "*"
+
+// ouGroups returns the organizational units of dn that are listed in
+// ous, outermost first and lower cased like the groups from memberOf.
+// For uid=alice,ou=eng,ou=nyc,dc=example,dc=com that is nyc and eng.
+func ouGroups(dn string, ous []string) []string {
+	parsed, err := goldap.ParseDN(dn)
+	if err != nil {
+		return nil
+	}
+
+	allowed := make(map[string]bool, len(ous))
+	for _, ou := range ous {
+		allowed[strings.ToLower(ou)] = true
+	}
+
+	var groups []string
+	for i := len(parsed.RDNs) - 1; i >= 0; i-- {
+		for _, attr := range parsed.RDNs[i].Attributes {
+			if !strings.EqualFold(attr.Type, "ou") {
+				continue
+			}
+			ou := strings.ToLower(attr.Value)
+			if allowed[OUGroupsAll] || allowed[ou] {
+				groups = appendUniqueGroups(groups, []string{ou})
+			}
+		}
+	}
+	return groups
+}
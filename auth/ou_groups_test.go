@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+func TestOUGroups(t *testing.T) {
+	cases := []struct {
+		name     string
+		dn       string
+		ous      []string
+		expected []string
+	}{
+		{
+			name:     "nested OUs",
+			dn:       "uid=alice,ou=eng,ou=nyc,dc=example,dc=com",
+			ous:      []string{OUGroupsAll},
+			expected: []string{"nyc", "eng"},
+		},
+		{
+			name:     "only listed OUs",
+			dn:       "uid=alice,ou=eng,ou=nyc,ou=people,dc=example,dc=com",
+			ous:      []string{"eng", "NYC"},
+			expected: []string{"nyc", "eng"},
+		},
+		{
+			name:     "upper case attribute type",
+			dn:       "CN=Alice,OU=Platform,OU=Engineering,DC=example,DC=com",
+			ous:      []string{OUGroupsAll},
+			expected: []string{"engineering", "platform"},
+		},
+		{
+			name:     "escaped value",
+			dn:       `uid=bob,ou=R\2CD,dc=example,dc=com`,
+			ous:      []string{OUGroupsAll},
+			expected: []string{"r,d"},
+		},
+		{
+			name:     "repeated OU",
+			dn:       "uid=carol,ou=ops,ou=eu,ou=ops,dc=example,dc=com",
+			ous:      []string{OUGroupsAll},
+			expected: []string{"ops", "eu"},
+		},
+		{
+			name: "no OUs",
+			dn:   "uid=dave,dc=example,dc=com",
+			ous:  []string{OUGroupsAll},
+		},
+		{
+			name: "unparseable DN",
+			dn:   "not a dn",
+			ous:  []string{OUGroupsAll},
+		},
+	}
+
+	for _, c := range cases {
+		if got := ouGroups(c.dn, c.ous); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: Expected groups %v, got %v", c.name, c.expected, got)
+		}
+	}
+}
+
+func TestOUGroupsInToken(t *testing.T) {
+	lti := LDAPTokenIssuer{OUGroups: []string{"eng", "nyc"}}
+	e := goldap.NewEntry("uid=alice,ou=eng,ou=nyc,ou=people,dc=example,dc=com", map[string][]string{
+		"memberOf": {"cn=developers,dc=example,dc=com", "cn=eng,dc=example,dc=com"},
+	})
+
+	tok := lti.createToken(e)
+	expected := []string{"developers", "eng", "nyc"}
+	if !reflect.DeepEqual(tok.Groups, expected) {
+		t.Errorf("Expected groups %v, got %v", expected, tok.Groups)
+	}
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/proofpoint/kubernetes-ldap/ldap"
+)
+
+var (
+	passwordChangeRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_password_change_requests",
+			Help: "Total number of requests to change a password.",
+		},
+	)
+	failedPasswordChanges = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_failed_password_changes",
+			Help: "Total number of requests to change a password which failed or were rejected.",
+		},
+	)
+	successfulPasswordChanges = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_successful_password_changes",
+			Help: "Total number of requests where a password was changed.",
+		},
+	)
+)
+
+//RegisterPasswordChangeMetrics registers the metrics for password changes
+func RegisterPasswordChangeMetrics() {
+	prometheus.MustRegister(passwordChangeRequests)
+	prometheus.MustRegister(failedPasswordChanges)
+	prometheus.MustRegister(successfulPasswordChanges)
+}
+
+// passwordChangeRequest is the body of a password change request. The
+// current credentials are sent with basic auth.
+type passwordChangeRequest struct {
+	NewPassword string `json:"newPassword"`
+}
+
+// PasswordChangeHandler lets users change their directory password. The
+// current password is verified with a bind before the change is made.
+type PasswordChangeHandler struct {
+	PasswordChanger ldap.PasswordChanger
+	// UnavailableRetryAfter is sent as the Retry-After of the 503
+	// returned when the directory is unavailable or read-only. Zero
+	// sends none.
+	UnavailableRetryAfter time.Duration
+	// UsernameDomains are stripped or replaced from usernames as by
+	// LDAPTokenIssuer.
+	UsernameDomains []UsernameDomain
+}
+
+func (pc *PasswordChangeHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	passwordChangeRequests.Inc()
+	reqID := requestID(resp, req)
+	if req.Method != http.MethodPost {
+		writeError(resp, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "password changes must be POSTed")
+		return
+	}
+
+	user, oldPassword, ok := req.BasicAuth()
+	if !ok || oldPassword == "" {
+		failedPasswordChanges.Inc()
+		resp.Header().Add("WWW-Authenticate", `Basic realm="kubernetes ldap"`)
+		writeError(resp, http.StatusUnauthorized, errCodeMissingCredentials, "username and current password are required")
+		return
+	}
+	user = mapUsernameDomain(user, pc.UsernameDomains)
+
+	body := &passwordChangeRequest{}
+	if err := json.NewDecoder(req.Body).Decode(body); err != nil || body.NewPassword == "" {
+		failedPasswordChanges.Inc()
+		writeError(resp, http.StatusBadRequest, errCodeInvalidRequest, "request body must be JSON with a non-empty newPassword")
+		return
+	}
+	defer req.Body.Close()
+
+	// Errors from the directory never contain the passwords, so they
+	// are safe to log.
+	err := pc.PasswordChanger.ChangePassword(user, oldPassword, body.NewPassword)
+	if err != nil {
+		failedPasswordChanges.Inc()
+		glog.Errorf("[%s] Error changing password for user %q: %v", reqID, user, err)
+
+		var unavailable *ldap.UnavailableError
+		var authErr *ldap.AuthenticationError
+		var dirErr *ldap.DirectoryError
+		var policyErr *ldap.PasswordPolicyError
+		if errors.Is(err, ldap.ErrReadOnly) || errors.As(err, &unavailable) || errors.As(err, &dirErr) {
+			setRetryAfter(resp, pc.UnavailableRetryAfter)
+		}
+		switch {
+		case errors.Is(err, ldap.ErrReadOnly):
+			writeError(resp, http.StatusServiceUnavailable, errCodeBackendUnavailable, "the directory is read-only, passwords can't be changed right now")
+		case errors.As(err, &unavailable):
+			writeError(resp, http.StatusServiceUnavailable, errCodeBackendUnavailable, "the directory is unavailable, try again later")
+		case errors.As(err, &dirErr):
+			writeError(resp, http.StatusServiceUnavailable, errCodeBackendError, "the directory failed to verify the current password, try again later")
+		case errors.As(err, &authErr):
+			writeError(resp, http.StatusUnauthorized, errCodeInvalidCredentials, "invalid username or password")
+		case errors.As(err, &policyErr):
+			writeError(resp, http.StatusBadRequest, errCodePasswordRejected, policyErr.Message)
+		default:
+			writeError(resp, http.StatusInternalServerError, errCodeInternal, "error changing password")
+		}
+		return
+	}
+
+	successfulPasswordChanges.Inc()
+	glog.Infof("[%s] Changed password for user %q", reqID, user)
+	resp.WriteHeader(http.StatusNoContent)
+}
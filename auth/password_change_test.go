@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/proofpoint/kubernetes-ldap/ldap"
+)
+
+type dummyPasswordChanger struct {
+	err error
+	// changed records the last change as user/old/new.
+	changed []string
+}
+
+func (d *dummyPasswordChanger) ChangePassword(username, oldPassword, newPassword string) error {
+	d.changed = []string{username, oldPassword, newPassword}
+	return d.err
+}
+
+func TestPasswordChangeHandler(t *testing.T) {
+	cases := []struct {
+		name              string
+		method            string
+		oldPassword       string
+		body              string
+		changeErr         error
+		expectedCode      int
+		expectedErrorCode string
+	}{
+		{
+			name:         "successful change",
+			method:       "POST",
+			oldPassword:  "old-password",
+			body:         `{"newPassword": "new-password"}`,
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:              "wrong old password",
+			method:            "POST",
+			oldPassword:       "wrong-password",
+			body:              `{"newPassword": "new-password"}`,
+			changeErr:         &ldap.AuthenticationError{Err: errors.New("invalid credentials")},
+			expectedCode:      http.StatusUnauthorized,
+			expectedErrorCode: errCodeInvalidCredentials,
+		},
+		{
+			name:              "rejected by password policy",
+			method:            "POST",
+			oldPassword:       "old-password",
+			body:              `{"newPassword": "short"}`,
+			changeErr:         &ldap.PasswordPolicyError{Message: "Password fails quality checking policy"},
+			expectedCode:      http.StatusBadRequest,
+			expectedErrorCode: errCodePasswordRejected,
+		},
+		{
+			name:              "directory failure",
+			method:            "POST",
+			oldPassword:       "old-password",
+			body:              `{"newPassword": "new-password"}`,
+			changeErr:         &ldap.DirectoryError{Err: errors.New("Error searching for user alice: operations error")},
+			expectedCode:      http.StatusServiceUnavailable,
+			expectedErrorCode: errCodeBackendError,
+		},
+		{
+			name:              "directory unavailable",
+			method:            "POST",
+			oldPassword:       "old-password",
+			body:              `{"newPassword": "new-password"}`,
+			changeErr:         &ldap.UnavailableError{Err: errors.New("connection refused")},
+			expectedCode:      http.StatusServiceUnavailable,
+			expectedErrorCode: errCodeBackendUnavailable,
+		},
+		{
+			name:              "directory read-only",
+			method:            "POST",
+			oldPassword:       "old-password",
+			body:              `{"newPassword": "new-password"}`,
+			changeErr:         &ldap.UnavailableError{Err: fmt.Errorf("%w: shadow context", ldap.ErrReadOnly)},
+			expectedCode:      http.StatusServiceUnavailable,
+			expectedErrorCode: errCodeBackendUnavailable,
+		},
+		{
+			name:              "missing new password",
+			method:            "POST",
+			oldPassword:       "old-password",
+			body:              `{}`,
+			expectedCode:      http.StatusBadRequest,
+			expectedErrorCode: errCodeInvalidRequest,
+		},
+		{
+			name:              "missing old password",
+			method:            "POST",
+			body:              `{"newPassword": "new-password"}`,
+			expectedCode:      http.StatusUnauthorized,
+			expectedErrorCode: errCodeMissingCredentials,
+		},
+		{
+			name:              "GET is not allowed",
+			method:            "GET",
+			oldPassword:       "old-password",
+			expectedCode:      http.StatusMethodNotAllowed,
+			expectedErrorCode: errCodeMethodNotAllowed,
+		},
+	}
+
+	for _, c := range cases {
+		changer := &dummyPasswordChanger{err: c.changeErr}
+		pc := &PasswordChangeHandler{PasswordChanger: changer, UnavailableRetryAfter: 1500 * time.Millisecond}
+
+		req, err := http.NewRequest(c.method, "/changePassword", strings.NewReader(c.body))
+		if err != nil {
+			t.Fatalf("%s: Failed to create request: %v", c.name, err)
+		}
+		req.SetBasicAuth("alice", c.oldPassword)
+
+		rec := httptest.NewRecorder()
+		pc.ServeHTTP(rec, req)
+
+		if rec.Code != c.expectedCode {
+			t.Errorf("%s: Expected %d, got %d", c.name, c.expectedCode, rec.Code)
+			continue
+		}
+		if c.expectedErrorCode != "" {
+			assertErrorCode(t, c.name, rec, c.expectedErrorCode)
+		}
+		if retry := rec.Header().Get("Retry-After"); (c.expectedCode == http.StatusServiceUnavailable) != (retry == "2") {
+			t.Errorf("%s: Unexpected Retry-After %q", c.name, retry)
+		}
+		if c.oldPassword != "" && strings.Contains(rec.Body.String(), c.oldPassword) {
+			t.Errorf("%s: Response must not echo the password", c.name)
+		}
+		if c.expectedCode == http.StatusNoContent && strings.Join(changer.changed, "/") != "alice/old-password/new-password" {
+			t.Errorf("%s: Unexpected change %v", c.name, changer.changed)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/proofpoint/kubernetes-ldap/ldap"
+)
+
+// passwordExpiresIn returns how long the user's password is good for,
+// if the directory warned of its expiry when the user bound.
+func passwordExpiresIn(entry *goldap.Entry) (time.Duration, bool) {
+	seconds, err := strconv.ParseInt(entry.GetAttributeValue(ldap.PasswordExpiresInAttribute), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// graceLoginsRemaining returns how many more logins the user has with
+// their expired password, if the bind was one of the grace logins.
+func graceLoginsRemaining(entry *goldap.Entry) (int, bool) {
+	grace, err := strconv.Atoi(entry.GetAttributeValue(ldap.GraceLoginsAttribute))
+	if err != nil {
+		return 0, false
+	}
+	return grace, true
+}
+
+// addPasswordWarnings passes the password policy warnings of the user's
+// bind on as Warning headers, which clients show, and in data, the JSON
+// token response if any.
+func addPasswordWarnings(resp http.ResponseWriter, entry *goldap.Entry, data map[string]interface{}) {
+	if expiresIn, ok := passwordExpiresIn(entry); ok {
+		resp.Header().Add("Warning", fmt.Sprintf(`299 - "your password expires in %v"`, expiresIn))
+		if data != nil {
+			data["passwordExpirationTimestamp"] = nowMillis() + expiresIn.Milliseconds()
+		}
+	}
+	if grace, ok := graceLoginsRemaining(entry); ok {
+		resp.Header().Add("Warning", fmt.Sprintf(`299 - "your password has expired, %d logins left before it must be changed"`, grace))
+		if data != nil {
+			data["graceLoginsRemaining"] = grace
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/proofpoint/kubernetes-ldap/ldap"
+)
+
+func TestPasswordWarnings(t *testing.T) {
+	cases := []struct {
+		name              string
+		attributes        map[string][]string
+		refuseGraceLogins bool
+		code              int
+		warnings          int
+		expiring          bool
+		grace             int
+	}{
+		{name: "no warning", code: http.StatusOK, grace: -1},
+		{name: "expiring", attributes: map[string][]string{ldap.PasswordExpiresInAttribute: {"3600"}}, code: http.StatusOK, warnings: 1, expiring: true, grace: -1},
+		{name: "grace login", attributes: map[string][]string{ldap.GraceLoginsAttribute: {"2"}}, code: http.StatusOK, warnings: 1, grace: 2},
+		{name: "expiring with refused grace logins", attributes: map[string][]string{ldap.PasswordExpiresInAttribute: {"3600"}}, refuseGraceLogins: true, code: http.StatusOK, warnings: 1, expiring: true, grace: -1},
+		{name: "refused grace login", attributes: map[string][]string{ldap.GraceLoginsAttribute: {"0"}}, refuseGraceLogins: true, code: http.StatusForbidden},
+	}
+	for _, c := range cases {
+		attributes := map[string][]string{"uid": {"alice"}}
+		for name, values := range c.attributes {
+			attributes[name] = values
+		}
+		lti := &LDAPTokenIssuer{
+			LDAPAuthenticator: dummyLDAP{entry: goldap.NewEntry("uid=alice,dc=example,dc=com", attributes)},
+			TokenSigner:       dummySigner{},
+			TTL:               time.Hour,
+			UsernameAttribute: "uid",
+			RefuseGraceLogins: c.refuseGraceLogins,
+		}
+
+		for _, accept := range []string{"", "application/json"} {
+			req, _ := http.NewRequest("GET", "/ldapAuth", nil)
+			req.SetBasicAuth("alice", "password")
+			req.Header.Set("Accept", accept)
+			rec := httptest.NewRecorder()
+			before := nowMillis()
+			lti.ServeHTTP(rec, req)
+			if rec.Code != c.code {
+				t.Fatalf("%s: Expected %d, got %d: %s", c.name, c.code, rec.Code, rec.Body.String())
+			}
+			if c.code != http.StatusOK {
+				assertErrorCode(t, c.name, rec, errCodePasswordReset)
+				continue
+			}
+			if warnings := rec.Header().Values("Warning"); len(warnings) != c.warnings {
+				t.Errorf("%s: Expected %d warnings, got %q", c.name, c.warnings, warnings)
+			}
+			if accept == "" {
+				continue
+			}
+
+			var body struct {
+				PasswordExpirationTimestamp int64 `json:"passwordExpirationTimestamp"`
+				GraceLoginsRemaining        *int  `json:"graceLoginsRemaining"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("%s: Failed to decode response: %v", c.name, err)
+			}
+			if expiring := body.PasswordExpirationTimestamp >= before+time.Hour.Milliseconds(); expiring != c.expiring {
+				t.Errorf("%s: Expected the password expiring in an hour %t, got %d", c.name, c.expiring, body.PasswordExpirationTimestamp)
+			}
+			grace := -1
+			if body.GraceLoginsRemaining != nil {
+				grace = *body.GraceLoginsRemaining
+			}
+			if grace != c.grace {
+				t.Errorf("%s: Expected %d grace logins left, got %d", c.name, c.grace, grace)
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// maxRateLimitedUsers is how many users a UserRateLimiter tracks before
+// it forgets those whose allowance has been fully replenished.
+const maxRateLimitedUsers = 10000
+
+// UserRateLimiter limits how fast each user can be issued tokens, with a
+// token bucket per username.
+type UserRateLimiter struct {
+	// interval is the time it takes to earn one more token request.
+	interval time.Duration
+	burst    int
+
+	mu      sync.Mutex
+	buckets map[string]*userBucket
+	// now is overridden by tests.
+	now func() time.Time
+}
+
+type userBucket struct {
+	// full is when the bucket will be back to burst requests. A bucket
+	// allows a request if full is no more than burst-1 intervals away.
+	full time.Time
+}
+
+// NewUserRateLimiter allows each user perMinute token requests a minute,
+// which must be positive, and up to burst at once.
+func NewUserRateLimiter(perMinute, burst int) *UserRateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &UserRateLimiter{
+		interval: time.Minute / time.Duration(perMinute),
+		burst:    burst,
+		buckets:  make(map[string]*userBucket),
+		now:      time.Now,
+	}
+}
+
+// reserve uses up one of user's requests and returns zero if it is
+// allowed now. Otherwise it returns how long user must wait, and uses
+// nothing up. The check and the use happen under one lock, so that
+// concurrent requests can't get past the burst. user is the username
+// as given: a login may be refused before the directory tells what its
+// canonical form is.
+func (l *UserRateLimiter) reserve(user string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[user]
+	if ok {
+		limit := now.Add(time.Duration(l.burst-1) * l.interval)
+		if b.full.After(limit) {
+			return b.full.Sub(limit)
+		}
+	} else {
+		if len(l.buckets) >= maxRateLimitedUsers {
+			l.forgetReplenished(now)
+		}
+		b = &userBucket{}
+		l.buckets[user] = b
+	}
+	if b.full.Before(now) {
+		b.full = now
+	}
+	b.full = b.full.Add(l.interval)
+	return 0
+}
+
+// cancel gives back a request reserved for user, e.g. because the login
+// failed.
+func (l *UserRateLimiter) cancel(user string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if b, ok := l.buckets[user]; ok {
+		b.full = b.full.Add(-l.interval)
+	}
+}
+
+// forgetReplenished drops the buckets that are full again, as they are
+// no different from having no bucket.
+func (l *UserRateLimiter) forgetReplenished(now time.Time) {
+	for user, b := range l.buckets {
+		if !b.full.After(now) {
+			delete(l.buckets, user)
+		}
+	}
+}
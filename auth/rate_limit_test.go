@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestUserRateLimit(t *testing.T) {
+	now := time.Now()
+	limiter := NewUserRateLimiter(6, 2)
+	limiter.now = func() time.Time { return now }
+
+	authenticator := &countingLDAP{entry: &ldap.Entry{}}
+	lti := LDAPTokenIssuer{
+		LDAPAuthenticator: authenticator,
+		TokenSigner:       dummySigner{"signedToken", nil},
+		UserRateLimiter:   limiter,
+	}
+	request := func(user, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/ldapAuth", nil)
+		req.SetBasicAuth(user, password)
+		rec := httptest.NewRecorder()
+		lti.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The burst is allowed, then the user is refused.
+	for i := 0; i < 2; i++ {
+		if rec := request("alice", "password"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: Expected %d, got %d", i, http.StatusOK, rec.Code)
+		}
+	}
+	rec := request("alice", "password")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected %d over the limit, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if retry := rec.Header().Get("Retry-After"); retry != "10" {
+		t.Errorf("Expected Retry-After 10, got %q", retry)
+	}
+	if authenticator.calls != 2 {
+		t.Errorf("Expected refused requests not to reach LDAP, got %d calls", authenticator.calls)
+	}
+
+	// Other users are unaffected.
+	if rec := request("bob", "password"); rec.Code != http.StatusOK {
+		t.Errorf("Expected another user to get a token, got %d", rec.Code)
+	}
+
+	// A request is allowed again once the rate has replenished one.
+	now = now.Add(10 * time.Second)
+	if rec := request("alice", "password"); rec.Code != http.StatusOK {
+		t.Errorf("Expected a request after the interval to be allowed, got %d", rec.Code)
+	}
+	if rec := request("alice", "password"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the next request to be refused, got %d", rec.Code)
+	}
+	// A wait under a second is rounded up rather than sent as 0.
+	now = now.Add(9500 * time.Millisecond)
+	if retry := request("alice", "password").Header().Get("Retry-After"); retry != "1" {
+		t.Errorf("Expected Retry-After 1, got %q", retry)
+	}
+
+	// Failed logins don't use up the user's requests.
+	authenticator.err = errors.New("LDAP Result Code 49")
+	for i := 0; i < 5; i++ {
+		request("carol", "wrong")
+	}
+	authenticator.err = nil
+	if rec := request("carol", "password"); rec.Code != http.StatusOK {
+		t.Errorf("Expected failed logins not to count against the limit, got %d", rec.Code)
+	}
+}
+
+func TestUserRateLimiterForgetsReplenished(t *testing.T) {
+	now := time.Now()
+	limiter := NewUserRateLimiter(60, 1)
+	limiter.now = func() time.Time { return now }
+
+	limiter.reserve("alice")
+	now = now.Add(time.Second)
+	limiter.reserve("bob")
+	limiter.forgetReplenished(now)
+	if _, ok := limiter.buckets["alice"]; ok {
+		t.Errorf("Expected the replenished bucket to be forgotten")
+	}
+	if _, ok := limiter.buckets["bob"]; !ok {
+		t.Errorf("Expected the bucket in use to be kept")
+	}
+}
+
+func TestUserRateLimiterConcurrentRequests(t *testing.T) {
+	limiter := NewUserRateLimiter(1, 3)
+
+	var wg sync.WaitGroup
+	var allowed int64
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if limiter.reserve("alice") == 0 {
+				atomic.AddInt64(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 3 {
+		t.Errorf("Expected the burst of 3 requests to be allowed, got %d", allowed)
+	}
+
+	limiter.cancel("alice")
+	if wait := limiter.reserve("alice"); wait != 0 {
+		t.Errorf("Expected a cancelled request to be given back, got a wait of %v", wait)
+	}
+}
+
+// countingLDAP is a dummyLDAP that counts its calls.
+type countingLDAP struct {
+	entry *ldap.Entry
+	err   error
+	calls int
+}
+
+func (c *countingLDAP) Authenticate(username, password string) (*ldap.Entry, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.entry, nil
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// What an over-age signing key does to readiness.
+const (
+	// KeyAgeWarn reports the key's age but stays ready.
+	KeyAgeWarn = "warn"
+	// KeyAgeFail fails readiness until the key is rotated.
+	KeyAgeFail = "fail"
+)
+
+// signingKeyCreated holds the creation time of the signing key in use.
+var signingKeyCreated atomic.Value
+
+var signingKeyAge = prometheus.NewGaugeFunc(
+	prometheus.GaugeOpts{
+		Name: "kubernetes_ldap_signing_key_age_seconds",
+		Help: "Age of the signing key in use, in seconds.",
+	},
+	func() float64 {
+		created, ok := signingKeyCreated.Load().(time.Time)
+		if !ok {
+			return 0
+		}
+		return time.Since(created).Seconds()
+	},
+)
+
+// RegisterReadinessMetrics registers the metrics reported by /readyz.
+func RegisterReadinessMetrics() {
+	prometheus.MustRegister(signingKeyAge)
+}
+
+// ReadinessHandler serves /readyz. It also reports the age of the signing
+// key, so that keys that are overdue for rotation get noticed before
+// their tokens stop being accepted.
+type ReadinessHandler struct {
+	keyCreated time.Time
+
+	// KeyMaxAge, if set, is the age past which the signing key is
+	// reported as due for rotation.
+	KeyMaxAge time.Duration
+	// KeyAgePolicy is what happens once the key is over KeyMaxAge:
+	// either KeyAgeWarn (the default) or KeyAgeFail.
+	KeyAgePolicy string
+
+	now func() time.Time
+}
+
+// NewReadinessHandler returns a ReadinessHandler for a signing key
+// created at keyCreated, and reports that key's age in the
+// kubernetes_ldap_signing_key_age_seconds metric.
+func NewReadinessHandler(keyCreated time.Time) *ReadinessHandler {
+	signingKeyCreated.Store(keyCreated)
+	return &ReadinessHandler{keyCreated: keyCreated, now: time.Now}
+}
+
+func (rh *ReadinessHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Content-Type", "text/plain")
+
+	age := rh.now().Sub(rh.keyCreated).Round(time.Second)
+	if rh.KeyMaxAge <= 0 || age <= rh.KeyMaxAge {
+		fmt.Fprintln(resp, "ok")
+		return
+	}
+
+	msg := fmt.Sprintf("signing key is %s old, over the maximum age of %s", age, rh.KeyMaxAge)
+	if rh.KeyAgePolicy == KeyAgeFail {
+		resp.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(resp, msg)
+		return
+	}
+	fmt.Fprintf(resp, "ok\nwarning: %s\n", msg)
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadinessKeyAge(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name           string
+		keyAge         time.Duration
+		maxAge         time.Duration
+		policy         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "no maximum age", keyAge: 1000 * time.Hour, expectedStatus: http.StatusOK, expectedBody: "ok\n"},
+		{name: "fresh key", keyAge: time.Hour, maxAge: 720 * time.Hour, policy: KeyAgeFail, expectedStatus: http.StatusOK, expectedBody: "ok\n"},
+		{name: "over-age key warns", keyAge: 800 * time.Hour, maxAge: 720 * time.Hour, policy: KeyAgeWarn, expectedStatus: http.StatusOK, expectedBody: "ok\nwarning: signing key is 800h0m0s old"},
+		{name: "warning is the default", keyAge: 800 * time.Hour, maxAge: 720 * time.Hour, expectedStatus: http.StatusOK, expectedBody: "ok\nwarning: "},
+		{name: "over-age key fails", keyAge: 800 * time.Hour, maxAge: 720 * time.Hour, policy: KeyAgeFail, expectedStatus: http.StatusServiceUnavailable, expectedBody: "signing key is 800h0m0s old, over the maximum age of 720h0m0s"},
+	}
+
+	for _, c := range cases {
+		rh := NewReadinessHandler(now.Add(-c.keyAge))
+		rh.KeyMaxAge = c.maxAge
+		rh.KeyAgePolicy = c.policy
+		rh.now = func() time.Time { return now }
+
+		rec := httptest.NewRecorder()
+		rh.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		if rec.Code != c.expectedStatus {
+			t.Errorf("%s: Expected %d, got %d", c.name, c.expectedStatus, rec.Code)
+		}
+		if body := rec.Body.String(); !strings.HasPrefix(body, c.expectedBody) {
+			t.Errorf("%s: Expected a body starting with %q, got %q", c.name, c.expectedBody, body)
+		}
+	}
+
+}
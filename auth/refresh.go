@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+var (
+	refreshTokenRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_refresh_token_requests",
+			Help: "Total number of requests to exchange a refresh token for a new token.",
+		},
+	)
+	invalidRefreshTokenRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_invalid_refresh_token",
+			Help: "Total number of requests to exchange a refresh token with a missing, invalid or non-refresh token.",
+		},
+	)
+	successfulRefreshes = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_successful_refresh_token_requests",
+			Help: "Total number of requests where a refresh token was exchanged for a new token.",
+		},
+	)
+)
+
+//RegisterRefreshTokenMetrics registers the metrics for token refresh
+func RegisterRefreshTokenMetrics() {
+	prometheus.MustRegister(refreshTokenRequests)
+	prometheus.MustRegister(invalidRefreshTokenRequests)
+	prometheus.MustRegister(successfulRefreshes)
+}
+
+// TokenRefresher exchanges a refresh token, sent as a bearer token, for
+// a new access token carrying the same identity.
+type TokenRefresher struct {
+	TokenVerifier token.Verifier
+	TokenSigner   token.Signer
+	// TTL of the issued access tokens.
+	TTL time.Duration
+	// GroupTTLs overrides TTL for tokens of these groups, as for
+	// LDAPTokenIssuer, but matched against the token's groups.
+	GroupTTLs map[string]time.Duration
+	// TokenPrefix is expected on the refresh token and prepended to the
+	// issued access token, as for LDAPTokenIssuer.
+	TokenPrefix string
+	// IssuedTokens, if set, records the tokens issued, for debugging.
+	IssuedTokens *IssuedTokenLog
+	// MaxGroups and GroupLimitPolicy cap the groups of the issued
+	// tokens, as for LDAPTokenIssuer.
+	MaxGroups        int
+	GroupLimitPolicy string
+}
+
+// newRefreshToken returns a refresh token for the identity in an access
+// token, valid for ttl.
+func newRefreshToken(accessToken *token.AuthToken, ttl time.Duration) *token.AuthToken {
+	refreshToken := *accessToken
+	refreshToken.Type = token.TypeRefresh
+	refreshToken.Expiration = expirationAfter(ttl)
+	refreshToken.ID = newTokenID()
+	return &refreshToken
+}
+
+func (tr *TokenRefresher) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	refreshTokenRequests.Inc()
+	reqID := requestID(resp, req)
+	if req.Method != http.MethodPost {
+		writeError(resp, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "refresh requests must be POSTed")
+		return
+	}
+
+	authorization := req.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		invalidRefreshTokenRequests.Inc()
+		writeError(resp, http.StatusUnauthorized, errCodeMissingCredentials, "a refresh token is required")
+		return
+	}
+	rawToken := strings.TrimPrefix(authorization, "Bearer ")
+	if tr.TokenPrefix != "" {
+		if !strings.HasPrefix(rawToken, tr.TokenPrefix) {
+			invalidRefreshTokenRequests.Inc()
+			writeError(resp, http.StatusUnauthorized, errCodeInvalidToken, "token was not issued by this server")
+			return
+		}
+		rawToken = strings.TrimPrefix(rawToken, tr.TokenPrefix)
+	}
+
+	refreshToken, err := tr.TokenVerifier.Verify(rawToken)
+	if err != nil {
+		invalidRefreshTokenRequests.Inc()
+		glog.Errorf("[%s] Refresh token is invalid: %v", reqID, err)
+		code := errCodeInvalidToken
+		if errors.Is(err, token.ErrTokenExpired) {
+			code = errCodeTokenExpired
+		}
+		writeError(resp, http.StatusUnauthorized, code, err.Error())
+		return
+	}
+
+	if err := token.RequireType(refreshToken, token.TypeRefresh); err != nil {
+		invalidRefreshTokenRequests.Inc()
+		glog.Errorf("[%s] Refresh token is invalid: %v", reqID, err)
+		writeError(resp, http.StatusUnauthorized, errCodeWrongTokenType, "only refresh tokens can be exchanged for a new token")
+		return
+	}
+
+	accessToken := *refreshToken
+	accessToken.Type = token.TypeAccess
+	accessToken.Expiration = expirationAfter(groupTTL(tr.GroupTTLs, refreshToken.Groups, tr.TTL))
+	accessToken.IssuedAt = nowMillis()
+	accessToken.Assertions = make(map[string]string, len(refreshToken.Assertions)+1)
+	for k, v := range refreshToken.Assertions {
+		accessToken.Assertions[k] = v
+	}
+	accessToken.Assertions[token.AuthMethodAssertion] = token.AuthMethodRefresh
+	accessToken.ID = newTokenID()
+	if err := (groupLimit{max: tr.MaxGroups, policy: tr.GroupLimitPolicy}).apply(reqID, &accessToken); err != nil {
+		invalidRefreshTokenRequests.Inc()
+		writeError(resp, http.StatusForbidden, errCodeTooManyGroups, err.Error())
+		return
+	}
+
+	signedToken, err := tr.TokenSigner.Sign(&accessToken)
+	if err != nil {
+		errorSigningToken.Inc()
+		glog.Errorf("[%s] Error signing token: %v", reqID, err)
+		writeError(resp, http.StatusInternalServerError, errCodeInternal, "error signing token")
+		return
+	}
+	tr.IssuedTokens.record(IssuedByRefresh, &accessToken)
+
+	jsondata, err := json.Marshal(map[string]interface{}{
+		"token":               tr.TokenPrefix + signedToken,
+		"expirationTimestamp": accessToken.Expiration,
+	})
+	if err != nil {
+		glog.Errorf("[%s] Error marshalling json %s", reqID, err.Error())
+		writeError(resp, http.StatusInternalServerError, errCodeInternal, "error marshalling response")
+		return
+	}
+
+	successfulRefreshes.Inc()
+	resp.Header().Add("Content-Type", "application/json")
+	resp.Write(jsondata)
+}
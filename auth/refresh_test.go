@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+// issueTokens logs in through the issuer and returns the access and
+// refresh tokens from the JSON response.
+func issueTokens(t *testing.T, lti *LDAPTokenIssuer) (string, string) {
+	req, err := http.NewRequest("GET", "/ldapAuth", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.SetBasicAuth("alice", "password")
+	req.Header.Set("Accept", "application/json")
+
+	rec := httptest.NewRecorder()
+	lti.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected %d from the issuer, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	body := struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refreshToken"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode issuer response: %v", err)
+	}
+	return body.Token, body.RefreshToken
+}
+
+func reviewToken(tw *TokenWebhook, tok string) *httptest.ResponseRecorder {
+	trrJSON, _ := json.Marshal(&TokenReviewRequest{Spec: TokenReviewSpec{Token: tok}})
+	req, _ := http.NewRequest("POST", "/authenticate", bytes.NewReader(trrJSON))
+	rec := httptest.NewRecorder()
+	tw.ServeHTTP(rec, req)
+	return rec
+}
+
+func refreshToken(tr *TokenRefresher, tok string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/refresh", nil)
+	req.Header.Set("Authorization", "Bearer "+tok)
+	rec := httptest.NewRecorder()
+	tr.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTokenTypes(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	entry := ldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{"uid": {"alice"}})
+	lti := &LDAPTokenIssuer{
+		LDAPAuthenticator: dummyLDAP{entry: entry},
+		TokenSigner:       signer,
+		TTL:               time.Hour,
+		UsernameAttribute: "uid",
+		RefreshTTL:        24 * time.Hour,
+		TokenPrefix:       "ldap:",
+	}
+	tw := NewTokenWebhook(verifier)
+	tw.TokenPrefix = "ldap:"
+	tr := &TokenRefresher{
+		TokenVerifier: verifier,
+		TokenSigner:   signer,
+		TTL:           time.Hour,
+		TokenPrefix:   "ldap:",
+	}
+
+	accessToken, refresh := issueTokens(t, lti)
+	if refresh == "" {
+		t.Fatalf("Expected a refresh token in the response")
+	}
+
+	// An untyped token predates token types and counts as an access token.
+	legacy, err := signer.Sign(&token.AuthToken{Username: "alice", Expiration: expirationAfter(time.Hour)})
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	legacy = "ldap:" + legacy
+
+	cases := []struct {
+		name         string
+		token        string
+		webhookCode  int
+		refreshCode  int
+		expectedType string
+	}{
+		{name: "access token", token: accessToken, webhookCode: http.StatusOK, refreshCode: http.StatusUnauthorized},
+		{name: "refresh token", token: refresh, webhookCode: http.StatusUnauthorized, refreshCode: http.StatusOK},
+		{name: "untyped token", token: legacy, webhookCode: http.StatusOK, refreshCode: http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		rec := reviewToken(tw, c.token)
+		if rec.Code != c.webhookCode {
+			t.Errorf("%s: Expected %d from the webhook, got %d", c.name, c.webhookCode, rec.Code)
+		}
+		if c.webhookCode != http.StatusOK {
+			assertErrorCode(t, c.name, rec, errCodeWrongTokenType)
+		}
+
+		rec = refreshToken(tr, c.token)
+		if rec.Code != c.refreshCode {
+			t.Errorf("%s: Expected %d from the refresh endpoint, got %d", c.name, c.refreshCode, rec.Code)
+			continue
+		}
+		if c.refreshCode != http.StatusOK {
+			assertErrorCode(t, c.name, rec, errCodeWrongTokenType)
+			continue
+		}
+
+		// The refreshed token authenticates at the webhook as the same user.
+		body := struct {
+			Token string `json:"token"`
+		}{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: Failed to decode refresh response: %v", c.name, err)
+		}
+		rec = reviewToken(tw, body.Token)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: Expected the refreshed token to authenticate, got %d", c.name, rec.Code)
+			continue
+		}
+		trr := &TokenReviewRequest{}
+		json.Unmarshal(rec.Body.Bytes(), trr)
+		if trr.Status.User.Username != "alice" {
+			t.Errorf("%s: Expected refreshed token for alice, got %q", c.name, trr.Status.User.Username)
+		}
+	}
+}
+
+func TestAuthMethod(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	entry := ldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{"uid": {"alice"}})
+	lti := &LDAPTokenIssuer{
+		LDAPAuthenticator: dummyLDAP{entry: entry},
+		TokenSigner:       signer,
+		TTL:               time.Hour,
+		UsernameAttribute: "uid",
+		RefreshTTL:        24 * time.Hour,
+	}
+	tr := &TokenRefresher{TokenVerifier: verifier, TokenSigner: signer, TTL: time.Hour}
+	tw := NewTokenWebhook(verifier)
+	tw.AuthMethodExtraKey = "kubernetes-ldap/amr"
+
+	accessToken, refresh := issueTokens(t, lti)
+	rec := refreshToken(tr, refresh)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected %d from the refresh endpoint, got %d", http.StatusOK, rec.Code)
+	}
+	body := struct {
+		Token string `json:"token"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode refresh response: %v", err)
+	}
+
+	cases := []struct {
+		name           string
+		token          string
+		expectedMethod string
+	}{
+		{name: "issued after LDAP bind", token: accessToken, expectedMethod: token.AuthMethodLDAPBind},
+		{name: "issued by refresh", token: body.Token, expectedMethod: token.AuthMethodRefresh},
+	}
+
+	for _, c := range cases {
+		tok, err := verifier.Verify(c.token)
+		if err != nil {
+			t.Fatalf("%s: Failed to verify token: %v", c.name, err)
+		}
+		if method := tok.Assertions[token.AuthMethodAssertion]; method != c.expectedMethod {
+			t.Errorf("%s: Expected auth method %q, got %q", c.name, c.expectedMethod, method)
+		}
+
+		rec := reviewToken(tw, c.token)
+		trr := &TokenReviewRequest{}
+		json.Unmarshal(rec.Body.Bytes(), trr)
+		if extra := trr.Status.User.Extra["kubernetes-ldap/amr"]; len(extra) != 1 || extra[0] != c.expectedMethod {
+			t.Errorf("%s: Expected auth method %q in the user extra, got %v", c.name, c.expectedMethod, trr.Status.User.Extra)
+		}
+	}
+
+	// The extra is only sent when configured.
+	rec = reviewToken(NewTokenWebhook(verifier), accessToken)
+	trr := &TokenReviewRequest{}
+	json.Unmarshal(rec.Body.Bytes(), trr)
+	if trr.Status.User.Extra != nil {
+		t.Errorf("Expected no user extra by default, got %v", trr.Status.User.Extra)
+	}
+}
+
+func TestTokenRefresherRejectsMissingToken(t *testing.T) {
+	tr := &TokenRefresher{TokenVerifier: &dummyVerifier{}, TokenSigner: dummySigner{"signedToken", nil}}
+
+	req, _ := http.NewRequest("POST", "/refresh", nil)
+	rec := httptest.NewRecorder()
+	tr.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/refresh", nil)
+	rec = httptest.NewRecorder()
+	tr.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+func assertErrorCode(t *testing.T, name string, rec *httptest.ResponseRecorder, code string) {
+	body := errorResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Errorf("%s: Failed to decode error response: %v", name, err)
+		return
+	}
+	if body.Error.Code != code {
+		t.Errorf("%s: Expected error code %q, got %q", name, code, body.Error.Code)
+	}
+}
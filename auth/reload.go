@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"net/http"
+	"sync"
+)
+
+// ReloadableHandler serves requests with a handler that can be replaced
+// while the server is running, e.g. after a configuration reload.
+// Requests already in flight finish on the handler they started with.
+type ReloadableHandler struct {
+	mu      sync.RWMutex
+	handler http.Handler
+}
+
+// NewReloadableHandler returns a ReloadableHandler serving handler.
+func NewReloadableHandler(handler http.Handler) *ReloadableHandler {
+	return &ReloadableHandler{handler: handler}
+}
+
+// Swap replaces the handler for subsequent requests.
+func (rh *ReloadableHandler) Swap(handler http.Handler) {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	rh.handler = handler
+}
+
+func (rh *ReloadableHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	rh.mu.RLock()
+	handler := rh.handler
+	rh.mu.RUnlock()
+	handler.ServeHTTP(resp, req)
+}
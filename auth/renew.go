@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+var (
+	tokenRenewalRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_token_renewal_requests",
+			Help: "Total number of requests to renew an access token.",
+		},
+	)
+	refusedTokenRenewals = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_refused_token_renewals",
+			Help: "Total number of requests to renew a missing, invalid or non-access token, or one past the renewal window.",
+		},
+	)
+	successfulTokenRenewals = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_successful_token_renewals",
+			Help: "Total number of requests where an access token was renewed.",
+		},
+	)
+)
+
+// RegisterTokenRenewalMetrics registers the metrics for token renewal.
+func RegisterTokenRenewalMetrics() {
+	prometheus.MustRegister(tokenRenewalRequests)
+	prometheus.MustRegister(refusedTokenRenewals)
+	prometheus.MustRegister(successfulTokenRenewals)
+}
+
+// TokenRenewer exchanges a still valid access token, sent as a bearer
+// token, for a new one with a later expiration, without going back to
+// LDAP. Tokens are only renewed within RenewalWindow of their IssuedAt,
+// which renewal keeps, so renewing can't extend a session indefinitely:
+// after the window, users must log in again.
+type TokenRenewer struct {
+	TokenVerifier token.Verifier
+	TokenSigner   token.Signer
+	// TTL of the renewed access tokens.
+	TTL time.Duration
+	// CertBoundTTL, if set, caps the TTL of tokens bound to a client
+	// certificate, as for LDAPTokenIssuer. Bound tokens are only renewed
+	// over a connection authenticated with their certificate.
+	CertBoundTTL time.Duration
+	// GroupTTLs overrides TTL for tokens of these groups, as for
+	// LDAPTokenIssuer, but matched against the token's groups.
+	GroupTTLs map[string]time.Duration
+	// RenewalWindow is how long after a token was issued it can still
+	// be renewed.
+	RenewalWindow time.Duration
+	// TokenPrefix is expected on the access token and prepended to the
+	// renewed one, as for LDAPTokenIssuer.
+	TokenPrefix string
+	// IssuedTokens, if set, records the tokens issued, for debugging.
+	IssuedTokens *IssuedTokenLog
+	// MaxGroups and GroupLimitPolicy cap the groups of the issued
+	// tokens, as for LDAPTokenIssuer.
+	MaxGroups        int
+	GroupLimitPolicy string
+
+	// now is overridden by tests.
+	now func() time.Time
+}
+
+func (tr *TokenRenewer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	tokenRenewalRequests.Inc()
+	reqID := requestID(resp, req)
+	if req.Method != http.MethodPost {
+		writeError(resp, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "renewal requests must be POSTed")
+		return
+	}
+
+	authorization := req.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		refusedTokenRenewals.Inc()
+		writeError(resp, http.StatusUnauthorized, errCodeMissingCredentials, "an access token is required")
+		return
+	}
+	rawToken := strings.TrimPrefix(authorization, "Bearer ")
+	if tr.TokenPrefix != "" {
+		if !strings.HasPrefix(rawToken, tr.TokenPrefix) {
+			refusedTokenRenewals.Inc()
+			writeError(resp, http.StatusUnauthorized, errCodeInvalidToken, "token was not issued by this server")
+			return
+		}
+		rawToken = strings.TrimPrefix(rawToken, tr.TokenPrefix)
+	}
+
+	current, err := tr.TokenVerifier.Verify(rawToken)
+	if err != nil {
+		refusedTokenRenewals.Inc()
+		glog.Errorf("[%s] Token to renew is invalid: %v", reqID, err)
+		code := errCodeInvalidToken
+		if errors.Is(err, token.ErrTokenExpired) {
+			code = errCodeTokenExpired
+		}
+		writeError(resp, http.StatusUnauthorized, code, err.Error())
+		return
+	}
+
+	if err := token.RequireType(current, token.TypeAccess); err != nil {
+		refusedTokenRenewals.Inc()
+		glog.Errorf("[%s] Token to renew is invalid: %v", reqID, err)
+		writeError(resp, http.StatusUnauthorized, errCodeWrongTokenType, "only access tokens can be renewed")
+		return
+	}
+	if current.Assertions[token.AuthMethodAssertion] == token.AuthMethodOIDC {
+		refusedTokenRenewals.Inc()
+		glog.Errorf("[%s] Not renewing the OIDC ID token of user %q", reqID, current.Username)
+		writeError(resp, http.StatusUnauthorized, errCodeWrongTokenType, "only tokens issued by this server can be renewed")
+		return
+	}
+
+	if err := token.CheckCertBinding(current, peerCertificates(req)); err != nil {
+		refusedTokenRenewals.Inc()
+		glog.Errorf("[%s] Token to renew is invalid: %v", reqID, err)
+		writeError(resp, http.StatusUnauthorized, errCodeCertMismatch, "token is bound to another client certificate")
+		return
+	}
+
+	now := time.Now()
+	if tr.now != nil {
+		now = tr.now()
+	}
+	issuedAt := millisToTime(current.IssuedAt)
+	if current.IssuedAt == 0 || now.Sub(issuedAt) > tr.RenewalWindow {
+		refusedTokenRenewals.Inc()
+		glog.Infof("[%s] Not renewing the token of user %q issued at %s, past the renewal window of %s", reqID, current.Username, issuedAt, tr.RenewalWindow)
+		writeError(resp, http.StatusUnauthorized, errCodeTokenExpired, "the token can no longer be renewed, log in again")
+		return
+	}
+
+	// The identity, assertions, binding and IssuedAt are kept as they
+	// are.
+	renewed := *current
+	ttl := groupTTL(tr.GroupTTLs, current.Groups, tr.TTL)
+	if current.Confirmation != nil && tr.CertBoundTTL > 0 && tr.CertBoundTTL < ttl {
+		ttl = tr.CertBoundTTL
+	}
+	renewed.Expiration = now.Add(ttl).UnixNano() / int64(time.Millisecond)
+	renewed.ID = newTokenID()
+	if err := (groupLimit{max: tr.MaxGroups, policy: tr.GroupLimitPolicy}).apply(reqID, &renewed); err != nil {
+		refusedTokenRenewals.Inc()
+		writeError(resp, http.StatusForbidden, errCodeTooManyGroups, err.Error())
+		return
+	}
+
+	signedToken, err := tr.TokenSigner.Sign(&renewed)
+	if err != nil {
+		errorSigningToken.Inc()
+		glog.Errorf("[%s] Error signing token: %v", reqID, err)
+		writeError(resp, http.StatusInternalServerError, errCodeInternal, "error signing token")
+		return
+	}
+	tr.IssuedTokens.record(IssuedByRenewal, &renewed)
+
+	jsondata, err := json.Marshal(map[string]interface{}{
+		"token":               tr.TokenPrefix + signedToken,
+		"expirationTimestamp": renewed.Expiration,
+	})
+	if err != nil {
+		glog.Errorf("[%s] Error marshalling json %s", reqID, err.Error())
+		writeError(resp, http.StatusInternalServerError, errCodeInternal, "error marshalling response")
+		return
+	}
+
+	successfulTokenRenewals.Inc()
+	resp.Header().Add("Content-Type", "application/json")
+	resp.Write(jsondata)
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+func renewToken(tr *TokenRenewer, tok string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/renew", nil)
+	req.Header.Set("Authorization", "Bearer "+tok)
+	rec := httptest.NewRecorder()
+	tr.ServeHTTP(rec, req)
+	return rec
+}
+
+// issuedAgo returns an access token for alice issued age ago and expiring
+// in ttl.
+func issuedAgo(age, ttl time.Duration) *token.AuthToken {
+	return &token.AuthToken{
+		Username:   "alice",
+		Groups:     []string{"admins", "developers"},
+		Assertions: map[string]string{"ldapServer": "ldap.example.com", token.AuthMethodAssertion: token.AuthMethodLDAPBind},
+		IssuedAt:   time.Now().Add(-age).UnixNano() / int64(time.Millisecond),
+		Expiration: expirationAfter(ttl),
+		ID:         "original",
+	}
+}
+
+func TestTokenRenewal(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	tr := &TokenRenewer{
+		TokenVerifier: verifier,
+		TokenSigner:   signer,
+		TTL:           time.Hour,
+		RenewalWindow: 8 * time.Hour,
+		TokenPrefix:   "ldap:",
+	}
+	sign := func(tok *token.AuthToken) string {
+		signed, err := signer.Sign(tok)
+		if err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+		return "ldap:" + signed
+	}
+
+	// Within the window, a token about to expire is renewed as is.
+	original := issuedAgo(7*time.Hour, time.Minute)
+	rec := renewToken(tr, sign(original))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	body := struct {
+		Token               string `json:"token"`
+		ExpirationTimestamp int64  `json:"expirationTimestamp"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	renewed, err := verifier.Verify(body.Token[len("ldap:"):])
+	if err != nil {
+		t.Fatalf("Expected the renewed token to verify, got %v", err)
+	}
+	if renewed.Username != "alice" || !reflect.DeepEqual(renewed.Groups, original.Groups) || !reflect.DeepEqual(renewed.Assertions, original.Assertions) {
+		t.Errorf("Expected the identity to be kept, got %+v", renewed)
+	}
+	if renewed.IssuedAt != original.IssuedAt {
+		t.Errorf("Expected IssuedAt to be kept, got %d instead of %d", renewed.IssuedAt, original.IssuedAt)
+	}
+	if renewed.Expiration <= original.Expiration || renewed.Expiration != body.ExpirationTimestamp {
+		t.Errorf("Expected a later expiration, got %d after %d", renewed.Expiration, original.Expiration)
+	}
+	if renewed.ID == "" || renewed.ID == original.ID {
+		t.Errorf("Expected a new token ID, got %q", renewed.ID)
+	}
+
+	// The renewed token can be renewed until the original login's window
+	// closes.
+	tr.now = func() time.Time { return time.Now().Add(59 * time.Minute) }
+	if rec := renewToken(tr, body.Token); rec.Code != http.StatusOK {
+		t.Errorf("Expected the renewed token to be renewable in the window, got %d: %s", rec.Code, rec.Body.String())
+	}
+	tr.now = func() time.Time { return time.Now().Add(time.Hour + time.Minute) }
+	if rec := renewToken(tr, body.Token); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the renewed token not to be renewable past the window, got %d", rec.Code)
+	} else {
+		assertErrorCode(t, "past the window", rec, errCodeTokenExpired)
+	}
+	tr.now = nil
+
+	cases := []struct {
+		name         string
+		token        string
+		expectedCode string
+	}{
+		{name: "out of the window", token: sign(issuedAgo(9*time.Hour, 2*time.Hour)), expectedCode: errCodeTokenExpired},
+		{name: "expired", token: sign(issuedAgo(2*time.Hour, -time.Minute)), expectedCode: errCodeTokenExpired},
+		{name: "refresh token", token: sign(newRefreshToken(issuedAgo(time.Hour, time.Hour), time.Hour)), expectedCode: errCodeWrongTokenType},
+		{name: "no issue time", token: sign(&token.AuthToken{Username: "alice", Expiration: expirationAfter(time.Hour)}), expectedCode: errCodeTokenExpired},
+		{name: "missing prefix", token: sign(issuedAgo(time.Hour, time.Hour))[len("ldap:"):], expectedCode: errCodeInvalidToken},
+	}
+	for _, c := range cases {
+		rec := renewToken(tr, c.token)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: Expected %d, got %d", c.name, http.StatusUnauthorized, rec.Code)
+			continue
+		}
+		assertErrorCode(t, c.name, rec, c.expectedCode)
+	}
+
+	req, _ := http.NewRequest("GET", "/renew", nil)
+	rec = httptest.NewRecorder()
+	tr.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected %d for GET, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+func TestCertBoundTokenRenewal(t *testing.T) {
+	ca := newTestCA(t, "clients")
+	alice := ca.issue(t, "alice").Leaf
+	mallory := ca.issue(t, "mallory").Leaf
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	tr := &TokenRenewer{
+		TokenVerifier: verifier,
+		TokenSigner:   signer,
+		TTL:           24 * time.Hour,
+		CertBoundTTL:  10 * time.Minute,
+		RenewalWindow: 8 * time.Hour,
+	}
+	bound := issuedAgo(time.Hour, time.Minute)
+	bound.Confirmation = &token.Confirmation{X5tS256: token.CertThumbprint(alice)}
+	signed, err := signer.Sign(bound)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	renew := func(cert *x509.Certificate) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/renew", nil)
+		req.Header.Set("Authorization", "Bearer "+signed)
+		req.TLS = &tls.ConnectionState{}
+		if cert != nil {
+			req.TLS.PeerCertificates = []*x509.Certificate{cert}
+		}
+		rec := httptest.NewRecorder()
+		tr.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The token can't be renewed without the certificate it is bound to.
+	for name, cert := range map[string]*x509.Certificate{"no certificate": nil, "another certificate": mallory} {
+		rec := renew(cert)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: Expected %d, got %d: %s", name, http.StatusUnauthorized, rec.Code, rec.Body.String())
+		}
+		assertErrorCode(t, name, rec, errCodeCertMismatch)
+	}
+
+	rec := renew(alice)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode renewal response: %v", err)
+	}
+	renewed, err := verifier.Verify(body.Token)
+	if err != nil {
+		t.Fatalf("Failed to verify renewed token: %v", err)
+	}
+	if renewed.Confirmation == nil || renewed.Confirmation.X5tS256 != token.CertThumbprint(alice) {
+		t.Errorf("Expected the renewed token to stay bound to alice's certificate, got %+v", renewed.Confirmation)
+	}
+	// The renewed token gets the bound TTL, not the longer TTL.
+	if renewed.Expiration > expirationAfter(10*time.Minute) {
+		t.Errorf("Expected the renewed bound token to expire within 10 minutes")
+	}
+}
+
+func TestOIDCTokenRenewalRefused(t *testing.T) {
+	// An ID token as the OIDC verifier returns it: no type, and issued
+	// moments ago.
+	idToken := &token.AuthToken{
+		Username:   "oidc:alice@example.com",
+		Groups:     []string{"oidc:developers"},
+		Assertions: map[string]string{token.AuthMethodAssertion: token.AuthMethodOIDC},
+		IssuedAt:   time.Now().UnixNano() / int64(time.Millisecond),
+		Expiration: expirationAfter(5 * time.Minute),
+	}
+	tr := &TokenRenewer{
+		TokenVerifier: &dummyVerifier{token: idToken},
+		TokenSigner:   dummySigner{"signedToken", nil},
+		TTL:           time.Hour,
+		RenewalWindow: 8 * time.Hour,
+	}
+	rec := renewToken(tr, "eyJ.id.token")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected %d for an OIDC ID token, got %d: %s", http.StatusUnauthorized, rec.Code, rec.Body.String())
+	}
+	assertErrorCode(t, "OIDC ID token", rec, errCodeWrongTokenType)
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+)
+
+// requestID returns the request's X-Request-ID, generating one when it is
+// missing or unusable, and echoes it in the response so that a client
+// reporting a failure can be matched to our logs.
+func requestID(resp http.ResponseWriter, req *http.Request) string {
+	id := req.Header.Get(requestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	resp.Header().Set(requestIDHeader, id)
+	return id
+}
+
+// validRequestID only accepts short, printable IDs, since they end up
+// verbatim in our logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	generated := regexp.MustCompile("^[0-9a-f]{32}$")
+
+	cases := []struct {
+		name       string
+		header     string
+		expectedID string
+	}{
+		{
+			name:       "provided request ID is preserved",
+			header:     "abc-123",
+			expectedID: "abc-123",
+		},
+		{
+			name: "missing request ID is generated",
+		},
+		{
+			name:   "request ID with control characters is replaced",
+			header: "abc\n123",
+		},
+		{
+			name:   "oversized request ID is replaced",
+			header: strings.Repeat("a", maxRequestIDLength+1),
+		},
+	}
+
+	handlers := map[string]http.Handler{
+		"webhook": NewTokenWebhook(&dummyVerifier{}),
+		"issuer":  &LDAPTokenIssuer{LDAPAuthenticator: dummyLDAP{}, TokenSigner: dummySigner{}},
+	}
+
+	for handlerName, handler := range handlers {
+		for _, c := range cases {
+			req, err := http.NewRequest("GET", "", nil)
+			if err != nil {
+				t.Fatalf("%s: %s: Failed to create request: %v", handlerName, c.name, err)
+			}
+			if c.header != "" {
+				req.Header.Set(requestIDHeader, c.header)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			id := rec.Header().Get(requestIDHeader)
+			if c.expectedID != "" && id != c.expectedID {
+				t.Errorf("%s: %s: Expected request ID %q, got %q", handlerName, c.name, c.expectedID, id)
+			}
+			if c.expectedID == "" && !generated.MatchString(id) {
+				t.Errorf("%s: %s: Expected a generated request ID, got %q", handlerName, c.name, id)
+			}
+		}
+	}
+}
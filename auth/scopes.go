@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// grantedScopes returns the scopes GroupScopes grants to members of
+// groups, in the order of the groups, without duplicates.
+func (lti *LDAPTokenIssuer) grantedScopes(groups []string) []string {
+	if len(lti.GroupScopes) == 0 {
+		return nil
+	}
+	byGroup := make(map[string][]string, len(lti.GroupScopes))
+	for group, scopes := range lti.GroupScopes {
+		key := strings.ToLower(group)
+		byGroup[key] = append(byGroup[key], scopes...)
+		// Directory groups are matched without GroupPrefix.
+		if lti.GroupPrefix != "" {
+			key = strings.ToLower(lti.GroupPrefix + group)
+			byGroup[key] = append(byGroup[key], scopes...)
+		}
+	}
+
+	var granted []string
+	seen := make(map[string]struct{})
+	for _, group := range groups {
+		for _, scope := range byGroup[strings.ToLower(group)] {
+			if _, ok := seen[scope]; ok {
+				continue
+			}
+			granted = append(granted, scope)
+			seen[scope] = struct{}{}
+		}
+	}
+	return granted
+}
+
+// tokenScopes returns the scopes of a token for a user in groups. The
+// requested scopes, space separated as in OAuth, narrow the token down
+// and must all be granted to the user. Without a request, the token gets
+// every granted scope.
+func (lti *LDAPTokenIssuer) tokenScopes(groups []string, requested string) ([]string, error) {
+	granted := lti.grantedScopes(groups)
+	fields := strings.Fields(requested)
+	if len(fields) == 0 {
+		return granted, nil
+	}
+
+	var scopes []string
+	for _, scope := range fields {
+		if !containsString(granted, scope) {
+			return nil, fmt.Errorf("scope %q is not granted to the user", scope)
+		}
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+func TestTokenScopes(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	entry := ldap.NewEntry("uid=svc-ci,dc=example,dc=com", map[string][]string{
+		"uid":      {"svc-ci"},
+		"memberOf": {"cn=ci-bots,ou=Groups,dc=example,dc=com", "cn=deployers,ou=Groups,dc=example,dc=com"},
+	})
+	lti := &LDAPTokenIssuer{
+		LDAPAuthenticator: dummyLDAP{entry: entry},
+		TokenSigner:       signer,
+		TTL:               time.Hour,
+		UsernameAttribute: "uid",
+		GroupScopes: map[string][]string{
+			"CI-Bots":   {"read-only"},
+			"deployers": {"deploy", "read-only"},
+			"admins":    {"admin"},
+		},
+	}
+	tw := NewTokenWebhook(verifier)
+	tw.ScopesExtraKey = "kubernetes-ldap/scopes"
+
+	cases := []struct {
+		name           string
+		scope          string
+		expectedCode   int
+		expectedScopes []string
+	}{
+		{name: "all granted scopes", expectedCode: http.StatusOK, expectedScopes: []string{"read-only", "deploy"}},
+		{name: "narrowed down", scope: "read-only", expectedCode: http.StatusOK, expectedScopes: []string{"read-only"}},
+		{name: "several requested", scope: "deploy read-only deploy", expectedCode: http.StatusOK, expectedScopes: []string{"deploy", "read-only"}},
+		{name: "scope of another group", scope: "read-only admin", expectedCode: http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		req, err := http.NewRequest("GET", "/ldapAuth", nil)
+		if err != nil {
+			t.Fatalf("%s: Failed to create request: %v", c.name, err)
+		}
+		if c.scope != "" {
+			q := req.URL.Query()
+			q.Set("scope", c.scope)
+			req.URL.RawQuery = q.Encode()
+		}
+		req.SetBasicAuth("svc-ci", "password")
+
+		rec := httptest.NewRecorder()
+		lti.ServeHTTP(rec, req)
+		if rec.Code != c.expectedCode {
+			t.Errorf("%s: Expected %d, got %d: %s", c.name, c.expectedCode, rec.Code, rec.Body.String())
+			continue
+		}
+		if c.expectedCode != http.StatusOK {
+			errResp := &errorResponse{}
+			json.Unmarshal(rec.Body.Bytes(), errResp)
+			if errResp.Error.Code != errCodeInvalidScope {
+				t.Errorf("%s: Expected error code %q, got %q", c.name, errCodeInvalidScope, errResp.Error.Code)
+			}
+			continue
+		}
+
+		tok, err := verifier.Verify(rec.Body.String())
+		if err != nil {
+			t.Fatalf("%s: Failed to verify token: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(tok.Scopes, c.expectedScopes) {
+			t.Errorf("%s: Expected scopes %v, got %v", c.name, c.expectedScopes, tok.Scopes)
+		}
+		for _, scope := range c.expectedScopes {
+			if err := token.RequireScope(tok, scope); err != nil {
+				t.Errorf("%s: Expected the token to have the %q scope: %v", c.name, scope, err)
+			}
+		}
+		if err := token.RequireScope(tok, "admin"); !errors.Is(err, token.ErrMissingScope) {
+			t.Errorf("%s: Expected ErrMissingScope for the admin scope, got %v", c.name, err)
+		}
+
+		trr := &TokenReviewRequest{}
+		json.Unmarshal(reviewToken(tw, rec.Body.String()).Body.Bytes(), trr)
+		if extra := trr.Status.User.Extra["kubernetes-ldap/scopes"]; !reflect.DeepEqual(extra, c.expectedScopes) {
+			t.Errorf("%s: Expected scopes %v in the user extra, got %v", c.name, c.expectedScopes, trr.Status.User.Extra)
+		}
+	}
+
+	// Without group scopes, tokens are unscoped.
+	lti.GroupScopes = nil
+	accessToken, _ := issueTokens(t, lti)
+	tok, err := verifier.Verify(accessToken)
+	if err != nil {
+		t.Fatalf("Failed to verify token: %v", err)
+	}
+	if len(tok.Scopes) != 0 {
+		t.Errorf("Expected an unscoped token, got %v", tok.Scopes)
+	}
+	if err := token.RequireScope(tok, "read-only"); !errors.Is(err, token.ErrMissingScope) {
+		t.Errorf("Expected ErrMissingScope for an unscoped token, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"testing"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/proofpoint/kubernetes-ldap/ldap"
+)
+
+func TestServerAssertion(t *testing.T) {
+	entry := goldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{
+		"uid":                {"alice"},
+		ldap.ServerAttribute: {"10.0.0.2:636"},
+	})
+	cases := []struct {
+		name            string
+		serverAssertion string
+		entry           *goldap.Entry
+		expected        string
+	}{
+		{name: "disabled", entry: entry},
+		{name: "enabled", serverAssertion: "ldapServerAddress", entry: entry, expected: "10.0.0.2:636"},
+		// e.g. a static user, authenticated without the directory.
+		{name: "no server", serverAssertion: "ldapServerAddress", entry: goldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{"uid": {"alice"}})},
+	}
+
+	for _, c := range cases {
+		lti := LDAPTokenIssuer{LDAPServer: "ldap.example.com", UsernameAttribute: "uid", ServerAssertion: c.serverAssertion}
+		tok := lti.createToken(c.entry)
+
+		server, ok := tok.Assertions["ldapServerAddress"]
+		if c.expected == "" && ok {
+			t.Errorf("%s: Expected no server assertion, got %q", c.name, server)
+		}
+		if server != c.expected {
+			t.Errorf("%s: Expected server assertion %q, got %q", c.name, c.expected, server)
+		}
+		if tok.Assertions["ldapServer"] != "ldap.example.com" {
+			t.Errorf("%s: Expected the ldapServer assertion to stay the configured host, got %q", c.name, tok.Assertions["ldapServer"])
+		}
+	}
+}
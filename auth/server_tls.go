@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// ServerTLSOptions configures the TLS of our own HTTPS listeners.
+type ServerTLSOptions struct {
+	// Certificates, if set, are served as is. Otherwise the certificate
+	// and key are read from CertFile and KeyFile, and reloaded when they
+	// are rotated.
+	Certificates      []tls.Certificate
+	CertFile, KeyFile string
+
+	// ClientCAFile, if set, requires clients to present a certificate
+	// signed by one of its CAs.
+	ClientCAFile string
+	// AllowedClientNames, if set, further restricts clients to those
+	// whose certificate has one of these names as its common name or as
+	// a DNS or URI subject alternative name. It requires ClientCAFile.
+	AllowedClientNames []string
+
+	// MinVersion is the minimum TLS version, e.g. tls.VersionTLS12.
+	MinVersion uint16
+	// CipherSuites restricts the TLS 1.0-1.2 cipher suites. TLS 1.3
+	// suites can't be configured. Empty uses Go's defaults.
+	CipherSuites []uint16
+}
+
+// ServerTLSConfig returns the TLS config for a listener.
+func ServerTLSConfig(opts ServerTLSOptions) (*tls.Config, error) {
+	config := &tls.Config{}
+	if opts.ClientCAFile != "" {
+		var err error
+		config, err = ClientCertTLSConfig(opts.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.AllowedClientNames) > 0 {
+		if opts.ClientCAFile == "" {
+			return nil, errors.New("allowed client names require a client CA file")
+		}
+		config.VerifyPeerCertificate = allowClientNames(opts.AllowedClientNames)
+	}
+	config.MinVersion = opts.MinVersion
+	config.CipherSuites = opts.CipherSuites
+
+	if len(opts.Certificates) > 0 {
+		config.Certificates = opts.Certificates
+		return config, nil
+	}
+	reloader, err := NewCertReloader(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	config.GetCertificate = reloader.GetCertificate
+	return config, nil
+}
+
+// allowClientNames returns a tls.Config.VerifyPeerCertificate hook that
+// fails the handshake unless the verified client certificate has one of
+// the allowed names.
+func allowClientNames(names []string) func([][]byte, [][]*x509.Certificate) error {
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		allowed[name] = true
+	}
+	return func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+			return errors.New("no verified client certificate")
+		}
+		cert := verifiedChains[0][0]
+		candidates := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+		for _, uri := range cert.URIs {
+			candidates = append(candidates, uri.String())
+		}
+		for _, name := range candidates {
+			if allowed[name] {
+				return nil
+			}
+		}
+		return fmt.Errorf("client certificate %q is not allowed", cert.Subject.CommonName)
+	}
+}
+
+// ParseTLSVersion maps a TLS version such as "1.2" to its constant.
+func ParseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q, expected 1.0, 1.1, 1.2 or 1.3", version)
+}
+
+// ParseCipherSuites maps cipher suite names, as in
+// TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, to their IDs. Suites with
+// known security issues are refused.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	secure := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite.ID
+	}
+	insecure := make(map[string]bool)
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = true
+	}
+
+	var ids []uint16
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		id, ok := secure[name]
+		if !ok {
+			if insecure[name] {
+				return nil, fmt.Errorf("cipher suite %s is insecure", name)
+			}
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// CertReloader serves a certificate and key read from files, and reloads
+// them when either file changes, so that rotated certificates are picked
+// up without a restart.
+type CertReloader struct {
+	certFile, keyFile string
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+	// seen is the state of the files when they were last loaded, or
+	// failed to load.
+	seen [2]fileState
+}
+
+// fileState is what is checked to tell that a file changed.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewCertReloader loads the certificate and key from the given files.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	r.seen = r.state()
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate is the tls.Config hook that serves the current
+// certificate. If the files changed but can't be loaded, e.g. because
+// only one of them has been written yet, the previous certificate is
+// served until they change again.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if state := r.state(); state != r.seen {
+		r.seen = state
+		if err := r.reload(); err != nil {
+			glog.Errorf("Error reloading TLS certificate, serving the previous one: %v", err)
+		} else {
+			glog.Infof("Reloaded TLS certificate from %s", r.certFile)
+		}
+	}
+	return r.cert, nil
+}
+
+// state returns the current state of the files. Files that can't be
+// read have the zero state.
+func (r *CertReloader) state() [2]fileState {
+	var state [2]fileState
+	for i, name := range []string{r.certFile, r.keyFile} {
+		state[i] = statFile(name)
+	}
+	return state
+}
+
+// statFile returns the state of the named file, or the zero state if it
+// can't be read.
+func statFile(name string) fileState {
+	info, err := os.Stat(name)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{modTime: info.ModTime(), size: info.Size()}
+}
+
+func (r *CertReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %v", err)
+	}
+	r.cert = &cert
+	return nil
+}
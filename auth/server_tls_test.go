@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startTLSServer serves okHandler with exactly the given TLS config,
+// unlike httptest which adds its own certificate, and returns its
+// address.
+func startTLSServer(t *testing.T, config *tls.Config) string {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	srv := &http.Server{Handler: okHandler, ErrorLog: log.New(ioutil.Discard, "", 0)}
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Close() })
+	return listener.Addr().String()
+}
+
+// servedCertificate returns the common name of the certificate the
+// server presents, or an error if the handshake fails.
+func servedCertificate(addr string, config *tls.Config) (string, error) {
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err := conn.Handshake(); err != nil {
+		return "", err
+	}
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestServerTLSConfigClientCA(t *testing.T) {
+	ca := newTestCA(t, "apiserver-ca")
+	otherCA := newTestCA(t, "other-ca")
+
+	config, err := ServerTLSConfig(ServerTLSOptions{
+		Certificates: []tls.Certificate{ca.issue(t, "server")},
+		ClientCAFile: writeTestFile(t, "ca.pem", ca.pem),
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatalf("creating TLS config: %v", err)
+	}
+	addr := startTLSServer(t, config)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	cases := []struct {
+		name        string
+		clientCerts []tls.Certificate
+		expectErr   bool
+	}{
+		{name: "no client certificate", expectErr: true},
+		{name: "client certificate from another CA", clientCerts: []tls.Certificate{otherCA.issue(t, "kube-apiserver")}, expectErr: true},
+		{name: "client certificate from the trusted CA", clientCerts: []tls.Certificate{ca.issue(t, "kube-apiserver")}},
+	}
+
+	for _, c := range cases {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: c.clientCerts,
+		}}}
+		resp, err := client.Get("https://" + addr)
+		if c.expectErr {
+			if err == nil {
+				resp.Body.Close()
+				t.Errorf("%s: Expected the request to be rejected", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", c.name, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+
+	// Clients limited to older versions are refused.
+	_, err = servedCertificate(addr, &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{ca.issue(t, "kube-apiserver")},
+		MaxVersion:   tls.VersionTLS11,
+	})
+	if err == nil {
+		t.Errorf("Expected a TLS 1.1 client to be refused")
+	}
+}
+
+func TestServerTLSConfigReloadsCertificate(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	dir := filepath.Dir(writeTestFile(t, "placeholder", nil))
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeKeyPair(t, ca.issue(t, "first"), certFile, keyFile)
+
+	config, err := ServerTLSConfig(ServerTLSOptions{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("creating TLS config: %v", err)
+	}
+	addr := startTLSServer(t, config)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientConfig := &tls.Config{RootCAs: roots}
+
+	if name, err := servedCertificate(addr, clientConfig); err != nil || name != "first" {
+		t.Fatalf("Expected the first certificate, got %q, %v", name, err)
+	}
+
+	// Rotate the certificate, as a Secret update would.
+	writeKeyPair(t, ca.issue(t, "second"), certFile, keyFile)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	os.Chtimes(keyFile, later, later)
+	if name, err := servedCertificate(addr, clientConfig); err != nil || name != "second" {
+		t.Errorf("Expected the rotated certificate, got %q, %v", name, err)
+	}
+
+	// A half-written rotation keeps the current certificate.
+	if err := ioutil.WriteFile(keyFile, []byte("partial"), 0600); err != nil {
+		t.Fatalf("writing key: %v", err)
+	}
+	if name, err := servedCertificate(addr, clientConfig); err != nil || name != "second" {
+		t.Errorf("Expected the previous certificate while the key is invalid, got %q, %v", name, err)
+	}
+
+	if _, err := ServerTLSConfig(ServerTLSOptions{CertFile: filepath.Join(dir, "missing"), KeyFile: keyFile}); err == nil {
+		t.Errorf("Expected an error for a missing certificate file")
+	}
+}
+
+func TestParseTLSSettings(t *testing.T) {
+	if v, err := ParseTLSVersion("1.2"); err != nil || v != tls.VersionTLS12 {
+		t.Errorf("Expected TLS 1.2, got %x, %v", v, err)
+	}
+	if _, err := ParseTLSVersion("1.4"); err == nil {
+		t.Errorf("Expected an error for an unknown TLS version")
+	}
+
+	cases := []struct {
+		names     []string
+		expected  []uint16
+		expectErr bool
+	}{
+		{names: nil},
+		{names: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", " TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}, expected: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}},
+		{names: []string{"TLS_RSA_WITH_RC4_128_SHA"}, expectErr: true},
+		{names: []string{"TLS_BOGUS"}, expectErr: true},
+	}
+	for _, c := range cases {
+		ids, err := ParseCipherSuites(c.names)
+		if c.expectErr {
+			if err == nil {
+				t.Errorf("%v: Expected an error", c.names)
+			}
+			continue
+		}
+		if err != nil || len(ids) != len(c.expected) {
+			t.Errorf("%v: Expected %v, got %v, %v", c.names, c.expected, ids, err)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != c.expected[i] {
+				t.Errorf("%v: Expected %v, got %v", c.names, c.expected, ids)
+			}
+		}
+	}
+}
+
+func TestServerTLSConfigAllowedClientNames(t *testing.T) {
+	ca := newTestCA(t, "apiserver-ca")
+	config, err := ServerTLSConfig(ServerTLSOptions{
+		Certificates:       []tls.Certificate{ca.issue(t, "server")},
+		ClientCAFile:       writeTestFile(t, "ca.pem", ca.pem),
+		AllowedClientNames: []string{"kube-apiserver", "apiserver.kube-system.svc"},
+	})
+	if err != nil {
+		t.Fatalf("creating TLS config: %v", err)
+	}
+	addr := startTLSServer(t, config)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	cases := []struct {
+		name       string
+		clientCert tls.Certificate
+		expectErr  bool
+	}{
+		{name: "allowed common name", clientCert: ca.issue(t, "kube-apiserver")},
+		{name: "allowed DNS name", clientCert: ca.issue(t, "apiserver", "apiserver.kube-system.svc")},
+		{name: "other client of the same CA", clientCert: ca.issue(t, "alice", "alice.example.com"), expectErr: true},
+	}
+
+	for _, c := range cases {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: []tls.Certificate{c.clientCert},
+		}}}
+		resp, err := client.Get("https://" + addr)
+		if c.expectErr {
+			if err == nil {
+				resp.Body.Close()
+				t.Errorf("%s: Expected the request to be rejected", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", c.name, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+
+	if _, err := ServerTLSConfig(ServerTLSOptions{AllowedClientNames: []string{"kube-apiserver"}}); err == nil {
+		t.Errorf("Expected an error for allowed client names without a client CA")
+	}
+}
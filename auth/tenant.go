@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// tenantPathPrefix is the path under which each tenant's endpoints are
+// served, e.g. /tenants/team-a/ldapAuth.
+const tenantPathPrefix = "/tenants/"
+
+// TenantRouter dispatches requests to one of several independent sets of
+// endpoints, each with its own directory and signing keys. The tenant is
+// picked by the request's Host header, or else by a /tenants/<name>/ path
+// prefix. Anything else goes to the default handler.
+type TenantRouter struct {
+	defaultHandler http.Handler
+	tenants        map[string]http.Handler
+	hosts          map[string]string
+}
+
+// NewTenantRouter returns a TenantRouter that sends requests for no
+// known tenant to defaultHandler.
+func NewTenantRouter(defaultHandler http.Handler) *TenantRouter {
+	return &TenantRouter{
+		defaultHandler: defaultHandler,
+		tenants:        make(map[string]http.Handler),
+		hosts:          make(map[string]string),
+	}
+}
+
+// AddTenant registers the handler for a tenant. If host is set, requests
+// with that Host header are also routed to the tenant.
+func (tr *TenantRouter) AddTenant(name, host string, handler http.Handler) {
+	tr.tenants[name] = handler
+	if host != "" {
+		tr.hosts[strings.ToLower(host)] = name
+	}
+}
+
+func (tr *TenantRouter) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if name, ok := tr.hosts[strings.ToLower(host)]; ok {
+		tr.tenants[name].ServeHTTP(resp, req)
+		return
+	}
+
+	if strings.HasPrefix(req.URL.Path, tenantPathPrefix) {
+		rest := strings.TrimPrefix(req.URL.Path, tenantPathPrefix)
+		name := rest
+		if i := strings.Index(rest, "/"); i >= 0 {
+			name = rest[:i]
+		}
+		handler, ok := tr.tenants[name]
+		if !ok {
+			writeError(resp, http.StatusNotFound, errCodeUnknownTenant, "unknown tenant")
+			return
+		}
+		http.StripPrefix(tenantPathPrefix+name, handler).ServeHTTP(resp, withPathTenant(req, name))
+		return
+	}
+
+	tr.defaultHandler.ServeHTTP(resp, req)
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+// newTestTenant returns the endpoints of a tenant with its own key and a
+// directory containing a single user.
+func newTestTenant(t *testing.T, username string) http.Handler {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/ldapAuth", &LDAPTokenIssuer{
+		LDAPAuthenticator: dummyLDAP{entry: ldap.NewEntry("uid="+username, map[string][]string{"uid": {username}})},
+		TokenSigner:       signer,
+		TTL:               time.Hour,
+		UsernameAttribute: "uid",
+	})
+	mux.Handle("/authenticate", NewTokenWebhook(verifier))
+	return mux
+}
+
+func TestTenantRouter(t *testing.T) {
+	router := NewTenantRouter(http.NotFoundHandler())
+	router.AddTenant("team-a", "a.auth.example.com", newTestTenant(t, "alice"))
+	router.AddTenant("team-b", "b.auth.example.com", newTestTenant(t, "bob"))
+
+	issue := func(host, path string) string {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Host = host
+		req.SetBasicAuth("user", "password")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected %d issuing a token at %s%s, got %d", http.StatusOK, host, path, rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	review := func(host, path, tok string) (int, string) {
+		trrJSON, _ := json.Marshal(&TokenReviewRequest{Spec: TokenReviewSpec{Token: tok}})
+		req := httptest.NewRequest("POST", path, bytes.NewReader(trrJSON))
+		req.Host = host
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		trr := &TokenReviewRequest{}
+		json.Unmarshal(rec.Body.Bytes(), trr)
+		return rec.Code, trr.Status.User.Username
+	}
+
+	tokenA := issue("kubernetes-ldap", "/tenants/team-a/ldapAuth")
+	tokenB := issue("b.auth.example.com:4000", "/ldapAuth")
+
+	cases := []struct {
+		name             string
+		host             string
+		path             string
+		token            string
+		expectedCode     int
+		expectedUsername string
+	}{
+		{
+			name:             "tenant A token at tenant A by path",
+			host:             "kubernetes-ldap",
+			path:             "/tenants/team-a/authenticate",
+			token:            tokenA,
+			expectedCode:     http.StatusOK,
+			expectedUsername: "alice",
+		},
+		{
+			name:             "tenant A token at tenant A by host",
+			host:             "A.auth.example.com",
+			path:             "/authenticate",
+			token:            tokenA,
+			expectedCode:     http.StatusOK,
+			expectedUsername: "alice",
+		},
+		{
+			name:         "tenant A token at tenant B by path",
+			host:         "kubernetes-ldap",
+			path:         "/tenants/team-b/authenticate",
+			token:        tokenA,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "tenant A token at tenant B by host",
+			host:         "b.auth.example.com",
+			path:         "/authenticate",
+			token:        tokenA,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "tenant B token at tenant A",
+			host:         "kubernetes-ldap",
+			path:         "/tenants/team-a/authenticate",
+			token:        tokenB,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:             "tenant B token at tenant B",
+			host:             "kubernetes-ldap",
+			path:             "/tenants/team-b/authenticate",
+			token:            tokenB,
+			expectedCode:     http.StatusOK,
+			expectedUsername: "bob",
+		},
+		{
+			name:         "unknown tenant",
+			host:         "kubernetes-ldap",
+			path:         "/tenants/team-c/authenticate",
+			token:        tokenA,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "no tenant goes to the default handler",
+			host:         "kubernetes-ldap",
+			path:         "/authenticate",
+			token:        tokenA,
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, c := range cases {
+		code, username := review(c.host, c.path, c.token)
+		if code != c.expectedCode {
+			t.Errorf("%s: Expected %d, got %d", c.name, c.expectedCode, code)
+			continue
+		}
+		if username != c.expectedUsername {
+			t.Errorf("%s: Expected username %q, got %q", c.name, c.expectedUsername, username)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+var (
+	tokenCacheHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_token_cache_hits",
+			Help: "Total number of tokens found verified in the token cache.",
+		},
+	)
+	tokenCacheMisses = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_token_cache_misses",
+			Help: "Total number of tokens not found in the token cache, and verified.",
+		},
+	)
+)
+
+// TokenCache is a token.Verifier remembering the tokens another verifier
+// accepted, so that a token presented again, as the API server does on
+// every request, isn't verified again. Tokens are looked up by a SHA-256
+// of the token, their only identifier before verification, and indexed
+// by their ID (jti), which revocations name them by. Only accepted
+// tokens are cached, each until the earlier of the cache's TTL and the
+// token's own expiration; the least recently used is evicted once the
+// cache is full.
+type TokenCache struct {
+	maxSize int
+	ttl     time.Duration
+
+	mu       sync.Mutex
+	verifier token.Verifier
+	entries  map[[sha256.Size]byte]*list.Element
+	// byID holds the entries of the tokens that have an ID.
+	byID map[string]*list.Element
+	// lru holds *tokenCacheEntry, most recently used first.
+	lru *list.List
+	// now is overridden by tests.
+	now func() time.Time
+}
+
+type tokenCacheEntry struct {
+	key    [sha256.Size]byte
+	id     string
+	token  *token.AuthToken
+	expiry time.Time
+}
+
+// NewTokenCache caches up to maxSize tokens accepted by verifier, for at
+// most ttl.
+func NewTokenCache(verifier token.Verifier, maxSize int, ttl time.Duration) *TokenCache {
+	return &TokenCache{
+		verifier: verifier,
+		maxSize:  maxSize,
+		ttl:      ttl,
+		entries:  map[[sha256.Size]byte]*list.Element{},
+		byID:     map[string]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+// SetVerifier has the cache verify the tokens it doesn't hold with
+// verifier from now on, e.g. after a configuration reload. The tokens
+// already cached are kept until they expire from the cache.
+func (tc *TokenCache) SetVerifier(verifier token.Verifier) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.verifier = verifier
+}
+
+func (tc *TokenCache) clock() time.Time {
+	if tc.now != nil {
+		return tc.now()
+	}
+	return time.Now()
+}
+
+// Verify returns the cached token for s, or verifies it with the
+// wrapped verifier and caches it if accepted. Each call returns its own
+// copy of the token.
+func (tc *TokenCache) Verify(s string) (*token.AuthToken, error) {
+	key := sha256.Sum256([]byte(s))
+	if tok := tc.get(key); tok != nil {
+		tokenCacheHits.Inc()
+		return tok, nil
+	}
+	tokenCacheMisses.Inc()
+
+	tc.mu.Lock()
+	verifier := tc.verifier
+	tc.mu.Unlock()
+	tok, err := verifier.Verify(s)
+	if err != nil {
+		return nil, err
+	}
+	tc.add(key, tok)
+	return cloneToken(tok), nil
+}
+
+func (tc *TokenCache) get(key [sha256.Size]byte) *token.AuthToken {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	elem, ok := tc.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*tokenCacheEntry)
+	if !tc.clock().Before(entry.expiry) {
+		tc.remove(elem)
+		return nil
+	}
+	tc.lru.MoveToFront(elem)
+	return cloneToken(entry.token)
+}
+
+func (tc *TokenCache) add(key [sha256.Size]byte, tok *token.AuthToken) {
+	now := tc.clock()
+	expiry := now.Add(tc.ttl)
+	if tok.Expiration != 0 {
+		if exp := time.Unix(0, tok.Expiration*int64(time.Millisecond)); exp.Before(expiry) {
+			expiry = exp
+		}
+	}
+	if !now.Before(expiry) {
+		// e.g. a token accepted during its grace period.
+		return
+	}
+	cached := cloneToken(tok)
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if elem, ok := tc.entries[key]; ok {
+		tc.remove(elem)
+	}
+	if elem, ok := tc.byID[tok.ID]; ok && tok.ID != "" {
+		tc.remove(elem)
+	}
+	for tc.lru.Len() >= tc.maxSize && tc.lru.Len() > 0 {
+		tc.remove(tc.lru.Back())
+	}
+	elem := tc.lru.PushFront(&tokenCacheEntry{key: key, id: tok.ID, token: cached, expiry: expiry})
+	tc.entries[key] = elem
+	if tok.ID != "" {
+		tc.byID[tok.ID] = elem
+	}
+}
+
+// cloneToken copies tok deeply enough that callers changing its groups
+// or assertions don't change the cached token.
+func cloneToken(tok *token.AuthToken) *token.AuthToken {
+	copied := *tok
+	copied.Groups = append([]string(nil), tok.Groups...)
+	copied.Scopes = append([]string(nil), tok.Scopes...)
+	copied.Audiences = append([]string(nil), tok.Audiences...)
+	if tok.Assertions != nil {
+		copied.Assertions = make(map[string]string, len(tok.Assertions))
+		for k, v := range tok.Assertions {
+			copied.Assertions[k] = v
+		}
+	}
+	return &copied
+}
+
+func (tc *TokenCache) remove(elem *list.Element) {
+	tc.lru.Remove(elem)
+	entry := elem.Value.(*tokenCacheEntry)
+	delete(tc.entries, entry.key)
+	if entry.id != "" {
+		delete(tc.byID, entry.id)
+	}
+}
+
+// Invalidate drops the token with ID id from the cache, so that it is
+// verified again when next presented. Whatever revokes a token must
+// call it.
+func (tc *TokenCache) Invalidate(id string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if elem, ok := tc.byID[id]; ok {
+		tc.remove(elem)
+	}
+}
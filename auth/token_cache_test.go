@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+// countingVerifier accepts the tokens in tokens, counting the calls.
+type countingVerifier struct {
+	tokens map[string]*token.AuthToken
+	calls  int64
+}
+
+func (cv *countingVerifier) Verify(s string) (*token.AuthToken, error) {
+	atomic.AddInt64(&cv.calls, 1)
+	tok, ok := cv.tokens[s]
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	copied := *tok
+	return &copied, nil
+}
+
+func (cv *countingVerifier) count() int64 {
+	return atomic.LoadInt64(&cv.calls)
+}
+
+func newCountingVerifier(names ...string) *countingVerifier {
+	cv := &countingVerifier{tokens: map[string]*token.AuthToken{}}
+	for _, name := range names {
+		cv.tokens[name] = &token.AuthToken{Username: name, Groups: []string{"devs"}, Expiration: expirationAfter(time.Hour), ID: "jti-" + name}
+	}
+	return cv
+}
+
+func TestTokenCacheHit(t *testing.T) {
+	cv := newCountingVerifier("alice")
+	tc := NewTokenCache(cv, 10, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		tok, err := tc.Verify("alice")
+		if err != nil || tok.Username != "alice" {
+			t.Fatalf("Expected alice's token, got %+v, %v", tok, err)
+		}
+		// Callers can't change the cached token.
+		tok.Groups[0] = "admins"
+	}
+	if cv.count() != 1 {
+		t.Errorf("Expected the token to be verified once, got %d", cv.count())
+	}
+	if tok, _ := tc.Verify("alice"); tok.Groups[0] != "devs" {
+		t.Errorf("Expected the cached groups to be unchanged, got %v", tok.Groups)
+	}
+
+	// Rejected tokens aren't cached.
+	for i := 0; i < 2; i++ {
+		if _, err := tc.Verify("mallory"); err == nil {
+			t.Fatal("Expected an invalid token to be rejected")
+		}
+	}
+	if cv.count() != 3 {
+		t.Errorf("Expected rejected tokens to be verified every time, got %d calls", cv.count())
+	}
+}
+
+func TestTokenCacheEviction(t *testing.T) {
+	cv := newCountingVerifier("alice", "bob", "carol")
+	tc := NewTokenCache(cv, 2, time.Minute)
+
+	tc.Verify("alice")
+	tc.Verify("bob")
+	// alice is now the most recently used, so carol evicts bob.
+	tc.Verify("alice")
+	tc.Verify("carol")
+	if tc.lru.Len() != 2 {
+		t.Fatalf("Expected 2 cached tokens, got %d", tc.lru.Len())
+	}
+
+	before := cv.count()
+	tc.Verify("alice")
+	tc.Verify("carol")
+	if cv.count() != before {
+		t.Errorf("Expected alice and carol to be cached")
+	}
+	tc.Verify("bob")
+	if cv.count() != before+1 {
+		t.Errorf("Expected bob to be evicted")
+	}
+}
+
+func TestTokenCacheExpiry(t *testing.T) {
+	now := time.Now()
+	cv := newCountingVerifier("alice", "bob")
+	// bob's token expires before the cache's TTL.
+	cv.tokens["bob"].Expiration = now.Add(10*time.Second).UnixNano() / int64(time.Millisecond)
+	tc := NewTokenCache(cv, 10, time.Minute)
+	tc.now = func() time.Time { return now }
+
+	tc.Verify("alice")
+	tc.Verify("bob")
+	now = now.Add(30 * time.Second)
+	tc.Verify("alice")
+	tc.Verify("bob")
+	if cv.count() != 3 {
+		t.Errorf("Expected bob to be verified again after his token expired, got %d calls", cv.count())
+	}
+
+	now = now.Add(31 * time.Second)
+	tc.Verify("alice")
+	if cv.count() != 4 {
+		t.Errorf("Expected alice to be verified again after the TTL, got %d calls", cv.count())
+	}
+
+	// A token accepted past its expiration, e.g. during a grace period,
+	// isn't cached.
+	cv.tokens["carol"] = &token.AuthToken{Username: "carol", Expiration: now.Add(-time.Second).UnixNano() / int64(time.Millisecond)}
+	tc.Verify("carol")
+	tc.Verify("carol")
+	if cv.count() != 6 {
+		t.Errorf("Expected an expired token not to be cached, got %d calls", cv.count())
+	}
+}
+
+func TestTokenCacheInvalidate(t *testing.T) {
+	cv := newCountingVerifier("alice")
+	tc := NewTokenCache(cv, 10, time.Minute)
+
+	if _, err := tc.Verify("alice"); err != nil {
+		t.Fatalf("Expected alice's token to be accepted: %v", err)
+	}
+	// alice's token is revoked by its ID.
+	delete(cv.tokens, "alice")
+	tc.Invalidate("jti-alice")
+	if _, err := tc.Verify("alice"); err == nil {
+		t.Error("Expected a revoked token to be verified again, and rejected")
+	}
+	if len(tc.entries) != 0 || len(tc.byID) != 0 {
+		t.Errorf("Expected the revoked token to be dropped, got %d and %d entries", len(tc.entries), len(tc.byID))
+	}
+	// Invalidating an uncached token is a no-op.
+	tc.Invalidate("jti-bob")
+}
+
+func TestTokenCacheConcurrency(t *testing.T) {
+	var names []string
+	for i := 0; i < 20; i++ {
+		names = append(names, fmt.Sprintf("user-%d", i))
+	}
+	cv := newCountingVerifier(names...)
+	tc := NewTokenCache(cv, 5, time.Minute)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				name := names[(g+i)%len(names)]
+				if i%7 == 0 {
+					tc.Invalidate("jti-" + name)
+					continue
+				}
+				if tok, err := tc.Verify(name); err != nil || tok.Username != name {
+					t.Errorf("Expected %s's token, got %+v, %v", name, tok, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if tc.lru.Len() > 5 || len(tc.entries) != tc.lru.Len() || len(tc.byID) != tc.lru.Len() {
+		t.Errorf("Expected at most 5 consistent entries, got %d, %d and %d", tc.lru.Len(), len(tc.entries), len(tc.byID))
+	}
+}
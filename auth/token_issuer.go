@@ -1,14 +1,19 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 
 	"encoding/json"
 	"strings"
 	"time"
+	"unicode/utf8"
 
-	goldap "github.com/go-ldap/ldap"
+	goldap "github.com/go-ldap/ldap/v3"
 	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/proofpoint/kubernetes-ldap/client"
@@ -25,8 +30,201 @@ type LDAPTokenIssuer struct {
 	TTL                   time.Duration
 	UsernameAttribute     string
 	EnforceClientVersions bool
+
+	// ExtraGroups are added to the groups of every authenticated user,
+	// regardless of their directory membership.
+	ExtraGroups []string
+
+	// AdminGroupDN is the DN of the group whose members get tokens with
+	// the "elevated" assertion set, which RBAC can gate on.
+	AdminGroupDN string
+	// AdminExtraGroup, if set, is added to the groups of members of
+	// AdminGroupDN.
+	AdminExtraGroup string
+
+	// TokenPrefix is prepended to every issued token, so the API server
+	// and our webhook can tell our tokens from other authenticators'.
+	TokenPrefix string
+
+	// MinPasswordLength rejects passwords shorter than this many
+	// characters before contacting LDAP. Zero disables the length check;
+	// empty passwords are always rejected.
+	MinPasswordLength int
+
+	// UIDAttribute is the directory attribute holding a stable user ID,
+	// e.g. entryUUID or uidNumber.
+	UIDAttribute string
+	// HashedUIDFallback derives a deterministic UID from the username
+	// when the user has no UIDAttribute value.
+	HashedUIDFallback bool
+
+	// UsernameRealm, if set, is appended to usernames as user@realm, to
+	// tell apart users with the same name in different domains. The UID
+	// is still derived from the bare username.
+	UsernameRealm string
+
+	// UsernameDomains are the domains users may qualify their login
+	// with, which are stripped or replaced before the directory is
+	// queried. The token's username still comes from UsernameAttribute.
+	UsernameDomains []UsernameDomain
+
+	// DNAssertion, if set, is the name of an assertion carrying the DN
+	// the user bound as, for integrations that look for it under their
+	// own name (e.g. "dn"). It is off by default as DNs can be sensitive.
+	DNAssertion string
+
+	// AuthTimeAssertion, if set, is the name of an assertion carrying
+	// the time the user authenticated in RFC 3339 (e.g. "auth_time"), for
+	// audit systems that want it human readable. IssuedAt remains the
+	// canonical time. Tokens from the refresh endpoint keep the original
+	// login's time.
+	AuthTimeAssertion string
+
+	// ServerAssertion, if set, is the name of an assertion carrying the
+	// address of the directory server the user bound to, from
+	// ldap.ServerAttribute. Unlike the ldapServer assertion, which is the
+	// configured host, it tells apart the replicas behind it.
+	ServerAssertion string
+
+	// DisplayNameAssertion, if set, is the name of an assertion carrying
+	// a friendly name of the user for dashboards, e.g. "Alice Smith",
+	// from the first of DisplayNameAttributes (e.g. displayName, cn) the
+	// user has. Users with none of them get no assertion.
+	DisplayNameAssertion  string
+	DisplayNameAttributes []string
+
+	// AccountTypeAssertion, if set, is the name of an assertion set to
+	// AccountTypeMachine for entries with one of MachineObjectClasses
+	// (DefaultMachineObjectClasses if unset), and to AccountTypeUser for
+	// others. MachineAccountPolicy is MachineAccountsAllow (the default)
+	// or MachineAccountsReject, and MachineExtraGroup, if set, is added
+	// to the groups of machine accounts.
+	AccountTypeAssertion string
+	MachineObjectClasses []string
+	MachineAccountPolicy string
+	MachineExtraGroup    string
+
+	// AssertionMappings copy directory attributes of the user into
+	// assertions, e.g. a lower cased mail attribute.
+	AssertionMappings []AssertionMapping
+
+	// GroupNameAttribute, if set, names each group of memberOf by the
+	// value of this attribute (e.g. cn) in the leading RDN of its DN,
+	// rather than by every cn anywhere in the DN. Groups whose leading
+	// RDN is of another attribute are named by its value.
+	GroupNameAttribute string
+
+	// OUGroups, if set, derives groups from the organizational units in
+	// the user's DN, for directories that encode teams in the DN rather
+	// than in group objects. Only the OUs listed become groups, or all of
+	// them with OUGroupsAll.
+	OUGroups []string
+
+	// GroupNormalization canonicalizes the names of the user's directory
+	// groups, e.g. with the steps of ParseGroupNormalization, before they
+	// are mapped, so that RBAC bindings match however the directory
+	// spells them. Groups that normalize to the same name are merged.
+	GroupNormalization []Transform
+	// OriginalGroupsAssertion, if set, keeps the directory groups as they
+	// were before GroupNormalization, as a JSON array, in an assertion of
+	// this name when normalization changed them.
+	OriginalGroupsAssertion string
+
+	// GroupMapper, if set, maps the user's directory groups to the
+	// groups put in the token, before ExtraGroups are added.
+	GroupMapper GroupMapper
+
+	// GroupScopes grants scopes to the members of groups, matched by
+	// name without regard to case. Users can narrow their token down to
+	// some of their scopes with the scope query parameter.
+	GroupScopes map[string][]string
+
+	// GroupTTLs overrides TTL for the members of groups, matched by name
+	// without regard to case like GroupScopes, e.g. to give admins
+	// shorter sessions. A user in several of them gets the shortest.
+	GroupTTLs map[string]time.Duration
+
+	// UserRateLimiter, if set, limits how often each user can get a
+	// token. Only successful logins count against the limit, so others
+	// can't lock a user out with bad passwords, but once it is reached
+	// the user's requests are refused before contacting LDAP.
+	UserRateLimiter *UserRateLimiter
+	// UnavailableRetryAfter is sent as the Retry-After of the 503 returned
+	// when the directory is unavailable. Zero sends none.
+	UnavailableRetryAfter time.Duration
+
+	// MaxGroups caps the number of groups in a token. Zero means no limit.
+	MaxGroups int
+	// GroupLimitPolicy is what happens to a user over MaxGroups: either
+	// GroupLimitTruncate (the default) or GroupLimitReject.
+	GroupLimitPolicy string
+	// PriorityGroups are kept ahead of other groups when truncating.
+	// ExtraGroups, AdminExtraGroup and MachineExtraGroup are always
+	// treated as priority.
+	PriorityGroups []string
+
+	// GroupPrefix, if set, is prepended to the groups from the directory
+	// (e.g. "ldap:"), so they can't collide with the cluster's own groups.
+	// ExtraGroups, AdminExtraGroup and MachineExtraGroup are configured
+	// rather than from the directory and aren't prefixed. GroupScopes,
+	// GroupTTLs and PriorityGroups name the groups without the prefix.
+	GroupPrefix string
+
+	// AudienceSource, if set, derives the audience of tokens from the
+	// request they are issued for, either AudienceFromHost or
+	// AudienceFromPath, so that tokens for one cluster are refused by
+	// another's webhook. Refresh tokens keep the audience.
+	AudienceSource string
+
+	// CertBound binds tokens to the TLS client certificate of the
+	// connection they are requested over, which is then required to
+	// present them, and refuses requests without one. Bound tokens are
+	// valid for CertBoundTTL if it is set and shorter than TTL, and come
+	// without a refresh token.
+	CertBound    bool
+	CertBoundTTL time.Duration
+
+	// IssuedTokens, if set, records the tokens issued, for debugging.
+	IssuedTokens *IssuedTokenLog
+
+	// RefreshTTL, if set, also issues a refresh token valid for this
+	// long in JSON responses. It can be exchanged at the refresh endpoint
+	// for a new access token.
+	RefreshTTL time.Duration
+
+	// PasswordResetResponse answers users whose password must be changed
+	// with a distinct 403 password_reset_required error instead of the
+	// generic invalid credentials one. No token is issued either way.
+	PasswordResetResponse bool
+	// PasswordResetMessage is the message returned with the
+	// password_reset_required error. Defaults to
+	// DefaultPasswordResetMessage.
+	PasswordResetMessage string
+
+	// RefuseGraceLogins answers users whose bind only succeeded as a
+	// grace login with their expired password, per the password policy
+	// control, with the password_reset_required error too. By default
+	// they get a token, with a warning.
+	RefuseGraceLogins bool
 }
 
+// DefaultPasswordResetMessage is returned to users who must change their
+// password when no PasswordResetMessage is configured.
+const DefaultPasswordResetMessage = "your password must be changed before you can log in"
+
+// Policies for users in more than MaxGroups groups.
+const (
+	// GroupLimitTruncate drops groups beyond the limit and marks the
+	// token with the groupsTruncated assertion.
+	GroupLimitTruncate = "truncate"
+	// GroupLimitReject refuses to issue a token.
+	GroupLimitReject = "reject"
+)
+
+// hashedUIDPrefix marks UIDs derived from the username, so they can't
+// collide with UIDs read from the directory.
+const hashedUIDPrefix = "ldap:"
+
 var (
 	newTokenRequests = prometheus.NewCounter(
 		prometheus.CounterOpts{
@@ -52,6 +250,30 @@ var (
 			Help: "Total number of requests where signing new token failed.",
 		},
 	)
+	groupLimitExceeded = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_group_limit_exceeded",
+			Help: "Total number of requests to get new token for users in more groups than the configured maximum.",
+		},
+	)
+	passwordResetRequired = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_password_reset_required",
+			Help: "Total number of requests to get new token from users who must change their password.",
+		},
+	)
+	precheckFailedRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_password_precheck_failed",
+			Help: "Total number of requests to get new token rejected before LDAP auth because of an empty or too short password.",
+		},
+	)
+	rateLimitedTokenRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_rate_limited_token_requests",
+			Help: "Total number of token requests refused because the user asked for tokens too often.",
+		},
+	)
 	successfulTokens = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Name: "kubernetes_ldap_successful_tokens_generated",
@@ -66,57 +288,138 @@ func RegisterIssueTokenMetrics() {
 	prometheus.MustRegister(noauthTokenRequests)
 	prometheus.MustRegister(unauthTokenRequests)
 	prometheus.MustRegister(errorSigningToken)
+	prometheus.MustRegister(precheckFailedRequests)
+	prometheus.MustRegister(groupLimitExceeded)
+	prometheus.MustRegister(passwordResetRequired)
+	prometheus.MustRegister(rateLimitedTokenRequests)
 	prometheus.MustRegister(successfulTokens)
 }
 
 func (lti *LDAPTokenIssuer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	newTokenRequests.Inc()
+	reqID := requestID(resp, req)
 	user, password, ok := req.BasicAuth()
 	if !ok {
 		noauthTokenRequests.Inc()
 		resp.Header().Add("WWW-Authenticate", `Basic realm="kubernetes ldap"`)
-		resp.WriteHeader(http.StatusUnauthorized)
+		writeError(resp, http.StatusUnauthorized, errCodeMissingCredentials, "username and password are required")
 		return
 	}
+	user = mapUsernameDomain(user, lti.UsernameDomains)
 
 	if lti.EnforceClientVersions {
 		pluginVersion := req.Header.Get("x-pfpt-k8sldapctl-version")
 		kubectlVersion := req.Header.Get("x-pfpt-kubectl-version")
 
 		if pluginVersion == "" || kubectlVersion == "" {
-			resp.WriteHeader(http.StatusBadRequest)
-			resp.Write([]byte(fmt.Sprintf("\nError: you are using an old version of k8sldapctl plugin. Please upgrade to minimum of %q", client.MinimumPluginVersion)))
+			writeError(resp, http.StatusBadRequest, errCodeUnsupportedClient, fmt.Sprintf("you are using an old version of k8sldapctl plugin. Please upgrade to minimum of %q", client.MinimumPluginVersion))
 			return
 		}
 
 		err := client.Validate(pluginVersion, kubectlVersion)
 		if err != nil {
-			resp.WriteHeader(http.StatusBadRequest)
-			resp.Write([]byte(fmt.Sprintf("\nError: %s", err.Error())))
+			writeError(resp, http.StatusBadRequest, errCodeUnsupportedClient, err.Error())
 			return
 		}
 	}
 
+	if err := lti.precheckPassword(password); err != nil {
+		precheckFailedRequests.Inc()
+		glog.Errorf("[%s] Rejecting credentials for user %q: %v", reqID, user, err)
+		writeError(resp, http.StatusUnauthorized, errCodeInvalidCredentials, "invalid username or password")
+		return
+	}
+
+	if lti.CertBound && (req.TLS == nil || len(req.TLS.PeerCertificates) == 0) {
+		glog.Errorf("[%s] Refusing token for user %q: no client certificate to bind it to", reqID, user)
+		writeError(resp, http.StatusUnauthorized, errCodeMissingCredentials, "a TLS client certificate is required")
+		return
+	}
+
+	loggedIn := false
+	if lti.UserRateLimiter != nil {
+		if wait := lti.UserRateLimiter.reserve(user); wait > 0 {
+			rateLimitedTokenRequests.Inc()
+			glog.Warningf("[%s] Refusing token for user %q: too many requests", reqID, user)
+			setRetryAfter(resp, wait)
+			writeError(resp, http.StatusTooManyRequests, errCodeRateLimited, "too many token requests, try again later")
+			return
+		}
+		// Failed logins don't use up the user's requests.
+		defer func() {
+			if !loggedIn {
+				lti.UserRateLimiter.cancel(user)
+			}
+		}()
+	}
+
 	// Authenticate the user via LDAP
 	ldapEntry, err := lti.LDAPAuthenticator.Authenticate(user, password)
 	if err != nil {
 		unauthTokenRequests.Inc()
-		glog.Errorf("Error authenticating user: %v", err)
-		resp.WriteHeader(http.StatusUnauthorized)
+		glog.Errorf("[%s] Error authenticating user: %v", reqID, err)
+		var unavailable *ldap.UnavailableError
+		if errors.As(err, &unavailable) {
+			setRetryAfter(resp, lti.UnavailableRetryAfter)
+			writeError(resp, http.StatusServiceUnavailable, errCodeBackendUnavailable, "the directory is unavailable, try again later")
+			return
+		}
+		var mustChange *ldap.PasswordMustChangeError
+		if lti.PasswordResetResponse && errors.As(err, &mustChange) {
+			lti.writePasswordReset(resp)
+			return
+		}
+		writeError(resp, http.StatusUnauthorized, errCodeInvalidCredentials, "invalid username or password")
+		return
+	}
+
+	if _, grace := graceLoginsRemaining(ldapEntry); lti.RefuseGraceLogins && grace {
+		glog.Errorf("[%s] Refusing token for user %q: logged in with an expired password", reqID, user)
+		lti.writePasswordReset(resp)
+		return
+	}
+
+	if lti.MachineAccountPolicy == MachineAccountsReject && lti.accountType(ldapEntry) == AccountTypeMachine {
+		glog.Errorf("[%s] Refusing token for machine account %q (%s)", reqID, user, ldapEntry.DN)
+		writeError(resp, http.StatusForbidden, errCodeMachineAccount, "machine accounts can't get tokens")
 		return
 	}
 
+	loggedIn = true
+
 	// Auth was successful, create token
 	token := lti.createToken(ldapEntry)
+	if audience := requestAudience(req, lti.AudienceSource); audience != "" {
+		token.Audiences = []string{audience}
+	}
+	if lti.CertBound {
+		lti.bindToCert(token, req.TLS.PeerCertificates[0])
+	}
+
+	scopes, err := lti.tokenScopes(token.Groups, req.URL.Query().Get("scope"))
+	if err != nil {
+		glog.Errorf("[%s] Refusing token for user %q: %v", reqID, user, err)
+		writeError(resp, http.StatusForbidden, errCodeInvalidScope, err.Error())
+		return
+	}
+	token.Scopes = scopes
+
+	if err := lti.groupLimit().apply(reqID, token); err != nil {
+		writeError(resp, http.StatusForbidden, errCodeTooManyGroups, err.Error())
+		return
+	}
 
 	// Sign token and return
+	token.ID = newTokenID()
 	signedToken, err := lti.TokenSigner.Sign(token)
 	if err != nil {
 		errorSigningToken.Inc()
-		glog.Errorf("Error signing token: %v", err)
-		resp.WriteHeader(http.StatusInternalServerError)
+		glog.Errorf("[%s] Error signing token: %v", reqID, err)
+		writeError(resp, http.StatusInternalServerError, errCodeInternal, "error signing token")
 		return
 	}
+	signedToken = lti.TokenPrefix + signedToken
+	lti.IssuedTokens.record(IssuedByLogin, token)
 
 	successfulTokens.Inc()
 	if req.Header.Get("Accept") == "application/json" {
@@ -124,11 +427,26 @@ func (lti *LDAPTokenIssuer) ServeHTTP(resp http.ResponseWriter, req *http.Reques
 			"token":               signedToken,
 			"expirationTimestamp": token.Expiration,
 		}
+		addPasswordWarnings(resp, ldapEntry, data)
+
+		if lti.RefreshTTL > 0 && token.Confirmation == nil {
+			refreshToken := newRefreshToken(token, lti.RefreshTTL)
+			signedRefreshToken, err := lti.TokenSigner.Sign(refreshToken)
+			if err != nil {
+				errorSigningToken.Inc()
+				glog.Errorf("[%s] Error signing refresh token: %v", reqID, err)
+				writeError(resp, http.StatusInternalServerError, errCodeInternal, "error signing token")
+				return
+			}
+			lti.IssuedTokens.record(IssuedByLogin, refreshToken)
+			data["refreshToken"] = lti.TokenPrefix + signedRefreshToken
+			data["refreshExpirationTimestamp"] = refreshToken.Expiration
+		}
 
 		jsondata, err := json.Marshal(data)
 		if err != nil {
-			glog.Errorf("Error marshalling json %s", err.Error())
-			resp.WriteHeader(http.StatusInternalServerError)
+			glog.Errorf("[%s] Error marshalling json %s", reqID, err.Error())
+			writeError(resp, http.StatusInternalServerError, errCodeInternal, "error marshalling response")
 			return
 		}
 
@@ -137,11 +455,38 @@ func (lti *LDAPTokenIssuer) ServeHTTP(resp http.ResponseWriter, req *http.Reques
 		return
 	}
 
+	addPasswordWarnings(resp, ldapEntry, nil)
 	resp.Header().Add("Content-Type", "text/plain")
 	resp.Write([]byte(signedToken))
 }
 
+// writePasswordReset answers a user who must change their password
+// before getting a token.
+func (lti *LDAPTokenIssuer) writePasswordReset(resp http.ResponseWriter) {
+	passwordResetRequired.Inc()
+	message := lti.PasswordResetMessage
+	if message == "" {
+		message = DefaultPasswordResetMessage
+	}
+	writeError(resp, http.StatusForbidden, errCodePasswordReset, message)
+}
+
+// precheckPassword fails obviously bad passwords fast, without a round
+// trip to the directory. It is a guard, not a password policy.
+func (lti *LDAPTokenIssuer) precheckPassword(password string) error {
+	if password == "" {
+		return errors.New("empty password")
+	}
+	if lti.MinPasswordLength > 0 && utf8.RuneCountInString(password) < lti.MinPasswordLength {
+		return fmt.Errorf("password shorter than %d characters", lti.MinPasswordLength)
+	}
+	return nil
+}
+
 func (lti *LDAPTokenIssuer) getGroupsFromMembersOf(membersOf []string) []string {
+	if lti.GroupNameAttribute != "" {
+		return groupNames(membersOf, lti.GroupNameAttribute)
+	}
 	groupsOf := []string{}
 	uniqueGroups := make(map[string]struct{})
 
@@ -168,26 +513,227 @@ func (lti *LDAPTokenIssuer) getGroupsFromMembersOf(membersOf []string) []string
 	return groupsOf
 }
 
+// appendUniqueGroups appends the extra groups that aren't already in groups.
+func appendUniqueGroups(groups []string, extra []string) []string {
+	seen := make(map[string]struct{}, len(groups))
+	for _, group := range groups {
+		seen[group] = struct{}{}
+	}
+
+	for _, group := range extra {
+		if _, ok := seen[group]; ok {
+			continue
+		}
+		groups = append(groups, group)
+		seen[group] = struct{}{}
+	}
+	return groups
+}
+
 func (lti *LDAPTokenIssuer) createToken(ldapEntry *goldap.Entry) *token.AuthToken {
 	username := ldapEntry.DN
 	if lti.UsernameAttribute != "" {
 		username = ldapEntry.GetAttributeValue(lti.UsernameAttribute)
 	}
 
+	membersOf := ldapEntry.GetAttributeValues("memberOf")
+	groups := lti.getGroupsFromMembersOf(membersOf)
+	if len(lti.OUGroups) > 0 {
+		groups = appendUniqueGroups(groups, ouGroups(ldapEntry.DN, lti.OUGroups))
+	}
+	var original []string
+	if len(lti.GroupNormalization) > 0 {
+		normalized, changed := normalizeGroups(groups, lti.GroupNormalization)
+		if changed {
+			original = groups
+		}
+		groups = normalized
+	}
+	if lti.GroupMapper != nil {
+		groups = lti.GroupMapper.Map(groups)
+	}
+	// The directory's groups are prefixed before the configured ones are
+	// added, so that a directory group named like one of those doesn't
+	// pass for it.
+	groups = prefixGroups(groups, lti.GroupPrefix)
+	groups = appendUniqueGroups(groups, lti.ExtraGroups)
+
+	assertions := map[string]string{
+		"ldapServer":              lti.LDAPServer,
+		"userDN":                  ldapEntry.DN,
+		token.AuthMethodAssertion: token.AuthMethodLDAPBind,
+	}
+
+	if lti.DNAssertion != "" {
+		assertions[lti.DNAssertion] = ldapEntry.DN
+	}
+	if server := ldapEntry.GetAttributeValue(ldap.ServerAttribute); lti.ServerAssertion != "" && server != "" {
+		assertions[lti.ServerAssertion] = server
+	}
+	if name := displayName(ldapEntry, lti.DisplayNameAttributes); lti.DisplayNameAssertion != "" && name != "" {
+		assertions[lti.DisplayNameAssertion] = name
+	}
+	mapAssertions(assertions, lti.AssertionMappings, ldapEntry)
+	if lti.OriginalGroupsAssertion != "" && original != nil {
+		assertions[lti.OriginalGroupsAssertion] = originalGroups(original)
+	}
+	if ldapEntry.GetAttributeValue(ldap.GroupsIncompleteAttribute) == "true" {
+		assertions["groupsIncomplete"] = "true"
+	}
+
+	if lti.isAdmin(membersOf) {
+		assertions["elevated"] = "true"
+		if lti.AdminExtraGroup != "" {
+			groups = appendUniqueGroups(groups, []string{lti.AdminExtraGroup})
+		}
+	}
+	if lti.AccountTypeAssertion != "" || lti.MachineExtraGroup != "" {
+		accountType := lti.accountType(ldapEntry)
+		if lti.AccountTypeAssertion != "" {
+			assertions[lti.AccountTypeAssertion] = accountType
+		}
+		if lti.MachineExtraGroup != "" && accountType == AccountTypeMachine {
+			groups = appendUniqueGroups(groups, []string{lti.MachineExtraGroup})
+		}
+	}
+
+	issuedAt := nowMillis()
+	if lti.AuthTimeAssertion != "" {
+		assertions[lti.AuthTimeAssertion] = time.Unix(0, issuedAt*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+	}
+
 	return &token.AuthToken{
-		Username: username,
-		Groups:   lti.getGroupsFromMembersOf(ldapEntry.GetAttributeValues("memberOf")),
-		Assertions: map[string]string{
-			"ldapServer": lti.LDAPServer,
-			"userDN":     ldapEntry.DN,
-		},
-		Expiration: lti.getExpirationTime(),
+		Username:   lti.qualifyUsername(username),
+		Groups:     groups,
+		Assertions: assertions,
+		Expiration: expirationAfter(groupTTL(prefixedGroupTTLs(lti.GroupTTLs, lti.GroupPrefix), groups, lti.TTL)),
+		IssuedAt:   issuedAt,
+		UID:        lti.getUID(ldapEntry, username),
+		Type:       token.TypeAccess,
 	}
 }
 
-func (lti *LDAPTokenIssuer) getExpirationTime() int64 {
-	nowMillis := time.Now().UnixNano() / int64(time.Millisecond)
-	ttlMillis := int64(time.Duration(lti.TTL) / time.Millisecond)
+// qualifyUsername appends UsernameRealm to username, unless it already
+// ends with it.
+func (lti *LDAPTokenIssuer) qualifyUsername(username string) string {
+	if lti.UsernameRealm == "" || username == "" {
+		return username
+	}
+	suffix := "@" + lti.UsernameRealm
+	if strings.HasSuffix(strings.ToLower(username), strings.ToLower(suffix)) {
+		return username
+	}
+	return username + suffix
+}
+
+// getUID returns the user's UIDAttribute value or, failing that and if
+// enabled, a UID hashed from the username.
+func (lti *LDAPTokenIssuer) getUID(ldapEntry *goldap.Entry, username string) string {
+	if lti.UIDAttribute != "" {
+		if uid := ldapEntry.GetAttributeValue(lti.UIDAttribute); uid != "" {
+			return uid
+		}
+	}
+	if lti.HashedUIDFallback && username != "" {
+		return hashedUID(username)
+	}
+	return ""
+}
+
+// hashedUID derives a UID from the canonical (lower case) username, so
+// the same user gets the same UID across restarts and replicas.
+func hashedUID(username string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(username))))
+	return hashedUIDPrefix + hex.EncodeToString(sum[:])
+}
+
+// prefixGroups returns groups with prefix prepended to each.
+func prefixGroups(groups []string, prefix string) []string {
+	if prefix == "" {
+		return groups
+	}
+	prefixed := make([]string, len(groups))
+	for i, group := range groups {
+		prefixed[i] = prefix + group
+	}
+	return prefixed
+}
+
+// bindToCert binds tok to the client certificate cert, shortening its
+// lifetime to CertBoundTTL.
+func (lti *LDAPTokenIssuer) bindToCert(tok *token.AuthToken, cert *x509.Certificate) {
+	tok.Confirmation = &token.Confirmation{X5tS256: token.CertThumbprint(cert)}
+	if expiration := expirationAfter(lti.CertBoundTTL); lti.CertBoundTTL > 0 && expiration < tok.Expiration {
+		tok.Expiration = expiration
+	}
+}
+
+// groupLimit is the MaxGroups limit, with PriorityGroups, ExtraGroups,
+// AdminExtraGroup and MachineExtraGroup kept first when truncating.
+func (lti *LDAPTokenIssuer) groupLimit() groupLimit {
+	priority := append([]string(nil), lti.PriorityGroups...)
+	if lti.GroupPrefix != "" {
+		for _, group := range lti.PriorityGroups {
+			priority = append(priority, lti.GroupPrefix+group)
+		}
+	}
+	priority = append(priority, lti.ExtraGroups...)
+	if lti.AdminExtraGroup != "" {
+		priority = append(priority, lti.AdminExtraGroup)
+	}
+	if lti.MachineExtraGroup != "" {
+		priority = append(priority, lti.MachineExtraGroup)
+	}
+	return groupLimit{max: lti.MaxGroups, policy: lti.GroupLimitPolicy, priority: priority}
+}
+
+// isAdmin returns true if one of the user's memberOf DNs is AdminGroupDN.
+func (lti *LDAPTokenIssuer) isAdmin(membersOf []string) bool {
+	if lti.AdminGroupDN == "" {
+		return false
+	}
+	for _, memberOf := range membersOf {
+		if dnEqual(memberOf, lti.AdminGroupDN) {
+			return true
+		}
+	}
+	return false
+}
+
+// dnEqual compares two DNs by their RDNs, ignoring case and insignificant
+// whitespace, since directories such as AD don't preserve either. DNs
+// that don't parse are compared as case-insensitive strings.
+func dnEqual(a, b string) bool {
+	dnA, errA := goldap.ParseDN(a)
+	dnB, errB := goldap.ParseDN(b)
+	if errA != nil || errB != nil {
+		return strings.EqualFold(a, b)
+	}
+	if len(dnA.RDNs) != len(dnB.RDNs) {
+		return false
+	}
+	for i := range dnA.RDNs {
+		attrsA, attrsB := dnA.RDNs[i].Attributes, dnB.RDNs[i].Attributes
+		if len(attrsA) != len(attrsB) {
+			return false
+		}
+		for j := range attrsA {
+			if !strings.EqualFold(attrsA[j].Type, attrsB[j].Type) || !strings.EqualFold(attrsA[j].Value, attrsB[j].Value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// expirationAfter returns the time ttl from now in unix milliseconds.
+func expirationAfter(ttl time.Duration) int64 {
+	ttlMillis := int64(ttl / time.Millisecond)
+
+	return nowMillis() + ttlMillis
+}
 
-	return nowMillis + ttlMillis
+// nowMillis returns the current time in unix milliseconds.
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
 }
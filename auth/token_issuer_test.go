@@ -1,13 +1,16 @@
 package auth
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 
-	"github.com/go-ldap/ldap"
+	"github.com/go-ldap/ldap/v3"
 	"github.com/proofpoint/kubernetes-ldap/token"
 	"time"
 )
@@ -30,6 +33,16 @@ func (d dummySigner) Sign(token *token.AuthToken) (string, error) {
 	return d.signed, d.err
 }
 
+// recordingSigner keeps the last token it was asked to sign.
+type recordingSigner struct {
+	signed *token.AuthToken
+}
+
+func (r *recordingSigner) Sign(token *token.AuthToken) (string, error) {
+	r.signed = token
+	return "signedToken", nil
+}
+
 func TestTokenIssuer(t *testing.T) {
 	cases := []struct {
 		basicAuth           bool
@@ -113,6 +126,100 @@ func TestTokenIssuer(t *testing.T) {
 	}
 }
 
+func TestPasswordPrecheck(t *testing.T) {
+	cases := []struct {
+		name              string
+		password          string
+		minPasswordLength int
+		expectedCode      int
+	}{
+		{
+			name:         "empty password is rejected even with the length check disabled",
+			password:     "",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:              "empty password is rejected with the length check enabled",
+			password:          "",
+			minPasswordLength: 8,
+			expectedCode:      http.StatusUnauthorized,
+		},
+		{
+			name:              "short password is rejected",
+			password:          "short",
+			minPasswordLength: 8,
+			expectedCode:      http.StatusUnauthorized,
+		},
+		{
+			name:              "password of minimum length is accepted",
+			password:          "12345678",
+			minPasswordLength: 8,
+			expectedCode:      http.StatusOK,
+		},
+		{
+			name:              "non-ASCII password is counted in characters",
+			password:          "pässwörd",
+			minPasswordLength: 8,
+			expectedCode:      http.StatusOK,
+		},
+		{
+			name:              "short non-ASCII password is rejected",
+			password:          "日本語の",
+			minPasswordLength: 8,
+			expectedCode:      http.StatusUnauthorized,
+		},
+		{
+			name:         "short password is accepted with the length check disabled",
+			password:     "short",
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, c := range cases {
+		// The LDAP backend accepts anything, so a 401 can only come
+		// from the pre-check.
+		lti := LDAPTokenIssuer{
+			LDAPAuthenticator: dummyLDAP{entry: &ldap.Entry{}},
+			TokenSigner:       dummySigner{"signedToken", nil},
+			MinPasswordLength: c.minPasswordLength,
+		}
+
+		req, err := http.NewRequest("GET", "", nil)
+		if err != nil {
+			t.Errorf("%s: Failed to create request: %v", c.name, err)
+		}
+		req.SetBasicAuth("user", c.password)
+
+		rec := httptest.NewRecorder()
+		lti.ServeHTTP(rec, req)
+
+		if rec.Code != c.expectedCode {
+			t.Errorf("%s: Expected %d, got %d", c.name, c.expectedCode, rec.Code)
+		}
+	}
+}
+
+func TestTokenIssuerPrefix(t *testing.T) {
+	lti := LDAPTokenIssuer{
+		LDAPAuthenticator: dummyLDAP{&ldap.Entry{}, nil},
+		TokenSigner:       dummySigner{"signedToken", nil},
+		TokenPrefix:       "ldap:",
+	}
+
+	req, err := http.NewRequest("GET", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.SetBasicAuth("user", "password")
+
+	rec := httptest.NewRecorder()
+	lti.ServeHTTP(rec, req)
+
+	if rec.Body.String() != "ldap:signedToken" {
+		t.Errorf("Expected prefixed token %q, got %q", "ldap:signedToken", rec.Body.String())
+	}
+}
+
 func TestCreateToken(t *testing.T) {
 	e := &ldap.Entry{
 		DN: "some-dn",
@@ -152,6 +259,7 @@ func TestCreateToken(t *testing.T) {
 			expectedAssertions: map[string]string{
 				"ldapServer": "some-ldap-server",
 				"userDN":     e.DN,
+				"amr":        "ldap-bind",
 			},
 			expectedUsername: "username@example.com",
 			expectedGroups: []string{
@@ -159,6 +267,24 @@ func TestCreateToken(t *testing.T) {
 				"sg-grp2",
 			},
 		},
+		{
+			name: "static extra groups are added and deduplicated",
+			tokenIssuer: LDAPTokenIssuer{
+				LDAPServer:  "some-ldap-server",
+				ExtraGroups: []string{"system:authenticated-ldap", "sg-grp1"},
+			},
+			expectedAssertions: map[string]string{
+				"ldapServer": "some-ldap-server",
+				"userDN":     e.DN,
+				"amr":        "ldap-bind",
+			},
+			expectedUsername: e.DN,
+			expectedGroups: []string{
+				"sg-grp1",
+				"sg-grp2",
+				"system:authenticated-ldap",
+			},
+		},
 		{
 			name: "verify backward compatibility",
 			tokenIssuer: LDAPTokenIssuer{
@@ -167,6 +293,7 @@ func TestCreateToken(t *testing.T) {
 			expectedAssertions: map[string]string{
 				"ldapServer": "some-ldap-server",
 				"userDN":     e.DN,
+				"amr":        "ldap-bind",
 			},
 			expectedUsername: e.DN,
 			expectedGroups: []string{
@@ -187,6 +314,321 @@ func TestCreateToken(t *testing.T) {
 				t.Errorf("Expected assertion '%s' to be '%s'. Got '%s'", k, v, tok.Assertions[k])
 			}
 		}
+
+		if !reflect.DeepEqual(tok.Groups, testcase.expectedGroups) {
+			t.Errorf("%s: Unexpected groups in token. Expected: %v. Got: %v.", testcase.name, testcase.expectedGroups, tok.Groups)
+		}
+	}
+}
+
+func TestAdminGroup(t *testing.T) {
+	adminGroupDN := "CN=k8s-admins,OU=Groups,DC=example,DC=com"
+
+	cases := []struct {
+		name             string
+		membersOf        []string
+		expectedElevated bool
+	}{
+		{
+			name:             "member of the admin group",
+			membersOf:        []string{"cn=sg-grp1,ou=Groups,dc=example,dc=com", "cn=k8s-admins, ou=groups, dc=example, dc=com"},
+			expectedElevated: true,
+		},
+		{
+			name:             "member of a group with a similar name",
+			membersOf:        []string{"cn=k8s-admins-readonly,ou=Groups,dc=example,dc=com"},
+			expectedElevated: false,
+		},
+		{
+			name:             "not a member of any group",
+			expectedElevated: false,
+		},
+	}
+
+	for _, c := range cases {
+		lti := LDAPTokenIssuer{
+			AdminGroupDN:    adminGroupDN,
+			AdminExtraGroup: "cluster-admins",
+		}
+		e := &ldap.Entry{
+			DN: "some-dn",
+			Attributes: []*ldap.EntryAttribute{
+				{Name: "memberOf", Values: c.membersOf},
+			},
+		}
+
+		tok := lti.createToken(e)
+		_, elevated := tok.Assertions["elevated"]
+		if elevated != c.expectedElevated {
+			t.Errorf("%s: Expected elevated %t, got %t", c.name, c.expectedElevated, elevated)
+		}
+		if elevated && tok.Assertions["elevated"] != "true" {
+			t.Errorf("%s: Expected elevated assertion to be \"true\", got %q", c.name, tok.Assertions["elevated"])
+		}
+
+		hasAdminGroup := false
+		for _, group := range tok.Groups {
+			if group == "cluster-admins" {
+				hasAdminGroup = true
+			}
+		}
+		if hasAdminGroup != c.expectedElevated {
+			t.Errorf("%s: Expected cluster-admins group %t, got groups %v", c.name, c.expectedElevated, tok.Groups)
+		}
+	}
+}
+
+func TestUID(t *testing.T) {
+	entry := func(attrs map[string][]string) *ldap.Entry {
+		return ldap.NewEntry("uid=alice,dc=example,dc=com", attrs)
+	}
+
+	// Precomputed so the test catches any change to the derivation, which
+	// would change every user's UID.
+	const aliceHash = "ldap:2bd806c97f0e00af1a1fc3328fa763a9269723c8db8fac4f93af71db186d6e90"
+
+	cases := []struct {
+		name        string
+		tokenIssuer LDAPTokenIssuer
+		entry       *ldap.Entry
+		expectedUID string
+	}{
+		{
+			name:        "no UID configured",
+			tokenIssuer: LDAPTokenIssuer{UsernameAttribute: "uid"},
+			entry:       entry(map[string][]string{"uid": {"alice"}}),
+			expectedUID: "",
+		},
+		{
+			name:        "UID attribute present",
+			tokenIssuer: LDAPTokenIssuer{UsernameAttribute: "uid", UIDAttribute: "entryUUID", HashedUIDFallback: true},
+			entry:       entry(map[string][]string{"uid": {"alice"}, "entryUUID": {"4c1b0c5e-6f2e-4b8e-9a55-3f7d2b1a9e10"}}),
+			expectedUID: "4c1b0c5e-6f2e-4b8e-9a55-3f7d2b1a9e10",
+		},
+		{
+			name:        "UID attribute missing, no fallback",
+			tokenIssuer: LDAPTokenIssuer{UsernameAttribute: "uid", UIDAttribute: "entryUUID"},
+			entry:       entry(map[string][]string{"uid": {"alice"}}),
+			expectedUID: "",
+		},
+		{
+			name:        "UID attribute missing, hashed fallback",
+			tokenIssuer: LDAPTokenIssuer{UsernameAttribute: "uid", UIDAttribute: "entryUUID", HashedUIDFallback: true},
+			entry:       entry(map[string][]string{"uid": {"alice"}}),
+			expectedUID: aliceHash,
+		},
+		{
+			name:        "hashed fallback uses the canonical username",
+			tokenIssuer: LDAPTokenIssuer{UsernameAttribute: "uid", HashedUIDFallback: true},
+			entry:       entry(map[string][]string{"uid": {"Alice"}}),
+			expectedUID: aliceHash,
+		},
+	}
+
+	for _, c := range cases {
+		tok := c.tokenIssuer.createToken(c.entry)
+		if tok.UID != c.expectedUID {
+			t.Errorf("%s: Expected UID %q, got %q", c.name, c.expectedUID, tok.UID)
+		}
+	}
+
+	if hashedUID("alice") != hashedUID("alice") {
+		t.Errorf("Expected the hashed UID to be deterministic")
+	}
+	if hashedUID("alice") == hashedUID("bob") {
+		t.Errorf("Expected different users to get different hashed UIDs")
+	}
+	uid := hashedUID("bob")
+	if !strings.HasPrefix(uid, hashedUIDPrefix) || len(uid) != len(hashedUIDPrefix)+64 {
+		t.Errorf("Expected %q followed by 64 hex characters, got %q", hashedUIDPrefix, uid)
+	}
+}
+
+func TestUsernameRealm(t *testing.T) {
+	cases := []struct {
+		name             string
+		realm            string
+		username         string
+		expectedUsername string
+	}{
+		{name: "no realm", username: "alice", expectedUsername: "alice"},
+		{name: "realm appended", realm: "corp.example.com", username: "alice", expectedUsername: "alice@corp.example.com"},
+		{name: "already qualified", realm: "corp.example.com", username: "alice@CORP.example.com", expectedUsername: "alice@CORP.example.com"},
+		{name: "qualified with another realm", realm: "corp.example.com", username: "alice@lab.example.com", expectedUsername: "alice@lab.example.com@corp.example.com"},
+	}
+
+	for _, c := range cases {
+		lti := LDAPTokenIssuer{UsernameAttribute: "uid", UsernameRealm: c.realm, HashedUIDFallback: true}
+		tok := lti.createToken(ldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{
+			"uid":      {c.username},
+			"memberOf": {"cn=sg-grp1,ou=Groups,dc=example,dc=com"},
+		}))
+		if tok.Username != c.expectedUsername {
+			t.Errorf("%s: Expected username %q, got %q", c.name, c.expectedUsername, tok.Username)
+		}
+		// The UID and groups come from the bare identity.
+		if tok.UID != hashedUID(c.username) {
+			t.Errorf("%s: Expected the UID of %q, got %q", c.name, c.username, tok.UID)
+		}
+		if len(tok.Groups) != 1 || tok.Groups[0] != "sg-grp1" {
+			t.Errorf("%s: Expected groups [sg-grp1], got %v", c.name, tok.Groups)
+		}
+
+		// The review carries the token's username.
+		tw := NewTokenWebhook(&dummyVerifier{token: tok})
+		trrJSON, _ := json.Marshal(&TokenReviewRequest{Spec: TokenReviewSpec{Token: "someToken"}})
+		rec := httptest.NewRecorder()
+		tw.ServeHTTP(rec, httptest.NewRequest("POST", "/authenticate", bytes.NewReader(trrJSON)))
+		trr := &TokenReviewRequest{}
+		if err := json.NewDecoder(rec.Body).Decode(trr); err != nil {
+			t.Fatalf("%s: Error decoding response: %v", c.name, err)
+		}
+		if trr.Status.User.Username != c.expectedUsername {
+			t.Errorf("%s: Expected review username %q, got %q", c.name, c.expectedUsername, trr.Status.User.Username)
+		}
+	}
+}
+
+func TestDNAssertion(t *testing.T) {
+	entry := ldap.NewEntry("uid=alice,ou=people,dc=example,dc=com", map[string][]string{"uid": {"alice"}})
+
+	cases := []struct {
+		name        string
+		dnAssertion string
+	}{
+		{name: "disabled"},
+		{name: "enabled", dnAssertion: "dn"},
+	}
+
+	for _, c := range cases {
+		lti := LDAPTokenIssuer{UsernameAttribute: "uid", DNAssertion: c.dnAssertion}
+		tok := lti.createToken(entry)
+
+		dn, ok := tok.Assertions["dn"]
+		if c.dnAssertion == "" {
+			if ok {
+				t.Errorf("%s: Expected no dn assertion, got %q", c.name, dn)
+			}
+			continue
+		}
+		if dn != entry.DN {
+			t.Errorf("%s: Expected dn assertion %q, got %q", c.name, entry.DN, dn)
+		}
+	}
+}
+
+func TestAuthTimeAssertion(t *testing.T) {
+	entry := ldap.NewEntry("uid=alice,ou=people,dc=example,dc=com", map[string][]string{"uid": {"alice"}})
+
+	tok := (&LDAPTokenIssuer{UsernameAttribute: "uid"}).createToken(entry)
+	if authTime, ok := tok.Assertions["auth_time"]; ok {
+		t.Errorf("Expected no auth_time assertion by default, got %q", authTime)
+	}
+
+	tok = (&LDAPTokenIssuer{UsernameAttribute: "uid", AuthTimeAssertion: "auth_time"}).createToken(entry)
+	authTime, err := time.Parse(time.RFC3339, tok.Assertions["auth_time"])
+	if err != nil {
+		t.Fatalf("Expected an RFC 3339 auth_time assertion, got %q: %v", tok.Assertions["auth_time"], err)
+	}
+	if _, offset := authTime.Zone(); offset != 0 {
+		t.Errorf("Expected auth_time in UTC, got %q", tok.Assertions["auth_time"])
+	}
+	issuedAt := time.Unix(0, tok.IssuedAt*int64(time.Millisecond))
+	if !authTime.Equal(issuedAt.Truncate(time.Second)) {
+		t.Errorf("Expected auth_time %q to match IssuedAt %v", tok.Assertions["auth_time"], issuedAt.UTC())
+	}
+}
+
+func TestGroupLimit(t *testing.T) {
+	membersOf := []string{
+		"cn=grp1,ou=Groups,dc=example,dc=com",
+		"cn=grp2,ou=Groups,dc=example,dc=com",
+		"cn=grp3,ou=Groups,dc=example,dc=com",
+		"cn=k8s-devs,ou=Groups,dc=example,dc=com",
+	}
+	e := &ldap.Entry{
+		DN: "some-dn",
+		Attributes: []*ldap.EntryAttribute{
+			{Name: "memberOf", Values: membersOf},
+		},
+	}
+
+	cases := []struct {
+		name           string
+		tokenIssuer    LDAPTokenIssuer
+		expectedCode   int
+		expectedGroups []string
+		truncated      string
+	}{
+		{
+			name:           "under the limit",
+			tokenIssuer:    LDAPTokenIssuer{MaxGroups: 10},
+			expectedCode:   http.StatusOK,
+			expectedGroups: []string{"grp1", "grp2", "grp3", "k8s-devs"},
+		},
+		{
+			name:           "no limit",
+			tokenIssuer:    LDAPTokenIssuer{},
+			expectedCode:   http.StatusOK,
+			expectedGroups: []string{"grp1", "grp2", "grp3", "k8s-devs"},
+		},
+		{
+			name:           "over the limit, truncate by default",
+			tokenIssuer:    LDAPTokenIssuer{MaxGroups: 2},
+			expectedCode:   http.StatusOK,
+			expectedGroups: []string{"grp1", "grp2"},
+			truncated:      "4",
+		},
+		{
+			name: "over the limit, truncate keeps priority and extra groups",
+			tokenIssuer: LDAPTokenIssuer{
+				MaxGroups:        3,
+				GroupLimitPolicy: GroupLimitTruncate,
+				PriorityGroups:   []string{"K8S-DEVS"},
+				ExtraGroups:      []string{"system:ldap"},
+			},
+			expectedCode:   http.StatusOK,
+			expectedGroups: []string{"k8s-devs", "system:ldap", "grp1"},
+			truncated:      "5",
+		},
+		{
+			name:         "over the limit, reject",
+			tokenIssuer:  LDAPTokenIssuer{MaxGroups: 2, GroupLimitPolicy: GroupLimitReject},
+			expectedCode: http.StatusForbidden,
+		},
+	}
+
+	for _, c := range cases {
+		signer := &recordingSigner{}
+		lti := c.tokenIssuer
+		lti.LDAPAuthenticator = dummyLDAP{entry: e}
+		lti.TokenSigner = signer
+
+		req, err := http.NewRequest("GET", "", nil)
+		if err != nil {
+			t.Fatalf("%s: Failed to create request: %v", c.name, err)
+		}
+		req.SetBasicAuth("user", "password")
+
+		rec := httptest.NewRecorder()
+		lti.ServeHTTP(rec, req)
+
+		if rec.Code != c.expectedCode {
+			t.Errorf("%s: Expected %d, got %d", c.name, c.expectedCode, rec.Code)
+			continue
+		}
+		if c.expectedCode != http.StatusOK {
+			if signer.signed != nil {
+				t.Errorf("%s: Expected no token to be signed", c.name)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(signer.signed.Groups, c.expectedGroups) {
+			t.Errorf("%s: Expected groups %v, got %v", c.name, c.expectedGroups, signer.signed.Groups)
+		}
+		if got := signer.signed.Assertions["groupsTruncated"]; got != c.truncated {
+			t.Errorf("%s: Expected groupsTruncated assertion %q, got %q", c.name, c.truncated, got)
+		}
 	}
 }
 
@@ -221,6 +663,9 @@ func TestTTL(t *testing.T) {
 		if tok.Expiration > expectedExpiration {
 			t.Errorf("Case: %d. Expiration expected: %d, got: %d", i, tok.Expiration, expectedExpiration)
 		}
+		if tok.IssuedAt > now || tok.IssuedAt < now-int64(time.Minute/time.Millisecond) {
+			t.Errorf("Case: %d. IssuedAt expected around %d, got: %d", i, now, tok.IssuedAt)
+		}
 	}
 }
 
@@ -262,3 +707,90 @@ func TestTokenExpired(t *testing.T) {
 		}
 	}
 }
+
+func TestGroupPrefix(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	entry := ldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{
+		"uid":      {"alice"},
+		"memberOf": {"cn=system:masters,ou=Groups,dc=example,dc=com", "cn=deployers,ou=Groups,dc=example,dc=com", "cn=admins,ou=Groups,dc=example,dc=com"},
+	})
+	lti := &LDAPTokenIssuer{
+		LDAPAuthenticator: dummyLDAP{entry: entry},
+		TokenSigner:       signer,
+		TTL:               time.Hour,
+		UsernameAttribute: "uid",
+		ExtraGroups:       []string{"system:authenticated-ldap"},
+		AdminGroupDN:      "cn=admins,ou=Groups,dc=example,dc=com",
+		AdminExtraGroup:   "ldap-admins",
+		GroupPrefix:       "ldap:",
+		// Scopes and priorities name the groups without the prefix.
+		GroupScopes:      map[string][]string{"deployers": {"deploy"}},
+		MaxGroups:        4,
+		PriorityGroups:   []string{"deployers"},
+		GroupLimitPolicy: GroupLimitTruncate,
+	}
+	tw := NewTokenWebhook(verifier)
+	tw.ScopesExtraKey = "kubernetes-ldap/scopes"
+
+	signed, _ := issueTokens(t, lti)
+	rec := reviewToken(tw, signed)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var trr TokenReviewRequest
+	if err := json.Unmarshal(rec.Body.Bytes(), &trr); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	expected := []string{"ldap:deployers", "system:authenticated-ldap", "ldap-admins", "ldap:system:masters"}
+	if !reflect.DeepEqual(trr.Status.User.Groups, expected) {
+		t.Errorf("Expected groups %v, got %v", expected, trr.Status.User.Groups)
+	}
+	if scopes := trr.Status.User.Extra["kubernetes-ldap/scopes"]; !reflect.DeepEqual(scopes, []string{"deploy"}) {
+		t.Errorf("Expected the deploy scope, got %v", scopes)
+	}
+}
+
+func TestGroupPrefixConfiguredGroupName(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	// bob isn't an admin, but is in a directory group named like the
+	// configured admin group.
+	entry := ldap.NewEntry("uid=bob,dc=example,dc=com", map[string][]string{
+		"uid":      {"bob"},
+		"memberOf": {"cn=ldap-admins,ou=Groups,dc=example,dc=com"},
+	})
+	lti := &LDAPTokenIssuer{
+		LDAPAuthenticator: dummyLDAP{entry: entry},
+		TokenSigner:       signer,
+		TTL:               time.Hour,
+		UsernameAttribute: "uid",
+		ExtraGroups:       []string{"system:authenticated-ldap"},
+		AdminGroupDN:      "cn=admins,ou=Groups,dc=example,dc=com",
+		AdminExtraGroup:   "ldap-admins",
+		GroupPrefix:       "ldap:",
+	}
+	tw := NewTokenWebhook(verifier)
+
+	signed, _ := issueTokens(t, lti)
+	rec := reviewToken(tw, signed)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var trr TokenReviewRequest
+	if err := json.Unmarshal(rec.Body.Bytes(), &trr); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	expected := []string{"ldap:ldap-admins", "system:authenticated-ldap"}
+	if !reflect.DeepEqual(trr.Status.User.Groups, expected) {
+		t.Errorf("Expected groups %v, got %v", expected, trr.Status.User.Groups)
+	}
+}
@@ -11,6 +11,9 @@ type TokenReviewRequest struct {
 // TokenReviewSpec contains the token being reviewed
 type TokenReviewSpec struct {
 	Token string `json:"token"`
+	// Audiences are those the API server accepts. If set, the token must
+	// be for one of them.
+	Audiences []string `json:"audiences,omitempty"`
 }
 
 // TokenReviewStatus is the result of the token authentication request.
@@ -19,6 +22,11 @@ type TokenReviewStatus struct {
 	Authenticated bool `json:"authenticated,omitempty"`
 	// User contains information about the authenticated user.
 	User UserInfo `json:"user,omitempty"`
+	// Audiences are the audiences of the review the token is for.
+	Audiences []string `json:"audiences,omitempty"`
+	// Error explains why a request that couldn't be reviewed was not
+	// authenticated.
+	Error string `json:"error,omitempty"`
 }
 
 // UserInfo contains information about the user
@@ -34,3 +42,11 @@ type UserInfo struct {
 	// Any additional information provided by the authenticator.
 	Extra map[string][]string `json:"extra,omitempty"`
 }
+
+// addExtra appends values to the extra information under key.
+func (u *UserInfo) addExtra(key string, values ...string) {
+	if u.Extra == nil {
+		u.Extra = make(map[string][]string)
+	}
+	u.Extra[key] = append(u.Extra[key], values...)
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UsernameDomain is a domain users may qualify their login with, as
+// user@domain (a UPN) or DOMAIN\user, for directories that only know the
+// bare user. The domain is stripped before the directory sees the
+// username, or replaced with Replacement if set.
+type UsernameDomain struct {
+	Domain      string
+	Replacement string
+}
+
+// ParseUsernameDomain parses a domain to strip from usernames, e.g.
+// "corp.example.com", or to replace, e.g.
+// "old.example.com=example.com".
+func ParseUsernameDomain(s string) (UsernameDomain, error) {
+	domain, replacement := s, ""
+	if i := strings.Index(s, "="); i >= 0 {
+		domain, replacement = s[:i], s[i+1:]
+		if replacement == "" {
+			return UsernameDomain{}, fmt.Errorf("%q has an empty replacement, leave out the = to strip the domain", s)
+		}
+	}
+	domain = strings.TrimSpace(domain)
+	replacement = strings.TrimSpace(replacement)
+	if domain == "" {
+		return UsernameDomain{}, fmt.Errorf("%q has an empty domain", s)
+	}
+	if strings.ContainsAny(domain+replacement, `@\`) {
+		return UsernameDomain{}, fmt.Errorf("%q: domains can't contain @ or \\", s)
+	}
+	return UsernameDomain{Domain: domain, Replacement: replacement}, nil
+}
+
+// mapUsernameDomain strips or replaces the domain of username if it is
+// one of domains, compared ignoring case. Other usernames, including
+// those qualified with another domain, are returned as they are.
+func mapUsernameDomain(username string, domains []UsernameDomain) string {
+	if len(domains) == 0 {
+		return username
+	}
+	user, domain := username, ""
+	if i := strings.LastIndex(username, "@"); i >= 0 {
+		user, domain = username[:i], username[i+1:]
+	} else if i := strings.Index(username, `\`); i >= 0 {
+		domain, user = username[:i], username[i+1:]
+	}
+	if user == "" || domain == "" {
+		return username
+	}
+	for _, d := range domains {
+		if !strings.EqualFold(domain, d.Domain) {
+			continue
+		}
+		if d.Replacement == "" {
+			return user
+		}
+		return user + "@" + d.Replacement
+	}
+	return username
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+// loginDirectory knows users by their bare login, or for remapped
+// domains by the login qualified with the replacement domain, and
+// records the usernames it was asked to authenticate.
+type loginDirectory struct {
+	users map[string]*ldap.Entry
+	asked *[]string
+}
+
+func (d loginDirectory) Authenticate(username, password string) (*ldap.Entry, error) {
+	*d.asked = append(*d.asked, username)
+	if entry, ok := d.users[username]; ok {
+		return entry, nil
+	}
+	return nil, fmt.Errorf("No result for the search filter '(uid=%s)'", username)
+}
+
+func TestParseUsernameDomain(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected UsernameDomain
+		invalid  bool
+	}{
+		{input: "corp.example.com", expected: UsernameDomain{Domain: "corp.example.com"}},
+		{input: "CORP", expected: UsernameDomain{Domain: "CORP"}},
+		{input: "old.example.com=example.com", expected: UsernameDomain{Domain: "old.example.com", Replacement: "example.com"}},
+		{input: "", invalid: true},
+		{input: "=example.com", invalid: true},
+		{input: "old.example.com=", invalid: true},
+		{input: "alice@corp.example.com", invalid: true},
+		{input: `CORP\`, invalid: true},
+	}
+	for _, c := range cases {
+		domain, err := ParseUsernameDomain(c.input)
+		if c.invalid {
+			if err == nil {
+				t.Errorf("%q: Expected an error, got %+v", c.input, domain)
+			}
+			continue
+		}
+		if err != nil || domain != c.expected {
+			t.Errorf("%q: Expected %+v, got %+v (%v)", c.input, c.expected, domain, err)
+		}
+	}
+}
+
+func TestUsernameDomains(t *testing.T) {
+	signer, verifier, err := token.NewEphemeralSigner(token.SignerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	domains := []UsernameDomain{
+		{Domain: "corp.example.com"},
+		{Domain: "CORP"},
+		{Domain: "old.example.com", Replacement: "example.com"},
+	}
+
+	cases := []struct {
+		name      string
+		login     string
+		realm     string
+		expected  string
+		directory string
+		status    int
+	}{
+		{name: "bare login", login: "alice", expected: "alice", directory: "alice", status: http.StatusOK},
+		{name: "UPN", login: "alice@corp.example.com", expected: "alice", directory: "alice", status: http.StatusOK},
+		{name: "UPN of another case", login: "alice@CORP.Example.com", expected: "alice", directory: "alice", status: http.StatusOK},
+		{name: "down-level logon name", login: `corp\alice`, expected: "alice", directory: "alice", status: http.StatusOK},
+		{name: "remapped domain", login: "bob@old.example.com", expected: "bob", directory: "bob@example.com", status: http.StatusOK},
+		// The token still carries the canonical username.
+		{name: "UPN with a realm", login: "alice@corp.example.com", realm: "example.com", expected: "alice@example.com", directory: "alice", status: http.StatusOK},
+		// Other domains are left for the directory, which doesn't know them.
+		{name: "UPN of another domain", login: "alice@evil.example.com", directory: "alice@evil.example.com", status: http.StatusUnauthorized},
+		{name: "empty user", login: "@corp.example.com", directory: "@corp.example.com", status: http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		var asked []string
+		issuer := &LDAPTokenIssuer{
+			LDAPAuthenticator: loginDirectory{
+				users: map[string]*ldap.Entry{
+					"alice":           ldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{"uid": {"alice"}}),
+					"bob@example.com": ldap.NewEntry("uid=bob,dc=example,dc=com", map[string][]string{"uid": {"bob"}}),
+				},
+				asked: &asked,
+			},
+			TokenSigner:       signer,
+			UsernameAttribute: "uid",
+			UsernameRealm:     c.realm,
+			UsernameDomains:   domains,
+			TTL:               time.Hour,
+		}
+		req, _ := http.NewRequest("GET", "/ldapAuth", nil)
+		req.SetBasicAuth(c.login, "password")
+		rec := httptest.NewRecorder()
+		issuer.ServeHTTP(rec, req)
+
+		if len(asked) != 1 || asked[0] != c.directory {
+			t.Errorf("%s: Expected the directory to be asked for %q, got %q", c.name, c.directory, asked)
+		}
+		if rec.Code != c.status {
+			t.Errorf("%s: Expected %d, got %d: %s", c.name, c.status, rec.Code, rec.Body.String())
+			continue
+		}
+		if c.status != http.StatusOK {
+			continue
+		}
+		tok, err := verifier.Verify(rec.Body.String())
+		if err != nil {
+			t.Fatalf("%s: Failed to verify token: %v", c.name, err)
+		}
+		if tok.Username != c.expected {
+			t.Errorf("%s: Expected username %q, got %q", c.name, c.expected, tok.Username)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package auth
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/prometheus"
@@ -34,26 +36,90 @@ var (
 			Help: "Total number of requests to verify token with invalid token.",
 		},
 	)
+	missingTokenRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_missing_token_requests",
+			Help: "Total number of requests to verify token which had no token.",
+		},
+	)
+	declinedTokenRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_declined_token_requests",
+			Help: "Total number of requests to verify token which were declined because the token lacks the configured prefix.",
+		},
+	)
 	successfulVerification = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Name: "kubernetes_ldap_successful_verify_token_requests",
 			Help: "Total number of requests where verify token request succeeded.",
 		},
 	)
+	verifyFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_token_verify_failures_total",
+			Help: "Total number of tokens rejected by the webhook, by reason.",
+		},
+		[]string{"reason"},
+	)
 )
 
+// reasonWrongType is the verify failure reason for refresh tokens sent to
+// the webhook.
+const reasonWrongType = "wrong_type"
+
 //RegisterVerifyTokenMetrics registers the metrics for the token generation
 func RegisterVerifyTokenMetrics() {
 	prometheus.MustRegister(verifyTokenRequests)
 	prometheus.MustRegister(invalidMethodRequests)
 	prometheus.MustRegister(invalidTokenRequests)
 	prometheus.MustRegister(invalidJSONBody)
+	prometheus.MustRegister(missingTokenRequests)
+	prometheus.MustRegister(declinedTokenRequests)
 	prometheus.MustRegister(successfulVerification)
+	prometheus.MustRegister(verifyFailures)
+	prometheus.MustRegister(tokenCacheHits)
+	prometheus.MustRegister(tokenCacheMisses)
 }
 
 // TokenWebhook responds to requests from the K8s authentication webhook
 type TokenWebhook struct {
 	tokenVerifier token.Verifier
+
+	// TokenPrefix, if set, is required on every token. Tokens without it
+	// belong to another authenticator and are declined without an error.
+	TokenPrefix string
+
+	// AuthMethodExtraKey, if set, is the user info extra key under which
+	// the token's authentication method (its amr assertion) is passed to
+	// the API server, for admission policies to check.
+	AuthMethodExtraKey string
+
+	// ScopesExtraKey, if set, is the user info extra key under which the
+	// token's scopes are passed to the API server, for authorizers that
+	// honor them.
+	ScopesExtraKey string
+
+	// StaleExtraKey, if set, is the user info extra key set to "true" for
+	// expired tokens accepted during a grace period, for authorizers to
+	// restrict them to read-only requests.
+	StaleExtraKey string
+
+	// DisplayNameExtraKey, if set, is the user info extra key under which
+	// the token's DisplayNameAssertion, if any, is passed to the API
+	// server, e.g. for audit logs.
+	DisplayNameExtraKey  string
+	DisplayNameAssertion string
+
+	// Audiences, if set, are accepted from tokens with audiences when the
+	// review doesn't list any, i.e. the API server has no
+	// --api-audiences.
+	Audiences []string
+
+	// UnauthenticatedOnAudienceMismatch, if set, answers tokens for
+	// another audience with a clean unauthenticated review, as the
+	// TokenReview contract asks, instead of a 401, so the API server
+	// goes on to its other authenticators.
+	UnauthenticatedOnAudienceMismatch bool
 }
 
 // NewTokenWebhook returns a TokenWebhook with the given verifier
@@ -67,50 +133,147 @@ func NewTokenWebhook(verifier token.Verifier) *TokenWebhook {
 // back if the token is valid.
 func (tw *TokenWebhook) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	verifyTokenRequests.Inc()
+	reqID := requestID(resp, req)
 	if req.Method != http.MethodPost {
 		invalidMethodRequests.Inc()
-		resp.WriteHeader(http.StatusMethodNotAllowed)
+		writeError(resp, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "token reviews must be POSTed")
 		return
 	}
 
+	// Health checks probe the webhook with empty or minimal reviews, so
+	// a review that can't be read is answered as unauthenticated rather
+	// than with an error.
 	trr := &TokenReviewRequest{}
 	err := json.NewDecoder(req.Body).Decode(trr)
 	if err != nil {
 		invalidJSONBody.Inc()
-		glog.Errorf("Error unmarshalling request: %v", err)
-		resp.WriteHeader(http.StatusInternalServerError)
+		glog.Errorf("[%s] Error unmarshalling request: %v", reqID, err)
+		tw.writeReview(resp, reqID, &TokenReviewRequest{}, TokenReviewStatus{Error: "malformed TokenReview request"})
 		return
 	}
 	defer req.Body.Close()
 
+	rawToken := stripBearer(trr.Spec.Token)
+	if rawToken == "" {
+		missingTokenRequests.Inc()
+		tw.writeReview(resp, reqID, trr, TokenReviewStatus{Error: "no token provided"})
+		return
+	}
+	if tw.TokenPrefix != "" {
+		if !strings.HasPrefix(rawToken, tw.TokenPrefix) {
+			declinedTokenRequests.Inc()
+			tw.writeReview(resp, reqID, trr, TokenReviewStatus{Authenticated: false})
+			return
+		}
+		rawToken = strings.TrimPrefix(rawToken, tw.TokenPrefix)
+	}
+
 	// Verify token
-	token, err := tw.tokenVerifier.Verify(trr.Spec.Token)
+	authToken, err := tw.tokenVerifier.Verify(rawToken)
 	if err != nil {
 		invalidTokenRequests.Inc()
-		glog.Errorf("Token is invalid: %v", err)
-		resp.WriteHeader(http.StatusUnauthorized)
-		resp.Header().Add("Content-Type", "text/plain")
-		resp.Write([]byte(err.Error()))
+		verifyFailures.WithLabelValues(token.FailureReason(err)).Inc()
+		glog.Errorf("[%s] Token is invalid: %v", reqID, err)
+		code := errCodeInvalidToken
+		if errors.Is(err, token.ErrTokenExpired) {
+			code = errCodeTokenExpired
+		}
+		writeError(resp, http.StatusUnauthorized, code, err.Error())
+		return
+	}
+
+	// Refresh tokens are only good for getting a new access token.
+	if err := token.RequireType(authToken, token.TypeAccess); err != nil {
+		invalidTokenRequests.Inc()
+		verifyFailures.WithLabelValues(reasonWrongType).Inc()
+		glog.Errorf("[%s] Token is invalid: %v", reqID, err)
+		writeError(resp, http.StatusUnauthorized, errCodeWrongTokenType, "only access tokens can be used to authenticate")
+		return
+	}
+
+	// TokenReviews don't carry the certificate of the user's connection
+	// to the API server, so a binding to it can't be confirmed here.
+	if err := token.CheckCertBinding(authToken, nil); err != nil {
+		invalidTokenRequests.Inc()
+		verifyFailures.WithLabelValues(token.ReasonCertMismatch).Inc()
+		glog.Errorf("[%s] Token is invalid: it is bound to a client certificate, which TokenReviews don't carry", reqID)
+		writeError(resp, http.StatusUnauthorized, errCodeCertMismatch, "certificate-bound tokens can't be confirmed from a TokenReview")
+		return
+	}
+
+	accepted := trr.Spec.Audiences
+	if len(accepted) == 0 {
+		accepted = tw.Audiences
+	}
+	audiences, ok := matchAudiences(authToken.Audiences, accepted)
+	if !ok {
+		invalidTokenRequests.Inc()
+		verifyFailures.WithLabelValues(token.ReasonWrongAudience).Inc()
+		glog.Errorf("[%s] Token is invalid: it is for %v, expected one of %v", reqID, authToken.Audiences, accepted)
+		if tw.UnauthenticatedOnAudienceMismatch {
+			tw.writeReview(resp, reqID, trr, TokenReviewStatus{Authenticated: false})
+			return
+		}
+		writeError(resp, http.StatusUnauthorized, errCodeWrongAudience, "token was issued for another audience")
 		return
 	}
+	if len(trr.Spec.Audiences) == 0 {
+		// Only the audiences of the review are reported back.
+		audiences = nil
+	}
 
 	// Token is valid.
-	trr.Status = TokenReviewStatus{
+	successfulVerification.Inc()
+	user := UserInfo{
+		Username: authToken.Username,
+		UID:      authToken.UID,
+		Groups:   authToken.Groups,
+	}
+	if method := authToken.Assertions[token.AuthMethodAssertion]; tw.AuthMethodExtraKey != "" && method != "" {
+		user.addExtra(tw.AuthMethodExtraKey, method)
+	}
+	if tw.ScopesExtraKey != "" && len(authToken.Scopes) > 0 {
+		user.addExtra(tw.ScopesExtraKey, authToken.Scopes...)
+	}
+	if stale := authToken.Assertions[token.StaleAssertion]; tw.StaleExtraKey != "" && stale != "" {
+		user.addExtra(tw.StaleExtraKey, stale)
+	}
+	if name := authToken.Assertions[tw.DisplayNameAssertion]; tw.DisplayNameExtraKey != "" && tw.DisplayNameAssertion != "" && name != "" {
+		user.addExtra(tw.DisplayNameExtraKey, name)
+	}
+	tw.writeReview(resp, reqID, trr, TokenReviewStatus{
 		Authenticated: true,
-		User: UserInfo{
-			Username: token.Username,
-			Groups:   token.Groups,
-		},
+		User:          user,
+		Audiences:     audiences,
+	})
+}
+
+// stripBearer removes whitespace and a leading "Bearer " around a token,
+// which some clients mistakenly send, copied from an Authorization
+// header, as the TokenReview's token.
+func stripBearer(rawToken string) string {
+	rawToken = strings.TrimSpace(rawToken)
+	const bearer = "bearer"
+	if len(rawToken) < len(bearer) || !strings.EqualFold(rawToken[:len(bearer)], bearer) {
+		return rawToken
+	}
+	if rest := rawToken[len(bearer):]; rest == "" || rest[0] == ' ' || rest[0] == '\t' {
+		return strings.TrimSpace(rest)
 	}
+	return rawToken
+}
+
+// writeReview sends the TokenReview back with the given status.
+func (tw *TokenWebhook) writeReview(resp http.ResponseWriter, reqID string, trr *TokenReviewRequest, status TokenReviewStatus) {
+	trr.Status = status
 
 	respJSON, err := json.Marshal(trr)
 	if err != nil {
-		glog.Errorf("Error marshalling response: %v", err)
-		resp.WriteHeader(http.StatusInternalServerError)
+		glog.Errorf("[%s] Error marshalling response: %v", reqID, err)
+		writeError(resp, http.StatusInternalServerError, errCodeInternal, "error marshalling response")
 		return
 	}
 
-	successfulVerification.Inc()
 	resp.Header().Add("Content-Type", "application/json")
 	resp.Write(respJSON)
 }
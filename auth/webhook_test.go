@@ -6,18 +6,22 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 
+	"github.com/go-ldap/ldap/v3"
 	"github.com/proofpoint/kubernetes-ldap/token"
-	"io/ioutil"
+	"time"
 )
 
 type dummyVerifier struct {
-	token *token.AuthToken
-	err   error
+	token    *token.AuthToken
+	err      error
+	verified []string
 }
 
 func (dv *dummyVerifier) Verify(s string) (token *token.AuthToken, err error) {
+	dv.verified = append(dv.verified, s)
 	return dv.token, dv.err
 }
 
@@ -29,7 +33,8 @@ func TestWebhook(t *testing.T) {
 		verifyErr            error
 		authenticated        bool
 		expectedCode         int
-		expectedResponseBody string
+		expectedErrorCode    string
+		expectedErrorMessage string
 	}{
 		{
 			// Happy path. Token is valid
@@ -46,20 +51,23 @@ func TestWebhook(t *testing.T) {
 			verifyErr:            errors.New("Invalid token provided"),
 			authenticated:        false,
 			expectedCode:         http.StatusUnauthorized,
-			expectedResponseBody: "Invalid token provided",
+			expectedErrorCode:    "invalid_token",
+			expectedErrorMessage: "Invalid token provided",
 		},
 		{
 			// The token provided by user has expired
 			reqMethod:            "POST",
-			verifyErr:            errors.New("token has expired"),
+			verifyErr:            token.ErrTokenExpired,
 			authenticated:        false,
 			expectedCode:         http.StatusUnauthorized,
-			expectedResponseBody: "token has expired",
+			expectedErrorCode:    "token_expired",
+			expectedErrorMessage: "token has expired",
 		},
 		{
 			// Incorrect method used on endpoint
-			reqMethod:    "GET",
-			expectedCode: http.StatusMethodNotAllowed,
+			reqMethod:         "GET",
+			expectedCode:      http.StatusMethodNotAllowed,
+			expectedErrorCode: "method_not_allowed",
 		},
 	}
 
@@ -89,12 +97,17 @@ func TestWebhook(t *testing.T) {
 			t.Errorf("Case: %d: Expected '%d' from server. Got '%d", i, c.expectedCode, rec.Code)
 		}
 
-		//assertion for response body when returning 401
-		if rec.Code == http.StatusUnauthorized {
-			body, _ := ioutil.ReadAll(rec.Body)
-			actualResponseBody := string(body)
-			if actualResponseBody != c.expectedResponseBody {
-				t.Errorf("expected response %s, actual response %s", c.expectedResponseBody, actualResponseBody)
+		// assertion for the error response body
+		if c.expectedErrorCode != "" {
+			errResp := &errorResponse{}
+			if err := json.NewDecoder(rec.Body).Decode(errResp); err != nil {
+				t.Errorf("Case: %d: Error decoding error response: %v", i, err)
+			}
+			if errResp.Error.Code != c.expectedErrorCode {
+				t.Errorf("Case: %d: Expected error code %q, got %q", i, c.expectedErrorCode, errResp.Error.Code)
+			}
+			if c.expectedErrorMessage != "" && errResp.Error.Message != c.expectedErrorMessage {
+				t.Errorf("Case: %d: Expected error message %q, got %q", i, c.expectedErrorMessage, errResp.Error.Message)
 			}
 		}
 
@@ -115,3 +128,220 @@ func TestWebhook(t *testing.T) {
 		}
 	}
 }
+
+func TestWebhookUnreviewableRequests(t *testing.T) {
+	cases := []struct {
+		name          string
+		body          string
+		expectedError string
+	}{
+		{
+			name:          "empty body",
+			body:          "",
+			expectedError: "malformed TokenReview request",
+		},
+		{
+			name:          "garbage body",
+			body:          "not a TokenReview",
+			expectedError: "malformed TokenReview request",
+		},
+		{
+			name:          "empty token",
+			body:          `{"kind": "TokenReview", "spec": {"token": ""}}`,
+			expectedError: "no token provided",
+		},
+		{
+			name:          "no spec",
+			body:          `{"kind": "TokenReview"}`,
+			expectedError: "no token provided",
+		},
+	}
+
+	for _, c := range cases {
+		v := &dummyVerifier{token: &token.AuthToken{Username: "username"}}
+		tw := NewTokenWebhook(v)
+
+		req, err := http.NewRequest("POST", "", bytes.NewReader([]byte(c.body)))
+		if err != nil {
+			t.Fatalf("%s: Error creating request: %v", c.name, err)
+		}
+		rec := httptest.NewRecorder()
+		tw.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: Expected %d, got %d", c.name, http.StatusOK, rec.Code)
+		}
+		trr := &TokenReviewRequest{}
+		if err := json.NewDecoder(rec.Body).Decode(trr); err != nil {
+			t.Fatalf("%s: Error decoding response: %v", c.name, err)
+		}
+		if trr.Status.Authenticated {
+			t.Errorf("%s: Expected the request not to be authenticated", c.name)
+		}
+		if trr.Status.Error != c.expectedError {
+			t.Errorf("%s: Expected error %q, got %q", c.name, c.expectedError, trr.Status.Error)
+		}
+		if len(v.verified) != 0 {
+			t.Errorf("%s: Expected no token to be verified, got %v", c.name, v.verified)
+		}
+	}
+}
+
+func TestWebhookIncludesExtraGroups(t *testing.T) {
+	lti := LDAPTokenIssuer{
+		TTL:         time.Hour,
+		ExtraGroups: []string{"system:authenticated-ldap"},
+	}
+	e := &ldap.Entry{
+		DN: "some-dn",
+		Attributes: []*ldap.EntryAttribute{
+			{
+				Name:   "memberOf",
+				Values: []string{"cn=sg-grp1,ou=Groups,dc=example,dc=com"},
+			},
+		},
+	}
+
+	tw := NewTokenWebhook(&dummyVerifier{token: lti.createToken(e)})
+
+	trrJSON, _ := json.Marshal(&TokenReviewRequest{Spec: TokenReviewSpec{Token: "someToken"}})
+	req, err := http.NewRequest("POST", "", bytes.NewReader(trrJSON))
+	if err != nil {
+		t.Fatalf("Error creating request: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	tw.ServeHTTP(rec, req)
+
+	trr := &TokenReviewRequest{}
+	if err := json.NewDecoder(rec.Body).Decode(trr); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+
+	expected := []string{"sg-grp1", "system:authenticated-ldap"}
+	if !reflect.DeepEqual(trr.Status.User.Groups, expected) {
+		t.Errorf("Expected groups %v, got %v", expected, trr.Status.User.Groups)
+	}
+}
+
+func TestWebhookTokenPrefix(t *testing.T) {
+	cases := []struct {
+		name                  string
+		token                 string
+		expectedAuthenticated bool
+		expectedVerified      []string
+	}{
+		{
+			name:                  "prefixed token is stripped and verified",
+			token:                 "ldap:someToken",
+			expectedAuthenticated: true,
+			expectedVerified:      []string{"someToken"},
+		},
+		{
+			name:                  "unprefixed token is declined without verification",
+			token:                 "someToken",
+			expectedAuthenticated: false,
+		},
+		{
+			name:                  "token with another authenticator's prefix is declined",
+			token:                 "oidc:someToken",
+			expectedAuthenticated: false,
+		},
+	}
+
+	for _, c := range cases {
+		v := &dummyVerifier{token: &token.AuthToken{Username: "username"}}
+		tw := NewTokenWebhook(v)
+		tw.TokenPrefix = "ldap:"
+
+		trrJSON, _ := json.Marshal(&TokenReviewRequest{Spec: TokenReviewSpec{Token: c.token}})
+		req, err := http.NewRequest("POST", "", bytes.NewReader(trrJSON))
+		if err != nil {
+			t.Fatalf("%s: Error creating request: %v", c.name, err)
+		}
+
+		rec := httptest.NewRecorder()
+		tw.ServeHTTP(rec, req)
+
+		// Declining is not an error: other authenticators get to try.
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: Expected %d, got %d", c.name, http.StatusOK, rec.Code)
+		}
+
+		trr := &TokenReviewRequest{}
+		if err := json.NewDecoder(rec.Body).Decode(trr); err != nil {
+			t.Fatalf("%s: Error decoding response: %v", c.name, err)
+		}
+		if trr.Status.Authenticated != c.expectedAuthenticated {
+			t.Errorf("%s: Expected authenticated %t, got %t", c.name, c.expectedAuthenticated, trr.Status.Authenticated)
+		}
+		if !reflect.DeepEqual(v.verified, c.expectedVerified) {
+			t.Errorf("%s: Expected verified tokens %v, got %v", c.name, c.expectedVerified, v.verified)
+		}
+	}
+}
+
+func TestWebhookBearerPrefix(t *testing.T) {
+	cases := []struct {
+		name             string
+		tokenPrefix      string
+		token            string
+		expectedVerified []string
+	}{
+		{name: "plain token", token: "someToken", expectedVerified: []string{"someToken"}},
+		{name: "Bearer prefix", token: "Bearer someToken", expectedVerified: []string{"someToken"}},
+		{name: "lower case prefix", token: "bearer someToken", expectedVerified: []string{"someToken"}},
+		{name: "surrounding whitespace", token: " \tBEARER   someToken\n", expectedVerified: []string{"someToken"}},
+		{name: "Bearer before the token prefix", tokenPrefix: "ldap:", token: "Bearer ldap:someToken", expectedVerified: []string{"someToken"}},
+		{name: "Bearer alone", token: "Bearer ", expectedVerified: nil},
+		{name: "Bearer without a space is kept", token: "BearersomeToken", expectedVerified: []string{"BearersomeToken"}},
+	}
+
+	for _, c := range cases {
+		v := &dummyVerifier{token: &token.AuthToken{Username: "username"}}
+		tw := NewTokenWebhook(v)
+		tw.TokenPrefix = c.tokenPrefix
+
+		trrJSON, _ := json.Marshal(&TokenReviewRequest{Spec: TokenReviewSpec{Token: c.token}})
+		req, err := http.NewRequest("POST", "", bytes.NewReader(trrJSON))
+		if err != nil {
+			t.Fatalf("%s: Error creating request: %v", c.name, err)
+		}
+
+		rec := httptest.NewRecorder()
+		tw.ServeHTTP(rec, req)
+
+		if !reflect.DeepEqual(v.verified, c.expectedVerified) {
+			t.Errorf("%s: Expected verified tokens %v, got %v", c.name, c.expectedVerified, v.verified)
+		}
+	}
+}
+
+func TestWebhookStaleToken(t *testing.T) {
+	cases := []struct {
+		name          string
+		assertions    map[string]string
+		expectedExtra map[string][]string
+	}{
+		{name: "fresh token", assertions: map[string]string{}},
+		{
+			name:          "stale token",
+			assertions:    map[string]string{token.StaleAssertion: "true"},
+			expectedExtra: map[string][]string{"kubernetes-ldap/stale": {"true"}},
+		},
+	}
+
+	for _, c := range cases {
+		tw := NewTokenWebhook(&dummyVerifier{token: &token.AuthToken{Username: "alice", Assertions: c.assertions}})
+		tw.StaleExtraKey = "kubernetes-ldap/stale"
+
+		trr := &TokenReviewRequest{}
+		json.Unmarshal(reviewToken(tw, "someToken").Body.Bytes(), trr)
+		if !trr.Status.Authenticated {
+			t.Errorf("%s: Expected the token to be authenticated", c.name)
+		}
+		if !reflect.DeepEqual(trr.Status.User.Extra, c.expectedExtra) {
+			t.Errorf("%s: Expected extra %v, got %v", c.name, c.expectedExtra, trr.Status.User.Extra)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+var (
+	whoAmIRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_whoami_requests",
+			Help: "Total number of requests to describe the identity of a token.",
+		},
+	)
+	invalidWhoAmIRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_invalid_whoami_token",
+			Help: "Total number of requests to describe a missing, invalid or non-access token.",
+		},
+	)
+)
+
+// RegisterWhoAmIMetrics registers the metrics for the whoami endpoint.
+func RegisterWhoAmIMetrics() {
+	prometheus.MustRegister(whoAmIRequests)
+	prometheus.MustRegister(invalidWhoAmIRequests)
+}
+
+// WhoAmIHandler verifies an access token, sent as a bearer token, and
+// describes the identity it grants, like `kubectl auth whoami`.
+type WhoAmIHandler struct {
+	TokenVerifier token.Verifier
+	// TokenPrefix is expected on the token, as for TokenWebhook.
+	TokenPrefix string
+}
+
+// whoAmIResponse is what WhoAmIHandler returns about a token. It never
+// includes the token itself.
+type whoAmIResponse struct {
+	Username            string            `json:"username"`
+	UID                 string            `json:"uid,omitempty"`
+	Groups              []string          `json:"groups"`
+	Assertions          map[string]string `json:"assertions,omitempty"`
+	Scopes              []string          `json:"scopes,omitempty"`
+	IssuedAtTimestamp   int64             `json:"issuedAtTimestamp,omitempty"`
+	ExpirationTimestamp int64             `json:"expirationTimestamp"`
+}
+
+func (wh *WhoAmIHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	whoAmIRequests.Inc()
+	reqID := requestID(resp, req)
+	if req.Method != http.MethodGet {
+		writeError(resp, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "whoami requests must use GET")
+		return
+	}
+
+	authorization := req.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		invalidWhoAmIRequests.Inc()
+		writeError(resp, http.StatusUnauthorized, errCodeMissingCredentials, "a bearer token is required")
+		return
+	}
+	rawToken := strings.TrimPrefix(authorization, "Bearer ")
+	if wh.TokenPrefix != "" {
+		if !strings.HasPrefix(rawToken, wh.TokenPrefix) {
+			invalidWhoAmIRequests.Inc()
+			writeError(resp, http.StatusUnauthorized, errCodeInvalidToken, "token was not issued by this server")
+			return
+		}
+		rawToken = strings.TrimPrefix(rawToken, wh.TokenPrefix)
+	}
+
+	accessToken, err := wh.TokenVerifier.Verify(rawToken)
+	if err != nil {
+		invalidWhoAmIRequests.Inc()
+		glog.Errorf("[%s] Token is invalid: %v", reqID, err)
+		code := errCodeInvalidToken
+		if errors.Is(err, token.ErrTokenExpired) {
+			code = errCodeTokenExpired
+		}
+		writeError(resp, http.StatusUnauthorized, code, err.Error())
+		return
+	}
+
+	if err := token.RequireType(accessToken, token.TypeAccess); err != nil {
+		invalidWhoAmIRequests.Inc()
+		glog.Errorf("[%s] Token is invalid: %v", reqID, err)
+		writeError(resp, http.StatusUnauthorized, errCodeWrongTokenType, "only access tokens grant an identity")
+		return
+	}
+
+	if err := token.CheckCertBinding(accessToken, peerCertificates(req)); err != nil {
+		invalidWhoAmIRequests.Inc()
+		glog.Errorf("[%s] Token is invalid: %v", reqID, err)
+		writeError(resp, http.StatusUnauthorized, errCodeCertMismatch, "token is bound to another client certificate")
+		return
+	}
+
+	groups := accessToken.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	jsondata, err := json.Marshal(whoAmIResponse{
+		Username:            accessToken.Username,
+		UID:                 accessToken.UID,
+		Groups:              groups,
+		Assertions:          accessToken.Assertions,
+		Scopes:              accessToken.Scopes,
+		IssuedAtTimestamp:   accessToken.IssuedAt,
+		ExpirationTimestamp: accessToken.Expiration,
+	})
+	if err != nil {
+		glog.Errorf("[%s] Error marshalling json %s", reqID, err.Error())
+		writeError(resp, http.StatusInternalServerError, errCodeInternal, "error marshalling response")
+		return
+	}
+
+	resp.Header().Add("Content-Type", "application/json")
+	resp.Write(jsondata)
+}
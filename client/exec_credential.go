@@ -0,0 +1,82 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	execCredentialAPIVersion = "client.authentication.k8s.io/v1"
+	execCredentialKind       = "ExecCredential"
+)
+
+// ExecCredential is the object printed for kubectl's exec credential
+// plugin mechanism.
+type ExecCredential struct {
+	APIVersion string               `json:"apiVersion"`
+	Kind       string               `json:"kind"`
+	Status     ExecCredentialStatus `json:"status"`
+}
+
+// ExecCredentialStatus holds the token and its expiry. kubectl caches
+// the token until expirationTimestamp.
+type ExecCredentialStatus struct {
+	Token               string `json:"token"`
+	ExpirationTimestamp string `json:"expirationTimestamp,omitempty"`
+}
+
+// tokenResponse is the JSON body returned by /ldapAuth when the client
+// asks for application/json.
+type tokenResponse struct {
+	Token               string `json:"token"`
+	ExpirationTimestamp int64  `json:"expirationTimestamp"`
+}
+
+// NewExecCredential builds an ExecCredential for the token, which
+// expires at the given unix time in milliseconds.
+func NewExecCredential(token string, expirationMillis int64) *ExecCredential {
+	ec := &ExecCredential{
+		APIVersion: execCredentialAPIVersion,
+		Kind:       execCredentialKind,
+		Status: ExecCredentialStatus{
+			Token: token,
+		},
+	}
+	if expirationMillis > 0 {
+		expiration := time.Unix(0, expirationMillis*int64(time.Millisecond))
+		ec.Status.ExpirationTimestamp = expiration.UTC().Format(time.RFC3339)
+	}
+	return ec
+}
+
+// RequestExecCredential authenticates against the kubernetes-ldap server's
+// /ldapAuth endpoint and returns the issued token as an ExecCredential.
+func RequestExecCredential(httpClient *http.Client, url, username, password string) (*ExecCredential, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating token request")
+	}
+	req.SetBasicAuth(username, password)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "requesting token")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("requesting token: server returned %s", resp.Status)
+	}
+
+	tr := &tokenResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(tr); err != nil {
+		return nil, errors.Wrap(err, "decoding token response")
+	}
+
+	return NewExecCredential(tr.Token, tr.ExpirationTimestamp), nil
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewExecCredential(t *testing.T) {
+	expiration := time.Date(2020, 10, 1, 12, 30, 0, 0, time.UTC)
+	millis := expiration.UnixNano() / int64(time.Millisecond)
+
+	data, err := json.Marshal(NewExecCredential("signedToken", millis))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"apiVersion": "client.authentication.k8s.io/v1",
+		"kind": "ExecCredential",
+		"status": {
+			"token": "signedToken",
+			"expirationTimestamp": "2020-10-01T12:30:00Z"
+		}
+	}`, string(data))
+}
+
+func TestNewExecCredentialWithoutExpiration(t *testing.T) {
+	data, err := json.Marshal(NewExecCredential("signedToken", 0))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"apiVersion": "client.authentication.k8s.io/v1",
+		"kind": "ExecCredential",
+		"status": {"token": "signedToken"}
+	}`, string(data))
+}
+
+func TestRequestExecCredential(t *testing.T) {
+	expiration := time.Date(2020, 10, 1, 12, 30, 0, 0, time.UTC)
+	millis := expiration.UnixNano() / int64(time.Millisecond)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "alice" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, "application/json", r.Header.Get("Accept"))
+		fmt.Fprintf(w, `{"token":"signedToken","expirationTimestamp":%d}`, millis)
+	}))
+	defer srv.Close()
+
+	testcases := []struct {
+		name       string
+		password   string
+		msg        string
+		expiration string
+	}{
+		{
+			name:       "valid credentials",
+			password:   "secret",
+			expiration: "2020-10-01T12:30:00Z",
+		},
+		{
+			name:     "invalid credentials",
+			password: "wrong",
+			msg:      "requesting token: server returned 401 Unauthorized",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ec, err := RequestExecCredential(srv.Client(), srv.URL, "alice", tc.password)
+			if tc.msg != "" {
+				assert.EqualError(t, err, tc.msg)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "signedToken", ec.Status.Token)
+			assert.Equal(t, tc.expiration, ec.Status.ExpirationTimestamp)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/proofpoint/kubernetes-ldap/auth"
+	"github.com/proofpoint/kubernetes-ldap/token"
+	"github.com/spf13/viper"
+)
+
+// assertionMappingConfig is one entry of the "assertion-mappings" list in
+// the config file.
+type assertionMappingConfig struct {
+	Assertion  string   `mapstructure:"assertion"`
+	Attribute  string   `mapstructure:"attribute"`
+	Transforms []string `mapstructure:"transforms"`
+}
+
+// reservedAssertions are set by the token issuer itself and can't be
+// mapped from the directory.
+var reservedAssertions = map[string]bool{
+	"ldapServer":              true,
+	"userDN":                  true,
+	"elevated":                true,
+	"groupsTruncated":         true,
+	"groupsIncomplete":        true,
+	token.AuthMethodAssertion: true,
+	token.StaleAssertion:      true,
+}
+
+// serverAssertionFlag is a flag naming an assertion the server sets.
+type serverAssertionFlag struct {
+	flag, assertion string
+}
+
+// serverAssertionFlags returns the flags naming an assertion the server
+// sets, with the assertion each names, if any.
+func serverAssertionFlags() []serverAssertionFlag {
+	return []serverAssertionFlag{
+		{"dn-assertion", dnAssertion},
+		{"auth-time-assertion", authTimeAssertion},
+		{"server-assertion", serverAssertion},
+		{"display-name-assertion", displayNameAssertion},
+		{"account-type-assertion", accountTypeAssertion},
+		{"original-groups-assertion", originalGroupsAssertion},
+	}
+}
+
+// serverSetAssertions returns the names of the assertions the server is
+// configured to set by the flags, besides the reservedAssertions.
+func serverSetAssertions() map[string]bool {
+	names := make(map[string]bool)
+	for _, f := range serverAssertionFlags() {
+		if f.assertion != "" {
+			names[f.assertion] = true
+		}
+	}
+	return names
+}
+
+// checkServerAssertions checks that no two flags name the same
+// assertion, and that none names a reserved one.
+func checkServerAssertions() error {
+	seen := make(map[string]bool)
+	for _, f := range serverAssertionFlags() {
+		if f.assertion == "" {
+			continue
+		}
+		if reservedAssertions[f.assertion] || seen[f.assertion] {
+			return fmt.Errorf("--%s: the %q assertion is already set by the server", f.flag, f.assertion)
+		}
+		seen[f.assertion] = true
+	}
+	return nil
+}
+
+// loadAssertionMappings reads and validates the assertion mappings from
+// the config file.
+func loadAssertionMappings() ([]auth.AssertionMapping, error) {
+	var configs []assertionMappingConfig
+	if err := viper.UnmarshalKey("assertion-mappings", &configs); err != nil {
+		return nil, fmt.Errorf("reading assertion-mappings: %v", err)
+	}
+
+	mappings := make([]auth.AssertionMapping, 0, len(configs))
+	for i, mc := range configs {
+		if mc.Assertion == "" || mc.Attribute == "" {
+			return nil, fmt.Errorf("assertion mapping %d: assertion and attribute are required", i)
+		}
+		if reservedAssertions[mc.Assertion] || serverSetAssertions()[mc.Assertion] {
+			return nil, fmt.Errorf("assertion mapping %d: the %q assertion is set by the server", i, mc.Assertion)
+		}
+
+		m := auth.AssertionMapping{Assertion: mc.Assertion, Attribute: mc.Attribute}
+		for _, spec := range mc.Transforms {
+			transform, err := auth.ParseTransform(spec)
+			if err != nil {
+				return nil, fmt.Errorf("assertion mapping %q: %v", mc.Assertion, err)
+			}
+			m.Transforms = append(m.Transforms, transform)
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, nil
+}
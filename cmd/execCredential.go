@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/proofpoint/kubernetes-ldap/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	execServerURL      string
+	execUsername       string
+	execPassword       string
+	execSkipTLSVerify  bool
+	execRequestTimeout time.Duration
+)
+
+// execCredentialCmd represents the exec-credential command
+var execCredentialCmd = &cobra.Command{
+	Use:   "exec-credential",
+	Short: "authenticate against the kubernetes-ldap server and print a kubectl ExecCredential",
+	Long: `exec-credential requests a token from the /ldapAuth endpoint and prints it
+as a client.authentication.k8s.io/v1 ExecCredential, for use as a kubectl exec
+credential plugin. The password is read from $KUBERNETES_LDAP_PASSWORD when
+--password is not set.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		requireFlag("--server", execServerURL)
+		requireFlag("--username", execUsername)
+
+		password := execPassword
+		if password == "" {
+			password = os.Getenv("KUBERNETES_LDAP_PASSWORD")
+		}
+
+		httpClient := &http.Client{
+			Timeout: execRequestTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: execSkipTLSVerify},
+			},
+		}
+
+		ec, err := client.RequestExecCredential(httpClient, execServerURL, execUsername, password)
+		if err != nil {
+			glog.Fatalf("Error getting credential: %v", err)
+		}
+
+		if err := json.NewEncoder(os.Stdout).Encode(ec); err != nil {
+			glog.Fatalf("Error printing credential: %v", err)
+		}
+	},
+}
+
+func init() {
+	execCredentialCmd.Flags().StringVar(&execServerURL, "server", "", "(Required) URL of the kubernetes-ldap token endpoint (e.g.: https://ldap-webhook:4000/ldapAuth)")
+	execCredentialCmd.Flags().StringVar(&execUsername, "username", "", "(Required) LDAP username")
+	execCredentialCmd.Flags().StringVar(&execPassword, "password", "", "LDAP password (defaults to $KUBERNETES_LDAP_PASSWORD)")
+	execCredentialCmd.Flags().BoolVar(&execSkipTLSVerify, "insecure-skip-tls-verify", false, "Skip verification of the server's certificate")
+	execCredentialCmd.Flags().DurationVar(&execRequestTimeout, "timeout", 30*time.Second, "Timeout for the token request")
+
+	RootCmd.AddCommand(execCredentialCmd)
+}
@@ -8,6 +8,8 @@ import (
 	"os"
 )
 
+var genKeypairAlgorithm string
+
 // genKeypairCmd represents the genKeypair command
 var genKeypairCmd = &cobra.Command{
 	Use:   "gen-keypair",
@@ -15,7 +17,7 @@ var genKeypairCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		os.MkdirAll(keypairDir, 0700)
 
-		if err := token.GenerateKeypair(keypairDir); err != nil {
+		if err := token.GenerateKeypairForAlgorithm(keypairDir, genKeypairAlgorithm); err != nil {
 			glog.Fatalf("Error generating key pair: %v", err)
 		}
 		fmt.Printf("Generated keypair in %s\n", keypairDir)
@@ -24,4 +26,5 @@ var genKeypairCmd = &cobra.Command{
 
 func init() {
 	RootCmd.AddCommand(genKeypairCmd)
+	genKeypairCmd.Flags().StringVar(&genKeypairAlgorithm, "algorithm", token.AlgorithmES256, "Signature algorithm of the keypair: ES256 or EdDSA")
 }
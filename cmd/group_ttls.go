@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+)
+
+// parseGroupTTLs parses the group-ttls of the config file, from group
+// names to durations.
+func parseGroupTTLs(values map[string]string) (map[string]time.Duration, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	ttls := make(map[string]time.Duration, len(values))
+	for group, value := range values {
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("group-ttls: invalid ttl %q for group %q: %v", value, group, err)
+		}
+		if ttl <= 0 {
+			return nil, fmt.Errorf("group-ttls: the ttl of group %q must be positive", group)
+		}
+		ttls[group] = ttl
+	}
+	return ttls, nil
+}
+
+// issuedGroupTTLs returns groupTTLs for matching the groups of issued
+// tokens, which carry --group-prefix on the groups from the directory.
+func issuedGroupTTLs() map[string]time.Duration {
+	if groupPrefix == "" || len(groupTTLs) == 0 {
+		return groupTTLs
+	}
+	ttls := make(map[string]time.Duration, 2*len(groupTTLs))
+	for group, ttl := range groupTTLs {
+		ttls[group] = ttl
+		ttls[groupPrefix+group] = ttl
+	}
+	return ttls
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+// hsmSigner and hsmPublicKey hold the --pkcs11-library key, opened with
+// hsmOptions. They are kept across config reloads that don't change the
+// options, rather than opening a new session on the module every time.
+// hsmOpened is when the key was opened, which /readyz reports as its
+// creation since the HSM doesn't tell.
+var (
+	hsmOptions   token.PKCS11Options
+	hsmSigner    token.Signer
+	hsmPublicKey *ecdsa.PublicKey
+	hsmOpened    time.Time
+)
+
+// newHSMTokenKeys returns a signer of tokens with the key in the HSM and
+// a verifier of tokens signed with it, writing its public key to
+// --pkcs11-public-key-file if set.
+func newHSMTokenKeys(signerOptions token.SignerOptions) (token.Signer, token.Verifier, error) {
+	pin, err := readBearerTokenFile(pkcs11PinFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading --pkcs11-pin-file: %v", err)
+	}
+	options := token.PKCS11Options{
+		Library:  pkcs11Library,
+		Slot:     pkcs11Slot,
+		KeyLabel: pkcs11KeyLabel,
+		PIN:      pin,
+	}
+	if hsmSigner == nil || options != hsmOptions {
+		signer, publicKey, err := token.NewPKCS11Signer(options, signerOptions)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error opening PKCS #11 key %q: %v", pkcs11KeyLabel, err)
+		}
+		glog.Infof("Signing tokens with the PKCS #11 key %q in slot %d of %s", pkcs11KeyLabel, pkcs11Slot, pkcs11Library)
+		hsmOptions, hsmSigner, hsmPublicKey = options, signer, publicKey
+		hsmOpened = time.Now()
+	}
+
+	if pkcs11PublicKeyFile != "" {
+		if err := token.WritePublicKey(pkcs11PublicKeyFile, hsmPublicKey); err != nil {
+			return nil, nil, fmt.Errorf("Error exporting the PKCS #11 public key: %v", err)
+		}
+	}
+	var tokenVerifier token.Verifier
+	if jwksURL != "" {
+		tokenVerifier, err = token.NewJWKSVerifier(jwksURL, jwksRefreshInterval, jwksFetchOptions())
+	} else {
+		tokenVerifier, err = token.NewVerifierForKey(hsmPublicKey, backupVerificationKeys)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating token verifier: %v", err)
+	}
+	return hsmSigner, tokenVerifier, nil
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/proofpoint/kubernetes-ldap/token"
+	"github.com/spf13/cobra"
+)
+
+var (
+	inspectTokenPrefix        string
+	inspectTokenEncryptionDir string
+)
+
+// inspectedToken is what inspect-token prints.
+type inspectedToken struct {
+	Expired bool             `json:"expired"`
+	Token   *token.AuthToken `json:"token"`
+}
+
+// inspectTokenCmd represents the inspect-token command
+var inspectTokenCmd = &cobra.Command{
+	Use:   "inspect-token [token]",
+	Short: "verify a token's signature and print its claims, even if it has expired",
+	Long: `inspect-token checks a token against the public key in --keypair-dir and
+prints its claims along with whether it has expired, to help debug RBAC
+issues with tokens that are no longer accepted. The token is read from stdin
+when it is not given as an argument. This is a debugging aid only: the
+/authenticate webhook always rejects expired tokens.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var s string
+		if len(args) == 1 {
+			s = args[0]
+		} else {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				glog.Fatalf("Error reading token from stdin: %v", err)
+			}
+			s = line
+		}
+		s = strings.TrimPrefix(strings.TrimSpace(s), inspectTokenPrefix)
+
+		inspector, err := token.NewInspector(keypairDir)
+		if err != nil {
+			glog.Fatalf("Error loading verification key from %q: %v", keypairDir, err)
+		}
+		if inspectTokenEncryptionDir != "" {
+			inspector, err = token.NewDecryptingInspector(inspector, inspectTokenEncryptionDir)
+			if err != nil {
+				glog.Fatalf("Error loading decryption key from %q: %v", inspectTokenEncryptionDir, err)
+			}
+		}
+
+		tok, expired, err := inspector.Inspect(s)
+		if err != nil {
+			glog.Fatalf("Error verifying token: %v", err)
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(&inspectedToken{Expired: expired, Token: tok}); err != nil {
+			glog.Fatalf("Error printing token: %v", err)
+		}
+	},
+}
+
+func init() {
+	inspectTokenCmd.Flags().StringVar(&inspectTokenPrefix, "token-prefix", "", "Prefix to strip from the token, as configured with the server's --token-prefix")
+	inspectTokenCmd.Flags().StringVar(&inspectTokenEncryptionDir, "token-encryption-keypair-dir", "", "Keypair the token is encrypted to, as configured with the server's --token-encryption-keypair-dir")
+	RootCmd.AddCommand(inspectTokenCmd)
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+// minKeyRotationBearerTokenLength is the shortest bearer token
+// /rotateKey accepts, since guessing it invalidates every token.
+const minKeyRotationBearerTokenLength = 32
+
+// keyRotator holds the --key-rotation-bearer-token-file keys. It is kept
+// across config reloads, so that a reload doesn't revert a rotation.
+var keyRotator *token.KeyRotator
+
+// newKeyRotator returns the rotator of the signing keys, wrapping signer
+// and verifier the first time it is called.
+func newKeyRotator(signer token.Signer, verifier token.Verifier) *token.KeyRotator {
+	if keyRotator == nil {
+		overlap := keyRotationOverlap
+		if overlap == 0 {
+			overlap = tokenTtl
+		}
+		keyRotator = token.NewKeyRotator(signer, verifier, token.SignerOptions{CompressionThreshold: tokenCompressionThreshold}, overlap)
+		glog.Warningf("*** /rotateKey is enabled: anyone with the --key-rotation-bearer-token-file token can replace the signing key, with one that is lost when the process exits ***")
+	}
+	return keyRotator
+}
+
+// readKeyRotationBearerToken reads the token /rotateKey requires,
+// refusing one short enough to guess.
+func readKeyRotationBearerToken() (string, error) {
+	clientToken, err := readBearerTokenFile(keyRotationBearerTokenFile)
+	if err != nil {
+		return "", err
+	}
+	if len(clientToken) < minKeyRotationBearerTokenLength {
+		return "", fmt.Errorf("bearer token in %s must be at least %d characters", keyRotationBearerTokenFile, minKeyRotationBearerTokenLength)
+	}
+	return clientToken, nil
+}
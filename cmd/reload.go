@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/proofpoint/kubernetes-ldap/auth"
+	"github.com/proofpoint/kubernetes-ldap/ldap"
+	"github.com/proofpoint/kubernetes-ldap/token"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// configReloader re-reads the config file and rebuilds the server's
+// endpoints on SIGHUP. If the new configuration doesn't load, the
+// previous one stays in place.
+type configReloader struct {
+	handler *auth.ReloadableHandler
+	flags   *pflag.FlagSet
+
+	// configFile is the config file in use, if any, and applied its
+	// contents when the configuration was last applied.
+	configFile string
+	applied    []byte
+
+	// build creates the endpoints from the loaded configuration. It is
+	// newHandler, except in tests.
+	build func() (http.Handler, error)
+
+	// mu serializes reloads, which share the global configuration.
+	mu sync.Mutex
+}
+
+// listenerConfig is the part of the configuration that is only used when
+// the listeners start, and so can't be changed by a reload.
+type listenerConfig struct {
+	port                    uint
+	tlsCertFile, tlsKeyFile string
+	tlsClientCAFile         string
+	tlsClientNames          string
+	tlsMinVersion           string
+	tlsCipherSuites         string
+	metricsPort             uint
+	metricsAddress          string
+	metricsBearerTokenFile  string
+	metricsClientCAFile     string
+	devMode                 bool
+}
+
+func currentListenerConfig() listenerConfig {
+	return listenerConfig{
+		port:                   serverPort,
+		tlsCertFile:            serverTlsCertFile,
+		tlsKeyFile:             serverTlsPrivateKeyFile,
+		tlsClientCAFile:        serverTLSClientCAFile,
+		tlsClientNames:         strings.Join(serverTLSClientNames, ","),
+		tlsMinVersion:          serverTLSMinVersion,
+		tlsCipherSuites:        strings.Join(serverTLSCipherSuites, ","),
+		metricsPort:            metricsPort,
+		metricsAddress:         metricsAddress,
+		metricsBearerTokenFile: metricsBearerTokenFile,
+		metricsClientCAFile:    metricsClientCAFile,
+		devMode:                devMode,
+	}
+}
+
+// handlerState holds the components of the endpoints that keep state
+// between requests, and the settings they were created with. A reload
+// reuses those whose settings haven't changed, so that users keep their
+// rate limits and the issued token log and token cache their contents.
+// The LDAP client is rebuilt every time, and the one it replaces closed.
+type handlerState struct {
+	ldapClient *ldap.Client
+
+	userRateLimiter              *auth.UserRateLimiter
+	userRateLimit, userRateBurst int
+
+	issuedTokens        *auth.IssuedTokenLog
+	issuedTokensLogSize int
+
+	tokenCache     *auth.TokenCache
+	tokenCacheSize int
+	tokenCacheTTL  time.Duration
+	// cachedVerifier is the verifier behind the token cache.
+	cachedVerifier token.Verifier
+}
+
+// currentState is the state of the endpoints being served.
+var currentState handlerState
+
+// rateLimiter returns the per-user rate limiter for the flags, if any.
+func (s *handlerState) rateLimiter() *auth.UserRateLimiter {
+	if userTokenRateLimit <= 0 {
+		s.userRateLimiter = nil
+	} else if s.userRateLimiter == nil || s.userRateLimit != userTokenRateLimit || s.userRateBurst != userTokenRateBurst {
+		s.userRateLimiter = auth.NewUserRateLimiter(userTokenRateLimit, userTokenRateBurst)
+		s.userRateLimit, s.userRateBurst = userTokenRateLimit, userTokenRateBurst
+	}
+	return s.userRateLimiter
+}
+
+// issuedTokenLog returns the issued token log for the flags, if any.
+func (s *handlerState) issuedTokenLog() *auth.IssuedTokenLog {
+	if issuedTokensBearerTokenFile == "" {
+		s.issuedTokens = nil
+	} else if s.issuedTokens == nil || s.issuedTokensLogSize != issuedTokensLogSize {
+		s.issuedTokens = auth.NewIssuedTokenLog(issuedTokensLogSize)
+		s.issuedTokensLogSize = issuedTokensLogSize
+	}
+	return s.issuedTokens
+}
+
+// cachingVerifier wraps verifier in the token cache for the flags, if
+// any. A reused cache only switches over to verifier once the endpoints
+// are, as the ones being served still use it until then.
+func (s *handlerState) cachingVerifier(verifier token.Verifier) token.Verifier {
+	s.cachedVerifier = verifier
+	if tokenCacheSize <= 0 {
+		s.tokenCache = nil
+		return verifier
+	}
+	if s.tokenCache == nil || s.tokenCacheSize != tokenCacheSize || s.tokenCacheTTL != tokenCacheTtl {
+		s.tokenCache = auth.NewTokenCache(verifier, tokenCacheSize, tokenCacheTtl)
+		s.tokenCacheSize, s.tokenCacheTTL = tokenCacheSize, tokenCacheTtl
+	}
+	return s.tokenCache
+}
+
+// replace makes s the state of the endpoints being served, in place of
+// currentState.
+func (s handlerState) replace() {
+	if currentState.ldapClient != nil && currentState.ldapClient != s.ldapClient {
+		currentState.ldapClient.Close()
+	}
+	if s.tokenCache != nil {
+		s.tokenCache.SetVerifier(s.cachedVerifier)
+	}
+	currentState = s
+}
+
+func newConfigReloader(handler *auth.ReloadableHandler, flags *pflag.FlagSet) (*configReloader, error) {
+	r := &configReloader{
+		handler:    handler,
+		flags:      flags,
+		configFile: viper.ConfigFileUsed(),
+		build:      newHandler,
+	}
+	if r.configFile != "" {
+		applied, err := ioutil.ReadFile(r.configFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %v", err)
+		}
+		r.applied = applied
+	}
+	return r, nil
+}
+
+// notifySIGHUP returns a channel that receives SIGHUPs.
+func notifySIGHUP() <-chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	return signals
+}
+
+// watch reloads the configuration every time a signal is received.
+func (r *configReloader) watch(signals <-chan os.Signal) {
+	for range signals {
+		glog.Infof("Reloading configuration")
+		if err := r.reload(); err != nil {
+			glog.Errorf("Error reloading configuration, keeping the current one: %v", err)
+			continue
+		}
+		glog.Infof("Configuration reloaded")
+	}
+}
+
+// reload loads the config file and, if it is valid, switches the
+// endpoints over to it. Otherwise the previous configuration is
+// restored.
+func (r *configReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var next []byte
+	if r.configFile != "" {
+		var err error
+		next, err = ioutil.ReadFile(r.configFile)
+		if err != nil {
+			return fmt.Errorf("reading config file: %v", err)
+		}
+	}
+
+	handler, err := r.apply(next)
+	if err != nil {
+		if rollbackErr := r.load(r.applied); rollbackErr != nil {
+			glog.Errorf("Error restoring the previous configuration: %v", rollbackErr)
+		}
+		return err
+	}
+
+	r.handler.Swap(handler)
+	r.applied = next
+	return nil
+}
+
+// apply loads config and builds the endpoints for it.
+func (r *configReloader) apply(config []byte) (http.Handler, error) {
+	listeners := currentListenerConfig()
+	if err := r.load(config); err != nil {
+		return nil, err
+	}
+	if currentListenerConfig() != listeners {
+		return nil, fmt.Errorf("the ports, TLS, metrics listener and dev mode settings can't be changed without a restart")
+	}
+	return r.build()
+}
+
+// load reads config as the contents of the config file, and loads the
+// configuration from it and the flags.
+func (r *configReloader) load(config []byte) error {
+	resetUnsetFlags(r.flags)
+	if r.configFile != "" {
+		if err := viper.ReadConfig(bytes.NewReader(config)); err != nil {
+			return fmt.Errorf("parsing config file: %v", err)
+		}
+	}
+	return loadConfig()
+}
+
+// resetUnsetFlags puts the flags that weren't given on the command line
+// back to their defaults. loadConfig stores the settings in the flags'
+// variables, which viper reads back as the flags' defaults, so without
+// this a setting removed from the config file would keep its old value.
+func resetUnsetFlags(flags *pflag.FlagSet) {
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Changed {
+			return
+		}
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			var values []string
+			if trimmed := strings.Trim(f.DefValue, "[]"); trimmed != "" {
+				values = strings.Split(trimmed, ",")
+			}
+			slice.Replace(values)
+			return
+		}
+		f.Value.Set(f.DefValue)
+	})
+}
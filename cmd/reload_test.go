@@ -0,0 +1,629 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/proofpoint/kubernetes-ldap/auth"
+	"github.com/proofpoint/kubernetes-ldap/token"
+	"github.com/spf13/viper"
+)
+
+const testConfig = `
+dev: true
+ldap-host: ldap1.example.com
+ldap-base-dn: dc=example,dc=com
+`
+
+// servedHost returns the LDAP host the handler was built with.
+func servedHost(handler http.Handler) string {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	return rec.Body.String()
+}
+
+func TestConfigReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatalf("creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config.yaml")
+	writeConfig := func(config string) {
+		if err := ioutil.WriteFile(configFile, []byte(config), 0600); err != nil {
+			t.Fatalf("writing config file: %v", err)
+		}
+	}
+
+	writeConfig(testConfig)
+	viper.SetConfigFile(configFile)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatalf("reading config file: %v", err)
+	}
+	if err := loadConfig(); err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+
+	// Each handler reports the LDAP host it was built with.
+	build := func() (http.Handler, error) {
+		host := ldapHost
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(host))
+		}), nil
+	}
+	initial, _ := build()
+	handler := auth.NewReloadableHandler(initial)
+	reloader, err := newConfigReloader(handler, RootCmd.Flags())
+	if err != nil {
+		t.Fatalf("creating reloader: %v", err)
+	}
+	reloader.build = build
+
+	signals := make(chan os.Signal)
+	go reloader.watch(signals)
+	defer close(signals)
+
+	// A valid config is applied on SIGHUP, without a new handler being
+	// needed by the server.
+	writeConfig(`
+dev: true
+ldap-host: ldap2.example.com
+ldap-base-dn: dc=example,dc=com
+group-limit-policy: reject
+`)
+	signals <- syscall.SIGHUP
+	deadline := time.Now().Add(5 * time.Second)
+	for servedHost(handler) != "ldap2.example.com" {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the new config to be applied, still serving %q", servedHost(handler))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if groupLimitPolicy != auth.GroupLimitReject {
+		t.Errorf("expected group limit policy %q, got %q", auth.GroupLimitReject, groupLimitPolicy)
+	}
+
+	cases := []struct {
+		name   string
+		config string
+	}{
+		{
+			name: "invalid setting",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+group-limit-policy: bogus
+`,
+		},
+		{
+			name: "plaintext LDAP in strict mode",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+use-insecure: true
+ldap-require-tls: true
+`,
+		},
+		{
+			name: "invalid assertion mapping",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+assertion-mappings:
+  - assertion: email
+    attribute: mail
+    transforms: [uppercase]
+`,
+		},
+		{
+			name: "assertion mapping of the auth time assertion",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+auth-time-assertion: auth_time
+assertion-mappings:
+  - assertion: auth_time
+    attribute: description
+`,
+		},
+		{
+			name: "auth time assertion named like the DN assertion",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+dn-assertion: dn
+auth-time-assertion: dn
+`,
+		},
+		{
+			name: "unlimited bulk issuance ttl",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+bulk-issue-max-ttl: 0s
+`,
+		},
+		{
+			name: "reserved stale assertion",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+assertion-mappings:
+  - assertion: stale
+    attribute: description
+`,
+		},
+		{
+			name: "invalid signing key age policy",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+signing-key-age-policy: ignore
+`,
+		},
+		{
+			name: "invalid group membership model",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+ldap-group-membership: member
+`,
+		},
+		{
+			name: "tiebreak without an attribute",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+ldap-multiple-match-policy: tiebreak
+`,
+		},
+		{
+			name: "negative LDAP buffer size",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+ldap-read-buffer-size: -1
+`,
+		},
+		{
+			name: "negative group page restarts",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+ldap-group-page-restarts: -1
+`,
+		},
+		{
+			name: "unknown group failure policy",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+group-failure-policy: ignore
+`,
+		},
+		{
+			name: "unknown group normalization",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+group-normalization: [trim, uppercase]
+`,
+		},
+		{
+			name: "original groups assertion without normalization",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+original-groups-assertion: originalGroups
+`,
+		},
+		{
+			name: "reserved original groups assertion",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+group-normalization: [trim]
+original-groups-assertion: userDN
+`,
+		},
+		{
+			name: "negative leeway",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+expiry-leeway: -1m
+`,
+		},
+		{
+			name: "grace period with a clock skew",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+token-grace-period: 5m
+stale-extra-key: kubernetes-ldap/stale
+clock-skew: 1m
+`,
+		},
+		{
+			name: "deprecated issued-at skew with its leeway",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+max-issued-at-skew: 5m
+issued-at-leeway: 1m
+`,
+		},
+		{
+			name: "cert-bound tokens without client certificates",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+cert-bound-tokens: true
+`,
+		},
+		{
+			name: "negative token cache size",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+token-cache-size: -1
+`,
+		},
+		{
+			name: "unknown credential control character policy",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+credential-control-characters: strip
+`,
+		},
+		{
+			name: "issued tokens endpoint without a log",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+issued-tokens-bearer-token-file: /etc/kubernetes-ldap/admin-token
+issued-tokens-log-size: 0
+`,
+		},
+		{
+			name: "empty user base DN",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+ldap-user-base-dns: ou=employees,dc=example,dc=com;;ou=contractors,dc=example,dc=com
+`,
+		},
+		{
+			name: "reserved server assertion",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+server-assertion: ldapServer
+`,
+		},
+		{
+			name: "username domain without a domain",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+username-domains: [corp.example.com, =example.com]
+`,
+		},
+		{
+			name: "unknown search limit policy",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+ldap-search-limit-policy: truncate
+`,
+		},
+		{
+			name: "invalid search limit filter",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+ldap-search-limit-policy: narrow
+ldap-search-limit-filter: objectClass=person
+`,
+		},
+		{
+			name: "display name extra key without an assertion",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+display-name-extra-key: kubernetes-ldap/display-name
+`,
+		},
+		{
+			name: "reserved display name assertion",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+display-name-assertion: userDN
+`,
+		},
+		{
+			name: "key rotation with a JWKS",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+key-rotation-bearer-token-file: /etc/kubernetes-ldap/rotation-token
+jwks-url: https://keys.example.com/jwks.json
+`,
+		},
+		{
+			name: "key rotation overlap shorter than the token TTL",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+key-rotation-bearer-token-file: /etc/kubernetes-ldap/rotation-token
+key-rotation-overlap: 1h
+token-ttl: 8h
+`,
+		},
+		{
+			name: "invalid group ttl",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+group-ttls:
+  cluster-admins: 15 minutes
+`,
+		},
+		{
+			name: "zero group ttl",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+group-ttls:
+  cluster-admins: 0s
+`,
+		},
+		{
+			name: "unknown machine account policy",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+machine-accounts: deny
+`,
+		},
+		{
+			name: "reserved account type assertion",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+account-type-assertion: elevated
+`,
+		},
+		{
+			name: "negative max verification keys",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+max-verification-keys: -1
+`,
+		},
+		{
+			name: "too many backup verification keys",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+max-verification-keys: 2
+backup-verification-keys: [a.pub, b.pub]
+`,
+		},
+		{
+			name: "invalid group search scope",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+ldap-group-membership: memberuid
+ldap-group-search-scope: children
+`,
+		},
+		{
+			name: "negative token renewal window",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+token-renewal-window: -8h
+`,
+		},
+		{
+			name: "negative unavailable retry after",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+unavailable-retry-after: -5s
+`,
+		},
+		{
+			name: "PKCS #11 key without a PIN file",
+			config: `
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+pkcs11-library: /usr/lib/softhsm/libsofthsm2.so
+pkcs11-key-label: signing
+`,
+		},
+		{
+			name: "PKCS #11 key with a signing key max age",
+			config: `
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+pkcs11-library: /usr/lib/softhsm/libsofthsm2.so
+pkcs11-key-label: signing
+pkcs11-pin-file: /etc/kubernetes-ldap/pkcs11.pin
+signing-key-max-age: 2160h
+`,
+		},
+		{
+			name: "backup verification keys with a JWKS URL",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+jwks-url: https://issuer.example.com/jwks.json
+backup-verification-keys: [/etc/kubernetes-ldap/backup.pub]
+`,
+		},
+		{
+			name: "unknown audience source",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+audience-source: header
+`,
+		},
+		{
+			name: "grace period without a stale extra key",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+token-grace-period: 5m
+`,
+		},
+		{
+			name: "Kerberos principal without a keytab",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+ldap-kerberos-principal: svc-k8s@EXAMPLE.COM
+`,
+		},
+		{
+			name: "missing required setting",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+`,
+		},
+		{
+			name: "listener setting",
+			config: `
+dev: true
+ldap-host: ldap3.example.com
+ldap-base-dn: dc=example,dc=com
+port: 5000
+`,
+		},
+		{
+			name:   "unparseable file",
+			config: "ldap-host: [ldap3.example.com\n",
+		},
+	}
+
+	for _, c := range cases {
+		writeConfig(c.config)
+		if err := reloader.reload(); err == nil {
+			t.Errorf("%s: expected the config to be rejected", c.name)
+		}
+		if host := servedHost(handler); host != "ldap2.example.com" {
+			t.Errorf("%s: expected the previous config to still be served, got %q", c.name, host)
+		}
+		// The previous settings are restored for the next reload.
+		if ldapHost != "ldap2.example.com" || groupLimitPolicy != auth.GroupLimitReject || serverPort != 4000 {
+			t.Errorf("%s: expected the previous settings to be restored, got %q, %q, %d", c.name, ldapHost, groupLimitPolicy, serverPort)
+		}
+	}
+}
+
+// rejectingVerifier accepts no tokens.
+type rejectingVerifier struct{}
+
+func (rejectingVerifier) Verify(string) (*token.AuthToken, error) {
+	return nil, errors.New("invalid token")
+}
+
+func TestHandlerStateReuse(t *testing.T) {
+	defer func(limit, burst, size int, ttl time.Duration) {
+		userTokenRateLimit, userTokenRateBurst, tokenCacheSize, tokenCacheTtl = limit, burst, size, ttl
+	}(userTokenRateLimit, userTokenRateBurst, tokenCacheSize, tokenCacheTtl)
+	userTokenRateLimit, userTokenRateBurst = 10, 5
+	tokenCacheSize, tokenCacheTtl = 100, time.Minute
+
+	var state handlerState
+	limiter := state.rateLimiter()
+	cache := state.cachingVerifier(rejectingVerifier{})
+	if limiter == nil || cache == nil {
+		t.Fatalf("Expected a rate limiter and a token cache")
+	}
+
+	// The previous state stays untouched until the new one replaces it.
+	next := state
+	if next.rateLimiter() != limiter {
+		t.Errorf("Expected the rate limiter to be reused when its limits are unchanged")
+	}
+	if next.cachingVerifier(rejectingVerifier{}) != cache {
+		t.Errorf("Expected the token cache to be reused when its size and TTL are unchanged")
+	}
+
+	userTokenRateBurst = 10
+	tokenCacheTtl = time.Hour
+	if next.rateLimiter() == limiter {
+		t.Errorf("Expected a new rate limiter once the burst changed")
+	}
+	if next.cachingVerifier(rejectingVerifier{}) == cache {
+		t.Errorf("Expected a new token cache once the TTL changed")
+	}
+	if state.userRateLimiter != limiter || state.tokenCache != cache {
+		t.Errorf("Expected the previous state to keep its components")
+	}
+
+	userTokenRateLimit = 0
+	if next.rateLimiter() != nil {
+		t.Errorf("Expected no rate limiter once the limit is removed")
+	}
+}
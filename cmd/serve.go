@@ -16,10 +16,14 @@ package cmd
 
 import (
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"strings"
 
 	"time"
 
@@ -31,6 +35,7 @@ import (
 	"github.com/proofpoint/kubernetes-ldap/token"
 	"github.com/spf13/cast"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
@@ -41,26 +46,205 @@ var (
 	ldapHost string
 	ldapPort uint
 
-	ldapBaseDn        string
-	ldapUserAttribute string
+	ldapBaseDn          string
+	ldapUserBaseDns     []string
+	ldapMachineBaseDns  []string
+	ldapUserAttribute   string
+	ldapUserSearchScope string
 
-	ldapSearchUserDn       string
-	ldapSearchUserPassword string
-	usernameAttribute      string
+	ldapMultipleMatchPolicy string
+	ldapTiebreakAttribute   string
+	ldapSearchLimitPolicy   string
+	ldapSearchLimitFilter   string
+
+	ldapGroupMembership   string
+	ldapGroupBaseDn       string
+	ldapGroupSearchScope  string
+	ldapGroupUIDAttribute string
+	ldapGroupPageSize     uint32
+	ldapGroupPageRestarts int
+	groupFailurePolicy    string
+
+	ldapSearchUserDn           string
+	ldapSearchUserPassword     string
+	ldapSearchUserPasswordFile string
+	ldapKerberosPrincipal      string
+	ldapKerberosKeytab         string
+	ldapKerberosConfig         string
+	ldapKerberosSPN            string
+	usernameAttribute          string
+	usernameRealm              string
+	usernameDomainList         []string
+	// usernameDomains is parsed from usernameDomainList.
+	usernameDomains []auth.UsernameDomain
 
 	serverPort              uint
 	serverTlsCertFile       string
 	serverTlsPrivateKeyFile string
+	serverTLSClientCAFile   string
+	serverTLSClientNames    []string
+	serverTLSMinVersion     string
+	serverTLSCipherSuites   []string
+	tlsStrictCipherSuites   bool
+
+	// tlsMinVersion and tlsCipherSuites are parsed from the flags above.
+	tlsMinVersion   uint16
+	tlsCipherSuites []uint16
 
 	ldapSkipTlsVerification bool
 	ldapUseInsecure         bool
+	ldapRequireTLS          bool
+	ldapWhoAmI              bool
+	ldapSearchAsUser        bool
+	credentialControlChars  string
+
+	ldapMaxIdleConns    int
+	ldapIdleTimeout     time.Duration
+	ldapTCPKeepAlive    time.Duration
+	ldapTCPNoDelay      bool
+	ldapReadBufferSize  int
+	ldapWriteBufferSize int
+
+	ldapNegativeCacheTTL  time.Duration
+	ldapNegativeCacheSize int
+
+	ldapMaxConcurrentBinds int
+	ldapBindQueueSize      int
+	ldapBindQueueTimeout   time.Duration
+
+	enablePasswordChange bool
+	passwordChangeMethod string
+	ldapWritableHost     string
+	ldapWritablePort     uint
+
+	passwordResetResponse  bool
+	passwordResetMessage   string
+	passwordPolicyWarnings bool
+	refuseGraceLogins      bool
+
+	tokenTtl        time.Duration
+	refreshTokenTtl time.Duration
+	maxTokenAge     time.Duration
+	// maxIssuedAtSkew is the deprecated --max-issued-at-skew, which sets
+	// tokenLeeway.IssuedAtLeeway.
+	maxIssuedAtSkew time.Duration
+	tokenGrace      time.Duration
+	// tokenLeeway is read from --clock-skew and the per-claim leeways.
+	tokenLeeway token.LeewayOptions
+
+	tokenCacheSize int
+	tokenCacheTtl  time.Duration
+
+	tokenRenewalWindow time.Duration
 
-	tokenTtl time.Duration
+	certBoundTokens   bool
+	certBoundTokenTtl time.Duration
+
+	requiredAssertions []string
 
 	keypairDir string
 	genKeypair bool
+	devMode    bool
+
+	tokenEncryptionKeypairDir string
+
+	pkcs11Library       string
+	pkcs11Slot          uint
+	pkcs11KeyLabel      string
+	pkcs11PinFile       string
+	pkcs11PublicKeyFile string
+
+	signingKeyMaxAge    time.Duration
+	signingKeyAgePolicy string
 
 	enforceClientVersions bool
+
+	uidAttribute      string
+	dnAssertion       string
+	authTimeAssertion string
+	serverAssertion   string
+	uidHashFallback   bool
+
+	displayNameAssertion  string
+	displayNameAttributes []string
+	displayNameExtraKey   string
+
+	accountTypeAssertion string
+	machineObjectClasses []string
+	machineAccounts      string
+	machineExtraGroup    string
+
+	// assertionMappings, groupScopes and groupTTLs are read from the
+	// config file only.
+	assertionMappings []auth.AssertionMapping
+	groupScopes       map[string][]string
+	groupTTLs         map[string]time.Duration
+
+	minPasswordLength  int
+	extraGroups        []string
+	adminGroupDn       string
+	adminExtraGroup    string
+	groupMappingFile   string
+	staticUsersFile    string
+	ouGroups           []string
+	groupNameAttribute string
+	groupPrefix        string
+
+	groupNormalizationSteps []string
+	originalGroupsAssertion string
+	// groupNormalization is parsed from groupNormalizationSteps.
+	groupNormalization []auth.Transform
+
+	userTokenRateLimit int
+	userTokenRateBurst int
+
+	unavailableRetryAfter time.Duration
+
+	maxGroups        int
+	groupLimitPolicy string
+	priorityGroups   []string
+
+	tokenPrefix               string
+	tokenCompressionThreshold int
+	authMethodExtraKey        string
+	scopesExtraKey            string
+	staleExtraKey             string
+
+	audienceSource                  string
+	webhookAudiences                []string
+	audienceMismatchUnauthenticated bool
+
+	jwksURL                string
+	backupVerificationKeys []string
+	maxVerificationKeys    int
+	jwksRefreshInterval    time.Duration
+	jwksFetchTimeout       time.Duration
+	jwksFetchRetries       int
+	jwksFetchBackoff       time.Duration
+
+	oidcIssuerURL      string
+	oidcClientID       string
+	oidcUsernamePrefix string
+	oidcGroupsPrefix   string
+
+	introspectionBearerTokenFile string
+	introspectionAudiences       []string
+
+	bulkIssueBearerTokenFile string
+	bulkIssueMaxTTL          time.Duration
+
+	verificationKeysBearerTokenFile string
+
+	keyRotationBearerTokenFile string
+	keyRotationOverlap         time.Duration
+
+	issuedTokensBearerTokenFile string
+	issuedTokensLogSize         int
+
+	metricsPort            uint
+	metricsAddress         string
+	metricsBearerTokenFile string
+	metricsClientCAFile    string
 )
 
 // RootCmd represents the serve command
@@ -73,14 +257,22 @@ var RootCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		validate()
 		registerMetrics()
-		serve()
+		serve(cmd.Flags())
 	},
 }
 
 func registerMetrics() {
 	auth.RegisterIssueTokenMetrics()
 	auth.RegisterVerifyTokenMetrics()
+	auth.RegisterRefreshTokenMetrics()
+	auth.RegisterTokenRenewalMetrics()
+	auth.RegisterWhoAmIMetrics()
+	auth.RegisterIntrospectionMetrics()
+	auth.RegisterBulkIssueMetrics()
+	auth.RegisterPasswordChangeMetrics()
+	auth.RegisterReadinessMetrics()
 	ldap.RegisterLDAPClientMetrics()
+	token.RegisterKeySetMetrics()
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -107,24 +299,171 @@ func init() {
 	RootCmd.Flags().UintVar(&ldapPort, "ldap-port", 389, "LDAP server port")
 
 	RootCmd.Flags().StringVar(&ldapBaseDn, "ldap-base-dn", "", "LDAP user base DN in for form 'dc=example,dc=com")
+	RootCmd.Flags().String("ldap-user-base-dns", "", "Base DNs searched for users in order, separated by semicolons since DNs contain commas (e.g. ou=employees,dc=example,dc=com;ou=contractors,dc=example,dc=com), the first match winning. In a config file, a list. Defaults to --ldap-base-dn, which groups are still searched under")
+	RootCmd.Flags().String("ldap-machine-base-dns", "", "Base DNs searched in order for machine accounts, after the user base DNs come up empty, separated by semicolons (e.g. ou=computers,dc=example,dc=com). In a config file, a list")
 	RootCmd.Flags().StringVar(&ldapUserAttribute, "ldap-user-attribute", "uid", "LDAP Username attribute for login")
+	RootCmd.Flags().StringVar(&ldapUserSearchScope, "ldap-user-search-scope", "sub", "Scope of the LDAP user search under --ldap-base-dn: base, one or sub")
+	RootCmd.Flags().StringVar(&ldapGroupSearchScope, "ldap-group-search-scope", "sub", "Scope of the posixGroup search under --ldap-group-base-dn with --ldap-group-membership=memberuid: base, one or sub")
+	RootCmd.Flags().StringVar(&ldapMultipleMatchPolicy, "ldap-multiple-match-policy", ldap.MultipleMatchReject, "What happens when the user search matches more than one entry: reject, or tiebreak to pick the entry with the lowest --ldap-tiebreak-attribute")
+	RootCmd.Flags().StringVar(&ldapSearchLimitPolicy, "ldap-search-limit-policy", ldap.SearchLimitFail, "What happens when the user search exceeds the directory's size or time limit: fail, or narrow to retry it once with the filter narrowed by --ldap-search-limit-filter")
+	RootCmd.Flags().StringVar(&ldapSearchLimitFilter, "ldap-search-limit-filter", "", "Filter ANDed with the user search filter when retrying with --ldap-search-limit-policy=narrow, e.g. on an indexed attribute (defaults to (objectClass=person))")
+	RootCmd.Flags().StringVar(&ldapTiebreakAttribute, "ldap-tiebreak-attribute", "", "Attribute whose lowest value picks the user's entry with --ldap-multiple-match-policy=tiebreak (e.g.: uidNumber)")
+	RootCmd.Flags().StringVar(&ldapGroupMembership, "ldap-group-membership", ldap.MembershipMemberOf, "How users' groups are found: memberof (the user's memberOf attribute) or memberuid (posixGroups listing the user in memberUid)")
+	RootCmd.Flags().StringVar(&ldapGroupBaseDn, "ldap-group-base-dn", "", "Base DN of the posixGroup search with --ldap-group-membership=memberuid (defaults to --ldap-base-dn)")
+	RootCmd.Flags().StringVar(&ldapGroupUIDAttribute, "ldap-group-uid-attribute", "uid", "User attribute whose value posixGroups list in memberUid")
+	RootCmd.Flags().Uint32Var(&ldapGroupPageSize, "ldap-group-page-size", 0, "Search for posixGroups in pages of this size with the paged results control (0 to disable)")
+	RootCmd.Flags().IntVar(&ldapGroupPageRestarts, "ldap-group-page-restarts", 2, "How many times a paged group search is restarted when the server rejects its paging cookie, before the login fails")
+	RootCmd.Flags().StringVar(&groupFailurePolicy, "group-failure-policy", ldap.GroupFailureClosed, "What to do when the group search fails after the user's bind succeeded: fail-closed (refuse the login) or fail-open (issue a token without the searched groups, with the groupsIncomplete assertion)")
+	RootCmd.Flags().StringVar(&credentialControlChars, "credential-control-characters", ldap.ControlCharsReject, "What to do with usernames and passwords containing control characters, which some LDAP servers truncate or misinterpret: reject (any control character, before binding), reject-null (only NUL bytes) or allow")
 
 	RootCmd.Flags().StringVar(&ldapSearchUserDn, "ldap-search-user-dn", "", "Search user DN for this app to find users (e.g.: cn=admin,dc=example,dc=com).")
 	RootCmd.Flags().StringVar(&ldapSearchUserPassword, "ldap-search-user-password", "", "Search user password")
+	RootCmd.Flags().StringVar(&ldapSearchUserPasswordFile, "ldap-search-user-password-file", "", "File containing the search user password, re-read on every login so it can be rotated. Takes precedence over --ldap-search-user-password")
+	RootCmd.Flags().StringVar(&ldapKerberosPrincipal, "ldap-kerberos-principal", "", "Kerberos principal (e.g.: svc-k8s@EXAMPLE.COM) the search user binds as with SASL GSSAPI instead of a simple bind. Requires --ldap-kerberos-keytab")
+	RootCmd.Flags().StringVar(&ldapKerberosKeytab, "ldap-kerberos-keytab", "", "Keytab holding the keys of --ldap-kerberos-principal")
+	RootCmd.Flags().StringVar(&ldapKerberosConfig, "ldap-kerberos-config", "/etc/krb5.conf", "Kerberos configuration file for --ldap-kerberos-principal")
+	RootCmd.Flags().StringVar(&ldapKerberosSPN, "ldap-kerberos-service-principal", "", "Service principal of the LDAP server for GSSAPI binds (defaults to ldap/<ldap-host>)")
 	RootCmd.Flags().StringVar(&usernameAttribute, "username-attribute", "uid", "ldap attribute to use for Username inside token")
+	RootCmd.Flags().StringSliceVar(&usernameDomainList, "username-domains", nil, "Domains users may log in with as user@domain or DOMAIN\\user, stripped before the directory is searched (e.g.: corp.example.com,CORP), or replaced when given as domain=replacement (e.g.: old.example.com=example.com)")
+	RootCmd.Flags().StringVar(&usernameRealm, "username-realm", "", "If set, appended to usernames in tokens as user@realm, to tell apart users of different domains (e.g.: corp.example.com)")
 
 	RootCmd.Flags().UintVar(&serverPort, "port", 4000, "Local port this proxy server will run on")
 	RootCmd.Flags().StringVar(&serverTlsCertFile, "tls-cert-file", "", "(Required) File containing x509 Certificate for HTTPS.  (CA cert, if any, concatenated after server cert) .")
 	RootCmd.Flags().StringVar(&serverTlsPrivateKeyFile, "tls-private-key-file", "", "(Required) File containing x509 private key matching --tls-cert-file.")
+	RootCmd.Flags().StringVar(&serverTLSClientCAFile, "tls-client-ca-file", "", "If set, every endpoint on --port, including /ldapAuth, requires a client certificate signed by a CA in this file, e.g. the API server's")
+	RootCmd.Flags().StringSliceVar(&serverTLSClientNames, "tls-client-allowed-names", nil, "If set, only client certificates with one of these common names or DNS/URI SANs pass --tls-client-ca-file (e.g.: kube-apiserver)")
+	RootCmd.Flags().StringVar(&serverTLSMinVersion, "tls-min-version", "1.0", "Minimum TLS version of the HTTPS listeners and LDAP connections: 1.0, 1.1, 1.2 or 1.3. LDAP connections never go below Go's default of 1.2")
+	RootCmd.Flags().StringSliceVar(&serverTLSCipherSuites, "tls-cipher-suites", nil, "TLS 1.0-1.2 cipher suites allowed by the HTTPS listeners and LDAP connections, by Go name (e.g.: TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256). Defaults to Go's secure suites")
+	RootCmd.Flags().BoolVar(&tlsStrictCipherSuites, "tls-strict-cipher-suites", false, "Refuse to start unless --tls-cipher-suites lists the allowed suites, rather than falling back to Go's defaults")
 
 	RootCmd.Flags().BoolVar(&ldapSkipTlsVerification, "ldap-skip-tls-verification", false, "Skip LDAP server TLS verification")
 	RootCmd.Flags().BoolVar(&ldapUseInsecure, "use-insecure", false, "Disable LDAP TLS")
+	RootCmd.Flags().BoolVar(&ldapRequireTLS, "ldap-require-tls", false, "Refuse to bind to LDAP over a connection that isn't TLS. Startup fails if --use-insecure is also set, here or for a tenant")
+	RootCmd.Flags().BoolVar(&ldapSearchAsUser, "ldap-search-as-user", false, "After the user binds, read their entry again on their own connection, for directories that only show some attributes, e.g. memberOf, to the user. The search user still finds the entry")
+	RootCmd.Flags().BoolVar(&ldapWhoAmI, "ldap-whoami", false, "After every LDAP bind, ask the directory which identity it established with the \"Who Am I?\" extended operation (RFC 4532). The identity is logged at -v=4, and a warning logged if it isn't the DN bound as")
+
+	RootCmd.Flags().IntVar(&ldapMaxIdleConns, "ldap-max-idle-conns", 0, "Number of LDAP connections kept open for reuse between logins (0 disables pooling)")
+	RootCmd.Flags().DurationVar(&ldapIdleTimeout, "ldap-idle-timeout", 0, "Close pooled LDAP connections idle for longer than this instead of reusing them. Set below the directory's own idle timeout (0 means no limit)")
+	RootCmd.Flags().DurationVar(&ldapTCPKeepAlive, "ldap-tcp-keepalive", 0, "TCP keepalive period for LDAP connections (0 uses the system default, negative disables keepalives)")
+	RootCmd.Flags().BoolVar(&ldapTCPNoDelay, "ldap-tcp-nodelay", true, "Set TCP_NODELAY on LDAP connections, sending small bind and search requests without waiting to coalesce them (Nagle's algorithm)")
+	RootCmd.Flags().IntVar(&ldapReadBufferSize, "ldap-read-buffer-size", 0, "Socket receive buffer size of LDAP connections in bytes (0 uses the system default)")
+	RootCmd.Flags().IntVar(&ldapWriteBufferSize, "ldap-write-buffer-size", 0, "Socket send buffer size of LDAP connections in bytes (0 uses the system default)")
+	RootCmd.Flags().DurationVar(&ldapNegativeCacheTTL, "ldap-negative-cache-ttl", 0, "Fail retries of a username and password that just failed for this long, without querying LDAP. Keep it short, e.g. 30s (0 disables the cache)")
+	RootCmd.Flags().IntVar(&ldapNegativeCacheSize, "ldap-negative-cache-size", 1000, "Maximum number of failed logins remembered by --ldap-negative-cache-ttl")
+	RootCmd.Flags().IntVar(&ldapMaxConcurrentBinds, "ldap-max-concurrent-binds", 0, "Maximum number of logins in progress against LDAP at once. Others are queued, and refused with a 503 once the queue is full (0 means no limit)")
+	RootCmd.Flags().IntVar(&ldapBindQueueSize, "ldap-bind-queue-size", 100, "Maximum number of logins waiting for --ldap-max-concurrent-binds")
+	RootCmd.Flags().DurationVar(&ldapBindQueueTimeout, "ldap-bind-queue-timeout", 5*time.Second, "How long a login waits in the --ldap-max-concurrent-binds queue before being refused with a 503 (0 waits indefinitely)")
+
+	RootCmd.Flags().BoolVar(&enablePasswordChange, "enable-password-change", false, "Serve /changePassword, which lets users change their LDAP password after verifying the current one")
+	RootCmd.Flags().StringVar(&passwordChangeMethod, "password-change-method", ldap.PasswordChangeExtendedOp, "How /changePassword sets the new password: exop (RFC 3062 password modify) or modify (replace userPassword)")
+	RootCmd.Flags().StringVar(&ldapWritableHost, "ldap-writable-host", "", "LDAP server that password changes are retried on when --ldap-host is read-only, e.g. a replica or a primary under maintenance")
+	RootCmd.Flags().UintVar(&ldapWritablePort, "ldap-writable-port", 0, "Port of --ldap-writable-host (defaults to --ldap-port)")
+	RootCmd.Flags().BoolVar(&passwordResetResponse, "password-reset-response", false, "Answer users whose password must be changed (AD data 773 or the ppolicy control) with a 403 password_reset_required error instead of invalid credentials")
+	RootCmd.Flags().StringVar(&passwordResetMessage, "password-reset-message", auth.DefaultPasswordResetMessage, "Message returned with the password_reset_required error")
+	RootCmd.Flags().BoolVar(&passwordPolicyWarnings, "password-policy-warnings", false, "Request the ppolicy control on user binds and pass its warnings, a password expiring soon or grace logins left, on to the user with the token")
+	RootCmd.Flags().BoolVar(&refuseGraceLogins, "refuse-grace-logins", false, "Answer users logging in with an expired password during the ppolicy grace logins with a 403 password_reset_required error instead of a token")
 
 	RootCmd.Flags().DurationVar(&tokenTtl, "token-ttl", 24*time.Hour, "TTL for the token")
+	RootCmd.Flags().DurationVar(&refreshTokenTtl, "refresh-token-ttl", 0, "If set, JSON token responses include a refresh token valid for this long, which /refresh exchanges for a new token (0 disables refresh tokens)")
+	RootCmd.Flags().DurationVar(&tokenRenewalWindow, "token-renewal-window", 0, "If set, /renew exchanges a still valid access token for one with a new --token-ttl expiry, without LDAP, for this long after the user logged in. After it, users must log in again (0 disables /renew)")
+	RootCmd.Flags().BoolVar(&certBoundTokens, "cert-bound-tokens", false, "Bind tokens to the TLS client certificate they are requested with (cnf x5t#S256), so that services verifying them require that certificate. Requires --tls-client-ca-file; /authenticate refuses bound tokens")
+	RootCmd.Flags().DurationVar(&certBoundTokenTtl, "cert-bound-token-ttl", 15*time.Minute, "TTL for tokens bound with --cert-bound-tokens, if shorter than --token-ttl")
+	RootCmd.Flags().DurationVar(&maxTokenAge, "max-token-age", 0, "If set, /authenticate rejects tokens issued longer ago than this, whatever their expiry. Tokens without an issue time are rejected too (0 disables the check)")
+	RootCmd.Flags().DurationVar(&maxIssuedAtSkew, "max-issued-at-skew", 0, "Deprecated: use --issued-at-leeway, which this sets")
+	RootCmd.Flags().StringSliceVar(&requiredAssertions, "required-assertions", nil, "Assertions (e.g.: email,department) that /authenticate requires every token to have a non-empty value for, rejecting tokens without them")
+	RootCmd.Flags().DurationVar(&tokenLeeway.Skew, "clock-skew", 0, "Clock skew /authenticate tolerates on the expiration, not-before and issued-at times of tokens, unless set per claim with --expiry-leeway, --not-before-leeway or --issued-at-leeway")
+	RootCmd.Flags().DurationVar(&tokenLeeway.ExpiryLeeway, "expiry-leeway", 0, "How long after they expire /authenticate still accepts tokens (0 uses --clock-skew)")
+	RootCmd.Flags().DurationVar(&tokenLeeway.NotBeforeLeeway, "not-before-leeway", 0, "How long before their not-before time /authenticate already accepts tokens (0 uses --clock-skew)")
+	RootCmd.Flags().DurationVar(&tokenLeeway.IssuedAtLeeway, "issued-at-leeway", 0, "How far in the future tokens accepted by /authenticate may be issued (0 uses --clock-skew)")
+	RootCmd.Flags().DurationVar(&tokenGrace, "token-grace-period", 0, "If set, /authenticate still accepts tokens that expired less than this long ago, marked stale under --stale-extra-key so that the authorizer can restrict them (e.g. to read-only requests)")
+	RootCmd.Flags().IntVar(&tokenCacheSize, "token-cache-size", 0, "Maximum number of tokens /authenticate remembers as verified, so that a token the API server presents again isn't verified again (0 disables the cache)")
+	RootCmd.Flags().DurationVar(&tokenCacheTtl, "token-cache-ttl", time.Minute, "How long /authenticate remembers a verified token with --token-cache-size, if shorter than the token's expiry")
 	RootCmd.Flags().BoolVar(&genKeypair, "gen-keypair", false, "generate new keypair while starting server")
+	RootCmd.Flags().StringVar(&tokenEncryptionKeypairDir, "token-encryption-keypair-dir", "", "If set, tokens are also encrypted (JWE, ECDH-ES with A256GCM) to the keypair in this directory, laid out like --keypair-dir, so their claims can't be read without its private key")
+	RootCmd.Flags().StringVar(&pkcs11Library, "pkcs11-library", "", "If set, sign tokens with a P-256 key in a hardware security module through this PKCS #11 module (e.g. /usr/lib/softhsm/libsofthsm2.so) instead of the private key in --keypair-dir. Needs a binary built with -tags pkcs11")
+	RootCmd.Flags().UintVar(&pkcs11Slot, "pkcs11-slot", 0, "PKCS #11 slot of the token holding the signing key")
+	RootCmd.Flags().StringVar(&pkcs11KeyLabel, "pkcs11-key-label", "", "Label of the signing key, and of its public key, in the PKCS #11 token")
+	RootCmd.Flags().StringVar(&pkcs11PinFile, "pkcs11-pin-file", "", "File containing the user PIN of the PKCS #11 token")
+	RootCmd.Flags().StringVar(&pkcs11PublicKeyFile, "pkcs11-public-key-file", "", "If set, the PKCS #11 public key is written to this file (PEM) at startup, for verifiers, e.g. as the signing.pub of their --keypair-dir")
+	RootCmd.Flags().DurationVar(&signingKeyMaxAge, "signing-key-max-age", 0, "If set, /readyz reports the signing key as due for rotation once it is older than this, going by its file's modification time (0 disables the check)")
+	RootCmd.Flags().StringVar(&signingKeyAgePolicy, "signing-key-age-policy", auth.KeyAgeWarn, "What /readyz does for a signing key older than --signing-key-max-age: warn (stay ready) or fail (report not ready)")
+	RootCmd.Flags().BoolVar(&devMode, "dev", false, "INSECURE, for development only: sign tokens with an in-memory key generated at startup, and serve a self-signed certificate if no --tls-cert-file is given")
 
 	RootCmd.Flags().BoolVar(&enforceClientVersions, "enforce-client-versions", false, "if true enforces minimum version of k8sldapctl and kubectl")
 
+	RootCmd.Flags().StringVar(&uidAttribute, "uid-attribute", "", "LDAP attribute holding a stable user ID, passed to Kubernetes as user.uid (e.g.: entryUUID)")
+	RootCmd.Flags().StringVar(&dnAssertion, "dn-assertion", "", "If set, tokens carry the DN the user bound as in an assertion of this name (e.g.: dn)")
+	RootCmd.Flags().StringVar(&displayNameAssertion, "display-name-assertion", "", "If set, tokens carry a friendly name of the user, from the first of --display-name-attributes they have, in an assertion of this name (e.g.: displayName)")
+	RootCmd.Flags().StringSliceVar(&displayNameAttributes, "display-name-attributes", []string{"displayName", "cn"}, "Directory attributes tried in order for --display-name-assertion")
+	RootCmd.Flags().StringVar(&authTimeAssertion, "auth-time-assertion", "", "If set, tokens carry the time the user authenticated, in RFC 3339, in an assertion of this name (e.g.: auth_time)")
+	RootCmd.Flags().StringVar(&serverAssertion, "server-assertion", "", "If set, tokens carry the address (IP and port) of the LDAP server the user bound to in an assertion of this name (e.g.: ldapServerAddress), telling apart the replicas behind --ldap-host")
+	RootCmd.Flags().BoolVar(&uidHashFallback, "uid-hash-fallback", false, "Derive user.uid from a SHA-256 of the username (\"ldap:<hex>\") when the user has no --uid-attribute value")
+
+	RootCmd.Flags().IntVar(&minPasswordLength, "min-password-length", 0, "Reject passwords shorter than this many characters before contacting LDAP (0 disables the check; empty passwords are always rejected)")
+
+	RootCmd.Flags().StringSliceVar(&extraGroups, "extra-groups", nil, "Groups added to the token of every authenticated user (e.g.: system:authenticated-ldap)")
+
+	RootCmd.Flags().StringVar(&adminGroupDn, "admin-group-dn", "", "DN of the LDAP group whose members get tokens with the elevated=true assertion")
+	RootCmd.Flags().StringVar(&adminExtraGroup, "admin-extra-group", "", "Group added to the token of members of --admin-group-dn")
+	RootCmd.Flags().StringVar(&accountTypeAssertion, "account-type-assertion", "", "If set, tokens carry the account type, machine for entries with one of --machine-object-classes and user for others, in an assertion of this name (e.g.: account_type)")
+	RootCmd.Flags().StringSliceVar(&machineObjectClasses, "machine-object-classes", auth.DefaultMachineObjectClasses, "Object classes of machine accounts")
+	RootCmd.Flags().StringVar(&machineAccounts, "machine-accounts", auth.MachineAccountsAllow, "Whether machine accounts can get tokens: allow or reject")
+	RootCmd.Flags().StringVar(&machineExtraGroup, "machine-extra-group", "", "Group added to the token of machine accounts, for RBAC to tell them from users")
+	RootCmd.Flags().StringVar(&groupPrefix, "group-prefix", "", "Prefix added to the groups from the directory (e.g.: ldap:), so they can't collide with the cluster's own groups")
+	RootCmd.Flags().StringSliceVar(&groupNormalizationSteps, "group-normalization", nil, "Normalization applied in order to the names of the groups from the directory: trim (white space), fold-case (full Unicode case folding) and/or fold-diacritics")
+	RootCmd.Flags().StringVar(&originalGroupsAssertion, "original-groups-assertion", "", "If set, tokens whose groups were changed by --group-normalization carry the groups as they were before, as a JSON array, in an assertion of this name")
+	RootCmd.Flags().StringVar(&groupNameAttribute, "group-name-attribute", "", "If set, groups are named by this attribute of the first RDN of their memberOf DN (e.g.: cn), or by that RDN's value if it is another attribute. By default every cn of the DN is a group")
+	RootCmd.Flags().StringSliceVar(&ouGroups, "ou-groups", nil, "Organizational units of the user's DN that become groups (e.g.: eng,nyc), or * for all of them")
+	RootCmd.Flags().StringVar(&groupMappingFile, "group-mapping-file", "", "File mapping LDAP groups to Kubernetes groups, reloaded when it changes")
+	RootCmd.Flags().StringVar(&staticUsersFile, "static-users-file", "", "File of users, with bcrypt password hashes and groups, who are authenticated only while LDAP is unreachable, for break-glass access")
+
+	RootCmd.Flags().IntVar(&userTokenRateLimit, "user-token-rate-limit", 0, "Maximum number of tokens issued to a single user per minute. Requests over it get a 429 (0 means no limit)")
+	RootCmd.Flags().IntVar(&userTokenRateBurst, "user-token-rate-burst", 5, "Number of tokens a user can get at once, before --user-token-rate-limit applies")
+	RootCmd.Flags().DurationVar(&unavailableRetryAfter, "unavailable-retry-after", 10*time.Second, "Retry-After sent with the 503 returned when the directory is unavailable, so clients back off instead of retrying at once (0 sends none). 429s always send the time until the rate limit allows the next request")
+
+	RootCmd.Flags().IntVar(&maxGroups, "max-groups", 0, "Maximum number of groups carried in a token (0 means no limit)")
+	RootCmd.Flags().StringVar(&groupLimitPolicy, "group-limit-policy", auth.GroupLimitTruncate, "What to do for users in more than --max-groups groups: truncate (keeping --priority-groups first) or reject")
+	RootCmd.Flags().StringSliceVar(&priorityGroups, "priority-groups", nil, "Groups kept ahead of others when a token's groups are truncated to --max-groups")
+
+	RootCmd.Flags().StringVar(&authMethodExtraKey, "auth-method-extra-key", "", "If set, /authenticate passes how the user authenticated (ldap-bind, oidc or refresh) to the API server under this user extra key (e.g.: kubernetes-ldap/amr)")
+	RootCmd.Flags().StringVar(&scopesExtraKey, "scopes-extra-key", "", "If set, /authenticate passes the token's scopes (granted with group-scopes in the config file) to the API server under this user extra key (e.g.: kubernetes-ldap/scopes)")
+	RootCmd.Flags().StringVar(&displayNameExtraKey, "display-name-extra-key", "", "If set, /authenticate passes the token's --display-name-assertion to the API server under this user extra key (e.g.: kubernetes-ldap/display-name)")
+	RootCmd.Flags().StringVar(&staleExtraKey, "stale-extra-key", "", "User extra key set to \"true\" for expired tokens accepted during --token-grace-period (e.g.: kubernetes-ldap/stale)")
+	RootCmd.Flags().StringVar(&audienceSource, "audience-source", "", "If set, tokens are issued for an audience derived from the request: host (the first label of the Host, e.g. cluster-a for cluster-a.example.com) or path (the tenant of a /tenants/<name>/ path)")
+	RootCmd.Flags().StringSliceVar(&webhookAudiences, "webhook-audiences", nil, "Audiences /authenticate accepts from tokens with an audience when the TokenReview lists none, i.e. the API server has no --api-audiences")
+	RootCmd.Flags().BoolVar(&audienceMismatchUnauthenticated, "audience-mismatch-unauthenticated", false, "If set, /authenticate answers tokens for another audience with an unauthenticated TokenReview instead of a 401, letting the API server try its other authenticators")
+	RootCmd.Flags().StringVar(&tokenPrefix, "token-prefix", "", "Prefix prepended to issued tokens and required by /authenticate (e.g.: ldap:). Tokens without it are declined so other authenticators can handle them")
+
+	RootCmd.Flags().IntVar(&tokenCompressionThreshold, "token-compression-threshold", 0, "Compress token payloads larger than this many bytes before signing (0 disables compression)")
+
+	RootCmd.Flags().StringVar(&jwksURL, "jwks-url", "", "If set, verify tokens against the keys published at this JWKS URL instead of the local keypair")
+	RootCmd.Flags().StringSliceVar(&backupVerificationKeys, "backup-verification-keys", nil, "Public key files (DER or PEM) trusted to verify tokens besides the keypair's own, e.g. of a parallel issuer. Tokens are still signed with the keypair")
+	RootCmd.Flags().DurationVar(&jwksRefreshInterval, "jwks-refresh-interval", 15*time.Minute, "How often to refresh the JWKS key set when the endpoint sends no Cache-Control max-age")
+	RootCmd.Flags().IntVar(&maxVerificationKeys, "max-verification-keys", 0, "If positive, the most keys trusted to verify tokens: JWKS key sets with more are refused, keeping the cached keys, and more backup verification keys fail to load")
+	RootCmd.Flags().DurationVar(&jwksFetchTimeout, "jwks-fetch-timeout", 10*time.Second, "Timeout of each attempt to fetch the JWKS key set or OIDC discovery document")
+	RootCmd.Flags().IntVar(&jwksFetchRetries, "jwks-fetch-retries", 2, "How many times a failed JWKS or OIDC discovery fetch is retried")
+	RootCmd.Flags().DurationVar(&jwksFetchBackoff, "jwks-fetch-retry-backoff", 500*time.Millisecond, "Wait before the first retry of a failed JWKS or OIDC discovery fetch, doubled for each further retry")
+
+	RootCmd.Flags().StringVar(&oidcIssuerURL, "oidc-issuer-url", "", "If set, /authenticate also accepts ID tokens from this OIDC provider, found through OIDC discovery")
+	RootCmd.Flags().StringVar(&oidcClientID, "oidc-client-id", "", "Audience required in OIDC ID tokens. Required with --oidc-issuer-url")
+	RootCmd.Flags().StringVar(&oidcUsernamePrefix, "oidc-username-prefix", "oidc:", "Prefix of the usernames of OIDC ID tokens, so that they can't be taken for LDAP users. Empty to use them as they are")
+	RootCmd.Flags().StringVar(&oidcGroupsPrefix, "oidc-groups-prefix", "oidc:", "Prefix of the groups of OIDC ID tokens, so that they can't be taken for LDAP groups. Empty to use them as they are")
+
+	RootCmd.Flags().StringVar(&introspectionBearerTokenFile, "introspection-bearer-token-file", "", "If set, serve /introspect (RFC 7662 token introspection) to clients with an Authorization: Bearer header matching the contents of this file")
+	RootCmd.Flags().StringSliceVar(&introspectionAudiences, "introspection-audiences", nil, "Audiences reported as the aud of tokens by /introspect")
+	RootCmd.Flags().StringVar(&bulkIssueBearerTokenFile, "bulk-issue-bearer-token-file", "", "If set, serve /bulkIssue, which issues tokens for a list of users without authenticating them, to clients with an Authorization: Bearer header matching the contents of this file")
+	RootCmd.Flags().DurationVar(&bulkIssueMaxTTL, "bulk-issue-max-ttl", 30*24*time.Hour, "Longest ttl /bulkIssue records may ask for")
+	RootCmd.Flags().StringVar(&verificationKeysBearerTokenFile, "verification-keys-bearer-token-file", "", "If set, serve /verificationKeys, listing the kid, algorithm, thumbprint and load time of the keys tokens are verified with, to clients with an Authorization: Bearer header matching the contents of this file")
+	RootCmd.Flags().StringVar(&keyRotationBearerTokenFile, "key-rotation-bearer-token-file", "", "If set, serve /rotateKey, which replaces the signing key with one generated in memory on POST, to clients with an Authorization: Bearer header matching the contents of this file, which must be at least 32 characters. For dev and test clusters: rotated keys are lost when the process exits")
+	RootCmd.Flags().DurationVar(&keyRotationOverlap, "key-rotation-overlap", 0, "How long tokens signed with a key replaced by /rotateKey still verify (0 means --token-ttl)")
+	RootCmd.Flags().StringVar(&issuedTokensBearerTokenFile, "issued-tokens-bearer-token-file", "", "If set, remember the jti, username, type and times of the last --issued-tokens-log-size tokens issued, and serve them at /issuedTokens to clients with an Authorization: Bearer header matching the contents of this file")
+	RootCmd.Flags().IntVar(&issuedTokensLogSize, "issued-tokens-log-size", 100, "Number of issued tokens /issuedTokens remembers")
+
+	RootCmd.Flags().UintVar(&metricsPort, "metrics-port", 9443, "Port for the /metrics endpoint, served over TLS on its own listener. Set to the same value as --port to serve it alongside the webhook")
+	RootCmd.Flags().StringVar(&metricsAddress, "metrics-address", "127.0.0.1", "Address the separate metrics listener binds to. Defaults to localhost only; set to 0.0.0.0 for scrapers on other hosts")
+	RootCmd.Flags().StringVar(&metricsBearerTokenFile, "metrics-bearer-token-file", "", "If set, /metrics requires an Authorization: Bearer header matching the contents of this file")
+	RootCmd.Flags().StringVar(&metricsClientCAFile, "metrics-client-ca-file", "", "If set, the metrics listener requires client certificates signed by a CA in this file")
+
 	viper.BindPFlags(RootCmd.Flags())
 	flag.CommandLine.Parse([]string{})
 }
@@ -150,40 +489,462 @@ func initConfig() {
 	}
 }
 
+// validate loads the configuration and exits if it is invalid.
 func validate() {
+	if err := loadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "kubernetes-ldap: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// loadConfig sets the configuration variables from the flags and config
+// file, and checks them.
+func loadConfig() error {
 	ldapHost = viper.GetString("ldap-host")
 	ldapPort = cast.ToUint(viper.Get("ldap-port"))
 
 	ldapBaseDn = viper.GetString("ldap-base-dn")
+	ldapUserBaseDns = baseDNList(viper.Get("ldap-user-base-dns"))
+	ldapMachineBaseDns = baseDNList(viper.Get("ldap-machine-base-dns"))
 	ldapUserAttribute = viper.GetString("ldap-user-attribute")
+	usernameRealm = viper.GetString("username-realm")
+	usernameDomainList = viper.GetStringSlice("username-domains")
+	ldapUserSearchScope = viper.GetString("ldap-user-search-scope")
+	ldapMultipleMatchPolicy = viper.GetString("ldap-multiple-match-policy")
+	ldapTiebreakAttribute = viper.GetString("ldap-tiebreak-attribute")
+	ldapSearchLimitPolicy = viper.GetString("ldap-search-limit-policy")
+	ldapSearchLimitFilter = viper.GetString("ldap-search-limit-filter")
+	ldapGroupMembership = viper.GetString("ldap-group-membership")
+	ldapGroupBaseDn = viper.GetString("ldap-group-base-dn")
+	ldapGroupSearchScope = viper.GetString("ldap-group-search-scope")
+	ldapGroupUIDAttribute = viper.GetString("ldap-group-uid-attribute")
+	ldapGroupPageSize = viper.GetUint32("ldap-group-page-size")
+	ldapGroupPageRestarts = viper.GetInt("ldap-group-page-restarts")
+	groupFailurePolicy = viper.GetString("group-failure-policy")
+	credentialControlChars = viper.GetString("credential-control-characters")
 
 	ldapSearchUserPassword = viper.GetString("ldap-search-user-password")
 	ldapSearchUserDn = viper.GetString("ldap-search-user-dn")
+	ldapSearchUserPasswordFile = viper.GetString("ldap-search-user-password-file")
+	ldapKerberosPrincipal = viper.GetString("ldap-kerberos-principal")
+	ldapKerberosKeytab = viper.GetString("ldap-kerberos-keytab")
+	ldapKerberosConfig = viper.GetString("ldap-kerberos-config")
+	ldapKerberosSPN = viper.GetString("ldap-kerberos-service-principal")
 
 	serverTlsPrivateKeyFile = viper.GetString("tls-private-key-file")
 	serverTlsCertFile = viper.GetString("tls-cert-file")
+	serverTLSClientCAFile = viper.GetString("tls-client-ca-file")
+	serverTLSClientNames = viper.GetStringSlice("tls-client-allowed-names")
+	serverTLSMinVersion = viper.GetString("tls-min-version")
+	serverTLSCipherSuites = viper.GetStringSlice("tls-cipher-suites")
+	tlsStrictCipherSuites = viper.GetBool("tls-strict-cipher-suites")
 
 	ldapUseInsecure = viper.GetBool("use-insecure")
 	ldapSkipTlsVerification = viper.GetBool("ldap-skip-tls-verification")
+	ldapRequireTLS = viper.GetBool("ldap-require-tls")
+	ldapWhoAmI = viper.GetBool("ldap-whoami")
+	ldapSearchAsUser = viper.GetBool("ldap-search-as-user")
+
+	ldapMaxIdleConns = viper.GetInt("ldap-max-idle-conns")
+	ldapIdleTimeout = viper.GetDuration("ldap-idle-timeout")
+	ldapTCPKeepAlive = viper.GetDuration("ldap-tcp-keepalive")
+	ldapTCPNoDelay = viper.GetBool("ldap-tcp-nodelay")
+	ldapReadBufferSize = viper.GetInt("ldap-read-buffer-size")
+	ldapWriteBufferSize = viper.GetInt("ldap-write-buffer-size")
+	ldapNegativeCacheTTL = viper.GetDuration("ldap-negative-cache-ttl")
+	ldapNegativeCacheSize = viper.GetInt("ldap-negative-cache-size")
+	ldapMaxConcurrentBinds = viper.GetInt("ldap-max-concurrent-binds")
+	ldapBindQueueSize = viper.GetInt("ldap-bind-queue-size")
+	ldapBindQueueTimeout = viper.GetDuration("ldap-bind-queue-timeout")
+
+	enablePasswordChange = viper.GetBool("enable-password-change")
+	passwordChangeMethod = viper.GetString("password-change-method")
+	ldapWritableHost = viper.GetString("ldap-writable-host")
+	ldapWritablePort = cast.ToUint(viper.Get("ldap-writable-port"))
+
+	passwordResetResponse = viper.GetBool("password-reset-response")
+	passwordResetMessage = viper.GetString("password-reset-message")
+	passwordPolicyWarnings = viper.GetBool("password-policy-warnings")
+	refuseGraceLogins = viper.GetBool("refuse-grace-logins")
+
+	devMode = viper.GetBool("dev")
 
 	tokenTtl = viper.GetDuration("token-ttl")
+	refreshTokenTtl = viper.GetDuration("refresh-token-ttl")
+	tokenRenewalWindow = viper.GetDuration("token-renewal-window")
+	certBoundTokens = viper.GetBool("cert-bound-tokens")
+	certBoundTokenTtl = viper.GetDuration("cert-bound-token-ttl")
+	maxTokenAge = viper.GetDuration("max-token-age")
+	maxIssuedAtSkew = viper.GetDuration("max-issued-at-skew")
+	tokenGrace = viper.GetDuration("token-grace-period")
+	tokenLeeway = token.LeewayOptions{
+		Skew:            viper.GetDuration("clock-skew"),
+		ExpiryLeeway:    viper.GetDuration("expiry-leeway"),
+		NotBeforeLeeway: viper.GetDuration("not-before-leeway"),
+		IssuedAtLeeway:  viper.GetDuration("issued-at-leeway"),
+	}
+	if maxIssuedAtSkew > 0 {
+		glog.Warningf("--max-issued-at-skew is deprecated, use --issued-at-leeway")
+		if tokenLeeway.IssuedAtLeeway != 0 {
+			return fmt.Errorf("--max-issued-at-skew can't be combined with --issued-at-leeway, which replaces it")
+		}
+		tokenLeeway.IssuedAtLeeway = maxIssuedAtSkew
+	}
+	tokenCacheSize = viper.GetInt("token-cache-size")
+	tokenCacheTtl = viper.GetDuration("token-cache-ttl")
+	requiredAssertions = viper.GetStringSlice("required-assertions")
+	signingKeyMaxAge = viper.GetDuration("signing-key-max-age")
+	tokenEncryptionKeypairDir = viper.GetString("token-encryption-keypair-dir")
+	pkcs11Library = viper.GetString("pkcs11-library")
+	pkcs11Slot = cast.ToUint(viper.Get("pkcs11-slot"))
+	pkcs11KeyLabel = viper.GetString("pkcs11-key-label")
+	pkcs11PinFile = viper.GetString("pkcs11-pin-file")
+	pkcs11PublicKeyFile = viper.GetString("pkcs11-public-key-file")
+	signingKeyAgePolicy = viper.GetString("signing-key-age-policy")
 	serverPort = cast.ToUint(viper.Get("port"))
 
-	requireFlag("--ldap-host", ldapHost)
-	requireFlag("--ldap-base-dn", ldapBaseDn)
+	uidAttribute = viper.GetString("uid-attribute")
+	dnAssertion = viper.GetString("dn-assertion")
+	authTimeAssertion = viper.GetString("auth-time-assertion")
+	displayNameAssertion = viper.GetString("display-name-assertion")
+	displayNameAttributes = viper.GetStringSlice("display-name-attributes")
+	displayNameExtraKey = viper.GetString("display-name-extra-key")
+	serverAssertion = viper.GetString("server-assertion")
+	uidHashFallback = viper.GetBool("uid-hash-fallback")
 
-	requireFlag("--tls-cert-file", serverTlsCertFile)
-	if _, err := os.Stat(serverTlsCertFile); os.IsNotExist(err) {
+	minPasswordLength = viper.GetInt("min-password-length")
+	extraGroups = viper.GetStringSlice("extra-groups")
+	adminGroupDn = viper.GetString("admin-group-dn")
+	adminExtraGroup = viper.GetString("admin-extra-group")
+	accountTypeAssertion = viper.GetString("account-type-assertion")
+	machineObjectClasses = viper.GetStringSlice("machine-object-classes")
+	machineAccounts = viper.GetString("machine-accounts")
+	machineExtraGroup = viper.GetString("machine-extra-group")
+	groupMappingFile = viper.GetString("group-mapping-file")
+	staticUsersFile = viper.GetString("static-users-file")
+	ouGroups = viper.GetStringSlice("ou-groups")
+	groupNameAttribute = viper.GetString("group-name-attribute")
+	groupPrefix = viper.GetString("group-prefix")
+	groupNormalizationSteps = viper.GetStringSlice("group-normalization")
+	originalGroupsAssertion = viper.GetString("original-groups-assertion")
 
-		fmt.Fprintf(os.Stderr, "file %s does not exist\n", serverTlsCertFile)
-		os.Exit(1)
+	userTokenRateLimit = viper.GetInt("user-token-rate-limit")
+	userTokenRateBurst = viper.GetInt("user-token-rate-burst")
+	unavailableRetryAfter = viper.GetDuration("unavailable-retry-after")
+	maxGroups = viper.GetInt("max-groups")
+	groupLimitPolicy = viper.GetString("group-limit-policy")
+	priorityGroups = viper.GetStringSlice("priority-groups")
+
+	tokenPrefix = viper.GetString("token-prefix")
+	authMethodExtraKey = viper.GetString("auth-method-extra-key")
+	scopesExtraKey = viper.GetString("scopes-extra-key")
+	staleExtraKey = viper.GetString("stale-extra-key")
+	audienceSource = viper.GetString("audience-source")
+	webhookAudiences = viper.GetStringSlice("webhook-audiences")
+	audienceMismatchUnauthenticated = viper.GetBool("audience-mismatch-unauthenticated")
+	tokenCompressionThreshold = viper.GetInt("token-compression-threshold")
+
+	jwksURL = viper.GetString("jwks-url")
+	backupVerificationKeys = viper.GetStringSlice("backup-verification-keys")
+	maxVerificationKeys = viper.GetInt("max-verification-keys")
+	jwksRefreshInterval = viper.GetDuration("jwks-refresh-interval")
+	jwksFetchTimeout = viper.GetDuration("jwks-fetch-timeout")
+	jwksFetchRetries = viper.GetInt("jwks-fetch-retries")
+	jwksFetchBackoff = viper.GetDuration("jwks-fetch-retry-backoff")
+
+	oidcIssuerURL = viper.GetString("oidc-issuer-url")
+	oidcClientID = viper.GetString("oidc-client-id")
+	oidcUsernamePrefix = viper.GetString("oidc-username-prefix")
+	oidcGroupsPrefix = viper.GetString("oidc-groups-prefix")
+
+	introspectionBearerTokenFile = viper.GetString("introspection-bearer-token-file")
+	introspectionAudiences = viper.GetStringSlice("introspection-audiences")
+	bulkIssueBearerTokenFile = viper.GetString("bulk-issue-bearer-token-file")
+	verificationKeysBearerTokenFile = viper.GetString("verification-keys-bearer-token-file")
+	keyRotationBearerTokenFile = viper.GetString("key-rotation-bearer-token-file")
+	keyRotationOverlap = viper.GetDuration("key-rotation-overlap")
+	issuedTokensBearerTokenFile = viper.GetString("issued-tokens-bearer-token-file")
+	issuedTokensLogSize = viper.GetInt("issued-tokens-log-size")
+	bulkIssueMaxTTL = viper.GetDuration("bulk-issue-max-ttl")
+
+	metricsPort = cast.ToUint(viper.Get("metrics-port"))
+	metricsAddress = viper.GetString("metrics-address")
+	metricsBearerTokenFile = viper.GetString("metrics-bearer-token-file")
+	metricsClientCAFile = viper.GetString("metrics-client-ca-file")
+
+	if err := checkRequired("--ldap-host", ldapHost); err != nil {
+		return err
+	}
+	if err := checkRequired("--ldap-base-dn", ldapBaseDn); err != nil {
+		return err
 	}
 
-	requireFlag("--tls-private-key", serverTlsPrivateKeyFile)
+	if ldapRequireTLS && ldapUseInsecure {
+		return fmt.Errorf("--ldap-require-tls is set, but --use-insecure disables LDAP TLS")
+	}
+
+	if issuedTokensBearerTokenFile != "" && issuedTokensLogSize <= 0 {
+		return fmt.Errorf("--issued-tokens-log-size must be positive with --issued-tokens-bearer-token-file")
+	}
+
+	if bulkIssueMaxTTL <= 0 {
+		return fmt.Errorf("--bulk-issue-max-ttl must be positive")
+	}
+
+	if tokenRenewalWindow < 0 {
+		return fmt.Errorf("--token-renewal-window can't be negative")
+	}
+	if unavailableRetryAfter < 0 {
+		return fmt.Errorf("--unavailable-retry-after can't be negative")
+	}
+	if jwksFetchRetries < 0 {
+		return fmt.Errorf("--jwks-fetch-retries can't be negative")
+	}
+	if jwksURL != "" && len(backupVerificationKeys) > 0 {
+		return fmt.Errorf("--backup-verification-keys can't be used with --jwks-url, publish the keys there instead")
+	}
+	if maxVerificationKeys < 0 {
+		return fmt.Errorf("--max-verification-keys can't be negative")
+	}
+	// The keypair's own key counts too.
+	if maxVerificationKeys > 0 && len(backupVerificationKeys)+1 > maxVerificationKeys {
+		return fmt.Errorf("--backup-verification-keys: %d keys besides the keypair's own are more than --max-verification-keys=%d allows", len(backupVerificationKeys), maxVerificationKeys)
+	}
+	if keyRotationBearerTokenFile != "" {
+		// The HSM key never leaves it, and the JWKS is shared with other
+		// servers, which wouldn't learn of the new key.
+		if pkcs11Library != "" || jwksURL != "" {
+			return fmt.Errorf("--key-rotation-bearer-token-file can't be used with --pkcs11-library or --jwks-url")
+		}
+		if keyRotationOverlap < 0 {
+			return fmt.Errorf("--key-rotation-overlap can't be negative")
+		}
+		if keyRotationOverlap > 0 && keyRotationOverlap < tokenTtl {
+			return fmt.Errorf("--key-rotation-overlap must be at least --token-ttl, or tokens signed before a rotation would be cut short")
+		}
+	}
+	if pkcs11Library != "" {
+		if pkcs11KeyLabel == "" || pkcs11PinFile == "" {
+			return fmt.Errorf("--pkcs11-library requires --pkcs11-key-label and --pkcs11-pin-file")
+		}
+		if devMode {
+			return fmt.Errorf("--pkcs11-library can't be used with --dev")
+		}
+		// The HSM doesn't tell when the key was generated.
+		if signingKeyMaxAge > 0 {
+			return fmt.Errorf("--signing-key-max-age can't be used with --pkcs11-library")
+		}
+	}
+
+	for _, key := range requiredAssertions {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("--required-assertions can't contain an empty assertion name")
+		}
+	}
+
+	// Stale tokens the authorizer couldn't tell apart would keep all of
+	// their permissions.
+	if tokenGrace > 0 && staleExtraKey == "" {
+		return fmt.Errorf("--token-grace-period requires --stale-extra-key")
+	}
+	if tokenLeeway.Skew < 0 || tokenLeeway.ExpiryLeeway < 0 || tokenLeeway.NotBeforeLeeway < 0 || tokenLeeway.IssuedAtLeeway < 0 {
+		return fmt.Errorf("--clock-skew and the leeways can't be negative")
+	}
+	// The grace period is a longer expiry leeway, with stale tokens
+	// marked as such.
+	if tokenGrace > 0 && tokenLeeway != (token.LeewayOptions{}) {
+		return fmt.Errorf("--token-grace-period can't be combined with --clock-skew or the leeways")
+	}
+	if tokenCacheSize < 0 {
+		return fmt.Errorf("--token-cache-size can't be negative")
+	}
+	if tokenCacheSize > 0 && tokenCacheTtl <= 0 {
+		return fmt.Errorf("--token-cache-ttl must be positive with --token-cache-size")
+	}
+
+	if (ldapKerberosPrincipal == "") != (ldapKerberosKeytab == "") {
+		return fmt.Errorf("--ldap-kerberos-principal and --ldap-kerberos-keytab must be set together")
+	}
+	if ldapKerberosPrincipal != "" && (ldapSearchUserPassword != "" || ldapSearchUserPasswordFile != "") {
+		return fmt.Errorf("--ldap-kerberos-principal can't be used with --ldap-search-user-password or --ldap-search-user-password-file")
+	}
+
+	for _, baseDN := range ldapUserBaseDns {
+		if baseDN == "" {
+			return fmt.Errorf("--ldap-user-base-dns can't contain an empty base DN")
+		}
+	}
+	for _, baseDN := range ldapMachineBaseDns {
+		if baseDN == "" {
+			return fmt.Errorf("--ldap-machine-base-dns can't contain an empty base DN")
+		}
+	}
+	if _, err := ldap.ParseSearchScope(ldapUserSearchScope); err != nil {
+		return fmt.Errorf("--ldap-user-search-scope: %v", err)
+	}
+	if _, err := ldap.ParseSearchScope(ldapGroupSearchScope); err != nil {
+		return fmt.Errorf("--ldap-group-search-scope: %v", err)
+	}
+	switch ldapMultipleMatchPolicy {
+	case ldap.MultipleMatchReject:
+	case ldap.MultipleMatchTiebreak:
+		if ldapTiebreakAttribute == "" {
+			return fmt.Errorf("--ldap-multiple-match-policy=%s requires --ldap-tiebreak-attribute", ldap.MultipleMatchTiebreak)
+		}
+	default:
+		return fmt.Errorf("--ldap-multiple-match-policy must be %q or %q", ldap.MultipleMatchReject, ldap.MultipleMatchTiebreak)
+	}
+	if ldapSearchLimitPolicy != ldap.SearchLimitFail && ldapSearchLimitPolicy != ldap.SearchLimitNarrow {
+		return fmt.Errorf("--ldap-search-limit-policy must be %q or %q", ldap.SearchLimitFail, ldap.SearchLimitNarrow)
+	}
+	if ldapSearchLimitFilter != "" {
+		if err := ldap.CheckSearchFilter(ldapSearchLimitFilter); err != nil {
+			return fmt.Errorf("--ldap-search-limit-filter: %v", err)
+		}
+	}
+
+	if ldapGroupMembership != ldap.MembershipMemberOf && ldapGroupMembership != ldap.MembershipMemberUID {
+		return fmt.Errorf("--ldap-group-membership must be %q or %q", ldap.MembershipMemberOf, ldap.MembershipMemberUID)
+	}
+	if ldapGroupPageRestarts < 0 {
+		return fmt.Errorf("--ldap-group-page-restarts can't be negative")
+	}
+	if groupFailurePolicy != ldap.GroupFailureClosed && groupFailurePolicy != ldap.GroupFailureOpen {
+		return fmt.Errorf("--group-failure-policy must be %q or %q", ldap.GroupFailureClosed, ldap.GroupFailureOpen)
+	}
+	switch credentialControlChars {
+	case ldap.ControlCharsReject, ldap.ControlCharsRejectNull, ldap.ControlCharsAllow:
+	default:
+		return fmt.Errorf("--credential-control-characters must be %q, %q or %q", ldap.ControlCharsReject, ldap.ControlCharsRejectNull, ldap.ControlCharsAllow)
+	}
+	if ldapReadBufferSize < 0 || ldapWriteBufferSize < 0 {
+		return fmt.Errorf("--ldap-read-buffer-size and --ldap-write-buffer-size can't be negative")
+	}
+
+	if passwordChangeMethod != ldap.PasswordChangeExtendedOp && passwordChangeMethod != ldap.PasswordChangeModify {
+		return fmt.Errorf("--password-change-method must be %q or %q", ldap.PasswordChangeExtendedOp, ldap.PasswordChangeModify)
+	}
+
+	if signingKeyAgePolicy != auth.KeyAgeWarn && signingKeyAgePolicy != auth.KeyAgeFail {
+		return fmt.Errorf("--signing-key-age-policy must be %q or %q", auth.KeyAgeWarn, auth.KeyAgeFail)
+	}
+
+	if groupLimitPolicy != auth.GroupLimitTruncate && groupLimitPolicy != auth.GroupLimitReject {
+		return fmt.Errorf("--group-limit-policy must be %q or %q", auth.GroupLimitTruncate, auth.GroupLimitReject)
+	}
+
+	if audienceSource != "" && audienceSource != auth.AudienceFromHost && audienceSource != auth.AudienceFromPath {
+		return fmt.Errorf("--audience-source must be %q or %q", auth.AudienceFromHost, auth.AudienceFromPath)
+	}
+
+	usernameDomains = nil
+	for _, s := range usernameDomainList {
+		domain, err := auth.ParseUsernameDomain(s)
+		if err != nil {
+			return fmt.Errorf("--username-domains: %v", err)
+		}
+		usernameDomains = append(usernameDomains, domain)
+	}
+
+	groupNormalization = nil
+	for _, step := range groupNormalizationSteps {
+		transform, err := auth.ParseGroupNormalization(step)
+		if err != nil {
+			return fmt.Errorf("--group-normalization: %v", err)
+		}
+		groupNormalization = append(groupNormalization, transform)
+	}
+	if originalGroupsAssertion != "" && len(groupNormalization) == 0 {
+		return errors.New("--original-groups-assertion requires --group-normalization")
+	}
+
+	if err := checkServerAssertions(); err != nil {
+		return err
+	}
+	if displayNameAssertion != "" && len(displayNameAttributes) == 0 {
+		return errors.New("--display-name-assertion requires --display-name-attributes")
+	}
+	if displayNameExtraKey != "" && displayNameAssertion == "" {
+		return errors.New("--display-name-extra-key requires --display-name-assertion")
+	}
+	switch machineAccounts {
+	case auth.MachineAccountsAllow, auth.MachineAccountsReject:
+	default:
+		return fmt.Errorf("--machine-accounts must be %s or %s, got %q", auth.MachineAccountsAllow, auth.MachineAccountsReject, machineAccounts)
+	}
+	if len(machineObjectClasses) == 0 && (accountTypeAssertion != "" || machineExtraGroup != "" || machineAccounts != auth.MachineAccountsAllow) {
+		return errors.New("--machine-object-classes can't be empty")
+	}
+
+	mappings, err := loadAssertionMappings()
+	if err != nil {
+		return err
+	}
+	assertionMappings = mappings
+	groupScopes = viper.GetStringMapStringSlice("group-scopes")
+	ttls, err := parseGroupTTLs(viper.GetStringMapString("group-ttls"))
+	if err != nil {
+		return err
+	}
+	groupTTLs = ttls
+
+	if oidcIssuerURL != "" {
+		if err := checkRequired("--oidc-client-id", oidcClientID); err != nil {
+			return err
+		}
+	}
+
+	version, err := auth.ParseTLSVersion(serverTLSMinVersion)
+	if err != nil {
+		return fmt.Errorf("--tls-min-version: %v", err)
+	}
+	tlsMinVersion = version
+	suites, err := auth.ParseCipherSuites(serverTLSCipherSuites)
+	if err != nil {
+		return fmt.Errorf("--tls-cipher-suites: %v", err)
+	}
+	tlsCipherSuites = suites
+	if tlsStrictCipherSuites && len(tlsCipherSuites) == 0 {
+		return errors.New("--tls-strict-cipher-suites requires --tls-cipher-suites")
+	}
+
+	if certBoundTokens && serverTLSClientCAFile == "" {
+		return errors.New("--cert-bound-tokens requires --tls-client-ca-file")
+	}
+	if len(serverTLSClientNames) > 0 && serverTLSClientCAFile == "" {
+		return errors.New("--tls-client-allowed-names requires --tls-client-ca-file")
+	}
+
+	if metricsPort == serverPort && metricsClientCAFile != "" {
+		return errors.New("--metrics-client-ca-file requires --metrics-port to differ from --port")
+	}
+
+	if devMode && serverTlsCertFile == "" && serverTlsPrivateKeyFile == "" {
+		return nil
+	}
+
+	if err := checkRequired("--tls-cert-file", serverTlsCertFile); err != nil {
+		return err
+	}
+	if _, err := os.Stat(serverTlsCertFile); os.IsNotExist(err) {
+		return fmt.Errorf("file %s does not exist", serverTlsCertFile)
+	}
+
+	if err := checkRequired("--tls-private-key", serverTlsPrivateKeyFile); err != nil {
+		return err
+	}
 	if _, err := os.Stat(serverTlsPrivateKeyFile); os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "file %s does not exist\n", serverTlsPrivateKeyFile)
-		os.Exit(1)
+		return fmt.Errorf("file %s does not exist", serverTlsPrivateKeyFile)
 	}
+	return nil
+}
+
+// checkRequired returns an error if a required flag is empty.
+func checkRequired(flagName string, flagValue string) error {
+	if flagValue == "" {
+		return fmt.Errorf("%s is required. \nUse -h flag for help.", flagName)
+	}
+	return nil
 }
 
 func requireFlag(flagName string, flagValue string) {
@@ -193,78 +954,637 @@ func requireFlag(flagName string, flagValue string) {
 	}
 }
 
-func serve() error {
-	if genKeypair {
+func serve(flags *pflag.FlagSet) error {
+	if !devMode && genKeypair {
 		if err := token.GenerateKeypair(keypairDir); err != nil {
 			glog.Errorf("Error generating key pair: %v", err)
 			os.Exit(1)
 		}
 	}
 
-	if !token.KeypairExists(keypairDir) {
-		glog.Errorf("keypair not found in dir %q", keypairDir)
+	if devMode && serverTlsCertFile == "" {
+		glog.Warning("*** DEV MODE: serving a self-signed TLS certificate generated at startup. ***")
+		cert, err := selfSignedCertificate()
+		if err != nil {
+			glog.Errorf("Error generating self-signed certificate: %v", err)
+			os.Exit(1)
+		}
+		serverCertificates = []tls.Certificate{cert}
+	}
+
+	handler, err := newHandler()
+	if err != nil {
+		glog.Errorf("%v", err)
 		os.Exit(1)
 	}
+	reloadable := auth.NewReloadableHandler(handler)
 
-	var err error
-	tokenSigner, err := token.NewSigner(keypairDir)
+	if metricsPort != serverPort {
+		metricsHandler, err := newMetricsHandler()
+		if err != nil {
+			glog.Errorf("Error setting up metrics endpoint: %v", err)
+			os.Exit(1)
+		}
+		go serveMetrics(metricsHandler)
+	}
+
+	reloader, err := newConfigReloader(reloadable, flags)
 	if err != nil {
-		glog.Errorf("Error creating token issuer: %v", err)
+		glog.Errorf("Error setting up config reloading: %v", err)
+		os.Exit(1)
 	}
+	go reloader.watch(notifySIGHUP())
 
-	tokenVerifier, err := token.NewVerifier(keypairDir)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", serverPort),
+		Handler: reloadable,
+	}
+
+	glog.Infof("Serving on %s", fmt.Sprintf(":%d", serverPort))
+
+	tlsOptions := serverTLSOptions(serverTLSClientCAFile)
+	tlsOptions.AllowedClientNames = serverTLSClientNames
+	server.TLSConfig, err = auth.ServerTLSConfig(tlsOptions)
 	if err != nil {
-		glog.Errorf("Error creating token verifier: %v", err)
+		glog.Errorf("Error setting up TLS: %v", err)
+		os.Exit(1)
 	}
 
-	ldapTLSConfig := &tls.Config{
-		ServerName:         ldapHost,
-		InsecureSkipVerify: ldapSkipTlsVerification,
+	glog.Fatal(server.ListenAndServeTLS("", ""))
+	return nil
+}
+
+// serverTLSOptions returns the TLS options of our listeners, requiring
+// client certificates signed by clientCAFile if it is set.
+func serverTLSOptions(clientCAFile string) auth.ServerTLSOptions {
+	return auth.ServerTLSOptions{
+		Certificates: serverCertificates,
+		CertFile:     serverTlsCertFile,
+		KeyFile:      serverTlsPrivateKeyFile,
+		ClientCAFile: clientCAFile,
+		MinVersion:   tlsMinVersion,
+		CipherSuites: tlsCipherSuites,
 	}
+}
+
+// newLDAPTLSConfig returns the TLS config of connections to the LDAP
+// server at host, with the same minimum version and cipher suites as our
+// listeners. Unlike them it doesn't go below TLS 1.2, Go's default for
+// clients, as the --tls-min-version default would.
+func newLDAPTLSConfig(host string, skipVerify bool) *tls.Config {
+	minVersion := tlsMinVersion
+	if minVersion < tls.VersionTLS12 {
+		minVersion = tls.VersionTLS12
+	}
+	return &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: skipVerify,
+		MinVersion:         minVersion,
+		CipherSuites:       tlsCipherSuites,
+	}
+}
+
+// newTokenKeys returns the signer and verifier for tokens, loading the
+// keys from --keypair-dir or, in dev mode, generating them once.
+func newTokenKeys() (token.Signer, token.Verifier, error) {
+	signerOptions := token.SignerOptions{
+		CompressionThreshold: tokenCompressionThreshold,
+	}
+
+	if devMode {
+		if devSigner == nil {
+			glog.Warning("*** DEV MODE: tokens are signed with an ephemeral in-memory key. This is INSECURE and tokens stop working when the process exits. Do not use in production. ***")
+			signer, verifier, err := token.NewEphemeralSigner(signerOptions)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Error generating ephemeral key pair: %v", err)
+			}
+			devSigner, devVerifier = signer, verifier
+			devKeyCreated = time.Now()
+		}
+		return devSigner, devVerifier, nil
+	}
+	if pkcs11Library != "" {
+		return newHSMTokenKeys(signerOptions)
+	}
+
+	if !token.KeypairExists(keypairDir) {
+		return nil, nil, fmt.Errorf("keypair not found in dir %q", keypairDir)
+	}
+
+	tokenSigner, err := token.NewSigner(keypairDir, signerOptions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating token issuer: %v", err)
+	}
+
+	var tokenVerifier token.Verifier
+	if jwksURL != "" {
+		tokenVerifier, err = token.NewJWKSVerifier(jwksURL, jwksRefreshInterval, jwksFetchOptions())
+	} else {
+		tokenVerifier, err = token.NewVerifierWithBackupKeys(keypairDir, backupVerificationKeys)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating token verifier: %v", err)
+	}
+	return tokenSigner, tokenVerifier, nil
+}
+
+// jwksFetchOptions is how remote keys are fetched.
+func jwksFetchOptions() token.FetchOptions {
+	return token.FetchOptions{
+		Timeout:      jwksFetchTimeout,
+		Retries:      jwksFetchRetries,
+		RetryBackoff: jwksFetchBackoff,
+		MaxKeys:      maxVerificationKeys,
+	}
+}
+
+// newHandler builds the server's endpoints from the current
+// configuration.
+func newHandler() (http.Handler, error) {
+	state := currentState
+	handler, err := buildHandler(&state)
+	if err != nil {
+		return nil, err
+	}
+	state.replace()
+	return handler, nil
+}
+
+// buildHandler builds the endpoints from the loaded configuration,
+// reusing the stateful components in state that are still configured
+// the same, and recording those it creates.
+func buildHandler(state *handlerState) (http.Handler, error) {
+	tokenSigner, tokenVerifier, err := newTokenKeys()
+	if err != nil {
+		return nil, err
+	}
+	var rotator *token.KeyRotator
+	if keyRotationBearerTokenFile != "" {
+		rotator = newKeyRotator(tokenSigner, tokenVerifier)
+		tokenSigner, tokenVerifier = rotator, rotator
+	}
+	tokenInspector, _ := tokenVerifier.(token.Inspector)
+	var keyListers []token.KeyLister
+	if lister, ok := tokenVerifier.(token.KeyLister); ok {
+		keyListers = append(keyListers, lister)
+	}
+	if tokenEncryptionKeypairDir != "" {
+		tokenSigner, err = token.NewEncryptingSigner(tokenSigner, tokenEncryptionKeypairDir)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading token encryption key: %v", err)
+		}
+		tokenVerifier, err = token.NewDecryptingVerifier(tokenVerifier, tokenEncryptionKeypairDir)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading token decryption key: %v", err)
+		}
+		if tokenInspector != nil {
+			tokenInspector, err = token.NewDecryptingInspector(tokenInspector, tokenEncryptionKeypairDir)
+			if err != nil {
+				return nil, fmt.Errorf("Error loading token decryption key: %v", err)
+			}
+		}
+	}
+
+	// Only /authenticate accepts tokens during the grace period, so stale
+	// tokens can't be refreshed or exchanged.
+	webhookVerifier := tokenVerifier
+	if tokenGrace > 0 {
+		if tokenInspector == nil {
+			return nil, fmt.Errorf("--token-grace-period isn't supported by the token verifier")
+		}
+		webhookVerifier = token.NewGraceVerifier(tokenInspector, tokenGrace)
+	}
+	if tokenLeeway != (token.LeewayOptions{}) {
+		if tokenInspector == nil {
+			return nil, fmt.Errorf("--clock-skew and the leeways aren't supported by the token verifier")
+		}
+		webhookVerifier = token.NewLeewayVerifier(tokenInspector, tokenLeeway)
+	}
+
+	// /renew and /refresh only exchange tokens we issued: an OIDC ID
+	// token must not become a token signed with our key.
+	nativeVerifier := tokenVerifier
+	if oidcIssuerURL != "" {
+		oidcVerifier, err := token.NewOIDCVerifier(oidcIssuerURL, oidcClientID, token.OIDCPrefixes{Username: oidcUsernamePrefix, Groups: oidcGroupsPrefix}, jwksRefreshInterval, jwksFetchOptions())
+		if err != nil {
+			return nil, fmt.Errorf("Error creating OIDC token verifier: %v", err)
+		}
+		tokenVerifier = token.NewMultiVerifier(tokenVerifier, oidcVerifier)
+		webhookOIDCVerifier := oidcVerifier
+		if inspector, ok := oidcVerifier.(token.Inspector); ok && tokenLeeway != (token.LeewayOptions{}) {
+			webhookOIDCVerifier = token.NewLeewayVerifier(inspector, tokenLeeway)
+		}
+		webhookVerifier = token.NewMultiVerifier(webhookVerifier, webhookOIDCVerifier)
+		if lister, ok := oidcVerifier.(token.KeyLister); ok {
+			keyListers = append(keyListers, lister)
+		}
+	}
+
+	// Cached tokens never outlive their expiry, and the wrappers added
+	// below, e.g. --max-token-age, still check them on every request.
+	webhookVerifier = state.cachingVerifier(webhookVerifier)
+
+	ldapTLSConfig := newLDAPTLSConfig(ldapHost, ldapSkipTlsVerification)
 
 	ldapClient := &ldap.Client{
-		BaseDN:             ldapBaseDn,
-		LdapServer:         ldapHost,
-		LdapPort:           ldapPort,
-		UseInsecure:        ldapUseInsecure,
-		UserLoginAttribute: ldapUserAttribute,
-		SearchUserDN:       ldapSearchUserDn,
-		SearchUserPassword: ldapSearchUserPassword,
-		TLSConfig:          ldapTLSConfig,
+		BaseDN:               ldapBaseDn,
+		UserBaseDNs:          ldapUserBaseDns,
+		MachineBaseDNs:       ldapMachineBaseDns,
+		LdapServer:           ldapHost,
+		LdapPort:             ldapPort,
+		UseInsecure:          ldapUseInsecure,
+		UserLoginAttribute:   ldapUserAttribute,
+		SearchUserDN:         ldapSearchUserDn,
+		SearchUserPassword:   ldapSearchUserPassword,
+		TLSConfig:            ldapTLSConfig,
+		UserSearchScope:      ldapUserSearchScope,
+		MultipleMatchPolicy:  ldapMultipleMatchPolicy,
+		TiebreakAttribute:    ldapTiebreakAttribute,
+		SearchLimitPolicy:    ldapSearchLimitPolicy,
+		SearchLimitFilter:    ldapSearchLimitFilter,
+		GroupMembership:      ldapGroupMembership,
+		GroupBaseDN:          ldapGroupBaseDn,
+		GroupSearchScope:     ldapGroupSearchScope,
+		GroupUIDAttribute:    ldapGroupUIDAttribute,
+		GroupPageSize:        ldapGroupPageSize,
+		GroupPageRestarts:    ldapGroupPageRestarts,
+		GroupFailurePolicy:   groupFailurePolicy,
+		ControlCharPolicy:    credentialControlChars,
+		MaxIdleConns:         ldapMaxIdleConns,
+		IdleTimeout:          ldapIdleTimeout,
+		TCPKeepAlive:         ldapTCPKeepAlive,
+		DisableTCPNoDelay:    !ldapTCPNoDelay,
+		ReadBufferSize:       ldapReadBufferSize,
+		WriteBufferSize:      ldapWriteBufferSize,
+		NegativeCacheTTL:     ldapNegativeCacheTTL,
+		NegativeCacheSize:    ldapNegativeCacheSize,
+		MaxConcurrentBinds:   ldapMaxConcurrentBinds,
+		BindQueueSize:        ldapBindQueueSize,
+		BindQueueTimeout:     ldapBindQueueTimeout,
+		PasswordChangeMethod: passwordChangeMethod,
+		WritableServer:       ldapWritableHost,
+		WritablePort:         ldapWritablePort,
+		PasswordPolicy:       passwordResetResponse || passwordPolicyWarnings || refuseGraceLogins,
+		RequireTLS:           ldapRequireTLS,
+		WhoAmI:               ldapWhoAmI,
+		SearchAsUser:         ldapSearchAsUser,
+	}
+
+	state.ldapClient = ldapClient
+
+	if ldapSearchUserPasswordFile != "" {
+		ldapClient.SecretProvider = &ldap.FileSecretProvider{
+			DN:           ldapSearchUserDn,
+			PasswordFile: ldapSearchUserPasswordFile,
+		}
 	}
 
-	server := &http.Server{Addr: fmt.Sprintf(":%d", serverPort)}
+	if ldapKerberosPrincipal != "" {
+		kerberos, err := ldap.NewKerberosKeytab(ldapKerberosPrincipal, ldapKerberosKeytab, ldapKerberosConfig)
+		if err != nil {
+			return nil, err
+		}
+		kerberos.ServicePrincipal = ldapKerberosSPN
+		ldapClient.Kerberos = kerberos
+		glog.Infof("Search user binds with GSSAPI as %s", ldapKerberosPrincipal)
+	} else if ldapSearchUserDn != "" {
+		glog.Infof("Search user binds with a simple bind as %s, Kerberos isn't configured", ldapSearchUserDn)
+	}
 
-	webhook := auth.NewTokenWebhook(tokenVerifier)
+	var authenticator ldap.Authenticator = ldapClient
+	if staticUsersFile != "" {
+		staticUsers, err := ldap.LoadStaticUsers(staticUsersFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading static users: %v", err)
+		}
+		staticUsers.UsernameAttribute = usernameAttribute
+		authenticator = ldap.Fallback{Primary: ldapClient, Secondary: staticUsers}
+		glog.Infof("Loaded %d static users, tried while LDAP is unreachable", len(staticUsers.Users))
+	}
 
-	ldapTokenIssuer := &auth.LDAPTokenIssuer{
-		LDAPAuthenticator:     ldapClient,
-		TokenSigner:           tokenSigner,
-		TTL:                   tokenTtl,
-		UsernameAttribute:     usernameAttribute,
-		EnforceClientVersions: enforceClientVersions,
+	var groupMapper auth.GroupMapper
+	if groupMappingFile != "" {
+		mapping, err := auth.NewGroupMappingFile(groupMappingFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading group mapping: %v", err)
+		}
+		groupMapper = mapping
 	}
 
+	webhookVerifier = token.NewRequiredAssertionsVerifier(webhookVerifier, requiredAssertions)
+	webhook := auth.NewTokenWebhook(token.NewMaxAgeVerifier(webhookVerifier, maxTokenAge))
+	webhook.TokenPrefix = tokenPrefix
+	webhook.AuthMethodExtraKey = authMethodExtraKey
+	webhook.ScopesExtraKey = scopesExtraKey
+	webhook.StaleExtraKey = staleExtraKey
+	webhook.DisplayNameExtraKey = displayNameExtraKey
+	webhook.DisplayNameAssertion = displayNameAssertion
+	webhook.Audiences = webhookAudiences
+	webhook.UnauthenticatedOnAudienceMismatch = audienceMismatchUnauthenticated
+
+	issuedTokens := state.issuedTokenLog()
+	userRateLimiter := state.rateLimiter()
+
+	ldapTokenIssuer := newTokenIssuer(authenticator, tokenSigner)
+	ldapTokenIssuer.GroupMapper = groupMapper
+	ldapTokenIssuer.UserRateLimiter = userRateLimiter
+	ldapTokenIssuer.IssuedTokens = issuedTokens
+
+	mux := http.NewServeMux()
+
 	// Endpoint for authenticating with token
-	http.Handle("/authenticate", webhook)
+	mux.Handle("/authenticate", webhook)
 
 	// Endpoint for token issuance after LDAP auth
-	http.Handle("/ldapAuth", ldapTokenIssuer)
-	//for prometheus metrics
-	http.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/ldapAuth", ldapTokenIssuer)
+
+	if enablePasswordChange {
+		// Endpoint for users to change their LDAP password
+		mux.Handle("/changePassword", &auth.PasswordChangeHandler{PasswordChanger: ldapClient, UnavailableRetryAfter: unavailableRetryAfter, UsernameDomains: usernameDomains})
+	}
+
+	if refreshTokenTtl > 0 {
+		// Endpoint for exchanging a refresh token for a new token
+		mux.Handle("/refresh", &auth.TokenRefresher{
+			TokenVerifier:    nativeVerifier,
+			TokenSigner:      tokenSigner,
+			TTL:              tokenTtl,
+			GroupTTLs:        issuedGroupTTLs(),
+			TokenPrefix:      tokenPrefix,
+			IssuedTokens:     issuedTokens,
+			MaxGroups:        maxGroups,
+			GroupLimitPolicy: groupLimitPolicy,
+		})
+	}
+
+	if tokenRenewalWindow > 0 {
+		// Endpoint for renewing an access token without LDAP
+		mux.Handle("/renew", &auth.TokenRenewer{
+			TokenVerifier:    nativeVerifier,
+			TokenSigner:      tokenSigner,
+			TTL:              tokenTtl,
+			CertBoundTTL:     certBoundTokenTtl,
+			GroupTTLs:        issuedGroupTTLs(),
+			RenewalWindow:    tokenRenewalWindow,
+			TokenPrefix:      tokenPrefix,
+			IssuedTokens:     issuedTokens,
+			MaxGroups:        maxGroups,
+			GroupLimitPolicy: groupLimitPolicy,
+		})
+	}
+
+	// Endpoint for users to check the identity their token grants
+	mux.Handle("/whoami", &auth.WhoAmIHandler{
+		TokenVerifier: tokenVerifier,
+		TokenPrefix:   tokenPrefix,
+	})
+
+	if introspectionBearerTokenFile != "" {
+		clientToken, err := readBearerTokenFile(introspectionBearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error setting up the introspection endpoint: %v", err)
+		}
+		// Endpoint for OAuth 2.0 token introspection (RFC 7662)
+		mux.Handle("/introspect", auth.RequireBearerToken(clientToken, &auth.TokenIntrospector{
+			TokenVerifier: tokenVerifier,
+			TokenPrefix:   tokenPrefix,
+			Audiences:     introspectionAudiences,
+		}))
+	}
+
+	if bulkIssueBearerTokenFile != "" {
+		clientToken, err := readBearerTokenFile(bulkIssueBearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error setting up the bulk issuance endpoint: %v", err)
+		}
+		// Endpoint for issuing tokens for a batch of users, e.g. service
+		// accounts, without LDAP
+		mux.Handle("/bulkIssue", auth.RequireBearerToken(clientToken, &auth.BulkTokenIssuer{
+			TokenSigner:      tokenSigner,
+			TTL:              tokenTtl,
+			MaxTTL:           bulkIssueMaxTTL,
+			TokenPrefix:      tokenPrefix,
+			IssuedTokens:     issuedTokens,
+			GroupMapper:      groupMapper,
+			MaxGroups:        maxGroups,
+			GroupLimitPolicy: groupLimitPolicy,
+			PriorityGroups:   priorityGroups,
+			GroupPrefix:      groupPrefix,
+		}))
+	}
+
+	if verificationKeysBearerTokenFile != "" {
+		clientToken, err := readBearerTokenFile(verificationKeysBearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error setting up the verification keys endpoint: %v", err)
+		}
+		// Endpoint listing the public metadata of the trusted keys
+		mux.Handle("/verificationKeys", auth.RequireBearerToken(clientToken, &auth.VerificationKeysHandler{
+			KeyListers: keyListers,
+		}))
+	}
+
+	if rotator != nil {
+		clientToken, err := readKeyRotationBearerToken()
+		if err != nil {
+			return nil, fmt.Errorf("Error setting up the key rotation endpoint: %v", err)
+		}
+		// Endpoint replacing the signing key
+		mux.Handle("/rotateKey", auth.RequireBearerToken(clientToken, &auth.KeyRotationHandler{
+			Rotator: rotator,
+		}))
+	}
+
+	if issuedTokens != nil {
+		clientToken, err := readBearerTokenFile(issuedTokensBearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error setting up the issued tokens endpoint: %v", err)
+		}
+		// Endpoint dumping the metadata of the last tokens issued
+		mux.Handle("/issuedTokens", auth.RequireBearerToken(clientToken, &auth.IssuedTokensHandler{
+			Log: issuedTokens,
+		}))
+	}
+
+	if metricsPort == serverPort {
+		metricsHandler, err := newMetricsHandler()
+		if err != nil {
+			return nil, fmt.Errorf("Error setting up metrics endpoint: %v", err)
+		}
+		mux.Handle("/metrics", metricsHandler)
+	}
 
 	//health
-	http.Handle("/health", &healthHandler{})
+	mux.Handle("/health", &healthHandler{})
 
-	glog.Infof("Serving on %s", fmt.Sprintf(":%d", serverPort))
+	readiness, err := newReadinessHandler()
+	if err != nil {
+		return nil, err
+	}
+	mux.Handle("/readyz", readiness)
 
-	server.TLSConfig = &tls.Config{
-		// Change default from SSLv3 to TLSv1.0 (because of POODLE vulnerability)
-		MinVersion: tls.VersionTLS10,
+	tenants, err := loadTenants()
+	if err != nil {
+		return nil, fmt.Errorf("Error loading tenants: %v", err)
+	}
+	if len(tenants) == 0 {
+		return mux, nil
 	}
 
-	glog.Fatal(server.ListenAndServeTLS(serverTlsCertFile, serverTlsPrivateKeyFile))
-	return nil
+	router := auth.NewTenantRouter(mux)
+	for _, tc := range tenants {
+		handler, err := newTenantHandler(tc)
+		if err != nil {
+			return nil, fmt.Errorf("Error setting up tenant: %v", err)
+		}
+		router.AddTenant(tc.Name, tc.Host, handler)
+		glog.Infof("Serving tenant %q under /tenants/%s/", tc.Name, tc.Name)
+	}
+	return router, nil
+}
+
+// newTokenIssuer returns the /ldapAuth issuer configured by the flags,
+// authenticating users with authenticator and signing their tokens with
+// signer. Tenants are built with it too, so that their tokens get the
+// same group limits and assertions as those of the default directory.
+func newTokenIssuer(authenticator ldap.Authenticator, signer token.Signer) *auth.LDAPTokenIssuer {
+	return &auth.LDAPTokenIssuer{
+		LDAPAuthenticator:       authenticator,
+		TokenSigner:             signer,
+		TTL:                     tokenTtl,
+		UsernameAttribute:       usernameAttribute,
+		UsernameRealm:           usernameRealm,
+		UsernameDomains:         usernameDomains,
+		EnforceClientVersions:   enforceClientVersions,
+		MinPasswordLength:       minPasswordLength,
+		ExtraGroups:             extraGroups,
+		AdminGroupDN:            adminGroupDn,
+		AdminExtraGroup:         adminExtraGroup,
+		TokenPrefix:             tokenPrefix,
+		UIDAttribute:            uidAttribute,
+		HashedUIDFallback:       uidHashFallback,
+		DNAssertion:             dnAssertion,
+		AuthTimeAssertion:       authTimeAssertion,
+		ServerAssertion:         serverAssertion,
+		DisplayNameAssertion:    displayNameAssertion,
+		DisplayNameAttributes:   displayNameAttributes,
+		AccountTypeAssertion:    accountTypeAssertion,
+		MachineObjectClasses:    machineObjectClasses,
+		MachineAccountPolicy:    machineAccounts,
+		MachineExtraGroup:       machineExtraGroup,
+		AssertionMappings:       assertionMappings,
+		GroupNameAttribute:      groupNameAttribute,
+		OUGroups:                ouGroups,
+		GroupScopes:             groupScopes,
+		GroupTTLs:               groupTTLs,
+		MaxGroups:               maxGroups,
+		GroupLimitPolicy:        groupLimitPolicy,
+		AudienceSource:          audienceSource,
+		PriorityGroups:          priorityGroups,
+		GroupPrefix:             groupPrefix,
+		GroupNormalization:      groupNormalization,
+		OriginalGroupsAssertion: originalGroupsAssertion,
+		RefreshTTL:              refreshTokenTtl,
+		CertBound:               certBoundTokens,
+		CertBoundTTL:            certBoundTokenTtl,
+		PasswordResetResponse:   passwordResetResponse,
+		PasswordResetMessage:    passwordResetMessage,
+		RefuseGraceLogins:       refuseGraceLogins,
+		UnavailableRetryAfter:   unavailableRetryAfter,
+	}
+}
+
+// newReadinessHandler returns the /readyz handler for the signing key in
+// use.
+func newReadinessHandler() (*auth.ReadinessHandler, error) {
+	keyCreated := devKeyCreated
+	if pkcs11Library != "" {
+		keyCreated = hsmOpened
+	} else if !devMode {
+		var err error
+		keyCreated, err = token.KeyCreated(keypairDir)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading signing key age: %v", err)
+		}
+	}
+
+	readiness := auth.NewReadinessHandler(keyCreated)
+	readiness.KeyMaxAge = signingKeyMaxAge
+	readiness.KeyAgePolicy = signingKeyAgePolicy
+	if age := time.Since(keyCreated); signingKeyMaxAge > 0 && age > signingKeyMaxAge {
+		glog.Warningf("The signing key is %s old, over --signing-key-max-age of %s; it should be rotated", age.Round(time.Second), signingKeyMaxAge)
+	}
+	return readiness, nil
+}
+
+// newMetricsHandler returns the prometheus handler, behind a bearer token
+// check if --metrics-bearer-token-file is set.
+func newMetricsHandler() (http.Handler, error) {
+	var handler http.Handler = promhttp.Handler()
+	if metricsBearerTokenFile == "" {
+		return handler, nil
+	}
+
+	bearerToken, err := readBearerTokenFile(metricsBearerTokenFile)
+	if err != nil {
+		return nil, err
+	}
+	return auth.RequireBearerToken(bearerToken, handler), nil
+}
+
+// baseDNList reads a list of DNs, from a config file list or a
+// semicolon-separated flag, since DNs contain commas.
+func baseDNList(value interface{}) []string {
+	var dns []string
+	if list, ok := value.([]interface{}); ok {
+		for _, dn := range list {
+			dns = append(dns, strings.TrimSpace(cast.ToString(dn)))
+		}
+		return dns
+	}
+	s := cast.ToString(value)
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	for _, dn := range strings.Split(s, ";") {
+		dns = append(dns, strings.TrimSpace(dn))
+	}
+	return dns
+}
+
+// readBearerTokenFile reads a bearer token that clients must present,
+// refusing an empty one.
+func readBearerTokenFile(file string) (string, error) {
+	bearerToken, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	trimmed := strings.TrimSpace(string(bearerToken))
+	if trimmed == "" {
+		return "", fmt.Errorf("bearer token file %s is empty", file)
+	}
+	return trimmed, nil
+}
+
+// serveMetrics serves the metrics endpoint on its own TLS listener so it
+// isn't exposed on the webhook port.
+func serveMetrics(handler http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+
+	tlsConfig, err := auth.ServerTLSConfig(serverTLSOptions(metricsClientCAFile))
+	if err != nil {
+		glog.Errorf("Error setting up metrics TLS: %v", err)
+		os.Exit(1)
+	}
+	metricsServer := &http.Server{
+		Addr:      net.JoinHostPort(metricsAddress, fmt.Sprint(metricsPort)),
+		Handler:   mux,
+		TLSConfig: tlsConfig,
+	}
+
+	glog.Infof("Serving metrics on %s", metricsServer.Addr)
+	glog.Fatal(metricsServer.ListenAndServeTLS("", ""))
 }
 
 type healthHandler struct{}
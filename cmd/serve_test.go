@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/proofpoint/kubernetes-ldap/auth"
+	"github.com/proofpoint/kubernetes-ldap/token"
+	"github.com/spf13/viper"
+)
+
+// loadTestConfig loads config as the config file. Like a reload, it
+// first resets the settings left over from earlier loads.
+func loadTestConfig(t *testing.T, config string) error {
+	resetUnsetFlags(RootCmd.Flags())
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatalf("writing config file: %v", err)
+	}
+	viper.SetConfigFile(configFile)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatalf("reading config file: %v", err)
+	}
+	return loadConfig()
+}
+
+func TestTLSCipherSuitePolicy(t *testing.T) {
+	err := loadTestConfig(t, testConfig+`
+tls-min-version: "1.2"
+tls-cipher-suites: [TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384]
+`)
+	if err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+	expected := []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}
+
+	serverOptions := serverTLSOptions("")
+	serverOptions.Certificates = []tls.Certificate{{}}
+	serverConfig, err := auth.ServerTLSConfig(serverOptions)
+	if err != nil {
+		t.Fatalf("creating server TLS config: %v", err)
+	}
+	if !reflect.DeepEqual(serverConfig.CipherSuites, expected) || serverConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("Expected the listener to allow %v from TLS 1.2, got %v from %x", expected, serverConfig.CipherSuites, serverConfig.MinVersion)
+	}
+
+	// The same policy applies to LDAP, including tenants and the
+	// writable server, which share newLDAPTLSConfig.
+	ldapConfig := newLDAPTLSConfig("ldap.example.com", false)
+	if !reflect.DeepEqual(ldapConfig.CipherSuites, expected) || ldapConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("Expected LDAP to allow %v from TLS 1.2, got %v from %x", expected, ldapConfig.CipherSuites, ldapConfig.MinVersion)
+	}
+	if ldapConfig.ServerName != "ldap.example.com" {
+		t.Errorf("Expected the LDAP server name to be set, got %q", ldapConfig.ServerName)
+	}
+
+	// A lower listener minimum doesn't weaken LDAP below Go's default.
+	if err := loadTestConfig(t, testConfig+"tls-min-version: \"1.0\"\n"); err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+	if version := newLDAPTLSConfig("ldap.example.com", false).MinVersion; version != tls.VersionTLS12 {
+		t.Errorf("Expected LDAP to require TLS 1.2, got %x", version)
+	}
+	if version := serverTLSOptions("").MinVersion; version != tls.VersionTLS10 {
+		t.Errorf("Expected the listener to allow TLS 1.0, got %x", version)
+	}
+
+	rejected := map[string]string{
+		"strict without suites":     "tls-strict-cipher-suites: true\n",
+		"strict with an empty list": "tls-strict-cipher-suites: true\ntls-cipher-suites: []\n",
+		"unknown suite":             "tls-cipher-suites: [TLS_NOT_A_SUITE]\n",
+		"insecure suite":            "tls-cipher-suites: [TLS_RSA_WITH_RC4_128_SHA]\n",
+	}
+	for name, config := range rejected {
+		if err := loadTestConfig(t, testConfig+config); err == nil {
+			t.Errorf("%s: expected the config to be rejected", name)
+		}
+	}
+	if err := loadTestConfig(t, testConfig+"tls-strict-cipher-suites: true\ntls-cipher-suites: [TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384]\n"); err != nil {
+		t.Errorf("expected strict mode with suites to be accepted: %v", err)
+	}
+	if err := loadTestConfig(t, testConfig); err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+}
+
+func TestUserBaseDNsConfig(t *testing.T) {
+	expected := []string{"ou=employees,dc=example,dc=com", "ou=contractors,dc=example,dc=com"}
+	for _, config := range []string{
+		"ldap-user-base-dns:\n- ou=employees,dc=example,dc=com\n- ou=contractors,dc=example,dc=com",
+		"ldap-user-base-dns: ou=employees,dc=example,dc=com; ou=contractors,dc=example,dc=com",
+	} {
+		if err := loadTestConfig(t, testConfig+config+"\n"); err != nil {
+			t.Fatalf("loading config: %v", err)
+		}
+		if !reflect.DeepEqual(ldapUserBaseDns, expected) {
+			t.Errorf("%s: expected %v, got %v", config, expected, ldapUserBaseDns)
+		}
+	}
+	if err := loadTestConfig(t, testConfig); err != nil || ldapUserBaseDns != nil {
+		t.Errorf("expected no user base DNs by default, got %v, %v", ldapUserBaseDns, err)
+	}
+}
+
+func TestMaxIssuedAtSkewConfig(t *testing.T) {
+	if err := loadTestConfig(t, testConfig+"max-issued-at-skew: 5m\n"); err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+	if tokenLeeway != (token.LeewayOptions{IssuedAtLeeway: 5 * time.Minute}) {
+		t.Errorf("expected --max-issued-at-skew to set the issued-at leeway, got %+v", tokenLeeway)
+	}
+}
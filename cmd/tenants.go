@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/proofpoint/kubernetes-ldap/auth"
+	"github.com/proofpoint/kubernetes-ldap/ldap"
+	"github.com/proofpoint/kubernetes-ldap/token"
+	"github.com/spf13/viper"
+)
+
+// tenantConfig is one entry of the "tenants" list in the config file.
+// Each tenant has its own directory and keypair, and is served under
+// /tenants/<name>/ or on its own host name.
+type tenantConfig struct {
+	Name string `mapstructure:"name"`
+	Host string `mapstructure:"host"`
+
+	LDAPHost                string `mapstructure:"ldap-host"`
+	LDAPPort                uint   `mapstructure:"ldap-port"`
+	LDAPBaseDN              string `mapstructure:"ldap-base-dn"`
+	LDAPUserAttribute       string `mapstructure:"ldap-user-attribute"`
+	LDAPSearchUserDN        string `mapstructure:"ldap-search-user-dn"`
+	LDAPSearchUserPassword  string `mapstructure:"ldap-search-user-password"`
+	LDAPSkipTLSVerification bool   `mapstructure:"ldap-skip-tls-verification"`
+	UseInsecure             bool   `mapstructure:"use-insecure"`
+
+	KeypairDir        string        `mapstructure:"keypair-dir"`
+	SigningAlgorithm  string        `mapstructure:"signing-algorithm"`
+	TokenTTL          time.Duration `mapstructure:"token-ttl"`
+	UsernameAttribute string        `mapstructure:"username-attribute"`
+	TokenPrefix       string        `mapstructure:"token-prefix"`
+}
+
+// loadTenants reads and validates the tenants from the config file.
+func loadTenants() ([]tenantConfig, error) {
+	var tenants []tenantConfig
+	if err := viper.UnmarshalKey("tenants", &tenants); err != nil {
+		return nil, fmt.Errorf("reading tenants: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for i := range tenants {
+		tc := &tenants[i]
+		if tc.Name == "" {
+			return nil, fmt.Errorf("tenant %d has no name", i)
+		}
+		if seen[tc.Name] {
+			return nil, fmt.Errorf("tenant %q is defined more than once", tc.Name)
+		}
+		seen[tc.Name] = true
+
+		if tc.LDAPHost == "" || tc.LDAPBaseDN == "" || tc.KeypairDir == "" {
+			return nil, fmt.Errorf("tenant %q: ldap-host, ldap-base-dn and keypair-dir are required", tc.Name)
+		}
+		if err := token.CheckAlgorithm(tc.SigningAlgorithm); err != nil {
+			return nil, fmt.Errorf("tenant %q: signing-algorithm: %v", tc.Name, err)
+		}
+		if ldapRequireTLS && tc.UseInsecure {
+			return nil, fmt.Errorf("tenant %q: --ldap-require-tls is set, but use-insecure disables LDAP TLS", tc.Name)
+		}
+		if tc.LDAPPort == 0 {
+			tc.LDAPPort = 389
+		}
+		if tc.LDAPUserAttribute == "" {
+			tc.LDAPUserAttribute = "uid"
+		}
+		if tc.UsernameAttribute == "" {
+			tc.UsernameAttribute = "uid"
+		}
+		if tc.SigningAlgorithm == "" {
+			tc.SigningAlgorithm = token.AlgorithmES256
+		}
+		if tc.TokenTTL == 0 {
+			tc.TokenTTL = 24 * time.Hour
+		}
+	}
+	return tenants, nil
+}
+
+// newTenantHandler builds the /ldapAuth and /authenticate endpoints of a
+// tenant, with its own signer, verifier and LDAP client. The keypair must
+// be for the tenant's signing algorithm, and only tokens signed with it
+// verify.
+func newTenantHandler(tc tenantConfig) (http.Handler, error) {
+	if !token.KeypairExists(tc.KeypairDir) {
+		return nil, fmt.Errorf("tenant %q: keypair not found in dir %q", tc.Name, tc.KeypairDir)
+	}
+	tokenSigner, err := token.NewSigner(tc.KeypairDir, token.SignerOptions{Algorithm: tc.SigningAlgorithm})
+	if err != nil {
+		return nil, fmt.Errorf("tenant %q: creating token issuer: %v", tc.Name, err)
+	}
+	tokenVerifier, err := token.NewVerifierForAlgorithm(tc.KeypairDir, tc.SigningAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("tenant %q: creating token verifier: %v", tc.Name, err)
+	}
+
+	ldapClient := &ldap.Client{
+		BaseDN:             tc.LDAPBaseDN,
+		LdapServer:         tc.LDAPHost,
+		LdapPort:           tc.LDAPPort,
+		UseInsecure:        tc.UseInsecure,
+		UserLoginAttribute: tc.LDAPUserAttribute,
+		SearchUserDN:       tc.LDAPSearchUserDN,
+		SearchUserPassword: tc.LDAPSearchUserPassword,
+		RequireTLS:         ldapRequireTLS,
+		WhoAmI:             ldapWhoAmI,
+		SearchAsUser:       ldapSearchAsUser,
+		ControlCharPolicy:  credentialControlChars,
+		TLSConfig:          newLDAPTLSConfig(tc.LDAPHost, tc.LDAPSkipTLSVerification),
+	}
+
+	webhook := auth.NewTokenWebhook(tokenVerifier)
+	webhook.TokenPrefix = tc.TokenPrefix
+	webhook.Audiences = webhookAudiences
+
+	mux := http.NewServeMux()
+	mux.Handle("/authenticate", webhook)
+	issuer := newTokenIssuer(ldapClient, tokenSigner)
+	issuer.LDAPServer = tc.LDAPHost
+	issuer.TTL = tc.TokenTTL
+	issuer.UsernameAttribute = tc.UsernameAttribute
+	issuer.TokenPrefix = tc.TokenPrefix
+	// Tenants serve no /refresh endpoint to redeem refresh tokens at.
+	issuer.RefreshTTL = 0
+	mux.Handle("/ldapAuth", issuer)
+	return mux, nil
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/proofpoint/kubernetes-ldap/auth"
+	"github.com/proofpoint/kubernetes-ldap/token"
+)
+
+func TestTenantSigningAlgorithms(t *testing.T) {
+	type tenant struct {
+		handler http.Handler
+		signer  token.Signer
+	}
+	tenants := make(map[string]tenant)
+	for _, alg := range []string{token.AlgorithmES256, token.AlgorithmEdDSA} {
+		dir, err := ioutil.TempDir("", "tenant")
+		if err != nil {
+			t.Fatalf("creating temp dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		if err := token.GenerateKeypairForAlgorithm(dir, alg); err != nil {
+			t.Fatalf("generating %s keypair: %v", alg, err)
+		}
+		handler, err := newTenantHandler(tenantConfig{
+			Name:             alg,
+			LDAPHost:         "ldap.example.com",
+			LDAPBaseDN:       "dc=example,dc=com",
+			KeypairDir:       dir,
+			SigningAlgorithm: alg,
+		})
+		if err != nil {
+			t.Fatalf("creating %s tenant: %v", alg, err)
+		}
+		signer, err := token.NewSigner(dir, token.SignerOptions{Algorithm: alg})
+		if err != nil {
+			t.Fatalf("creating %s signer: %v", alg, err)
+		}
+		tenants[alg] = tenant{handler: handler, signer: signer}
+	}
+
+	tok := &token.AuthToken{
+		Username:   "alice",
+		Expiration: time.Now().Add(time.Hour).UnixNano() / int64(time.Millisecond),
+	}
+	for signedBy, issuer := range tenants {
+		signed, err := issuer.signer.Sign(tok)
+		if err != nil {
+			t.Fatalf("signing %s token: %v", signedBy, err)
+		}
+		trrJSON, _ := json.Marshal(&auth.TokenReviewRequest{Spec: auth.TokenReviewSpec{Token: signed}})
+		for verifiedBy, verifier := range tenants {
+			rec := httptest.NewRecorder()
+			verifier.handler.ServeHTTP(rec, httptest.NewRequest("POST", "/authenticate", bytes.NewReader(trrJSON)))
+			expected := http.StatusUnauthorized
+			if signedBy == verifiedBy {
+				expected = http.StatusOK
+			}
+			if rec.Code != expected {
+				t.Errorf("%s token, %s tenant: Expected %d, got %d: %s", signedBy, verifiedBy, expected, rec.Code, rec.Body.String())
+			}
+		}
+	}
+}
+
+func TestTenantKeypairForOtherAlgorithm(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenant")
+	if err != nil {
+		t.Fatalf("creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := token.GenerateKeypair(dir); err != nil {
+		t.Fatalf("generating keypair: %v", err)
+	}
+	_, err = newTenantHandler(tenantConfig{
+		Name:             "team-a",
+		LDAPHost:         "ldap.example.com",
+		LDAPBaseDN:       "dc=example,dc=com",
+		KeypairDir:       dir,
+		SigningAlgorithm: token.AlgorithmEdDSA,
+	})
+	if err == nil {
+		t.Errorf("Expected an ES256 keypair to be rejected for an EdDSA tenant")
+	}
+}
+
+func TestTenantIssuerSharesGroupLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenant")
+	if err != nil {
+		t.Fatalf("creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := token.GenerateKeypair(dir); err != nil {
+		t.Fatalf("generating keypair: %v", err)
+	}
+	defer func(max int) { maxGroups = max }(maxGroups)
+	maxGroups = 5
+
+	handler, err := newTenantHandler(tenantConfig{
+		Name:       "team-a",
+		LDAPHost:   "ldap.example.com",
+		LDAPBaseDN: "dc=example,dc=com",
+		KeypairDir: dir,
+		TokenTTL:   time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("creating tenant: %v", err)
+	}
+	h, _ := handler.(*http.ServeMux).Handler(httptest.NewRequest("POST", "/ldapAuth", nil))
+	issuer, ok := h.(*auth.LDAPTokenIssuer)
+	if !ok {
+		t.Fatalf("Expected an LDAPTokenIssuer, got %T", h)
+	}
+	if issuer.MaxGroups != 5 {
+		t.Errorf("Expected the tenant to limit groups to 5, got %d", issuer.MaxGroups)
+	}
+	if issuer.LDAPServer != "ldap.example.com" || issuer.TTL != time.Hour {
+		t.Errorf("Expected the tenant's own directory and TTL, got %q and %v", issuer.LDAPServer, issuer.TTL)
+	}
+}
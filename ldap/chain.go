@@ -0,0 +1,93 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Chain is an Authenticator trying each of its authenticators in order,
+// e.g. the directory then local break-glass users, until one accepts
+// the user.
+type Chain []Authenticator
+
+// Authenticate returns the entry from the first authenticator accepting
+// the user. If none does, the error is a *ChainError with every
+// authenticator's error.
+func (c Chain) Authenticate(username, password string) (*ldap.Entry, error) {
+	var errs []error
+	for _, authenticator := range c {
+		entry, err := authenticator.Authenticate(username, password)
+		if err == nil {
+			return entry, nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no authenticator is configured")
+	}
+	return nil, &ChainError{Errs: errs}
+}
+
+// Fallback is an Authenticator trying Primary, and Secondary only when
+// Primary is unavailable, e.g. local break-glass users consulted only
+// while the directory is down. That way directory logins don't also pay
+// for a bcrypt comparison, and the break-glass passwords can't be
+// guessed while the directory is up.
+type Fallback struct {
+	Primary, Secondary Authenticator
+}
+
+// Authenticate returns the entry from Primary, or from Secondary if
+// Primary is unavailable. If Secondary rejects the user too, the error
+// is a *ChainError with both errors, so that the UnavailableError is
+// still found.
+func (f Fallback) Authenticate(username, password string) (*ldap.Entry, error) {
+	entry, err := f.Primary.Authenticate(username, password)
+	var unavailable *UnavailableError
+	if !errors.As(err, &unavailable) {
+		return entry, err
+	}
+	entry, fallbackErr := f.Secondary.Authenticate(username, password)
+	if fallbackErr != nil {
+		return nil, &ChainError{Errs: []error{err, fallbackErr}}
+	}
+	return entry, nil
+}
+
+// ChainError is returned by Chain when every authenticator rejected the
+// user. errors.Is and errors.As look at each of the errors in turn, so
+// e.g. an UnavailableError from the directory is still found.
+type ChainError struct {
+	Errs []error
+}
+
+func (e *ChainError) Error() string {
+	messages := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("all %d authenticators failed: %s", len(e.Errs), strings.Join(messages, "; "))
+}
+
+// Is reports whether one of the errors is target.
+func (e *ChainError) Is(target error) bool {
+	for _, err := range e.Errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors that matches target.
+func (e *ChainError) As(target interface{}) bool {
+	for _, err := range e.Errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package ldap
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+type fakeAuthenticator struct {
+	entry *ldap.Entry
+	err   error
+	calls int
+}
+
+func (f *fakeAuthenticator) Authenticate(username, password string) (*ldap.Entry, error) {
+	f.calls++
+	return f.entry, f.err
+}
+
+func TestChain(t *testing.T) {
+	alice := ldap.NewEntry("uid=alice,dc=example,dc=com", nil)
+	failing := &fakeAuthenticator{err: errors.New("alice is not a static user")}
+	succeeding := &fakeAuthenticator{entry: alice}
+	unused := &fakeAuthenticator{err: errors.New("unused")}
+
+	entry, err := Chain{failing, succeeding, unused}.Authenticate("alice", "password")
+	if err != nil {
+		t.Fatalf("Expected the second authenticator to accept alice: %v", err)
+	}
+	if entry != alice {
+		t.Errorf("Expected the entry of the second authenticator, got %v", entry)
+	}
+	if failing.calls != 1 || succeeding.calls != 1 || unused.calls != 0 {
+		t.Errorf("Expected the chain to stop at the first success, got %d, %d and %d calls", failing.calls, succeeding.calls, unused.calls)
+	}
+}
+
+func TestChainAllFail(t *testing.T) {
+	unavailable := &UnavailableError{Err: errors.New("connection refused")}
+	chain := Chain{
+		&fakeAuthenticator{err: errors.New("alice is not a static user")},
+		&fakeAuthenticator{err: unavailable},
+	}
+	_, err := chain.Authenticate("alice", "password")
+	var chainErr *ChainError
+	if !errors.As(err, &chainErr) || len(chainErr.Errs) != 2 {
+		t.Fatalf("Expected both errors, got %v", err)
+	}
+	for _, message := range []string{"not a static user", "connection refused"} {
+		if !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected %q in %q", message, err.Error())
+		}
+	}
+	// The directory being down still answers 503.
+	var unavailableErr *UnavailableError
+	if !errors.As(err, &unavailableErr) || unavailableErr != unavailable {
+		t.Errorf("Expected to find the UnavailableError in %v", err)
+	}
+
+	if _, err := (Chain{}).Authenticate("alice", "password"); err == nil {
+		t.Errorf("Expected an empty chain to reject every user")
+	}
+}
+
+func TestFallback(t *testing.T) {
+	alice := ldap.NewEntry("uid=alice,dc=example,dc=com", nil)
+	breakglass := ldap.NewEntry("uid=breakglass", nil)
+	unavailable := &UnavailableError{Err: errors.New("connection refused")}
+
+	// While the directory answers, the fallback isn't consulted, even
+	// for users the directory rejects.
+	for _, primary := range []*fakeAuthenticator{{entry: alice}, {err: errors.New("invalid credentials")}} {
+		secondary := &fakeAuthenticator{entry: breakglass}
+		entry, err := Fallback{Primary: primary, Secondary: secondary}.Authenticate("breakglass", "password")
+		if entry != primary.entry || err != primary.err {
+			t.Errorf("Expected the directory's answer, got %v, %v", entry, err)
+		}
+		if secondary.calls != 0 {
+			t.Errorf("Expected the fallback not to be tried while the directory is up")
+		}
+	}
+
+	entry, err := Fallback{Primary: &fakeAuthenticator{err: unavailable}, Secondary: &fakeAuthenticator{entry: breakglass}}.Authenticate("breakglass", "password")
+	if err != nil || entry != breakglass {
+		t.Errorf("Expected the fallback's entry while the directory is down, got %v, %v", entry, err)
+	}
+
+	_, err = Fallback{Primary: &fakeAuthenticator{err: unavailable}, Secondary: &fakeAuthenticator{err: errors.New("alice is not a static user")}}.Authenticate("alice", "password")
+	var unavailableErr *UnavailableError
+	if !errors.As(err, &unavailableErr) || !strings.Contains(err.Error(), "not a static user") {
+		t.Errorf("Expected both errors, with the UnavailableError, got %v", err)
+	}
+}
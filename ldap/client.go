@@ -4,8 +4,11 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
-	"github.com/go-ldap/ldap"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -25,8 +28,186 @@ type Client struct {
 	SearchUserDN       string
 	SearchUserPassword string
 	TLSConfig          *tls.Config
+	// SecretProvider, if set, supplies the search user credentials
+	// instead of SearchUserDN and SearchUserPassword.
+	SecretProvider SecretProvider
+	// Kerberos, if set, binds the search user with SASL GSSAPI instead
+	// of a simple bind, and takes the place of SearchUserDN,
+	// SearchUserPassword and SecretProvider.
+	Kerberos *Kerberos
+	// UserBaseDNs, if set, are searched for the user in order instead of
+	// BaseDN, for directories keeping users under several branches. The
+	// first base with a match wins; bases that don't exist are skipped.
+	UserBaseDNs []string
+	// MachineBaseDNs are searched for the account after the user bases
+	// (UserBaseDNs, or BaseDN) come up empty, for directories keeping
+	// computer accounts apart from users.
+	MachineBaseDNs []string
+	// UserSearchScope is the scope of the user search: "base", "one" or
+	// "sub". Defaults to "sub".
+	UserSearchScope string
+	// MultipleMatchPolicy is what happens when the user search matches
+	// more than one entry: MultipleMatchReject (the default) or
+	// MultipleMatchTiebreak, which picks by TiebreakAttribute.
+	MultipleMatchPolicy string
+	TiebreakAttribute   string
+	// SearchLimitPolicy is what happens when the user search exceeds the
+	// server's size or time limit: SearchLimitFail (the default) or
+	// SearchLimitNarrow, which retries with the filter narrowed by
+	// SearchLimitFilter, "(objectClass=person)" by default.
+	SearchLimitPolicy string
+	SearchLimitFilter string
+
+	// MaxIdleConns is how many connections are kept open for reuse
+	// between logins. Zero disables pooling.
+	MaxIdleConns int
+	// IdleTimeout is how long a connection may sit idle in the pool
+	// before it is closed instead of reused. It should be shorter than
+	// the server's own idle timeout. Zero means no limit.
+	IdleTimeout time.Duration
+	// TCPKeepAlive is the keepalive period of LDAP connections. Zero uses
+	// the system default and a negative value disables keepalives.
+	TCPKeepAlive time.Duration
+	// DisableTCPNoDelay turns Nagle's algorithm back on for LDAP
+	// connections. By default TCP_NODELAY is set, so that small bind and
+	// search requests are sent without waiting to be coalesced.
+	DisableTCPNoDelay bool
+	// ReadBufferSize and WriteBufferSize, if set, are the socket receive
+	// and send buffer sizes of LDAP connections in bytes. Zero keeps the
+	// system default.
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// PasswordChangeMethod is how ChangePassword sets the new password,
+	// PasswordChangeExtendedOp (the default) or PasswordChangeModify.
+	PasswordChangeMethod string
+	// WritableServer, if set, is where ChangePassword retries a change
+	// that LdapServer refused because it is read-only, e.g. a replica or
+	// a primary under maintenance. Logins keep using LdapServer.
+	// WritablePort defaults to LdapPort.
+	WritableServer string
+	WritablePort   uint
+
+	// PasswordPolicy requests the password policy control (OpenLDAP's
+	// ppolicy overlay) on user binds, so that accounts whose password
+	// must be changed are reported as PasswordMustChangeError, and its
+	// warnings are set on the entry as PasswordExpiresInAttribute and
+	// GraceLoginsAttribute.
+	PasswordPolicy bool
+
+	// RequireTLS refuses to bind over a connection that isn't TLS, so
+	// that no misconfiguration can send credentials in the clear.
+	RequireTLS bool
+
+	// ControlCharPolicy is what happens to usernames and passwords
+	// containing control characters, which some servers truncate or
+	// otherwise interpret surprisingly: ControlCharsReject (the default),
+	// ControlCharsRejectNull or ControlCharsAllow.
+	ControlCharPolicy string
+
+	// WhoAmI follows every successful bind with the RFC 4532 "Who Am
+	// I?" extended operation, logging the identity the directory
+	// established and warning when it isn't the DN bound as.
+	WhoAmI bool
+
+	// SearchAsUser reads the user's entry again once the user has bound,
+	// on their own connection, for directories that only show some
+	// attributes, e.g. memberOf, to the user themselves. The search user
+	// still finds the entry. It has no effect when users bind directly,
+	// since they then search as themselves.
+	SearchAsUser bool
+
+	// GroupMembership is how the user's groups are found:
+	// MembershipMemberOf (the default) or MembershipMemberUID.
+	GroupMembership string
+	// GroupBaseDN is where MembershipMemberUID searches for groups.
+	// Defaults to BaseDN.
+	GroupBaseDN string
+	// GroupSearchScope is the scope of the MembershipMemberUID group
+	// search: "base", "one" or "sub". Defaults to "sub".
+	GroupSearchScope string
+	// GroupUIDAttribute is the user attribute whose value groups list in
+	// memberUid. Defaults to uid.
+	GroupUIDAttribute string
+	// GroupPageSize, if set, has MembershipMemberUID search for groups
+	// in pages of this size with the paged results control.
+	GroupPageSize uint32
+	// GroupPageRestarts bounds how many times a paged group search is
+	// restarted when the server rejects its paging cookie, before
+	// failing with ErrPagingCookieInvalid.
+	GroupPageRestarts int
+	// GroupFailurePolicy is what happens when the group search fails
+	// after the user's bind succeeded: GroupFailureClosed (the default)
+	// or GroupFailureOpen.
+	GroupFailurePolicy string
+
+	// NegativeCacheTTL, if set, is how long a failed login is remembered,
+	// so that retrying the same username and password fails without
+	// querying the directory. Other passwords are still checked.
+	NegativeCacheTTL time.Duration
+	// NegativeCacheSize bounds the number of remembered failures.
+	// Defaults to 1000.
+	NegativeCacheSize int
+
+	// MaxConcurrentBinds, if set, limits the number of logins in
+	// progress against the directory at once. Logins over the limit wait
+	// for up to BindQueueTimeout (forever if zero) in a queue of up to
+	// BindQueueSize, and fail with ErrTooManyBinds beyond that.
+	MaxConcurrentBinds int
+	BindQueueSize      int
+	BindQueueTimeout   time.Duration
+
+	pool     connPool
+	negative negativeCache
+	limiter  bindLimiter
+	servers  connServers
 }
 
+// ParseSearchScope maps a scope name to its LDAP constant. An empty
+// name is the whole subtree.
+func ParseSearchScope(scope string) (int, error) {
+	switch strings.ToLower(scope) {
+	case "", "sub", "subtree":
+		return ldap.ScopeWholeSubtree, nil
+	case "one", "onelevel", "single":
+		return ldap.ScopeSingleLevel, nil
+	case "base", "baseobject":
+		return ldap.ScopeBaseObject, nil
+	}
+	return 0, fmt.Errorf("unknown search scope %q, expected one of base, one or sub", scope)
+}
+
+// UnavailableError is returned by Authenticate when the LDAP server can't
+// be reached, as opposed to the user failing to authenticate.
+type UnavailableError struct {
+	Err error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("Error opening LDAP connection: %v", e.Err)
+}
+
+// Unwrap returns the underlying connection error.
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// PasswordMustChangeError is returned by Authenticate when the user's
+// credentials are correct but the directory requires them to change
+// their password before they may log in.
+type PasswordMustChangeError struct {
+	Username string
+	Reason   string
+}
+
+func (e *PasswordMustChangeError) Error() string {
+	return fmt.Sprintf("Error authenticating user %s: password must be changed: %s", e.Username, e.Reason)
+}
+
+// ErrPlaintextBind is returned when RequireTLS is set and a bind would
+// have been sent over an unencrypted connection.
+var ErrPlaintextBind = errors.New("refusing to bind over an unencrypted LDAP connection")
+
 var (
 	ldapConnectionError = prometheus.NewCounter(
 		prometheus.CounterOpts{
@@ -64,6 +245,12 @@ var (
 			Help: "Total number of times invalid user credentials were used.",
 		},
 	)
+	negativeCacheHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_negative_cache_hits",
+			Help: "Total number of logins failed from the negative cache, without querying LDAP.",
+		},
+	)
 )
 
 //RegisterLDAPClientMetrics registers the metrics for the token generation
@@ -73,92 +260,333 @@ func RegisterLDAPClientMetrics() {
 	prometheus.MustRegister(userSearchFailed)
 	prometheus.MustRegister(noUserFound)
 	prometheus.MustRegister(multipleUsersFound)
+	prometheus.MustRegister(searchLimitExceeded)
 	prometheus.MustRegister(invalidUserCredentials)
+	prometheus.MustRegister(negativeCacheHits)
+	prometheus.MustRegister(groupSearchFailed)
+	prometheus.MustRegister(groupSearchRestarts)
+	prometheus.MustRegister(readOnlyRetries)
+	prometheus.MustRegister(bindLimitRejected)
+	prometheus.MustRegister(staticUserLogins)
+	prometheus.MustRegister(invalidStaticUserCredentials)
 }
 
 // Authenticate a user against the LDAP directory. Returns an LDAP entry if password
 // is valid, otherwise returns an error.
 func (c *Client) Authenticate(username, password string) (*ldap.Entry, error) {
-	conn, err := c.dial()
+	// Many servers treat a simple bind with an empty password as an
+	// anonymous bind, which succeeds for any DN. Never let that through.
+	if password == "" {
+		invalidUserCredentials.Inc()
+		return nil, fmt.Errorf("Error authenticating user %s: empty password", username)
+	}
+	if err := c.checkCredentials(username, password); err != nil {
+		invalidUserCredentials.Inc()
+		return nil, fmt.Errorf("Error authenticating user %q: %w", username, err)
+	}
+
+	if c.NegativeCacheTTL > 0 && c.negative.contains(username, password) {
+		negativeCacheHits.Inc()
+		return nil, fmt.Errorf("Error authenticating user %s: invalid credentials (cached)", username)
+	}
+
+	if c.MaxConcurrentBinds > 0 {
+		if err := c.limiter.acquire(c.MaxConcurrentBinds, c.BindQueueSize, c.BindQueueTimeout); err != nil {
+			bindLimitRejected.Inc()
+			return nil, &UnavailableError{Err: err}
+		}
+		defer c.limiter.release()
+	}
+
+	conn, err := c.getConn()
 	if err != nil {
 		ldapConnectionError.Inc()
-		return nil, fmt.Errorf("Error opening LDAP connection: %v", err)
+		return nil, &UnavailableError{Err: err}
 	}
-	defer conn.Close()
+	defer func() { c.releaseConn(conn, err) }()
+
+	var entry *ldap.Entry
+	entry, err = c.authenticate(conn, username, password)
+	if err != nil {
+		var credsErr *credentialsError
+		if c.NegativeCacheTTL > 0 && errors.As(err, &credsErr) {
+			size := c.NegativeCacheSize
+			if size <= 0 {
+				size = defaultNegativeCacheSize
+			}
+			c.negative.add(username, password, c.NegativeCacheTTL, size)
+		}
+		return nil, err
+	}
+	if addr := c.servers.get(conn); addr != "" {
+		entry.Attributes = append(entry.Attributes, &ldap.EntryAttribute{Name: ServerAttribute, Values: []string{addr}})
+	}
+	return entry, nil
+}
+
+// authenticate finds and verifies the user on conn, which is left bound
+// as the user on success. If the user's password must be changed, the
+// entry (when known) is returned along with a PasswordMustChangeError.
+func (c *Client) authenticate(conn *ldap.Conn, username, password string) (*ldap.Entry, error) {
+	var searchDN, searchPassword string
+	var err error
+	if c.Kerberos == nil {
+		searchDN, searchPassword, err = c.searchCredentials()
+		if err != nil {
+			ldapBindingError.Inc()
+			return nil, fmt.Errorf("Error getting search user credentials: %v", err)
+		}
+	}
+	searchThenBind := c.Kerberos != nil || (searchDN != "" && searchPassword != "")
 
 	// Bind user to perform the search
-	if c.SearchUserDN != "" && c.SearchUserPassword != "" {
-		err = conn.Bind(c.SearchUserDN, c.SearchUserPassword)
+	var policy *ldap.ControlBeheraPasswordPolicy
+	if searchThenBind {
+		err = c.bindSearchUser(conn, searchDN, searchPassword)
 	} else {
-		err = conn.Bind(username, password)
+		policy, err = c.bindUser(conn, username, username, password)
 	}
 
+	var mustChange *PasswordMustChangeError
+	if errors.As(err, &mustChange) {
+		invalidUserCredentials.Inc()
+		return nil, err
+	}
 	if err != nil {
 		ldapBindingError.Inc()
-		return nil, fmt.Errorf("Error binding user to LDAP server: %v", err)
+		bindErr := fmt.Errorf("Error binding user to LDAP server: %w", err)
+		if !searchThenBind && ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, &credentialsError{bindErr}
+		}
+		return nil, bindErr
 	}
 
-	req := c.newUserSearchRequest(username)
+	req, err := c.newUserSearchRequest(username)
+	if err != nil {
+		return nil, err
+	}
 
 	// Do a search to ensure the user exists within the BaseDN scope
-	res, err := conn.Search(req)
+	entries, err := c.searchUser(conn, req)
 	if err != nil {
 		userSearchFailed.Inc()
-		return nil, fmt.Errorf("Error searching for user %s: %v", username, err)
+		return nil, fmt.Errorf("Error searching for user %s: %w", username, err)
 	}
 
-	switch {
-	case len(res.Entries) == 0:
+	if len(entries) == 0 {
 		noUserFound.Inc()
-		return nil, fmt.Errorf("No result for the search filter '%s'", req.Filter)
-	case len(res.Entries) > 1:
-		multipleUsersFound.Inc()
-		return nil, fmt.Errorf("Multiple entries found for the search filter '%s': %+v", req.Filter, res.Entries)
+		if bases := c.userBaseDNs(); len(bases) > 1 {
+			return nil, &credentialsError{fmt.Errorf("No result for the search filter '%s' in any of the user base DNs %s", req.Filter, strings.Join(bases, "; "))}
+		}
+		return nil, &credentialsError{fmt.Errorf("No result for the search filter '%s'", req.Filter)}
+	}
+	entry, err := c.pickEntry(username, entries)
+	if err != nil {
+		return nil, err
 	}
 
 	// Now that we know the user exists within the BaseDN scope
 	// let's do user bind to check credentials using the full DN instead of
 	// the attribute used for search
-	if c.SearchUserDN != "" && c.SearchUserPassword != "" {
-		err = conn.Bind(res.Entries[0].DN, password)
+	if searchThenBind {
+		policy, err = c.bindUser(conn, username, entry.DN, password)
+		if errors.As(err, &mustChange) {
+			invalidUserCredentials.Inc()
+			return entry, err
+		}
 		if err != nil {
 			invalidUserCredentials.Inc()
-			return nil, fmt.Errorf("Error binding user %s, invalid credentials: %v", username, err)
+			bindErr := fmt.Errorf("Error binding user %s, invalid credentials: %w", username, err)
+			if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+				return nil, &credentialsError{bindErr}
+			}
+			return nil, bindErr
+		}
+		if c.SearchAsUser {
+			entry, err = c.readEntryAsUser(conn, username, entry)
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
 	// Single user entry found
-	return res.Entries[0], nil
+	if err := c.resolveGroups(conn, entry); err != nil {
+		return nil, err
+	}
+	addPasswordWarnings(entry, policy)
+	return entry, nil
+}
+
+// searchCredentials returns the credentials of the search user, which
+// are empty when users bind directly.
+func (c *Client) searchCredentials() (string, string, error) {
+	if c.SecretProvider != nil {
+		return c.SecretProvider.GetBindCredentials()
+	}
+	return c.SearchUserDN, c.SearchUserPassword, nil
+}
+
+// bindSearchUser binds as the search user. If the directory rejects the
+// credentials and the provider caches them, they are refreshed and the
+// bind retried once, in case the password was rotated. With Kerberos,
+// the search user binds with GSSAPI instead.
+func (c *Client) bindSearchUser(conn *ldap.Conn, dn, password string) error {
+	if err := c.checkEncrypted(conn); err != nil {
+		return err
+	}
+	if c.Kerberos != nil {
+		err := c.Kerberos.bind(conn, c.LdapServer)
+		if err == nil {
+			c.checkIdentity(conn, "")
+		}
+		return err
+	}
+	err := conn.Bind(dn, password)
+	logBindFailure(dn, err)
+	if err == nil {
+		c.checkIdentity(conn, dn)
+	}
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return err
+	}
+
+	refresher, ok := c.SecretProvider.(RefreshableSecretProvider)
+	if !ok {
+		return err
+	}
+	if err := refresher.Refresh(); err != nil {
+		return fmt.Errorf("refreshing search user credentials: %v", err)
+	}
+	dn, password, err = refresher.GetBindCredentials()
+	if err != nil {
+		return fmt.Errorf("refreshing search user credentials: %v", err)
+	}
+	err = conn.Bind(dn, password)
+	logBindFailure(dn, err)
+	if err == nil {
+		c.checkIdentity(conn, dn)
+	}
+	return err
+}
+
+// checkEncrypted returns ErrPlaintextBind if TLS is required and conn
+// isn't using it.
+func (c *Client) checkEncrypted(conn *ldap.Conn) error {
+	if !c.RequireTLS {
+		return nil
+	}
+	if _, ok := conn.TLSConnectionState(); !ok {
+		return ErrPlaintextBind
+	}
+	return nil
 }
 
-// Create a new TCP connection to the LDAP server
-func (c *Client) dial() (*ldap.Conn, error) {
-	address := fmt.Sprintf("%s:%d", c.LdapServer, c.LdapPort)
+// bindUser binds as the user with the given DN, and returns the password
+// policy control the bind was answered with, if any. When the directory
+// signals that the password must be changed, either through the password
+// policy control or AD's data 773 diagnostic, a PasswordMustChangeError
+// is returned.
+func (c *Client) bindUser(conn *ldap.Conn, username, dn, password string) (*ldap.ControlBeheraPasswordPolicy, error) {
+	if err := c.checkEncrypted(conn); err != nil {
+		return nil, err
+	}
+	req := ldap.NewSimpleBindRequest(dn, password, nil)
+	if c.PasswordPolicy {
+		req.Controls = append(req.Controls, ldap.NewControlBeheraPasswordPolicy())
+	}
+	res, err := conn.SimpleBind(req)
+	logBindFailure(dn, err)
+	if err == nil {
+		c.checkIdentity(conn, dn)
+	}
 
-	if c.TLSConfig != nil && !c.UseInsecure {
-		return ldap.DialTLS("tcp", address, c.TLSConfig)
+	var policy *ldap.ControlBeheraPasswordPolicy
+	if res != nil {
+		if control, ok := ldap.FindControl(res.Controls, ldap.ControlTypeBeheraPasswordPolicy).(*ldap.ControlBeheraPasswordPolicy); ok {
+			switch control.Error {
+			case ldap.BeheraChangeAfterReset, ldap.BeheraPasswordExpired:
+				return nil, &PasswordMustChangeError{Username: username, Reason: control.ErrorString}
+			}
+			policy = control
+		}
 	}
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		// AD reports the reason for a failed bind as a "data" code in
+		// the diagnostic message; 773 is "user must reset password".
+		if d, ok := bindDiagnostic(err); ok && d.Code == "773" {
+			return nil, &PasswordMustChangeError{Username: username, Reason: d.Label}
+		}
+	}
+	return policy, err
+}
 
-	// This will send passwords in clear text (LDAP doesn't obfuscate password in any way),
-	// thus we use a flag to enable this mode
-	if c.UseInsecure {
-		return ldap.Dial("tcp", address)
+// searchUser runs req under each of the user base DNs in turn, and
+// returns the entries found under the first with any.
+func (c *Client) searchUser(conn *ldap.Conn, req *ldap.SearchRequest) ([]*ldap.Entry, error) {
+	bases := c.userBaseDNs()
+	if len(bases) == 0 {
+		return c.searchUserWithLimits(conn, req)
 	}
 
-	// TLSConfig was not specified, and insecure flag not set
-	return nil, errors.New("The LDAP TLS Configuration was not set.")
+	for _, baseDN := range bases {
+		req.BaseDN = baseDN
+		entries, err := c.searchUserWithLimits(conn, req)
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			glog.Warningf("User base DN %s doesn't exist, skipping it: %v", baseDN, err)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("under %s: %w", baseDN, err)
+		}
+		if len(entries) > 0 {
+			return entries, nil
+		}
+	}
+	return nil, nil
+}
+
+// userBaseDNs returns the bases searched in order for the user, or none
+// if only BaseDN is.
+func (c *Client) userBaseDNs() []string {
+	if len(c.MachineBaseDNs) == 0 {
+		return c.UserBaseDNs
+	}
+	bases := append([]string{}, c.UserBaseDNs...)
+	if len(bases) == 0 {
+		bases = append(bases, c.BaseDN)
+	}
+	return append(bases, c.MachineBaseDNs...)
 }
 
-func (c *Client) newUserSearchRequest(username string) *ldap.SearchRequest {
-	// TODO(abrand): sanitize
-	userFilter := fmt.Sprintf("(%s=%s)", c.UserLoginAttribute, username)
+func (c *Client) newUserSearchRequest(username string) (*ldap.SearchRequest, error) {
+	scope, err := ParseSearchScope(c.UserSearchScope)
+	if err != nil {
+		return nil, err
+	}
+
+	userFilter := userSearchFilter(c.UserLoginAttribute, username)
+	// Two entries are enough to reject an ambiguous user, but picking
+	// one needs to see them all.
+	sizeLimit := 2
+	if c.MultipleMatchPolicy == MultipleMatchTiebreak {
+		sizeLimit = tiebreakSizeLimit
+	}
 	return &ldap.SearchRequest{
 		BaseDN:       c.BaseDN,
-		Scope:        ldap.ScopeWholeSubtree,
+		Scope:        scope,
 		DerefAliases: ldap.NeverDerefAliases, // ????
-		SizeLimit:    2,
+		SizeLimit:    sizeLimit,
 		TimeLimit:    10, // make configurable?
 		TypesOnly:    false,
 		Filter:       userFilter,
-	}
+	}, nil
+}
+
+// userSearchFilter returns the filter matching entries whose attribute
+// equals username. The username is escaped (RFC 4515) so that however it
+// is spelled it can't change the filter, e.g. "*" matching every user or
+// "x)(uid=*" adding a condition.
+func userSearchFilter(attribute, username string) string {
+	return fmt.Sprintf("(%s=%s)", attribute, ldap.EscapeFilter(username))
 }
@@ -0,0 +1,491 @@
+package ldap
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+func newTestDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		passwords: map[string]string{
+			"cn=search,dc=example,dc=com": "search-password",
+			"uid=alice,dc=example,dc=com": "alice-password",
+			// Like AD accepting a UPN, alice can also bind by her login.
+			"alice": "alice-password",
+		},
+		entries: []*ldap.Entry{
+			ldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{
+				"uid": {"alice"},
+			}),
+		},
+	}
+}
+
+func TestAuthenticateRejectsEmptyPassword(t *testing.T) {
+	cases := []struct {
+		name           string
+		username       string
+		searchUserDN   string
+		searchPassword string
+	}{
+		{
+			name:     "direct bind as the user",
+			username: "alice",
+		},
+		{
+			name:           "search then bind",
+			username:       "alice",
+			searchUserDN:   "cn=search,dc=example,dc=com",
+			searchPassword: "search-password",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fs := newFakeServer(t)
+			defer fs.Close()
+			newTestDirectory().attach(fs)
+
+			client := fs.client()
+			client.SearchUserDN = c.searchUserDN
+			client.SearchUserPassword = c.searchPassword
+
+			entry, err := client.Authenticate(c.username, "")
+			if err == nil {
+				t.Fatalf("expected blank password to be rejected, got entry %v", entry.DN)
+			}
+			if n := fs.connCount(); n != 0 {
+				t.Errorf("expected no connection to the directory, got %d", n)
+			}
+
+			// The same user with the right password still gets in.
+			entry, err = client.Authenticate(c.username, "alice-password")
+			if err != nil {
+				t.Fatalf("expected valid credentials to authenticate: %v", err)
+			}
+			if entry.DN != "uid=alice,dc=example,dc=com" {
+				t.Errorf("unexpected entry %q", entry.DN)
+			}
+		})
+	}
+}
+
+func TestUserSearchScope(t *testing.T) {
+	cases := []struct {
+		scope         string
+		expectedScope int
+		expectErr     bool
+	}{
+		{scope: "", expectedScope: ldap.ScopeWholeSubtree},
+		{scope: "sub", expectedScope: ldap.ScopeWholeSubtree},
+		{scope: "one", expectedScope: ldap.ScopeSingleLevel},
+		{scope: "base", expectedScope: ldap.ScopeBaseObject},
+		{scope: "bogus", expectErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.scope, func(t *testing.T) {
+			fs := newFakeServer(t)
+			defer fs.Close()
+			newTestDirectory().attach(fs)
+
+			client := fs.client()
+			client.UserSearchScope = c.scope
+
+			_, err := client.Authenticate("alice", "alice-password")
+			if c.expectErr {
+				if err == nil {
+					t.Fatalf("expected an error for scope %q", c.scope)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			searches := fs.searchRequests()
+			if len(searches) != 1 {
+				t.Fatalf("expected one search, got %d", len(searches))
+			}
+			if searches[0].Scope != c.expectedScope {
+				t.Errorf("expected scope %d, got %d", c.expectedScope, searches[0].Scope)
+			}
+		})
+	}
+}
+
+func TestAuthenticatePasswordMustChange(t *testing.T) {
+	const aliceDN = "uid=alice,dc=example,dc=com"
+	cases := []struct {
+		name             string
+		searchThenBind   bool
+		passwordPolicy   bool
+		bindResult       fakeResult
+		expectMustChange bool
+	}{
+		{
+			name: "AD data 773",
+			bindResult: fakeResult{
+				code: ldap.LDAPResultInvalidCredentials,
+				diag: "80090308: LdapErr: DSID-0C09042A, comment: AcceptSecurityContext error, data 773, v3839",
+			},
+			expectMustChange: true,
+		},
+		{
+			name: "AD wrong password",
+			bindResult: fakeResult{
+				code: ldap.LDAPResultInvalidCredentials,
+				diag: "80090308: LdapErr: DSID-0C09042A, comment: AcceptSecurityContext error, data 52e, v3839",
+			},
+		},
+		{
+			name:           "ppolicy change after reset",
+			searchThenBind: true,
+			passwordPolicy: true,
+			bindResult: fakeResult{
+				code:     ldap.LDAPResultSuccess,
+				controls: []*ber.Packet{fakePasswordPolicyControl(ldap.BeheraChangeAfterReset, -1, 0)},
+			},
+			expectMustChange: true,
+		},
+		{
+			name:           "ppolicy password expired",
+			searchThenBind: true,
+			passwordPolicy: true,
+			bindResult: fakeResult{
+				code:     ldap.LDAPResultInvalidCredentials,
+				controls: []*ber.Packet{fakePasswordPolicyControl(ldap.BeheraPasswordExpired, -1, 0)},
+			},
+			expectMustChange: true,
+		},
+		{
+			name:           "ppolicy expiry warning only",
+			searchThenBind: true,
+			passwordPolicy: true,
+			bindResult: fakeResult{
+				code:     ldap.LDAPResultSuccess,
+				controls: []*ber.Packet{fakePasswordPolicyControl(-1, 0, 3600)},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fs := newFakeServer(t)
+			defer fs.Close()
+			d := newTestDirectory()
+			d.attach(fs)
+			fs.bind = func(dn, password string) fakeResult {
+				if dn == aliceDN || dn == "alice" {
+					return c.bindResult
+				}
+				return d.bindHook(dn, password)
+			}
+
+			client := fs.client()
+			client.PasswordPolicy = c.passwordPolicy
+			if c.searchThenBind {
+				client.SearchUserDN = "cn=search,dc=example,dc=com"
+				client.SearchUserPassword = "search-password"
+			}
+
+			entry, err := client.Authenticate("alice", "alice-password")
+			var mustChange *PasswordMustChangeError
+			if errors.As(err, &mustChange) != c.expectMustChange {
+				t.Fatalf("expected PasswordMustChangeError %t, got %v", c.expectMustChange, err)
+			}
+			if c.bindResult.code != ldap.LDAPResultSuccess || c.expectMustChange {
+				if entry != nil || err == nil {
+					t.Errorf("expected authentication to fail, got entry %v and error %v", entry, err)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			requested := false
+			for _, oid := range fs.bindControlTypes() {
+				requested = requested || oid == ldap.ControlTypeBeheraPasswordPolicy
+			}
+			if requested != c.passwordPolicy {
+				t.Errorf("expected password policy control requested %t, got %t", c.passwordPolicy, requested)
+			}
+		})
+	}
+}
+
+func TestAuthenticatePasswordWarnings(t *testing.T) {
+	const aliceDN = "uid=alice,dc=example,dc=com"
+	cases := []struct {
+		name              string
+		searchThenBind    bool
+		controls          []*ber.Packet
+		expectedExpiresIn string
+		expectedGrace     string
+	}{
+		{name: "no control"},
+		{name: "no warning", controls: []*ber.Packet{fakePasswordPolicyControl(-1, -1, 0)}},
+		{name: "expiring", controls: []*ber.Packet{fakePasswordPolicyControl(-1, 0, 3600)}, expectedExpiresIn: "3600"},
+		{name: "grace login", controls: []*ber.Packet{fakePasswordPolicyControl(-1, 1, 2)}, expectedGrace: "2"},
+		{name: "last grace login", controls: []*ber.Packet{fakePasswordPolicyControl(-1, 1, 0)}, expectedGrace: "0"},
+		{name: "expiring after a search", searchThenBind: true, controls: []*ber.Packet{fakePasswordPolicyControl(-1, 0, 60)}, expectedExpiresIn: "60"},
+		{name: "grace login after a search", searchThenBind: true, controls: []*ber.Packet{fakePasswordPolicyControl(-1, 1, 1)}, expectedGrace: "1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fs := newFakeServer(t)
+			defer fs.Close()
+			d := newTestDirectory()
+			d.attach(fs)
+			fs.bind = func(dn, password string) fakeResult {
+				if dn == aliceDN || dn == "alice" {
+					return fakeResult{code: ldap.LDAPResultSuccess, controls: c.controls}
+				}
+				return d.bindHook(dn, password)
+			}
+
+			client := fs.client()
+			client.PasswordPolicy = true
+			if c.searchThenBind {
+				client.SearchUserDN = "cn=search,dc=example,dc=com"
+				client.SearchUserPassword = "search-password"
+			}
+
+			entry, err := client.Authenticate("alice", "alice-password")
+			if err != nil {
+				t.Fatalf("expected the warnings not to block the login, got %v", err)
+			}
+			if expiresIn := entry.GetAttributeValue(PasswordExpiresInAttribute); expiresIn != c.expectedExpiresIn {
+				t.Errorf("expected the password to expire in %q seconds, got %q", c.expectedExpiresIn, expiresIn)
+			}
+			if grace := entry.GetAttributeValue(GraceLoginsAttribute); grace != c.expectedGrace {
+				t.Errorf("expected %q grace logins left, got %q", c.expectedGrace, grace)
+			}
+		})
+	}
+}
+
+func TestRequireTLSRejectsPlaintext(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	newTestDirectory().attach(fs)
+
+	client := fs.client()
+	client.RequireTLS = true
+	for _, searchUserDN := range []string{"", "cn=search,dc=example,dc=com"} {
+		client.SearchUserDN = searchUserDN
+		client.SearchUserPassword = "search-password"
+		if _, err := client.Authenticate("alice", "alice-password"); !errors.Is(err, ErrPlaintextBind) {
+			t.Errorf("search user %q: expected ErrPlaintextBind, got %v", searchUserDN, err)
+		}
+	}
+	if binds := fs.boundDNs(); len(binds) != 0 {
+		t.Errorf("expected no binds, got %v", binds)
+	}
+
+	// A plaintext connection that is already open, here from the pool,
+	// is refused at the bind too.
+	client = fs.client()
+	client.MaxIdleConns = 1
+	if _, err := client.Authenticate("alice", "alice-password"); err != nil {
+		t.Fatalf("expected valid credentials to authenticate: %v", err)
+	}
+	binds := len(fs.boundDNs())
+	client.RequireTLS = true
+	if _, err := client.Authenticate("alice", "alice-password"); !errors.Is(err, ErrPlaintextBind) {
+		t.Errorf("expected ErrPlaintextBind for a pooled plaintext connection, got %v", err)
+	}
+	if n := len(fs.boundDNs()); n != binds {
+		t.Errorf("expected no more binds, got %d", n-binds)
+	}
+}
+
+func TestUserBaseDNs(t *testing.T) {
+	bases := []string{
+		"ou=employees,dc=example,dc=com",
+		"ou=contractors,dc=example,dc=com",
+		"ou=services,dc=example,dc=com",
+	}
+	cases := []struct {
+		name             string
+		users            map[string]string
+		missing          string
+		failing          string
+		expectedDN       string
+		expectedSearches []string
+		expectErr        string
+	}{
+		{
+			name:             "user in the second base",
+			users:            map[string]string{"uid=alice,ou=contractors,dc=example,dc=com": "alice"},
+			expectedDN:       "uid=alice,ou=contractors,dc=example,dc=com",
+			expectedSearches: bases[:2],
+		},
+		{
+			name: "first match wins",
+			users: map[string]string{
+				"uid=alice,ou=employees,dc=example,dc=com": "alice",
+				"uid=alice,ou=services,dc=example,dc=com":  "alice",
+			},
+			expectedDN:       "uid=alice,ou=employees,dc=example,dc=com",
+			expectedSearches: bases[:1],
+		},
+		{
+			name:             "missing base skipped",
+			users:            map[string]string{"uid=alice,ou=services,dc=example,dc=com": "alice"},
+			missing:          bases[0],
+			expectedDN:       "uid=alice,ou=services,dc=example,dc=com",
+			expectedSearches: bases,
+		},
+		{
+			name:             "not found in any base",
+			users:            map[string]string{"uid=bob,ou=contractors,dc=example,dc=com": "bob"},
+			expectedSearches: bases,
+			expectErr:        "in any of the user base DNs",
+		},
+		{
+			name:             "failing base",
+			users:            map[string]string{"uid=alice,ou=services,dc=example,dc=com": "alice"},
+			failing:          bases[1],
+			expectedSearches: bases[:2],
+			expectErr:        "under ou=contractors,dc=example,dc=com",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fs := newFakeServer(t)
+			defer fs.Close()
+			d := &fakeDirectory{passwords: map[string]string{"cn=search,dc=example,dc=com": "search-password"}}
+			for dn, uid := range c.users {
+				d.passwords[dn] = uid + "-password"
+				d.entries = append(d.entries, ldap.NewEntry(dn, map[string][]string{"uid": {uid}}))
+			}
+			d.attach(fs)
+			fs.search = func(req fakeSearch) ([]*ldap.Entry, fakeResult) {
+				switch req.BaseDN {
+				case c.missing:
+					return nil, fakeResult{code: ldap.LDAPResultNoSuchObject, diag: "no such object"}
+				case c.failing:
+					return nil, fakeResult{code: ldap.LDAPResultUnwillingToPerform, diag: "unwilling"}
+				}
+				entries, result := d.searchHook(req)
+				var under []*ldap.Entry
+				for _, entry := range entries {
+					if strings.HasSuffix(entry.DN, ","+req.BaseDN) {
+						under = append(under, entry)
+					}
+				}
+				return under, result
+			}
+
+			client := fs.client()
+			client.SearchUserDN = "cn=search,dc=example,dc=com"
+			client.SearchUserPassword = "search-password"
+			client.UserBaseDNs = bases
+
+			entry, err := client.Authenticate("alice", "alice-password")
+			var searched []string
+			for _, req := range fs.searchRequests() {
+				searched = append(searched, req.BaseDN)
+			}
+			if !reflect.DeepEqual(searched, c.expectedSearches) {
+				t.Errorf("expected searches under %v, got %v", c.expectedSearches, searched)
+			}
+			if c.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.expectErr) {
+					t.Fatalf("expected an error containing %q, got %v", c.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected alice to authenticate: %v", err)
+			}
+			if entry.DN != c.expectedDN {
+				t.Errorf("expected entry %q, got %q", c.expectedDN, entry.DN)
+			}
+		})
+	}
+}
+
+func TestMachineBaseDNs(t *testing.T) {
+	cases := []struct {
+		name             string
+		baseDN           string
+		userBases        []string
+		expectedSearches []string
+	}{
+		{
+			name:             "after the user base DNs",
+			userBases:        []string{"ou=employees,dc=example,dc=com"},
+			expectedSearches: []string{"ou=employees,dc=example,dc=com", "ou=computers,dc=example,dc=com"},
+		},
+		{
+			name:             "after the base DN",
+			baseDN:           "ou=people,dc=example,dc=com",
+			expectedSearches: []string{"ou=people,dc=example,dc=com", "ou=computers,dc=example,dc=com"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fs := newFakeServer(t)
+			defer fs.Close()
+			d := &fakeDirectory{
+				passwords: map[string]string{
+					"cn=search,dc=example,dc=com":               "search-password",
+					"cn=build01,ou=computers,dc=example,dc=com": "machine-password",
+				},
+				entries: []*ldap.Entry{
+					ldap.NewEntry("cn=build01,ou=computers,dc=example,dc=com", map[string][]string{
+						"uid":         {"build01$"},
+						"objectClass": {"top", "computer"},
+					}),
+				},
+			}
+			d.attach(fs)
+			fs.search = func(req fakeSearch) ([]*ldap.Entry, fakeResult) {
+				entries, result := d.searchHook(req)
+				var under []*ldap.Entry
+				for _, entry := range entries {
+					if strings.HasSuffix(entry.DN, ","+req.BaseDN) {
+						under = append(under, entry)
+					}
+				}
+				return under, result
+			}
+
+			client := fs.client()
+			if c.baseDN != "" {
+				client.BaseDN = c.baseDN
+			}
+			client.SearchUserDN = "cn=search,dc=example,dc=com"
+			client.SearchUserPassword = "search-password"
+			client.UserBaseDNs = c.userBases
+			client.MachineBaseDNs = []string{"ou=computers,dc=example,dc=com"}
+
+			entry, err := client.Authenticate("build01$", "machine-password")
+			if err != nil {
+				t.Fatalf("expected the machine account to authenticate: %v", err)
+			}
+			if entry.DN != "cn=build01,ou=computers,dc=example,dc=com" {
+				t.Errorf("expected the computer's entry, got %q", entry.DN)
+			}
+			var searched []string
+			for _, req := range fs.searchRequests() {
+				searched = append(searched, req.BaseDN)
+			}
+			if !reflect.DeepEqual(searched, c.expectedSearches) {
+				t.Errorf("expected searches under %v, got %v", c.expectedSearches, searched)
+			}
+
+			if _, err := client.Authenticate("alice", "alice-password"); err == nil || !strings.Contains(err.Error(), "ou=computers,dc=example,dc=com") {
+				t.Errorf("expected alice not to be found in any base, got %v", err)
+			}
+		})
+	}
+}
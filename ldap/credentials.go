@@ -0,0 +1,48 @@
+package ldap
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// What Authenticate and ChangePassword do with usernames and passwords
+// containing control characters, for ControlCharPolicy.
+const (
+	// ControlCharsReject refuses credentials containing NUL or any other
+	// control character (C0, DEL or C1) before binding.
+	ControlCharsReject = "reject"
+	// ControlCharsRejectNull only refuses credentials containing NUL,
+	// which some servers truncate credentials at.
+	ControlCharsRejectNull = "reject-null"
+	// ControlCharsAllow sends credentials to the directory as they are.
+	ControlCharsAllow = "allow"
+)
+
+// ErrControlCharacters is wrapped in the error returned for credentials
+// refused by ControlCharPolicy.
+var ErrControlCharacters = errors.New("credentials contain control characters")
+
+// hasControlCharacters reports whether s contains characters refused by
+// the control character policy.
+func (c *Client) hasControlCharacters(s string) bool {
+	switch c.ControlCharPolicy {
+	case ControlCharsAllow:
+		return false
+	case ControlCharsRejectNull:
+		return strings.IndexByte(s, 0) >= 0
+	}
+	return strings.IndexFunc(s, unicode.IsControl) >= 0
+}
+
+// checkCredentials returns an error wrapping ErrControlCharacters if any
+// of the credentials has characters refused by the control character
+// policy.
+func (c *Client) checkCredentials(credentials ...string) error {
+	for _, s := range credentials {
+		if c.hasControlCharacters(s) {
+			return ErrControlCharacters
+		}
+	}
+	return nil
+}
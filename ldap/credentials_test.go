@@ -0,0 +1,92 @@
+package ldap
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestControlCharacterPolicy(t *testing.T) {
+	cases := []struct {
+		name     string
+		policy   string
+		username string
+		password string
+		rejected bool
+	}{
+		{name: "valid credentials", username: "alice", password: "alice-password"},
+		{name: "non-ASCII password", username: "alice", password: "pässwörd-密码"},
+		{name: "NUL in password", username: "alice", password: "alice-password\x00anything", rejected: true},
+		{name: "NUL in username", username: "alice\x00admin", password: "alice-password", rejected: true},
+		{name: "trailing NUL", username: "alice", password: "alice-password\x00", rejected: true},
+		{name: "newline in username", username: "alice\n", password: "alice-password", rejected: true},
+		{name: "tab in password", username: "alice", password: "alice\tpassword", rejected: true},
+		{name: "DEL", username: "alice", password: "alice-password\x7f", rejected: true},
+		{name: "C1 control", username: "alice\u0085", password: "alice-password", rejected: true},
+		{name: "explicit reject", policy: ControlCharsReject, username: "alice", password: "a\x01", rejected: true},
+		{name: "reject-null with NUL", policy: ControlCharsRejectNull, username: "alice", password: "alice-password\x00", rejected: true},
+		{name: "reject-null with tab", policy: ControlCharsRejectNull, username: "alice", password: "alice\tpassword"},
+		{name: "allow", policy: ControlCharsAllow, username: "alice", password: "alice-password\x00"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fs := newFakeServer(t)
+			defer fs.Close()
+			d := newTestDirectory()
+			var mu sync.Mutex
+			var boundPasswords []string
+			fs.bind = func(dn, password string) fakeResult {
+				mu.Lock()
+				defer mu.Unlock()
+				boundPasswords = append(boundPasswords, password)
+				return d.bindHook(dn, password)
+			}
+			fs.search = d.searchHook
+
+			client := fs.client()
+			client.SearchUserDN = "cn=search,dc=example,dc=com"
+			client.SearchUserPassword = "search-password"
+			client.ControlCharPolicy = c.policy
+
+			_, err := client.Authenticate(c.username, c.password)
+			if c.rejected {
+				if !errors.Is(err, ErrControlCharacters) {
+					t.Fatalf("expected ErrControlCharacters, got %v", err)
+				}
+				if n := fs.connCount(); n != 0 {
+					t.Errorf("expected no connection to the directory, got %d", n)
+				}
+				return
+			}
+			if errors.Is(err, ErrControlCharacters) {
+				t.Fatalf("expected the credentials to be sent to the directory, got %v", err)
+			}
+			// The password reaches the directory untouched.
+			mu.Lock()
+			defer mu.Unlock()
+			if len(boundPasswords) != 2 || boundPasswords[1] != c.password {
+				t.Errorf("expected the user to bind with %q, got %q", c.password, boundPasswords)
+			}
+		})
+	}
+}
+
+func TestChangePasswordControlCharacters(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	newTestDirectory().attach(fs)
+	client := fs.client()
+
+	var authErr *AuthenticationError
+	if err := client.ChangePassword("alice", "alice-password\x00", "new-password"); !errors.As(err, &authErr) || !errors.Is(err, ErrControlCharacters) {
+		t.Errorf("expected an AuthenticationError for the current password, got %v", err)
+	}
+	var policyErr *PasswordPolicyError
+	if err := client.ChangePassword("alice", "alice-password", "new-password\x00"); !errors.As(err, &policyErr) {
+		t.Errorf("expected a PasswordPolicyError for the new password, got %v", err)
+	}
+	if n := fs.connCount(); n != 0 {
+		t.Errorf("expected no connection to the directory, got %d", n)
+	}
+}
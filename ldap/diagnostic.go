@@ -0,0 +1,83 @@
+package ldap
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/golang/glog"
+)
+
+// adDataCodes label the sub-codes AD reports as "data <code>" in the
+// diagnostic message of a failed bind.
+var adDataCodes = map[string]string{
+	"525": "user not found",
+	"52e": "invalid credentials",
+	"52f": "account restrictions",
+	"530": "logon not permitted at this time",
+	"531": "logon not permitted from this workstation",
+	"532": "password expired",
+	"533": "account disabled",
+	"568": "too many security IDs",
+	"701": "account expired",
+	"773": "user must reset password",
+	"775": "account locked out",
+}
+
+var adDataCodePattern = regexp.MustCompile(`\bdata ([0-9a-fA-F]+)\b`)
+
+// BindDiagnostic is what the diagnostic message of a failed bind says
+// about why it failed.
+type BindDiagnostic struct {
+	// Message is the server's diagnostic message.
+	Message string
+	// Code is AD's sub-code, e.g. "52e", or empty for other servers.
+	Code string
+	// Label describes Code, or is empty for an unknown code.
+	Label string
+}
+
+// ParseBindDiagnostic parses the diagnostic message of a failed bind.
+func ParseBindDiagnostic(message string) BindDiagnostic {
+	// AD terminates the message with a NUL.
+	d := BindDiagnostic{Message: strings.Trim(message, " \t\r\n\x00")}
+	if match := adDataCodePattern.FindStringSubmatch(d.Message); match != nil {
+		d.Code = strings.ToLower(match[1])
+		d.Label = adDataCodes[d.Code]
+	}
+	return d
+}
+
+// String describes the diagnostic for logs.
+func (d BindDiagnostic) String() string {
+	switch {
+	case d.Code == "":
+		return d.Message
+	case d.Label == "":
+		return "AD data " + d.Code + ": " + d.Message
+	}
+	return d.Label + " (AD data " + d.Code + "): " + d.Message
+}
+
+// bindDiagnostic returns the diagnostic of err if it is a bind the
+// server rejected with a diagnostic message. Client side errors, such as
+// network errors, have no diagnostic.
+func bindDiagnostic(err error) (BindDiagnostic, bool) {
+	var ldapErr *ldap.Error
+	if !errors.As(err, &ldapErr) || ldapErr.Err == nil || ldapErr.ResultCode >= ldap.ErrorNetwork {
+		return BindDiagnostic{}, false
+	}
+	d := ParseBindDiagnostic(ldapErr.Err.Error())
+	return d, d.Message != ""
+}
+
+// logBindFailure logs why the bind as dn failed, according to the
+// server. The diagnostic can tell e.g. a disabled account from a wrong
+// password, so it is only ever logged: clients get the same answer for
+// every failed bind.
+func logBindFailure(dn string, err error) {
+	if d, ok := bindDiagnostic(err); ok {
+		glog.Infof("LDAP bind as %s failed: %s", dn, d)
+	}
+}
@@ -0,0 +1,97 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestParseBindDiagnostic(t *testing.T) {
+	cases := []struct {
+		message       string
+		expectedCode  string
+		expectedLabel string
+	}{
+		{
+			message:       "80090308: LdapErr: DSID-0C09042A, comment: AcceptSecurityContext error, data 52e, v3839",
+			expectedCode:  "52e",
+			expectedLabel: "invalid credentials",
+		},
+		{
+			message:       "80090308: LdapErr: DSID-0C09042A, comment: AcceptSecurityContext error, data 533, v4563\x00",
+			expectedCode:  "533",
+			expectedLabel: "account disabled",
+		},
+		{
+			message:       "80090308: LdapErr: DSID-0C090447, comment: AcceptSecurityContext error, data 775, v3839",
+			expectedCode:  "775",
+			expectedLabel: "account locked out",
+		},
+		{
+			message:       "80090308: LdapErr: DSID-0C09042A, comment: AcceptSecurityContext error, data 532, v3839",
+			expectedCode:  "532",
+			expectedLabel: "password expired",
+		},
+		{
+			message:       "80090308: LdapErr: DSID-0C09042A, comment: AcceptSecurityContext error, data 701, v3839",
+			expectedCode:  "701",
+			expectedLabel: "account expired",
+		},
+		{
+			message:       "80090308: LdapErr: DSID-0C09042A, comment: AcceptSecurityContext error, data 525, v3839",
+			expectedCode:  "525",
+			expectedLabel: "user not found",
+		},
+		{
+			message:       "80090308: LdapErr: DSID-0C09042A, comment: AcceptSecurityContext error, data 773, v3839",
+			expectedCode:  "773",
+			expectedLabel: "user must reset password",
+		},
+		{
+			// The case of the code varies between AD versions.
+			message:       "80090308: LdapErr: DSID-0C09042A, comment: AcceptSecurityContext error, data 52E, v3839",
+			expectedCode:  "52e",
+			expectedLabel: "invalid credentials",
+		},
+		{
+			// A code without a label is still reported.
+			message:      "80090308: LdapErr: DSID-0C09042A, comment: AcceptSecurityContext error, data 5a1, v3839",
+			expectedCode: "5a1",
+		},
+		// Other servers have no sub-code.
+		{message: "Invalid credentials"},
+		{message: ""},
+		{message: "database 52e is unavailable"},
+	}
+	for _, c := range cases {
+		d := ParseBindDiagnostic(c.message)
+		if d.Code != c.expectedCode || d.Label != c.expectedLabel {
+			t.Errorf("%q: Expected code %q (%q), got %q (%q)", c.message, c.expectedCode, c.expectedLabel, d.Code, d.Label)
+		}
+	}
+}
+
+func TestBindDiagnostic(t *testing.T) {
+	message := "80090308: LdapErr: DSID-0C09042A, comment: AcceptSecurityContext error, data 533, v3839"
+	err := fmt.Errorf("Error binding user: %w", ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New(message)))
+	d, ok := bindDiagnostic(err)
+	if !ok {
+		t.Fatalf("Expected a diagnostic for %v", err)
+	}
+	if expected := "account disabled (AD data 533): " + message; d.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, d.String())
+	}
+
+	for _, err := range []error{
+		nil,
+		errors.New("data 533"),
+		ldap.NewError(ldap.ErrorNetwork, errors.New("connection reset, data 533")),
+		ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("")),
+	} {
+		if d, ok := bindDiagnostic(err); ok {
+			t.Errorf("%v: Expected no diagnostic, got %q", err, d)
+		}
+	}
+}
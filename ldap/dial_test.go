@@ -0,0 +1,107 @@
+package ldap
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// newFakeTLSServer is a fake server behind TLS with a self-signed
+// certificate for 127.0.0.1, and a pool trusting it.
+func newFakeTLSServer(t *testing.T) (*fakeServer, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ldap.example.com"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	fs := &fakeServer{t: t, listener: tls.NewListener(listener, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})}
+	go fs.serve()
+	return fs, roots
+}
+
+func TestDialTLS(t *testing.T) {
+	fs, roots := newFakeTLSServer(t)
+	defer fs.Close()
+	newTestDirectory().attach(fs)
+
+	client := fs.client()
+	client.UseInsecure = false
+	client.RequireTLS = true
+	// The server name defaults to the host dialed.
+	client.TLSConfig = &tls.Config{RootCAs: roots}
+	client.ReadBufferSize = 64 << 10
+	client.WriteBufferSize = 64 << 10
+
+	if _, err := client.Authenticate("alice", "alice-password"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.TLSConfig.ServerName != "" {
+		t.Errorf("expected the TLS config not to be modified, got server name %q", client.TLSConfig.ServerName)
+	}
+
+	client.TLSConfig = &tls.Config{RootCAs: roots, ServerName: "ldap.example.com"}
+	if _, err := client.Authenticate("alice", "alice-password"); err == nil {
+		t.Errorf("expected a certificate not valid for the server name to be rejected")
+	}
+}
+
+// BenchmarkAuthenticate measures logins over pooled connections, with
+// and without TCP_NODELAY.
+func BenchmarkAuthenticate(b *testing.B) {
+	for _, c := range []struct {
+		name              string
+		disableTCPNoDelay bool
+	}{
+		{name: "nodelay"},
+		{name: "nagle", disableTCPNoDelay: true},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			fs := newFakeServer(b)
+			defer fs.Close()
+			newTestDirectory().attach(fs)
+
+			client := fs.client()
+			client.SearchUserDN = "cn=search,dc=example,dc=com"
+			client.SearchUserPassword = "search-password"
+			client.MaxIdleConns = 1
+			client.DisableTCPNoDelay = c.disableTCPNoDelay
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := client.Authenticate("alice", "alice-password"); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
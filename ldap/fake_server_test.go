@@ -0,0 +1,387 @@
+package ldap
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeResult is the LDAPResult part of a response from the fake server.
+type fakeResult struct {
+	code     uint16
+	diag     string
+	controls []*ber.Packet
+}
+
+// fakeSearch is a decoded search request.
+type fakeSearch struct {
+	BaseDN   string
+	Scope    int
+	Filter   string
+	Controls []*ber.Packet
+	// BoundDN is the DN the connection was last successfully bound as
+	// with a simple bind, if any.
+	BoundDN string
+}
+
+// fakeServer is a minimal in-process LDAP server. Each operation is
+// answered by the corresponding hook; unset hooks fail the operation
+// with unwillingToPerform.
+type fakeServer struct {
+	t        testing.TB
+	listener net.Listener
+
+	bind     func(dn, password string) fakeResult
+	search   func(req fakeSearch) ([]*ldap.Entry, fakeResult)
+	modify   func(dn string, changes []*ber.Packet) fakeResult
+	extended func(name string, value []byte) (fakeResult, []byte)
+	// saslBind answers SASL binds with a result and the server's SASL
+	// credentials, if any.
+	saslBind func(mechanism string, credentials []byte) (fakeResult, []byte)
+
+	mu           sync.Mutex
+	binds        []string
+	bindControls []string
+	searches     []fakeSearch
+	conns        int
+}
+
+func newFakeServer(t testing.TB) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	fs := &fakeServer{t: t, listener: listener}
+	go fs.serve()
+	return fs
+}
+
+// client returns an insecure Client pointed at the fake server.
+func (fs *fakeServer) client() *Client {
+	addr := fs.listener.Addr().(*net.TCPAddr)
+	return &Client{
+		BaseDN:             "dc=example,dc=com",
+		LdapServer:         addr.IP.String(),
+		LdapPort:           uint(addr.Port),
+		UseInsecure:        true,
+		UserLoginAttribute: "uid",
+	}
+}
+
+func (fs *fakeServer) Close() {
+	fs.listener.Close()
+}
+
+// boundDNs returns the DNs of every bind the server has seen.
+func (fs *fakeServer) boundDNs() []string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return append([]string(nil), fs.binds...)
+}
+
+// bindControlTypes returns the OIDs of the controls sent with binds.
+func (fs *fakeServer) bindControlTypes() []string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return append([]string(nil), fs.bindControls...)
+}
+
+// searchRequests returns every search request the server has seen.
+func (fs *fakeServer) searchRequests() []fakeSearch {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return append([]fakeSearch(nil), fs.searches...)
+}
+
+func (fs *fakeServer) connCount() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.conns
+}
+
+func (fs *fakeServer) serve() {
+	for {
+		conn, err := fs.listener.Accept()
+		if err != nil {
+			return
+		}
+		fs.mu.Lock()
+		fs.conns++
+		fs.mu.Unlock()
+		go fs.handle(conn)
+	}
+}
+
+func (fs *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	var boundDN string
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		var responses []*ber.Packet
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			if auth := op.Children[2]; auth.ClassType == ber.ClassContext && auth.Tag == 3 {
+				responses = append(responses, fs.handleSASLBind(id, auth))
+				break
+			}
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			fs.mu.Lock()
+			fs.binds = append(fs.binds, dn)
+			if len(packet.Children) > 2 {
+				for _, control := range packet.Children[2].Children {
+					fs.bindControls = append(fs.bindControls, control.Children[0].Value.(string))
+				}
+			}
+			fs.mu.Unlock()
+			result := fakeResult{code: ldap.LDAPResultUnwillingToPerform}
+			if fs.bind != nil {
+				result = fs.bind(dn, password)
+			}
+			boundDN = ""
+			if result.code == ldap.LDAPResultSuccess {
+				boundDN = dn
+			}
+			responses = append(responses, fakeResponse(id, ldap.ApplicationBindResponse, result))
+		case ldap.ApplicationSearchRequest:
+			req := fakeSearch{
+				BaseDN:  op.Children[0].Value.(string),
+				Scope:   int(op.Children[1].Value.(int64)),
+				BoundDN: boundDN,
+			}
+			req.Filter, _ = ldap.DecompileFilter(op.Children[6])
+			if len(packet.Children) > 2 {
+				req.Controls = packet.Children[2].Children
+			}
+			fs.mu.Lock()
+			fs.searches = append(fs.searches, req)
+			fs.mu.Unlock()
+			result := fakeResult{code: ldap.LDAPResultUnwillingToPerform}
+			var entries []*ldap.Entry
+			if fs.search != nil {
+				entries, result = fs.search(req)
+			}
+			for _, entry := range entries {
+				responses = append(responses, fakeEntry(id, entry))
+			}
+			responses = append(responses, fakeResponse(id, ldap.ApplicationSearchResultDone, result))
+		case ldap.ApplicationModifyRequest:
+			dn := op.Children[0].Value.(string)
+			result := fakeResult{code: ldap.LDAPResultUnwillingToPerform}
+			if fs.modify != nil {
+				result = fs.modify(dn, op.Children[1].Children)
+			}
+			responses = append(responses, fakeResponse(id, ldap.ApplicationModifyResponse, result))
+		case ldap.ApplicationExtendedRequest:
+			name := op.Children[0].Data.String()
+			var value []byte
+			if len(op.Children) > 1 {
+				value = op.Children[1].Data.Bytes()
+			}
+			result := fakeResult{code: ldap.LDAPResultUnwillingToPerform}
+			var respValue []byte
+			if fs.extended != nil {
+				result, respValue = fs.extended(name, value)
+			}
+			resp := fakeResponse(id, ldap.ApplicationExtendedResponse, result)
+			if respValue != nil {
+				resp.Children[1].AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 11, string(respValue), "responseValue"))
+			}
+			responses = append(responses, resp)
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			continue
+		}
+
+		for _, resp := range responses {
+			if _, err := conn.Write(resp.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleSASLBind answers a SASL bind, whose SaslCredentials are auth.
+func (fs *fakeServer) handleSASLBind(id int64, auth *ber.Packet) *ber.Packet {
+	mechanism := auth.Children[0].Data.String()
+	var credentials []byte
+	if len(auth.Children) > 1 {
+		credentials = auth.Children[1].Data.Bytes()
+	}
+	result := fakeResult{code: ldap.LDAPResultAuthMethodNotSupported}
+	var serverCredentials []byte
+	if fs.saslBind != nil {
+		result, serverCredentials = fs.saslBind(mechanism, credentials)
+	}
+	op := fakeResultOp(ldap.ApplicationBindResponse, result)
+	if serverCredentials != nil {
+		op.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 7, string(serverCredentials), "serverSaslCreds"))
+	}
+	return fakeMessage(id, op, result.controls)
+}
+
+func fakeMessage(id int64, op *ber.Packet, controls []*ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	packet.AppendChild(op)
+	if len(controls) > 0 {
+		wrapper := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+		for _, control := range controls {
+			wrapper.AppendChild(control)
+		}
+		packet.AppendChild(wrapper)
+	}
+	return packet
+}
+
+func fakeResponse(id int64, tag ber.Tag, result fakeResult) *ber.Packet {
+	return fakeMessage(id, fakeResultOp(tag, result), result.controls)
+}
+
+// fakeResultOp builds the LDAPResult of a response, which further fields
+// may be appended to.
+func fakeResultOp(tag ber.Tag, result fakeResult) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(result.code), "resultCode"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, result.diag, "diagnosticMessage"))
+	return op
+}
+
+// fakePasswordPolicyControl builds a password policy response control
+// (draft-behera-ldap-password-policy). errCode is one of the
+// ldap.BeheraXxx error values, or -1 for none. warningTag selects the
+// warning: 0 for timeBeforeExpiration, 1 for graceAuthNsRemaining, or -1
+// for none.
+func fakePasswordPolicyControl(errCode int64, warningTag int, warning int64) *ber.Packet {
+	value := ber.NewSequence("PasswordPolicyResponseValue")
+	if warningTag >= 0 {
+		w := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "warning")
+		w.AppendChild(ber.NewInteger(ber.ClassContext, ber.TypePrimitive, ber.Tag(warningTag), warning, "warning value"))
+		value.AppendChild(w)
+	}
+	if errCode >= 0 {
+		value.AppendChild(ber.NewInteger(ber.ClassContext, ber.TypePrimitive, 1, errCode, "error"))
+	}
+
+	control := ber.NewSequence("Control")
+	control.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, ldap.ControlTypeBeheraPasswordPolicy, "controlType"))
+	control.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(value.Bytes()), "controlValue"))
+	return control
+}
+
+func fakeEntry(id int64, entry *ldap.Entry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "objectName"))
+	attrs := ber.NewSequence("attributes")
+	for _, attr := range entry.Attributes {
+		a := ber.NewSequence("attribute")
+		a.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attr.Name, "type"))
+		vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		for _, v := range attr.Values {
+			vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "value"))
+		}
+		a.AppendChild(vals)
+		attrs.AppendChild(a)
+	}
+	op.AppendChild(attrs)
+	return fakeMessage(id, op, nil)
+}
+
+// fakeDirectory is a simple directory model for the fake server: users
+// bind with their DN and password, and are found by their uid.
+type fakeDirectory struct {
+	passwords map[string]string
+	entries   []*ldap.Entry
+}
+
+// bindHook accepts correct passwords and, like many real servers,
+// treats a bind with an empty password as a successful anonymous bind.
+func (d *fakeDirectory) bindHook(dn, password string) fakeResult {
+	if password == "" {
+		return fakeResult{code: ldap.LDAPResultSuccess}
+	}
+	if expected, ok := d.passwords[dn]; ok && expected == password {
+		return fakeResult{code: ldap.LDAPResultSuccess}
+	}
+	return fakeResult{code: ldap.LDAPResultInvalidCredentials, diag: "invalid credentials"}
+}
+
+// searchHook matches entries on simple (attr=value) filters and
+// conjunctions of them.
+func (d *fakeDirectory) searchHook(req fakeSearch) ([]*ldap.Entry, fakeResult) {
+	var matches []*ldap.Entry
+	for _, entry := range d.entries {
+		if entryMatches(entry, req.Filter) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches, fakeResult{code: ldap.LDAPResultSuccess}
+}
+
+func entryMatches(entry *ldap.Entry, filter string) bool {
+	if len(filter) < 2 || filter[0] != '(' || filter[len(filter)-1] != ')' {
+		return false
+	}
+	inner := filter[1 : len(filter)-1]
+	if strings.HasPrefix(inner, "&") {
+		for _, sub := range splitFilters(inner[1:]) {
+			if !entryMatches(entry, sub) {
+				return false
+			}
+		}
+		return true
+	}
+	for i := 0; i < len(inner); i++ {
+		if inner[i] == '=' {
+			attr, value := inner[:i], inner[i+1:]
+			for _, v := range entry.GetAttributeValues(attr) {
+				if v == value {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return false
+}
+
+// splitFilters splits a list of parenthesized filters.
+func splitFilters(list string) []string {
+	var filters []string
+	depth, start := 0, 0
+	for i, ch := range list {
+		switch ch {
+		case '(':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				filters = append(filters, list[start:i+1])
+			}
+		}
+	}
+	return filters
+}
+
+func (d *fakeDirectory) attach(fs *fakeServer) {
+	fs.bind = d.bindHook
+	fs.search = d.searchHook
+}
@@ -0,0 +1,96 @@
+package ldap
+
+import (
+	"errors"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// FuzzUserSearchFilter checks that no username changes the structure of
+// the user search filter: it always compiles to a single equality match
+// on the login attribute, whose value is the username verbatim.
+func FuzzUserSearchFilter(f *testing.F) {
+	for _, username := range []string{
+		"",
+		"alice",
+		"*",
+		"alice*",
+		"*)(uid=*",
+		"alice)(|(uid=*",
+		"alice)(&)",
+		"*)(|(objectClass=*)",
+		`\2a`,
+		`alice\`,
+		"alice\x00",
+		"(",
+		")",
+		"))((",
+		"admin)(!(userPassword=*))",
+		"élise",
+		"\xff\xfe",
+		"alice@example.com",
+		"cn=alice,dc=example,dc=com",
+		" alice ",
+	} {
+		f.Add(username)
+	}
+
+	f.Fuzz(func(t *testing.T, username string) {
+		filter := userSearchFilter("uid", username)
+		packet, err := ldap.CompileFilter(filter)
+		if err != nil {
+			t.Fatalf("%q: filter %q doesn't compile: %v", username, filter, err)
+		}
+		if packet.Tag != ldap.FilterEqualityMatch {
+			t.Fatalf("%q: filter %q is a %s, not an equality match", username, filter, ldap.FilterMap[uint64(packet.Tag)])
+		}
+		if len(packet.Children) != 2 {
+			t.Fatalf("%q: filter %q has %d parts", username, filter, len(packet.Children))
+		}
+		if attr := packet.Children[0].Data.String(); attr != "uid" {
+			t.Errorf("%q: filter %q matches on %q", username, filter, attr)
+		}
+		value := packet.Children[1].Data.String()
+		if value != username {
+			t.Errorf("%q: filter %q matches the value %q", username, filter, value)
+		}
+		if !utf8.ValidString(username) {
+			return
+		}
+		decompiled, err := ldap.DecompileFilter(packet)
+		if err != nil {
+			t.Fatalf("%q: filter %q doesn't decompile: %v", username, filter, err)
+		}
+		if decompiled != filter {
+			t.Errorf("%q: filter %q decompiles to %q", username, filter, decompiled)
+		}
+	})
+}
+
+func TestUserSearchFilterInjection(t *testing.T) {
+	cases := map[string]string{
+		"*":            `(uid=\2a)`,
+		"alice)(uid=*": `(uid=alice\29\28uid=\2a)`,
+		"*)(|(uid=*":   `(uid=\2a\29\28|\28uid=\2a)`,
+		`alice\`:       `(uid=alice\5c)`,
+	}
+	for username, expectedFilter := range cases {
+		fs := newFakeServer(t)
+		newTestDirectory().attach(fs)
+		client := fs.client()
+		client.SearchUserDN = "cn=search,dc=example,dc=com"
+		client.SearchUserPassword = "search-password"
+
+		_, err := client.Authenticate(username, "alice-password")
+		var credsErr *credentialsError
+		if !errors.As(err, &credsErr) {
+			t.Errorf("%q: expected no user to be found, got %v", username, err)
+		}
+		if searches := fs.searchRequests(); len(searches) != 1 || searches[0].Filter != expectedFilter {
+			t.Errorf("%q: expected a search for %s, got %+v", username, expectedFilter, searches)
+		}
+		fs.Close()
+	}
+}
@@ -0,0 +1,182 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// How the groups of a user are found.
+const (
+	// MembershipMemberOf reads the groups from the user's memberOf
+	// attribute.
+	MembershipMemberOf = "memberof"
+	// MembershipMemberUID searches for the posixGroups that list the
+	// user's uid in memberUid (RFC 2307).
+	MembershipMemberUID = "memberuid"
+)
+
+// What happens to a login when the user's bind succeeded but their groups
+// couldn't be searched for.
+const (
+	// GroupFailureClosed fails the login.
+	GroupFailureClosed = "fail-closed"
+	// GroupFailureOpen returns the entry with only the groups already in
+	// its memberOf attribute, if any, marked with
+	// GroupsIncompleteAttribute.
+	GroupFailureOpen = "fail-open"
+)
+
+// GroupsIncompleteAttribute is set to "true" on entries returned without
+// their searched groups under GroupFailureOpen. The colon can't appear in
+// LDAP attribute names, so the directory can't set it.
+const GroupsIncompleteAttribute = "kubernetes-ldap:groupsIncomplete"
+
+// defaultGroupUIDAttribute is the user attribute that posixGroups list
+// in memberUid.
+const defaultGroupUIDAttribute = "uid"
+
+// ErrPagingCookieInvalid is returned when a paged group search keeps
+// being interrupted by the server rejecting its paging cookie, e.g.
+// because the groups changed under it, after GroupPageRestarts restarts.
+var ErrPagingCookieInvalid = errors.New("paged results cookie was rejected")
+
+var (
+	groupSearchFailed = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_group_search_failed",
+			Help: "Total number of LDAP group search failures.",
+		},
+	)
+	groupSearchRestarts = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_group_search_restarts",
+			Help: "Total number of paged LDAP group searches restarted after the server rejected the paging cookie.",
+		},
+	)
+)
+
+// resolveGroups adds the DNs of the user's groups to the entry's memberOf
+// attribute when they aren't kept there, so that groups are read the
+// same way whichever membership model the directory uses.
+func (c *Client) resolveGroups(conn *ldap.Conn, entry *ldap.Entry) error {
+	if c.GroupMembership != MembershipMemberUID {
+		return nil
+	}
+
+	uidAttribute := c.GroupUIDAttribute
+	if uidAttribute == "" {
+		uidAttribute = defaultGroupUIDAttribute
+	}
+	uid := entry.GetAttributeValue(uidAttribute)
+	if uid == "" {
+		return nil
+	}
+
+	baseDN := c.GroupBaseDN
+	if baseDN == "" {
+		baseDN = c.BaseDN
+	}
+	scope, err := ParseSearchScope(c.GroupSearchScope)
+	if err != nil {
+		return err
+	}
+	groups, err := c.searchGroups(conn, &ldap.SearchRequest{
+		BaseDN:       baseDN,
+		Scope:        scope,
+		DerefAliases: ldap.NeverDerefAliases,
+		TimeLimit:    10,
+		Filter:       fmt.Sprintf("(&(objectClass=posixGroup)(memberUid=%s))", ldap.EscapeFilter(uid)),
+		Attributes:   []string{"cn"},
+	})
+	if err != nil {
+		groupSearchFailed.Inc()
+		if c.GroupFailurePolicy == GroupFailureOpen {
+			glog.Warningf("Error searching for the groups of %s, continuing without them: %v", entry.DN, err)
+			entry.Attributes = append(entry.Attributes, &ldap.EntryAttribute{Name: GroupsIncompleteAttribute, Values: []string{"true"}})
+			return nil
+		}
+		return fmt.Errorf("Error searching for the groups of %s: %w", entry.DN, err)
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+
+	var memberOf *ldap.EntryAttribute
+	for _, attr := range entry.Attributes {
+		if attr.Name == "memberOf" {
+			memberOf = attr
+		}
+	}
+	if memberOf == nil {
+		memberOf = &ldap.EntryAttribute{Name: "memberOf"}
+		entry.Attributes = append(entry.Attributes, memberOf)
+	}
+	for _, group := range groups {
+		memberOf.Values = append(memberOf.Values, group.DN)
+	}
+	return nil
+}
+
+// searchGroups runs the group search req, in pages of GroupPageSize if
+// set. If the server rejects the paging cookie part way through, the
+// search is restarted from the first page up to GroupPageRestarts
+// times, so that a partial set of groups is never returned.
+func (c *Client) searchGroups(conn *ldap.Conn, req *ldap.SearchRequest) ([]*ldap.Entry, error) {
+	if c.GroupPageSize == 0 {
+		res, err := conn.Search(req)
+		if err != nil {
+			return nil, err
+		}
+		return res.Entries, nil
+	}
+
+	for restarts := 0; ; restarts++ {
+		entries, err := c.searchGroupPages(conn, req)
+		if !errors.Is(err, ErrPagingCookieInvalid) {
+			return entries, err
+		}
+		if restarts >= c.GroupPageRestarts {
+			return nil, fmt.Errorf("%w after %d restarts", err, restarts)
+		}
+		groupSearchRestarts.Inc()
+		glog.Warningf("Restarting the group search under %s: %v", req.BaseDN, err)
+	}
+}
+
+// searchGroupPages runs req page by page with the paged results control
+// (RFC 2696). A continuation page failing is reported as
+// ErrPagingCookieInvalid.
+func (c *Client) searchGroupPages(conn *ldap.Conn, req *ldap.SearchRequest) ([]*ldap.Entry, error) {
+	paging := ldap.NewControlPaging(c.GroupPageSize)
+	pageReq := *req
+	pageReq.Controls = append(append([]ldap.Control(nil), req.Controls...), paging)
+
+	var entries []*ldap.Entry
+	for {
+		res, err := conn.Search(&pageReq)
+		if err != nil {
+			if len(paging.Cookie) > 0 && isPagingCookieError(err) {
+				return nil, fmt.Errorf("%w: %v", ErrPagingCookieInvalid, err)
+			}
+			return nil, err
+		}
+		entries = append(entries, res.Entries...)
+
+		control, ok := ldap.FindControl(res.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+		if !ok || len(control.Cookie) == 0 {
+			return entries, nil
+		}
+		paging.SetCookie(control.Cookie)
+	}
+}
+
+// isPagingCookieError reports whether err is how servers reject a stale
+// or unknown paging cookie: OpenLDAP with a protocol error, Active
+// Directory with unwillingToPerform and 389 DS with an operations error.
+func isPagingCookieError(err error) bool {
+	return ldap.IsErrorAnyOf(err, ldap.LDAPResultProtocolError, ldap.LDAPResultUnwillingToPerform, ldap.LDAPResultOperationsError)
+}
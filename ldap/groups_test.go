@@ -0,0 +1,331 @@
+package ldap
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// newPosixGroupDirectory is a directory where groups list their members'
+// uids in memberUid, and users have no memberOf attribute.
+func newPosixGroupDirectory() *fakeDirectory {
+	group := func(cn string, members ...string) *ldap.Entry {
+		return ldap.NewEntry("cn="+cn+",ou=groups,dc=example,dc=com", map[string][]string{
+			"objectClass": {"posixGroup"},
+			"cn":          {cn},
+			"memberUid":   members,
+		})
+	}
+	return &fakeDirectory{
+		passwords: map[string]string{
+			"cn=search,dc=example,dc=com":           "search-password",
+			"uid=alice,ou=people,dc=example,dc=com": "alice-password",
+			"uid=carol,ou=people,dc=example,dc=com": "carol-password",
+		},
+		entries: []*ldap.Entry{
+			ldap.NewEntry("uid=alice,ou=people,dc=example,dc=com", map[string][]string{
+				"uid":   {"alice"},
+				"login": {"asmith"},
+			}),
+			ldap.NewEntry("uid=carol,ou=people,dc=example,dc=com", map[string][]string{
+				"uid": {"carol"},
+			}),
+			group("developers", "alice", "bob"),
+			group("admins", "bob"),
+			group("ops", "alice"),
+			group("legacy", "asmith"),
+			// Not a posixGroup, so not a group of alice's.
+			ldap.NewEntry("cn=alice-mail,ou=lists,dc=example,dc=com", map[string][]string{
+				"objectClass": {"groupOfNames"},
+				"memberUid":   {"alice"},
+			}),
+		},
+	}
+}
+
+func TestMemberUIDGroups(t *testing.T) {
+	cases := []struct {
+		name             string
+		username         string
+		membership       string
+		uidAttribute     string
+		groupBaseDN      string
+		searchScope      string
+		expectedMemberOf []string
+		expectedBaseDN   string
+		expectedScope    int
+	}{
+		{
+			name:             "memberUid",
+			username:         "alice",
+			membership:       MembershipMemberUID,
+			expectedMemberOf: []string{"cn=developers,ou=groups,dc=example,dc=com", "cn=ops,ou=groups,dc=example,dc=com"},
+			expectedBaseDN:   "dc=example,dc=com",
+			expectedScope:    ldap.ScopeWholeSubtree,
+		},
+		{
+			name:             "custom uid attribute",
+			username:         "alice",
+			membership:       MembershipMemberUID,
+			uidAttribute:     "login",
+			expectedMemberOf: []string{"cn=legacy,ou=groups,dc=example,dc=com"},
+			expectedBaseDN:   "dc=example,dc=com",
+			expectedScope:    ldap.ScopeWholeSubtree,
+		},
+		{
+			name:             "group base DN",
+			username:         "alice",
+			membership:       MembershipMemberUID,
+			groupBaseDN:      "ou=groups,dc=example,dc=com",
+			expectedMemberOf: []string{"cn=developers,ou=groups,dc=example,dc=com", "cn=ops,ou=groups,dc=example,dc=com"},
+			expectedBaseDN:   "ou=groups,dc=example,dc=com",
+			expectedScope:    ldap.ScopeWholeSubtree,
+		},
+		{
+			name:             "group search scope",
+			username:         "alice",
+			membership:       MembershipMemberUID,
+			groupBaseDN:      "ou=groups,dc=example,dc=com",
+			searchScope:      "one",
+			expectedMemberOf: []string{"cn=developers,ou=groups,dc=example,dc=com", "cn=ops,ou=groups,dc=example,dc=com"},
+			expectedBaseDN:   "ou=groups,dc=example,dc=com",
+			expectedScope:    ldap.ScopeSingleLevel,
+		},
+		{
+			name:             "user in no groups",
+			username:         "carol",
+			membership:       MembershipMemberUID,
+			expectedMemberOf: []string{},
+			expectedBaseDN:   "dc=example,dc=com",
+			expectedScope:    ldap.ScopeWholeSubtree,
+		},
+		{
+			name:             "memberOf doesn't search for groups",
+			username:         "alice",
+			expectedMemberOf: []string{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fs := newFakeServer(t)
+			defer fs.Close()
+			newPosixGroupDirectory().attach(fs)
+
+			client := fs.client()
+			client.GroupMembership = c.membership
+			client.GroupUIDAttribute = c.uidAttribute
+			client.GroupBaseDN = c.groupBaseDN
+			client.GroupSearchScope = c.searchScope
+			client.SearchUserDN = "cn=search,dc=example,dc=com"
+			client.SearchUserPassword = "search-password"
+
+			entry, err := client.Authenticate(c.username, c.username+"-password")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if memberOf := entry.GetAttributeValues("memberOf"); !reflect.DeepEqual(memberOf, c.expectedMemberOf) {
+				t.Errorf("expected memberOf %v, got %v", c.expectedMemberOf, memberOf)
+			}
+
+			searches := fs.searchRequests()
+			if c.expectedBaseDN == "" {
+				if len(searches) != 1 {
+					t.Errorf("expected only the user search, got %d searches", len(searches))
+				}
+				return
+			}
+			if len(searches) != 2 {
+				t.Fatalf("expected a user and a group search, got %d searches", len(searches))
+			}
+			if searches[1].BaseDN != c.expectedBaseDN {
+				t.Errorf("expected the groups to be searched under %q, got %q", c.expectedBaseDN, searches[1].BaseDN)
+			}
+			if searches[1].Scope != c.expectedScope {
+				t.Errorf("expected group search scope %d, got %d", c.expectedScope, searches[1].Scope)
+			}
+		})
+	}
+}
+
+// pagedSearchHook serves the directory's matches in pages of the size
+// requested by the paging control, with the offset of the next page as
+// the cookie. rejectCookie decides whether a continuation page's cookie
+// is rejected.
+func pagedSearchHook(t *testing.T, d *fakeDirectory, rejectCookie func() bool) func(fakeSearch) ([]*ldap.Entry, fakeResult) {
+	return func(req fakeSearch) ([]*ldap.Entry, fakeResult) {
+		matches, result := d.searchHook(req)
+		var paging *ldap.ControlPaging
+		for _, packet := range req.Controls {
+			control, err := ldap.DecodeControl(packet)
+			if err != nil {
+				t.Errorf("decoding control: %v", err)
+			}
+			if p, ok := control.(*ldap.ControlPaging); ok {
+				paging = p
+			}
+		}
+		if paging == nil {
+			return matches, result
+		}
+
+		offset := 0
+		if len(paging.Cookie) > 0 {
+			if rejectCookie() {
+				return nil, fakeResult{code: ldap.LDAPResultUnwillingToPerform, diag: "paged results cookie is invalid"}
+			}
+			offset, _ = strconv.Atoi(string(paging.Cookie))
+		}
+		end := offset + int(paging.PagingSize)
+		next := ldap.NewControlPaging(paging.PagingSize)
+		if end < len(matches) {
+			next.SetCookie([]byte(strconv.Itoa(end)))
+		} else {
+			end = len(matches)
+		}
+		result.controls = []*ber.Packet{next.Encode()}
+		return matches[offset:end], result
+	}
+}
+
+func TestPagedGroupSearch(t *testing.T) {
+	allGroups := []string{"cn=developers,ou=groups,dc=example,dc=com", "cn=ops,ou=groups,dc=example,dc=com"}
+	cases := []struct {
+		name             string
+		rejections       int
+		restarts         int
+		expectedMemberOf []string
+		expectedSearches int
+		expectErr        bool
+	}{
+		{
+			name:             "uninterrupted",
+			expectedMemberOf: allGroups,
+			// The user search, then a page per group.
+			expectedSearches: 3,
+		},
+		{
+			name:             "cookie rejected then restarted",
+			rejections:       1,
+			restarts:         2,
+			expectedMemberOf: allGroups,
+			expectedSearches: 5,
+		},
+		{
+			name:             "cookie rejected more often than restarts allow",
+			rejections:       3,
+			restarts:         2,
+			expectErr:        true,
+			expectedSearches: 7,
+		},
+		{
+			name:             "no restarts",
+			rejections:       1,
+			expectErr:        true,
+			expectedSearches: 3,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fs := newFakeServer(t)
+			defer fs.Close()
+			d := newPosixGroupDirectory()
+			d.attach(fs)
+			rejections := c.rejections
+			fs.search = pagedSearchHook(t, d, func() bool {
+				rejections--
+				return rejections >= 0
+			})
+
+			client := fs.client()
+			client.GroupMembership = MembershipMemberUID
+			client.GroupPageSize = 1
+			client.GroupPageRestarts = c.restarts
+			client.SearchUserDN = "cn=search,dc=example,dc=com"
+			client.SearchUserPassword = "search-password"
+
+			entry, err := client.Authenticate("alice", "alice-password")
+			if c.expectErr {
+				if !errors.Is(err, ErrPagingCookieInvalid) {
+					t.Errorf("expected ErrPagingCookieInvalid, got %v", err)
+				}
+				if entry != nil {
+					t.Errorf("expected no entry, got %v", entry)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if memberOf := entry.GetAttributeValues("memberOf"); !reflect.DeepEqual(memberOf, c.expectedMemberOf) {
+				t.Errorf("expected memberOf %v, got %v", c.expectedMemberOf, memberOf)
+			}
+			if searches := len(fs.searchRequests()); searches != c.expectedSearches {
+				t.Errorf("expected %d searches, got %d", c.expectedSearches, searches)
+			}
+		})
+	}
+}
+
+func TestGroupFailurePolicy(t *testing.T) {
+	cases := []struct {
+		name             string
+		policy           string
+		expectErr        bool
+		expectedMemberOf []string
+	}{
+		{name: "default", expectErr: true},
+		{name: "fail-closed", policy: GroupFailureClosed, expectErr: true},
+		{
+			name:   "fail-open",
+			policy: GroupFailureOpen,
+			// Only the groups already in memberOf are kept.
+			expectedMemberOf: []string{"cn=mail,ou=lists,dc=example,dc=com"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fs := newFakeServer(t)
+			defer fs.Close()
+			d := newPosixGroupDirectory()
+			d.entries[0].Attributes = append(d.entries[0].Attributes, &ldap.EntryAttribute{Name: "memberOf", Values: []string{"cn=mail,ou=lists,dc=example,dc=com"}})
+			d.attach(fs)
+			fs.search = func(req fakeSearch) ([]*ldap.Entry, fakeResult) {
+				if strings.Contains(req.Filter, "posixGroup") {
+					return nil, fakeResult{code: ldap.LDAPResultTimeLimitExceeded, diag: "time limit exceeded"}
+				}
+				return d.searchHook(req)
+			}
+
+			client := fs.client()
+			client.GroupMembership = MembershipMemberUID
+			client.GroupFailurePolicy = c.policy
+			client.SearchUserDN = "cn=search,dc=example,dc=com"
+			client.SearchUserPassword = "search-password"
+
+			entry, err := client.Authenticate("alice", "alice-password")
+			if c.expectErr {
+				if !ldap.IsErrorWithCode(err, ldap.LDAPResultTimeLimitExceeded) {
+					t.Errorf("expected the group search error, got %v", err)
+				}
+				if entry != nil {
+					t.Errorf("expected no entry, got %v", entry)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if memberOf := entry.GetAttributeValues("memberOf"); !reflect.DeepEqual(memberOf, c.expectedMemberOf) {
+				t.Errorf("expected memberOf %v, got %v", c.expectedMemberOf, memberOf)
+			}
+			if incomplete := entry.GetAttributeValue(GroupsIncompleteAttribute); incomplete != "true" {
+				t.Errorf("expected the entry to be marked with incomplete groups, got %q", incomplete)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package ldap
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/go-ldap/ldap/v3/gssapi"
+	"github.com/jcmturner/gokrb5/v8/client"
+)
+
+// Kerberos binds the search user with SASL GSSAPI, for directories that
+// don't allow simple binds.
+type Kerberos struct {
+	// ServicePrincipal is the directory's service principal name.
+	// Defaults to ldap/<LdapServer>.
+	ServicePrincipal string
+
+	principal string
+	// newContext returns the GSSAPI client for a single bind. The
+	// security context is per bind, but the Kerberos client behind it,
+	// and the tickets it has, are shared.
+	newContext func() ldap.GSSAPIClient
+}
+
+// NewKerberosKeytab returns Kerberos credentials for principal, either
+// user@REALM or a bare user in the default realm of krb5Conf, whose keys
+// are read from keytab.
+func NewKerberosKeytab(principal, keytab, krb5Conf string) (*Kerberos, error) {
+	user, realm := principal, ""
+	if i := strings.LastIndex(principal, "@"); i >= 0 {
+		user, realm = principal[:i], principal[i+1:]
+	}
+	if user == "" {
+		return nil, fmt.Errorf("invalid Kerberos principal %q", principal)
+	}
+
+	// Active Directory doesn't support FAST pre-authentication.
+	krb, err := gssapi.NewClientWithKeytab(user, realm, keytab, krb5Conf, client.DisablePAFXFAST(true))
+	if err != nil {
+		return nil, fmt.Errorf("loading Kerberos credentials for %s: %v", principal, err)
+	}
+	return &Kerberos{
+		principal: principal,
+		newContext: func() ldap.GSSAPIClient {
+			return &gssapi.Client{Client: krb.Client}
+		},
+	}, nil
+}
+
+// bind binds conn as the Kerberos principal to the directory at server.
+func (k *Kerberos) bind(conn *ldap.Conn, server string) error {
+	spn := k.ServicePrincipal
+	if spn == "" {
+		spn = "ldap/" + server
+	}
+	if err := conn.GSSAPIBind(k.newContext(), spn, ""); err != nil {
+		return fmt.Errorf("GSSAPI bind as %s to %s: %w", k.principal, spn, err)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeGSSAPIClient plays the client side of a GSSAPI exchange with
+// saslBindHook: an AP-REQ answered by an AP-REP, then the security layer
+// negotiation.
+type fakeGSSAPIClient struct {
+	targets []string
+	deleted bool
+}
+
+func (f *fakeGSSAPIClient) InitSecContext(target string, token []byte) ([]byte, bool, error) {
+	f.targets = append(f.targets, target)
+	switch string(token) {
+	case "":
+		return []byte("ap-req"), true, nil
+	case "ap-rep":
+		return nil, false, nil
+	}
+	return nil, false, fmt.Errorf("unexpected token %q", token)
+}
+
+func (f *fakeGSSAPIClient) NegotiateSaslAuth(token []byte, authzid string) ([]byte, error) {
+	if string(token) != "layers" {
+		return nil, fmt.Errorf("unexpected token %q", token)
+	}
+	return []byte("layers-ack"), nil
+}
+
+func (f *fakeGSSAPIClient) DeleteSecContext() error {
+	f.deleted = true
+	return nil
+}
+
+// fakeKerberos returns Kerberos credentials whose binds use
+// fakeGSSAPIClients, and the clients it has handed out.
+func fakeKerberos() (*Kerberos, func() []*fakeGSSAPIClient) {
+	var mu sync.Mutex
+	var contexts []*fakeGSSAPIClient
+	k := &Kerberos{
+		principal: "svc-k8s@EXAMPLE.COM",
+		newContext: func() ldap.GSSAPIClient {
+			mu.Lock()
+			defer mu.Unlock()
+			ctx := &fakeGSSAPIClient{}
+			contexts = append(contexts, ctx)
+			return ctx
+		},
+	}
+	return k, func() []*fakeGSSAPIClient {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*fakeGSSAPIClient(nil), contexts...)
+	}
+}
+
+// saslBindHook answers the server side of fakeGSSAPIClient's exchange,
+// recording the mechanism of each step.
+func saslBindHook(mechanisms *[]string) func(string, []byte) (fakeResult, []byte) {
+	var mu sync.Mutex
+	return func(mechanism string, credentials []byte) (fakeResult, []byte) {
+		mu.Lock()
+		*mechanisms = append(*mechanisms, mechanism)
+		mu.Unlock()
+		inProgress := fakeResult{code: ldap.LDAPResultSaslBindInProgress}
+		switch string(credentials) {
+		case "ap-req":
+			return inProgress, []byte("ap-rep")
+		case "":
+			return inProgress, []byte("layers")
+		case "layers-ack":
+			return fakeResult{code: ldap.LDAPResultSuccess}, nil
+		}
+		return fakeResult{code: ldap.LDAPResultInvalidCredentials, diag: "bad token"}, nil
+	}
+}
+
+func TestKerberosSearchBind(t *testing.T) {
+	cases := []struct {
+		name             string
+		servicePrincipal string
+		expectedTarget   string
+	}{
+		{
+			name:           "default service principal",
+			expectedTarget: "ldap/127.0.0.1",
+		},
+		{
+			name:             "configured service principal",
+			servicePrincipal: "ldap/ldap.example.com@EXAMPLE.COM",
+			expectedTarget:   "ldap/ldap.example.com@EXAMPLE.COM",
+		},
+	}
+
+	for _, c := range cases {
+		fs := newFakeServer(t)
+		newTestDirectory().attach(fs)
+		var mechanisms []string
+		fs.saslBind = saslBindHook(&mechanisms)
+
+		client := fs.client()
+		// Kerberos takes the place of the search user's password.
+		client.SearchUserDN = "cn=search,dc=example,dc=com"
+		client.SearchUserPassword = "search-password"
+		kerberos, contexts := fakeKerberos()
+		kerberos.ServicePrincipal = c.servicePrincipal
+		client.Kerberos = kerberos
+
+		if _, err := client.Authenticate("alice", "alice-password"); err != nil {
+			t.Errorf("%s: Unexpected error: %v", c.name, err)
+		}
+		if expected := []string{"GSSAPI", "GSSAPI", "GSSAPI"}; !reflect.DeepEqual(mechanisms, expected) {
+			t.Errorf("%s: Expected SASL binds %v, got %v", c.name, expected, mechanisms)
+		}
+		// The user is still checked with a simple bind.
+		if expected := []string{"uid=alice,dc=example,dc=com"}; !reflect.DeepEqual(fs.boundDNs(), expected) {
+			t.Errorf("%s: Expected simple binds %v, got %v", c.name, expected, fs.boundDNs())
+		}
+		ctxs := contexts()
+		if len(ctxs) != 1 {
+			t.Fatalf("%s: Expected one GSSAPI context, got %d", c.name, len(ctxs))
+		}
+		if expected := []string{c.expectedTarget, c.expectedTarget}; !reflect.DeepEqual(ctxs[0].targets, expected) {
+			t.Errorf("%s: Expected targets %v, got %v", c.name, expected, ctxs[0].targets)
+		}
+		if !ctxs[0].deleted {
+			t.Errorf("%s: Expected the GSSAPI context to be deleted", c.name)
+		}
+		fs.Close()
+	}
+}
+
+func TestKerberosBindRejected(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	newTestDirectory().attach(fs)
+	fs.saslBind = func(string, []byte) (fakeResult, []byte) {
+		return fakeResult{code: ldap.LDAPResultInvalidCredentials, diag: "preauth failed"}, nil
+	}
+
+	client := fs.client()
+	client.Kerberos, _ = fakeKerberos()
+
+	_, err := client.Authenticate("alice", "alice-password")
+	if err == nil {
+		t.Fatalf("Expected an error")
+	}
+	if !strings.Contains(err.Error(), "svc-k8s@EXAMPLE.COM") {
+		t.Errorf("Expected the error to name the principal, got %v", err)
+	}
+	// The service account failing isn't the user's fault.
+	var credsErr *credentialsError
+	if errors.As(err, &credsErr) {
+		t.Errorf("Expected a search bind failure, got a credentials error: %v", err)
+	}
+	if len(fs.boundDNs()) != 0 {
+		t.Errorf("Expected no simple binds, got %v", fs.boundDNs())
+	}
+}
+
+func TestNewKerberosKeytabErrors(t *testing.T) {
+	cases := map[string]string{
+		"empty user":        "@EXAMPLE.COM",
+		"missing krb5.conf": "svc-k8s@EXAMPLE.COM",
+	}
+	for name, principal := range cases {
+		if _, err := NewKerberosKeytab(principal, "/nonexistent/krb5.keytab", "/nonexistent/krb5.conf"); err == nil {
+			t.Errorf("%s: Expected an error", name)
+		}
+	}
+}
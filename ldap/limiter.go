@@ -0,0 +1,74 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrTooManyBinds is returned, as an UnavailableError, when a login
+// can't get one of the MaxConcurrentBinds slots.
+var ErrTooManyBinds = errors.New("too many LDAP logins in progress")
+
+var bindLimitRejected = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "kubernetes_ldap_bind_limit_rejected",
+		Help: "Total number of logins refused because too many were already in progress or queued.",
+	},
+)
+
+// bindLimiter bounds the number of logins in flight against the
+// directory. Logins over the limit wait in a bounded queue.
+type bindLimiter struct {
+	once  sync.Once
+	slots chan struct{}
+
+	mu      sync.Mutex
+	waiting int
+}
+
+// acquire takes a slot, waiting up to timeout (forever if zero) for one
+// if all limit slots are taken and fewer than queueSize logins are
+// already waiting.
+func (l *bindLimiter) acquire(limit, queueSize int, timeout time.Duration) error {
+	l.once.Do(func() { l.slots = make(chan struct{}, limit) })
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	l.mu.Lock()
+	if l.waiting >= queueSize {
+		l.mu.Unlock()
+		return fmt.Errorf("%w: the queue is full", ErrTooManyBinds)
+	}
+	l.waiting++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.waiting--
+		l.mu.Unlock()
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-expired:
+		return fmt.Errorf("%w: timed out after %s in the queue", ErrTooManyBinds, timeout)
+	}
+}
+
+func (l *bindLimiter) release() {
+	<-l.slots
+}
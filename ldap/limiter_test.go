@@ -0,0 +1,114 @@
+package ldap
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// queued returns the number of logins waiting for a slot.
+func queued(c *Client) int {
+	c.limiter.mu.Lock()
+	defer c.limiter.mu.Unlock()
+	return c.limiter.waiting
+}
+
+func TestMaxConcurrentBinds(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	d := newTestDirectory()
+	d.attach(fs)
+
+	// User binds block until released, so logins pile up.
+	bound := make(chan struct{}, 10)
+	unblock := make(chan struct{})
+	fs.bind = func(dn, password string) fakeResult {
+		bound <- struct{}{}
+		<-unblock
+		return d.bindHook(dn, password)
+	}
+
+	client := fs.client()
+	client.MaxConcurrentBinds = 2
+	client.BindQueueSize = 1
+	client.BindQueueTimeout = 5 * time.Second
+
+	results := make(chan error, 3)
+	login := func() {
+		_, err := client.Authenticate("alice", "alice-password")
+		results <- err
+	}
+	for i := 0; i < 3; i++ {
+		go login()
+	}
+
+	// Two logins reach the directory and the third waits in the queue.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-bound:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %d logins to reach the directory", 2)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for queued(client) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the third login to be queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if len(bound) != 0 {
+		t.Fatalf("expected the queued login not to reach the directory")
+	}
+
+	// With the queue full, another login is refused right away.
+	if _, err := client.Authenticate("alice", "alice-password"); !errors.Is(err, ErrTooManyBinds) {
+		t.Errorf("expected ErrTooManyBinds with the queue full, got %v", err)
+	} else if _, ok := err.(*UnavailableError); !ok {
+		t.Errorf("expected an UnavailableError, got %T", err)
+	}
+
+	// Releasing the directory lets the queued login through.
+	close(unblock)
+	for i := 0; i < 3; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("expected the login to succeed, got %v", err)
+		}
+	}
+	if len(bound) != 1 {
+		t.Errorf("expected the queued login to bind once released, got %d binds", len(bound))
+	}
+}
+
+func TestBindQueueTimeout(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	d := newTestDirectory()
+	d.attach(fs)
+
+	bound := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	defer close(unblock)
+	fs.bind = func(dn, password string) fakeResult {
+		bound <- struct{}{}
+		<-unblock
+		return d.bindHook(dn, password)
+	}
+
+	client := fs.client()
+	client.MaxConcurrentBinds = 1
+	client.BindQueueSize = 1
+	client.BindQueueTimeout = 50 * time.Millisecond
+
+	go client.Authenticate("alice", "alice-password")
+	<-bound
+
+	start := time.Now()
+	_, err := client.Authenticate("alice", "alice-password")
+	if !errors.Is(err, ErrTooManyBinds) {
+		t.Fatalf("expected the queued login to time out, got %v", err)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("expected the login to wait for the queue timeout, waited %s", waited)
+	}
+}
@@ -0,0 +1,85 @@
+package ldap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/golang/glog"
+)
+
+// What happens when the user search matches more than one entry.
+const (
+	// MultipleMatchReject fails the login, as it can't be told which
+	// entry is the user's.
+	MultipleMatchReject = "reject"
+	// MultipleMatchTiebreak picks the entry with the lowest value of
+	// TiebreakAttribute, e.g. the lowest uidNumber.
+	MultipleMatchTiebreak = "tiebreak"
+)
+
+// tiebreakSizeLimit bounds the entries a user search returns with
+// MultipleMatchTiebreak. Beyond it the search fails.
+const tiebreakSizeLimit = 10
+
+// pickEntry returns the user's entry among the matches of the user
+// search, or an error if there is no single one under the client's
+// MultipleMatchPolicy.
+func (c *Client) pickEntry(username string, entries []*ldap.Entry) (*ldap.Entry, error) {
+	if len(entries) == 1 {
+		return entries[0], nil
+	}
+
+	multipleUsersFound.Inc()
+	dns := make([]string, len(entries))
+	for i, entry := range entries {
+		dns[i] = entry.DN
+	}
+	glog.Warningf("The user search for %s matched %d entries: %s", username, len(entries), strings.Join(dns, "; "))
+
+	if c.MultipleMatchPolicy != MultipleMatchTiebreak {
+		return nil, fmt.Errorf("Multiple entries found for user %s: %s", username, strings.Join(dns, "; "))
+	}
+
+	var picked *ldap.Entry
+	tied := false
+	for _, entry := range entries {
+		value := entry.GetAttributeValue(c.TiebreakAttribute)
+		if value == "" {
+			continue
+		}
+		if picked == nil {
+			picked = entry
+			continue
+		}
+		switch cmp := compareTiebreak(value, picked.GetAttributeValue(c.TiebreakAttribute)); {
+		case cmp < 0:
+			picked, tied = entry, false
+		case cmp == 0:
+			tied = true
+		}
+	}
+	if picked == nil || tied {
+		return nil, fmt.Errorf("Multiple entries found for user %s, and %s doesn't tell them apart: %s", username, c.TiebreakAttribute, strings.Join(dns, "; "))
+	}
+	glog.Warningf("Picked %s for %s by the lowest %s", picked.DN, username, c.TiebreakAttribute)
+	return picked, nil
+}
+
+// compareTiebreak compares two attribute values, as numbers if they both
+// are one.
+func compareTiebreak(a, b string) int {
+	x, errX := strconv.ParseInt(a, 10, 64)
+	y, errY := strconv.ParseInt(b, 10, 64)
+	if errX == nil && errY == nil {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,136 @@
+package ldap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// newDuplicateUserDirectory is a directory where two entries share the
+// uid alice, as after a botched migration.
+func newDuplicateUserDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		passwords: map[string]string{
+			"cn=search,dc=example,dc=com":             "search-password",
+			"uid=alice,ou=people,dc=example,dc=com":   "alice-password",
+			"uid=alice,ou=contract,dc=example,dc=com": "other-password",
+		},
+		entries: []*ldap.Entry{
+			ldap.NewEntry("uid=alice,ou=contract,dc=example,dc=com", map[string][]string{
+				"uid":       {"alice"},
+				"uidNumber": {"10001"},
+			}),
+			ldap.NewEntry("uid=alice,ou=people,dc=example,dc=com", map[string][]string{
+				"uid":       {"alice"},
+				"uidNumber": {"9001"},
+			}),
+		},
+	}
+}
+
+func TestMultipleUserMatches(t *testing.T) {
+	cases := []struct {
+		name        string
+		policy      string
+		tiebreak    string
+		password    string
+		expectedDN  string
+		expectedErr string
+	}{
+		{
+			name:        "rejected by default",
+			password:    "alice-password",
+			expectedErr: "Multiple entries found for user alice",
+		},
+		{
+			name:        "rejected",
+			policy:      MultipleMatchReject,
+			password:    "alice-password",
+			expectedErr: "Multiple entries found for user alice",
+		},
+		{
+			name:       "tiebreak by the lowest number",
+			policy:     MultipleMatchTiebreak,
+			tiebreak:   "uidNumber",
+			password:   "alice-password",
+			expectedDN: "uid=alice,ou=people,dc=example,dc=com",
+		},
+		{
+			// The picked entry's password is still checked.
+			name:        "tiebreak with the other entry's password",
+			policy:      MultipleMatchTiebreak,
+			tiebreak:    "uidNumber",
+			password:    "other-password",
+			expectedErr: "invalid credentials",
+		},
+		{
+			name:        "tiebreak attribute missing",
+			policy:      MultipleMatchTiebreak,
+			tiebreak:    "employeeNumber",
+			password:    "alice-password",
+			expectedErr: "employeeNumber doesn't tell them apart",
+		},
+		{
+			name:        "tiebreak attribute tied",
+			policy:      MultipleMatchTiebreak,
+			tiebreak:    "uid",
+			password:    "alice-password",
+			expectedErr: "uid doesn't tell them apart",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fs := newFakeServer(t)
+			defer fs.Close()
+			newDuplicateUserDirectory().attach(fs)
+
+			client := fs.client()
+			client.SearchUserDN = "cn=search,dc=example,dc=com"
+			client.SearchUserPassword = "search-password"
+			client.MultipleMatchPolicy = c.policy
+			client.TiebreakAttribute = c.tiebreak
+
+			entry, err := client.Authenticate("alice", c.password)
+			if c.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.expectedErr) {
+					t.Fatalf("expected an error containing %q, got %v", c.expectedErr, err)
+				}
+				// Both candidates are named for whoever cleans up the
+				// directory.
+				if strings.Contains(c.expectedErr, "Multiple") && !strings.Contains(err.Error(), "ou=contract") {
+					t.Errorf("expected the error to list the entries, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if entry.DN != c.expectedDN {
+				t.Errorf("expected entry %q, got %q", c.expectedDN, entry.DN)
+			}
+			if expected := []string{"cn=search,dc=example,dc=com", c.expectedDN}; !reflect.DeepEqual(fs.boundDNs(), expected) {
+				t.Errorf("expected binds %v, got %v", expected, fs.boundDNs())
+			}
+		})
+	}
+}
+
+func TestCompareTiebreak(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"9", "10", -1},
+		{"10", "10", 0},
+		{"20210101000000Z", "20190101000000Z", 1},
+		{"abc", "abd", -1},
+	}
+	for _, c := range cases {
+		if cmp := compareTiebreak(c.a, c.b); cmp != c.expected {
+			t.Errorf("compareTiebreak(%q, %q): expected %d, got %d", c.a, c.b, c.expected, cmp)
+		}
+	}
+}
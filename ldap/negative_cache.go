@@ -0,0 +1,127 @@
+package ldap
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// defaultNegativeCacheSize bounds the negative cache when NegativeCacheSize
+// isn't set.
+const defaultNegativeCacheSize = 1000
+
+// credentialsError marks an Authenticate error caused by the user's own
+// credentials, i.e. an unknown user or a wrong password, as opposed to
+// the directory or the search user failing. Only those are cached.
+type credentialsError struct {
+	err error
+}
+
+func (e *credentialsError) Error() string {
+	return e.err.Error()
+}
+
+func (e *credentialsError) Unwrap() error {
+	return e.err
+}
+
+// negativeCache remembers recently failed credentials, so that retrying
+// the same bad password fails without a round trip to the directory.
+// Entries are keyed by an HMAC of the username and password with a key
+// generated at startup, so no password hash is kept that could be
+// checked offline.
+type negativeCache struct {
+	mu      sync.Mutex
+	secret  []byte
+	entries map[[sha256.Size]byte]time.Time
+	// now is overridden by tests.
+	now func() time.Time
+}
+
+func (nc *negativeCache) key(username, password string) [sha256.Size]byte {
+	mac := hmac.New(sha256.New, nc.secret)
+	mac.Write([]byte(username))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	var key [sha256.Size]byte
+	copy(key[:], mac.Sum(nil))
+	return key
+}
+
+// contains reports whether the credentials failed less than the cache's
+// TTL ago.
+func (nc *negativeCache) contains(username, password string) bool {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	if nc.entries == nil {
+		return false
+	}
+	key := nc.key(username, password)
+	expiry, ok := nc.entries[key]
+	if !ok {
+		return false
+	}
+	if !nc.clock().Before(expiry) {
+		delete(nc.entries, key)
+		return false
+	}
+	return true
+}
+
+// add records failed credentials for ttl. When the cache holds maxSize
+// entries, expired ones are dropped and, failing that, the one closest
+// to expiring.
+func (nc *negativeCache) add(username, password string, ttl time.Duration, maxSize int) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	if nc.entries == nil {
+		nc.secret = make([]byte, 32)
+		if _, err := rand.Read(nc.secret); err != nil {
+			// Without a secret the cache could leak password hashes,
+			// so don't cache at all.
+			return
+		}
+		nc.entries = make(map[[sha256.Size]byte]time.Time)
+	}
+
+	now := nc.clock()
+	key := nc.key(username, password)
+	if _, ok := nc.entries[key]; !ok && len(nc.entries) >= maxSize {
+		var oldestKey [sha256.Size]byte
+		var oldest time.Time
+		for k, expiry := range nc.entries {
+			if !now.Before(expiry) {
+				delete(nc.entries, k)
+				continue
+			}
+			if oldest.IsZero() || expiry.Before(oldest) {
+				oldestKey, oldest = k, expiry
+			}
+		}
+		if len(nc.entries) >= maxSize {
+			delete(nc.entries, oldestKey)
+		}
+	}
+	nc.entries[key] = now.Add(ttl)
+}
+
+// remove forgets failed credentials, once they have become valid.
+func (nc *negativeCache) remove(username, password string) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	if nc.entries != nil {
+		delete(nc.entries, nc.key(username, password))
+	}
+}
+
+func (nc *negativeCache) clock() time.Time {
+	if nc.now != nil {
+		return nc.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,144 @@
+package ldap
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestNegativeCache(t *testing.T) {
+	cases := []struct {
+		name         string
+		searchUserDN string
+	}{
+		{name: "direct bind as the user"},
+		{name: "search then bind", searchUserDN: "cn=search,dc=example,dc=com"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fs := newFakeServer(t)
+			defer fs.Close()
+			newTestDirectory().attach(fs)
+
+			client := fs.client()
+			client.SearchUserDN = c.searchUserDN
+			client.SearchUserPassword = "search-password"
+			client.NegativeCacheTTL = time.Minute
+			now := time.Now()
+			client.negative.now = func() time.Time { return now }
+
+			if _, err := client.Authenticate("alice", "wrong-password"); err == nil {
+				t.Fatalf("expected a wrong password to be rejected")
+			}
+			binds := len(fs.boundDNs())
+
+			// Retrying the same bad password fails without a bind.
+			if _, err := client.Authenticate("alice", "wrong-password"); err == nil {
+				t.Fatalf("expected the cached failure to be returned")
+			}
+			if n := len(fs.boundDNs()); n != binds {
+				t.Errorf("expected no binds for a cached failure, got %d", n-binds)
+			}
+
+			// The right password isn't affected.
+			entry, err := client.Authenticate("alice", "alice-password")
+			if err != nil {
+				t.Fatalf("expected the correct password to authenticate: %v", err)
+			}
+			if entry.DN != "uid=alice,dc=example,dc=com" {
+				t.Errorf("unexpected entry %q", entry.DN)
+			}
+
+			// Once the TTL has passed, the directory is asked again.
+			now = now.Add(time.Minute)
+			binds = len(fs.boundDNs())
+			if _, err := client.Authenticate("alice", "wrong-password"); err == nil {
+				t.Fatalf("expected a wrong password to be rejected")
+			}
+			if n := len(fs.boundDNs()); n == binds {
+				t.Errorf("expected the directory to be queried after the TTL")
+			}
+		})
+	}
+}
+
+func TestNegativeCacheUnknownUser(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	newTestDirectory().attach(fs)
+
+	client := fs.client()
+	client.SearchUserDN = "cn=search,dc=example,dc=com"
+	client.SearchUserPassword = "search-password"
+	client.NegativeCacheTTL = time.Minute
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.Authenticate("mallory", "password"); err == nil {
+			t.Fatalf("expected an unknown user to be rejected")
+		}
+	}
+	if n := len(fs.searchRequests()); n != 1 {
+		t.Errorf("expected one search for the unknown user, got %d", n)
+	}
+}
+
+func TestNegativeCacheIgnoresSearchUserFailures(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	dir := newTestDirectory()
+	dir.attach(fs)
+
+	// The search user's password is wrong, which says nothing about the
+	// user's.
+	client := fs.client()
+	client.SearchUserDN = "cn=search,dc=example,dc=com"
+	client.SearchUserPassword = "stale-password"
+	client.NegativeCacheTTL = time.Minute
+
+	if _, err := client.Authenticate("alice", "alice-password"); err == nil {
+		t.Fatalf("expected the login to fail with the wrong search password")
+	}
+	client.SearchUserPassword = "search-password"
+	if _, err := client.Authenticate("alice", "alice-password"); err != nil {
+		t.Errorf("expected the login to succeed once the search password is fixed: %v", err)
+	}
+
+	// Nor is a directory error.
+	fs.search = func(req fakeSearch) ([]*ldap.Entry, fakeResult) {
+		return nil, fakeResult{code: ldap.LDAPResultBusy}
+	}
+	if _, err := client.Authenticate("alice", "alice-password"); err == nil {
+		t.Fatalf("expected the login to fail while the directory is busy")
+	}
+	fs.search = dir.searchHook
+	if _, err := client.Authenticate("alice", "alice-password"); err != nil {
+		t.Errorf("expected the login to succeed once the directory recovers: %v", err)
+	}
+}
+
+func TestNegativeCacheSize(t *testing.T) {
+	nc := &negativeCache{}
+	now := time.Now()
+	nc.now = func() time.Time { return now }
+
+	for i := 0; i < 5; i++ {
+		nc.add(fmt.Sprintf("user%d", i), "password", time.Minute+time.Duration(i)*time.Second, 3)
+	}
+	if n := len(nc.entries); n != 3 {
+		t.Fatalf("expected the cache to be bounded to 3 entries, got %d", n)
+	}
+	// The entries closest to expiring were dropped first.
+	for i := 0; i < 5; i++ {
+		if cached := nc.contains(fmt.Sprintf("user%d", i), "password"); cached != (i >= 2) {
+			t.Errorf("user%d: expected cached to be %t, got %t", i, i >= 2, cached)
+		}
+	}
+
+	nc.remove("user4", "password")
+	if nc.contains("user4", "password") {
+		t.Errorf("expected a removed entry not to be cached")
+	}
+}
@@ -0,0 +1,211 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Password change methods.
+const (
+	// PasswordChangeExtendedOp uses the RFC 3062 password modify
+	// extended operation.
+	PasswordChangeExtendedOp = "exop"
+	// PasswordChangeModify replaces the userPassword attribute with an
+	// LDAP modify.
+	PasswordChangeModify = "modify"
+)
+
+// PasswordChanger changes a user's password in the directory.
+type PasswordChanger interface {
+	ChangePassword(username, oldPassword, newPassword string) error
+}
+
+// AuthenticationError is returned by ChangePassword when the user's
+// current password couldn't be verified.
+type AuthenticationError struct {
+	Err error
+}
+
+func (e *AuthenticationError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying authentication error.
+func (e *AuthenticationError) Unwrap() error {
+	return e.Err
+}
+
+// DirectoryError is returned by ChangePassword when the directory failed
+// while verifying the user's current password, e.g. because the search
+// user couldn't bind or the user search failed, rather than rejecting
+// the password.
+type DirectoryError struct {
+	Err error
+}
+
+func (e *DirectoryError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying directory error.
+func (e *DirectoryError) Unwrap() error {
+	return e.Err
+}
+
+// PasswordPolicyError is returned by ChangePassword when the directory
+// rejects the new password, e.g. because it is too short or was used
+// before. Message is the server's diagnostic message.
+type PasswordPolicyError struct {
+	Message string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return fmt.Sprintf("new password rejected by the directory: %s", e.Message)
+}
+
+var readOnlyRetries = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "kubernetes_ldap_read_only_password_changes",
+		Help: "Total number of password changes refused by a read-only LDAP server and retried on the writable server.",
+	},
+)
+
+// ErrReadOnly is wrapped in the UnavailableError returned by
+// ChangePassword when the directory refuses writes because it is
+// read-only, and no WritableServer takes them instead.
+var ErrReadOnly = errors.New("the directory is read-only")
+
+// ChangePassword verifies the user's current password by binding as
+// them, then sets the new password using PasswordChangeMethod. If the
+// server is read-only, the change is retried on WritableServer.
+func (c *Client) ChangePassword(username, oldPassword, newPassword string) error {
+	if oldPassword == "" || newPassword == "" {
+		return &AuthenticationError{Err: fmt.Errorf("Error changing password for user %s: empty password", username)}
+	}
+	if err := c.checkCredentials(username, oldPassword); err != nil {
+		return &AuthenticationError{Err: fmt.Errorf("Error changing password for user %q: %w", username, err)}
+	}
+	if c.hasControlCharacters(newPassword) {
+		return &PasswordPolicyError{Message: "the new password contains control characters"}
+	}
+
+	conn, err := c.getConn()
+	if err != nil {
+		ldapConnectionError.Inc()
+		return &UnavailableError{Err: err}
+	}
+	err = c.changePassword(conn, username, oldPassword, newPassword)
+	c.releaseConn(conn, err)
+
+	if isReadOnlyError(err) {
+		if c.WritableServer == "" {
+			return &UnavailableError{Err: fmt.Errorf("%w: %v", ErrReadOnly, err)}
+		}
+		glog.Warningf("LDAP server %s is read-only, changing the password of user %s on %s", c.LdapServer, username, c.WritableServer)
+		readOnlyRetries.Inc()
+		err = c.changePasswordOnWritable(username, oldPassword, newPassword)
+		if isReadOnlyError(err) {
+			return &UnavailableError{Err: fmt.Errorf("%w: %v", ErrReadOnly, err)}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	// The new password may have been tried, and cached as wrong, before.
+	c.negative.remove(username, newPassword)
+	return nil
+}
+
+// changePasswordOnWritable makes the change on a connection of its own
+// to WritableServer, which isn't pooled.
+func (c *Client) changePasswordOnWritable(username, oldPassword, newPassword string) error {
+	port := c.WritablePort
+	if port == 0 {
+		port = c.LdapPort
+	}
+	conn, err := c.dialServer(c.WritableServer, port)
+	if err != nil {
+		ldapConnectionError.Inc()
+		return &UnavailableError{Err: err}
+	}
+	defer conn.Close()
+	return c.changePassword(conn, username, oldPassword, newPassword)
+}
+
+// changePassword binds conn as the user and makes the change. Read-only
+// refusals are returned as is, for isReadOnlyError.
+func (c *Client) changePassword(conn *ldap.Conn, username, oldPassword, newPassword string) error {
+	entry, err := c.authenticate(conn, username, oldPassword)
+	var mustChange *PasswordMustChangeError
+	if errors.As(err, &mustChange) && entry != nil {
+		// Changing the password is exactly what the user needs to do.
+		err = nil
+	}
+	var credsErr *credentialsError
+	if errors.As(err, &credsErr) || errors.As(err, &mustChange) {
+		return &AuthenticationError{Err: err}
+	}
+	if err != nil {
+		return &DirectoryError{Err: err}
+	}
+
+	// conn is now bound as the user, so the change is made with their
+	// own rights and subject to the directory's password policy.
+	switch c.PasswordChangeMethod {
+	case "", PasswordChangeExtendedOp:
+		_, err = conn.PasswordModify(ldap.NewPasswordModifyRequest(entry.DN, oldPassword, newPassword))
+	case PasswordChangeModify:
+		req := ldap.NewModifyRequest(entry.DN, nil)
+		req.Replace("userPassword", []string{newPassword})
+		err = conn.Modify(req)
+	default:
+		return fmt.Errorf("unknown password change method %q", c.PasswordChangeMethod)
+	}
+
+	if isReadOnlyError(err) {
+		return err
+	}
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultConstraintViolation) || ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform) {
+		return &PasswordPolicyError{Message: err.(*ldap.Error).Err.Error()}
+	}
+	if err != nil {
+		return fmt.Errorf("Error changing password for user %s: %w", username, err)
+	}
+	return nil
+}
+
+// readOnlyMessages are found in the diagnostic messages of servers
+// refusing writes with unwillingToPerform because they are read-only:
+// 389 Directory Server and OpenLDAP consumers without an update referral.
+var readOnlyMessages = []string{"read-only", "read only", "readonly", "shadow context"}
+
+// isReadOnlyError reports whether err is the server refusing a write
+// because it is read-only: a referral to the server taking writes, or
+// unwillingToPerform saying so. Other unwillingToPerform results are
+// policy rejections.
+func isReadOnlyError(err error) bool {
+	var ldapErr *ldap.Error
+	if !errors.As(err, &ldapErr) {
+		return false
+	}
+	switch ldapErr.ResultCode {
+	case ldap.LDAPResultReferral:
+		return true
+	case ldap.LDAPResultUnwillingToPerform:
+		if ldapErr.Err == nil {
+			return false
+		}
+		message := strings.ToLower(ldapErr.Err.Error())
+		for _, m := range readOnlyMessages {
+			if strings.Contains(message, m) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package ldap
+
+import (
+	"strconv"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// PasswordExpiresInAttribute is set on the entries Authenticate returns
+// to the seconds left before the user's password expires, when the
+// password policy control warns of it. Like ServerAttribute, the
+// directory can't set it.
+const PasswordExpiresInAttribute = "kubernetes-ldap:passwordExpiresIn"
+
+// GraceLoginsAttribute is set on the entries Authenticate returns to the
+// number of logins left with the user's expired password, when the bind
+// only succeeded as one of the grace logins of the password policy.
+const GraceLoginsAttribute = "kubernetes-ldap:graceLoginsRemaining"
+
+// addPasswordWarnings marks entry with the warnings of the password
+// policy control the user's bind was answered with, if any.
+func addPasswordWarnings(entry *ldap.Entry, control *ldap.ControlBeheraPasswordPolicy) {
+	if control == nil {
+		return
+	}
+	if control.Expire >= 0 {
+		entry.Attributes = append(entry.Attributes, &ldap.EntryAttribute{Name: PasswordExpiresInAttribute, Values: []string{strconv.FormatInt(control.Expire, 10)}})
+	}
+	if control.Grace >= 0 {
+		entry.Attributes = append(entry.Attributes, &ldap.EntryAttribute{Name: GraceLoginsAttribute, Values: []string{strconv.FormatInt(control.Grace, 10)}})
+	}
+}
@@ -0,0 +1,301 @@
+package ldap
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const passwordModifyOID = "1.3.6.1.4.1.4203.1.11.1"
+
+// passwordDirectory extends the test directory with password changes
+// made through the extended operation or a userPassword modify.
+func passwordDirectory(fs *fakeServer, policy func(newPassword string) fakeResult) *fakeDirectory {
+	d := newTestDirectory()
+	d.attach(fs)
+	accept := func(dn, newPassword string) fakeResult {
+		if policy != nil {
+			if result := policy(newPassword); result.code != ldap.LDAPResultSuccess {
+				return result
+			}
+		}
+		fs.mu.Lock()
+		d.passwords[dn] = newPassword
+		fs.mu.Unlock()
+		return fakeResult{code: ldap.LDAPResultSuccess}
+	}
+
+	fs.extended = func(name string, value []byte) (fakeResult, []byte) {
+		if name != passwordModifyOID {
+			return fakeResult{code: ldap.LDAPResultProtocolError}, nil
+		}
+		var dn, newPassword string
+		for _, field := range ber.DecodePacket(value).Children {
+			switch field.Tag {
+			case 0:
+				dn = field.Data.String()
+			case 2:
+				newPassword = field.Data.String()
+			}
+		}
+		return accept(dn, newPassword), nil
+	}
+	fs.modify = func(dn string, changes []*ber.Packet) fakeResult {
+		// change: SEQUENCE { operation, SEQUENCE { type, SET { values } } }
+		attr := changes[0].Children[1]
+		if attr.Children[0].Value.(string) != "userPassword" {
+			return fakeResult{code: ldap.LDAPResultUnwillingToPerform}
+		}
+		return accept(dn, attr.Children[1].Children[0].Value.(string))
+	}
+	return d
+}
+
+func TestChangePassword(t *testing.T) {
+	for _, method := range []string{"", PasswordChangeExtendedOp, PasswordChangeModify} {
+		t.Run("method "+method, func(t *testing.T) {
+			fs := newFakeServer(t)
+			defer fs.Close()
+			passwordDirectory(fs, nil)
+
+			client := fs.client()
+			client.SearchUserDN = "cn=search,dc=example,dc=com"
+			client.SearchUserPassword = "search-password"
+			client.PasswordChangeMethod = method
+
+			if err := client.ChangePassword("alice", "alice-password", "new-password"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// The current password was verified by binding as alice.
+			binds := fs.boundDNs()
+			if binds[len(binds)-1] != "uid=alice,dc=example,dc=com" {
+				t.Errorf("expected the change to be made bound as alice, binds were %v", binds)
+			}
+
+			if _, err := client.Authenticate("alice", "new-password"); err != nil {
+				t.Errorf("expected the new password to work: %v", err)
+			}
+			if _, err := client.Authenticate("alice", "alice-password"); err == nil {
+				t.Errorf("expected the old password to stop working")
+			}
+		})
+	}
+}
+
+func TestChangePasswordWrongOldPassword(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	changed := false
+	passwordDirectory(fs, nil)
+	extended := fs.extended
+	fs.extended = func(name string, value []byte) (fakeResult, []byte) {
+		changed = true
+		return extended(name, value)
+	}
+
+	client := fs.client()
+	client.SearchUserDN = "cn=search,dc=example,dc=com"
+	client.SearchUserPassword = "search-password"
+
+	err := client.ChangePassword("alice", "wrong-password", "new-password")
+	var authErr *AuthenticationError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("expected an AuthenticationError, got %v", err)
+	}
+	if strings.Contains(err.Error(), "wrong-password") || strings.Contains(err.Error(), "new-password") {
+		t.Errorf("error must not contain passwords: %v", err)
+	}
+	if changed {
+		t.Errorf("expected no password change to be attempted")
+	}
+}
+
+func TestChangePasswordSearchFailure(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	passwordDirectory(fs, nil)
+	fs.search = func(req fakeSearch) ([]*ldap.Entry, fakeResult) {
+		return nil, fakeResult{code: ldap.LDAPResultOperationsError, diag: "search backend failed"}
+	}
+
+	client := fs.client()
+	client.SearchUserDN = "cn=search,dc=example,dc=com"
+	client.SearchUserPassword = "search-password"
+
+	err := client.ChangePassword("alice", "alice-password", "new-password")
+	var dirErr *DirectoryError
+	if !errors.As(err, &dirErr) {
+		t.Fatalf("expected a DirectoryError, got %v", err)
+	}
+	var authErr *AuthenticationError
+	if errors.As(err, &authErr) {
+		t.Errorf("expected a failed search not to be taken for a wrong password")
+	}
+}
+
+func TestChangePasswordPolicyRejection(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	passwordDirectory(fs, func(newPassword string) fakeResult {
+		if len(newPassword) < 12 {
+			return fakeResult{code: ldap.LDAPResultConstraintViolation, diag: "Password fails quality checking policy"}
+		}
+		return fakeResult{code: ldap.LDAPResultSuccess}
+	})
+
+	client := fs.client()
+	client.SearchUserDN = "cn=search,dc=example,dc=com"
+	client.SearchUserPassword = "search-password"
+
+	err := client.ChangePassword("alice", "alice-password", "short")
+	var policyErr *PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("expected a PasswordPolicyError, got %v", err)
+	}
+	if policyErr.Message != "Password fails quality checking policy" {
+		t.Errorf("expected the server's message, got %q", policyErr.Message)
+	}
+
+	if _, err := client.Authenticate("alice", "alice-password"); err != nil {
+		t.Errorf("expected the old password to still work: %v", err)
+	}
+}
+
+func TestChangePasswordAfterReset(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	d := passwordDirectory(fs, nil)
+	fs.bind = func(dn, password string) fakeResult {
+		result := d.bindHook(dn, password)
+		if dn == "uid=alice,dc=example,dc=com" && password == "alice-password" {
+			result.controls = []*ber.Packet{fakePasswordPolicyControl(ldap.BeheraChangeAfterReset, -1, 0)}
+		}
+		return result
+	}
+
+	client := fs.client()
+	client.SearchUserDN = "cn=search,dc=example,dc=com"
+	client.SearchUserPassword = "search-password"
+	client.PasswordPolicy = true
+
+	var mustChange *PasswordMustChangeError
+	if _, err := client.Authenticate("alice", "alice-password"); !errors.As(err, &mustChange) {
+		t.Fatalf("expected a PasswordMustChangeError, got %v", err)
+	}
+
+	// The reset password is still good enough to change it.
+	if err := client.ChangePassword("alice", "alice-password", "new-password"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.Authenticate("alice", "new-password"); err != nil {
+		t.Errorf("expected the new password to work: %v", err)
+	}
+}
+
+// readOnly refuses every password change as a read-only server would.
+func readOnly(result fakeResult) func(string) fakeResult {
+	return func(string) fakeResult { return result }
+}
+
+func TestChangePasswordReadOnlyPrimary(t *testing.T) {
+	results := map[string]fakeResult{
+		"shadow context": {code: ldap.LDAPResultUnwillingToPerform, diag: "shadow context; no update referral"},
+		"read-only":      {code: ldap.LDAPResultUnwillingToPerform, diag: "Server is read-only"},
+		"referral":       {code: ldap.LDAPResultReferral},
+	}
+	for name, result := range results {
+		t.Run(name, func(t *testing.T) {
+			primary := newFakeServer(t)
+			defer primary.Close()
+			passwordDirectory(primary, readOnly(result))
+			writable := newFakeServer(t)
+			defer writable.Close()
+			passwordDirectory(writable, nil)
+
+			client := primary.client()
+			client.SearchUserDN = "cn=search,dc=example,dc=com"
+			client.SearchUserPassword = "search-password"
+			writableClient := writable.client()
+			client.WritableServer = writableClient.LdapServer
+			client.WritablePort = writableClient.LdapPort
+
+			if err := client.ChangePassword("alice", "alice-password", "new-password"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// The change was made on the writable server, bound as alice.
+			binds := writable.boundDNs()
+			if len(binds) == 0 || binds[len(binds)-1] != "uid=alice,dc=example,dc=com" {
+				t.Errorf("expected the change to be made on the writable server bound as alice, binds were %v", binds)
+			}
+			writableClient.SearchUserDN = client.SearchUserDN
+			writableClient.SearchUserPassword = client.SearchUserPassword
+			if _, err := writableClient.Authenticate("alice", "new-password"); err != nil {
+				t.Errorf("expected the new password to work on the writable server: %v", err)
+			}
+
+			// Logins keep going to the read-only primary, which hasn't
+			// replicated the change in this test.
+			writableBinds := len(writable.boundDNs())
+			if _, err := client.Authenticate("alice", "alice-password"); err != nil {
+				t.Errorf("expected logins against the primary to keep working: %v", err)
+			}
+			if len(writable.boundDNs()) != writableBinds {
+				t.Errorf("expected logins not to use the writable server")
+			}
+		})
+	}
+}
+
+func TestChangePasswordReadOnlyWithoutWritableServer(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	passwordDirectory(fs, readOnly(fakeResult{code: ldap.LDAPResultUnwillingToPerform, diag: "shadow context; no update referral"}))
+
+	client := fs.client()
+	client.SearchUserDN = "cn=search,dc=example,dc=com"
+	client.SearchUserPassword = "search-password"
+
+	err := client.ChangePassword("alice", "alice-password", "new-password")
+	var unavailable *UnavailableError
+	if !errors.As(err, &unavailable) || !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected an UnavailableError wrapping ErrReadOnly, got %v", err)
+	}
+	var policyErr *PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		t.Errorf("expected a read-only server not to be reported as a policy rejection")
+	}
+}
+
+func TestChangePasswordUnwillingIsPolicy(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	passwordDirectory(fs, readOnly(fakeResult{code: ldap.LDAPResultUnwillingToPerform, diag: "0000052D: Constraint violation"}))
+	writableCalled := false
+	writable := newFakeServer(t)
+	defer writable.Close()
+	writable.bind = func(dn, password string) fakeResult {
+		writableCalled = true
+		return fakeResult{code: ldap.LDAPResultInvalidCredentials}
+	}
+
+	client := fs.client()
+	client.SearchUserDN = "cn=search,dc=example,dc=com"
+	client.SearchUserPassword = "search-password"
+	writableClient := writable.client()
+	client.WritableServer = writableClient.LdapServer
+	client.WritablePort = writableClient.LdapPort
+
+	err := client.ChangePassword("alice", "alice-password", "new-password")
+	var policyErr *PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("expected a PasswordPolicyError, got %v", err)
+	}
+	if writableCalled {
+		t.Errorf("expected a policy rejection not to be retried on the writable server")
+	}
+}
@@ -0,0 +1,237 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// idleConn is a pooled connection and when it was last returned.
+type idleConn struct {
+	conn     *ldap.Conn
+	lastUsed time.Time
+}
+
+// connPool holds idle LDAP connections for reuse between logins. Every
+// login rebinds, so a pooled connection carries no identity over.
+type connPool struct {
+	mu   sync.Mutex
+	idle []idleConn
+	// closed is set once the client is replaced, after which
+	// connections are closed instead of pooled.
+	closed bool
+	// now is overridden by tests.
+	now func() time.Time
+}
+
+// get returns the most recently used idle connection that is younger
+// than idleTimeout, closing any that are too old or already closed.
+func (p *connPool) get(idleTimeout time.Duration) *ldap.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.clock()
+	for len(p.idle) > 0 {
+		last := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+
+		if last.conn.IsClosing() {
+			continue
+		}
+		if idleTimeout > 0 && now.Sub(last.lastUsed) >= idleTimeout {
+			last.conn.Close()
+			continue
+		}
+		return last.conn
+	}
+	return nil
+}
+
+// put returns a connection to the pool, or closes it if the pool is full.
+func (p *connPool) put(conn *ldap.Conn, maxIdle int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed || len(p.idle) >= maxIdle || conn.IsClosing() {
+		conn.Close()
+		return
+	}
+	p.idle = append(p.idle, idleConn{conn: conn, lastUsed: p.clock()})
+}
+
+// close closes the idle connections, and those returned from now on.
+func (p *connPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for _, idle := range p.idle {
+		idle.conn.Close()
+	}
+	p.idle = nil
+}
+
+func (p *connPool) clock() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// Close closes the client's idle connections. The client can still be
+// used, e.g. by the logins in flight when it was replaced on a reload,
+// but will no longer pool connections, closing each once done with it.
+func (c *Client) Close() {
+	c.pool.close()
+}
+
+// getConn returns a pooled connection if one is available, or dials a
+// new one.
+func (c *Client) getConn() (*ldap.Conn, error) {
+	if c.MaxIdleConns > 0 {
+		if conn := c.pool.get(c.IdleTimeout); conn != nil {
+			return conn, nil
+		}
+	}
+	return c.dial()
+}
+
+// releaseConn pools the connection for reuse unless pooling is disabled
+// or the last operation on it failed at the network level.
+func (c *Client) releaseConn(conn *ldap.Conn, err error) {
+	if c.MaxIdleConns <= 0 || isNetworkError(err) {
+		conn.Close()
+		return
+	}
+	c.pool.put(conn, c.MaxIdleConns)
+}
+
+// isNetworkError reports whether err, or an error it wraps, is an LDAP
+// network error, after which the connection can't be trusted.
+func isNetworkError(err error) bool {
+	var ldapErr *ldap.Error
+	return errors.As(err, &ldapErr) && ldapErr.ResultCode == ldap.ErrorNetwork
+}
+
+// Create a new TCP connection to the LDAP server
+func (c *Client) dial() (*ldap.Conn, error) {
+	return c.dialServer(c.LdapServer, c.LdapPort)
+}
+
+// dialServer connects to the LDAP server at host and port, with the
+// client's TLS and socket settings.
+func (c *Client) dialServer(host string, port uint) (*ldap.Conn, error) {
+	address := net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+	if c.TLSConfig != nil && !c.UseInsecure {
+		conn, err := c.dialTCP(address)
+		if err != nil {
+			return nil, ldap.NewError(ldap.ErrorNetwork, err)
+		}
+		config := c.TLSConfig
+		if host != c.LdapServer {
+			// ServerName is usually set to LdapServer.
+			config = config.Clone()
+			config.ServerName = host
+		}
+		tlsConn, err := handshakeTLS(conn, host, config)
+		if err != nil {
+			conn.Close()
+			return nil, ldap.NewError(ldap.ErrorNetwork, err)
+		}
+		ldapConn := startConn(tlsConn, true)
+		c.servers.add(ldapConn, conn.RemoteAddr().String())
+		return ldapConn, nil
+	}
+
+	// This will send passwords in clear text (LDAP doesn't obfuscate password in any way),
+	// thus we use a flag to enable this mode
+	if c.UseInsecure {
+		if c.RequireTLS {
+			return nil, ErrPlaintextBind
+		}
+		conn, err := c.dialTCP(address)
+		if err != nil {
+			return nil, ldap.NewError(ldap.ErrorNetwork, err)
+		}
+		ldapConn := startConn(conn, false)
+		c.servers.add(ldapConn, conn.RemoteAddr().String())
+		return ldapConn, nil
+	}
+
+	// TLSConfig was not specified, and insecure flag not set
+	return nil, errors.New("The LDAP TLS Configuration was not set.")
+}
+
+// dialTCP connects to address with the client's socket options applied.
+func (c *Client) dialTCP(address string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   ldap.DefaultTimeout,
+		KeepAlive: c.TCPKeepAlive,
+	}
+	conn, err := dialer.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if err := c.configureTCPConn(tcpConn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// configureTCPConn applies DisableTCPNoDelay and the buffer sizes.
+func (c *Client) configureTCPConn(conn *net.TCPConn) error {
+	// Go sets TCP_NODELAY on every connection, so it only needs to be
+	// set when turning it off.
+	if c.DisableTCPNoDelay {
+		if err := conn.SetNoDelay(false); err != nil {
+			return err
+		}
+	}
+	if c.ReadBufferSize > 0 {
+		if err := conn.SetReadBuffer(c.ReadBufferSize); err != nil {
+			return err
+		}
+	}
+	if c.WriteBufferSize > 0 {
+		if err := conn.SetWriteBuffer(c.WriteBufferSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handshakeTLS runs the TLS handshake over conn, as tls.DialWithDialer
+// does: the server name defaults to the host dialed, and the handshake
+// is bounded by the dial timeout.
+func handshakeTLS(conn net.Conn, host string, config *tls.Config) (*tls.Conn, error) {
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := conn.SetDeadline(time.Now().Add(ldap.DefaultTimeout)); err != nil {
+		return nil, err
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func startConn(conn net.Conn, isTLS bool) *ldap.Conn {
+	ldapConn := ldap.NewConn(conn, isTLS)
+	ldapConn.Start()
+	return ldapConn
+}
@@ -0,0 +1,120 @@
+package ldap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnectionPoolIdleTimeout(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	newTestDirectory().attach(fs)
+
+	now := time.Now()
+	client := fs.client()
+	client.SearchUserDN = "cn=search,dc=example,dc=com"
+	client.SearchUserPassword = "search-password"
+	client.MaxIdleConns = 1
+	client.IdleTimeout = time.Minute
+	client.pool.now = func() time.Time { return now }
+
+	authenticate := func() {
+		if _, err := client.Authenticate("alice", "alice-password"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	authenticate()
+	authenticate()
+	if n := fs.connCount(); n != 1 {
+		t.Errorf("expected the idle connection to be reused, got %d connections", n)
+	}
+
+	// A failed login leaves the connection usable.
+	if _, err := client.Authenticate("alice", "wrong-password"); err == nil {
+		t.Fatalf("expected wrong password to be rejected")
+	}
+	authenticate()
+	if n := fs.connCount(); n != 1 {
+		t.Errorf("expected the connection to be reused after a failed login, got %d connections", n)
+	}
+
+	// The connection has now been idle for longer than the timeout, so
+	// the server may have dropped it: a new one must be dialed.
+	now = now.Add(time.Minute)
+	authenticate()
+	if n := fs.connCount(); n != 2 {
+		t.Errorf("expected a connection idle past the timeout not to be reused, got %d connections", n)
+	}
+
+	now = now.Add(59 * time.Second)
+	authenticate()
+	if n := fs.connCount(); n != 2 {
+		t.Errorf("expected a connection idle within the timeout to be reused, got %d connections", n)
+	}
+}
+
+func TestConnectionPoolDisabled(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	newTestDirectory().attach(fs)
+
+	client := fs.client()
+	for i := 0; i < 3; i++ {
+		if _, err := client.Authenticate("alice", "alice-password"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := fs.connCount(); n != 3 {
+		t.Errorf("expected a new connection per login without pooling, got %d", n)
+	}
+}
+
+func TestConnectionPoolDropsClosedConnections(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	newTestDirectory().attach(fs)
+
+	client := fs.client()
+	client.MaxIdleConns = 1
+
+	if _, err := client.Authenticate("alice", "alice-password"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Simulate the connection having been torn down while idle.
+	client.pool.idle[0].conn.Close()
+
+	if _, err := client.Authenticate("alice", "alice-password"); err != nil {
+		t.Fatalf("expected a fresh connection to be dialed: %v", err)
+	}
+	if n := fs.connCount(); n != 2 {
+		t.Errorf("expected a closed connection not to be reused, got %d connections", n)
+	}
+}
+
+func TestConnectionPoolClose(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	newTestDirectory().attach(fs)
+
+	client := fs.client()
+	client.MaxIdleConns = 1
+
+	if _, err := client.Authenticate("alice", "alice-password"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	idle := client.pool.idle[0].conn
+	client.Close()
+	if !idle.IsClosing() {
+		t.Errorf("expected the idle connection to be closed")
+	}
+
+	// A closed client still logs users in, without pooling.
+	if _, err := client.Authenticate("alice", "alice-password"); err != nil {
+		t.Fatalf("unexpected error after closing: %v", err)
+	}
+	if n := len(client.pool.idle); n != 0 {
+		t.Errorf("expected no connections pooled after closing, got %d", n)
+	}
+}
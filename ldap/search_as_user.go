@@ -0,0 +1,30 @@
+package ldap
+
+import (
+	"fmt"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// readEntryAsUser reads entry again on conn, which is bound as the user,
+// and returns it as the user sees it. Their groups are then searched for
+// on the same connection.
+func (c *Client) readEntryAsUser(conn *ldap.Conn, username string, entry *ldap.Entry) (*ldap.Entry, error) {
+	res, err := conn.Search(&ldap.SearchRequest{
+		BaseDN:       entry.DN,
+		Scope:        ldap.ScopeBaseObject,
+		DerefAliases: ldap.NeverDerefAliases,
+		SizeLimit:    1,
+		TimeLimit:    10,
+		Filter:       "(objectClass=*)",
+	})
+	if err != nil {
+		userSearchFailed.Inc()
+		return nil, fmt.Errorf("Error reading the entry of user %s as the user: %w", username, err)
+	}
+	if len(res.Entries) == 0 {
+		userSearchFailed.Inc()
+		return nil, fmt.Errorf("Error reading the entry of user %s as the user: %s isn't visible to them", username, entry.DN)
+	}
+	return res.Entries[0], nil
+}
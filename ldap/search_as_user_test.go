@@ -0,0 +1,116 @@
+package ldap
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	aliceDN      = "uid=alice,dc=example,dc=com"
+	developersDN = "cn=developers,ou=groups,dc=example,dc=com"
+)
+
+// privateDirectory only reveals alice's groups to alice herself: the
+// search user sees her entry without memberOf and no posixGroups. With
+// hidden, alice can't read her own entry either.
+func privateDirectory(fs *fakeServer, hidden bool) {
+	d := newTestDirectory()
+	fs.bind = d.bindHook
+	fs.search = func(req fakeSearch) ([]*ldap.Entry, fakeResult) {
+		asAlice := req.BoundDN == aliceDN || req.BoundDN == "alice"
+		attrs := map[string][]string{"uid": {"alice"}, "cn": {"Alice"}}
+		if asAlice {
+			attrs["memberOf"] = []string{developersDN}
+		}
+		alice := ldap.NewEntry(aliceDN, attrs)
+		switch {
+		case strings.Contains(req.Filter, "posixGroup"):
+			if asAlice {
+				return []*ldap.Entry{ldap.NewEntry("cn=posix-developers,ou=groups,dc=example,dc=com", map[string][]string{"cn": {"posix-developers"}})}, fakeResult{code: ldap.LDAPResultSuccess}
+			}
+		case req.Scope == ldap.ScopeBaseObject && req.BaseDN == aliceDN:
+			if !hidden {
+				return []*ldap.Entry{alice}, fakeResult{code: ldap.LDAPResultSuccess}
+			}
+		case entryMatches(alice, req.Filter):
+			return []*ldap.Entry{alice}, fakeResult{code: ldap.LDAPResultSuccess}
+		}
+		return nil, fakeResult{code: ldap.LDAPResultSuccess}
+	}
+}
+
+func TestSearchAsUser(t *testing.T) {
+	cases := []struct {
+		name         string
+		searchAsUser bool
+		membership   string
+		searchUser   bool
+		expected     []string
+	}{
+		{name: "memberOf as the search user", membership: MembershipMemberOf, searchUser: true},
+		{name: "memberOf as the user", searchAsUser: true, membership: MembershipMemberOf, searchUser: true, expected: []string{developersDN}},
+		{name: "memberUid as the user", searchAsUser: true, membership: MembershipMemberUID, searchUser: true, expected: []string{developersDN, "cn=posix-developers,ou=groups,dc=example,dc=com"}},
+		// Users binding directly already search as themselves.
+		{name: "direct bind", searchAsUser: true, membership: MembershipMemberOf, expected: []string{developersDN}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fs := newFakeServer(t)
+			defer fs.Close()
+			privateDirectory(fs, false)
+
+			client := fs.client()
+			client.SearchAsUser = c.searchAsUser
+			client.GroupMembership = c.membership
+			if c.searchUser {
+				client.SearchUserDN = "cn=search,dc=example,dc=com"
+				client.SearchUserPassword = "search-password"
+			}
+
+			entry, err := client.Authenticate("alice", "alice-password")
+			if err != nil {
+				t.Fatalf("expected alice to authenticate: %v", err)
+			}
+			if entry.DN != aliceDN || entry.GetAttributeValue("cn") != "Alice" {
+				t.Errorf("expected alice's entry, got %+v", entry)
+			}
+			if groups := entry.GetAttributeValues("memberOf"); strings.Join(groups, ";") != strings.Join(c.expected, ";") {
+				t.Errorf("expected groups %v, got %v", c.expected, groups)
+			}
+
+			var reads, expectedReads int
+			for _, req := range fs.searchRequests() {
+				if req.Scope == ldap.ScopeBaseObject {
+					reads++
+					if req.BoundDN != aliceDN {
+						t.Errorf("expected the entry to be read as alice, got %q", req.BoundDN)
+					}
+				}
+			}
+			if c.searchAsUser && c.searchUser {
+				expectedReads = 1
+			}
+			if reads != expectedReads {
+				t.Errorf("expected %d reads of the entry as alice, got %d", expectedReads, reads)
+			}
+		})
+	}
+}
+
+func TestSearchAsUserEntryNotVisible(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	privateDirectory(fs, true)
+
+	client := fs.client()
+	client.SearchUserDN = "cn=search,dc=example,dc=com"
+	client.SearchUserPassword = "search-password"
+	client.SearchAsUser = true
+
+	_, err := client.Authenticate("alice", "alice-password")
+	if err == nil || !strings.Contains(err.Error(), "isn't visible to them") {
+		t.Fatalf("expected the login to fail when alice can't read her entry, got %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package ldap
+
+import (
+	"fmt"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// What happens when the user search hits the server's size or time
+// limit.
+const (
+	// SearchLimitFail fails the login with a SearchLimitError.
+	SearchLimitFail = "fail"
+	// SearchLimitNarrow retries the search once with its filter narrowed
+	// by SearchLimitFilter, and fails if that hits a limit too.
+	SearchLimitNarrow = "narrow"
+)
+
+// defaultSearchLimitFilter narrows user searches with SearchLimitNarrow
+// when SearchLimitFilter isn't set.
+const defaultSearchLimitFilter = "(objectClass=person)"
+
+// Which limit a search exceeded, as in SearchLimitError.
+const (
+	SizeLimit = "size"
+	TimeLimit = "time"
+)
+
+// SearchLimitError is returned when the user search hit the server's
+// size or time limit, so its results may be incomplete.
+type SearchLimitError struct {
+	// Limit is SizeLimit or TimeLimit.
+	Limit  string
+	Filter string
+	Err    error
+}
+
+func (e *SearchLimitError) Error() string {
+	return fmt.Sprintf("the search for %s exceeded the server's %s limit: %v", e.Filter, e.Limit, e.Err)
+}
+
+// Unwrap returns the server's error.
+func (e *SearchLimitError) Unwrap() error {
+	return e.Err
+}
+
+var searchLimitExceeded = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "kubernetes_ldap_search_limit_exceeded",
+		Help: "Total number of LDAP user searches which exceeded the server's size or time limit, by limit.",
+	},
+	[]string{"limit"},
+)
+
+// CheckSearchFilter returns an error if filter isn't a valid LDAP search
+// filter (RFC 4515), e.g. one for SearchLimitFilter.
+func CheckSearchFilter(filter string) error {
+	if _, err := ldap.CompileFilter(filter); err != nil {
+		return fmt.Errorf("invalid search filter %q: %v", filter, err)
+	}
+	return nil
+}
+
+// exceededLimit returns which limit err reports the search exceeded, or
+// "" if it isn't a limit error.
+func exceededLimit(err error) string {
+	switch {
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded):
+		return SizeLimit
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultTimeLimitExceeded):
+		return TimeLimit
+	}
+	return ""
+}
+
+// searchUserWithLimits runs the user search req, handling the server's
+// size and time limits as SearchLimitPolicy says.
+func (c *Client) searchUserWithLimits(conn *ldap.Conn, req *ldap.SearchRequest) ([]*ldap.Entry, error) {
+	filters := []string{req.Filter}
+	if c.SearchLimitPolicy == SearchLimitNarrow {
+		narrow := c.SearchLimitFilter
+		if narrow == "" {
+			narrow = defaultSearchLimitFilter
+		}
+		filters = append(filters, fmt.Sprintf("(&%s%s)", narrow, req.Filter))
+	}
+
+	var limitErr *SearchLimitError
+	for _, filter := range filters {
+		attempt := *req
+		attempt.Filter = filter
+		res, err := conn.Search(&attempt)
+		limit := exceededLimit(err)
+		if limit == "" {
+			if err != nil {
+				return nil, err
+			}
+			return res.Entries, nil
+		}
+		// Rejecting an ambiguous user only needs two matches, which the
+		// server returned before stopping; picking one needs them all.
+		if limit == SizeLimit && c.MultipleMatchPolicy != MultipleMatchTiebreak && res != nil && len(res.Entries) >= 2 {
+			return res.Entries, nil
+		}
+		searchLimitExceeded.WithLabelValues(limit).Inc()
+		glog.Warningf("The user search for %s under %s exceeded the server's %s limit: %v", filter, attempt.BaseDN, limit, err)
+		limitErr = &SearchLimitError{Limit: limit, Filter: filter, Err: err}
+	}
+	return nil, limitErr
+}
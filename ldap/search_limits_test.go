@@ -0,0 +1,139 @@
+package ldap
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestSearchLimitExceeded(t *testing.T) {
+	alice := ldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{"uid": {"alice"}})
+	contractor := ldap.NewEntry("uid=alice,ou=contract,dc=example,dc=com", map[string][]string{"uid": {"alice"}})
+
+	cases := []struct {
+		name     string
+		code     uint16
+		policy   string
+		narrow   string
+		tiebreak bool
+		// entries are returned along with the limit error, and narrowed
+		// are the results of searches with a narrowed filter, which don't
+		// exceed the limit unless narrowedCode is set.
+		entries      []*ldap.Entry
+		narrowed     []*ldap.Entry
+		narrowedCode uint16
+		limit        string
+		filters      []string
+		errContains  string
+	}{
+		{
+			name:    "size limit",
+			code:    ldap.LDAPResultSizeLimitExceeded,
+			entries: []*ldap.Entry{alice},
+			limit:   SizeLimit,
+			filters: []string{"(uid=alice)"},
+		},
+		{
+			name:    "time limit",
+			code:    ldap.LDAPResultTimeLimitExceeded,
+			entries: []*ldap.Entry{alice},
+			limit:   TimeLimit,
+			filters: []string{"(uid=alice)"},
+		},
+		{
+			// Two matches are enough to know the user is ambiguous.
+			name:        "size limit with two matches",
+			code:        ldap.LDAPResultSizeLimitExceeded,
+			entries:     []*ldap.Entry{alice, contractor},
+			filters:     []string{"(uid=alice)"},
+			errContains: "Multiple entries found for user alice",
+		},
+		{
+			// But not to pick one of them.
+			name:     "size limit with two matches to tiebreak",
+			code:     ldap.LDAPResultSizeLimitExceeded,
+			tiebreak: true,
+			entries:  []*ldap.Entry{alice, contractor},
+			limit:    SizeLimit,
+			filters:  []string{"(uid=alice)"},
+		},
+		{
+			name:     "size limit narrowed",
+			code:     ldap.LDAPResultSizeLimitExceeded,
+			policy:   SearchLimitNarrow,
+			narrowed: []*ldap.Entry{alice},
+			filters:  []string{"(uid=alice)", "(&(objectClass=person)(uid=alice))"},
+		},
+		{
+			name:     "time limit narrowed with a custom filter",
+			code:     ldap.LDAPResultTimeLimitExceeded,
+			policy:   SearchLimitNarrow,
+			narrow:   "(objectClass=inetOrgPerson)",
+			narrowed: []*ldap.Entry{alice},
+			filters:  []string{"(uid=alice)", "(&(objectClass=inetOrgPerson)(uid=alice))"},
+		},
+		{
+			name:         "time limit still exceeded narrowed",
+			code:         ldap.LDAPResultTimeLimitExceeded,
+			policy:       SearchLimitNarrow,
+			narrowedCode: ldap.LDAPResultTimeLimitExceeded,
+			limit:        TimeLimit,
+			filters:      []string{"(uid=alice)", "(&(objectClass=person)(uid=alice))"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fs := newFakeServer(t)
+			defer fs.Close()
+			d := newTestDirectory()
+			fs.bind = d.bindHook
+			fs.search = func(req fakeSearch) ([]*ldap.Entry, fakeResult) {
+				if strings.HasPrefix(req.Filter, "(&") {
+					if c.narrowedCode != 0 {
+						return nil, fakeResult{code: c.narrowedCode, diag: "limit exceeded"}
+					}
+					return c.narrowed, fakeResult{code: ldap.LDAPResultSuccess}
+				}
+				return c.entries, fakeResult{code: c.code, diag: "limit exceeded"}
+			}
+
+			client := fs.client()
+			client.SearchUserDN = "cn=search,dc=example,dc=com"
+			client.SearchUserPassword = "search-password"
+			client.SearchLimitPolicy = c.policy
+			client.SearchLimitFilter = c.narrow
+			if c.tiebreak {
+				client.MultipleMatchPolicy = MultipleMatchTiebreak
+				client.TiebreakAttribute = "uidNumber"
+			}
+
+			entry, err := client.Authenticate("alice", "alice-password")
+			var limitErr *SearchLimitError
+			switch {
+			case c.limit != "":
+				if !errors.As(err, &limitErr) || limitErr.Limit != c.limit {
+					t.Errorf("expected a %s limit error, got %v", c.limit, err)
+				}
+			case c.errContains != "":
+				if err == nil || !strings.Contains(err.Error(), c.errContains) || errors.As(err, &limitErr) {
+					t.Errorf("expected an error containing %q, got %v", c.errContains, err)
+				}
+			case err != nil:
+				t.Errorf("expected alice to authenticate, got %v", err)
+			case entry.DN != alice.DN:
+				t.Errorf("expected %s, got %s", alice.DN, entry.DN)
+			}
+
+			var filters []string
+			for _, req := range fs.searchRequests() {
+				filters = append(filters, req.Filter)
+			}
+			if strings.Join(filters, " ") != strings.Join(c.filters, " ") {
+				t.Errorf("expected searches for %q, got %q", c.filters, filters)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package ldap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// SecretProvider supplies the credentials of the service account used
+// to search for users. It is called for every authentication, so
+// dynamic providers (e.g. Vault) can rotate the password underneath us.
+type SecretProvider interface {
+	GetBindCredentials() (dn, password string, err error)
+}
+
+// RefreshableSecretProvider is a SecretProvider that caches credentials
+// and can be told to refetch them. The client refreshes it when the
+// directory rejects the cached credentials, in case they were rotated.
+type RefreshableSecretProvider interface {
+	SecretProvider
+	Refresh() error
+}
+
+// StaticSecretProvider returns fixed credentials.
+type StaticSecretProvider struct {
+	DN       string
+	Password string
+}
+
+// GetBindCredentials returns the configured DN and password.
+func (s *StaticSecretProvider) GetBindCredentials() (string, string, error) {
+	return s.DN, s.Password, nil
+}
+
+// FileSecretProvider reads the password from a file on every call, so a
+// password rotated by rewriting the file (e.g. a mounted Kubernetes
+// Secret or a Vault agent template) is picked up without a restart.
+type FileSecretProvider struct {
+	DN           string
+	PasswordFile string
+}
+
+// GetBindCredentials returns the configured DN and the current contents
+// of the password file, without a trailing newline.
+func (f *FileSecretProvider) GetBindCredentials() (string, string, error) {
+	password, err := ioutil.ReadFile(f.PasswordFile)
+	if err != nil {
+		return "", "", fmt.Errorf("Error reading search user password file: %v", err)
+	}
+	return f.DN, strings.TrimRight(string(password), "\r\n"), nil
+}
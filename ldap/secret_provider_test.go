@@ -0,0 +1,117 @@
+package ldap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// rotatingProvider caches the credentials it was last given by the
+// vault, like a real dynamic provider, until it is refreshed.
+type rotatingProvider struct {
+	mu        sync.Mutex
+	vault     *string
+	cached    string
+	refreshes int
+}
+
+func (r *rotatingProvider) GetBindCredentials() (string, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return "cn=search,dc=example,dc=com", r.cached, nil
+}
+
+func (r *rotatingProvider) Refresh() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refreshes++
+	r.cached = *r.vault
+	return nil
+}
+
+func TestSecretProviderRotation(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	dir := newTestDirectory()
+	dir.attach(fs)
+
+	vault := "search-password"
+	provider := &rotatingProvider{vault: &vault, cached: vault}
+
+	client := fs.client()
+	client.SecretProvider = provider
+
+	if _, err := client.Authenticate("alice", "alice-password"); err != nil {
+		t.Fatalf("expected authentication with the initial password to succeed: %v", err)
+	}
+
+	// The service account password is rotated in both the vault and the
+	// directory; the provider still holds the old one.
+	vault = "rotated-password"
+	dir.passwords["cn=search,dc=example,dc=com"] = vault
+
+	if _, err := client.Authenticate("alice", "alice-password"); err != nil {
+		t.Fatalf("expected authentication to succeed after rotation: %v", err)
+	}
+	if provider.refreshes != 1 {
+		t.Errorf("expected the provider to be refreshed once, got %d", provider.refreshes)
+	}
+
+	// Subsequent logins use the refreshed password without refetching.
+	if _, err := client.Authenticate("alice", "alice-password"); err != nil {
+		t.Fatalf("expected authentication to succeed: %v", err)
+	}
+	if provider.refreshes != 1 {
+		t.Errorf("expected no further refreshes, got %d", provider.refreshes)
+	}
+}
+
+func TestSecretProviderWrongUserPasswordDoesNotRefresh(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	newTestDirectory().attach(fs)
+
+	vault := "search-password"
+	provider := &rotatingProvider{vault: &vault, cached: vault}
+
+	client := fs.client()
+	client.SecretProvider = provider
+
+	if _, err := client.Authenticate("alice", "wrong-password"); err == nil {
+		t.Fatalf("expected a wrong user password to be rejected")
+	}
+	if provider.refreshes != 0 {
+		t.Errorf("expected a failed user bind not to refresh the search credentials, got %d refreshes", provider.refreshes)
+	}
+}
+
+func TestFileSecretProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret-provider")
+	if err != nil {
+		t.Fatalf("creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	passwordFile := filepath.Join(dir, "password")
+	provider := &FileSecretProvider{DN: "cn=search", PasswordFile: passwordFile}
+
+	for _, password := range []string{"first", "second"} {
+		if err := ioutil.WriteFile(passwordFile, []byte(password+"\n"), 0600); err != nil {
+			t.Fatalf("writing password file: %v", err)
+		}
+		dn, got, err := provider.GetBindCredentials()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if dn != "cn=search" || got != password {
+			t.Errorf("expected (cn=search, %s), got (%s, %s)", password, dn, got)
+		}
+	}
+
+	os.Remove(passwordFile)
+	if _, _, err := provider.GetBindCredentials(); err == nil {
+		t.Errorf("expected an error for a missing password file")
+	}
+}
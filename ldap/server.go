@@ -0,0 +1,43 @@
+package ldap
+
+import (
+	"sync"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ServerAttribute is set on the entries Authenticate returns to the
+// address, IP and port, of the directory server the user bound to, which
+// tells apart replicas behind a single LdapServer name. Like
+// GroupsIncompleteAttribute, the directory can't set it.
+const ServerAttribute = "kubernetes-ldap:server"
+
+// connServers remembers the address of the server each connection was
+// dialed to, since ldap.Conn doesn't expose it.
+type connServers struct {
+	mu    sync.Mutex
+	addrs map[*ldap.Conn]string
+}
+
+// add records conn's server address, first forgetting the connections
+// that have been closed since the last one was added.
+func (s *connServers) add(conn *ldap.Conn, addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.addrs == nil {
+		s.addrs = map[*ldap.Conn]string{}
+	}
+	for c := range s.addrs {
+		if c.IsClosing() {
+			delete(s.addrs, c)
+		}
+	}
+	s.addrs[conn] = addr
+}
+
+// get returns conn's server address, or "" if it isn't known.
+func (s *connServers) get(conn *ldap.Conn) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addrs[conn]
+}
@@ -0,0 +1,64 @@
+package ldap
+
+import "testing"
+
+func TestServerAttribute(t *testing.T) {
+	// Three replicas: the first is down, the second hasn't replicated
+	// alice yet, and the third logs her in.
+	down := newFakeServer(t)
+	down.Close()
+	stale := newFakeServer(t)
+	defer stale.Close()
+	staleDirectory := newTestDirectory()
+	staleDirectory.entries = nil
+	staleDirectory.attach(stale)
+	current := newFakeServer(t)
+	defer current.Close()
+	newTestDirectory().attach(current)
+
+	var replicas Chain
+	for _, fs := range []*fakeServer{down, stale, current} {
+		client := fs.client()
+		client.SearchUserDN = "cn=search,dc=example,dc=com"
+		client.SearchUserPassword = "search-password"
+		client.MaxIdleConns = 1
+		replicas = append(replicas, client)
+	}
+
+	// Pooled connections remember their server too.
+	for i := 0; i < 2; i++ {
+		entry, err := replicas.Authenticate("alice", "alice-password")
+		if err != nil {
+			t.Fatalf("login %d: expected alice to log in, got %v", i, err)
+		}
+		if server, expected := entry.GetAttributeValue(ServerAttribute), current.listener.Addr().String(); server != expected {
+			t.Errorf("login %d: expected the login to be attributed to %s, got %q", i, expected, server)
+		}
+	}
+	if n := current.connCount(); n != 1 {
+		t.Errorf("expected the connection to be reused, got %d connections", n)
+	}
+}
+
+func TestConnServersForgetsClosedConns(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	client := fs.client()
+
+	first, err := client.dial()
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	if client.servers.get(first) != fs.listener.Addr().String() {
+		t.Errorf("expected the connection's server to be %s, got %q", fs.listener.Addr(), client.servers.get(first))
+	}
+	first.Close()
+	second, err := client.dial()
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	defer second.Close()
+	if client.servers.get(first) != "" || len(client.servers.addrs) != 1 {
+		t.Errorf("expected the closed connection to be forgotten, got %v", client.servers.addrs)
+	}
+}
@@ -0,0 +1,74 @@
+//go:build linux || darwin
+
+package ldap
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+// sockopt reads an integer socket option of conn.
+func sockopt(t *testing.T, conn net.Conn, level, opt int) int {
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatalf("getting raw connection: %v", err)
+	}
+	var value int
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		value, sockErr = syscall.GetsockoptInt(int(fd), level, opt)
+	}); err != nil {
+		t.Fatalf("controlling connection: %v", err)
+	}
+	if sockErr != nil {
+		t.Fatalf("reading socket option: %v", sockErr)
+	}
+	return value
+}
+
+func TestDialSocketOptions(t *testing.T) {
+	fs := newFakeServer(t)
+	defer fs.Close()
+	address := fs.listener.Addr().String()
+
+	cases := []struct {
+		name            string
+		disableNoDelay  bool
+		readBufferSize  int
+		writeBufferSize int
+		expectedNoDelay bool
+	}{
+		{name: "defaults", expectedNoDelay: true},
+		{name: "nagle", disableNoDelay: true},
+		{name: "buffer sizes", readBufferSize: 256 << 10, writeBufferSize: 128 << 10, expectedNoDelay: true},
+	}
+
+	for _, c := range cases {
+		client := fs.client()
+		client.DisableTCPNoDelay = c.disableNoDelay
+		client.ReadBufferSize = c.readBufferSize
+		client.WriteBufferSize = c.writeBufferSize
+
+		conn, err := client.dialTCP(address)
+		if err != nil {
+			t.Fatalf("%s: dialing: %v", c.name, err)
+		}
+		if noDelay := sockopt(t, conn, syscall.IPPROTO_TCP, syscall.TCP_NODELAY) != 0; noDelay != c.expectedNoDelay {
+			t.Errorf("%s: expected TCP_NODELAY %v, got %v", c.name, c.expectedNoDelay, noDelay)
+		}
+		// Linux doubles the requested size for bookkeeping, so only a
+		// lower bound can be checked.
+		if c.readBufferSize > 0 {
+			if size := sockopt(t, conn, syscall.SOL_SOCKET, syscall.SO_RCVBUF); size < c.readBufferSize {
+				t.Errorf("%s: expected a receive buffer of at least %d, got %d", c.name, c.readBufferSize, size)
+			}
+		}
+		if c.writeBufferSize > 0 {
+			if size := sockopt(t, conn, syscall.SOL_SOCKET, syscall.SO_SNDBUF); size < c.writeBufferSize {
+				t.Errorf("%s: expected a send buffer of at least %d, got %d", c.name, c.writeBufferSize, size)
+			}
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,142 @@
+package ldap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	staticUserLogins = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_static_user_logins",
+			Help: "Total number of logins of static (break-glass) users.",
+		},
+	)
+	invalidStaticUserCredentials = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_invalid_static_user_credentials",
+			Help: "Total number of logins of static (break-glass) users with the wrong password.",
+		},
+	)
+)
+
+// StaticUser is a user of a StaticAuthenticator.
+type StaticUser struct {
+	// PasswordHash is the bcrypt hash of the user's password, e.g. from
+	// htpasswd -nB.
+	PasswordHash string `yaml:"password-hash"`
+	// DN is the DN of the user's entry. Defaults to cn=<username>.
+	DN string `yaml:"dn"`
+	// Groups are the names of the user's groups, put in the entry's
+	// memberOf as cn=<group>.
+	Groups []string `yaml:"groups"`
+	// Attributes are added to the entry, e.g. a uidNumber.
+	Attributes map[string][]string `yaml:"attributes"`
+}
+
+// StaticAuthenticator authenticates users listed in a file rather than
+// the directory, e.g. break-glass users for when the directory is down.
+// Their entries look like directory entries to the token issuer.
+type StaticAuthenticator struct {
+	Users map[string]StaticUser
+	// UsernameAttribute is the attribute the username is put in, so
+	// that the token issuer finds it, e.g. mail.
+	UsernameAttribute string
+
+	// unknownUserHash is checked for users that aren't in Users, so
+	// they take as long to reject as a wrong password.
+	unknownUserHash []byte
+}
+
+// ParseStaticUsers parses a static users file, e.g.
+//
+//	users:
+//	  breakglass:
+//	    password-hash: $2y$10$...
+//	    groups: [system:masters]
+func ParseStaticUsers(data []byte) (*StaticAuthenticator, error) {
+	var file struct {
+		Users map[string]StaticUser `yaml:"users"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing static users: %v", err)
+	}
+	for username, user := range file.Users {
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("static user %s: password-hash must be a bcrypt hash: %v", username, err)
+		}
+	}
+
+	unknownUserHash, err := bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	return &StaticAuthenticator{Users: file.Users, unknownUserHash: unknownUserHash}, nil
+}
+
+// LoadStaticUsers reads and parses a static users file. The file grants
+// access without the directory, so it must not be accessible to group
+// or others.
+func LoadStaticUsers(filename string) (*StaticAuthenticator, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if mode := info.Mode().Perm(); mode&0077 != 0 {
+		return nil, fmt.Errorf("%s is accessible to group or others (mode %04o), it must only be accessible to its owner", filename, mode)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseStaticUsers(data)
+}
+
+func (s *StaticAuthenticator) Authenticate(username, password string) (*ldap.Entry, error) {
+	user, ok := s.Users[username]
+	if !ok {
+		if s.unknownUserHash != nil {
+			bcrypt.CompareHashAndPassword(s.unknownUserHash, []byte(password))
+		}
+		return nil, fmt.Errorf("%s is not a static user", username)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		invalidStaticUserCredentials.Inc()
+		glog.Warningf("BREAK-GLASS: login of static user %s failed: invalid password", username)
+		return nil, fmt.Errorf("invalid password for static user %s", username)
+	}
+
+	staticUserLogins.Inc()
+	glog.Warningf("BREAK-GLASS: static user %s logged in without the directory, with groups %v", username, user.Groups)
+	return s.entry(username, user), nil
+}
+
+// entry returns the directory entry standing for user.
+func (s *StaticAuthenticator) entry(username string, user StaticUser) *ldap.Entry {
+	dn := user.DN
+	if dn == "" {
+		dn = "cn=" + ldap.EscapeDN(username)
+	}
+	attributes := map[string][]string{}
+	for name, values := range user.Attributes {
+		attributes[name] = values
+	}
+	if s.UsernameAttribute != "" {
+		attributes[s.UsernameAttribute] = []string{username}
+	}
+	memberOf := make([]string, 0, len(user.Groups))
+	for _, group := range user.Groups {
+		memberOf = append(memberOf, "cn="+ldap.EscapeDN(group))
+	}
+	if len(memberOf) > 0 {
+		attributes["memberOf"] = memberOf
+	}
+	return ldap.NewEntry(dn, attributes)
+}
@@ -0,0 +1,88 @@
+package ldap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestStaticAuthenticator(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("glass-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	s, err := ParseStaticUsers([]byte(`
+users:
+  breakglass:
+    password-hash: ` + string(hash) + `
+    groups: [system:masters]
+    attributes:
+      uidNumber: ["0"]
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse static users: %v", err)
+	}
+	s.UsernameAttribute = "uid"
+
+	entry, err := s.Authenticate("breakglass", "glass-password")
+	if err != nil {
+		t.Fatalf("Expected breakglass to be authenticated: %v", err)
+	}
+	if entry.DN != "cn=breakglass" {
+		t.Errorf("Expected the default DN, got %q", entry.DN)
+	}
+	expected := map[string][]string{
+		"uid":       {"breakglass"},
+		"uidNumber": {"0"},
+		"memberOf":  {"cn=system:masters"},
+	}
+	for name, values := range expected {
+		if got := entry.GetAttributeValues(name); !reflect.DeepEqual(got, values) {
+			t.Errorf("Expected %s %v, got %v", name, values, got)
+		}
+	}
+
+	for _, c := range []struct{ username, password string }{
+		{"breakglass", "wrong"},
+		{"breakglass", ""},
+		{"Breakglass", "glass-password"},
+		{"alice", "glass-password"},
+	} {
+		if _, err := s.Authenticate(c.username, c.password); err == nil {
+			t.Errorf("Expected %s with password %q to be rejected", c.username, c.password)
+		}
+	}
+}
+
+func TestParseStaticUsersRejectsInvalidFiles(t *testing.T) {
+	cases := map[string]string{
+		"plaintext password": "users:\n  breakglass:\n    password-hash: glass-password\n",
+		"no password":        "users:\n  breakglass:\n    groups: [system:masters]\n",
+		"unknown field":      "users:\n  breakglass:\n    password: glass-password\n",
+	}
+	for name, data := range cases {
+		if _, err := ParseStaticUsers([]byte(data)); err == nil {
+			t.Errorf("%s: expected the file to be rejected", name)
+		}
+	}
+}
+
+func TestLoadStaticUsersRequiresPrivateFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "static-users.yaml")
+	if err := ioutil.WriteFile(filename, []byte("users: {}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write static users: %v", err)
+	}
+	if _, err := LoadStaticUsers(filename); err == nil {
+		t.Errorf("Expected a world readable file to be rejected")
+	}
+	if err := os.Chmod(filename, 0600); err != nil {
+		t.Fatalf("Failed to chmod static users: %v", err)
+	}
+	if _, err := LoadStaticUsers(filename); err != nil {
+		t.Errorf("Expected a file only its owner can read to be loaded: %v", err)
+	}
+}
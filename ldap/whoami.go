@@ -0,0 +1,52 @@
+package ldap
+
+import (
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/golang/glog"
+)
+
+// checkIdentity asks the directory which identity the bind on conn
+// established, with the RFC 4532 "Who Am I?" extended operation, and
+// logs it. If the directory reports a DN other than boundDN, a warning is
+// logged: e.g. SASL mapped the bind to another entry, or a referral
+// bound elsewhere. boundDN is empty for binds without a DN to compare,
+// like GSSAPI. Failures are logged only, they never fail the login.
+func (c *Client) checkIdentity(conn *ldap.Conn, boundDN string) {
+	if !c.WhoAmI {
+		return
+	}
+	res, err := conn.WhoAmI(nil)
+	if err != nil {
+		glog.V(4).Infof("LDAP Who Am I? after binding as %q failed: %v", boundDN, err)
+		return
+	}
+	glog.V(4).Infof("LDAP bind as %q established authzId %q", boundDN, res.AuthzID)
+	if boundDN != "" && !identityMatches(res.AuthzID, boundDN) {
+		glog.Warningf("LDAP bind as %q established a different identity, authzId %q", boundDN, res.AuthzID)
+	}
+}
+
+// identityMatches reports whether authzID, as returned by Who Am I?, is
+// the identity of a bind as boundDN. Only "dn:" authzIds can be
+// compared; "u:" ones (e.g. AD's DOMAIN\user) are assumed to match, and
+// an empty one is an anonymous bind. Names that aren't DNs, like AD's
+// user@domain, can't be compared either.
+func identityMatches(authzID, boundDN string) bool {
+	if authzID == "" {
+		return false
+	}
+	if !strings.HasPrefix(strings.ToLower(authzID), "dn:") {
+		return true
+	}
+	expected, err := ldap.ParseDN(boundDN)
+	if err != nil || len(expected.RDNs) == 0 {
+		return true
+	}
+	actual, err := ldap.ParseDN(authzID[len("dn:"):])
+	if err != nil {
+		return false
+	}
+	return expected.EqualFold(actual)
+}
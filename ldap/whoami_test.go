@@ -0,0 +1,114 @@
+package ldap
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestWhoAmIAfterBind(t *testing.T) {
+	cases := []struct {
+		name     string
+		whoAmI   bool
+		authzIDs map[string]string
+		expected []string
+	}{
+		{
+			name:   "matching identities",
+			whoAmI: true,
+			authzIDs: map[string]string{
+				"cn=search,dc=example,dc=com": "dn:cn=search,dc=example,dc=com",
+				"uid=alice,dc=example,dc=com": "dn:UID=alice, DC=example, DC=com",
+			},
+			expected: []string{"cn=search,dc=example,dc=com", "uid=alice,dc=example,dc=com"},
+		},
+		{
+			// A mismatch is only logged, the login succeeds.
+			name:   "mismatching identity",
+			whoAmI: true,
+			authzIDs: map[string]string{
+				"cn=search,dc=example,dc=com": "dn:cn=search,dc=example,dc=com",
+				"uid=alice,dc=example,dc=com": "dn:uid=mallory,dc=example,dc=com",
+			},
+			expected: []string{"cn=search,dc=example,dc=com", "uid=alice,dc=example,dc=com"},
+		},
+		{
+			// Neither is a server refusing the operation.
+			name:     "unsupported operation",
+			whoAmI:   true,
+			expected: []string{"cn=search,dc=example,dc=com", "uid=alice,dc=example,dc=com"},
+		},
+		{
+			name: "disabled",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fs := newFakeServer(t)
+			defer fs.Close()
+			newTestDirectory().attach(fs)
+
+			var mu sync.Mutex
+			var asked []string
+			fs.extended = func(name string, value []byte) (fakeResult, []byte) {
+				if name != ldap.ControlTypeWhoAmI {
+					return fakeResult{code: ldap.LDAPResultProtocolError}, nil
+				}
+				binds := fs.boundDNs()
+				dn := binds[len(binds)-1]
+				mu.Lock()
+				asked = append(asked, dn)
+				mu.Unlock()
+				authzID, ok := c.authzIDs[dn]
+				if !ok {
+					return fakeResult{code: ldap.LDAPResultUnwillingToPerform}, nil
+				}
+				return fakeResult{code: ldap.LDAPResultSuccess}, []byte(authzID)
+			}
+
+			client := fs.client()
+			client.SearchUserDN = "cn=search,dc=example,dc=com"
+			client.SearchUserPassword = "search-password"
+			client.WhoAmI = c.whoAmI
+
+			entry, err := client.Authenticate("alice", "alice-password")
+			if err != nil {
+				t.Fatalf("expected alice to authenticate: %v", err)
+			}
+			if entry.DN != "uid=alice,dc=example,dc=com" {
+				t.Errorf("unexpected entry %q", entry.DN)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(asked, c.expected) {
+				t.Errorf("expected Who Am I? after binding as %v, got %v", c.expected, asked)
+			}
+		})
+	}
+}
+
+func TestIdentityMatches(t *testing.T) {
+	cases := []struct {
+		authzID  string
+		boundDN  string
+		expected bool
+	}{
+		{"dn:uid=alice,dc=example,dc=com", "uid=alice,dc=example,dc=com", true},
+		{"DN:UID=Alice, DC=Example, DC=com", "uid=alice,dc=example,dc=com", true},
+		{"dn:uid=mallory,dc=example,dc=com", "uid=alice,dc=example,dc=com", false},
+		// An anonymous bind, e.g. an unauthenticated one.
+		{"", "uid=alice,dc=example,dc=com", false},
+		{"dn:not a dn", "uid=alice,dc=example,dc=com", false},
+		// AD's authzIds, and binds with a UPN, can't be compared.
+		{`u:EXAMPLE\alice`, "cn=alice,dc=example,dc=com", true},
+		{"dn:cn=alice,dc=example,dc=com", "alice@example.com", true},
+	}
+	for _, c := range cases {
+		if actual := identityMatches(c.authzID, c.boundDN); actual != c.expected {
+			t.Errorf("identityMatches(%q, %q): expected %v, got %v", c.authzID, c.boundDN, c.expected, actual)
+		}
+	}
+}
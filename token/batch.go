@@ -0,0 +1,44 @@
+package token
+
+import (
+	"runtime"
+	"sync"
+)
+
+// VerifyBatch verifies many tokens with v, spreading the work over up to
+// GOMAXPROCS goroutines. tokens[i]'s result is in toks[i] and errs[i], as
+// returned by v.Verify. v must be safe for concurrent use, as all the
+// verifiers in this package are.
+func VerifyBatch(v Verifier, tokens []string) (toks []*AuthToken, errs []error) {
+	toks = make([]*AuthToken, len(tokens))
+	errs = make([]error, len(tokens))
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(tokens) {
+		workers = len(tokens)
+	}
+	if workers <= 1 {
+		for i, s := range tokens {
+			toks[i], errs[i] = v.Verify(s)
+		}
+		return toks, errs
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				toks[i], errs[i] = v.Verify(tokens[i])
+			}
+		}()
+	}
+	for i := range tokens {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return toks, errs
+}
@@ -0,0 +1,90 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// batchTestTokens returns a verifier and n tokens signed for it, where
+// every third token is expired and every fifth one is garbage.
+func batchTestTokens(t testing.TB, n int) (Verifier, []string) {
+	dir := newTestKeypairDir(t)
+	signer, err := NewSigner(dir, SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	verifier, err := NewVerifier(dir)
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+
+	tokens := make([]string, n)
+	for i := range tokens {
+		tok := validTestToken()
+		if i%3 == 0 {
+			tok = expiredTestToken()
+		}
+		tok.Username = fmt.Sprintf("user%d", i)
+		s, err := signer.Sign(tok)
+		if err != nil {
+			t.Fatalf("signing token: %v", err)
+		}
+		if i%5 == 0 {
+			s = "garbage"
+		}
+		tokens[i] = s
+	}
+	return verifier, tokens
+}
+
+func TestVerifyBatch(t *testing.T) {
+	verifier, tokens := batchTestTokens(t, 50)
+
+	toks, errs := VerifyBatch(verifier, tokens)
+	if len(toks) != len(tokens) || len(errs) != len(tokens) {
+		t.Fatalf("expected %d results, got %d tokens and %d errors", len(tokens), len(toks), len(errs))
+	}
+	for i := range tokens {
+		switch {
+		case i%5 == 0:
+			if FailureReason(errs[i]) != ReasonMalformed || toks[i] != nil {
+				t.Errorf("token %d: expected a malformed token error, got %v", i, errs[i])
+			}
+		case i%3 == 0:
+			if !errors.Is(errs[i], ErrTokenExpired) || toks[i] != nil {
+				t.Errorf("token %d: expected ErrTokenExpired, got %v", i, errs[i])
+			}
+		default:
+			if errs[i] != nil {
+				t.Errorf("token %d: expected token to verify: %v", i, errs[i])
+				continue
+			}
+			if expected := fmt.Sprintf("user%d", i); toks[i].Username != expected {
+				t.Errorf("token %d: expected username %q, got %q", i, expected, toks[i].Username)
+			}
+		}
+	}
+
+	if toks, errs := VerifyBatch(verifier, nil); len(toks) != 0 || len(errs) != 0 {
+		t.Errorf("expected no results for an empty batch, got %v, %v", toks, errs)
+	}
+}
+
+func BenchmarkVerifySequential(b *testing.B) {
+	verifier, tokens := batchTestTokens(b, 100)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, s := range tokens {
+			verifier.Verify(s)
+		}
+	}
+}
+
+func BenchmarkVerifyBatch(b *testing.B) {
+	verifier, tokens := batchTestTokens(b, 100)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		VerifyBatch(verifier, tokens)
+	}
+}
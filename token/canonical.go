@@ -0,0 +1,31 @@
+package token
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// ErrNonCanonicalPayload is returned by Verify for tokens whose payload
+// isn't serialized exactly as a signer serializes it: compact JSON with
+// the fields in AuthToken order, assertions sorted by name and the
+// encoding/json escaping. Such a payload has a valid signature but was
+// produced by something else, so it is refused rather than risk two
+// serializations, e.g. with duplicate or differently cased keys, being
+// read differently by different parsers.
+var ErrNonCanonicalPayload = errors.New("token payload isn't in canonical form")
+
+// checkCanonical returns an error wrapping ErrNonCanonicalPayload unless
+// payload is the canonical serialization of token, as decoded from it.
+// It must be called before the token is migrated, since signers of
+// earlier versions serialized it without the fields migrations add.
+func checkCanonical(payload []byte, token *AuthToken) error {
+	canonical, err := json.Marshal(token)
+	if err != nil {
+		return newVerifyError(ReasonMalformed, err)
+	}
+	if !bytes.Equal(payload, canonical) {
+		return newVerifyError(ReasonMalformed, ErrNonCanonicalPayload)
+	}
+	return nil
+}
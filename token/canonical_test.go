@@ -0,0 +1,144 @@
+package token
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+// signTestPayload signs payload as is.
+func signTestPayload(t *testing.T, priv *ecdsa.PrivateKey, payload []byte) string {
+	signer, err := jose.NewSigner(curveJose, priv)
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	s, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatalf("serializing token: %v", err)
+	}
+	return s
+}
+
+func TestCanonicalPayload(t *testing.T) {
+	priv, _ := newTestKey(t, "")
+	v := &ecdsaVerifier{publicKey: &priv.PublicKey}
+	tok := validTestToken()
+	tok.Assertions = map[string]string{"ldapServer": "ldap.example.com", "amr": "ldap-bind"}
+	canonical, err := marshalToken(tok)
+	if err != nil {
+		t.Fatalf("marshalling token: %v", err)
+	}
+	if _, err := v.Verify(signTestPayload(t, priv, canonical)); err != nil {
+		t.Fatalf("expected the canonical payload to verify, got %v", err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(canonical, &fields); err != nil {
+		t.Fatalf("decoding payload: %v", err)
+	}
+	// Marshalling a map sorts the keys, unlike the struct.
+	reordered, _ := json.Marshal(fields)
+	indented, _ := json.MarshalIndent(tok, "", "  ")
+
+	cases := []struct {
+		name    string
+		payload []byte
+	}{
+		{name: "reordered keys", payload: reordered},
+		{name: "indented", payload: indented},
+		{name: "trailing newline", payload: append(append([]byte{}, canonical...), '\n')},
+		{name: "space after colons", payload: bytes.Replace(canonical, []byte(`":`), []byte(`": `), -1)},
+		{name: "escaped characters", payload: bytes.Replace(canonical, []byte(`"alice"`), []byte(`"\u0061lice"`), 1)},
+		{name: "lowercase key", payload: bytes.Replace(canonical, []byte(`"Username"`), []byte(`"username"`), 1)},
+		// Parsers disagree on which of duplicate keys wins.
+		{name: "duplicate key", payload: bytes.Replace(canonical, []byte(`{"Username":"alice"`), []byte(`{"Username":"alice","Username":"admin"`), 1)},
+		{name: "unknown field", payload: bytes.Replace(canonical, []byte(`{`), []byte(`{"Admin":true,`), 1)},
+	}
+	for _, c := range cases {
+		if bytes.Equal(c.payload, canonical) {
+			t.Fatalf("%s: expected the payload to differ from %s", c.name, canonical)
+		}
+		signed := signTestPayload(t, priv, c.payload)
+		_, err := v.Verify(signed)
+		if !errors.Is(err, ErrNonCanonicalPayload) || FailureReason(err) != ReasonMalformed {
+			t.Errorf("%s: expected a non-canonical payload error, got %v", c.name, err)
+		}
+		if _, _, err := v.Inspect(signed); !errors.Is(err, ErrNonCanonicalPayload) {
+			t.Errorf("%s: expected inspecting to fail with a non-canonical payload error, got %v", c.name, err)
+		}
+	}
+}
+
+// TestPreviousReleasePayloads checks that tokens signed by the previous
+// release, whose AuthToken had only the first four fields, are still in
+// canonical form. The payloads are pinned as that release's json.Marshal
+// wrote them, rather than built from today's struct.
+func TestPreviousReleasePayloads(t *testing.T) {
+	priv, _ := newTestKey(t, "")
+	v := &ecdsaVerifier{publicKey: &priv.PublicKey}
+	expiration := time.Now().Add(time.Hour).UnixNano() / int64(time.Millisecond)
+
+	payloads := []string{
+		`{"Username":"alice","Groups":["developers","viewers"],"Assertions":{"ldapServer":"ldap.example.com","userDN":"uid=alice,dc=example,dc=com"},"Expiration":%d}`,
+		// No groups or assertions, and characters encoding/json escapes.
+		`{"Username":"bob","Groups":null,"Assertions":null,"Expiration":%d}`,
+		`{"Username":"carol","Groups":["r\u0026d"],"Assertions":{"userDN":"cn=carol \u003cc@example.com\u003e,dc=example,dc=com"},"Expiration":%d}`,
+	}
+	for _, format := range payloads {
+		payload := fmt.Sprintf(format, expiration)
+		tok, err := v.Verify(signTestPayload(t, priv, []byte(payload)))
+		if err != nil {
+			t.Errorf("expected the previous release's payload %s to verify, got %v", payload, err)
+			continue
+		}
+		if tok.Version != CurrentVersion || tok.Type != TypeAccess || tok.Expiration != expiration {
+			t.Errorf("expected %s to be migrated to a v%d access token, got %+v", payload, CurrentVersion, tok)
+		}
+	}
+}
+
+func TestSignerPayloadIsCanonical(t *testing.T) {
+	tok := validTestToken()
+	tok.Assertions = map[string]string{}
+	for _, name := range strings.Fields("z y x w v u t s r q p o n m l k j i h g f e d c b a") {
+		tok.Assertions[name] = "<" + name + ">"
+	}
+	first, err := marshalToken(tok)
+	if err != nil {
+		t.Fatalf("marshalling token: %v", err)
+	}
+	compact := &bytes.Buffer{}
+	if err := json.Compact(compact, first); err != nil || !bytes.Equal(compact.Bytes(), first) {
+		t.Errorf("expected a compact payload, got %s", first)
+	}
+	for i := 0; i < 10; i++ {
+		again, _ := marshalToken(tok)
+		if !bytes.Equal(again, first) {
+			t.Fatalf("expected the same payload every time, got %s and %s", first, again)
+		}
+	}
+
+	// Compressed payloads are checked once inflated.
+	signer, verifier, err := NewEphemeralSigner(SignerOptions{CompressionThreshold: 1})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	signed, err := signer.Sign(tok)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	if _, err := verifier.Verify(signed); err != nil {
+		t.Errorf("expected the compressed token to verify, got %v", err)
+	}
+}
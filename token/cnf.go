@@ -0,0 +1,46 @@
+package token
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// ErrCertMismatch is returned for a token bound to a client certificate
+// that isn't the one of the connection it was presented over.
+var ErrCertMismatch = errors.New("token is bound to another client certificate")
+
+// Confirmation binds a token to its holder's key (RFC 7800), as in the
+// cnf claim of certificate-bound tokens (RFC 8705).
+type Confirmation struct {
+	// X5tS256 is the CertThumbprint of the client certificate the token
+	// is bound to.
+	X5tS256 string `json:"x5t#S256,omitempty"`
+}
+
+// CertThumbprint returns the base64url encoded SHA-256 hash of the DER
+// encoding of cert.
+func CertThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// CheckCertBinding verifies that a token bound to a client certificate
+// is presented over a connection authenticated with that certificate,
+// the first of peerCertificates. Tokens that aren't bound pass.
+func CheckCertBinding(token *AuthToken, peerCertificates []*x509.Certificate) error {
+	if token.Confirmation == nil || token.Confirmation.X5tS256 == "" {
+		return nil
+	}
+	if len(peerCertificates) == 0 {
+		return newVerifyError(ReasonCertMismatch, fmt.Errorf("%w: no client certificate was presented", ErrCertMismatch))
+	}
+	thumbprint := CertThumbprint(peerCertificates[0])
+	if subtle.ConstantTimeCompare([]byte(thumbprint), []byte(token.Confirmation.X5tS256)) != 1 {
+		return newVerifyError(ReasonCertMismatch, ErrCertMismatch)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package token
+
+import (
+	"crypto/x509"
+	"errors"
+	"testing"
+)
+
+func TestCheckCertBinding(t *testing.T) {
+	// Only the DER encoding of a certificate is hashed.
+	alice := &x509.Certificate{Raw: []byte("alice's certificate")}
+	mallory := &x509.Certificate{Raw: []byte("mallory's certificate")}
+	bound := &AuthToken{Username: "alice", Confirmation: &Confirmation{X5tS256: CertThumbprint(alice)}}
+	unbound := &AuthToken{Username: "alice"}
+
+	cases := []struct {
+		name      string
+		tok       *AuthToken
+		certs     []*x509.Certificate
+		expectErr bool
+	}{
+		{name: "matching certificate", tok: bound, certs: []*x509.Certificate{alice}},
+		{name: "other certificate", tok: bound, certs: []*x509.Certificate{mallory}, expectErr: true},
+		{name: "no certificate", tok: bound, expectErr: true},
+		{name: "unbound token without a certificate", tok: unbound},
+		{name: "unbound token with a certificate", tok: unbound, certs: []*x509.Certificate{mallory}},
+	}
+	for _, c := range cases {
+		err := CheckCertBinding(c.tok, c.certs)
+		if !c.expectErr {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", c.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrCertMismatch) || FailureReason(err) != ReasonCertMismatch {
+			t.Errorf("%s: expected ErrCertMismatch, got %v", c.name, err)
+		}
+	}
+}
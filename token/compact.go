@@ -0,0 +1,34 @@
+package token
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxHeaderSize bounds the encoded protected header of a token. Signers
+// here produce headers of at most a few hundred bytes, so larger ones are
+// rejected before they are decoded.
+const maxHeaderSize = 4 << 10
+
+// checkCompactJWS rejects s unless it is a compact JWS, with a protected
+// header of at most maxHeaderSize. go-jose also parses the JSON
+// serializations, whose unprotected headers aren't covered by the
+// signature, and which no signer here produces.
+func checkCompactJWS(s string) error {
+	return checkCompact(s, 3, "JWS")
+}
+
+// checkCompactJWE is checkCompactJWS for encrypted tokens.
+func checkCompactJWE(s string) error {
+	return checkCompact(s, 5, "JWE")
+}
+
+func checkCompact(s string, segments int, kind string) error {
+	if strings.Count(s, ".") != segments-1 {
+		return fmt.Errorf("not a compact %s", kind)
+	}
+	if i := strings.Index(s, "."); i > maxHeaderSize {
+		return fmt.Errorf("token header exceeds %d bytes", maxHeaderSize)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package token
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestNonCompactTokens(t *testing.T) {
+	dir := newTestKeypairDir(t)
+	signer, err := NewSigner(dir, SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	verifier, err := NewVerifier(dir)
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	signed, err := signer.Sign(validTestToken())
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	parts := strings.Split(signed, ".")
+	header, payload, signature := parts[0], parts[1], parts[2]
+	// A header of maxHeaderSize, once encoded, with padding in the kid.
+	padding := strings.Repeat("k", maxHeaderSize*3/4-len(`{"alg":"ES256","kid":""}`))
+	bigHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES256","kid":"` + padding + `"}`))
+
+	for name, s := range map[string]string{
+		// The same signature, in the JSON serializations.
+		"flattened JSON":   `{"payload":"` + payload + `","protected":"` + header + `","signature":"` + signature + `"}`,
+		"general JSON":     `{"payload":"` + payload + `","signatures":[{"protected":"` + header + `","signature":"` + signature + `"}]}`,
+		"oversized header": bigHeader + "k." + payload + "." + signature,
+	} {
+		token, err := verifier.Verify(s)
+		if token != nil || FailureReason(err) != ReasonMalformed {
+			t.Errorf("%s: expected the token to be rejected as malformed, got %+v, %v", name, token, err)
+		}
+	}
+
+	encryptionDir := newTestKeypairDir(t)
+	encSigner, err := NewEncryptingSigner(signer, encryptionDir)
+	if err != nil {
+		t.Fatalf("creating encrypting signer: %v", err)
+	}
+	decVerifier, err := NewDecryptingVerifier(verifier, encryptionDir)
+	if err != nil {
+		t.Fatalf("creating decrypting verifier: %v", err)
+	}
+	encrypted, err := encSigner.Sign(validTestToken())
+	if err != nil {
+		t.Fatalf("encrypting token: %v", err)
+	}
+	parts = strings.Split(encrypted, ".")
+	flattened := `{"protected":"` + parts[0] + `","encrypted_key":"` + parts[1] + `","iv":"` + parts[2] + `","ciphertext":"` + parts[3] + `","tag":"` + parts[4] + `"}`
+	if token, err := decVerifier.Verify(flattened); token != nil || FailureReason(err) != ReasonMalformed {
+		t.Errorf("expected an encrypted token in the JSON serialization to be rejected as malformed, got %+v, %v", token, err)
+	}
+	if _, err := decVerifier.Verify(encrypted); err != nil {
+		t.Errorf("expected the compact encrypted token to verify, got %v", err)
+	}
+
+	// A header of maxHeaderSize is still parsed, and fails on its
+	// signature.
+	if len(bigHeader) != maxHeaderSize {
+		t.Fatalf("expected a header of %d bytes, got %d", maxHeaderSize, len(bigHeader))
+	}
+	if _, err := verifier.Verify(bigHeader + "." + payload + "." + signature); FailureReason(err) != ReasonBadSignature {
+		t.Errorf("expected a header of %d bytes to be parsed, got %v", maxHeaderSize, err)
+	}
+}
@@ -0,0 +1,64 @@
+package token
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// zipDeflate is the "zip" protected header parameter of DEFLATE
+// compressed payloads, as registered for JOSE in RFC 7516 section 4.1.3.
+// go-jose v1 can't set it on a JWS, which is why our signers serialize
+// the compact JWS themselves.
+const zipDeflate = "DEF"
+
+// maxDecompressedPayloadSize bounds inflation of a verified payload.
+const maxDecompressedPayloadSize = 1 << 20
+
+// compressPayload DEFLATE compresses a serialized token.
+func compressPayload(payload []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w, err := flate.NewWriter(buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressPayload inflates the verified payload of the compact JWS s
+// if its protected header says it is compressed, and returns it
+// unchanged otherwise. Compression algorithms other than DEFLATE are
+// rejected.
+func decompressPayload(s string, payload []byte) ([]byte, error) {
+	header, err := parseHeader(s)
+	if err != nil {
+		return nil, newVerifyError(ReasonMalformed, err)
+	}
+	zip, ok := header.Params["zip"]
+	if !ok {
+		return payload, nil
+	}
+	if zip != zipDeflate {
+		return nil, newVerifyError(ReasonMalformed, fmt.Errorf("unsupported token payload compression %v", zip))
+	}
+
+	r := flate.NewReader(bytes.NewReader(payload))
+	defer r.Close()
+
+	inflated, err := ioutil.ReadAll(io.LimitReader(r, maxDecompressedPayloadSize+1))
+	if err != nil {
+		return nil, newVerifyError(ReasonMalformed, fmt.Errorf("decompressing token payload: %v", err))
+	}
+	if len(inflated) > maxDecompressedPayloadSize {
+		return nil, newVerifyError(ReasonMalformed, fmt.Errorf("decompressed token payload exceeds %d bytes", maxDecompressedPayloadSize))
+	}
+	return inflated, nil
+}
@@ -0,0 +1,93 @@
+package token
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDetachedVerifier(t *testing.T) {
+	dir := newTestKeypairDir(t)
+	verifier, err := NewDetachedVerifier(dir)
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+
+	for _, opts := range []SignerOptions{{}, {CompressionThreshold: 1}} {
+		signer, err := NewSigner(dir, opts)
+		if err != nil {
+			t.Fatalf("creating signer: %v", err)
+		}
+		tok := validTestToken()
+		tok.Groups = []string{"engineering"}
+		signed, err := signer.Sign(tok)
+		if err != nil {
+			t.Fatalf("signing token: %v", err)
+		}
+
+		detached, payload, err := Detach(signed)
+		if err != nil {
+			t.Fatalf("detaching token: %v", err)
+		}
+		if !strings.Contains(detached, "..") {
+			t.Errorf("expected an empty payload in the detached JWS, got %q", detached)
+		}
+		verified, err := verifier.VerifyDetached(detached, payload)
+		if err != nil {
+			t.Fatalf("compression %d: expected the detached token to verify: %v", opts.CompressionThreshold, err)
+		}
+		if verified.Username != "alice" || len(verified.Groups) != 1 {
+			t.Errorf("compression %d: expected the signed token back, got %+v", opts.CompressionThreshold, verified)
+		}
+
+		cases := []struct {
+			name     string
+			detached string
+			payload  []byte
+			reason   string
+		}{
+			{name: "other payload", detached: detached, payload: []byte(`{"Username":"mallory"}`), reason: ReasonBadSignature},
+			{name: "attached payload", detached: signed, payload: payload, reason: ReasonMalformed},
+			{name: "not a JWS", detached: "garbage", payload: payload, reason: ReasonMalformed},
+		}
+		for _, c := range cases {
+			if _, err := verifier.VerifyDetached(c.detached, c.payload); FailureReason(err) != c.reason {
+				t.Errorf("%s: expected reason %q, got %v", c.name, c.reason, err)
+			}
+		}
+	}
+
+	signer, err := NewSigner(dir, SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	signed, err := signer.Sign(expiredTestToken())
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	detached, payload, _ := Detach(signed)
+	if _, err := verifier.VerifyDetached(detached, payload); FailureReason(err) != ReasonExpired {
+		t.Errorf("expected an expired detached token to be rejected, got %v", err)
+	}
+}
+
+func TestJWKSDetachedVerifier(t *testing.T) {
+	priv, pub := newTestKey(t, "key-1")
+	fake := &fakeJWKS{}
+	fake.setKeys(pub)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	v, err := NewJWKSVerifier(srv.URL, time.Hour, FetchOptions{})
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	detached, payload, err := Detach(signTestToken(t, priv, "key-1", validTestToken()))
+	if err != nil {
+		t.Fatalf("detaching token: %v", err)
+	}
+	if _, err := v.(DetachedVerifier).VerifyDetached(detached, payload); err != nil {
+		t.Errorf("expected the detached token to verify: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Signature algorithms of keypairs.
+const (
+	// AlgorithmES256 is ECDSA with P-256, the default.
+	AlgorithmES256 = "ES256"
+	// AlgorithmEdDSA is Ed25519 (RFC 8037).
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// CheckAlgorithm returns an error unless algorithm is one of the
+// Algorithm values, or empty for the default.
+func CheckAlgorithm(algorithm string) error {
+	switch algorithm {
+	case "", AlgorithmES256, AlgorithmEdDSA:
+		return nil
+	}
+	return fmt.Errorf("unknown signature algorithm %q, expected %s or %s", algorithm, AlgorithmES256, AlgorithmEdDSA)
+}
+
+// GenerateKeypairForAlgorithm generates a keypair for algorithm in
+// dirname. EdDSA keys are stored as PKCS #8 and PKIX DER.
+func GenerateKeypairForAlgorithm(dirname, algorithm string) error {
+	if err := CheckAlgorithm(algorithm); err != nil {
+		return err
+	}
+	if algorithm != AlgorithmEdDSA {
+		return GenerateKeypair(dirname)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(getPrivateKeyFilename(dirname), privDER, os.FileMode(0600)); err != nil {
+		return err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return fmt.Errorf("Error marshalling public key: %v", err)
+	}
+	return ioutil.WriteFile(getPublicKeyFilename(dirname), pubDER, os.FileMode(0644))
+}
+
+// NewVerifierForAlgorithm reads the verification key in dirname, which
+// must be for algorithm, and returns a verifier that only accepts tokens
+// signed with that algorithm.
+func NewVerifierForAlgorithm(dirname, algorithm string) (Verifier, error) {
+	if err := CheckAlgorithm(algorithm); err != nil {
+		return nil, err
+	}
+	if algorithm != AlgorithmEdDSA {
+		return NewVerifier(dirname)
+	}
+	return newEd25519Verifier(dirname)
+}
+
+// ed25519Verifier verifies EdDSA signed compact JWS. go-jose v1 predates
+// EdDSA, so the JWS is handled here.
+type ed25519Verifier struct {
+	publicKey ed25519.PublicKey
+	loadedAt  time.Time
+}
+
+func newEd25519Verifier(dirname string) (*ed25519Verifier, error) {
+	file := getPublicKeyFilename(dirname)
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(keyDER(buf))
+	if err != nil {
+		return nil, keyFileError(file, buf, false, keyAlgorithmEdDSA, err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, keyFileError(file, buf, false, keyAlgorithmEdDSA, nil)
+	}
+	return &ed25519Verifier{publicKey: pub, loadedAt: time.Now()}, nil
+}
+
+func (ev *ed25519Verifier) Verify(s string) (*AuthToken, error) {
+	payload, err := ev.verifySignature(s)
+	if err != nil {
+		return nil, err
+	}
+	return decodeToken(payload)
+}
+
+func (ev *ed25519Verifier) Inspect(s string) (*AuthToken, bool, error) {
+	payload, err := ev.verifySignature(s)
+	if err != nil {
+		return nil, false, err
+	}
+	return inspectToken(payload)
+}
+
+// jwsHeader is the protected header of the JWS we sign.
+type jwsHeader struct {
+	Algorithm string `json:"alg"`
+	Zip       string `json:"zip,omitempty"`
+}
+
+func (ev *ed25519Verifier) verifySignature(s string) ([]byte, error) {
+	if err := checkCompactJWS(s); err != nil {
+		return nil, newVerifyError(ReasonMalformed, err)
+	}
+	parts := strings.Split(s, ".")
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, newVerifyError(ReasonMalformed, err)
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, newVerifyError(ReasonMalformed, err)
+	}
+	if header.Algorithm != AlgorithmEdDSA {
+		return nil, newVerifyError(ReasonBadSignature, fmt.Errorf("token is signed with %s, expected %s", header.Algorithm, AlgorithmEdDSA))
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, newVerifyError(ReasonMalformed, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, newVerifyError(ReasonMalformed, err)
+	}
+	if !ed25519.Verify(ev.publicKey, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, newVerifyError(ReasonBadSignature, errors.New("invalid EdDSA signature"))
+	}
+	return decompressPayload(s, payload)
+}
+
+// ed25519Signer signs tokens as EdDSA compact JWS.
+type ed25519Signer struct {
+	privateKey ed25519.PrivateKey
+	opts       SignerOptions
+}
+
+func newEd25519Signer(dirname string, opts SignerOptions) (*ed25519Signer, error) {
+	file := getPrivateKeyFilename(dirname)
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyDER(buf))
+	if err != nil {
+		return nil, keyFileError(file, buf, true, keyAlgorithmEdDSA, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, keyFileError(file, buf, true, keyAlgorithmEdDSA, nil)
+	}
+	return &ed25519Signer{privateKey: priv, opts: opts}, nil
+}
+
+func (es *ed25519Signer) Sign(token *AuthToken) (string, error) {
+	input, err := signingInput(token, AlgorithmEdDSA, es.opts)
+	if err != nil {
+		return "", err
+	}
+	signature := ed25519.Sign(es.privateKey, []byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
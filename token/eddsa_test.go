@@ -0,0 +1,119 @@
+package token
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func newTestEdDSAKeypairDir(t testing.TB) string {
+	dir, err := ioutil.TempDir("", "keypair")
+	if err != nil {
+		t.Fatalf("creating temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	if err := GenerateKeypairForAlgorithm(dir, AlgorithmEdDSA); err != nil {
+		t.Fatalf("generating keypair: %v", err)
+	}
+	return dir
+}
+
+func TestEdDSASignAndVerify(t *testing.T) {
+	dir := newTestEdDSAKeypairDir(t)
+	verifier, err := NewVerifierForAlgorithm(dir, AlgorithmEdDSA)
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+
+	for _, opts := range []SignerOptions{
+		{Algorithm: AlgorithmEdDSA},
+		{Algorithm: AlgorithmEdDSA, CompressionThreshold: 512},
+	} {
+		signer, err := NewSigner(dir, opts)
+		if err != nil {
+			t.Fatalf("creating signer: %v", err)
+		}
+		tok := largeTestToken()
+		signed, err := signer.Sign(tok)
+		if err != nil {
+			t.Fatalf("signing token: %v", err)
+		}
+		verified, err := verifier.Verify(signed)
+		if err != nil {
+			t.Fatalf("verifying token: %v", err)
+		}
+		tok.Version = CurrentVersion
+		tok.Type = TypeAccess
+		if !reflect.DeepEqual(tok, verified) {
+			t.Errorf("Expected %+v, got %+v", tok, verified)
+		}
+	}
+}
+
+func TestMismatchedAlgorithm(t *testing.T) {
+	ecDir := newTestKeypairDir(t)
+	edDir := newTestEdDSAKeypairDir(t)
+
+	ecSigner, err := NewSigner(ecDir, SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	edSigner, err := NewSigner(edDir, SignerOptions{Algorithm: AlgorithmEdDSA})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	ecVerifier, err := NewVerifierForAlgorithm(ecDir, AlgorithmES256)
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	edVerifier, err := NewVerifierForAlgorithm(edDir, AlgorithmEdDSA)
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+
+	cases := []struct {
+		name     string
+		signer   Signer
+		verifier Verifier
+	}{
+		{name: "EdDSA token, ES256 verifier", signer: edSigner, verifier: ecVerifier},
+		{name: "ES256 token, EdDSA verifier", signer: ecSigner, verifier: edVerifier},
+	}
+	for _, c := range cases {
+		signed, err := c.signer.Sign(validTestToken())
+		if err != nil {
+			t.Fatalf("%s: signing token: %v", c.name, err)
+		}
+		_, err = c.verifier.Verify(signed)
+		if err == nil {
+			t.Errorf("%s: Expected the token to be rejected", c.name)
+			continue
+		}
+		if reason := FailureReason(err); reason != ReasonBadSignature {
+			t.Errorf("%s: Expected reason %q, got %q (%v)", c.name, ReasonBadSignature, reason, err)
+		}
+	}
+}
+
+func TestMismatchedKeypair(t *testing.T) {
+	ecDir := newTestKeypairDir(t)
+	edDir := newTestEdDSAKeypairDir(t)
+
+	if _, err := NewSigner(ecDir, SignerOptions{Algorithm: AlgorithmEdDSA}); err == nil {
+		t.Errorf("Expected an ECDSA private key to be rejected for EdDSA")
+	}
+	if _, err := NewVerifierForAlgorithm(ecDir, AlgorithmEdDSA); err == nil {
+		t.Errorf("Expected an ECDSA public key to be rejected for EdDSA")
+	}
+	if _, err := NewSigner(edDir, SignerOptions{}); err == nil {
+		t.Errorf("Expected an EdDSA private key to be rejected for ES256")
+	}
+	if _, err := NewVerifierForAlgorithm(edDir, AlgorithmES256); err == nil {
+		t.Errorf("Expected an EdDSA public key to be rejected for ES256")
+	}
+	if _, err := NewSigner(edDir, SignerOptions{Algorithm: "RS256"}); err == nil {
+		t.Errorf("Expected an unknown algorithm to be rejected")
+	}
+}
@@ -0,0 +1,62 @@
+package token
+
+import (
+	"embed"
+	"testing"
+	"testing/fstest"
+)
+
+// The embedded keypair's private key was discarded after signing
+// token.jws, which expires in 2100.
+var (
+	//go:embed testdata/embedded/signing.pub
+	embeddedPublicKey []byte
+	//go:embed testdata/embedded/token.jws
+	embeddedToken string
+	//go:embed testdata/embedded
+	embeddedKeypair embed.FS
+)
+
+func TestEmbeddedVerifier(t *testing.T) {
+	fromBytes, err := NewVerifierFromPublicKey(embeddedPublicKey)
+	if err != nil {
+		t.Fatalf("creating verifier from bytes: %v", err)
+	}
+	fromFS, err := NewVerifierFS(embeddedKeypair, "testdata/embedded")
+	if err != nil {
+		t.Fatalf("creating verifier from embed.FS: %v", err)
+	}
+
+	for name, v := range map[string]Verifier{"bytes": fromBytes, "embed.FS": fromFS} {
+		tok, err := v.Verify(embeddedToken)
+		if err != nil {
+			t.Errorf("%s: expected the token to verify, got %v", name, err)
+			continue
+		}
+		if tok.Username != "alice" || len(tok.Groups) != 1 || tok.Groups[0] != "developers" {
+			t.Errorf("%s: unexpected token %+v", name, tok)
+		}
+
+		// Tokens from other keys are still rejected.
+		signer, err := NewSigner(newTestKeypairDir(t), SignerOptions{})
+		if err != nil {
+			t.Fatalf("creating signer: %v", err)
+		}
+		other, err := signer.Sign(validTestToken())
+		if err != nil {
+			t.Fatalf("signing token: %v", err)
+		}
+		if _, err := v.Verify(other); FailureReason(err) != ReasonBadSignature {
+			t.Errorf("%s: expected a bad signature, got %v", name, err)
+		}
+	}
+}
+
+func TestEmbeddedVerifierErrors(t *testing.T) {
+	if _, err := NewVerifierFromPublicKey([]byte("not a key")); err == nil {
+		t.Errorf("expected an error for an invalid key")
+	}
+	if _, err := NewVerifierFS(fstest.MapFS{}, "keys"); err == nil {
+		t.Errorf("expected an error for a missing key")
+	}
+}
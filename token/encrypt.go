@@ -0,0 +1,126 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+// Tokens are encrypted with ECDH-ES key agreement and AES-256-GCM.
+const (
+	encryptionKeyAlgorithm = jose.ECDH_ES
+	encryptionContent      = jose.A256GCM
+)
+
+// encryptingSigner wraps signed tokens in a JWE, so that their claims
+// can only be read by whoever holds the encryption private key.
+type encryptingSigner struct {
+	signer    Signer
+	encrypter jose.Encrypter
+}
+
+// NewEncryptingSigner returns a signer that signs tokens with signer and
+// then encrypts the JWS to the public key of the keypair in dirname.
+func NewEncryptingSigner(signer Signer, dirname string) (Signer, error) {
+	publicKey, err := loadPublicKey(dirname)
+	if err != nil {
+		return nil, err
+	}
+	encrypter, err := jose.NewEncrypter(encryptionKeyAlgorithm, encryptionContent, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptingSigner{signer: signer, encrypter: encrypter}, nil
+}
+
+func (es *encryptingSigner) Sign(token *AuthToken) (string, error) {
+	signed, err := es.signer.Sign(token)
+	if err != nil {
+		return "", err
+	}
+	jwe, err := es.encrypter.Encrypt([]byte(signed))
+	if err != nil {
+		return "", err
+	}
+	return jwe.CompactSerialize()
+}
+
+// tokenDecrypter decrypts tokens made by an encryptingSigner, giving back
+// the signed token inside.
+type tokenDecrypter struct {
+	privateKey *ecdsa.PrivateKey
+}
+
+func newTokenDecrypter(dirname string) (*tokenDecrypter, error) {
+	privateKey, err := loadPrivateKey(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenDecrypter{privateKey: privateKey}, nil
+}
+
+func (td *tokenDecrypter) decrypt(s string) (string, error) {
+	if err := checkCompactJWE(s); err != nil {
+		return "", newVerifyError(ReasonMalformed, err)
+	}
+	jwe, err := jose.ParseEncrypted(s)
+	if err != nil {
+		return "", newVerifyError(ReasonMalformed, err)
+	}
+	if alg := jwe.Header.Algorithm; alg != string(encryptionKeyAlgorithm) {
+		return "", newVerifyError(ReasonMalformed, fmt.Errorf("unexpected key management algorithm %q", alg))
+	}
+	signed, err := jwe.Decrypt(td.privateKey)
+	if err != nil {
+		return "", newVerifyError(ReasonUndecryptable, err)
+	}
+	return string(signed), nil
+}
+
+type decryptingVerifier struct {
+	*tokenDecrypter
+	verifier Verifier
+}
+
+// NewDecryptingVerifier returns a verifier that decrypts tokens with the
+// private key of the keypair in dirname, then verifies the signed token
+// inside with verifier. Tokens that aren't encrypted are rejected.
+func NewDecryptingVerifier(verifier Verifier, dirname string) (Verifier, error) {
+	td, err := newTokenDecrypter(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingVerifier{tokenDecrypter: td, verifier: verifier}, nil
+}
+
+func (dv *decryptingVerifier) Verify(s string) (*AuthToken, error) {
+	signed, err := dv.decrypt(s)
+	if err != nil {
+		return nil, err
+	}
+	return dv.verifier.Verify(signed)
+}
+
+type decryptingInspector struct {
+	*tokenDecrypter
+	inspector Inspector
+}
+
+// NewDecryptingInspector is the Inspector counterpart of
+// NewDecryptingVerifier.
+func NewDecryptingInspector(inspector Inspector, dirname string) (Inspector, error) {
+	td, err := newTokenDecrypter(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingInspector{tokenDecrypter: td, inspector: inspector}, nil
+}
+
+func (di *decryptingInspector) Inspect(s string) (*AuthToken, bool, error) {
+	signed, err := di.decrypt(s)
+	if err != nil {
+		return nil, false, err
+	}
+	return di.inspector.Inspect(signed)
+}
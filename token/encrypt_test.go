@@ -0,0 +1,116 @@
+package token
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedTokens(t *testing.T) {
+	signingDir, encryptionDir := newTestKeypairDir(t), newTestKeypairDir(t)
+	signer, err := NewSigner(signingDir, SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	verifier, err := NewVerifier(signingDir)
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+
+	encSigner, err := NewEncryptingSigner(signer, encryptionDir)
+	if err != nil {
+		t.Fatalf("creating encrypting signer: %v", err)
+	}
+	decVerifier, err := NewDecryptingVerifier(verifier, encryptionDir)
+	if err != nil {
+		t.Fatalf("creating decrypting verifier: %v", err)
+	}
+
+	tok := validTestToken()
+	tok.Assertions = map[string]string{"email": "alice.smith@example.com"}
+	encrypted, err := encSigner.Sign(tok)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+
+	// A compact JWE has five parts, none of which reveal the claims.
+	parts := strings.Split(encrypted, ".")
+	if len(parts) != 5 {
+		t.Fatalf("expected a compact JWE, got %d parts", len(parts))
+	}
+	for _, part := range parts {
+		decoded, _ := base64.RawURLEncoding.DecodeString(part)
+		if strings.Contains(string(decoded), "alice") {
+			t.Errorf("expected the claims to be encrypted, found them in %q", decoded)
+		}
+	}
+
+	got, err := decVerifier.Verify(encrypted)
+	if err != nil {
+		t.Fatalf("verifying encrypted token: %v", err)
+	}
+	if got.Username != "alice" || got.Assertions["email"] != "alice.smith@example.com" {
+		t.Errorf("unexpected claims %+v", got)
+	}
+
+	// The signed token inside is only accepted encrypted, and the plain
+	// verifier doesn't accept the encrypted one.
+	signed, err := signer.Sign(tok)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	if _, err := decVerifier.Verify(signed); FailureReason(err) != ReasonMalformed {
+		t.Errorf("expected a signed-only token to be rejected as malformed, got %v", err)
+	}
+	if _, err := verifier.Verify(encrypted); err == nil {
+		t.Errorf("expected the signature verifier to reject an encrypted token")
+	}
+
+	// Another encryption key can't decrypt the token.
+	wrongKey, err := NewDecryptingVerifier(verifier, newTestKeypairDir(t))
+	if err != nil {
+		t.Fatalf("creating decrypting verifier: %v", err)
+	}
+	if _, err := wrongKey.Verify(encrypted); FailureReason(err) != ReasonUndecryptable {
+		t.Errorf("expected decryption with the wrong key to fail, got %v", err)
+	}
+
+	// Encryption doesn't stand in for the signature.
+	otherSigner, err := NewSigner(newTestKeypairDir(t), SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	forgedSigner, err := NewEncryptingSigner(otherSigner, encryptionDir)
+	if err != nil {
+		t.Fatalf("creating encrypting signer: %v", err)
+	}
+	forged, err := forgedSigner.Sign(tok)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	if _, err := decVerifier.Verify(forged); FailureReason(err) != ReasonBadSignature {
+		t.Errorf("expected a token signed with another key to be rejected, got %v", err)
+	}
+
+	// Expired encrypted tokens can still be inspected.
+	expired, err := encSigner.Sign(expiredTestToken())
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	if _, err := decVerifier.Verify(expired); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("expected the expired token to be rejected, got %v", err)
+	}
+	inspector, err := NewInspector(signingDir)
+	if err != nil {
+		t.Fatalf("creating inspector: %v", err)
+	}
+	decInspector, err := NewDecryptingInspector(inspector, encryptionDir)
+	if err != nil {
+		t.Fatalf("creating decrypting inspector: %v", err)
+	}
+	inspected, isExpired, err := decInspector.Inspect(expired)
+	if err != nil || !isExpired || inspected.Username != "alice" {
+		t.Errorf("expected expired claims for alice, got %+v, %t, %v", inspected, isExpired, err)
+	}
+}
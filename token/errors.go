@@ -0,0 +1,73 @@
+package token
+
+import "errors"
+
+// Reasons a token fails verification, as returned by VerifyError.Reason.
+const (
+	// ReasonMalformed tokens couldn't be parsed or lack required claims.
+	ReasonMalformed = "malformed"
+	// ReasonBadSignature tokens aren't signed by a trusted key.
+	ReasonBadSignature = "bad_signature"
+	// ReasonUndecryptable encrypted tokens can't be decrypted with our
+	// key.
+	ReasonUndecryptable = "undecryptable"
+	// ReasonExpired tokens are past their expiration time.
+	ReasonExpired = "expired"
+	// ReasonNotYetValid tokens have a not-before time in the future.
+	ReasonNotYetValid = "not_yet_valid"
+	// ReasonWrongIssuer tokens come from another OIDC issuer.
+	ReasonWrongIssuer = "wrong_issuer"
+	// ReasonWrongAudience tokens were issued for another OIDC client.
+	ReasonWrongAudience = "wrong_audience"
+	// ReasonTooOld tokens were issued longer ago than the maximum age.
+	ReasonTooOld = "too_old"
+	// ReasonIssuedInFuture tokens claim to be issued further in the
+	// future than the allowed clock skew.
+	ReasonIssuedInFuture = "issued_in_future"
+	// ReasonCertMismatch tokens are bound to a client certificate other
+	// than the presenting connection's.
+	ReasonCertMismatch = "cert_mismatch"
+	// ReasonMissingAssertion tokens lack an assertion that is required.
+	ReasonMissingAssertion = "missing_assertion"
+	// ReasonUnknownVersion tokens are of a newer format version than we
+	// understand.
+	ReasonUnknownVersion = "unknown_version"
+	// ReasonUnknown is returned by FailureReason for unclassified errors.
+	ReasonUnknown = "unknown"
+)
+
+// VerifyError is the error returned by Verify, classifying why the token
+// was rejected for metrics and logs.
+type VerifyError struct {
+	reason string
+	Err    error
+}
+
+func newVerifyError(reason string, err error) *VerifyError {
+	return &VerifyError{reason: reason, Err: err}
+}
+
+func (e *VerifyError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error, so errors.Is(err, ErrTokenExpired)
+// keeps working.
+func (e *VerifyError) Unwrap() error {
+	return e.Err
+}
+
+// Reason is one of the Reason constants.
+func (e *VerifyError) Reason() string {
+	return e.reason
+}
+
+// FailureReason returns the reason of a VerifyError anywhere in err's
+// chain, or ReasonUnknown.
+func FailureReason(err error) string {
+	var verifyErr *VerifyError
+	if errors.As(err, &verifyErr) {
+		return verifyErr.Reason()
+	}
+	return ReasonUnknown
+}
@@ -0,0 +1,105 @@
+package token
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFailureReason(t *testing.T) {
+	priv, pub := newTestKey(t, "key-1")
+	otherPriv, _ := newTestKey(t, "key-1")
+	fake := &fakeJWKS{}
+	fake.setKeys(pub)
+	srv := newFakeOIDCProvider(t, fake)
+	defer srv.Close()
+
+	jv, err := newJWKSVerifier(srv.URL+"/keys", time.Hour, FetchOptions{})
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	ov, err := NewOIDCVerifier(srv.URL, "kubernetes", OIDCPrefixes{}, time.Hour, FetchOptions{})
+	if err != nil {
+		t.Fatalf("creating OIDC verifier: %v", err)
+	}
+
+	now := time.Now()
+	idToken := func(mutate func(claims map[string]interface{})) string {
+		claims := map[string]interface{}{
+			"iss":                srv.URL,
+			"aud":                "kubernetes",
+			"exp":                now.Unix() + 3600,
+			"preferred_username": "alice@example.com",
+		}
+		mutate(claims)
+		return signTestClaims(t, priv, "key-1", claims)
+	}
+	old := validTestToken()
+	old.IssuedAt = now.Add(-48*time.Hour).UnixNano() / int64(time.Millisecond)
+	future := validTestToken()
+	future.Version = CurrentVersion + 1
+	issuedLater := validTestToken()
+	issuedLater.IssuedAt = now.Add(time.Hour).UnixNano() / int64(time.Millisecond)
+
+	cases := []struct {
+		name     string
+		verifier Verifier
+		token    string
+		reason   string
+	}{
+		{name: "not a JWS", verifier: jv, token: "garbage", reason: ReasonMalformed},
+		{name: "payload isn't a token", verifier: jv, token: signTestClaims(t, priv, "key-1", "garbage"), reason: ReasonMalformed},
+		{name: "Bearer prefix", verifier: jv, token: "Bearer " + signTestToken(t, priv, "key-1", validTestToken()), reason: ReasonMalformed},
+		{name: "untrusted key", verifier: jv, token: signTestToken(t, otherPriv, "key-1", validTestToken()), reason: ReasonBadSignature},
+		{name: "unknown kid", verifier: jv, token: signTestToken(t, priv, "key-2", validTestToken()), reason: ReasonBadSignature},
+		{name: "expired", verifier: jv, token: signTestToken(t, priv, "key-1", expiredTestToken()), reason: ReasonExpired},
+		{name: "too old", verifier: NewMaxAgeVerifier(jv, 24*time.Hour), token: signTestToken(t, priv, "key-1", old), reason: ReasonTooOld},
+		{name: "issued in the future", verifier: NewIssuedAtSkewVerifier(jv, time.Minute), token: signTestToken(t, priv, "key-1", issuedLater), reason: ReasonIssuedInFuture},
+		{name: "unknown version", verifier: jv, token: signTestToken(t, priv, "key-1", future), reason: ReasonUnknownVersion},
+		{name: "missing required assertion", verifier: NewRequiredAssertionsVerifier(jv, []string{"email"}), token: signTestToken(t, priv, "key-1", validTestToken()), reason: ReasonMissingAssertion},
+		{name: "ID token wrong issuer", verifier: ov, token: idToken(func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }), reason: ReasonWrongIssuer},
+		{name: "ID token wrong audience", verifier: ov, token: idToken(func(c map[string]interface{}) { c["aud"] = "other" }), reason: ReasonWrongAudience},
+		{name: "ID token expired", verifier: ov, token: idToken(func(c map[string]interface{}) { c["exp"] = now.Unix() - 60 }), reason: ReasonExpired},
+		{name: "ID token not yet valid", verifier: ov, token: idToken(func(c map[string]interface{}) { c["nbf"] = now.Unix() + 600 }), reason: ReasonNotYetValid},
+		{name: "ID token without a username", verifier: ov, token: idToken(func(c map[string]interface{}) { delete(c, "preferred_username") }), reason: ReasonMalformed},
+	}
+
+	for _, c := range cases {
+		_, err := c.verifier.Verify(c.token)
+		if err == nil {
+			t.Errorf("%s: expected token to be rejected", c.name)
+			continue
+		}
+		if reason := FailureReason(err); reason != c.reason {
+			t.Errorf("%s: expected reason %q, got %q (%v)", c.name, c.reason, reason, err)
+		}
+	}
+
+	// The ECDSA verifier classifies its failures the same way.
+	dir := newTestKeypairDir(t)
+	signer, err := NewSigner(dir, SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	verifier, err := NewVerifier(dir)
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	expired, err := signer.Sign(expiredTestToken())
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	for s, reason := range map[string]string{
+		"garbage": ReasonMalformed,
+		signTestToken(t, priv, "", validTestToken()): ReasonBadSignature,
+		expired: ReasonExpired,
+	} {
+		if _, err := verifier.Verify(s); FailureReason(err) != reason {
+			t.Errorf("expected reason %q, got %q (%v)", reason, FailureReason(err), err)
+		}
+	}
+
+	if reason := FailureReason(errors.New("other")); reason != ReasonUnknown {
+		t.Errorf("expected reason %q for an unclassified error, got %q", ReasonUnknown, reason)
+	}
+}
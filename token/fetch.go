@@ -0,0 +1,79 @@
+package token
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// defaultFetchTimeout bounds fetches of remote keys when FetchOptions
+// sets no timeout.
+const defaultFetchTimeout = 10 * time.Second
+
+// maxFetchSize bounds the responses read by fetches, so that a
+// misbehaving endpoint can't exhaust our memory. Key sets and discovery
+// documents are a few kilobytes.
+const maxFetchSize = 1 << 20
+
+// FetchOptions configures how remote keys and OIDC discovery documents
+// are fetched.
+type FetchOptions struct {
+	// Timeout bounds each attempt. Defaults to 10 seconds.
+	Timeout time.Duration
+	// Retries is how many times a failed fetch is retried, waiting
+	// RetryBackoff before the first retry and twice as long before each
+	// further one.
+	Retries      int
+	RetryBackoff time.Duration
+	// MaxKeys, if positive, is the most keys a fetched key set may hold.
+	// Larger key sets are refused and the cached keys kept, so that a
+	// misconfigured endpoint can't grow the trusted keys without bound.
+	MaxKeys int
+}
+
+func (opts FetchOptions) client() *http.Client {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultFetchTimeout
+	}
+	return &http.Client{Timeout: timeout}
+}
+
+// fetch gets url, retrying failed attempts as configured by opts, and
+// returns the response body and headers.
+func fetch(client *http.Client, url string, opts FetchOptions) ([]byte, http.Header, error) {
+	backoff := opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		body, header, err := fetchOnce(client, url)
+		if err == nil || attempt >= opts.Retries {
+			return body, header, err
+		}
+		glog.Warningf("Error fetching %s, retrying in %v: %v", url, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func fetchOnce(client *http.Client, url string) ([]byte, http.Header, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxFetchSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(body) > maxFetchSize {
+		return nil, nil, fmt.Errorf("response is larger than %d bytes", maxFetchSize)
+	}
+	return body, resp.Header, nil
+}
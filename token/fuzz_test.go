@@ -0,0 +1,179 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// seedTokens are edge cases of the token syntax, to start fuzzing from.
+func seedTokens(t testing.TB, signed string) []string {
+	b64 := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	parts := strings.Split(signed, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected a compact JWS, got %q", signed)
+	}
+	header, payload, signature := parts[0], parts[1], parts[2]
+
+	return []string{
+		signed,
+		"",
+		".",
+		"..",
+		"...",
+		"a.b.c",
+		header,
+		header + ".",
+		header + "." + payload,
+		header + "." + payload + ".",
+		header + ".." + signature,
+		"." + payload + "." + signature,
+		header + "." + payload + "." + signature + ".",
+		header + "." + payload + "." + signature[:len(signature)/2],
+		header + "." + payload[:len(payload)-1] + "." + signature,
+		header + "=." + payload + "." + signature,
+		header + "." + payload + "!." + signature,
+		strings.ToUpper(header) + "." + payload + "." + signature,
+		b64(`{"alg":"none"}`) + "." + payload + ".",
+		b64(`{"alg":"HS256"}`) + "." + payload + "." + signature,
+		b64(`{"alg":"EdDSA"}`) + "." + payload + "." + signature,
+		b64(`{"alg":"ES256","crit":["exp"]}`) + "." + payload + "." + signature,
+		b64(`{"alg":"ES256","jwk":{"kty":"EC"}}`) + "." + payload + "." + signature,
+		b64(`{"alg":"ES256","kid":"`+strings.Repeat("k", maxHeaderSize)+`"}`) + "." + payload + "." + signature,
+		b64(`{"alg":`+strings.Repeat("[", 1<<10)+strings.Repeat("]", 1<<10)+`}`) + "." + payload + "." + signature,
+		b64(`{"alg":"ES256"}`) + "." + b64(strings.Repeat(`{"a":`, 1<<10)+"1"+strings.Repeat("}", 1<<10)) + "." + signature,
+		b64(`[]`) + "." + payload + "." + signature,
+		b64(`null`) + "." + payload + "." + signature,
+		`{}`,
+		`{"payload":"` + payload + `"}`,
+		`{"payload":"` + payload + `","signatures":[]}`,
+		`{"payload":"` + payload + `","signatures":[{}]}`,
+		`{"payload":"` + payload + `","protected":"` + header + `","signature":"` + signature + `"}`,
+		`{"payload":"` + payload + `","signatures":[{"protected":"` + header + `","signature":"` + signature + `"},{"protected":"` + header + `","signature":"` + signature + `"}]}`,
+		`{"protected":"` + header + `","encrypted_key":"","iv":"","ciphertext":"","tag":""}`,
+		"a.b.c.d.e",
+		header + "." + payload + "." + signature + "." + payload + "." + signature,
+		"\x00." + payload + "." + signature,
+		"\xff\xfe." + payload + "." + signature,
+	}
+}
+
+// FuzzVerify checks that verifiers never panic on attacker-controlled
+// tokens, and return either a token or an error, never both.
+func FuzzVerify(f *testing.F) {
+	dir := newTestKeypairDir(f)
+	signer, err := NewSigner(dir, SignerOptions{})
+	if err != nil {
+		f.Fatalf("creating signer: %v", err)
+	}
+	verifier, err := NewVerifier(dir)
+	if err != nil {
+		f.Fatalf("creating verifier: %v", err)
+	}
+	edDir := newTestEdDSAKeypairDir(f)
+	edVerifier, err := NewVerifierForAlgorithm(edDir, AlgorithmEdDSA)
+	if err != nil {
+		f.Fatalf("creating verifier: %v", err)
+	}
+	detached, err := NewDetachedVerifier(dir)
+	if err != nil {
+		f.Fatalf("creating verifier: %v", err)
+	}
+	encryptionDir := newTestKeypairDir(f)
+	encSigner, err := NewEncryptingSigner(signer, encryptionDir)
+	if err != nil {
+		f.Fatalf("creating encrypting signer: %v", err)
+	}
+	decVerifier, err := NewDecryptingVerifier(verifier, encryptionDir)
+	if err != nil {
+		f.Fatalf("creating decrypting verifier: %v", err)
+	}
+
+	signed, err := signer.Sign(validTestToken())
+	if err != nil {
+		f.Fatalf("signing token: %v", err)
+	}
+	for _, seed := range seedTokens(f, signed) {
+		f.Add(seed)
+	}
+	encrypted, err := encSigner.Sign(validTestToken())
+	if err != nil {
+		f.Fatalf("encrypting token: %v", err)
+	}
+	f.Add(encrypted)
+	f.Add(encrypted[:len(encrypted)/2])
+	f.Add(strings.Replace(encrypted, ".", "..", 1))
+
+	f.Fuzz(func(t *testing.T, s string) {
+		for name, v := range map[string]Verifier{"ES256": verifier, "EdDSA": edVerifier, "encrypted": decVerifier} {
+			token, err := v.Verify(s)
+			if (token == nil) == (err == nil) {
+				t.Errorf("%s: expected a token or an error for %q, got %+v, %v", name, s, token, err)
+			}
+			if inspector, ok := v.(Inspector); ok {
+				token, _, err = inspector.Inspect(s)
+				if (token == nil) == (err == nil) {
+					t.Errorf("%s: expected a token or an error inspecting %q, got %+v, %v", name, s, token, err)
+				}
+			}
+			token, header, err := VerifyWithHeader(v, s)
+			if err == nil && (token == nil || header == nil) {
+				t.Errorf("%s: expected a token and its header for %q, got %+v, %+v", name, s, token, header)
+			}
+		}
+		if i := strings.Index(s, "."); i >= 0 {
+			token, err := detached.VerifyDetached(s, []byte(s[i:]))
+			if (token == nil) == (err == nil) {
+				t.Errorf("detached: expected a token or an error for %q, got %+v, %v", s, token, err)
+			}
+		}
+	})
+}
+
+// FuzzVerifyPayload checks that validly signed tokens with any payload
+// are decoded without panicking.
+func FuzzVerifyPayload(f *testing.F) {
+	priv, err := ecdsa.GenerateKey(curveEll, rand.Reader)
+	if err != nil {
+		f.Fatalf("generating key: %v", err)
+	}
+	verifier, err := NewVerifierForKey(&priv.PublicKey, nil)
+	if err != nil {
+		f.Fatalf("creating verifier: %v", err)
+	}
+	for _, payload := range []string{
+		`{"usr":"alice","exp":32503680000000}`,
+		``,
+		`{}`,
+		`null`,
+		`[]`,
+		`"alice"`,
+		`{"usr":"alice","exp":"tomorrow"}`,
+		`{"usr":"alice","exp":1e400}`,
+		`{"usr":"alice","exp":32503680000000,"grp":null,"ast":{"a":null}}`,
+		`{"usr":"alice","usr":"mallory","exp":32503680000000}`,
+		`{"usr":"alice","exp":32503680000000,"ver":99}`,
+		`{"usr":"alice","exp":32503680000000,"cnf":{}}`,
+		strings.Repeat(`{"a":`, 1<<10) + "1" + strings.Repeat("}", 1<<10),
+		strings.Repeat("[", 1<<14) + strings.Repeat("]", 1<<14),
+		"\x00",
+		"\x00\x01\x02\x03",
+		"\x00" + strings.Repeat("\xff", 64),
+	} {
+		f.Add([]byte(payload))
+	}
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		s := signCompact(t, priv, `{"alg":"ES256"}`, payload)
+		token, err := verifier.Verify(s)
+		if (token == nil) == (err == nil) {
+			t.Errorf("expected a token or an error for %q, got %+v, %v", payload, token, err)
+		}
+		token, _, err = verifier.(Inspector).Inspect(s)
+		if (token == nil) == (err == nil) {
+			t.Errorf("expected a token or an error inspecting %q, got %+v, %v", payload, token, err)
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package token
+
+import (
+	"fmt"
+	"time"
+)
+
+// StaleAssertion is set to "true" on expired tokens accepted during a
+// grace period, so that they can be restricted, e.g. to read-only
+// requests.
+const StaleAssertion = "stale"
+
+// graceVerifier accepts tokens for a while after they expire, marked
+// with the StaleAssertion.
+type graceVerifier struct {
+	inspector Inspector
+	grace     time.Duration
+	// now is overridden by tests.
+	now func() time.Time
+}
+
+// NewGraceVerifier returns a verifier that accepts the tokens with a
+// valid signature according to inspector that haven't expired, or that
+// expired no more than grace ago. The latter are returned with the
+// StaleAssertion set. It smooths over tokens expiring in the middle of
+// long running operations.
+func NewGraceVerifier(inspector Inspector, grace time.Duration) Verifier {
+	return &graceVerifier{inspector: inspector, grace: grace, now: time.Now}
+}
+
+func (gv *graceVerifier) Verify(s string) (*AuthToken, error) {
+	token, _, err := gv.inspector.Inspect(s)
+	if err != nil {
+		return nil, err
+	}
+
+	expiration := time.Unix(0, token.Expiration*int64(time.Millisecond))
+	expiredFor := gv.now().Sub(expiration)
+	if expiredFor <= 0 {
+		return token, nil
+	}
+	if expiredFor > gv.grace {
+		return nil, newVerifyError(ReasonExpired, fmt.Errorf("%w: expired %v ago, past the grace period of %v", ErrTokenExpired, expiredFor.Round(time.Second), gv.grace))
+	}
+
+	assertions := make(map[string]string, len(token.Assertions)+1)
+	for k, v := range token.Assertions {
+		assertions[k] = v
+	}
+	assertions[StaleAssertion] = "true"
+	token.Assertions = assertions
+	return token, nil
+}
@@ -0,0 +1,74 @@
+package token
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGraceVerifier(t *testing.T) {
+	dir := newTestKeypairDir(t)
+	signer, err := NewSigner(dir, SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	inspector, err := NewInspector(dir)
+	if err != nil {
+		t.Fatalf("creating inspector: %v", err)
+	}
+
+	now := time.Now()
+	cases := []struct {
+		name        string
+		expiration  time.Time
+		expectStale bool
+		expectErr   bool
+	}{
+		{name: "unexpired", expiration: now.Add(time.Hour)},
+		{name: "within the grace period", expiration: now.Add(-2 * time.Minute), expectStale: true},
+		{name: "past the grace period", expiration: now.Add(-10 * time.Minute), expectErr: true},
+	}
+
+	for _, c := range cases {
+		tok := validTestToken()
+		tok.Assertions = map[string]string{"email": "alice@example.com"}
+		tok.Expiration = c.expiration.UnixNano() / int64(time.Millisecond)
+		signed, err := signer.Sign(tok)
+		if err != nil {
+			t.Fatalf("%s: signing token: %v", c.name, err)
+		}
+
+		v := NewGraceVerifier(inspector, 5*time.Minute)
+		v.(*graceVerifier).now = func() time.Time { return now }
+		verified, err := v.Verify(signed)
+		if c.expectErr {
+			if !errors.Is(err, ErrTokenExpired) || FailureReason(err) != ReasonExpired {
+				t.Errorf("%s: expected the token to be rejected as expired, got %v", c.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected the token to be accepted, got %v", c.name, err)
+			continue
+		}
+		if stale := verified.Assertions[StaleAssertion] == "true"; stale != c.expectStale {
+			t.Errorf("%s: expected stale %v, got assertions %v", c.name, c.expectStale, verified.Assertions)
+		}
+		if verified.Assertions["email"] != "alice@example.com" {
+			t.Errorf("%s: expected the token's assertions to be kept, got %v", c.name, verified.Assertions)
+		}
+	}
+
+	// The grace period doesn't excuse a bad signature.
+	other, err := NewSigner(newTestKeypairDir(t), SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	forged, err := other.Sign(expiredTestToken())
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	if _, err := NewGraceVerifier(inspector, 2*time.Hour).Verify(forged); FailureReason(err) != ReasonBadSignature {
+		t.Errorf("expected a bad signature, got %v", err)
+	}
+}
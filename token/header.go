@@ -0,0 +1,57 @@
+package token
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Header is the protected header of a verified token.
+type Header struct {
+	// Algorithm and KeyID are the alg and kid parameters, empty for a
+	// token without a kid.
+	Algorithm string
+	KeyID     string
+	// Params holds every parameter of the header, including alg, kid and
+	// any others set by the signer, as decoded from JSON.
+	Params map[string]interface{}
+	// Raw is the header's JSON as signed.
+	Raw []byte
+}
+
+// VerifyWithHeader verifies s with v like v.Verify and, if it is valid,
+// also returns its protected header, e.g. to tell which key signed it
+// during a key rotation. The signature covers the header, so it is as
+// trustworthy as the token. For encrypted tokens, it is the header of
+// the encryption.
+func VerifyWithHeader(v Verifier, s string) (*AuthToken, *Header, error) {
+	token, err := v.Verify(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	header, err := parseHeader(s)
+	if err != nil {
+		return nil, nil, newVerifyError(ReasonMalformed, err)
+	}
+	return token, header, nil
+}
+
+// parseHeader decodes the protected header of a compact JWS or JWE.
+func parseHeader(s string) (*Header, error) {
+	i := strings.Index(s, ".")
+	if i < 0 {
+		return nil, errors.New("not a compact JWS")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s[:i])
+	if err != nil {
+		return nil, err
+	}
+	header := &Header{Raw: raw}
+	if err := json.Unmarshal(raw, &header.Params); err != nil {
+		return nil, err
+	}
+	header.Algorithm, _ = header.Params["alg"].(string)
+	header.KeyID, _ = header.Params["kid"].(string)
+	return header, nil
+}
@@ -0,0 +1,120 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// signWithHeader signs tok as a compact JWS with header as its protected
+// header, as signers with custom parameters would.
+func signWithHeader(t *testing.T, priv *ecdsa.PrivateKey, header string, tok *AuthToken) string {
+	payload, err := marshalToken(tok)
+	if err != nil {
+		t.Fatalf("marshalling token: %v", err)
+	}
+	return signCompact(t, priv, header, payload)
+}
+
+// signCompact signs payload as a compact ES256 JWS with header as its
+// protected header.
+func signCompact(t testing.TB, priv *ecdsa.PrivateKey, header string, payload []byte) string {
+	signingInput := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, priv, digest[:])
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyWithHeader(t *testing.T) {
+	priv, pub := newTestKey(t, "2026-10")
+	fake := &fakeJWKS{}
+	fake.setKeys(pub)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	jwks, err := NewJWKSVerifier(srv.URL, time.Hour, FetchOptions{})
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+
+	keypair, err := NewVerifier(newTestKeypairDir(t))
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	edDir := newTestEdDSAKeypairDir(t)
+	edSigner, err := NewSigner(edDir, SignerOptions{Algorithm: AlgorithmEdDSA})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	edVerifier, err := NewVerifierForAlgorithm(edDir, AlgorithmEdDSA)
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	edSigned, err := edSigner.Sign(validTestToken())
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+
+	cases := []struct {
+		name     string
+		verifier Verifier
+		signed   string
+		alg      string
+		kid      string
+		params   map[string]interface{}
+	}{
+		{name: "JWKS key", verifier: jwks, signed: signTestToken(t, priv, "2026-10", validTestToken()), alg: AlgorithmES256, kid: "2026-10"},
+		{
+			name:     "custom parameters",
+			verifier: jwks,
+			signed:   signWithHeader(t, priv, `{"alg":"ES256","kid":"2026-10","x-rotation":"second"}`, validTestToken()),
+			alg:      AlgorithmES256,
+			kid:      "2026-10",
+			params:   map[string]interface{}{"x-rotation": "second"},
+		},
+		{name: "JWKS key without a kid", verifier: jwks, signed: signTestToken(t, priv, "", validTestToken()), alg: AlgorithmES256},
+		{name: "EdDSA keypair", verifier: edVerifier, signed: edSigned, alg: AlgorithmEdDSA},
+	}
+	for _, c := range cases {
+		tok, header, err := VerifyWithHeader(c.verifier, c.signed)
+		if err != nil {
+			t.Errorf("%s: expected the token to verify, got %v", c.name, err)
+			continue
+		}
+		if tok.Username != "alice" {
+			t.Errorf("%s: expected alice's token, got %+v", c.name, tok)
+		}
+		if header.Algorithm != c.alg || header.KeyID != c.kid {
+			t.Errorf("%s: expected alg %q and kid %q, got %+v", c.name, c.alg, c.kid, header)
+		}
+		if header.Params["alg"] != c.alg {
+			t.Errorf("%s: expected the parameters to hold alg, got %v", c.name, header.Params)
+		}
+		for name, value := range c.params {
+			if header.Params[name] != value {
+				t.Errorf("%s: expected %s to be %v, got %v", c.name, name, value, header.Params)
+			}
+		}
+		if len(header.Raw) == 0 || header.Raw[0] != '{' {
+			t.Errorf("%s: expected the header's JSON, got %q", c.name, header.Raw)
+		}
+	}
+
+	// Nothing is returned of tokens that don't verify.
+	tok, header, err := VerifyWithHeader(keypair, signTestToken(t, priv, "2026-10", validTestToken()))
+	if err == nil || tok != nil || header != nil {
+		t.Errorf("expected a token signed with another key to fail, got %+v, %+v, %v", tok, header, err)
+	}
+	if reason := FailureReason(err); reason != ReasonBadSignature {
+		t.Errorf("expected reason %q, got %q (%v)", ReasonBadSignature, reason, err)
+	}
+}
@@ -0,0 +1,131 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"time"
+)
+
+// PKCS11Options locates a P-256 signing key in a hardware security
+// module, through its PKCS #11 module.
+type PKCS11Options struct {
+	// Library is the path of the PKCS #11 module, e.g.
+	// /usr/lib/softhsm/libsofthsm2.so.
+	Library string
+	// Slot is the ID of the slot holding the token with the key.
+	Slot uint
+	// KeyLabel is the CKA_LABEL of the private key and of its public
+	// key.
+	KeyLabel string
+	// PIN is the user PIN of the token.
+	PIN string
+}
+
+// ErrNoPKCS11Support is returned by NewPKCS11Signer from binaries built
+// without the pkcs11 build tag. PKCS #11 modules are loaded with cgo, so
+// the default static build can't do it.
+var ErrNoPKCS11Support = errors.New("built without PKCS #11 support, rebuild with -tags pkcs11")
+
+// hsmKey is a private key that never leaves the module holding it.
+type hsmKey interface {
+	// public returns the public key of the private key.
+	public() *ecdsa.PublicKey
+	// signDigest returns the ECDSA signature of a SHA-256 digest, as the
+	// 64 bytes r || s, as in a JWS.
+	signDigest(digest []byte) ([]byte, error)
+}
+
+// hsmSigner signs tokens as ES256 compact JWS with an hsmKey. go-jose v1
+// needs the private key itself, so the JWS is handled here.
+type hsmSigner struct {
+	key  hsmKey
+	opts SignerOptions
+}
+
+// NewPKCS11Signer returns a signer of ES256 tokens with the key in a
+// hardware security module, and the public key to verify them with. The
+// private key is never read: the module signs the tokens.
+func NewPKCS11Signer(hsm PKCS11Options, opts SignerOptions) (Signer, *ecdsa.PublicKey, error) {
+	if opts.Algorithm != "" && opts.Algorithm != AlgorithmES256 {
+		return nil, nil, fmt.Errorf("PKCS #11 keys must use %s, not %s", AlgorithmES256, opts.Algorithm)
+	}
+	key, err := openPKCS11Key(hsm)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, err := newHSMSigner(key, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return signer, key.public(), nil
+}
+
+// newHSMSigner returns a signer with key, once a test signature shows
+// that key's public key matches it, e.g. that both were found under the
+// same label.
+func newHSMSigner(key hsmKey, opts SignerOptions) (*hsmSigner, error) {
+	digest := sha256.Sum256([]byte("kubernetes-ldap key check"))
+	signature, err := key.signDigest(digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("Error signing with the HSM key: %v", err)
+	}
+	if !verifyRawSignature(key.public(), digest[:], signature) {
+		return nil, errors.New("the HSM's signature doesn't verify with its public key")
+	}
+	return &hsmSigner{key: key, opts: opts}, nil
+}
+
+// Sign an authentication token and return the serialized JWS.
+func (hs *hsmSigner) Sign(token *AuthToken) (string, error) {
+	input, err := signingInput(token, AlgorithmES256, hs.opts)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(input))
+	signature, err := hs.key.signDigest(digest[:])
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// verifyRawSignature checks an r || s ECDSA signature of digest.
+func verifyRawSignature(key *ecdsa.PublicKey, digest, signature []byte) bool {
+	size := (key.Params().BitSize + 7) / 8
+	if len(signature) != 2*size {
+		return false
+	}
+	r := new(big.Int).SetBytes(signature[:size])
+	s := new(big.Int).SetBytes(signature[size:])
+	return ecdsa.Verify(key, digest, r, s)
+}
+
+// NewVerifierForKey is like NewVerifierWithBackupKeys, for the keypair
+// whose public key is key, e.g. the one returned by NewPKCS11Signer.
+func NewVerifierForKey(key *ecdsa.PublicKey, backupKeyFiles []string) (Verifier, error) {
+	v := &ecdsaVerifier{publicKey: key, loadedAt: time.Now()}
+	if err := v.loadBackupKeys(backupKeyFiles); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// WritePublicKey writes key to filename as PEM, which verifiers read
+// like a keypair's public key, e.g. with --backup-verification-keys or
+// as the signing.pub of a verifying only keypair directory.
+func WritePublicKey(filename string, key *ecdsa.PublicKey) error {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return fmt.Errorf("Error marshalling public key: %v", err)
+	}
+	buf := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	return ioutil.WriteFile(filename, buf, os.FileMode(0644))
+}
@@ -0,0 +1,113 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+)
+
+// softKey is an hsmKey held in memory.
+type softKey struct {
+	privateKey *ecdsa.PrivateKey
+	publicKey  *ecdsa.PublicKey
+	signed     int
+}
+
+func newSoftKey(t *testing.T) *softKey {
+	priv, _ := newTestKey(t, "")
+	return &softKey{privateKey: priv, publicKey: &priv.PublicKey}
+}
+
+func (k *softKey) public() *ecdsa.PublicKey {
+	return k.publicKey
+}
+
+func (k *softKey) signDigest(digest []byte) ([]byte, error) {
+	k.signed++
+	r, s, err := ecdsa.Sign(rand.Reader, k.privateKey, digest)
+	if err != nil {
+		return nil, err
+	}
+	// Fixed size r and s: a short one must be padded.
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signature, nil
+}
+
+func TestHSMSigner(t *testing.T) {
+	key := newSoftKey(t)
+	signer, err := newHSMSigner(key, SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	verifier, err := NewVerifierForKey(key.public(), nil)
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	tok := validTestToken()
+	tok.Groups = []string{"admins"}
+	signed, err := signer.Sign(tok)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	verified, err := verifier.Verify(signed)
+	if err != nil {
+		t.Fatalf("expected the HSM signed token to verify, got %v", err)
+	}
+	if verified.Username != "alice" || len(verified.Groups) != 1 || verified.Groups[0] != "admins" {
+		t.Errorf("expected the signed claims back, got %+v", verified)
+	}
+
+	// Exported, the public key verifies like a keypair's.
+	dir := t.TempDir()
+	pubFile := filepath.Join(dir, fileprefix+".pub")
+	if err := WritePublicKey(pubFile, key.public()); err != nil {
+		t.Fatalf("writing public key: %v", err)
+	}
+	fromDir, err := NewVerifier(dir)
+	if err != nil {
+		t.Fatalf("loading exported public key: %v", err)
+	}
+	if _, err := fromDir.Verify(signed); err != nil {
+		t.Errorf("expected the exported public key to verify the token, got %v", err)
+	}
+
+	// Another key's tokens don't verify.
+	other, err := newHSMSigner(newSoftKey(t), SignerOptions{CompressionThreshold: 1})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	otherSigned, err := other.Sign(tok)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	if _, err := verifier.Verify(otherSigned); FailureReason(err) != ReasonBadSignature {
+		t.Errorf("expected a bad signature, got %v", err)
+	}
+	// Unless it's a backup key, compression notwithstanding.
+	withBackup, err := NewVerifierForKey(key.public(), []string{pubFileOf(t, other.key.public())})
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	if _, err := withBackup.Verify(otherSigned); err != nil {
+		t.Errorf("expected the backup key to verify the token, got %v", err)
+	}
+}
+
+func pubFileOf(t *testing.T, key *ecdsa.PublicKey) string {
+	file := filepath.Join(t.TempDir(), "backup.pub")
+	if err := WritePublicKey(file, key); err != nil {
+		t.Fatalf("writing public key: %v", err)
+	}
+	return file
+}
+
+func TestHSMSignerKeyMismatch(t *testing.T) {
+	key := newSoftKey(t)
+	key.publicKey = &newSoftKey(t).privateKey.PublicKey
+	if _, err := newHSMSigner(key, SignerOptions{}); err == nil {
+		t.Errorf("expected a key whose public key doesn't match to be refused")
+	}
+}
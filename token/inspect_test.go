@@ -0,0 +1,96 @@
+package token
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func expiredTestToken() *AuthToken {
+	tok := validTestToken()
+	tok.Groups = []string{"engineering"}
+	tok.Expiration = time.Now().Add(-time.Hour).UnixNano() / int64(time.Millisecond)
+	return tok
+}
+
+func TestInspectExpiredToken(t *testing.T) {
+	dir := newTestKeypairDir(t)
+	signer, err := NewSigner(dir, SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	verifier, err := NewVerifier(dir)
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	inspector, err := NewInspector(dir)
+	if err != nil {
+		t.Fatalf("creating inspector: %v", err)
+	}
+
+	expired, err := signer.Sign(expiredTestToken())
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	if _, err := verifier.Verify(expired); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("expected Verify to reject the expired token, got %v", err)
+	}
+
+	tok, isExpired, err := inspector.Inspect(expired)
+	if err != nil {
+		t.Fatalf("expected the expired token to be inspected: %v", err)
+	}
+	if !isExpired {
+		t.Errorf("expected the token to be reported as expired")
+	}
+	if tok.Username != "alice" || len(tok.Groups) != 1 || tok.Groups[0] != "engineering" {
+		t.Errorf("unexpected claims %+v", tok)
+	}
+
+	valid, err := signer.Sign(validTestToken())
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	if _, isExpired, err := inspector.Inspect(valid); err != nil || isExpired {
+		t.Errorf("expected a valid token to be inspected as not expired, got %t, %v", isExpired, err)
+	}
+
+	// The signature is still checked.
+	otherSigner, err := NewSigner(newTestKeypairDir(t), SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	forged, err := otherSigner.Sign(expiredTestToken())
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	if _, _, err := inspector.Inspect(forged); err == nil {
+		t.Errorf("expected a token signed with another key to be rejected")
+	}
+}
+
+func TestJWKSInspectExpiredToken(t *testing.T) {
+	priv, pub := newTestKey(t, "key-1")
+	fake := &fakeJWKS{}
+	fake.setKeys(pub)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	jv, err := newJWKSVerifier(srv.URL, time.Hour, FetchOptions{})
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+
+	expired := signTestToken(t, priv, "key-1", expiredTestToken())
+	if _, err := jv.Verify(expired); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("expected Verify to reject the expired token, got %v", err)
+	}
+	tok, isExpired, err := jv.Inspect(expired)
+	if err != nil {
+		t.Fatalf("expected the expired token to be inspected: %v", err)
+	}
+	if !isExpired || tok.Username != "alice" {
+		t.Errorf("expected expired claims for alice, got %t, %+v", isExpired, tok)
+	}
+}
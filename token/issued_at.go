@@ -0,0 +1,48 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrIssuedInFuture is returned by an issued at skew verifier for a token
+// issued further in the future than the allowed clock skew.
+var ErrIssuedInFuture = errors.New("token is issued in the future")
+
+// issuedAtSkewVerifier rejects tokens whose IssuedAt is in the future by
+// more than the allowed clock skew.
+type issuedAtSkewVerifier struct {
+	verifier Verifier
+	maxSkew  time.Duration
+	// now is overridden by tests.
+	now func() time.Time
+}
+
+// NewIssuedAtSkewVerifier returns a verifier that accepts the tokens
+// accepted by verifier, unless they claim to have been issued more than
+// maxSkew from now. Such tokens come from an issuer with a bad clock, or
+// weren't issued by us at all. Tokens without an IssuedAt time are left
+// to verifier. A zero maxSkew returns verifier unchanged.
+func NewIssuedAtSkewVerifier(verifier Verifier, maxSkew time.Duration) Verifier {
+	if maxSkew <= 0 {
+		return verifier
+	}
+	return &issuedAtSkewVerifier{verifier: verifier, maxSkew: maxSkew, now: time.Now}
+}
+
+func (iv *issuedAtSkewVerifier) Verify(s string) (*AuthToken, error) {
+	token, err := iv.verifier.Verify(s)
+	if err != nil {
+		return nil, err
+	}
+	if token.IssuedAt == 0 {
+		return token, nil
+	}
+
+	issuedAt := time.Unix(0, token.IssuedAt*int64(time.Millisecond))
+	if ahead := issuedAt.Sub(iv.now()); ahead > iv.maxSkew {
+		return nil, newVerifyError(ReasonIssuedInFuture, fmt.Errorf("%w: issued %v from now, allowed skew is %v", ErrIssuedInFuture, ahead.Round(time.Second), iv.maxSkew))
+	}
+	return token, nil
+}
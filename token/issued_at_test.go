@@ -0,0 +1,80 @@
+package token
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIssuedAtSkewVerifier(t *testing.T) {
+	now := time.Now()
+	millis := func(t time.Time) int64 { return t.UnixNano() / int64(time.Millisecond) }
+	expiration := millis(now.Add(24 * time.Hour))
+
+	cases := []struct {
+		name      string
+		issuedAt  int64
+		expectErr bool
+	}{
+		{name: "issued in the past", issuedAt: millis(now.Add(-time.Hour))},
+		{name: "issued now", issuedAt: millis(now)},
+		{name: "issued in the future within the skew", issuedAt: millis(now.Add(4 * time.Minute))},
+		{name: "issued in the future beyond the skew", issuedAt: millis(now.Add(6 * time.Minute)), expectErr: true},
+		{name: "issued far in the future", issuedAt: millis(now.Add(365 * 24 * time.Hour)), expectErr: true},
+		{name: "token without an issue time", issuedAt: 0},
+	}
+
+	for _, c := range cases {
+		v := NewIssuedAtSkewVerifier(staticVerifier{&AuthToken{Username: "alice", Expiration: expiration, IssuedAt: c.issuedAt}}, 5*time.Minute)
+		v.(*issuedAtSkewVerifier).now = func() time.Time { return now }
+
+		tok, err := v.Verify("token")
+		if !c.expectErr {
+			if err != nil || tok.Username != "alice" {
+				t.Errorf("%s: expected the token to be accepted, got %v", c.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrIssuedInFuture) {
+			t.Errorf("%s: expected ErrIssuedInFuture, got %v", c.name, err)
+		}
+		if errors.Is(err, ErrTokenExpired) {
+			t.Errorf("%s: expected the error not to count as expired, got %v", c.name, err)
+		}
+	}
+
+	// Without a skew, the verifier is used as is.
+	inner := staticVerifier{&AuthToken{Username: "alice", Expiration: expiration}}
+	if v := NewIssuedAtSkewVerifier(inner, 0); v != Verifier(inner) {
+		t.Errorf("expected a zero skew to return the verifier unchanged")
+	}
+}
+
+func TestIssuedAtSkewVerifierSignedToken(t *testing.T) {
+	dir := newTestKeypairDir(t)
+	signer, err := NewSigner(dir, SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	verifier, err := NewVerifier(dir)
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+
+	tok := validTestToken()
+	tok.IssuedAt = time.Now().Add(time.Hour).UnixNano() / int64(time.Millisecond)
+	signed, err := signer.Sign(tok)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+
+	if _, err := verifier.Verify(signed); err != nil {
+		t.Fatalf("expected the token to verify without a skew limit: %v", err)
+	}
+	if _, err := NewIssuedAtSkewVerifier(verifier, 5*time.Minute).Verify(signed); !errors.Is(err, ErrIssuedInFuture) {
+		t.Errorf("expected the token issued an hour from now to be rejected, got %v", err)
+	}
+	if _, err := NewIssuedAtSkewVerifier(verifier, 2*time.Hour).Verify(signed); err != nil {
+		t.Errorf("expected the token to be within a 2h skew: %v", err)
+	}
+}
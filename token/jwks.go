@@ -0,0 +1,277 @@
+package token
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+var (
+	keySetKeys = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kubernetes_ldap_key_set_keys",
+			Help: "Number of keys in the cached JWKS key set, by URL.",
+		},
+		[]string{"url"},
+	)
+	keySetLastRefresh = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kubernetes_ldap_key_set_last_refresh_timestamp_seconds",
+			Help: "Time of the last successful refresh of the JWKS key set, by URL.",
+		},
+		[]string{"url"},
+	)
+	keySetRefreshFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kubernetes_ldap_key_set_refresh_failures_total",
+			Help: "Total number of failed refreshes of the JWKS key set, by URL.",
+		},
+		[]string{"url"},
+	)
+)
+
+// RegisterKeySetMetrics registers the metrics of JWKS key sets.
+func RegisterKeySetMetrics() {
+	prometheus.MustRegister(keySetKeys)
+	prometheus.MustRegister(keySetLastRefresh)
+	prometheus.MustRegister(keySetRefreshFailures)
+}
+
+// minJWKSRefetchInterval bounds how often an unknown kid can force a
+// refetch of the key set, so a stream of forged tokens can't be used to
+// hammer the JWKS endpoint.
+const minJWKSRefetchInterval = 10 * time.Second
+
+// jwksVerifier verifies tokens against the keys published at a remote
+// JWKS endpoint. Keys are cached and refreshed periodically, or early
+// when a token references a kid that isn't in the cache.
+type jwksVerifier struct {
+	url             string
+	client          *http.Client
+	opts            FetchOptions
+	refreshInterval time.Duration
+	minRefetch      time.Duration
+
+	// fetchMu serializes fetches, which are made without holding mu so
+	// that a slow endpoint doesn't hold up verification.
+	fetchMu sync.Mutex
+
+	mu           sync.RWMutex
+	keys         []jose.JsonWebKey
+	keysLoadedAt time.Time
+	lastFetch    time.Time
+	nextRefresh  time.Time
+	revalidating bool
+}
+
+// NewJWKSVerifier returns a verifier that trusts the keys published at
+// the given JWKS URL. The key set is refreshed every refreshInterval,
+// unless the endpoint sends a Cache-Control max-age, which takes
+// precedence. Refreshes happen in the background, with the cached keys
+// in use until they succeed, and are made as configured by opts.
+func NewJWKSVerifier(url string, refreshInterval time.Duration, opts FetchOptions) (Verifier, error) {
+	jv, err := newJWKSVerifier(url, refreshInterval, opts)
+	if err != nil {
+		return nil, err
+	}
+	return jv, nil
+}
+
+func newJWKSVerifier(url string, refreshInterval time.Duration, opts FetchOptions) (*jwksVerifier, error) {
+	jv := &jwksVerifier{
+		url:             url,
+		client:          opts.client(),
+		opts:            opts,
+		refreshInterval: refreshInterval,
+		minRefetch:      minJWKSRefetchInterval,
+	}
+	if err := jv.refresh(); err != nil {
+		return nil, fmt.Errorf("fetching JWKS from %s: %v", url, err)
+	}
+	return jv, nil
+}
+
+// Verify checks the token's signature against the cached key set and
+// returns the token if it is valid and not expired.
+func (jv *jwksVerifier) Verify(s string) (*AuthToken, error) {
+	payload, err := jv.verifySignature(s)
+	if err != nil {
+		return nil, err
+	}
+	return decodeToken(payload)
+}
+
+// Inspect checks the token's signature against the cached key set and
+// returns it even if it has expired.
+func (jv *jwksVerifier) Inspect(s string) (*AuthToken, bool, error) {
+	payload, err := jv.verifySignature(s)
+	if err != nil {
+		return nil, false, err
+	}
+	return inspectToken(payload)
+}
+
+// verifySignature checks the JWS signature against the cached key set and
+// returns the verified payload.
+func (jv *jwksVerifier) verifySignature(s string) ([]byte, error) {
+	if err := checkCompactJWS(s); err != nil {
+		return nil, newVerifyError(ReasonMalformed, err)
+	}
+	jws, err := jose.ParseSigned(s)
+	if err != nil {
+		return nil, newVerifyError(ReasonMalformed, err)
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, newVerifyError(ReasonMalformed, fmt.Errorf("expected a single signature, got %d", len(jws.Signatures)))
+	}
+	kid := jws.Signatures[0].Header.KeyID
+
+	jv.maybeRefresh(kid)
+
+	keys := jv.candidateKeys(kid)
+	if len(keys) == 0 {
+		return nil, newVerifyError(ReasonBadSignature, fmt.Errorf("no trusted key found for kid %q", kid))
+	}
+
+	for _, key := range keys {
+		payload, err := jws.Verify(key.Key)
+		if err == nil {
+			return decompressPayload(s, payload)
+		}
+	}
+	return nil, newVerifyError(ReasonBadSignature, errors.New("token signature is invalid"))
+}
+
+// candidateKeys returns the cached keys that could have signed a token
+// with the given kid. Tokens without a kid are tried against every key.
+func (jv *jwksVerifier) candidateKeys(kid string) []jose.JsonWebKey {
+	jv.mu.RLock()
+	defer jv.mu.RUnlock()
+
+	if kid == "" {
+		return jv.keys
+	}
+
+	var keys []jose.JsonWebKey
+	for _, key := range jv.keys {
+		if key.KeyID == kid {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// maybeRefresh refetches the key set if kid is unknown and we haven't
+// refetched too recently. If the cache is merely due for a refresh, the
+// cached keys are used while it is refreshed in the background. Fetch
+// failures are logged and the stale keys are kept.
+func (jv *jwksVerifier) maybeRefresh(kid string) {
+	now := time.Now()
+
+	jv.mu.Lock()
+	due := now.After(jv.nextRefresh)
+	unknown := kid != "" && !hasKeyID(jv.keys, kid) && now.Sub(jv.lastFetch) >= jv.minRefetch
+	revalidate := due && !unknown && len(jv.keys) > 0
+	if revalidate {
+		if jv.revalidating {
+			jv.mu.Unlock()
+			return
+		}
+		jv.revalidating = true
+	}
+	jv.mu.Unlock()
+
+	switch {
+	case revalidate:
+		go func() {
+			if err := jv.refresh(); err != nil {
+				glog.Warningf("Error refreshing JWKS from %s, using cached keys: %v", jv.url, err)
+			}
+			jv.mu.Lock()
+			jv.revalidating = false
+			jv.mu.Unlock()
+		}()
+	case due || unknown:
+		if err := jv.refresh(); err != nil {
+			glog.Warningf("Error refreshing JWKS from %s, using cached keys: %v", jv.url, err)
+		}
+	}
+}
+
+// refresh fetches the key set and replaces the cached keys on success.
+func (jv *jwksVerifier) refresh() (err error) {
+	defer func() {
+		if err != nil {
+			keySetRefreshFailures.WithLabelValues(jv.url).Inc()
+		}
+	}()
+
+	jv.fetchMu.Lock()
+	defer jv.fetchMu.Unlock()
+
+	now := time.Now()
+	jv.mu.Lock()
+	jv.lastFetch = now
+	// Back off for a full interval on failure so a dead endpoint isn't
+	// retried on every request.
+	jv.nextRefresh = now.Add(jv.refreshInterval)
+	jv.mu.Unlock()
+
+	body, header, err := fetch(jv.client, jv.url, jv.opts)
+	if err != nil {
+		return err
+	}
+
+	keySet := jose.JsonWebKeySet{}
+	if err := json.Unmarshal(body, &keySet); err != nil {
+		return fmt.Errorf("decoding key set: %v", err)
+	}
+	if max := jv.opts.MaxKeys; max > 0 && len(keySet.Keys) > max {
+		return fmt.Errorf("key set has %d keys, more than the maximum of %d", len(keySet.Keys), max)
+	}
+
+	jv.mu.Lock()
+	defer jv.mu.Unlock()
+	jv.keys = keySet.Keys
+	jv.keysLoadedAt = now
+	keySetKeys.WithLabelValues(jv.url).Set(float64(len(jv.keys)))
+	keySetLastRefresh.WithLabelValues(jv.url).Set(float64(now.Unix()))
+	if maxAge, ok := cacheMaxAge(header.Get("Cache-Control")); ok {
+		jv.nextRefresh = now.Add(maxAge)
+	}
+	return nil
+}
+
+func hasKeyID(keys []jose.JsonWebKey, kid string) bool {
+	for _, key := range keys {
+		if key.KeyID == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheMaxAge extracts the max-age directive from a Cache-Control header.
+func cacheMaxAge(header string) (time.Duration, bool) {
+	for _, directive := range strings.Split(header, ",") {
+		directive = strings.TrimSpace(strings.ToLower(directive))
+		if !strings.HasPrefix(directive, "max-age=") {
+			continue
+		}
+		seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+		if err != nil || seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 0, false
+}
@@ -0,0 +1,507 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+// fakeJWKS serves a mutable key set and counts fetches.
+type fakeJWKS struct {
+	mu           sync.Mutex
+	keys         []jose.JsonWebKey
+	cacheControl string
+	fail         bool
+	// failures fails that many fetches before succeeding again.
+	failures int
+	// delay holds responses back, unless the client gives up first.
+	delay   time.Duration
+	fetches int
+}
+
+func (f *fakeJWKS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.fetches++
+	delay := f.delay
+	f.mu.Unlock()
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if f.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if f.cacheControl != "" {
+		w.Header().Set("Cache-Control", f.cacheControl)
+	}
+	json.NewEncoder(w).Encode(jose.JsonWebKeySet{Keys: f.keys})
+}
+
+func (f *fakeJWKS) setKeys(keys ...jose.JsonWebKey) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys = keys
+}
+
+func (f *fakeJWKS) fetchCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fetches
+}
+
+// waitForFetches waits for the key set to have been fetched n times, by
+// refreshes in the background.
+func (f *fakeJWKS) waitForFetches(t *testing.T, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for f.fetchCount() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d fetches, got %d", n, f.fetchCount())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitForRevalidation waits for a background refresh of jv to finish.
+func waitForRevalidation(t *testing.T, jv *jwksVerifier) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		jv.mu.RLock()
+		revalidating := jv.revalidating
+		jv.mu.RUnlock()
+		if !revalidating {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the background refresh to finish")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newTestKey(t *testing.T, kid string) (*ecdsa.PrivateKey, jose.JsonWebKey) {
+	priv, err := ecdsa.GenerateKey(curveEll, rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	return priv, jose.JsonWebKey{Key: &priv.PublicKey, KeyID: kid, Algorithm: string(curveJose)}
+}
+
+func signTestToken(t *testing.T, priv *ecdsa.PrivateKey, kid string, tok *AuthToken) string {
+	return signTestClaims(t, priv, kid, tok)
+}
+
+// signTestClaims signs the JSON encoding of claims with the given key.
+func signTestClaims(t *testing.T, priv *ecdsa.PrivateKey, kid string, claims interface{}) string {
+	signer, err := jose.NewSigner(curveJose, &jose.JsonWebKey{Key: priv, KeyID: kid})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	payload, _ := json.Marshal(claims)
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	s, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatalf("serializing token: %v", err)
+	}
+	return s
+}
+
+func validTestToken() *AuthToken {
+	return &AuthToken{
+		Username:   "alice",
+		Expiration: time.Now().Add(time.Hour).UnixNano() / int64(time.Millisecond),
+	}
+}
+
+func TestJWKSVerifier(t *testing.T) {
+	priv1, pub1 := newTestKey(t, "key-1")
+	priv2, pub2 := newTestKey(t, "key-2")
+	_, other := newTestKey(t, "key-1")
+
+	fake := &fakeJWKS{}
+	fake.setKeys(pub1)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	v, err := NewJWKSVerifier(srv.URL, time.Hour, FetchOptions{})
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	jv := v.(*jwksVerifier)
+	jv.minRefetch = 0
+
+	tok, err := v.Verify(signTestToken(t, priv1, "key-1", validTestToken()))
+	if err != nil {
+		t.Fatalf("expected token signed by key-1 to verify: %v", err)
+	}
+	if tok.Username != "alice" {
+		t.Errorf("expected username alice, got %q", tok.Username)
+	}
+
+	// Key rotation: the issuer starts signing with key-2 and publishes it.
+	// The unknown kid forces a refetch before the refresh interval is up.
+	fake.setKeys(pub1, pub2)
+	if _, err := v.Verify(signTestToken(t, priv2, "key-2", validTestToken())); err != nil {
+		t.Errorf("expected token signed by rotated key-2 to verify: %v", err)
+	}
+	if n := fake.fetchCount(); n != 2 {
+		t.Errorf("expected 2 fetches after rotation, got %d", n)
+	}
+
+	// key-1 is retired: once it drops out of the set, its tokens fail.
+	fake.setKeys(pub2)
+	jv.refresh()
+	if _, err := v.Verify(signTestToken(t, priv1, "key-1", validTestToken())); err == nil {
+		t.Errorf("expected token signed by retired key-1 to be rejected")
+	}
+
+	// A key published under a known kid but a different key must not verify.
+	fake.setKeys(other, pub2)
+	jv.refresh()
+	if _, err := v.Verify(signTestToken(t, priv1, "key-1", validTestToken())); err == nil {
+		t.Errorf("expected token to be rejected when kid maps to a different key")
+	}
+}
+
+func TestJWKSVerifierUsesStaleKeysOnFailure(t *testing.T) {
+	priv, pub := newTestKey(t, "key-1")
+
+	fake := &fakeJWKS{}
+	fake.setKeys(pub)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	v, err := NewJWKSVerifier(srv.URL, time.Hour, FetchOptions{})
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	jv := v.(*jwksVerifier)
+
+	fake.mu.Lock()
+	fake.fail = true
+	fake.mu.Unlock()
+
+	// Force the cache to be due for a refresh; the failed fetch must not
+	// discard the keys we already have.
+	jv.mu.Lock()
+	jv.nextRefresh = time.Time{}
+	jv.mu.Unlock()
+
+	if _, err := v.Verify(signTestToken(t, priv, "key-1", validTestToken())); err != nil {
+		t.Errorf("expected stale key to be used when refresh fails: %v", err)
+	}
+	fake.waitForFetches(t, 2)
+	waitForRevalidation(t, jv)
+	if _, err := v.Verify(signTestToken(t, priv, "key-1", validTestToken())); err != nil {
+		t.Errorf("expected stale key to be kept after the refresh failed: %v", err)
+	}
+}
+
+func TestJWKSVerifierTimeout(t *testing.T) {
+	priv, pub := newTestKey(t, "key-1")
+
+	fake := &fakeJWKS{}
+	fake.setKeys(pub)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	v, err := NewJWKSVerifier(srv.URL, time.Hour, FetchOptions{Timeout: 50 * time.Millisecond, Retries: 1})
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	jv := v.(*jwksVerifier)
+
+	// The endpoint hangs, and the cache is due for a refresh.
+	fake.mu.Lock()
+	fake.delay = time.Minute
+	fake.mu.Unlock()
+	jv.mu.Lock()
+	jv.nextRefresh = time.Time{}
+	jv.mu.Unlock()
+
+	start := time.Now()
+	if _, err := v.Verify(signTestToken(t, priv, "key-1", validTestToken())); err != nil {
+		t.Errorf("expected the cached key to be used while the endpoint hangs: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected verification not to wait for the endpoint, took %v", elapsed)
+	}
+
+	// Both attempts time out and the cached keys are kept.
+	fake.waitForFetches(t, 3)
+	waitForRevalidation(t, jv)
+	if _, err := v.Verify(signTestToken(t, priv, "key-1", validTestToken())); err != nil {
+		t.Errorf("expected the cached key to be kept after the timeouts: %v", err)
+	}
+
+	// An unknown kid waits for its refetch, but no longer than the
+	// timeout allows.
+	priv2, _ := newTestKey(t, "key-2")
+	jv.minRefetch = 0
+	start = time.Now()
+	if _, err := v.Verify(signTestToken(t, priv2, "key-2", validTestToken())); FailureReason(err) != ReasonBadSignature {
+		t.Errorf("expected an unknown key to be rejected, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the refetch to time out, took %v", elapsed)
+	}
+	if n := fake.fetchCount(); n != 5 {
+		t.Errorf("expected the unknown kid to be refetched, got %d fetches", n)
+	}
+}
+
+func TestJWKSVerifierRetries(t *testing.T) {
+	priv, pub := newTestKey(t, "key-1")
+
+	fake := &fakeJWKS{failures: 2}
+	fake.setKeys(pub)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	// The initial fetch survives a transient outage.
+	v, err := NewJWKSVerifier(srv.URL, time.Hour, FetchOptions{Retries: 2, RetryBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatalf("expected the fetch to be retried: %v", err)
+	}
+	if n := fake.fetchCount(); n != 3 {
+		t.Errorf("expected 3 fetches, got %d", n)
+	}
+	if _, err := v.Verify(signTestToken(t, priv, "key-1", validTestToken())); err != nil {
+		t.Errorf("expected the token to verify: %v", err)
+	}
+
+	fake.mu.Lock()
+	fake.failures = 3
+	fake.mu.Unlock()
+	if _, err := NewJWKSVerifier(srv.URL, time.Hour, FetchOptions{Retries: 2, RetryBackoff: time.Millisecond}); err == nil {
+		t.Errorf("expected an error once the retries run out")
+	}
+}
+
+func TestJWKSVerifierRevalidatesRotatedKeys(t *testing.T) {
+	priv1, pub1 := newTestKey(t, "key-1")
+	priv2, pub2 := newTestKey(t, "key-2")
+
+	fake := &fakeJWKS{}
+	fake.setKeys(pub1)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	v, err := NewJWKSVerifier(srv.URL, time.Hour, FetchOptions{})
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	jv := v.(*jwksVerifier)
+
+	// The keys are rotated, and the cache comes due. The next token is
+	// verified with the cached keys while they are refetched.
+	fake.setKeys(pub2)
+	jv.mu.Lock()
+	jv.nextRefresh = time.Time{}
+	jv.mu.Unlock()
+
+	if _, err := v.Verify(signTestToken(t, priv1, "key-1", validTestToken())); err != nil {
+		t.Errorf("expected the cached key to be used during the refresh: %v", err)
+	}
+	fake.waitForFetches(t, 2)
+	waitForRevalidation(t, jv)
+
+	if _, err := v.Verify(signTestToken(t, priv2, "key-2", validTestToken())); err != nil {
+		t.Errorf("expected the rotated key to verify: %v", err)
+	}
+	if _, err := v.Verify(signTestToken(t, priv1, "key-1", validTestToken())); err == nil {
+		t.Errorf("expected the retired key to be rejected")
+	}
+	if n := fake.fetchCount(); n != 2 {
+		t.Errorf("expected no more fetches once refreshed, got %d", n)
+	}
+}
+
+func TestJWKSVerifierHonorsCacheControl(t *testing.T) {
+	_, pub := newTestKey(t, "key-1")
+
+	fake := &fakeJWKS{cacheControl: "public, max-age=60"}
+	fake.setKeys(pub)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	v, err := NewJWKSVerifier(srv.URL, time.Hour, FetchOptions{})
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	jv := v.(*jwksVerifier)
+
+	jv.mu.RLock()
+	ttl := jv.nextRefresh.Sub(jv.lastFetch)
+	jv.mu.RUnlock()
+	if ttl != 60*time.Second {
+		t.Errorf("expected refresh after max-age of 60s, got %v", ttl)
+	}
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	cases := []struct {
+		header   string
+		expected time.Duration
+		ok       bool
+	}{
+		{header: "max-age=300", expected: 300 * time.Second, ok: true},
+		{header: "public, Max-Age=5", expected: 5 * time.Second, ok: true},
+		{header: "no-cache", ok: false},
+		{header: "max-age=bogus", ok: false},
+		{header: "", ok: false},
+	}
+
+	for i, c := range cases {
+		maxAge, ok := cacheMaxAge(c.header)
+		if ok != c.ok || maxAge != c.expected {
+			t.Errorf("Case: %d. Expected (%v, %t), got (%v, %t)", i, c.expected, c.ok, maxAge, ok)
+		}
+	}
+}
+
+func TestJWKSVerifierMaxKeys(t *testing.T) {
+	_, pub1 := newTestKey(t, "key-1")
+	_, pub2 := newTestKey(t, "key-2")
+	priv3, pub3 := newTestKey(t, "key-3")
+
+	fake := &fakeJWKS{}
+	fake.setKeys(pub1, pub2, pub3)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	if _, err := NewJWKSVerifier(srv.URL, time.Hour, FetchOptions{MaxKeys: 2}); err == nil {
+		t.Fatal("expected a key set over the maximum to be refused")
+	}
+
+	fake.setKeys(pub1, pub2)
+	jv, err := newJWKSVerifier(srv.URL, time.Hour, FetchOptions{MaxKeys: 2})
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	fake.setKeys(pub1, pub2, pub3)
+	if err := jv.refresh(); err == nil {
+		t.Error("expected a refresh over the maximum to fail")
+	}
+	if len(jv.keys) != 2 {
+		t.Errorf("expected the cached keys to be kept, got %d keys", len(jv.keys))
+	}
+	if _, err := jv.Verify(signTestToken(t, priv3, "key-3", validTestToken())); err == nil {
+		t.Error("expected tokens signed with a refused key to fail")
+	}
+}
+
+func TestJWKSVerifierMaxFetchSize(t *testing.T) {
+	priv1, pub1 := newTestKey(t, "key-1")
+	_, pub2 := newTestKey(t, "key-2")
+
+	fake := &fakeJWKS{}
+	fake.setKeys(pub1)
+	var oversized int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&oversized) == 0 {
+			fake.ServeHTTP(w, r)
+			return
+		}
+		// A valid key set, but for the whitespace padding it over the
+		// limit.
+		w.Write([]byte(strings.Repeat(" ", maxFetchSize)))
+		json.NewEncoder(w).Encode(jose.JsonWebKeySet{Keys: []jose.JsonWebKey{pub2}})
+	}))
+	defer srv.Close()
+
+	jv, err := newJWKSVerifier(srv.URL, time.Hour, FetchOptions{})
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	atomic.StoreInt32(&oversized, 1)
+	if err := jv.refresh(); err == nil {
+		t.Error("expected a refresh over the size limit to fail")
+	}
+	if _, err := jv.Verify(signTestToken(t, priv1, "key-1", validTestToken())); err != nil {
+		t.Errorf("expected the cached keys to be kept, got %v", err)
+	}
+}
+
+func TestJWKSVerifierMetrics(t *testing.T) {
+	_, pub1 := newTestKey(t, "key-1")
+	_, pub2 := newTestKey(t, "key-2")
+
+	fake := &fakeJWKS{}
+	fake.setKeys(pub1)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	gauge := func(g *prometheus.GaugeVec) float64 {
+		m := &dto.Metric{}
+		if err := g.WithLabelValues(srv.URL).Write(m); err != nil {
+			t.Fatalf("reading metric: %v", err)
+		}
+		return m.GetGauge().GetValue()
+	}
+	failures := func() float64 {
+		m := &dto.Metric{}
+		if err := keySetRefreshFailures.WithLabelValues(srv.URL).Write(m); err != nil {
+			t.Fatalf("reading metric: %v", err)
+		}
+		return m.GetCounter().GetValue()
+	}
+
+	before := time.Now().Unix()
+	jv, err := newJWKSVerifier(srv.URL, time.Hour, FetchOptions{})
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	if keys := gauge(keySetKeys); keys != 1 {
+		t.Errorf("expected 1 key, got %v", keys)
+	}
+	if refreshed := gauge(keySetLastRefresh); refreshed < float64(before) {
+		t.Errorf("expected the last refresh at %d or later, got %v", before, refreshed)
+	}
+
+	fake.setKeys(pub1, pub2)
+	if err := jv.refresh(); err != nil {
+		t.Fatalf("refreshing: %v", err)
+	}
+	if keys := gauge(keySetKeys); keys != 2 {
+		t.Errorf("expected 2 keys after the refresh, got %v", keys)
+	}
+
+	fake.mu.Lock()
+	fake.fail = true
+	fake.mu.Unlock()
+	if err := jv.refresh(); err == nil {
+		t.Fatal("expected the refresh to fail")
+	}
+	if f := failures(); f != 1 {
+		t.Errorf("expected 1 refresh failure, got %v", f)
+	}
+	if keys := gauge(keySetKeys); keys != 2 {
+		t.Errorf("expected the cached keys to be counted after a failure, got %v", keys)
+	}
+}
@@ -0,0 +1,142 @@
+package token
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// Key algorithms as described in KeyFileErrors.
+const (
+	keyAlgorithmES256 = "ECDSA " + curveName
+	keyAlgorithmEdDSA = "Ed25519"
+)
+
+// KeyFileError is returned when a key file, e.g. a keypair's signing.priv
+// or signing.pub, can't be loaded. Problem says what looks wrong with it,
+// rather than leaving operators with a parser error.
+type KeyFileError struct {
+	// File is the file name, empty for keys that didn't come from a
+	// file.
+	File    string
+	Problem string
+	// Err is the parser's error, if any.
+	Err error
+}
+
+func (e *KeyFileError) Error() string {
+	msg := "invalid key"
+	if e.File != "" {
+		msg = "invalid key file " + e.File
+	}
+	msg += ": " + e.Problem
+	if e.Err != nil {
+		msg += " (" + e.Err.Error() + ")"
+	}
+	return msg
+}
+
+// Unwrap returns the parser's error.
+func (e *KeyFileError) Unwrap() error {
+	return e.Err
+}
+
+// keyFileError returns a KeyFileError for buf, read from file, which
+// didn't load as a private key, or public key unless private, for
+// algorithm.
+func keyFileError(file string, buf []byte, private bool, algorithm string, err error) error {
+	problem := diagnoseKey(buf, private, algorithm)
+	if problem == "" {
+		problem = "the key couldn't be loaded"
+	}
+	return &KeyFileError{File: file, Problem: problem, Err: err}
+}
+
+// diagnoseKey says what is wrong with buf as a private, or public unless
+// private, key for algorithm, or returns "" if nothing is found to be.
+func diagnoseKey(buf []byte, private bool, algorithm string) string {
+	if len(bytes.TrimSpace(buf)) == 0 {
+		return "the file is empty"
+	}
+	der := buf
+	isPEM := bytes.Contains(buf, []byte("-----BEGIN"))
+	if isPEM {
+		block, _ := pem.Decode(buf)
+		if block == nil {
+			return "the PEM block is truncated or malformed"
+		}
+		der = block.Bytes
+	}
+
+	key, isPrivate, ok := parseAnyKey(der)
+	switch {
+	case !ok && isPEM:
+		block, _ := pem.Decode(buf)
+		if !bytes.Contains([]byte(block.Type), []byte("KEY")) && block.Type != "CERTIFICATE" {
+			return fmt.Sprintf("unexpected PEM block type %q, expected a key", block.Type)
+		}
+		return "the PEM block doesn't hold a valid key, it may be truncated"
+	case !ok:
+		return "it isn't a DER or PEM encoded key, it may be truncated or corrupted"
+	case isPrivate && !private:
+		return "it holds a private key where a public key was expected"
+	case !isPrivate && private:
+		return "it holds a public key where the private key was expected"
+	}
+	if name := keyAlgorithm(key); name != algorithm {
+		return fmt.Sprintf("it holds an %s key, expected %s", name, algorithm)
+	}
+	return ""
+}
+
+// parseAnyKey parses a DER encoded private key, public key or
+// certificate, of any algorithm, and returns the key and whether it is
+// private.
+func parseAnyKey(der []byte) (interface{}, bool, bool) {
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, true, true
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, true, true
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, true, true
+	}
+	if key, err := x509.ParsePKIXPublicKey(der); err == nil {
+		return key, false, true
+	}
+	if key, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return key, false, true
+	}
+	if cert, err := x509.ParseCertificate(der); err == nil {
+		return cert.PublicKey, false, true
+	}
+	return nil, false, false
+}
+
+// keyAlgorithm names the algorithm of a key, e.g. "ECDSA P-256".
+func keyAlgorithm(key interface{}) string {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return "ECDSA " + k.Params().Name
+	case *ecdsa.PublicKey:
+		return "ECDSA " + k.Params().Name
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return keyAlgorithmEdDSA
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return "RSA"
+	}
+	return fmt.Sprintf("%T", key)
+}
+
+// keyDER returns the DER key in buf, decoding it first if it is PEM.
+func keyDER(buf []byte) []byte {
+	if block, _ := pem.Decode(buf); block != nil {
+		return block.Bytes
+	}
+	return buf
+}
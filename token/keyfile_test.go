@@ -0,0 +1,167 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testKeyFiles holds DER encoded keys of several algorithms.
+type testKeyFiles struct {
+	ecPriv, ecPub     []byte
+	p384Priv, p384Pub []byte
+	edPriv, edPub     []byte
+	rsaPriv, rsaPub   []byte
+}
+
+func newTestKeyFiles(t *testing.T) testKeyFiles {
+	var files testKeyFiles
+	var err error
+	ec, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if files.ecPriv, err = x509.MarshalECPrivateKey(ec); err != nil {
+		t.Fatalf("marshalling key: %v", err)
+	}
+	files.ecPub, _ = x509.MarshalPKIXPublicKey(&ec.PublicKey)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	files.p384Priv, _ = x509.MarshalECPrivateKey(p384)
+	files.p384Pub, _ = x509.MarshalPKIXPublicKey(&p384.PublicKey)
+	edPub, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	files.edPriv, _ = x509.MarshalPKCS8PrivateKey(edPriv)
+	files.edPub, _ = x509.MarshalPKIXPublicKey(edPub)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+	files.rsaPriv = x509.MarshalPKCS1PrivateKey(rsaKey)
+	files.rsaPub, _ = x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	return files
+}
+
+func pemBlock(blockType string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
+
+func TestMalformedKeyFiles(t *testing.T) {
+	keys := newTestKeyFiles(t)
+	truncatedPEM := pemBlock("EC PRIVATE KEY", keys.ecPriv)
+	truncatedPEM = truncatedPEM[:len(truncatedPEM)/2]
+
+	cases := []struct {
+		name      string
+		algorithm string
+		private   bool
+		contents  []byte
+		problem   string
+	}{
+		{name: "empty private key", private: true, contents: []byte("\n"), problem: "the file is empty"},
+		{name: "empty public key", contents: nil, problem: "the file is empty"},
+		{name: "truncated PEM", private: true, contents: truncatedPEM, problem: "the PEM block is truncated or malformed"},
+		{name: "truncated DER private key", private: true, contents: keys.ecPriv[:len(keys.ecPriv)-10], problem: "it isn't a DER or PEM encoded key"},
+		{name: "truncated DER public key", contents: keys.ecPub[:20], problem: "it isn't a DER or PEM encoded key"},
+		{name: "truncated key in PEM", contents: pemBlock("PUBLIC KEY", keys.ecPub[:20]), problem: "the PEM block doesn't hold a valid key"},
+		{name: "certificate request", contents: pemBlock("CERTIFICATE REQUEST", []byte("not a key")), problem: `unexpected PEM block type "CERTIFICATE REQUEST"`},
+		{name: "public key as private key", private: true, contents: keys.ecPub, problem: "it holds a public key where the private key was expected"},
+		{name: "private key as public key", contents: keys.ecPriv, problem: "it holds a private key where a public key was expected"},
+		{name: "RSA private key", private: true, contents: keys.rsaPriv, problem: "it holds an RSA key, expected ECDSA P-256"},
+		{name: "RSA public key", contents: pemBlock("PUBLIC KEY", keys.rsaPub), problem: "it holds an RSA key, expected ECDSA P-256"},
+		{name: "P-384 private key", private: true, contents: keys.p384Priv, problem: "it holds an ECDSA P-384 key, expected ECDSA P-256"},
+		{name: "P-384 public key", contents: keys.p384Pub, problem: "it holds an ECDSA P-384 key, expected ECDSA P-256"},
+		{name: "EdDSA private key for ES256", private: true, contents: keys.edPriv, problem: "it holds an Ed25519 key, expected ECDSA P-256"},
+		{name: "EdDSA public key for ES256", contents: keys.edPub, problem: "it holds an Ed25519 key, expected ECDSA P-256"},
+		{name: "ES256 private key for EdDSA", algorithm: AlgorithmEdDSA, private: true, contents: keys.ecPriv, problem: "it holds an ECDSA P-256 key, expected Ed25519"},
+		{name: "ES256 public key for EdDSA", algorithm: AlgorithmEdDSA, contents: keys.ecPub, problem: "it holds an ECDSA P-256 key, expected Ed25519"},
+		{name: "EdDSA public key as private key", algorithm: AlgorithmEdDSA, private: true, contents: keys.edPub, problem: "it holds a public key where the private key was expected"},
+		{name: "truncated EdDSA public key", algorithm: AlgorithmEdDSA, contents: keys.edPub[:len(keys.edPub)-1], problem: "it isn't a DER or PEM encoded key"},
+	}
+	for _, c := range cases {
+		dir := newTestKeypairDir(t)
+		if c.algorithm == AlgorithmEdDSA {
+			dir = newTestEdDSAKeypairDir(t)
+		}
+		file := getPublicKeyFilename(dir)
+		if c.private {
+			file = getPrivateKeyFilename(dir)
+		}
+		if err := ioutil.WriteFile(file, c.contents, 0600); err != nil {
+			t.Fatalf("writing key: %v", err)
+		}
+
+		var err error
+		if c.private {
+			_, err = NewSigner(dir, SignerOptions{Algorithm: c.algorithm})
+		} else {
+			_, err = NewVerifierForAlgorithm(dir, c.algorithm)
+		}
+		var keyErr *KeyFileError
+		if !errors.As(err, &keyErr) {
+			t.Errorf("%s: expected a key file error, got %v", c.name, err)
+			continue
+		}
+		if keyErr.File != file || !strings.Contains(err.Error(), file) {
+			t.Errorf("%s: expected the error to name %s, got %v", c.name, file, err)
+		}
+		if !strings.HasPrefix(keyErr.Problem, c.problem) {
+			t.Errorf("%s: expected the problem to be %q, got %q", c.name, c.problem, keyErr.Problem)
+		}
+	}
+}
+
+func TestMalformedBackupKeyFile(t *testing.T) {
+	keys := newTestKeyFiles(t)
+	file := filepath.Join(t.TempDir(), "old.pub")
+	if err := ioutil.WriteFile(file, keys.rsaPub, 0644); err != nil {
+		t.Fatalf("writing key: %v", err)
+	}
+	_, err := NewVerifierWithBackupKeys(newTestKeypairDir(t), []string{file})
+	var keyErr *KeyFileError
+	if !errors.As(err, &keyErr) || keyErr.File != file {
+		t.Fatalf("expected a key file error for %s, got %v", file, err)
+	}
+	if !strings.Contains(err.Error(), "backup verification key") {
+		t.Errorf("expected the error to say it is a backup key, got %v", err)
+	}
+
+	if _, err := NewVerifierFromPublicKey(keys.ecPriv); !errors.As(err, &keyErr) || keyErr.File != "" {
+		t.Errorf("expected a key error without a file, got %v", err)
+	}
+}
+
+func TestPEMEncodedEdDSAKeypair(t *testing.T) {
+	dir := newTestEdDSAKeypairDir(t)
+	for _, path := range []struct{ file, blockType string }{
+		{getPrivateKeyFilename(dir), "PRIVATE KEY"},
+		{getPublicKeyFilename(dir), "PUBLIC KEY"},
+	} {
+		der, err := ioutil.ReadFile(path.file)
+		if err != nil {
+			t.Fatalf("reading key: %v", err)
+		}
+		if err := ioutil.WriteFile(path.file, pemBlock(path.blockType, der), 0600); err != nil {
+			t.Fatalf("writing key: %v", err)
+		}
+	}
+	signer, err := NewSigner(dir, SignerOptions{Algorithm: AlgorithmEdDSA})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	verifier, err := NewVerifierForAlgorithm(dir, AlgorithmEdDSA)
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	signed, err := signer.Sign(validTestToken())
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	if _, err := verifier.Verify(signed); err != nil {
+		t.Errorf("expected the token to verify, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+// What a trusted key is used for, in KeyInfo.
+const (
+	// KeyUsePrimary is the key of the keypair tokens are signed with.
+	KeyUsePrimary = "primary"
+	// KeyUseBackup keys are trusted for verification only.
+	KeyUseBackup = "backup"
+	// KeyUseJWKS keys are published at the JWKS URL.
+	KeyUseJWKS = "jwks"
+	// KeyUseOIDC keys are the OIDC provider's.
+	KeyUseOIDC = "oidc"
+	// KeyUseRetired keys were replaced by a key rotation, and are trusted
+	// for verification until its overlap ends.
+	KeyUseRetired = "retired"
+)
+
+// KeyInfo is public metadata about a key a verifier trusts, for
+// operators to check e.g. that a rotation completed. It never holds key
+// material beyond the thumbprint of the public key.
+type KeyInfo struct {
+	// KeyID is the kid of the key, for keys that have one.
+	KeyID     string `json:"kid,omitempty"`
+	Algorithm string `json:"alg"`
+	// Thumbprint is the RFC 7638 SHA-256 thumbprint of the public key,
+	// base64url encoded.
+	Thumbprint string    `json:"thumbprint"`
+	Use        string    `json:"use"`
+	LoadedAt   time.Time `json:"loadedAt"`
+}
+
+// KeyLister is implemented by verifiers that can tell which keys they
+// trust.
+type KeyLister interface {
+	TrustedKeys() []KeyInfo
+}
+
+func (ev *ecdsaVerifier) TrustedKeys() []KeyInfo {
+	keys := []KeyInfo{ecdsaKeyInfo(ev.publicKey, KeyUsePrimary, ev.loadedAt)}
+	for _, key := range ev.backupKeys {
+		keys = append(keys, ecdsaKeyInfo(key, KeyUseBackup, ev.loadedAt))
+	}
+	return keys
+}
+
+func ecdsaKeyInfo(key *ecdsa.PublicKey, use string, loadedAt time.Time) KeyInfo {
+	return KeyInfo{
+		Algorithm:  AlgorithmES256,
+		Thumbprint: jwkThumbprint(&jose.JsonWebKey{Key: key}),
+		Use:        use,
+		LoadedAt:   loadedAt,
+	}
+}
+
+func (ev *ed25519Verifier) TrustedKeys() []KeyInfo {
+	return []KeyInfo{{
+		Algorithm:  AlgorithmEdDSA,
+		Thumbprint: ed25519Thumbprint(ev.publicKey),
+		Use:        KeyUsePrimary,
+		LoadedAt:   ev.loadedAt,
+	}}
+}
+
+func (jv *jwksVerifier) TrustedKeys() []KeyInfo {
+	return jv.keyInfos(KeyUseJWKS)
+}
+
+func (jv *jwksVerifier) keyInfos(use string) []KeyInfo {
+	jv.mu.RLock()
+	defer jv.mu.RUnlock()
+	keys := make([]KeyInfo, 0, len(jv.keys))
+	for i := range jv.keys {
+		key := &jv.keys[i]
+		keys = append(keys, KeyInfo{
+			KeyID:      key.KeyID,
+			Algorithm:  key.Algorithm,
+			Thumbprint: jwkThumbprint(key),
+			Use:        use,
+			LoadedAt:   jv.keysLoadedAt,
+		})
+	}
+	return keys
+}
+
+func (ov *oidcVerifier) TrustedKeys() []KeyInfo {
+	return ov.keys.keyInfos(KeyUseOIDC)
+}
+
+// jwkThumbprint returns the thumbprint of key, or empty for keys go-jose
+// can't take the thumbprint of.
+func jwkThumbprint(key *jose.JsonWebKey) string {
+	sum, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(sum)
+}
+
+// ed25519Thumbprint returns the RFC 8037 thumbprint of key, which go-jose
+// v1 predates.
+func ed25519Thumbprint(key ed25519.PublicKey) string {
+	// The members are in lexicographic order, as RFC 7638 requires.
+	input, _ := json.Marshal(struct {
+		Crv string `json:"crv"`
+		Kty string `json:"kty"`
+		X   string `json:"x"`
+	}{"Ed25519", "OKP", base64.RawURLEncoding.EncodeToString(key)})
+	sum := sha256.Sum256(input)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,110 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// ecThumbprint is the RFC 7638 thumbprint of a P-256 key, computed
+// independently of go-jose.
+func ecThumbprint(key *ecdsa.PublicKey) string {
+	coord := func(b []byte) string {
+		padded := make([]byte, 32)
+		copy(padded[32-len(b):], b)
+		return base64.RawURLEncoding.EncodeToString(padded)
+	}
+	input := fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`, coord(key.X.Bytes()), coord(key.Y.Bytes()))
+	sum := sha256.Sum256([]byte(input))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestTrustedKeys(t *testing.T) {
+	primaryDir := newTestKeypairDir(t)
+	backupDir := newTestKeypairDir(t)
+	before := time.Now()
+	v, err := NewVerifierWithBackupKeys(primaryDir, []string{getPublicKeyFilename(backupDir)})
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	primary, _ := loadPublicKey(primaryDir)
+	backup, _ := loadPublicKey(backupDir)
+
+	keys := v.(KeyLister).TrustedKeys()
+	expected := []KeyInfo{
+		{Algorithm: AlgorithmES256, Thumbprint: ecThumbprint(primary), Use: KeyUsePrimary},
+		{Algorithm: AlgorithmES256, Thumbprint: ecThumbprint(backup), Use: KeyUseBackup},
+	}
+	if len(keys) != len(expected) {
+		t.Fatalf("expected %d keys, got %+v", len(expected), keys)
+	}
+	for i, key := range keys {
+		if key.LoadedAt.Before(before) || key.LoadedAt.After(time.Now()) {
+			t.Errorf("expected key %d to be loaded just now, got %v", i, key.LoadedAt)
+		}
+		key.LoadedAt = time.Time{}
+		if key != expected[i] {
+			t.Errorf("expected key %d to be %+v, got %+v", i, expected[i], key)
+		}
+	}
+}
+
+func TestTrustedKeysEdDSA(t *testing.T) {
+	// The key of RFC 8037 appendix A.3, with its thumbprint.
+	x, _ := base64.RawURLEncoding.DecodeString("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
+	ev := &ed25519Verifier{publicKey: ed25519.PublicKey(x)}
+	keys := ev.TrustedKeys()
+	if len(keys) != 1 || keys[0].Thumbprint != "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k" || keys[0].Algorithm != AlgorithmEdDSA {
+		t.Errorf("expected the RFC 8037 thumbprint, got %+v", keys)
+	}
+
+	dir := t.TempDir()
+	if err := GenerateKeypairForAlgorithm(dir, AlgorithmEdDSA); err != nil {
+		t.Fatalf("generating keypair: %v", err)
+	}
+	v, err := NewVerifierForAlgorithm(dir, AlgorithmEdDSA)
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	if keys := v.(KeyLister).TrustedKeys(); len(keys) != 1 || keys[0].Use != KeyUsePrimary || keys[0].LoadedAt.IsZero() {
+		t.Errorf("expected the primary EdDSA key, got %+v", keys)
+	}
+}
+
+func TestTrustedKeysJWKS(t *testing.T) {
+	priv1, pub1 := newTestKey(t, "key-1")
+	priv2, pub2 := newTestKey(t, "key-2")
+	fake := &fakeJWKS{}
+	fake.setKeys(pub1)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	v, err := NewJWKSVerifier(srv.URL, time.Hour, FetchOptions{})
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	jv := v.(*jwksVerifier)
+	jv.minRefetch = 0
+	firstLoad := jv.TrustedKeys()
+	if len(firstLoad) != 1 || firstLoad[0].KeyID != "key-1" || firstLoad[0].Thumbprint != ecThumbprint(&priv1.PublicKey) || firstLoad[0].Use != KeyUseJWKS {
+		t.Fatalf("expected key-1, got %+v", firstLoad)
+	}
+
+	// After a rotation, only the new key is listed, as loaded later.
+	fake.setKeys(pub2)
+	if _, err := v.Verify(signTestToken(t, priv2, "key-2", validTestToken())); err != nil {
+		t.Fatalf("expected a token signed by key-2 to verify after the rotation: %v", err)
+	}
+	keys := jv.TrustedKeys()
+	if len(keys) != 1 || keys[0].KeyID != "key-2" || keys[0].Thumbprint != ecThumbprint(&priv2.PublicKey) {
+		t.Fatalf("expected key-2, got %+v", keys)
+	}
+	if keys[0].LoadedAt.Before(firstLoad[0].LoadedAt) {
+		t.Errorf("expected key-2 to be loaded after key-1, got %v before %v", keys[0].LoadedAt, firstLoad[0].LoadedAt)
+	}
+}
@@ -0,0 +1,82 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrTokenNotYetValid is returned for a token whose not-before time is
+// further in the future than the allowed leeway.
+var ErrTokenNotYetValid = errors.New("token is not valid yet")
+
+// LeewayOptions are the clock skews tolerated when checking the time
+// claims of a token, for issuers and verifiers whose clocks disagree.
+type LeewayOptions struct {
+	// Skew is the leeway of every claim whose own leeway is left zero.
+	Skew time.Duration
+	// ExpiryLeeway is how long after its expiration a token is still
+	// accepted.
+	ExpiryLeeway time.Duration
+	// NotBeforeLeeway is how long before its not-before time a token is
+	// already accepted.
+	NotBeforeLeeway time.Duration
+	// IssuedAtLeeway is how far in the future a token may claim to be
+	// issued.
+	IssuedAtLeeway time.Duration
+}
+
+func (o LeewayOptions) orSkew(leeway time.Duration) time.Duration {
+	if leeway == 0 {
+		return o.Skew
+	}
+	return leeway
+}
+
+// leewayVerifier checks the time claims of the tokens returned by an
+// Inspector, which checks everything else.
+type leewayVerifier struct {
+	inspector Inspector
+	opts      LeewayOptions
+	// now is overridden by tests.
+	now func() time.Time
+}
+
+// NewLeewayVerifier returns a verifier that accepts the tokens with a
+// valid signature according to inspector whose expiration, not-before
+// and issued-at times hold with the leeways of opts. Tokens without a
+// not-before or issued-at time aren't checked for them.
+func NewLeewayVerifier(inspector Inspector, opts LeewayOptions) Verifier {
+	return &leewayVerifier{inspector: inspector, opts: opts, now: time.Now}
+}
+
+func (lv *leewayVerifier) Verify(s string) (*AuthToken, error) {
+	token, _, err := lv.inspector.Inspect(s)
+	if err != nil {
+		return nil, err
+	}
+	now := lv.now()
+
+	leeway := lv.opts.orSkew(lv.opts.ExpiryLeeway)
+	if expiredFor := now.Sub(millisTime(token.Expiration)); expiredFor > leeway {
+		return nil, newVerifyError(ReasonExpired, fmt.Errorf("%w: expired %v ago, allowed leeway is %v", ErrTokenExpired, expiredFor.Round(time.Second), leeway))
+	}
+	if token.NotBefore != 0 {
+		leeway := lv.opts.orSkew(lv.opts.NotBeforeLeeway)
+		if early := millisTime(token.NotBefore).Sub(now); early > leeway {
+			return nil, newVerifyError(ReasonNotYetValid, fmt.Errorf("%w: valid in %v, allowed leeway is %v", ErrTokenNotYetValid, early.Round(time.Second), leeway))
+		}
+	}
+	if token.IssuedAt != 0 {
+		leeway := lv.opts.orSkew(lv.opts.IssuedAtLeeway)
+		if ahead := millisTime(token.IssuedAt).Sub(now); ahead > leeway {
+			return nil, newVerifyError(ReasonIssuedInFuture, fmt.Errorf("%w: issued %v from now, allowed leeway is %v", ErrIssuedInFuture, ahead.Round(time.Second), leeway))
+		}
+	}
+	return token, nil
+}
+
+// millisTime converts a time claim in unix milliseconds.
+func millisTime(millis int64) time.Time {
+	return time.Unix(0, millis*int64(time.Millisecond))
+}
@@ -0,0 +1,95 @@
+package token
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// staticInspector returns tok for any token, as if validly signed.
+type staticInspector struct {
+	tok *AuthToken
+}
+
+func (si staticInspector) Inspect(s string) (*AuthToken, bool, error) {
+	copied := *si.tok
+	return &copied, TokenExpired(&copied), nil
+}
+
+func TestLeewayVerifier(t *testing.T) {
+	now := time.Now()
+	millis := func(d time.Duration) int64 { return now.Add(d).UnixNano() / int64(time.Millisecond) }
+	// Each leeway is set to a distinct value, so that a claim checked
+	// with another claim's leeway is caught.
+	opts := LeewayOptions{Skew: time.Minute, ExpiryLeeway: 10 * time.Minute, NotBeforeLeeway: 20 * time.Minute, IssuedAtLeeway: 30 * time.Minute}
+
+	cases := []struct {
+		name        string
+		opts        LeewayOptions
+		tok         AuthToken
+		expectedErr error
+	}{
+		{name: "valid", opts: opts, tok: AuthToken{Expiration: millis(time.Hour), NotBefore: millis(-time.Hour), IssuedAt: millis(-time.Hour)}},
+		{name: "expired within the expiry leeway", opts: opts, tok: AuthToken{Expiration: millis(-9 * time.Minute)}},
+		{name: "expired beyond the expiry leeway", opts: opts, tok: AuthToken{Expiration: millis(-11 * time.Minute)}, expectedErr: ErrTokenExpired},
+		{name: "not valid yet within the not-before leeway", opts: opts, tok: AuthToken{Expiration: millis(time.Hour), NotBefore: millis(19 * time.Minute)}},
+		{name: "not valid yet beyond the not-before leeway", opts: opts, tok: AuthToken{Expiration: millis(time.Hour), NotBefore: millis(21 * time.Minute)}, expectedErr: ErrTokenNotYetValid},
+		{name: "issued in the future within the issued-at leeway", opts: opts, tok: AuthToken{Expiration: millis(time.Hour), IssuedAt: millis(29 * time.Minute)}},
+		{name: "issued in the future beyond the issued-at leeway", opts: opts, tok: AuthToken{Expiration: millis(time.Hour), IssuedAt: millis(31 * time.Minute)}, expectedErr: ErrIssuedInFuture},
+
+		// Leeways left zero default to the skew.
+		{name: "expired within the skew", opts: LeewayOptions{Skew: 5 * time.Minute}, tok: AuthToken{Expiration: millis(-4 * time.Minute)}},
+		{name: "expired beyond the skew", opts: LeewayOptions{Skew: 5 * time.Minute}, tok: AuthToken{Expiration: millis(-6 * time.Minute)}, expectedErr: ErrTokenExpired},
+		{name: "not valid yet within the skew", opts: LeewayOptions{Skew: 5 * time.Minute}, tok: AuthToken{Expiration: millis(time.Hour), NotBefore: millis(4 * time.Minute)}},
+		{name: "not valid yet beyond the skew", opts: LeewayOptions{Skew: 5 * time.Minute}, tok: AuthToken{Expiration: millis(time.Hour), NotBefore: millis(6 * time.Minute)}, expectedErr: ErrTokenNotYetValid},
+		{name: "issued in the future within the skew", opts: LeewayOptions{Skew: 5 * time.Minute}, tok: AuthToken{Expiration: millis(time.Hour), IssuedAt: millis(4 * time.Minute)}},
+		{name: "issued in the future beyond the skew", opts: LeewayOptions{Skew: 5 * time.Minute}, tok: AuthToken{Expiration: millis(time.Hour), IssuedAt: millis(6 * time.Minute)}, expectedErr: ErrIssuedInFuture},
+		{name: "no leeway", tok: AuthToken{Expiration: millis(-time.Second)}, expectedErr: ErrTokenExpired},
+	}
+
+	for _, c := range cases {
+		c.tok.Username = "alice"
+		v := NewLeewayVerifier(staticInspector{&c.tok}, c.opts)
+		v.(*leewayVerifier).now = func() time.Time { return now }
+
+		tok, err := v.Verify("token")
+		if c.expectedErr == nil {
+			if err != nil || tok.Username != "alice" {
+				t.Errorf("%s: expected the token to be accepted, got %v", c.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, c.expectedErr) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expectedErr, err)
+		}
+	}
+}
+
+func TestLeewayVerifierSignedToken(t *testing.T) {
+	dir := newTestKeypairDir(t)
+	signer, err := NewSigner(dir, SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	inspector, err := NewInspector(dir)
+	if err != nil {
+		t.Fatalf("creating inspector: %v", err)
+	}
+
+	tok := validTestToken()
+	tok.Expiration = time.Now().Add(-time.Minute).UnixNano() / int64(time.Millisecond)
+	signed, err := signer.Sign(tok)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+
+	if _, err := NewLeewayVerifier(inspector, LeewayOptions{ExpiryLeeway: 5 * time.Minute}).Verify(signed); err != nil {
+		t.Errorf("expected the token to be within the expiry leeway: %v", err)
+	}
+	if _, err := NewLeewayVerifier(inspector, LeewayOptions{}).Verify(signed); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("expected the token to be expired without a leeway, got %v", err)
+	}
+	if _, err := NewLeewayVerifier(inspector, LeewayOptions{ExpiryLeeway: 5 * time.Minute}).Verify(signed + "x"); err == nil {
+		t.Errorf("expected a bad signature to be rejected")
+	}
+}
@@ -0,0 +1,48 @@
+package token
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrTokenTooOld is returned by a max age verifier for a token issued
+// longer ago than the maximum age. It wraps ErrTokenExpired, so clients
+// are told to get a new token.
+var ErrTokenTooOld = fmt.Errorf("%w: token is older than the maximum token age", ErrTokenExpired)
+
+// maxAgeVerifier rejects tokens issued too long ago, whatever their
+// Expiration says.
+type maxAgeVerifier struct {
+	verifier Verifier
+	maxAge   time.Duration
+	// now is overridden by tests.
+	now func() time.Time
+}
+
+// NewMaxAgeVerifier returns a verifier that accepts the tokens accepted
+// by verifier, as long as they were issued no more than maxAge ago.
+// This bounds the lifetime of mis-issued long-lived tokens. Tokens
+// without an IssuedAt time are rejected. A zero maxAge returns verifier
+// unchanged.
+func NewMaxAgeVerifier(verifier Verifier, maxAge time.Duration) Verifier {
+	if maxAge <= 0 {
+		return verifier
+	}
+	return &maxAgeVerifier{verifier: verifier, maxAge: maxAge, now: time.Now}
+}
+
+func (mv *maxAgeVerifier) Verify(s string) (*AuthToken, error) {
+	token, err := mv.verifier.Verify(s)
+	if err != nil {
+		return nil, err
+	}
+	if token.IssuedAt == 0 {
+		return nil, newVerifyError(ReasonTooOld, fmt.Errorf("%w: token has no issue time", ErrTokenTooOld))
+	}
+
+	issuedAt := time.Unix(0, token.IssuedAt*int64(time.Millisecond))
+	if age := mv.now().Sub(issuedAt); age > mv.maxAge {
+		return nil, newVerifyError(ReasonTooOld, fmt.Errorf("%w: issued %v ago, limit is %v", ErrTokenTooOld, age.Round(time.Second), mv.maxAge))
+	}
+	return token, nil
+}
@@ -0,0 +1,85 @@
+package token
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// staticVerifier accepts every token and returns a copy of tok.
+type staticVerifier struct {
+	tok *AuthToken
+}
+
+func (sv staticVerifier) Verify(s string) (*AuthToken, error) {
+	tok := *sv.tok
+	return &tok, nil
+}
+
+func TestMaxAgeVerifier(t *testing.T) {
+	now := time.Now()
+	millis := func(t time.Time) int64 { return t.UnixNano() / int64(time.Millisecond) }
+	farFuture := millis(now.Add(365 * 24 * time.Hour))
+
+	cases := []struct {
+		name      string
+		issuedAt  int64
+		expectErr bool
+	}{
+		{name: "recent token", issuedAt: millis(now.Add(-time.Hour))},
+		{name: "old token with a distant expiry", issuedAt: millis(now.Add(-48 * time.Hour)), expectErr: true},
+		{name: "token without an issue time", issuedAt: 0, expectErr: true},
+	}
+
+	for _, c := range cases {
+		v := NewMaxAgeVerifier(staticVerifier{&AuthToken{Username: "alice", Expiration: farFuture, IssuedAt: c.issuedAt}}, 24*time.Hour)
+		v.(*maxAgeVerifier).now = func() time.Time { return now }
+
+		tok, err := v.Verify("token")
+		if !c.expectErr {
+			if err != nil || tok.Username != "alice" {
+				t.Errorf("%s: expected the token to be accepted, got %v", c.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrTokenTooOld) {
+			t.Errorf("%s: expected ErrTokenTooOld, got %v", c.name, err)
+		}
+		if !errors.Is(err, ErrTokenExpired) {
+			t.Errorf("%s: expected the error to count as expired, got %v", c.name, err)
+		}
+	}
+
+	// Without a max age, the verifier is used as is.
+	inner := staticVerifier{&AuthToken{Username: "alice", Expiration: farFuture}}
+	if v := NewMaxAgeVerifier(inner, 0); v != Verifier(inner) {
+		t.Errorf("expected a zero max age to return the verifier unchanged")
+	}
+}
+
+func TestMaxAgeVerifierSignedToken(t *testing.T) {
+	dir := newTestKeypairDir(t)
+	signer, err := NewSigner(dir, SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	verifier, err := NewVerifier(dir)
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+
+	tok := validTestToken()
+	tok.Expiration = time.Now().Add(365*24*time.Hour).UnixNano() / int64(time.Millisecond)
+	tok.IssuedAt = time.Now().Add(-30*24*time.Hour).UnixNano() / int64(time.Millisecond)
+	signed, err := signer.Sign(tok)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+
+	if _, err := verifier.Verify(signed); err != nil {
+		t.Fatalf("expected the token to verify without a max age: %v", err)
+	}
+	if _, err := NewMaxAgeVerifier(verifier, 24*time.Hour).Verify(signed); !errors.Is(err, ErrTokenTooOld) {
+		t.Errorf("expected the 30 day old token to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package token
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// oidcVerifier verifies ID tokens issued by an external OpenID Connect
+// provider, using the provider's published JWKS.
+type oidcVerifier struct {
+	issuer   string
+	clientID string
+	prefixes OIDCPrefixes
+	keys     *jwksVerifier
+}
+
+// OIDCPrefixes are prepended to the usernames and groups of ID tokens,
+// so that a provider can't assert the identity or the groups of a
+// directory user. Empty prefixes map them as they are.
+type OIDCPrefixes struct {
+	Username string
+	Groups   string
+}
+
+// oidcDiscovery is the subset of the provider's
+// .well-known/openid-configuration document we need.
+type oidcDiscovery struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// oidcClaims are the ID token claims mapped into an AuthToken.
+type oidcClaims struct {
+	Issuer            string       `json:"iss"`
+	Subject           string       `json:"sub"`
+	Audience          oidcAudience `json:"aud"`
+	Expiry            int64        `json:"exp"`
+	NotBefore         int64        `json:"nbf"`
+	IssuedAt          int64        `json:"iat"`
+	PreferredUsername string       `json:"preferred_username"`
+	Groups            []string     `json:"groups"`
+}
+
+// oidcAudience accepts the aud claim as either a string or an array.
+type oidcAudience []string
+
+func (a *oidcAudience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = oidcAudience{single}
+		return nil
+	}
+	var multi []string
+	if err := json.Unmarshal(b, &multi); err != nil {
+		return err
+	}
+	*a = multi
+	return nil
+}
+
+func (a oidcAudience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// NewOIDCVerifier returns a verifier for ID tokens from the OIDC provider
+// at issuerURL. The provider's keys are found through OIDC discovery, and
+// tokens must be issued for clientID. Their usernames and groups are
+// prefixed with prefixes. The discovery document and keys are fetched as
+// configured by opts.
+func NewOIDCVerifier(issuerURL, clientID string, prefixes OIDCPrefixes, refreshInterval time.Duration, opts FetchOptions) (Verifier, error) {
+	issuerURL = strings.TrimSuffix(issuerURL, "/")
+
+	body, _, err := fetch(opts.client(), issuerURL+"/.well-known/openid-configuration", opts)
+	if err != nil {
+		return nil, fmt.Errorf("fetching OIDC discovery document: %v", err)
+	}
+
+	discovery := &oidcDiscovery{}
+	if err := json.Unmarshal(body, discovery); err != nil {
+		return nil, fmt.Errorf("decoding OIDC discovery document: %v", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuerURL {
+		return nil, fmt.Errorf("OIDC discovery document is for issuer %q, expected %q", discovery.Issuer, issuerURL)
+	}
+	if discovery.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document has no jwks_uri")
+	}
+
+	keys, err := newJWKSVerifier(discovery.JWKSURI, refreshInterval, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidcVerifier{
+		issuer:   discovery.Issuer,
+		clientID: clientID,
+		prefixes: prefixes,
+		keys:     keys,
+	}, nil
+}
+
+// Verify checks the ID token's signature and its iss, aud, exp and nbf
+// claims, and maps preferred_username and groups into an AuthToken.
+func (ov *oidcVerifier) Verify(s string) (*AuthToken, error) {
+	token, expired, err := ov.Inspect(s)
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return nil, newVerifyError(ReasonExpired, ErrTokenExpired)
+	}
+	if token.NotBefore > time.Now().Unix()*1000 {
+		return nil, newVerifyError(ReasonNotYetValid, ErrTokenNotYetValid)
+	}
+	return token, nil
+}
+
+// Inspect is like Verify, but returns the token even if it has expired
+// or isn't valid yet, e.g. for NewLeewayVerifier to check those claims.
+func (ov *oidcVerifier) Inspect(s string) (*AuthToken, bool, error) {
+	payload, err := ov.keys.verifySignature(s)
+	if err != nil {
+		return nil, false, err
+	}
+
+	claims := &oidcClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, false, newVerifyError(ReasonMalformed, fmt.Errorf("decoding OIDC claims: %v", err))
+	}
+
+	if claims.Issuer != ov.issuer {
+		return nil, false, newVerifyError(ReasonWrongIssuer, fmt.Errorf("token issued by %q, expected %q", claims.Issuer, ov.issuer))
+	}
+	if !claims.Audience.contains(ov.clientID) {
+		return nil, false, newVerifyError(ReasonWrongAudience, fmt.Errorf("token audience %v does not include %q", []string(claims.Audience), ov.clientID))
+	}
+	if claims.PreferredUsername == "" {
+		return nil, false, newVerifyError(ReasonMalformed, errors.New("token has no preferred_username claim"))
+	}
+
+	var groups []string
+	for _, group := range claims.Groups {
+		groups = append(groups, ov.prefixes.Groups+group)
+	}
+
+	expired := claims.Expiry == 0 || claims.Expiry < time.Now().Unix()
+	return &AuthToken{
+		Username: ov.prefixes.Username + claims.PreferredUsername,
+		Groups:   groups,
+		Assertions: map[string]string{
+			"iss":               claims.Issuer,
+			"sub":               claims.Subject,
+			AuthMethodAssertion: AuthMethodOIDC,
+		},
+		Expiration: claims.Expiry * 1000,
+		IssuedAt:   claims.IssuedAt * 1000,
+		NotBefore:  claims.NotBefore * 1000,
+	}, expired, nil
+}
+
+// multiVerifier tries each verifier in turn and accepts the token if any
+// of them does.
+type multiVerifier struct {
+	verifiers []Verifier
+}
+
+// NewMultiVerifier returns a verifier that accepts tokens valid for any
+// of the given verifiers, e.g. native tokens alongside OIDC ID tokens.
+// If every verifier rejects the token, the first verifier's error is
+// returned, unless another one found the token expired.
+func NewMultiVerifier(verifiers ...Verifier) Verifier {
+	return &multiVerifier{verifiers: verifiers}
+}
+
+func (mv *multiVerifier) Verify(s string) (*AuthToken, error) {
+	var firstErr error
+	for _, v := range mv.verifiers {
+		token, err := v.Verify(s)
+		if err == nil {
+			return token, nil
+		}
+		if errors.Is(err, ErrTokenExpired) {
+			return nil, err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = errors.New("no token verifiers configured")
+	}
+	return nil, firstErr
+}
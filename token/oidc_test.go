@@ -0,0 +1,206 @@
+package token
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newFakeOIDCProvider serves a discovery document pointing at the given
+// JWKS handler, and returns the provider's issuer URL.
+func newFakeOIDCProvider(t *testing.T, jwks http.Handler) *httptest.Server {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:  srv.URL,
+			JWKSURI: srv.URL + "/keys",
+		})
+	})
+	mux.Handle("/keys", jwks)
+	return srv
+}
+
+func TestOIDCVerifier(t *testing.T) {
+	priv, pub := newTestKey(t, "oidc-key")
+	otherPriv, _ := newTestKey(t, "oidc-key")
+
+	fake := &fakeJWKS{}
+	fake.setKeys(pub)
+	srv := newFakeOIDCProvider(t, fake)
+	defer srv.Close()
+
+	v, err := NewOIDCVerifier(srv.URL+"/", "kubernetes", OIDCPrefixes{}, time.Hour, FetchOptions{})
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+
+	now := time.Now().Unix()
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":                srv.URL,
+			"sub":                "00u1abcd",
+			"aud":                "kubernetes",
+			"exp":                now + 3600,
+			"preferred_username": "alice@example.com",
+			"groups":             []string{"admins", "devs"},
+		}
+	}
+
+	tok, err := v.Verify(signTestClaims(t, priv, "oidc-key", validClaims()))
+	if err != nil {
+		t.Fatalf("expected valid ID token to verify: %v", err)
+	}
+	if tok.Username != "alice@example.com" {
+		t.Errorf("expected username alice@example.com, got %q", tok.Username)
+	}
+	if !reflect.DeepEqual(tok.Groups, []string{"admins", "devs"}) {
+		t.Errorf("unexpected groups %v", tok.Groups)
+	}
+	if tok.Expiration != (now+3600)*1000 {
+		t.Errorf("expected expiration in milliseconds, got %d", tok.Expiration)
+	}
+	if tok.Assertions["sub"] != "00u1abcd" {
+		t.Errorf("expected sub assertion, got %v", tok.Assertions)
+	}
+	if tok.Assertions[AuthMethodAssertion] != AuthMethodOIDC {
+		t.Errorf("expected amr assertion %q, got %v", AuthMethodOIDC, tok.Assertions)
+	}
+
+	prefixed, err := NewOIDCVerifier(srv.URL, "kubernetes", OIDCPrefixes{Username: "oidc:", Groups: "oidc-"}, time.Hour, FetchOptions{})
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	tok, err = prefixed.Verify(signTestClaims(t, priv, "oidc-key", validClaims()))
+	if err != nil {
+		t.Fatalf("expected valid ID token to verify: %v", err)
+	}
+	if tok.Username != "oidc:alice@example.com" {
+		t.Errorf("expected username oidc:alice@example.com, got %q", tok.Username)
+	}
+	if !reflect.DeepEqual(tok.Groups, []string{"oidc-admins", "oidc-devs"}) {
+		t.Errorf("expected prefixed groups, got %v", tok.Groups)
+	}
+
+	cases := []struct {
+		name   string
+		mutate func(claims map[string]interface{})
+		key    interface{}
+	}{
+		{name: "audience as array", mutate: func(c map[string]interface{}) { c["aud"] = []string{"other", "kubernetes"} }},
+		{name: "wrong issuer", mutate: func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }},
+		{name: "wrong audience", mutate: func(c map[string]interface{}) { c["aud"] = "other" }},
+		{name: "expired", mutate: func(c map[string]interface{}) { c["exp"] = now - 60 }},
+		{name: "missing exp", mutate: func(c map[string]interface{}) { delete(c, "exp") }},
+		{name: "not yet valid", mutate: func(c map[string]interface{}) { c["nbf"] = now + 600 }},
+		{name: "missing preferred_username", mutate: func(c map[string]interface{}) { delete(c, "preferred_username") }},
+	}
+
+	for i, c := range cases {
+		claims := validClaims()
+		c.mutate(claims)
+		_, err := v.Verify(signTestClaims(t, priv, "oidc-key", claims))
+		if i == 0 {
+			if err != nil {
+				t.Errorf("%s: expected token to verify: %v", c.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: expected token to be rejected", c.name)
+		}
+	}
+
+	if _, err := v.Verify(signTestClaims(t, otherPriv, "oidc-key", validClaims())); err == nil {
+		t.Errorf("expected token signed by an untrusted key to be rejected")
+	}
+
+	claims := validClaims()
+	claims["exp"] = now - 60
+	if _, err := v.Verify(signTestClaims(t, priv, "oidc-key", claims)); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("expected ErrTokenExpired for an expired ID token, got %v", err)
+	}
+
+	// A provider whose clock is ahead is tolerated with a leeway.
+	claims = validClaims()
+	claims["nbf"] = now + 60
+	early := signTestClaims(t, priv, "oidc-key", claims)
+	if _, err := v.Verify(early); !errors.Is(err, ErrTokenNotYetValid) {
+		t.Errorf("expected ErrTokenNotYetValid for an ID token valid in a minute, got %v", err)
+	}
+	if _, err := NewLeewayVerifier(v.(Inspector), LeewayOptions{NotBeforeLeeway: 2 * time.Minute}).Verify(early); err != nil {
+		t.Errorf("expected the ID token to be within the not-before leeway: %v", err)
+	}
+}
+
+func TestOIDCVerifierIssuerMismatch(t *testing.T) {
+	_, pub := newTestKey(t, "oidc-key")
+	fake := &fakeJWKS{}
+	fake.setKeys(pub)
+	srv := newFakeOIDCProvider(t, fake)
+	defer srv.Close()
+
+	// Discovery for a different issuer than the one configured.
+	mux := http.NewServeMux()
+	mux.Handle("/.well-known/openid-configuration", http.RedirectHandler(srv.URL+"/.well-known/openid-configuration", http.StatusFound))
+	other := httptest.NewServer(mux)
+	defer other.Close()
+	if _, err := NewOIDCVerifier(other.URL, "kubernetes", OIDCPrefixes{}, time.Hour, FetchOptions{}); err == nil {
+		t.Errorf("expected an error when discovery reports a different issuer")
+	}
+}
+
+func TestMultiVerifier(t *testing.T) {
+	nativePriv, nativePub := newTestKey(t, "native")
+	oidcPriv, oidcPub := newTestKey(t, "oidc-key")
+
+	nativeJWKS := &fakeJWKS{}
+	nativeJWKS.setKeys(nativePub)
+	nativeSrv := httptest.NewServer(nativeJWKS)
+	defer nativeSrv.Close()
+	native, err := NewJWKSVerifier(nativeSrv.URL, time.Hour, FetchOptions{})
+	if err != nil {
+		t.Fatalf("creating native verifier: %v", err)
+	}
+
+	oidcJWKS := &fakeJWKS{}
+	oidcJWKS.setKeys(oidcPub)
+	oidcSrv := newFakeOIDCProvider(t, oidcJWKS)
+	defer oidcSrv.Close()
+	oidc, err := NewOIDCVerifier(oidcSrv.URL, "kubernetes", OIDCPrefixes{}, time.Hour, FetchOptions{})
+	if err != nil {
+		t.Fatalf("creating OIDC verifier: %v", err)
+	}
+
+	v := NewMultiVerifier(native, oidc)
+
+	tok, err := v.Verify(signTestToken(t, nativePriv, "native", validTestToken()))
+	if err != nil || tok.Username != "alice" {
+		t.Errorf("expected native token to verify, got %v, %v", tok, err)
+	}
+
+	idToken := signTestClaims(t, oidcPriv, "oidc-key", map[string]interface{}{
+		"iss":                oidcSrv.URL,
+		"aud":                "kubernetes",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"preferred_username": "bob@example.com",
+	})
+	tok, err = v.Verify(idToken)
+	if err != nil || tok.Username != "bob@example.com" {
+		t.Errorf("expected OIDC token to verify, got %v, %v", tok, err)
+	}
+
+	expired := validTestToken()
+	expired.Expiration = 0
+	if _, err := v.Verify(signTestToken(t, nativePriv, "native", expired)); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("expected ErrTokenExpired for an expired native token, got %v", err)
+	}
+
+	if _, err := v.Verify("not-a-token"); err == nil {
+		t.Errorf("expected garbage to be rejected")
+	}
+}
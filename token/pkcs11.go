@@ -0,0 +1,150 @@
+//go:build pkcs11
+
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// oidP256 is the CKA_EC_PARAMS of P-256 keys, the named curve
+// prime256v1.
+var oidP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+
+// pkcs11Key is a P-256 private key in a PKCS #11 token. It signs on a
+// single logged in session, one signature at a time.
+type pkcs11Key struct {
+	ctx       *pkcs11.Ctx
+	session   pkcs11.SessionHandle
+	object    pkcs11.ObjectHandle
+	publicKey *ecdsa.PublicKey
+
+	mu sync.Mutex
+}
+
+func openPKCS11Key(hsm PKCS11Options) (hsmKey, error) {
+	ctx := pkcs11.New(hsm.Library)
+	if ctx == nil {
+		return nil, fmt.Errorf("Error loading PKCS #11 module %s", hsm.Library)
+	}
+	// The module may already be initialized, e.g. by an earlier
+	// configuration.
+	if err := ctx.Initialize(); err != nil && !isPKCS11Error(err, pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		ctx.Destroy()
+		return nil, fmt.Errorf("Error initializing PKCS #11 module %s: %v", hsm.Library, err)
+	}
+	session, err := ctx.OpenSession(hsm.Slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening a session on PKCS #11 slot %d: %v", hsm.Slot, err)
+	}
+	if err := ctx.Login(session, pkcs11.CKU_USER, hsm.PIN); err != nil && !isPKCS11Error(err, pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		ctx.CloseSession(session)
+		return nil, fmt.Errorf("Error logging in to PKCS #11 slot %d: %v", hsm.Slot, err)
+	}
+
+	key := &pkcs11Key{ctx: ctx, session: session}
+	if key.object, err = key.findObject(pkcs11.CKO_PRIVATE_KEY, hsm.KeyLabel); err != nil {
+		ctx.CloseSession(session)
+		return nil, err
+	}
+	publicObject, err := key.findObject(pkcs11.CKO_PUBLIC_KEY, hsm.KeyLabel)
+	if err != nil {
+		ctx.CloseSession(session)
+		return nil, err
+	}
+	if key.publicKey, err = key.readPublicKey(publicObject); err != nil {
+		ctx.CloseSession(session)
+		return nil, fmt.Errorf("Error reading the public key %q: %v", hsm.KeyLabel, err)
+	}
+	return key, nil
+}
+
+// findObject returns the only object of class with label.
+func (k *pkcs11Key) findObject(class uint, label string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := k.ctx.FindObjectsInit(k.session, template); err != nil {
+		return 0, err
+	}
+	objects, _, err := k.ctx.FindObjects(k.session, 2)
+	if finalErr := k.ctx.FindObjectsFinal(k.session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	kind := "private"
+	if class == pkcs11.CKO_PUBLIC_KEY {
+		kind = "public"
+	}
+	switch len(objects) {
+	case 0:
+		return 0, fmt.Errorf("no %s key labelled %q in the PKCS #11 token", kind, label)
+	case 1:
+		return objects[0], nil
+	}
+	return 0, fmt.Errorf("several %s keys labelled %q in the PKCS #11 token", kind, label)
+}
+
+// readPublicKey reads a P-256 public key object.
+func (k *pkcs11Key) readPublicKey(object pkcs11.ObjectHandle) (*ecdsa.PublicKey, error) {
+	attrs, err := k.ctx.GetAttributeValue(k.session, object, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var params, point []byte
+	for _, attr := range attrs {
+		switch attr.Type {
+		case pkcs11.CKA_EC_PARAMS:
+			params = attr.Value
+		case pkcs11.CKA_EC_POINT:
+			point = attr.Value
+		}
+	}
+
+	var curve asn1.ObjectIdentifier
+	if rest, err := asn1.Unmarshal(params, &curve); err != nil || len(rest) > 0 || !curve.Equal(oidP256) {
+		return nil, fmt.Errorf("expected the key to use %s", curveName)
+	}
+	// CKA_EC_POINT is the DER encoding of the point as an OCTET STRING,
+	// though some modules return the point itself.
+	var raw []byte
+	if rest, err := asn1.Unmarshal(point, &raw); err == nil && len(rest) == 0 {
+		point = raw
+	}
+	x, y := elliptic.Unmarshal(elliptic.P256(), point)
+	if x == nil {
+		return nil, errors.New("invalid EC point")
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+}
+
+func (k *pkcs11Key) public() *ecdsa.PublicKey {
+	return k.publicKey
+}
+
+func (k *pkcs11Key) signDigest(digest []byte) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}
+	if err := k.ctx.SignInit(k.session, mechanism, k.object); err != nil {
+		return nil, err
+	}
+	return k.ctx.Sign(k.session, digest)
+}
+
+func isPKCS11Error(err error, code uint) bool {
+	var e pkcs11.Error
+	return errors.As(err, &e) && uint(e) == code
+}
@@ -0,0 +1,7 @@
+//go:build !pkcs11
+
+package token
+
+func openPKCS11Key(hsm PKCS11Options) (hsmKey, error) {
+	return nil, ErrNoPKCS11Support
+}
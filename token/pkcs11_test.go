@@ -0,0 +1,144 @@
+//go:build pkcs11
+
+package token
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+// newSoftHSMKey initializes a SoftHSM token in a temporary directory and
+// generates a P-256 keypair labelled label in it. It returns the options
+// to use the key, and skips the test unless SOFTHSM2_LIB is the path of
+// libsofthsm2.so.
+func newSoftHSMKey(t *testing.T, label string) PKCS11Options {
+	lib := os.Getenv("SOFTHSM2_LIB")
+	if lib == "" {
+		t.Skip("SOFTHSM2_LIB isn't set")
+	}
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "softhsm2.conf")
+	if err := ioutil.WriteFile(conf, []byte(fmt.Sprintf("directories.tokendir = %s\nobjectstore.backend = file\n", dir)), 0600); err != nil {
+		t.Fatalf("writing SoftHSM config: %v", err)
+	}
+	os.Setenv("SOFTHSM2_CONF", conf)
+	t.Cleanup(func() { os.Unsetenv("SOFTHSM2_CONF") })
+
+	const soPIN, pin = "so-secret", "user-secret"
+	ctx := pkcs11.New(lib)
+	if ctx == nil {
+		t.Fatalf("loading %s", lib)
+	}
+	defer ctx.Destroy()
+	if err := ctx.Initialize(); err != nil {
+		t.Fatalf("initializing SoftHSM: %v", err)
+	}
+	defer ctx.Finalize()
+	slots, err := ctx.GetSlotList(false)
+	if err != nil || len(slots) == 0 {
+		t.Fatalf("listing slots: %v", err)
+	}
+	if err := ctx.InitToken(slots[0], soPIN, "kubernetes-ldap"); err != nil {
+		t.Fatalf("initializing token: %v", err)
+	}
+	// SoftHSM renumbers the slot of an initialized token.
+	slots, err = ctx.GetSlotList(true)
+	if err != nil || len(slots) == 0 {
+		t.Fatalf("listing initialized slots: %v", err)
+	}
+	slot := slots[0]
+
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		t.Fatalf("opening session: %v", err)
+	}
+	defer ctx.CloseSession(session)
+	if err := ctx.Login(session, pkcs11.CKU_SO, soPIN); err != nil {
+		t.Fatalf("logging in as SO: %v", err)
+	}
+	if err := ctx.InitPIN(session, pin); err != nil {
+		t.Fatalf("setting user PIN: %v", err)
+	}
+	ctx.Logout(session)
+	if err := ctx.Login(session, pkcs11.CKU_USER, pin); err != nil {
+		t.Fatalf("logging in: %v", err)
+	}
+	defer ctx.Logout(session)
+
+	params, err := asn1.Marshal(oidP256)
+	if err != nil {
+		t.Fatalf("marshalling curve: %v", err)
+	}
+	_, _, err = ctx.GenerateKeyPair(session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+			pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		})
+	if err != nil {
+		t.Fatalf("generating keypair: %v", err)
+	}
+	return PKCS11Options{Library: lib, Slot: slot, KeyLabel: label, PIN: pin}
+}
+
+func TestPKCS11Signer(t *testing.T) {
+	hsm := newSoftHSMKey(t, "signing")
+
+	// Before any session logs in, as the login is then shared.
+	wrongPIN := hsm
+	wrongPIN.PIN = "wrong"
+	if _, _, err := NewPKCS11Signer(wrongPIN, SignerOptions{}); err == nil {
+		t.Errorf("expected a wrong PIN to be refused")
+	}
+
+	signer, publicKey, err := NewPKCS11Signer(hsm, SignerOptions{CompressionThreshold: 512})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	pubFile := filepath.Join(t.TempDir(), fileprefix+".pub")
+	if err := WritePublicKey(pubFile, publicKey); err != nil {
+		t.Fatalf("exporting public key: %v", err)
+	}
+	verifier, err := NewVerifier(filepath.Dir(pubFile))
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	for _, tok := range []*AuthToken{validTestToken(), largeTestToken()} {
+		signed, err := signer.Sign(tok)
+		if err != nil {
+			t.Fatalf("signing token: %v", err)
+		}
+		verified, err := verifier.Verify(signed)
+		if err != nil {
+			t.Fatalf("expected the token signed by SoftHSM to verify, got %v", err)
+		}
+		if verified.Username != tok.Username || len(verified.Groups) != len(tok.Groups) {
+			t.Errorf("expected %+v, got %+v", tok, verified)
+		}
+	}
+
+	wrong := hsm
+	wrong.KeyLabel = "missing"
+	if _, _, err := NewPKCS11Signer(wrong, SignerOptions{}); err == nil {
+		t.Errorf("expected an unknown key label to be refused")
+	}
+	if _, _, err := NewPKCS11Signer(hsm, SignerOptions{Algorithm: AlgorithmEdDSA}); err == nil {
+		t.Errorf("expected EdDSA to be refused")
+	}
+}
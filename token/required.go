@@ -0,0 +1,47 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrMissingAssertion is returned by a required assertions verifier for
+// a token without one of the required assertions.
+var ErrMissingAssertion = errors.New("missing required assertion")
+
+// requiredAssertionsVerifier rejects tokens lacking any of a set of
+// assertions.
+type requiredAssertionsVerifier struct {
+	verifier Verifier
+	required []string
+}
+
+// NewRequiredAssertionsVerifier returns a verifier that accepts the
+// tokens accepted by verifier that have a non-empty value for each of
+// the required assertions. A token missing one points at a directory
+// misconfiguration rather than at the user. Without required assertions
+// verifier is returned unchanged.
+func NewRequiredAssertionsVerifier(verifier Verifier, required []string) Verifier {
+	if len(required) == 0 {
+		return verifier
+	}
+	return &requiredAssertionsVerifier{verifier: verifier, required: required}
+}
+
+func (rv *requiredAssertionsVerifier) Verify(s string) (*AuthToken, error) {
+	token, err := rv.verifier.Verify(s)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, key := range rv.required {
+		if token.Assertions[key] == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, newVerifyError(ReasonMissingAssertion, fmt.Errorf("%w: token for %s has no %s", ErrMissingAssertion, token.Username, strings.Join(missing, ", ")))
+	}
+	return token, nil
+}
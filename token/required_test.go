@@ -0,0 +1,56 @@
+package token
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRequiredAssertionsVerifier(t *testing.T) {
+	required := []string{"email", "department"}
+	cases := []struct {
+		name       string
+		assertions map[string]string
+		missing    string
+	}{
+		{
+			name:       "complete token",
+			assertions: map[string]string{"email": "alice@example.com", "department": "engineering", "amr": "ldap-bind"},
+		},
+		{
+			name:       "missing assertion",
+			assertions: map[string]string{"email": "alice@example.com"},
+			missing:    "department",
+		},
+		{
+			name:       "empty assertion",
+			assertions: map[string]string{"email": "", "department": "engineering"},
+			missing:    "email",
+		},
+		{name: "no assertions", missing: "email, department"},
+	}
+
+	for _, c := range cases {
+		v := NewRequiredAssertionsVerifier(staticVerifier{&AuthToken{Username: "alice", Assertions: c.assertions}}, required)
+		tok, err := v.Verify("token")
+		if c.missing == "" {
+			if err != nil || tok.Username != "alice" {
+				t.Errorf("%s: expected the token to be accepted, got %v", c.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrMissingAssertion) {
+			t.Errorf("%s: expected ErrMissingAssertion, got %v", c.name, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), "has no "+c.missing) {
+			t.Errorf("%s: expected the error to name %s, got %v", c.name, c.missing, err)
+		}
+	}
+
+	// Without required assertions, the verifier is used as is.
+	inner := staticVerifier{&AuthToken{Username: "alice"}}
+	if v := NewRequiredAssertionsVerifier(inner, nil); v != Verifier(inner) {
+		t.Errorf("expected no required assertions to return the verifier unchanged")
+	}
+}
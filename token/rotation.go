@@ -0,0 +1,154 @@
+package token
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// KeyRotator signs tokens with a key that can be replaced while the
+// server runs, for clusters without shell access to the pod. Rotated keys
+// are ES256 keys generated in memory, so they are lost, along with the
+// tokens signed with them, when the process exits.
+type KeyRotator struct {
+	opts    SignerOptions
+	overlap time.Duration
+	// now is overridden by tests.
+	now func() time.Time
+
+	mu       sync.RWMutex
+	signer   Signer
+	verifier Verifier
+	retired  []retiredKey
+}
+
+// retiredKey is the verifier of a key replaced by a rotation.
+type retiredKey struct {
+	verifier Verifier
+	until    time.Time
+}
+
+// Rotation describes a key rotation.
+type Rotation struct {
+	// Key is the new signing key.
+	Key KeyInfo `json:"key"`
+	// Retired are the replaced keys, still trusted until RetiredUntil.
+	Retired      []KeyInfo `json:"retired"`
+	RetiredUntil time.Time `json:"retiredUntil"`
+}
+
+// NewKeyRotator returns a KeyRotator that signs with signer and verifies
+// with verifier until the first rotation. After each rotation, tokens
+// signed with the replaced key still verify for overlap, which should be
+// at least the token TTL.
+func NewKeyRotator(signer Signer, verifier Verifier, opts SignerOptions, overlap time.Duration) *KeyRotator {
+	return &KeyRotator{
+		opts:     opts,
+		overlap:  overlap,
+		now:      time.Now,
+		signer:   signer,
+		verifier: verifier,
+	}
+}
+
+// Rotate generates a new key, signs tokens with it from now on, and
+// retires the current one.
+func (kr *KeyRotator) Rotate() (*Rotation, error) {
+	signer, verifier, err := NewEphemeralSigner(kr.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	now := kr.now()
+	rotation := &Rotation{
+		Key:          verifier.(KeyLister).TrustedKeys()[0],
+		Retired:      []KeyInfo{},
+		RetiredUntil: now.Add(kr.overlap),
+	}
+	if lister, ok := kr.verifier.(KeyLister); ok {
+		for _, key := range lister.TrustedKeys() {
+			key.Use = KeyUseRetired
+			rotation.Retired = append(rotation.Retired, key)
+		}
+	}
+	kr.retired = append(kr.liveRetired(now), retiredKey{verifier: kr.verifier, until: rotation.RetiredUntil})
+	kr.signer, kr.verifier = signer, verifier
+	return rotation, nil
+}
+
+// liveRetired returns the retired keys whose overlap hasn't ended. kr.mu
+// must be held.
+func (kr *KeyRotator) liveRetired(now time.Time) []retiredKey {
+	var live []retiredKey
+	for _, key := range kr.retired {
+		if now.Before(key.until) {
+			live = append(live, key)
+		}
+	}
+	return live
+}
+
+// verifiers returns the current verifier, then those of the retired keys
+// still trusted.
+func (kr *KeyRotator) verifiers() []Verifier {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	verifiers := []Verifier{kr.verifier}
+	for _, key := range kr.liveRetired(kr.now()) {
+		verifiers = append(verifiers, key.verifier)
+	}
+	return verifiers
+}
+
+func (kr *KeyRotator) Sign(token *AuthToken) (string, error) {
+	kr.mu.RLock()
+	signer := kr.signer
+	kr.mu.RUnlock()
+	return signer.Sign(token)
+}
+
+func (kr *KeyRotator) Verify(s string) (*AuthToken, error) {
+	return NewMultiVerifier(kr.verifiers()...).Verify(s)
+}
+
+// Inspect inspects s with the first of the current and retired keys that
+// can.
+func (kr *KeyRotator) Inspect(s string) (*AuthToken, bool, error) {
+	var firstErr error
+	for _, v := range kr.verifiers() {
+		inspector, ok := v.(Inspector)
+		if !ok {
+			continue
+		}
+		token, expired, err := inspector.Inspect(s)
+		if err == nil {
+			return token, expired, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = errors.New("the signing keys can't be inspected")
+	}
+	return nil, false, firstErr
+}
+
+func (kr *KeyRotator) TrustedKeys() []KeyInfo {
+	keys := []KeyInfo{}
+	for i, v := range kr.verifiers() {
+		lister, ok := v.(KeyLister)
+		if !ok {
+			continue
+		}
+		for _, key := range lister.TrustedKeys() {
+			if i > 0 {
+				key.Use = KeyUseRetired
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
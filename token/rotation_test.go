@@ -0,0 +1,87 @@
+package token
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyRotator(t *testing.T) {
+	dir := newTestKeypairDir(t)
+	signer, err := NewSigner(dir, SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	verifier, err := NewVerifier(dir)
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	now := time.Now()
+	rotator := NewKeyRotator(signer, verifier, SignerOptions{}, time.Hour)
+	rotator.now = func() time.Time { return now }
+
+	oldSigned, err := rotator.Sign(validTestToken())
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	oldKey := verifier.(KeyLister).TrustedKeys()[0]
+
+	rotation, err := rotator.Rotate()
+	if err != nil {
+		t.Fatalf("rotating key: %v", err)
+	}
+	if rotation.Key.Thumbprint == oldKey.Thumbprint || rotation.Key.Use != KeyUsePrimary {
+		t.Errorf("expected a new primary key, got %+v", rotation.Key)
+	}
+	if len(rotation.Retired) != 1 || rotation.Retired[0].Thumbprint != oldKey.Thumbprint || rotation.Retired[0].Use != KeyUseRetired {
+		t.Errorf("expected the old key to be retired, got %+v", rotation.Retired)
+	}
+	if !rotation.RetiredUntil.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected the old key to be retired until %v, got %v", now.Add(time.Hour), rotation.RetiredUntil)
+	}
+
+	// New tokens are signed with the new key...
+	newSigned, err := rotator.Sign(validTestToken())
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	if _, err := verifier.Verify(newSigned); FailureReason(err) != ReasonBadSignature {
+		t.Errorf("expected the old key not to verify new tokens, got %v", err)
+	}
+	if _, err := rotator.Verify(newSigned); err != nil {
+		t.Errorf("expected a new token to verify, got %v", err)
+	}
+	// ...and old ones still verify during the overlap.
+	if _, err := rotator.Verify(oldSigned); err != nil {
+		t.Errorf("expected an old token to verify during the overlap, got %v", err)
+	}
+	if _, expired, err := rotator.Inspect(oldSigned); err != nil || expired {
+		t.Errorf("expected an old token to be inspected during the overlap, got %v (expired: %v)", err, expired)
+	}
+	keys := rotator.TrustedKeys()
+	if len(keys) != 2 || keys[0].Thumbprint != rotation.Key.Thumbprint || keys[1].Use != KeyUseRetired {
+		t.Errorf("expected the new key and the retired one, got %+v", keys)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := rotator.Verify(oldSigned); FailureReason(err) != ReasonBadSignature {
+		t.Errorf("expected an old token to fail after the overlap, got %v", err)
+	}
+	if _, err := rotator.Verify(newSigned); err != nil {
+		t.Errorf("expected a new token to verify after the overlap, got %v", err)
+	}
+	if keys := rotator.TrustedKeys(); len(keys) != 1 {
+		t.Errorf("expected only the new key after the overlap, got %+v", keys)
+	}
+
+	// Rotating again retires the rotated key.
+	second, err := rotator.Rotate()
+	if err != nil {
+		t.Fatalf("rotating key: %v", err)
+	}
+	if len(second.Retired) != 1 || second.Retired[0].Thumbprint != rotation.Key.Thumbprint {
+		t.Errorf("expected the first rotated key to be retired, got %+v", second.Retired)
+	}
+	if _, err := rotator.Verify(newSigned); err != nil {
+		t.Errorf("expected a token of the first rotated key to verify, got %v", err)
+	}
+}
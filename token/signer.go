@@ -2,9 +2,12 @@ package token
 
 import (
 	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
+	"time"
 
 	jose "gopkg.in/square/go-jose.v1"
 )
@@ -15,15 +18,41 @@ type Signer interface {
 	Sign(token *AuthToken) (string, error)
 }
 
+// SignerOptions configures how tokens are serialized before signing.
+type SignerOptions struct {
+	// CompressionThreshold is the payload size in bytes above which
+	// payloads are DEFLATE compressed. Zero disables compression.
+	CompressionThreshold int
+	// Algorithm is the signature algorithm of the keypair, one of the
+	// Algorithm values. Defaults to AlgorithmES256.
+	Algorithm string
+}
+
 // ecdsaSigner represents a signer of tokens under a particular public key.
 type ecdsaSigner struct {
 	ecdsaVerifier
-	signer jose.Signer
+	privateKey *ecdsa.PrivateKey
+	opts       SignerOptions
+}
+
+// NewSigner issues ECDSA-P256 (or, with AlgorithmEdDSA, Ed25519) JWS
+// tokens with the keypair in dirname.
+func NewSigner(dirname string, opts SignerOptions) (Signer, error) {
+	if err := CheckAlgorithm(opts.Algorithm); err != nil {
+		return nil, err
+	}
+	if opts.Algorithm == AlgorithmEdDSA {
+		return newEd25519Signer(dirname, opts)
+	}
+	ecdsaKey, err := loadPrivateKey(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return newECDSASigner(ecdsaKey, opts)
 }
 
-// NewSigner is, for the moment, a thin wrapper around Square's
-// go-jose library to issue ECDSA-P256 JWS tokens.
-func NewSigner(dirname string) (Signer, error) {
+// loadPrivateKey reads the private key of the keypair in dirname.
+func loadPrivateKey(dirname string) (*ecdsa.PrivateKey, error) {
 	// We use P-256, because Go has a constant-time implementation
 	// of it. Go correctly checks that points are on the curve. A
 	// version of Go > 1.4 is recommended, because ECDSA signatures
@@ -36,44 +65,94 @@ func NewSigner(dirname string) (Signer, error) {
 
 	privateKey, err := jose.LoadPrivateKey(key)
 	if err != nil {
-		return nil, err
+		return nil, keyFileError(privateKeyFile, key, true, keyAlgorithmES256, err)
 	}
 	// TODO(dlg): Once JOSE supports it, make sure that this works for curve25519
-	// Check that it's actually an ECDSA key,
+	// Check that it's actually an ECDSA key on the expected curve.
 	ecdsaKey, ok := privateKey.(*ecdsa.PrivateKey)
-	if !ok {
-		return nil, fmt.Errorf("expected an ECDSA private key, but got a key of type %T", privateKey)
-	}
-	// and that it's on the expected curve.
-	if ecdsaKey.Params().Name != curveName {
-		return nil, fmt.Errorf("expected the key to use %s, but it's using %s", curveName, ecdsaKey.Params().Name)
+	if !ok || ecdsaKey.Params().Name != curveName {
+		return nil, keyFileError(privateKeyFile, key, true, keyAlgorithmES256, nil)
 	}
+	return ecdsaKey, nil
+}
 
-	signer, err := jose.NewSigner(curveJose, privateKey)
+// NewEphemeralSigner generates a keypair in memory and returns a signer
+// and a verifier for it. The key is never written to disk, so tokens are
+// only valid for the life of the process. This is for development only.
+func NewEphemeralSigner(opts SignerOptions) (Signer, Verifier, error) {
+	privateKey, err := ecdsa.GenerateKey(curveEll, rand.Reader)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	signer, err := newECDSASigner(privateKey, opts)
+	if err != nil {
+		return nil, nil, err
 	}
+	return signer, &signer.ecdsaVerifier, nil
+}
+
+func newECDSASigner(privateKey *ecdsa.PrivateKey, opts SignerOptions) (*ecdsaSigner, error) {
 	ecdsaSigner := &ecdsaSigner{
-		signer: signer,
+		privateKey: privateKey,
+		opts:       opts,
 	}
-	ecdsaSigner.publicKey = &ecdsaKey.PublicKey
+	ecdsaSigner.publicKey = &privateKey.PublicKey
+	ecdsaSigner.loadedAt = time.Now()
 	return ecdsaSigner, nil
 }
 
 // Sign an authentcation token and return the serialized JWS
 func (es *ecdsaSigner) Sign(token *AuthToken) (string, error) {
-	tokenBytes, err := json.Marshal(token)
+	input, err := signingInput(token, AlgorithmES256, es.opts)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, es.privateKey, digest[:])
 	if err != nil {
-		// panic? what are the conditions under which this can fail?
 		return "", err
 	}
-	jws, err := es.signer.Sign(tokenBytes)
+	// JWS signatures are r || s, each padded to the size of the curve.
+	size := (es.privateKey.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// signingInput returns the encoded protected header and payload of the
+// compact JWS of token signed with alg. Payloads over the compression
+// threshold of opts are DEFLATE compressed, which the zip header says.
+func signingInput(token *AuthToken, alg string, opts SignerOptions) (string, error) {
+	payload, err := marshalToken(token)
 	if err != nil {
 		return "", err
 	}
-	signed, err := jws.CompactSerialize()
+	header := jwsHeader{Algorithm: alg}
+	if opts.CompressionThreshold > 0 && len(payload) > opts.CompressionThreshold {
+		if payload, err = compressPayload(payload); err != nil {
+			return "", err
+		}
+		header.Zip = zipDeflate
+	}
+	headerJSON, err := json.Marshal(header)
 	if err != nil {
 		return "", err
 	}
-	return signed, nil
+	return base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(payload), nil
+}
+
+// marshalToken serializes a token as CurrentVersion to be signed.
+func marshalToken(token *AuthToken) ([]byte, error) {
+	versioned := *token
+	versioned.Version = CurrentVersion
+	if versioned.Type == "" {
+		versioned.Type = TypeAccess
+	}
+	tokenBytes, err := json.Marshal(&versioned)
+	if err != nil {
+		// panic? what are the conditions under which this can fail?
+		return nil, err
+	}
+	return tokenBytes, nil
 }
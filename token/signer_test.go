@@ -0,0 +1,180 @@
+package token
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+// newTestKeypairDir generates a keypair in a temporary directory, which
+// is removed when the test finishes.
+func newTestKeypairDir(t testing.TB) string {
+	dir, err := ioutil.TempDir("", "keypair")
+	if err != nil {
+		t.Fatalf("creating temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	if err := GenerateKeypair(dir); err != nil {
+		t.Fatalf("generating keypair: %v", err)
+	}
+	return dir
+}
+
+func largeTestToken() *AuthToken {
+	tok := validTestToken()
+	tok.Assertions = map[string]string{"ldapServer": "ldap.example.com", "userDN": "uid=alice,ou=people,dc=example,dc=com"}
+	for i := 0; i < 200; i++ {
+		tok.Groups = append(tok.Groups, fmt.Sprintf("engineering-team-%d", i))
+	}
+	return tok
+}
+
+func TestSignerCompression(t *testing.T) {
+	dir := newTestKeypairDir(t)
+
+	verifier, err := NewVerifier(dir)
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+
+	plain, err := NewSigner(dir, SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	compressing, err := NewSigner(dir, SignerOptions{CompressionThreshold: 512})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+
+	tok := largeTestToken()
+	tok.Type = TypeAccess
+
+	plainToken, err := plain.Sign(tok)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	compressedToken, err := compressing.Sign(tok)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+
+	if len(compressedToken) >= len(plainToken)/2 {
+		t.Errorf("expected compression to at least halve the token, got %d bytes vs %d", len(compressedToken), len(plainToken))
+	}
+	for signed, zip := range map[string]interface{}{plainToken: nil, compressedToken: "DEF"} {
+		header, err := parseHeader(signed)
+		if err != nil {
+			t.Fatalf("parsing header: %v", err)
+		}
+		if header.Params["zip"] != zip {
+			t.Errorf("expected zip header %v, got %s", zip, header.Raw)
+		}
+	}
+
+	for name, signed := range map[string]string{"plain": plainToken, "compressed": compressedToken} {
+		verified, err := verifier.Verify(signed)
+		if err != nil {
+			t.Fatalf("%s: verifying token: %v", name, err)
+		}
+		expected := *tok
+		expected.Version = CurrentVersion
+		if !reflect.DeepEqual(verified, &expected) {
+			t.Errorf("%s: round-tripped token differs: expected %+v, got %+v", name, &expected, verified)
+		}
+	}
+}
+
+func TestSignerCompressionThreshold(t *testing.T) {
+	dir := newTestKeypairDir(t)
+
+	signer, err := NewSigner(dir, SignerOptions{CompressionThreshold: 4096})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+
+	signed, err := signer.Sign(validTestToken())
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+
+	jws, err := jose.ParseSigned(signed)
+	if err != nil {
+		t.Fatalf("parsing token: %v", err)
+	}
+	payload, err := jws.Verify(signer.(*ecdsaSigner).publicKey)
+	if err != nil {
+		t.Fatalf("verifying token: %v", err)
+	}
+	if payload[0] != '{' {
+		t.Errorf("expected a token below the threshold not to be compressed")
+	}
+}
+
+func TestUnsupportedCompression(t *testing.T) {
+	priv, _ := newTestKey(t, "")
+	v := &ecdsaVerifier{publicKey: &priv.PublicKey}
+	payload, err := marshalToken(validTestToken())
+	if err != nil {
+		t.Fatalf("marshalling token: %v", err)
+	}
+	signed := signCompact(t, priv, `{"alg":"ES256","zip":"GZIP"}`, payload)
+	if _, err := v.Verify(signed); err == nil {
+		t.Errorf("expected a token with an unknown zip header to be rejected")
+	}
+}
+
+func TestEphemeralSigner(t *testing.T) {
+	// Run from an empty directory to check that nothing is written.
+	dir, err := ioutil.TempDir("", "ephemeral")
+	if err != nil {
+		t.Fatalf("creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getting working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("changing directory: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	signer, verifier, err := NewEphemeralSigner(SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating ephemeral signer: %v", err)
+	}
+
+	signed, err := signer.Sign(validTestToken())
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	tok, err := verifier.Verify(signed)
+	if err != nil {
+		t.Fatalf("verifying token: %v", err)
+	}
+	if tok.Username != "alice" {
+		t.Errorf("expected username alice, got %q", tok.Username)
+	}
+
+	// A second process start gets a new key, so old tokens stop working.
+	_, otherVerifier, err := NewEphemeralSigner(SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating ephemeral signer: %v", err)
+	}
+	if _, err := otherVerifier.Verify(signed); err == nil {
+		t.Errorf("expected a token from another ephemeral key to be rejected")
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("reading directory: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected no files to be written, found %d", len(files))
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 
 	jose "gopkg.in/square/go-jose.v1"
 )
@@ -26,6 +28,95 @@ type AuthToken struct {
 	Groups     []string
 	Assertions map[string]string
 	Expiration int64
+	// IssuedAt is when the token was issued, in unix milliseconds like
+	// Expiration. Tokens issued before it was introduced have none.
+	IssuedAt int64 `json:",omitempty"`
+	// NotBefore, if set, is when the token becomes valid, in unix
+	// milliseconds like Expiration.
+	NotBefore int64 `json:",omitempty"`
+	// ID is a random identifier of the token, like the JWT jti claim,
+	// e.g. to find it in logs. Tokens issued before it was introduced
+	// have none.
+	ID string `json:",omitempty"`
+	// UID is a stable identifier for the user, if one is known.
+	UID string `json:",omitempty"`
+	// Type is the purpose of the token, TypeAccess or TypeRefresh.
+	// Tokens issued before types were introduced have none and are
+	// treated as access tokens.
+	Type string `json:",omitempty"`
+	// Scopes limit what the token may be used for, for authorizers that
+	// honor them (e.g. "read-only"). Tokens without scopes are unlimited.
+	Scopes []string `json:",omitempty"`
+	// Audiences are the clusters the token was issued for, e.g. derived
+	// from the host it was requested through. Tokens without audiences
+	// are good for any.
+	Audiences []string `json:",omitempty"`
+	// Confirmation, if set, binds the token to the client certificate it
+	// was issued to, so that it is only accepted over a connection
+	// authenticated with that certificate.
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+	// Version is the format version of the token, one of the Version
+	// constants. Signers set it to CurrentVersion, and verified tokens
+	// are migrated to it.
+	Version int `json:",omitempty"`
+}
+
+// Token types.
+const (
+	// TypeAccess tokens are presented to the API server.
+	TypeAccess = "access"
+	// TypeRefresh tokens are only accepted by the refresh endpoint, in
+	// exchange for a new access token.
+	TypeRefresh = "refresh"
+)
+
+// AuthMethodAssertion is the assertion recording how the user
+// authenticated to get the token, one of the AuthMethod values.
+const AuthMethodAssertion = "amr"
+
+// Authentication methods recorded in the AuthMethodAssertion.
+const (
+	// AuthMethodLDAPBind tokens were issued after binding as the user.
+	AuthMethodLDAPBind = "ldap-bind"
+	// AuthMethodOIDC tokens are ID tokens from an OIDC provider.
+	AuthMethodOIDC = "oidc"
+	// AuthMethodRefresh tokens were issued in exchange for a refresh
+	// token, without the user presenting their credentials again.
+	AuthMethodRefresh = "refresh"
+	// AuthMethodBulk tokens were issued in bulk by an administrator,
+	// e.g. for service accounts, without the user authenticating.
+	AuthMethodBulk = "bulk"
+)
+
+// ErrWrongTokenType is returned by RequireType for a token of another type.
+var ErrWrongTokenType = errors.New("wrong token type")
+
+// RequireType returns ErrWrongTokenType unless the token is of the given
+// type. Untyped tokens are access tokens.
+func RequireType(token *AuthToken, tokenType string) error {
+	actual := token.Type
+	if actual == "" {
+		actual = TypeAccess
+	}
+	if actual != tokenType {
+		return fmt.Errorf("%w: expected a %s token, got a %s token", ErrWrongTokenType, tokenType, actual)
+	}
+	return nil
+}
+
+// ErrMissingScope is returned by RequireScope for a token without the
+// required scope.
+var ErrMissingScope = errors.New("missing scope")
+
+// RequireScope returns ErrMissingScope unless the token has the given
+// scope.
+func RequireScope(token *AuthToken, scope string) error {
+	for _, s := range token.Scopes {
+		if s == scope {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: token does not have the %q scope", ErrMissingScope, scope)
 }
 
 const fileprefix = "signing"
@@ -45,6 +136,16 @@ func KeypairExists(dirname string) bool {
 	return (err1 == nil && err2 == nil)
 }
 
+// KeyCreated returns when the signing key in dirname was created, taken
+// from the modification time of its file.
+func KeyCreated(dirname string) (time.Time, error) {
+	info, err := os.Stat(getPrivateKeyFilename(dirname))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
 // GenerateKeypair generates a public and private ECDSA key, to be
 // used for signing and verifying authentication tokens.
 func GenerateKeypair(dirname string) (err error) {
@@ -3,68 +3,235 @@ package token
 import (
 	"crypto/ecdsa"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
+	"path"
 
 	jose "gopkg.in/square/go-jose.v1"
 	"time"
 )
 
+// ErrTokenExpired is returned by Verify for a validly signed token that
+// has expired.
+var ErrTokenExpired = errors.New("token has expired")
+
 // Verifier verifies the serialized representation of a token
 type Verifier interface {
 	// Verify the payload and return the Token if the payload is valid.
 	Verify(s string) (token *AuthToken, err error)
 }
 
+// Inspector verifies a token's signature but, unlike Verifier, returns
+// its claims even when it has expired. It is meant for debugging tools
+// only; authentication must always go through Verify.
+type Inspector interface {
+	// Inspect returns the token if its signature is valid, and whether
+	// it has expired.
+	Inspect(s string) (token *AuthToken, expired bool, err error)
+}
+
 // EcdsaVerifier represents an object that can verify tokens.
 type ecdsaVerifier struct {
 	publicKey *ecdsa.PublicKey
+	// backupKeys are also trusted, but only for verification.
+	backupKeys []*ecdsa.PublicKey
+	// loadedAt is when the keys were loaded.
+	loadedAt time.Time
 }
 
 // NewVerifier reads a verification key file, and returns a verifier
 // to verify token objects.
 func NewVerifier(dirname string) (Verifier, error) {
-	publicKeyFile := getPublicKeyFilename(dirname)
-	buf, err := ioutil.ReadFile(publicKeyFile)
+	v, err := newECDSAVerifier(dirname)
 	if err != nil {
 		return nil, err
 	}
-	pubKey, err := jose.LoadPublicKey(buf)
+	return v, nil
+}
+
+// NewVerifierWithBackupKeys is like NewVerifier, but also accepts tokens
+// signed with the public keys in backupKeyFiles (DER or PEM), e.g. those
+// of a parallel issuer during a migration, or a new key rolled out in an
+// emergency ahead of the signer. The returned verifier is an Inspector.
+func NewVerifierWithBackupKeys(dirname string, backupKeyFiles []string) (Verifier, error) {
+	v, err := newECDSAVerifier(dirname)
 	if err != nil {
 		return nil, err
 	}
-	ecdsaPubKey, ok := pubKey.(*ecdsa.PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("Expected the public key to use ECDSA, but got a key of type %T", pubKey)
+	if err := v.loadBackupKeys(backupKeyFiles); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// loadBackupKeys adds the public keys in files to the backup keys.
+func (ev *ecdsaVerifier) loadBackupKeys(files []string) error {
+	for _, file := range files {
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		key, err := parsePublicKey(file, buf)
+		if err != nil {
+			return fmt.Errorf("backup verification key: %w", err)
+		}
+		ev.backupKeys = append(ev.backupKeys, key)
+	}
+	return nil
+}
+
+// NewInspector reads a verification key file, and returns an inspector
+// for debugging tokens signed with it.
+func NewInspector(dirname string) (Inspector, error) {
+	v, err := newECDSAVerifier(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// NewVerifierFromPublicKey returns a verifier for tokens signed with the
+// keypair whose public key, as in signing.pub, is pubKey. It needs no
+// file or network access, for clients verifying tokens offline with the
+// key built in, e.g. with go:embed.
+func NewVerifierFromPublicKey(pubKey []byte) (Verifier, error) {
+	ecdsaPubKey, err := parsePublicKey("", pubKey)
+	if err != nil {
+		return nil, err
+	}
+	return &ecdsaVerifier{publicKey: ecdsaPubKey, loadedAt: time.Now()}, nil
+}
+
+// NewVerifierFS reads the verification key of the keypair in dirname of
+// fsys, such as an embed.FS, and returns a verifier for tokens signed
+// with it.
+func NewVerifierFS(fsys fs.FS, dirname string) (Verifier, error) {
+	file := path.Join(dirname, fileprefix+".pub")
+	buf, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaPubKey, err := parsePublicKey(file, buf)
+	if err != nil {
+		return nil, err
+	}
+	return &ecdsaVerifier{publicKey: ecdsaPubKey, loadedAt: time.Now()}, nil
+}
+
+func newECDSAVerifier(dirname string) (*ecdsaVerifier, error) {
+	ecdsaPubKey, err := loadPublicKey(dirname)
+	if err != nil {
+		return nil, err
 	}
 	v := &ecdsaVerifier{
 		publicKey: ecdsaPubKey,
+		loadedAt:  time.Now(),
 	}
 	return v, nil
 }
 
+// loadPublicKey reads the public key of the keypair in dirname.
+func loadPublicKey(dirname string) (*ecdsa.PublicKey, error) {
+	publicKeyFile := getPublicKeyFilename(dirname)
+	buf, err := ioutil.ReadFile(publicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return parsePublicKey(publicKeyFile, buf)
+}
+
+// parsePublicKey parses a DER or PEM encoded ECDSA P-256 public key
+// read from file.
+func parsePublicKey(file string, buf []byte) (*ecdsa.PublicKey, error) {
+	pubKey, err := jose.LoadPublicKey(buf)
+	if err != nil {
+		return nil, keyFileError(file, buf, false, keyAlgorithmES256, err)
+	}
+	ecdsaPubKey, ok := pubKey.(*ecdsa.PublicKey)
+	if !ok || ecdsaPubKey.Params().Name != curveName {
+		return nil, keyFileError(file, buf, false, keyAlgorithmES256, nil)
+	}
+	return ecdsaPubKey, nil
+}
+
 // Verify checks that a token's signature is valid, and returns the
 // token. Otherwise returns an error.
 func (ev *ecdsaVerifier) Verify(s string) (token *AuthToken, err error) {
-	jws, err := jose.ParseSigned(s)
+	payload, err := ev.verifySignature(s)
 	if err != nil {
 		return
 	}
+	return decodeToken(payload)
+}
+
+// Inspect checks the token's signature and returns it even if it has
+// expired.
+func (ev *ecdsaVerifier) Inspect(s string) (*AuthToken, bool, error) {
+	payload, err := ev.verifySignature(s)
+	if err != nil {
+		return nil, false, err
+	}
+	return inspectToken(payload)
+}
+
+func (ev *ecdsaVerifier) verifySignature(s string) ([]byte, error) {
+	if err := checkCompactJWS(s); err != nil {
+		return nil, newVerifyError(ReasonMalformed, err)
+	}
+	jws, err := jose.ParseSigned(s)
+	if err != nil {
+		return nil, newVerifyError(ReasonMalformed, err)
+	}
+	if alg := jws.Signatures[0].Header.Algorithm; alg != string(curveJose) {
+		return nil, newVerifyError(ReasonBadSignature, fmt.Errorf("token is signed with %s, expected %s", alg, curveJose))
+	}
 	payload, err := jws.Verify(ev.publicKey)
 	if err != nil {
-		return
+		for _, key := range ev.backupKeys {
+			if payload, backupErr := jws.Verify(key); backupErr == nil {
+				return decompressPayload(s, payload)
+			}
+		}
+		return nil, newVerifyError(ReasonBadSignature, err)
 	}
-	token = &AuthToken{}
-	err = json.Unmarshal(payload, token)
+	return decompressPayload(s, payload)
+}
+
+// decodeToken unmarshals a verified JWS payload into a token and
+// rejects it if it has already expired or isn't valid yet.
+func decodeToken(payload []byte) (*AuthToken, error) {
+	token, expired, err := inspectToken(payload)
 	if err != nil {
-		token = nil
-		return
+		return nil, err
+	}
+	if expired {
+		return nil, newVerifyError(ReasonExpired, ErrTokenExpired)
+	}
+	if token.NotBefore > time.Now().UnixNano()/int64(time.Millisecond) {
+		return nil, newVerifyError(ReasonNotYetValid, ErrTokenNotYetValid)
 	}
+	return token, nil
+}
 
-	if TokenExpired(token) {
-		return nil, fmt.Errorf("token has expired")
+// inspectToken unmarshals a verified JWS payload into a token and
+// reports whether it has expired.
+func inspectToken(payload []byte) (*AuthToken, bool, error) {
+	token := &AuthToken{}
+	if err := json.Unmarshal(payload, token); err != nil {
+		return nil, false, newVerifyError(ReasonMalformed, err)
+	}
+	if err := checkVersion(token); err != nil {
+		return nil, false, err
+	}
+	if err := checkCanonical(payload, token); err != nil {
+		return nil, false, err
+	}
+	if err := migrate(token); err != nil {
+		return nil, false, err
 	}
-	return
+	return token, TokenExpired(token), nil
 }
 
 // Given a token verifies if it has already expired or not
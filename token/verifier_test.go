@@ -0,0 +1,75 @@
+package token
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupVerificationKeys(t *testing.T) {
+	primaryDir := newTestKeypairDir(t)
+	backupDir := newTestKeypairDir(t)
+	otherDir := newTestKeypairDir(t)
+
+	// The backup key is configured as a PEM file of its own, with no
+	// private key alongside it.
+	der, err := ioutil.ReadFile(getPublicKeyFilename(backupDir))
+	if err != nil {
+		t.Fatalf("reading backup public key: %v", err)
+	}
+	backupKeyFile := filepath.Join(t.TempDir(), "backup.pem")
+	if err := ioutil.WriteFile(backupKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatalf("writing backup public key: %v", err)
+	}
+
+	verifier, err := NewVerifierWithBackupKeys(primaryDir, []string{backupKeyFile})
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+	primaryVerifier, err := NewVerifier(primaryDir)
+	if err != nil {
+		t.Fatalf("creating verifier: %v", err)
+	}
+
+	sign := func(dir string) string {
+		signer, err := NewSigner(dir, SignerOptions{})
+		if err != nil {
+			t.Fatalf("creating signer: %v", err)
+		}
+		signed, err := signer.Sign(validTestToken())
+		if err != nil {
+			t.Fatalf("signing token: %v", err)
+		}
+		return signed
+	}
+	primaryToken := sign(primaryDir)
+	backupToken := sign(backupDir)
+
+	if _, err := verifier.Verify(primaryToken); err != nil {
+		t.Errorf("expected a token signed with the primary key to verify: %v", err)
+	}
+	if tok, err := verifier.Verify(backupToken); err != nil || tok.Username != validTestToken().Username {
+		t.Errorf("expected a token signed with the backup key to verify, got %v", err)
+	}
+	if _, _, err := verifier.(Inspector).Inspect(backupToken); err != nil {
+		t.Errorf("expected a token signed with the backup key to be inspectable: %v", err)
+	}
+	_, err = verifier.Verify(sign(otherDir))
+	if reason := FailureReason(err); reason != ReasonBadSignature {
+		t.Errorf("expected a token signed with an untrusted key to be rejected as %q, got %q (%v)", ReasonBadSignature, reason, err)
+	}
+
+	// Signing is untouched: the signer of the keypair still signs with
+	// the primary key, which verifies without the backup.
+	if _, err := primaryVerifier.Verify(primaryToken); err != nil {
+		t.Errorf("expected the primary key to verify its own tokens: %v", err)
+	}
+	if _, err := primaryVerifier.Verify(backupToken); err == nil {
+		t.Errorf("expected the backup key's tokens to need the backup key")
+	}
+
+	if _, err := NewVerifierWithBackupKeys(primaryDir, []string{filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Errorf("expected a missing backup key file to be an error")
+	}
+}
@@ -0,0 +1,60 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Token format versions. Tokens issued before versions were introduced
+// have none and are Version1. Fields added to AuthToken must be
+// omitempty, so that earlier tokens keep their canonical form (see
+// checkCanonical). A field added without a new version would be refused
+// by older builds as non-canonical, so new fields come with a new
+// version, which those builds refuse as unknown instead.
+const (
+	// Version1 tokens may lack IssuedAt and Type.
+	Version1 = 1
+	// Version2 tokens always carry a Type.
+	Version2 = 2
+
+	// CurrentVersion is the version of the tokens we sign.
+	CurrentVersion = Version2
+)
+
+// ErrUnknownVersion is returned by Verify for tokens of a format version
+// newer than this build understands, e.g. issued by a newer release
+// during a rolling upgrade.
+var ErrUnknownVersion = errors.New("unknown token format version")
+
+// checkVersion refuses a decoded token of a format version this build
+// doesn't know. It is checked before the token's canonical form, which
+// a token of a newer version needn't have for this build.
+func checkVersion(token *AuthToken) error {
+	if token.Version == 0 {
+		return nil
+	}
+	if token.Version < Version1 || token.Version > CurrentVersion {
+		return newVerifyError(ReasonUnknownVersion, fmt.Errorf("%w %d, expected at most %d", ErrUnknownVersion, token.Version, CurrentVersion))
+	}
+	return nil
+}
+
+// migrate upgrades a decoded token of an earlier format version to
+// CurrentVersion, filling in the defaults for the fields it lacks, so
+// that tokens issued before an upgrade stay valid until they expire.
+func migrate(token *AuthToken) error {
+	if err := checkVersion(token); err != nil {
+		return err
+	}
+	if token.Version == 0 {
+		token.Version = Version1
+	}
+
+	if token.Version == Version1 {
+		if token.Type == "" {
+			token.Type = TypeAccess
+		}
+		token.Version = Version2
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package token
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVersionMigration(t *testing.T) {
+	priv, _ := newTestKey(t, "")
+	v := &ecdsaVerifier{publicKey: &priv.PublicKey}
+	expiration := time.Now().Add(time.Hour).UnixNano() / int64(time.Millisecond)
+
+	// A token as issued before versions and types were introduced,
+	// serialized in the field order of the time.
+	type v1Token struct {
+		Username   string
+		Groups     []string
+		Assertions map[string]string
+		Expiration int64
+		Type       string `json:",omitempty"`
+	}
+	v1 := v1Token{
+		Username:   "alice",
+		Groups:     []string{"developers"},
+		Assertions: map[string]string{"ldapServer": "ldap.example.com"},
+		Expiration: expiration,
+	}
+	tok, err := v.Verify(signTestClaims(t, priv, "", v1))
+	if err != nil {
+		t.Fatalf("expected the v1 token to verify, got %v", err)
+	}
+	if tok.Version != CurrentVersion || tok.Type != TypeAccess {
+		t.Errorf("expected the token to be migrated to v%d with type %q, got v%d with type %q", CurrentVersion, TypeAccess, tok.Version, tok.Type)
+	}
+	if tok.Username != "alice" || tok.Assertions["ldapServer"] != "ldap.example.com" || tok.Expiration != expiration {
+		t.Errorf("expected the token's claims to be kept, got %+v", tok)
+	}
+
+	// v1 refresh tokens stay refresh tokens.
+	v1.Type = TypeRefresh
+	if tok, err := v.Verify(signTestClaims(t, priv, "", v1)); err != nil || tok.Type != TypeRefresh {
+		t.Errorf("expected a refresh token, got %+v, %v", tok, err)
+	}
+
+	future := validTestToken()
+	future.Version = CurrentVersion + 1
+	signed := signTestToken(t, priv, "", future)
+	if _, err := v.Verify(signed); !errors.Is(err, ErrUnknownVersion) || FailureReason(err) != ReasonUnknownVersion {
+		t.Errorf("expected an unknown version error, got %v", err)
+	}
+	if _, _, err := v.Inspect(signed); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("expected inspecting to fail with an unknown version error, got %v", err)
+	}
+
+	// A newer version's tokens may have fields this build doesn't know,
+	// which doesn't make them malformed.
+	type futureToken struct {
+		AuthToken
+		Audience string
+	}
+	signed = signTestClaims(t, priv, "", futureToken{AuthToken: *future, Audience: "kubernetes"})
+	if _, err := v.Verify(signed); !errors.Is(err, ErrUnknownVersion) || FailureReason(err) != ReasonUnknownVersion {
+		t.Errorf("expected an unknown version error for a token with a new field, got %v", err)
+	}
+}
+
+func TestSignerSetsVersion(t *testing.T) {
+	signer, verifier, err := NewEphemeralSigner(SignerOptions{})
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+
+	tok := validTestToken()
+	tok.Version = Version1
+	signed, err := signer.Sign(tok)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	if tok.Version != Version1 {
+		t.Errorf("expected the signed token not to be modified, got v%d", tok.Version)
+	}
+
+	_, payload, err := Detach(signed)
+	if err != nil {
+		t.Fatalf("detaching payload: %v", err)
+	}
+	raw := &AuthToken{}
+	if err := json.Unmarshal(payload, raw); err != nil {
+		t.Fatalf("decoding payload: %v", err)
+	}
+	if raw.Version != CurrentVersion || raw.Type != TypeAccess {
+		t.Errorf("expected a v%d %s token, got v%d %q", CurrentVersion, TypeAccess, raw.Version, raw.Type)
+	}
+	if _, err := verifier.Verify(signed); err != nil {
+		t.Errorf("expected the token to verify, got %v", err)
+	}
+}